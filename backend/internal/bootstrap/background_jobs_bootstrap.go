@@ -0,0 +1,46 @@
+package bootstrap
+
+import (
+	"context"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+// Types of the background jobs that admins can submit
+const (
+	backgroundJobTypeLdapSync                   = "ldapSync"
+	backgroundJobTypePregenerateProfilePictures = "pregenerateProfilePictures"
+	backgroundJobTypeArchiveAuditLogs           = "archiveAuditLogs"
+)
+
+// registerBackgroundJobHandlers registers the long-running operations that can be run as background jobs
+func registerBackgroundJobHandlers(svc *services) {
+	svc.backgroundJobService.RegisterHandler(backgroundJobTypeLdapSync, service.BackgroundJobHandler{
+		Run: func(ctx context.Context, _ []byte, progress service.BackgroundJobProgressFunc) (any, error) {
+			// The sync is coalesced with the other triggers, so the progress is passed with the context
+			return svc.ldapService.SyncAllCoalesced(service.WithBackgroundJobProgress(ctx, progress))
+		},
+		// A new sync fixes what an interrupted one left behind
+		Resumable: true,
+	})
+
+	svc.backgroundJobService.RegisterHandler(backgroundJobTypePregenerateProfilePictures, service.BackgroundJobHandler{
+		Run: func(ctx context.Context, _ []byte, progress service.BackgroundJobProgressFunc) (any, error) {
+			return nil, svc.userService.PregenerateDefaultProfilePictures(ctx, progress)
+		},
+		Cancellable: true,
+		Resumable:   true,
+	})
+
+	// Audit logs can only be archived if an archive storage is configured
+	if svc.auditLogArchiveService != nil {
+		svc.backgroundJobService.RegisterHandler(backgroundJobTypeArchiveAuditLogs, service.BackgroundJobHandler{
+			Run: func(ctx context.Context, _ []byte, _ service.BackgroundJobProgressFunc) (any, error) {
+				count, err := svc.auditLogArchiveService.ArchiveOldAuditLogs(ctx)
+				return map[string]int{"archivedCount": count}, err
+			},
+			// The archive keeps a checkpoint, so an interrupted run continues where it stopped
+			Resumable: true,
+		})
+	}
+}
@@ -21,6 +21,13 @@ func Bootstrap(ctx context.Context) error {
 	}
 	slog.InfoContext(ctx, "Pocket ID is starting")
 
+	if common.EnvConfig.TempPath != "" {
+		err = utils.EnsureDirWritable(common.EnvConfig.TempPath)
+		if err != nil {
+			return fmt.Errorf("invalid TEMP_PATH: %w", err)
+		}
+	}
+
 	err = initApplicationImages()
 	if err != nil {
 		return fmt.Errorf("failed to initialize application images: %w", err)
@@ -38,8 +45,18 @@ func Bootstrap(ctx context.Context) error {
 		return fmt.Errorf("failed to initialize services: %w", err)
 	}
 
+	err = svc.userService.WarnIfInitialSetupUnprotected(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the initial setup: %w", err)
+	}
+
+	err = svc.appConfigService.WarnIfSecretsUnencrypted(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check the encryption of sensitive values: %w", err)
+	}
+
 	// Init the job scheduler
-	scheduler, err := job.NewScheduler()
+	scheduler, err := job.NewScheduler(job.NewLeaderElector(db))
 	if err != nil {
 		return fmt.Errorf("failed to create job scheduler: %w", err)
 	}
@@ -48,13 +65,16 @@ func Bootstrap(ctx context.Context) error {
 		return fmt.Errorf("failed to register scheduled jobs: %w", err)
 	}
 
+	// Background jobs are run by the same replica as the scheduled jobs
+	svc.backgroundJobService.SetLeaderChecker(scheduler.LeaderElector())
+
 	// Init the router
-	router := initRouter(db, svc)
+	router := initRouter(db, svc, scheduler)
 
 	// Run all background services
 	// This call blocks until the context is canceled
 	err = utils.
-		NewServiceRunner(router, scheduler.Run).
+		NewServiceRunner(router, scheduler.Run, svc.outboxService.Run, svc.backgroundJobService.Run, svc.jwtService.RunKeySync, svc.appConfigService.RunConfigSync).
 		Run(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to run services: %w", err)
@@ -1,10 +1,14 @@
 package bootstrap
 
 import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -14,17 +18,26 @@ import (
 	postgresMigrate "github.com/golang-migrate/migrate/v4/database/postgres"
 	sqliteMigrate "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	slogGorm "github.com/orandin/slog-gorm"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	gormLogger "gorm.io/gorm/logger"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	sqliteutil "github.com/pocket-id/pocket-id/backend/internal/utils/sqlite"
 	"github.com/pocket-id/pocket-id/backend/resources"
 )
 
 func NewDatabase() (db *gorm.DB, err error) {
+	// The key must be set before any encrypted value is read or written
+	err = initDbEncryption()
+	if err != nil {
+		return nil, err
+	}
+
 	db, err = connectDatabase()
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
@@ -40,7 +53,13 @@ func NewDatabase() (db *gorm.DB, err error) {
 	case common.DbProviderSqlite:
 		driver, err = sqliteMigrate.WithInstance(sqlDb, &sqliteMigrate.Config{})
 	case common.DbProviderPostgres:
-		driver, err = postgresMigrate.WithInstance(sqlDb, &postgresMigrate.Config{})
+		var conn *sql.Conn
+		conn, err = openPostgresMigrationConn(sqlDb)
+		if err != nil {
+			return nil, err
+		}
+		defer closePostgresMigrationConn(conn)
+		driver, err = postgresMigrate.WithConnection(context.Background(), conn, &postgresMigrate.Config{})
 	default:
 		// Should never happen at this point
 		return nil, fmt.Errorf("unsupported database provider: %s", common.EnvConfig.DbProvider)
@@ -54,9 +73,55 @@ func NewDatabase() (db *gorm.DB, err error) {
 		return nil, fmt.Errorf("failed to run migrations: %w", err)
 	}
 
+	if common.EnvConfig.DbProvider == common.DbProviderPostgres && common.EnvConfig.DbPostgresUnaccent {
+		if err := checkPostgresUnaccentExtension(db); err != nil {
+			return nil, err
+		}
+	}
+
 	return db, nil
 }
 
+// checkPostgresUnaccentExtension ensures that the "unaccent" extension, which is used for accent-insensitive searches, is installed
+func checkPostgresUnaccentExtension(db *gorm.DB) error {
+	var count int64
+	err := db.Raw("SELECT COUNT(*) FROM pg_extension WHERE extname = 'unaccent'").Scan(&count).Error
+	if err != nil {
+		return fmt.Errorf("failed to check for the unaccent extension: %w", err)
+	}
+	if count == 0 {
+		return errors.New("DB_POSTGRES_UNACCENT is enabled, but the 'unaccent' extension is not installed; run 'CREATE EXTENSION unaccent' on the database")
+	}
+	return nil
+}
+
+// openPostgresMigrationConn returns a connection without statement timeout, as migrations can take much longer than regular queries
+func openPostgresMigrationConn(sqlDb *sql.DB) (*sql.Conn, error) {
+	conn, err := sqlDb.Conn(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migration connection: %w", err)
+	}
+
+	_, err = conn.ExecContext(context.Background(), "SET statement_timeout = 0")
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to disable the statement timeout of the migration connection: %w", err)
+	}
+
+	return conn, nil
+}
+
+// closePostgresMigrationConn restores the statement timeout of the connection before it's returned to the pool
+func closePostgresMigrationConn(conn *sql.Conn) {
+	_, err := conn.ExecContext(context.Background(), "RESET statement_timeout")
+	if err != nil {
+		// The connection can't be used for regular queries anymore
+		_ = conn.Raw(func(any) error { return sqldriver.ErrBadConn })
+		slog.Warn("Failed to restore the statement timeout of the migration connection", slog.Any("error", err))
+	}
+	_ = conn.Close()
+}
+
 func migrateDatabase(driver database.Driver) error {
 	// Use the embedded migrations
 	source, err := iofs.New(resources.FS, "migrations/"+string(common.EnvConfig.DbProvider))
@@ -99,7 +164,10 @@ func connectDatabase() (db *gorm.DB, err error) {
 		if common.EnvConfig.DbConnectionString == "" {
 			return nil, errors.New("missing required env var 'DB_CONNECTION_STRING' for Postgres database")
 		}
-		dialector = postgres.Open(common.EnvConfig.DbConnectionString)
+		dialector, err = openPostgres(common.EnvConfig.DbConnectionString, common.EnvConfig.DbQueryTimeout)
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, fmt.Errorf("unsupported database provider: %s", common.EnvConfig.DbProvider)
 	}
@@ -110,6 +178,10 @@ func connectDatabase() (db *gorm.DB, err error) {
 			Logger:         getGormLogger(),
 		})
 		if err == nil {
+			err = db.Use(utils.QueryTimeoutPlugin{Timeout: common.EnvConfig.DbQueryTimeout})
+			if err != nil {
+				return nil, fmt.Errorf("failed to register query timeout plugin: %w", err)
+			}
 			return db, nil
 		}
 
@@ -120,6 +192,23 @@ func connectDatabase() (db *gorm.DB, err error) {
 	return nil, err
 }
 
+// openPostgres opens a Postgres database whose connections have a statement_timeout, unless it's already set in the connection string.
+// Queries are canceled by the client after the timeout as well, but this also stops queries whose cancellation doesn't reach the server.
+func openPostgres(connString string, queryTimeout time.Duration) (gorm.Dialector, error) {
+	config, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Postgres connection string: %w", err)
+	}
+
+	if _, ok := config.RuntimeParams["statement_timeout"]; !ok && queryTimeout > 0 {
+		config.RuntimeParams["statement_timeout"] = strconv.FormatInt(queryTimeout.Milliseconds(), 10)
+	}
+
+	return postgres.New(postgres.Config{
+		Conn: stdlib.OpenDB(*config),
+	}), nil
+}
+
 // The official C implementation of SQLite allows some additional properties in the connection string
 // that are not supported in the in the modernc.org/sqlite driver, and which must be passed as PRAGMA args instead.
 // To ensure that people can use similar args as in the C driver, which was also used by Pocket ID
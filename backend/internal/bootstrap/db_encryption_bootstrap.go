@@ -0,0 +1,40 @@
+package bootstrap
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// LoadDbEncryptionKey loads the key used to encrypt sensitive values in the database, from the value or from the file.
+// It returns nil if neither is set, in which case values are stored unencrypted.
+func LoadDbEncryptionKey(value string, file string) ([]byte, error) {
+	input := []byte(value)
+
+	// If there's nothing in the value, try loading from file
+	if len(input) == 0 && file != "" {
+		var err error
+		input, err = os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file '%s': %w", file, err)
+		}
+	}
+
+	if len(input) == 0 {
+		return nil, nil
+	}
+
+	return datatype.DeriveEncryptionKey(input), nil
+}
+
+func initDbEncryption() error {
+	key, err := LoadDbEncryptionKey(common.EnvConfig.DbEncryptionKey, common.EnvConfig.DbEncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the database encryption key: %w", err)
+	}
+
+	datatype.SetEncryptionKey(key)
+	return nil
+}
@@ -125,10 +125,14 @@ func initOtelLogging(ctx context.Context, resource *resource.Resource) error {
 	// Set the logger provider globally
 	globallog.SetLoggerProvider(provider)
 
-	// Wrap the handler in a "fanout" one
-	handler = utils.LogFanoutHandler{
-		handler,
-		otelslog.NewHandler(common.Name, otelslog.WithLoggerProvider(provider)),
+	// Wrap the handler in a "fanout" one, redact sensitive values, and add the correlation ID from the context to all records
+	handler = utils.LogCorrelationHandler{
+		Handler: utils.LogRedactionHandler{
+			Handler: utils.LogFanoutHandler{
+				handler,
+				otelslog.NewHandler(common.Name, otelslog.WithLoggerProvider(provider)),
+			},
+		},
 	}
 
 	// Set the default slog to send logs to OTel and add the app name
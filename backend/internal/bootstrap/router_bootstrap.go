@@ -21,6 +21,7 @@ import (
 	"github.com/pocket-id/pocket-id/backend/frontend"
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/controller"
+	"github.com/pocket-id/pocket-id/backend/internal/job"
 	"github.com/pocket-id/pocket-id/backend/internal/middleware"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/systemd"
@@ -29,8 +30,8 @@ import (
 // This is used to register additional controllers for tests
 var registerTestControllers []func(apiGroup *gin.RouterGroup, db *gorm.DB, svc *services)
 
-func initRouter(db *gorm.DB, svc *services) utils.Service {
-	runner, err := initRouterInternal(db, svc)
+func initRouter(db *gorm.DB, svc *services, scheduler *job.Scheduler) utils.Service {
+	runner, err := initRouterInternal(db, svc, scheduler)
 	if err != nil {
 		slog.Error("Failed to init router", "error", err)
 		os.Exit(1)
@@ -38,7 +39,7 @@ func initRouter(db *gorm.DB, svc *services) utils.Service {
 	return runner
 }
 
-func initRouterInternal(db *gorm.DB, svc *services) (utils.Service, error) {
+func initRouterInternal(db *gorm.DB, svc *services, scheduler *job.Scheduler) (utils.Service, error) {
 	// Set the appropriate Gin mode based on the environment
 	switch common.EnvConfig.AppEnv {
 	case "production":
@@ -82,10 +83,14 @@ func initRouterInternal(db *gorm.DB, svc *services) (utils.Service, error) {
 		r.Use(otelgin.Middleware(common.Name))
 	}
 
+	// This must come after the tracing middleware, so the trace ID can be used as correlation ID
+	r.Use(middleware.NewCorrelationIDMiddleware().Add())
+
 	rateLimitMiddleware := middleware.NewRateLimitMiddleware().Add(rate.Every(time.Second), 60)
+	requestLimitsMiddleware := middleware.NewRequestLimitsMiddleware().Add(common.EnvConfig.MaxRequestBodySize, common.EnvConfig.MaxJSONDepth)
 
 	// Setup global middleware
-	r.Use(middleware.NewCorsMiddleware().Add())
+	r.Use(middleware.NewCorsMiddleware(middleware.NewCorsPolicyFromEnv()).Add())
 	r.Use(middleware.NewErrorHandlerMiddleware().Add())
 
 	err := frontend.RegisterFrontend(r)
@@ -100,15 +105,21 @@ func initRouterInternal(db *gorm.DB, svc *services) (utils.Service, error) {
 	fileSizeLimitMiddleware := middleware.NewFileSizeLimitMiddleware()
 
 	// Set up API routes
-	apiGroup := r.Group("/api", rateLimitMiddleware)
+	// Routes that accept uploads override the request body limits with the file size limit middleware
+	apiGroup := r.Group("/api", rateLimitMiddleware, requestLimitsMiddleware)
 	controller.NewApiKeyController(apiGroup, authMiddleware, svc.apiKeyService)
 	controller.NewWebauthnController(apiGroup, authMiddleware, middleware.NewRateLimitMiddleware(), svc.webauthnService, svc.appConfigService)
 	controller.NewOidcController(apiGroup, authMiddleware, fileSizeLimitMiddleware, svc.oidcService, svc.jwtService)
-	controller.NewUserController(apiGroup, authMiddleware, middleware.NewRateLimitMiddleware(), svc.userService, svc.appConfigService)
-	controller.NewAppConfigController(apiGroup, authMiddleware, svc.appConfigService, svc.emailService, svc.ldapService)
+	controller.NewUserController(apiGroup, authMiddleware, middleware.NewRateLimitMiddleware(), fileSizeLimitMiddleware, svc.userService, svc.appConfigService)
+	controller.NewAppConfigController(apiGroup, authMiddleware, fileSizeLimitMiddleware, svc.appConfigService, svc.emailService, svc.ldapService, svc.auditLogService)
 	controller.NewAuditLogController(apiGroup, svc.auditLogService, authMiddleware)
-	controller.NewUserGroupController(apiGroup, authMiddleware, svc.userGroupService)
+	controller.NewUserGroupController(apiGroup, authMiddleware, fileSizeLimitMiddleware, svc.userGroupService)
+	controller.NewImportReportController(apiGroup, authMiddleware, svc.importReportService)
 	controller.NewCustomClaimController(apiGroup, authMiddleware, svc.customClaimService)
+	controller.NewScheduledJobController(apiGroup, authMiddleware, scheduler)
+	controller.NewBackgroundJobController(apiGroup, authMiddleware, svc.backgroundJobService)
+	controller.NewConsistencyCheckController(apiGroup, authMiddleware, svc.consistencyCheckService)
+	controller.NewEmailController(apiGroup, authMiddleware, middleware.NewRateLimitMiddleware(), svc.emailService)
 
 	// Add test controller in non-production environments
 	if common.EnvConfig.AppEnv != "production" {
@@ -118,12 +129,12 @@ func initRouterInternal(db *gorm.DB, svc *services) (utils.Service, error) {
 	}
 
 	// Set up base routes
-	baseGroup := r.Group("/", rateLimitMiddleware)
-	controller.NewWellKnownController(baseGroup, svc.jwtService)
+	baseGroup := r.Group("/", rateLimitMiddleware, requestLimitsMiddleware)
+	controller.NewWellKnownController(baseGroup, svc.jwtService, r.Routes)
 
 	// Set up healthcheck routes
 	// These are not rate-limited
-	controller.NewHealthzController(r)
+	controller.NewHealthzController(r, authMiddleware, scheduler.LeaderElector(), svc.jwtService, svc.ldapService, svc.appConfigService)
 
 	// Set up the server
 	srv := &http.Server{
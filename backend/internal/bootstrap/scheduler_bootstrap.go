@@ -23,10 +23,22 @@ func registerScheduledJobs(ctx context.Context, db *gorm.DB, svc *services, http
 	if err != nil {
 		return fmt.Errorf("failed to register DB cleanup jobs in scheduler: %w", err)
 	}
+	err = scheduler.RegisterAuditLogArchiveJob(ctx, svc.auditLogArchiveService)
+	if err != nil {
+		return fmt.Errorf("failed to register audit log archive job in scheduler: %w", err)
+	}
+	err = scheduler.RegisterAuditLogLocationJob(ctx, svc.auditLogService)
+	if err != nil {
+		return fmt.Errorf("failed to register audit log location job in scheduler: %w", err)
+	}
 	err = scheduler.RegisterFileCleanupJobs(ctx, db)
 	if err != nil {
 		return fmt.Errorf("failed to register file cleanup jobs in scheduler: %w", err)
 	}
+	err = scheduler.RegisterConsistencyCheckJob(ctx, svc.consistencyCheckService)
+	if err != nil {
+		return fmt.Errorf("failed to register consistency check job in scheduler: %w", err)
+	}
 	err = scheduler.RegisterApiKeyExpiryJob(ctx, svc.apiKeyService, svc.appConfigService)
 	if err != nil {
 		return fmt.Errorf("failed to register API key expiration jobs in scheduler: %w", err)
@@ -35,6 +47,14 @@ func registerScheduledJobs(ctx context.Context, db *gorm.DB, svc *services, http
 	if err != nil {
 		return fmt.Errorf("failed to register analytics job in scheduler: %w", err)
 	}
+	err = scheduler.RegisterKeyRotationJob(ctx, svc.jwtService)
+	if err != nil {
+		return fmt.Errorf("failed to register key rotation job in scheduler: %w", err)
+	}
+	err = scheduler.RegisterInactiveUserJob(ctx, svc.userService)
+	if err != nil {
+		return fmt.Errorf("failed to register inactive user job in scheduler: %w", err)
+	}
 
 	return nil
 }
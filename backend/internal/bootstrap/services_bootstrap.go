@@ -7,22 +7,33 @@ import (
 
 	"gorm.io/gorm"
 
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/secrets"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/storage"
 )
 
 type services struct {
-	appConfigService   *service.AppConfigService
-	emailService       *service.EmailService
-	geoLiteService     *service.GeoLiteService
-	auditLogService    *service.AuditLogService
-	jwtService         *service.JwtService
-	webauthnService    *service.WebAuthnService
-	userService        *service.UserService
-	customClaimService *service.CustomClaimService
-	oidcService        *service.OidcService
-	userGroupService   *service.UserGroupService
-	ldapService        *service.LdapService
-	apiKeyService      *service.ApiKeyService
+	appConfigService    *service.AppConfigService
+	emailService        *service.EmailService
+	geoLiteService      *service.GeoLiteService
+	auditLogService     *service.AuditLogService
+	jwtService          *service.JwtService
+	webauthnService     *service.WebAuthnService
+	userService         *service.UserService
+	customClaimService  *service.CustomClaimService
+	oidcService         *service.OidcService
+	userGroupService    *service.UserGroupService
+	ldapService         *service.LdapService
+	apiKeyService       *service.ApiKeyService
+	outboxService       *service.OutboxService
+	importReportService *service.ImportReportService
+
+	consistencyCheckService *service.ConsistencyCheckService
+	backgroundJobService    *service.BackgroundJobService
+	// Nil if audit logs aren't archived
+	auditLogArchiveService *service.AuditLogArchiveService
 }
 
 // Initializes all services
@@ -34,34 +45,97 @@ func initServices(ctx context.Context, db *gorm.DB, httpClient *http.Client) (sv
 		return nil, fmt.Errorf("failed to create app config service: %w", err)
 	}
 
-	svc.emailService, err = service.NewEmailService(db, svc.appConfigService)
+	secretsProvider, err := secrets.NewProvider(&common.EnvConfig, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create secrets provider: %w", err)
+	}
+
+	svc.emailService, err = service.NewEmailService(db, svc.appConfigService, secretsProvider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create email service: %w", err)
 	}
 
+	svc.outboxService = service.NewOutboxService(db)
+	svc.backgroundJobService = service.NewBackgroundJobService(db)
 	svc.geoLiteService = service.NewGeoLiteService(httpClient)
-	svc.auditLogService = service.NewAuditLogService(db, svc.appConfigService, svc.emailService, svc.geoLiteService)
-	svc.jwtService, err = service.NewJwtService(db, svc.appConfigService)
+	svc.auditLogService = service.NewAuditLogService(db, httpClient, svc.appConfigService, svc.emailService, svc.geoLiteService, svc.outboxService)
+	svc.jwtService, err = service.NewJwtService(db, httpClient, svc.appConfigService, secretsProvider)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create JWT service: %w", err)
 	}
 
-	svc.userService = service.NewUserService(db, svc.jwtService, svc.auditLogService, svc.emailService, svc.appConfigService)
+	bulkWorkerPool, err := newBulkWorkerPool(db)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.userService = service.NewUserService(db, svc.jwtService, svc.auditLogService, svc.emailService, svc.appConfigService, svc.outboxService, bulkWorkerPool)
 	svc.customClaimService = service.NewCustomClaimService(db)
 
-	svc.oidcService, err = service.NewOidcService(ctx, db, svc.jwtService, svc.appConfigService, svc.auditLogService, svc.customClaimService)
+	uploadStorage, err := storage.New(storage.Config{
+		Type:              common.EnvConfig.UploadStorage,
+		Path:              common.EnvConfig.UploadPath,
+		S3Endpoint:        common.EnvConfig.UploadS3Endpoint,
+		S3Region:          common.EnvConfig.UploadS3Region,
+		S3Bucket:          common.EnvConfig.UploadS3Bucket,
+		S3AccessKeyID:     common.EnvConfig.UploadS3AccessKeyID,
+		S3SecretAccessKey: common.EnvConfig.UploadS3SecretAccessKey,
+		S3Prefix:          common.EnvConfig.UploadS3Prefix,
+		S3PathStyle:       common.EnvConfig.UploadS3PathStyle,
+	}, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload storage: %w", err)
+	}
+
+	svc.oidcService, err = service.NewOidcService(ctx, db, svc.jwtService, svc.appConfigService, svc.auditLogService, svc.customClaimService, svc.geoLiteService, uploadStorage)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create OIDC service: %w", err)
 	}
 
-	svc.userGroupService = service.NewUserGroupService(db, svc.appConfigService)
-	svc.ldapService = service.NewLdapService(db, httpClient, svc.appConfigService, svc.userService, svc.userGroupService)
-	svc.apiKeyService = service.NewApiKeyService(db, svc.emailService)
+	svc.importReportService = service.NewImportReportService(db)
+	svc.userGroupService = service.NewUserGroupService(db, svc.appConfigService, svc.auditLogService, svc.importReportService)
+	svc.ldapService = service.NewLdapService(db, httpClient, svc.appConfigService, svc.userService, svc.userGroupService, svc.emailService, svc.auditLogService, bulkWorkerPool, secretsProvider)
+	svc.apiKeyService = service.NewApiKeyService(db, svc.emailService, svc.auditLogService, svc.appConfigService)
+	svc.consistencyCheckService = service.NewConsistencyCheckService(db)
+
+	if common.EnvConfig.AuditLogArchiveStorage != "" {
+		archiveStorage, err := storage.New(storage.Config{
+			Type:              common.EnvConfig.AuditLogArchiveStorage,
+			Path:              common.EnvConfig.AuditLogArchivePath,
+			S3Endpoint:        common.EnvConfig.AuditLogArchiveS3Endpoint,
+			S3Region:          common.EnvConfig.AuditLogArchiveS3Region,
+			S3Bucket:          common.EnvConfig.AuditLogArchiveS3Bucket,
+			S3AccessKeyID:     common.EnvConfig.AuditLogArchiveS3AccessKeyID,
+			S3SecretAccessKey: common.EnvConfig.AuditLogArchiveS3SecretAccessKey,
+			S3Prefix:          common.EnvConfig.AuditLogArchiveS3Prefix,
+			S3PathStyle:       common.EnvConfig.AuditLogArchiveS3PathStyle,
+		}, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create audit log archive storage: %w", err)
+		}
+		svc.auditLogArchiveService = service.NewAuditLogArchiveService(db, archiveStorage, common.EnvConfig.AuditLogArchiveAfter)
+	}
 
 	svc.webauthnService, err = service.NewWebAuthnService(db, svc.jwtService, svc.auditLogService, svc.appConfigService)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create WebAuthn service: %w", err)
 	}
 
+	registerBackgroundJobHandlers(svc)
+
 	return svc, nil
 }
+
+// newBulkWorkerPool creates the worker pool shared by bulk operations like the LDAP sync
+func newBulkWorkerPool(db *gorm.DB) (*utils.WorkerPool, error) {
+	limit := common.EnvConfig.BulkConcurrency
+	if limit == 0 {
+		sqlDb, err := db.DB()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get sql.DB: %w", err)
+		}
+		limit = utils.DefaultWorkerPoolLimit(sqlDb.Stats().MaxOpenConnections)
+	}
+
+	return utils.NewWorkerPool("bulk", limit), nil
+}
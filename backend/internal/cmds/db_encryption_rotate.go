@@ -0,0 +1,83 @@
+package cmds
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/bootstrap"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+type dbEncryptionRotateFlags struct {
+	OldKey     string
+	OldKeyFile string
+	Yes        bool
+}
+
+func init() {
+	var flags dbEncryptionRotateFlags
+
+	dbEncryptionRotateCmd := &cobra.Command{
+		Use:   "db-encryption-rotate",
+		Short: "Re-encrypts the sensitive values stored in the database with the current DB_ENCRYPTION_KEY",
+		Long: "Re-encrypts the sensitive values stored in the database, like the SMTP and LDAP passwords, with the key set in DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE.\n" +
+			"Values encrypted with the previous key are decrypted with the key passed with --old-key or --old-key-file, and unencrypted values are encrypted.\n" +
+			"If no key is set in the environment, all values are decrypted and stored unencrypted.",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, err := bootstrap.NewDatabase()
+			if err != nil {
+				return err
+			}
+
+			return dbEncryptionRotate(cmd.Context(), flags, db, &common.EnvConfig)
+		},
+	}
+
+	dbEncryptionRotateCmd.Flags().StringVar(&flags.OldKey, "old-key", "", "Previous value of DB_ENCRYPTION_KEY, if the values were encrypted with a different key")
+	dbEncryptionRotateCmd.Flags().StringVar(&flags.OldKeyFile, "old-key-file", "", "File containing the previous key, if the values were encrypted with a different key")
+	dbEncryptionRotateCmd.Flags().BoolVarP(&flags.Yes, "yes", "y", false, "Do not prompt for confirmation")
+
+	rootCmd.AddCommand(dbEncryptionRotateCmd)
+}
+
+func dbEncryptionRotate(ctx context.Context, flags dbEncryptionRotateFlags, db *gorm.DB, envConfig *common.EnvConfigSchema) error {
+	newKey, err := bootstrap.LoadDbEncryptionKey(envConfig.DbEncryptionKey, envConfig.DbEncryptionKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the new key: %w", err)
+	}
+	oldKey, err := bootstrap.LoadDbEncryptionKey(flags.OldKey, flags.OldKeyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load the old key: %w", err)
+	}
+
+	if !flags.Yes {
+		if newKey == nil {
+			fmt.Println("WARNING: DB_ENCRYPTION_KEY is not set, so all sensitive values will be stored unencrypted in the database.")
+		} else {
+			fmt.Println("WARNING: Once re-encrypted, the sensitive values can only be read with the new key. All instances of pocket-id must be restarted with the new key.")
+		}
+		ok, err := utils.PromptForConfirmation("Confirm")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Aborted")
+			return nil
+		}
+	}
+
+	updated, err := service.ReencryptSensitiveAppConfigValues(ctx, db, oldKey, newKey)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Updated %d value(s) successfully\n", updated)
+	fmt.Println("Note: if pocket-id is running, you will need to restart it with the new key")
+
+	return nil
+}
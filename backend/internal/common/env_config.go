@@ -6,6 +6,9 @@ import (
 	"log/slog"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env/v11"
 	_ "github.com/joho/godotenv/autoload"
@@ -27,6 +30,9 @@ const (
 	defaultSqliteConnString string     = "file:data/pocket-id.db?_pragma=journal_mode(WAL)&_pragma=busy_timeout(2500)&_txlock=immediate"
 )
 
+// URL of the SHA-256 checksum MaxMind publishes for the GeoLite2 City archive
+const maxMindGeoLiteCityChecksumUrl = "https://download.maxmind.com/app/geoip_download?edition_id=GeoLite2-City&license_key=%s&suffix=tar.gz.sha256"
+
 type EnvConfigSchema struct {
 	AppEnv             string     `env:"APP_ENV"`
 	AppURL             string     `env:"APP_URL"`
@@ -51,6 +57,105 @@ type EnvConfigSchema struct {
 	LogJSON            bool       `env:"LOG_JSON"`
 	TrustProxy         bool       `env:"TRUST_PROXY"`
 	AnalyticsDisabled  bool       `env:"ANALYTICS_DISABLED"`
+	MaxRequestBodySize int64      `env:"MAX_REQUEST_BODY_SIZE"`
+	MaxJSONDepth       int        `env:"MAX_JSON_DEPTH"`
+	// CORS policy for the API; if no origins are allowed, only same-origin requests are possible
+	CorsAllowedOrigins   []string `env:"CORS_ALLOWED_ORIGINS"`
+	CorsAllowedMethods   []string `env:"CORS_ALLOWED_METHODS"`
+	CorsAllowedHeaders   []string `env:"CORS_ALLOWED_HEADERS"`
+	CorsAllowCredentials bool     `env:"CORS_ALLOW_CREDENTIALS"`
+	// Secret that must be presented to sign up the initial admin; if empty, anyone can complete the setup
+	SetupSecret string `env:"SETUP_SECRET"`
+	// Maximum number of concurrent workers for bulk operations like the LDAP sync; if 0, it's derived from the size of the database connection pool
+	BulkConcurrency int `env:"BULK_CONCURRENCY"`
+	// Number of attempts to download the GeoLite database; interrupted downloads are resumed
+	GeoLiteDownloadAttempts int `env:"GEOLITE_DOWNLOAD_ATTEMPTS"`
+	// URL of the SHA-256 checksum of the GeoLite archive; if empty, the checksum isn't verified (except for MaxMind, which always publishes one)
+	GeoLiteDBChecksumUrl string `env:"GEOLITE_DB_CHECKSUM_URL"`
+	// Whether the hostname of public IP addresses that aren't in the GeoLite database is looked up with reverse DNS
+	GeoLiteReverseDNS        bool          `env:"GEOLITE_REVERSE_DNS"`
+	GeoLiteReverseDNSTimeout time.Duration `env:"GEOLITE_REVERSE_DNS_TIMEOUT"`
+	// Country shown for public IP addresses whose location is unknown; if empty, the location is left empty
+	GeoLiteUnknownLocationLabel string `env:"GEOLITE_UNKNOWN_LOCATION_LABEL"`
+	// Provider of sensitive configuration values like the SMTP password: "env", "file" or "vault"; if empty, the values stored in the database are used
+	SecretsProvider   string        `env:"SECRETS_PROVIDER"`
+	SecretsFilePath   string        `env:"SECRETS_FILE_PATH"`
+	SecretsVaultAddr  string        `env:"SECRETS_VAULT_ADDR"`
+	SecretsVaultPath  string        `env:"SECRETS_VAULT_PATH"`
+	SecretsVaultToken string        `env:"SECRETS_VAULT_TOKEN"`
+	SecretsCacheTTL   time.Duration `env:"SECRETS_CACHE_TTL"`
+	// Algorithm of the signing key, e.g. "RS256" or "ES256"; if empty, it's derived from the key type
+	KeysAlgorithm string `env:"KEYS_ALGORITHM"`
+	// Path of the PEM file containing the signing key when KEYS_STORAGE is "pem"; defaults to a file in KEYS_PATH
+	KeysPemFile string `env:"KEYS_PEM_FILE"`
+	// HashiCorp Vault Transit key used to sign tokens when KEYS_STORAGE is "kms"; the private key never leaves Vault
+	KeysKmsVaultAddr  string `env:"KEYS_KMS_VAULT_ADDR"`
+	KeysKmsVaultToken string `env:"KEYS_KMS_VAULT_TOKEN"`
+	KeysKmsVaultMount string `env:"KEYS_KMS_VAULT_MOUNT"`
+	KeysKmsVaultKey   string `env:"KEYS_KMS_VAULT_KEY"`
+	// Interval after which keys generated by Pocket ID are rotated; if 0, keys are never rotated
+	KeysRotationInterval time.Duration `env:"KEYS_ROTATION_INTERVAL"`
+	// How long tokens signed with a retired key are still accepted; should be at least the lifetime of refresh tokens
+	KeysRotationGracePeriod time.Duration `env:"KEYS_ROTATION_GRACE_PERIOD"`
+	// Whether searches on Postgres ignore accents; requires the "unaccent" extension, which must be created with "CREATE EXTENSION unaccent"
+	DbPostgresUnaccent bool `env:"DB_POSTGRES_UNACCENT"`
+	// ACR values emitted in ID tokens for users who signed in with a passkey or a one-time code; clients can require them with the acr_values parameter
+	OidcAcrPasskey     string `env:"OIDC_ACR_PASSKEY"`
+	OidcAcrOneTimeCode string `env:"OIDC_ACR_ONE_TIME_CODE"`
+	// Timeout of each attempt to fetch the JWK set of a federated identity, and number of retries after a failed attempt
+	FederatedJwksFetchTimeout time.Duration `env:"FEDERATED_JWKS_FETCH_TIMEOUT"`
+	FederatedJwksFetchRetries int           `env:"FEDERATED_JWKS_FETCH_RETRIES"`
+	// Schemes allowed in the callback URLs of OIDC clients, e.g. "https" or custom schemes of mobile apps; "http" is always allowed for localhost
+	CallbackURLAllowedSchemes []string `env:"CALLBACK_URL_ALLOWED_SCHEMES"`
+	// Whether callback URLs whose host resolves to a private IP address are rejected
+	CallbackURLBlockPrivateIPs bool `env:"CALLBACK_URL_BLOCK_PRIVATE_IPS"`
+	// Key used to encrypt sensitive values stored in the database, like the SMTP and LDAP passwords; if empty, they're stored unencrypted
+	DbEncryptionKey     string `env:"DB_ENCRYPTION_KEY"`
+	DbEncryptionKeyFile string `env:"DB_ENCRYPTION_KEY_FILE"`
+	// Emergency override that lets everyone sign in regardless of the geoblocking policy, e.g. if the admins are locked out
+	GeoblockingDisabled bool `env:"GEOBLOCKING_DISABLED"`
+	// Interval at which each replica checks if the application configuration was changed by another replica; if 0, changes are only loaded at startup
+	AppConfigSyncInterval time.Duration `env:"APP_CONFIG_SYNC_INTERVAL"`
+	// Directory for temporary files, like uploaded images and downloaded databases before they're moved to their destination; if empty, they're created next to the destination
+	TempPath string `env:"TEMP_PATH"`
+	// Number of items per page of listings if the client doesn't request one, and the maximum a client can request
+	PaginationDefaultLimit int `env:"PAGINATION_DEFAULT_LIMIT"`
+	PaginationMaxLimit     int `env:"PAGINATION_MAX_LIMIT"`
+	// Environment in the prefix of new API keys, "live" or "test"; keys of another environment are rejected
+	ApiKeyEnvironment string `env:"API_KEY_ENVIRONMENT"`
+	// Whether API keys created before the prefixed format was introduced are still accepted; disable once they've all been replaced
+	ApiKeyLegacyFormatAllowed bool `env:"API_KEY_LEGACY_FORMAT_ALLOWED"`
+	// Maximum duration of a database query, and of the queries of an LDAP sync and of maintenance jobs like the cleanups, the audit log archival
+	// and the re-encryption of the database, which can be much slower; if 0, queries don't time out. Migrations never time out
+	DbQueryTimeout            time.Duration `env:"DB_QUERY_TIMEOUT"`
+	DbQueryTimeoutLdapSync    time.Duration `env:"DB_QUERY_TIMEOUT_LDAP_SYNC"`
+	DbQueryTimeoutMaintenance time.Duration `env:"DB_QUERY_TIMEOUT_MAINTENANCE"`
+	// Where audit logs are archived before they're deleted: "file" or "s3"; if empty, they're deleted without being archived
+	AuditLogArchiveStorage string `env:"AUDIT_LOG_ARCHIVE_STORAGE"`
+	// Age after which audit logs are archived and deleted from the database
+	AuditLogArchiveAfter time.Duration `env:"AUDIT_LOG_ARCHIVE_AFTER"`
+	// Directory of the archives when AUDIT_LOG_ARCHIVE_STORAGE is "file"
+	AuditLogArchivePath string `env:"AUDIT_LOG_ARCHIVE_PATH"`
+	// S3-compatible bucket of the archives when AUDIT_LOG_ARCHIVE_STORAGE is "s3"; if the endpoint is empty, AWS is used
+	AuditLogArchiveS3Endpoint        string `env:"AUDIT_LOG_ARCHIVE_S3_ENDPOINT"`
+	AuditLogArchiveS3Region          string `env:"AUDIT_LOG_ARCHIVE_S3_REGION"`
+	AuditLogArchiveS3Bucket          string `env:"AUDIT_LOG_ARCHIVE_S3_BUCKET"`
+	AuditLogArchiveS3AccessKeyID     string `env:"AUDIT_LOG_ARCHIVE_S3_ACCESS_KEY_ID"`
+	AuditLogArchiveS3SecretAccessKey string `env:"AUDIT_LOG_ARCHIVE_S3_SECRET_ACCESS_KEY"`
+	AuditLogArchiveS3Prefix          string `env:"AUDIT_LOG_ARCHIVE_S3_PREFIX"`
+	AuditLogArchiveS3PathStyle       bool   `env:"AUDIT_LOG_ARCHIVE_S3_PATH_STYLE"`
+	// Where uploaded images, like the logos of OIDC clients, are stored: "file" to store them in UPLOAD_PATH, or "s3"
+	UploadStorage string `env:"UPLOAD_STORAGE"`
+	// S3-compatible bucket of the uploads when UPLOAD_STORAGE is "s3"; if the endpoint is empty, AWS is used
+	UploadS3Endpoint        string `env:"UPLOAD_S3_ENDPOINT"`
+	UploadS3Region          string `env:"UPLOAD_S3_REGION"`
+	UploadS3Bucket          string `env:"UPLOAD_S3_BUCKET"`
+	UploadS3AccessKeyID     string `env:"UPLOAD_S3_ACCESS_KEY_ID"`
+	UploadS3SecretAccessKey string `env:"UPLOAD_S3_SECRET_ACCESS_KEY"`
+	UploadS3Prefix          string `env:"UPLOAD_S3_PREFIX"`
+	UploadS3PathStyle       bool   `env:"UPLOAD_S3_PATH_STYLE"`
+	// Generator of the profile pictures of users who didn't upload one: "initials" or "identicon"
+	ProfilePictureGenerator string `env:"PROFILE_PICTURE_GENERATOR"`
 }
 
 var EnvConfig = defaultConfig()
@@ -70,7 +175,7 @@ func defaultConfig() EnvConfigSchema {
 		DbConnectionString: "",
 		UploadPath:         "data/uploads",
 		KeysPath:           "data/keys",
-		KeysStorage:        "", // "database" or "file"
+		KeysStorage:        "", // "database", "file", "pem" or "kms"
 		EncryptionKey:      "",
 		AppURL:             "http://localhost:1411",
 		Port:               "1411",
@@ -86,6 +191,35 @@ func defaultConfig() EnvConfigSchema {
 		TracingEnabled:     false,
 		TrustProxy:         false,
 		AnalyticsDisabled:  false,
+		MaxRequestBodySize: 1 << 20, // 1 MB
+		MaxJSONDepth:       32,
+		CorsAllowedOrigins: nil,
+		CorsAllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		CorsAllowedHeaders: []string{"Authorization", "Content-Type", "X-API-KEY"},
+
+		GeoLiteDownloadAttempts:  5,
+		GeoLiteReverseDNSTimeout: 2 * time.Second,
+		SecretsCacheTTL:          5 * time.Minute,
+		KeysKmsVaultMount:        "transit",
+		KeysRotationGracePeriod:  30 * 24 * time.Hour,
+		OidcAcrPasskey:           "phr",
+		OidcAcrOneTimeCode:       "otp",
+
+		CallbackURLAllowedSchemes: []string{"https"},
+		FederatedJwksFetchTimeout: 5 * time.Second,
+		FederatedJwksFetchRetries: 2,
+		AppConfigSyncInterval:     10 * time.Second,
+		PaginationDefaultLimit:    20,
+		PaginationMaxLimit:        100,
+		ApiKeyEnvironment:         "live",
+		ApiKeyLegacyFormatAllowed: true,
+		DbQueryTimeout:            time.Minute,
+		DbQueryTimeoutLdapSync:    10 * time.Minute,
+		DbQueryTimeoutMaintenance: 30 * time.Minute,
+		AuditLogArchiveAfter:      90 * 24 * time.Hour,
+		AuditLogArchivePath:       "data/audit-log-archive",
+		UploadStorage:             "file",
+		ProfilePictureGenerator:   "initials",
 	}
 }
 
@@ -128,9 +262,147 @@ func parseEnvConfig() error {
 		}
 	case "file":
 		// All good, these are valid values
+	case "pem":
+		if EnvConfig.KeysPemFile == "" {
+			EnvConfig.KeysPemFile = filepath.Join(EnvConfig.KeysPath, "jwt_private_key.pem")
+		}
+	case "kms":
+		if EnvConfig.KeysKmsVaultAddr == "" || EnvConfig.KeysKmsVaultToken == "" || EnvConfig.KeysKmsVaultKey == "" {
+			return errors.New("KEYS_KMS_VAULT_ADDR, KEYS_KMS_VAULT_TOKEN and KEYS_KMS_VAULT_KEY must be non-empty when KEYS_STORAGE is kms")
+		}
 	default:
 		return fmt.Errorf("invalid value for KEYS_STORAGE: %s", EnvConfig.KeysStorage)
 	}
 
+	if EnvConfig.MaxRequestBodySize <= 0 {
+		return errors.New("MAX_REQUEST_BODY_SIZE must be greater than 0")
+	}
+	if EnvConfig.MaxJSONDepth <= 0 {
+		return errors.New("MAX_JSON_DEPTH must be greater than 0")
+	}
+	// MaxMind publishes a checksum for its archives, other providers need to be configured explicitly
+	if EnvConfig.GeoLiteDBChecksumUrl == "" && EnvConfig.GeoLiteDBUrl == MaxMindGeoLiteCityUrl {
+		EnvConfig.GeoLiteDBChecksumUrl = maxMindGeoLiteCityChecksumUrl
+	}
+	if EnvConfig.GeoLiteDownloadAttempts <= 0 {
+		return errors.New("GEOLITE_DOWNLOAD_ATTEMPTS must be greater than 0")
+	}
+	if EnvConfig.GeoLiteReverseDNS && EnvConfig.GeoLiteReverseDNSTimeout <= 0 {
+		return errors.New("GEOLITE_REVERSE_DNS_TIMEOUT must be greater than 0")
+	}
+	switch EnvConfig.SecretsProvider {
+	case "", "env":
+		// Nothing else to configure
+	case "file":
+		if EnvConfig.SecretsFilePath == "" {
+			return errors.New("SECRETS_FILE_PATH must be non-empty when SECRETS_PROVIDER is file")
+		}
+	case "vault":
+		if EnvConfig.SecretsVaultAddr == "" || EnvConfig.SecretsVaultPath == "" || EnvConfig.SecretsVaultToken == "" {
+			return errors.New("SECRETS_VAULT_ADDR, SECRETS_VAULT_PATH and SECRETS_VAULT_TOKEN must be non-empty when SECRETS_PROVIDER is vault")
+		}
+	default:
+		return fmt.Errorf("invalid value for SECRETS_PROVIDER: %s", EnvConfig.SecretsProvider)
+	}
+	if EnvConfig.KeysRotationInterval < 0 || EnvConfig.KeysRotationGracePeriod < 0 {
+		return errors.New("KEYS_ROTATION_INTERVAL and KEYS_ROTATION_GRACE_PERIOD must not be negative")
+	}
+	if EnvConfig.KeysRotationInterval > 0 && EnvConfig.KeysStorage != "file" && EnvConfig.KeysStorage != "database" {
+		return fmt.Errorf("KEYS_ROTATION_INTERVAL can't be used when KEYS_STORAGE is %s", EnvConfig.KeysStorage)
+	}
+	if EnvConfig.OidcAcrPasskey == "" || EnvConfig.OidcAcrOneTimeCode == "" || EnvConfig.OidcAcrPasskey == EnvConfig.OidcAcrOneTimeCode {
+		return errors.New("OIDC_ACR_PASSKEY and OIDC_ACR_ONE_TIME_CODE must be non-empty and different")
+	}
+	if EnvConfig.FederatedJwksFetchTimeout <= 0 || EnvConfig.FederatedJwksFetchRetries < 0 {
+		return errors.New("FEDERATED_JWKS_FETCH_TIMEOUT must be greater than 0 and FEDERATED_JWKS_FETCH_RETRIES must not be negative")
+	}
+	if EnvConfig.AppConfigSyncInterval < 0 {
+		return errors.New("APP_CONFIG_SYNC_INTERVAL must not be negative")
+	}
+	if EnvConfig.BulkConcurrency < 0 {
+		return errors.New("BULK_CONCURRENCY must not be negative")
+	}
+	if EnvConfig.PaginationDefaultLimit <= 0 || EnvConfig.PaginationMaxLimit < EnvConfig.PaginationDefaultLimit {
+		return errors.New("PAGINATION_DEFAULT_LIMIT must be greater than 0 and not greater than PAGINATION_MAX_LIMIT")
+	}
+	if EnvConfig.DbQueryTimeout < 0 || EnvConfig.DbQueryTimeoutLdapSync < 0 || EnvConfig.DbQueryTimeoutMaintenance < 0 {
+		return errors.New("DB_QUERY_TIMEOUT, DB_QUERY_TIMEOUT_LDAP_SYNC and DB_QUERY_TIMEOUT_MAINTENANCE must not be negative")
+	}
+	switch EnvConfig.AuditLogArchiveStorage {
+	case "", "file":
+		// Nothing else to configure
+	case "s3":
+		if EnvConfig.AuditLogArchiveS3Region == "" || EnvConfig.AuditLogArchiveS3Bucket == "" || EnvConfig.AuditLogArchiveS3AccessKeyID == "" || EnvConfig.AuditLogArchiveS3SecretAccessKey == "" {
+			return errors.New("AUDIT_LOG_ARCHIVE_S3_REGION, AUDIT_LOG_ARCHIVE_S3_BUCKET, AUDIT_LOG_ARCHIVE_S3_ACCESS_KEY_ID and AUDIT_LOG_ARCHIVE_S3_SECRET_ACCESS_KEY must be non-empty when AUDIT_LOG_ARCHIVE_STORAGE is s3")
+		}
+	default:
+		return fmt.Errorf("invalid value for AUDIT_LOG_ARCHIVE_STORAGE: %s", EnvConfig.AuditLogArchiveStorage)
+	}
+	if EnvConfig.AuditLogArchiveAfter < 24*time.Hour {
+		return errors.New("AUDIT_LOG_ARCHIVE_AFTER must be at least 24h")
+	}
+	switch EnvConfig.UploadStorage {
+	case "file":
+		// Nothing else to configure
+	case "s3":
+		if EnvConfig.UploadS3Region == "" || EnvConfig.UploadS3Bucket == "" || EnvConfig.UploadS3AccessKeyID == "" || EnvConfig.UploadS3SecretAccessKey == "" {
+			return errors.New("UPLOAD_S3_REGION, UPLOAD_S3_BUCKET, UPLOAD_S3_ACCESS_KEY_ID and UPLOAD_S3_SECRET_ACCESS_KEY must be non-empty when UPLOAD_STORAGE is s3")
+		}
+	default:
+		return fmt.Errorf("invalid value for UPLOAD_STORAGE: %s", EnvConfig.UploadStorage)
+	}
+	if EnvConfig.ProfilePictureGenerator != "initials" && EnvConfig.ProfilePictureGenerator != "identicon" {
+		return fmt.Errorf("invalid value for PROFILE_PICTURE_GENERATOR: %s", EnvConfig.ProfilePictureGenerator)
+	}
+	if EnvConfig.ApiKeyEnvironment != "live" && EnvConfig.ApiKeyEnvironment != "test" {
+		return fmt.Errorf("invalid value for API_KEY_ENVIRONMENT: %s", EnvConfig.ApiKeyEnvironment)
+	}
+
+	if EnvConfig.TempPath != "" {
+		EnvConfig.TempPath = filepath.Clean(EnvConfig.TempPath)
+	}
+
+	for i, scheme := range EnvConfig.CallbackURLAllowedSchemes {
+		scheme = strings.ToLower(strings.TrimSpace(scheme))
+		if scheme == "" || strings.Contains(scheme, "*") {
+			return fmt.Errorf("invalid scheme '%s' in CALLBACK_URL_ALLOWED_SCHEMES", scheme)
+		}
+		EnvConfig.CallbackURLAllowedSchemes[i] = scheme
+	}
+
+	for i, origin := range EnvConfig.CorsAllowedOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		err = validateCorsOrigin(origin)
+		if err != nil {
+			return fmt.Errorf("invalid origin '%s' in CORS_ALLOWED_ORIGINS: %w", origin, err)
+		}
+		EnvConfig.CorsAllowedOrigins[i] = strings.TrimSuffix(origin, "/")
+	}
+
+	return nil
+}
+
+// validateCorsOrigin checks that the origin is in the format "scheme://host[:port]".
+// The host can start with "*." to allow all direct subdomains of a domain.
+func validateCorsOrigin(origin string) error {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return errors.New("not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("scheme must be http or https")
+	}
+	if u.Host == "" || u.User != nil || (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.Fragment != "" {
+		return errors.New("must be in the format scheme://host[:port]")
+	}
+
+	host := u.Hostname()
+	if strings.Contains(host, "*") {
+		base, ok := strings.CutPrefix(host, "*.")
+		if !ok || base == "" || strings.Contains(base, "*") || !strings.Contains(base, ".") {
+			return errors.New("wildcards are only allowed as the first label of a domain with at least two other labels, such as https://*.example.com")
+		}
+	}
+
 	return nil
 }
@@ -2,6 +2,7 @@ package common
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -185,4 +186,158 @@ func TestParseEnvConfig(t *testing.T) {
 		assert.Equal(t, "8080", EnvConfig.Port)
 		assert.Equal(t, "127.0.0.1", EnvConfig.Host)
 	})
+
+	t.Run("should validate CORS_ALLOWED_ORIGINS", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("CORS_ALLOWED_ORIGINS", "https://App.example.com/,https://*.example.org")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"https://app.example.com", "https://*.example.org"}, EnvConfig.CorsAllowedOrigins)
+
+		for _, origin := range []string{"*", "https://*.org", "https://foo.*.example.com", "https://example.com/path", "ftp://example.com"} {
+			EnvConfig = defaultConfig()
+			t.Setenv("CORS_ALLOWED_ORIGINS", origin)
+			err = parseEnvConfig()
+			assert.ErrorContains(t, err, "CORS_ALLOWED_ORIGINS", origin)
+		}
+	})
+
+	t.Run("should use the MaxMind checksum URL for the MaxMind database only", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, maxMindGeoLiteCityChecksumUrl, EnvConfig.GeoLiteDBChecksumUrl)
+
+		EnvConfig = defaultConfig()
+		t.Setenv("GEOLITE_DB_URL", "https://mirror.example.com/geolite.tar.gz")
+		err = parseEnvConfig()
+		require.NoError(t, err)
+		assert.Empty(t, EnvConfig.GeoLiteDBChecksumUrl)
+	})
+
+	t.Run("should allow key rotation only for keys managed by Pocket ID", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("KEYS_ROTATION_INTERVAL", "720h")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, 720*time.Hour, EnvConfig.KeysRotationInterval)
+
+		EnvConfig = defaultConfig()
+		t.Setenv("KEYS_STORAGE", "pem")
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "KEYS_ROTATION_INTERVAL")
+	})
+
+	t.Run("should require the default page size to be within the maximum", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("PAGINATION_MAX_LIMIT", "500")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, 500, EnvConfig.PaginationMaxLimit)
+
+		EnvConfig = defaultConfig()
+		t.Setenv("PAGINATION_DEFAULT_LIMIT", "1000")
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "PAGINATION_DEFAULT_LIMIT")
+	})
+
+	t.Run("should validate the API key environment", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("API_KEY_ENVIRONMENT", "test")
+		t.Setenv("API_KEY_LEGACY_FORMAT_ALLOWED", "false")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, "test", EnvConfig.ApiKeyEnvironment)
+		assert.False(t, EnvConfig.ApiKeyLegacyFormatAllowed)
+
+		EnvConfig = defaultConfig()
+		t.Setenv("API_KEY_ENVIRONMENT", "staging")
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "API_KEY_ENVIRONMENT")
+	})
+
+	t.Run("should reject negative query timeouts", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("DB_QUERY_TIMEOUT", "-1s")
+
+		err := parseEnvConfig()
+		require.ErrorContains(t, err, "DB_QUERY_TIMEOUT")
+
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_QUERY_TIMEOUT", "1m")
+		t.Setenv("DB_QUERY_TIMEOUT_MAINTENANCE", "-1s")
+
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "DB_QUERY_TIMEOUT_MAINTENANCE")
+	})
+
+	t.Run("should require the bucket settings for S3 audit log archives", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("AUDIT_LOG_ARCHIVE_STORAGE", "s3")
+		t.Setenv("AUDIT_LOG_ARCHIVE_S3_REGION", "eu-central-1")
+
+		err := parseEnvConfig()
+		require.ErrorContains(t, err, "AUDIT_LOG_ARCHIVE_S3_BUCKET")
+
+		EnvConfig = defaultConfig()
+		t.Setenv("AUDIT_LOG_ARCHIVE_S3_BUCKET", "archive")
+		t.Setenv("AUDIT_LOG_ARCHIVE_S3_ACCESS_KEY_ID", "access")
+		t.Setenv("AUDIT_LOG_ARCHIVE_S3_SECRET_ACCESS_KEY", "secret")
+		err = parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, 90*24*time.Hour, EnvConfig.AuditLogArchiveAfter)
+	})
+
+	t.Run("should validate the upload storage", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("UPLOAD_STORAGE", "ftp")
+
+		err := parseEnvConfig()
+		require.ErrorContains(t, err, "UPLOAD_STORAGE")
+
+		EnvConfig = defaultConfig()
+		t.Setenv("UPLOAD_STORAGE", "s3")
+		t.Setenv("UPLOAD_S3_REGION", "eu-central-1")
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "UPLOAD_S3_BUCKET")
+	})
+
+	t.Run("should validate the federated JWKS fetch settings", func(t *testing.T) {
+		EnvConfig = defaultConfig()
+		t.Setenv("DB_PROVIDER", "sqlite")
+		t.Setenv("APP_URL", "http://localhost:3000")
+		t.Setenv("FEDERATED_JWKS_FETCH_TIMEOUT", "2s")
+		t.Setenv("FEDERATED_JWKS_FETCH_RETRIES", "0")
+
+		err := parseEnvConfig()
+		require.NoError(t, err)
+		assert.Equal(t, 2*time.Second, EnvConfig.FederatedJwksFetchTimeout)
+		assert.Zero(t, EnvConfig.FederatedJwksFetchRetries)
+
+		EnvConfig = defaultConfig()
+		t.Setenv("FEDERATED_JWKS_FETCH_TIMEOUT", "0s")
+		err = parseEnvConfig()
+		require.ErrorContains(t, err, "FEDERATED_JWKS_FETCH_TIMEOUT")
+	})
 }
@@ -4,6 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strings"
+	"time"
 )
 
 type AppError interface {
@@ -33,6 +36,11 @@ type SetupAlreadyCompletedError struct{}
 func (e *SetupAlreadyCompletedError) Error() string       { return "setup already completed" }
 func (e *SetupAlreadyCompletedError) HttpStatusCode() int { return 400 }
 
+type InvalidSetupSecretError struct{}
+
+func (e *InvalidSetupSecretError) Error() string       { return "setup secret is missing or invalid" }
+func (e *InvalidSetupSecretError) HttpStatusCode() int { return http.StatusForbidden }
+
 type TokenInvalidOrExpiredError struct{}
 
 func (e *TokenInvalidOrExpiredError) Error() string       { return "token is invalid or expired" }
@@ -65,11 +73,50 @@ type OidcClientSecretInvalidError struct{}
 func (e *OidcClientSecretInvalidError) Error() string       { return "invalid client secret" }
 func (e *OidcClientSecretInvalidError) HttpStatusCode() int { return 400 }
 
-type OidcClientAssertionInvalidError struct{}
+type OidcClientAssertionInvalidError struct {
+	// Optional reason, e.g. which claim doesn't match the federated identity
+	Reason string
+}
 
-func (e *OidcClientAssertionInvalidError) Error() string       { return "invalid client assertion" }
+func (e *OidcClientAssertionInvalidError) Error() string {
+	if e.Reason == "" {
+		return "invalid client assertion"
+	}
+	return "invalid client assertion: " + e.Reason
+}
 func (e *OidcClientAssertionInvalidError) HttpStatusCode() int { return 400 }
 
+// OidcFederatedIdentityAlreadyExistsError is returned if a client already has a federated identity with the same issuer, subject and audience
+type OidcFederatedIdentityAlreadyExistsError struct{}
+
+func (e *OidcFederatedIdentityAlreadyExistsError) Error() string {
+	return "The client already has a federated identity with this issuer, subject and audience"
+}
+func (e *OidcFederatedIdentityAlreadyExistsError) HttpStatusCode() int { return http.StatusConflict }
+
+// OidcFederatedIdentityNotFoundError is returned if a client has no federated identity with the given issuer, subject and audience
+type OidcFederatedIdentityNotFoundError struct{}
+
+func (e *OidcFederatedIdentityNotFoundError) Error() string {
+	return "The client has no federated identity with this issuer, subject and audience"
+}
+func (e *OidcFederatedIdentityNotFoundError) HttpStatusCode() int { return http.StatusNotFound }
+
+type OidcFederatedJwksUnavailableError struct {
+	Issuer string
+	URL    string
+	// Reason of the last failed attempt; it's not part of the message, as it may reveal details of the network
+	Err error
+}
+
+func (e *OidcFederatedJwksUnavailableError) Error() string {
+	return fmt.Sprintf("the JWK set of the federated identity provider '%s' could not be fetched, check that its JWKS URL is reachable and returns a valid JWK set", e.Issuer)
+}
+func (e *OidcFederatedJwksUnavailableError) Unwrap() error { return e.Err }
+func (e *OidcFederatedJwksUnavailableError) HttpStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
 type OidcInvalidAuthorizationCodeError struct{}
 
 func (e *OidcInvalidAuthorizationCodeError) Error() string       { return "invalid authorization code" }
@@ -94,6 +141,20 @@ type FileTypeNotSupportedError struct{}
 func (e *FileTypeNotSupportedError) Error() string       { return "file type not supported" }
 func (e *FileTypeNotSupportedError) HttpStatusCode() int { return 400 }
 
+type FileContentMismatchError struct{}
+
+func (e *FileContentMismatchError) Error() string {
+	return "The content of the file does not match its file type"
+}
+func (e *FileContentMismatchError) HttpStatusCode() int { return http.StatusBadRequest }
+
+type ImageNotFoundError struct{}
+
+func (e *ImageNotFoundError) Error() string {
+	return "Image not found"
+}
+func (e *ImageNotFoundError) HttpStatusCode() int { return http.StatusNotFound }
+
 type FileTooLargeError struct {
 	MaxSize string
 }
@@ -103,6 +164,24 @@ func (e *FileTooLargeError) Error() string {
 }
 func (e *FileTooLargeError) HttpStatusCode() int { return http.StatusRequestEntityTooLarge }
 
+type RequestBodyTooLargeError struct {
+	MaxSize string
+}
+
+func (e *RequestBodyTooLargeError) Error() string {
+	return fmt.Sprintf("The request body can't be larger than %s", e.MaxSize)
+}
+func (e *RequestBodyTooLargeError) HttpStatusCode() int { return http.StatusRequestEntityTooLarge }
+
+type JSONTooDeepError struct {
+	MaxDepth int
+}
+
+func (e *JSONTooDeepError) Error() string {
+	return fmt.Sprintf("The request body can't have more than %d levels of nesting", e.MaxDepth)
+}
+func (e *JSONTooDeepError) HttpStatusCode() int { return http.StatusRequestEntityTooLarge }
+
 type NotSignedInError struct{}
 
 func (e *NotSignedInError) Error() string       { return "You are not signed in" }
@@ -120,6 +199,15 @@ func (e *MissingPermissionError) Error() string {
 }
 func (e *MissingPermissionError) HttpStatusCode() int { return http.StatusForbidden }
 
+type OneTimeAccessLockedError struct {
+	RetryAt time.Time
+}
+
+func (e *OneTimeAccessLockedError) Error() string {
+	return fmt.Sprintf("Too many invalid login codes, please try again at %s", e.RetryAt.Format(time.RFC3339))
+}
+func (e *OneTimeAccessLockedError) HttpStatusCode() int { return http.StatusTooManyRequests }
+
 type TooManyRequestsError struct{}
 
 func (e *TooManyRequestsError) Error() string {
@@ -189,6 +277,57 @@ func (e *LdapUserUpdateError) Error() string {
 }
 func (e *LdapUserUpdateError) HttpStatusCode() int { return http.StatusForbidden }
 
+type LdapUnavailableError struct {
+	RetryAt time.Time
+}
+
+func (e *LdapUnavailableError) Error() string {
+	return fmt.Sprintf("LDAP is unavailable after repeated failures, it will be retried at %s", e.RetryAt.Format(time.RFC3339))
+}
+func (e *LdapUnavailableError) HttpStatusCode() int { return http.StatusServiceUnavailable }
+
+type LdapDisabledError struct{}
+
+func (e *LdapDisabledError) Error() string {
+	return "LDAP is not enabled"
+}
+func (e *LdapDisabledError) HttpStatusCode() int { return http.StatusBadRequest }
+
+type LdapDuplicateIdentifierError struct {
+	Attribute string
+	// DNs of the LDAP entries, by their shared unique identifier
+	Identifiers map[string][]string
+}
+
+func (e *LdapDuplicateIdentifierError) Error() string {
+	identifiers := make([]string, 0, len(e.Identifiers))
+	for identifier := range e.Identifiers {
+		identifiers = append(identifiers, identifier)
+	}
+	slices.Sort(identifiers)
+
+	conflicts := make([]string, len(identifiers))
+	for i, identifier := range identifiers {
+		conflicts[i] = fmt.Sprintf("%q is used by %s", identifier, strings.Join(e.Identifiers[identifier], ", "))
+	}
+	return fmt.Sprintf("LDAP sync aborted because multiple users share the same unique identifier in the attribute '%s': %s", e.Attribute, strings.Join(conflicts, "; "))
+}
+func (e *LdapDuplicateIdentifierError) HttpStatusCode() int { return http.StatusConflict }
+
+type AccountSelfDeletionDisabledError struct{}
+
+func (e *AccountSelfDeletionDisabledError) Error() string {
+	return "Deleting your own account is disabled"
+}
+func (e *AccountSelfDeletionDisabledError) HttpStatusCode() int { return http.StatusForbidden }
+
+type LastAdminDeletionError struct{}
+
+func (e *LastAdminDeletionError) Error() string {
+	return "The last admin can't be deleted"
+}
+func (e *LastAdminDeletionError) HttpStatusCode() int { return http.StatusBadRequest }
+
 type LdapUserGroupUpdateError struct{}
 
 func (e *LdapUserGroupUpdateError) Error() string {
@@ -203,6 +342,97 @@ func (e *OidcAccessDeniedError) Error() string {
 }
 func (e *OidcAccessDeniedError) HttpStatusCode() int { return http.StatusForbidden }
 
+// OidcEmailRequiredError is returned if a user without an email address tries to authorize a client that requires one
+type OidcEmailRequiredError struct{}
+
+func (e *OidcEmailRequiredError) Error() string {
+	return "This service requires an email address. Add one to your account, or ask an administrator to add it, then sign in again"
+}
+func (e *OidcEmailRequiredError) HttpStatusCode() int { return http.StatusForbidden }
+
+// OidcSilentAuthenticationError is returned if the client requested silent authentication with prompt=none, but the authorization requires user interaction.
+// Code is the error code defined by the OIDC spec, like "login_required" or "consent_required", which is sent to the callback URL of the client.
+type OidcSilentAuthenticationError struct {
+	Code string
+}
+
+func (e *OidcSilentAuthenticationError) Error() string {
+	return "silent authentication failed: " + e.Code
+}
+func (e *OidcSilentAuthenticationError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcReauthenticationRequiredError is returned if the client requested a more recent sign in with max_age than the one of the current session
+type OidcReauthenticationRequiredError struct{}
+
+func (e *OidcReauthenticationRequiredError) Error() string {
+	return "You have to sign in again to authorize this client"
+}
+func (e *OidcReauthenticationRequiredError) HttpStatusCode() int { return http.StatusUnauthorized }
+
+// OidcAcrValuesNotSupportedError is returned if none of the ACR values requested by the client can be satisfied
+type OidcAcrValuesNotSupportedError struct{}
+
+func (e *OidcAcrValuesNotSupportedError) Error() string {
+	return "None of the requested authentication context classes is supported"
+}
+func (e *OidcAcrValuesNotSupportedError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcInvalidRequestObjectError is returned if the request object of an authorization request can't be loaded or verified
+type OidcInvalidRequestObjectError struct {
+	Reason string
+}
+
+func (e *OidcInvalidRequestObjectError) Error() string {
+	return "The request object is invalid: " + e.Reason
+}
+func (e *OidcInvalidRequestObjectError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcRequestObjectRequiredError is returned if a client that requires signed request objects sent the authorization parameters without one
+type OidcRequestObjectRequiredError struct{}
+
+func (e *OidcRequestObjectRequiredError) Error() string {
+	return "This client requires the authorization parameters to be sent in a signed request object"
+}
+func (e *OidcRequestObjectRequiredError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcUnauthorizedClientError is returned if the client isn't allowed to use the requested grant type
+type OidcUnauthorizedClientError struct{}
+
+func (e *OidcUnauthorizedClientError) Error() string {
+	return "the client is not allowed to use this grant type"
+}
+func (e *OidcUnauthorizedClientError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcInvalidScopeError is returned if the client requested a scope it isn't allowed to use
+type OidcInvalidScopeError struct {
+	Scope string
+}
+
+func (e *OidcInvalidScopeError) Error() string {
+	return fmt.Sprintf("the scope '%s' is not allowed for this client", e.Scope)
+}
+func (e *OidcInvalidScopeError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcInvalidTargetError is returned if the client requested an audience or resource it isn't allowed to use
+type OidcInvalidTargetError struct {
+	Target string
+}
+
+func (e *OidcInvalidTargetError) Error() string {
+	return fmt.Sprintf("the audience '%s' is not allowed for this client", e.Target)
+}
+func (e *OidcInvalidTargetError) HttpStatusCode() int { return http.StatusBadRequest }
+
+// OidcInvalidTokenExchangeError is returned if a token exchange request can't be fulfilled, e.g. because the subject token is invalid
+type OidcInvalidTokenExchangeError struct {
+	Reason string
+}
+
+func (e *OidcInvalidTokenExchangeError) Error() string {
+	return "invalid token exchange request: " + e.Reason
+}
+func (e *OidcInvalidTokenExchangeError) HttpStatusCode() int { return http.StatusBadRequest }
+
 type OidcClientIdNotMatchingError struct{}
 
 func (e *OidcClientIdNotMatchingError) Error() string {
@@ -224,6 +454,13 @@ func (e *UiConfigDisabledError) Error() string {
 }
 func (e *UiConfigDisabledError) HttpStatusCode() int { return http.StatusForbidden }
 
+type UiConfigDisabledSecretsExportError struct{}
+
+func (e *UiConfigDisabledSecretsExportError) Error() string {
+	return "Sensitive values can't be exported since the UI configuration is disabled"
+}
+func (e *UiConfigDisabledSecretsExportError) HttpStatusCode() int { return http.StatusForbidden }
+
 type InvalidUUIDError struct{}
 
 func (e *InvalidUUIDError) Error() string {
@@ -231,6 +468,20 @@ func (e *InvalidUUIDError) Error() string {
 }
 func (e *InvalidUUIDError) HttpStatusCode() int { return http.StatusBadRequest }
 
+type EmailUnavailableError struct{}
+
+func (e *EmailUnavailableError) Error() string {
+	return "The email can't be sent at the moment, please try again later"
+}
+func (e *EmailUnavailableError) HttpStatusCode() int { return http.StatusServiceUnavailable }
+
+type NotificationMandatoryError struct{}
+
+func (e *NotificationMandatoryError) Error() string {
+	return "This notification is mandatory and can't be disabled"
+}
+func (e *NotificationMandatoryError) HttpStatusCode() int { return http.StatusBadRequest }
+
 type OneTimeAccessDisabledError struct{}
 
 func (e *OneTimeAccessDisabledError) Error() string {
@@ -266,6 +517,24 @@ func (e *APIKeyExpirationDateError) Error() string {
 }
 func (e *APIKeyExpirationDateError) HttpStatusCode() int { return http.StatusBadRequest }
 
+type APIKeyLimitReachedError struct {
+	Limit int
+}
+
+func (e *APIKeyLimitReachedError) Error() string {
+	return fmt.Sprintf("You can't have more than %d active API keys", e.Limit)
+}
+func (e *APIKeyLimitReachedError) HttpStatusCode() int { return http.StatusBadRequest }
+
+type PasskeyLimitReachedError struct {
+	Limit int
+}
+
+func (e *PasskeyLimitReachedError) Error() string {
+	return fmt.Sprintf("You can't have more than %d passkeys", e.Limit)
+}
+func (e *PasskeyLimitReachedError) HttpStatusCode() int { return http.StatusBadRequest }
+
 type OidcInvalidRefreshTokenError struct{}
 
 func (e *OidcInvalidRefreshTokenError) Error() string {
@@ -302,6 +571,44 @@ func (e *UserDisabledError) HttpStatusCode() int {
 	return http.StatusForbidden
 }
 
+type GeoblockedError struct {
+	// CountryCode of the location the user tried to sign in from, if it's known
+	CountryCode string
+}
+
+func (e *GeoblockedError) Error() string {
+	return "Signing in from your location is not allowed"
+}
+func (e *GeoblockedError) HttpStatusCode() int {
+	return http.StatusForbidden
+}
+
+type MaintenanceModeError struct {
+	Message string
+}
+
+func (e *MaintenanceModeError) Error() string {
+	if e.Message != "" {
+		return e.Message
+	}
+	return "This instance is under maintenance, only administrators can sign in"
+}
+func (e *MaintenanceModeError) HttpStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+type UnsupportedLocaleError struct {
+	Locale string
+}
+
+func (e *UnsupportedLocaleError) Error() string {
+	return fmt.Sprintf("Locale '%s' is not supported", e.Locale)
+}
+
+func (e *UnsupportedLocaleError) HttpStatusCode() int {
+	return http.StatusBadRequest
+}
+
 type ValidationError struct {
 	Message string
 }
@@ -359,3 +666,47 @@ func (e *OpenSignupDisabledError) Error() string {
 func (e *OpenSignupDisabledError) HttpStatusCode() int {
 	return http.StatusForbidden
 }
+
+type EmailDomainNotAllowedError struct {
+	Domain string
+}
+
+func (e *EmailDomainNotAllowedError) Error() string {
+	return fmt.Sprintf("Email addresses of the domain '%s' are not allowed", e.Domain)
+}
+
+func (e *EmailDomainNotAllowedError) HttpStatusCode() int {
+	return http.StatusBadRequest
+}
+
+type QueryTimeoutError struct{}
+
+func (e *QueryTimeoutError) Error() string {
+	return "The request took too long to complete, try again with a narrower search"
+}
+
+func (e *QueryTimeoutError) HttpStatusCode() int {
+	return http.StatusServiceUnavailable
+}
+
+type BackgroundJobTypeUnknownError struct {
+	Type string
+}
+
+func (e *BackgroundJobTypeUnknownError) Error() string {
+	return fmt.Sprintf("Unknown background job type '%s'", e.Type)
+}
+
+func (e *BackgroundJobTypeUnknownError) HttpStatusCode() int {
+	return http.StatusBadRequest
+}
+
+type BackgroundJobNotCancellableError struct{}
+
+func (e *BackgroundJobNotCancellableError) Error() string {
+	return "The background job has already finished or can't be canceled while it's running"
+}
+
+func (e *BackgroundJobNotCancellableError) HttpStatusCode() int {
+	return http.StatusConflict
+}
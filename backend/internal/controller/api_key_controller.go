@@ -30,6 +30,7 @@ func NewApiKeyController(group *gin.RouterGroup, authMiddleware *middleware.Auth
 	{
 		apiKeyGroup.GET("", uc.listApiKeysHandler)
 		apiKeyGroup.POST("", uc.createApiKeyHandler)
+		apiKeyGroup.POST("/:id/rotate", uc.rotateApiKeyHandler)
 		apiKeyGroup.DELETE("/:id", uc.revokeApiKeyHandler)
 	}
 }
@@ -105,6 +106,42 @@ func (c *ApiKeyController) createApiKeyHandler(ctx *gin.Context) {
 	})
 }
 
+// rotateApiKeyHandler godoc
+// @Summary Rotate API key
+// @Description Generate a new secret for an existing API key. The previous secret remains valid for the grace period.
+// @Tags API Keys
+// @Param id path string true "API Key ID"
+// @Param rotation body dto.ApiKeyRotateDto true "Rotation options"
+// @Success 200 {object} dto.ApiKeyResponseDto "Rotated API key with the new token"
+// @Router /api/api-keys/{id}/rotate [post]
+func (c *ApiKeyController) rotateApiKeyHandler(ctx *gin.Context) {
+	userID := ctx.GetString("userID")
+	apiKeyID := ctx.Param("id")
+
+	var input dto.ApiKeyRotateDto
+	if err := ctx.ShouldBindJSON(&input); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	apiKey, token, err := c.apiKeyService.RotateApiKey(ctx.Request.Context(), userID, apiKeyID, input, ctx.ClientIP(), ctx.Request.UserAgent())
+	if err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	var apiKeyDto dto.ApiKeyDto
+	if err := dto.MapStruct(apiKey, &apiKeyDto); err != nil {
+		_ = ctx.Error(err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, dto.ApiKeyResponseDto{
+		ApiKey: apiKeyDto,
+		Token:  token,
+	})
+}
+
 // revokeApiKeyHandler godoc
 // @Summary Revoke API key
 // @Description Revoke (delete) an existing API key by ID
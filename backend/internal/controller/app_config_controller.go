@@ -20,35 +20,45 @@ import (
 func NewAppConfigController(
 	group *gin.RouterGroup,
 	authMiddleware *middleware.AuthMiddleware,
+	fileSizeLimitMiddleware *middleware.FileSizeLimitMiddleware,
 	appConfigService *service.AppConfigService,
 	emailService *service.EmailService,
 	ldapService *service.LdapService,
+	auditLogService *service.AuditLogService,
 ) {
 
 	acc := &AppConfigController{
 		appConfigService: appConfigService,
 		emailService:     emailService,
 		ldapService:      ldapService,
+		auditLogService:  auditLogService,
 	}
 	group.GET("/application-configuration", acc.listAppConfigHandler)
 	group.GET("/application-configuration/all", authMiddleware.Add(), acc.listAllAppConfigHandler)
 	group.PUT("/application-configuration", authMiddleware.Add(), acc.updateAppConfigHandler)
+	group.GET("/application-configuration/export", authMiddleware.Add(), acc.exportAppConfigHandler)
+	group.POST("/application-configuration/import", authMiddleware.Add(), acc.importAppConfigHandler)
+	group.GET("/application-configuration/theme-presets", authMiddleware.Add(), acc.listThemePresetsHandler)
+	group.GET("/application-configuration/accent-color-contrast", acc.getAccentColorContrastHandler)
+	group.GET("/application-configuration/locales", acc.getLocalesHandler)
 
 	group.GET("/application-configuration/logo", acc.getLogoHandler)
 	group.GET("/application-configuration/background-image", acc.getBackgroundImageHandler)
 	group.GET("/application-configuration/favicon", acc.getFaviconHandler)
-	group.PUT("/application-configuration/logo", authMiddleware.Add(), acc.updateLogoHandler)
-	group.PUT("/application-configuration/favicon", authMiddleware.Add(), acc.updateFaviconHandler)
-	group.PUT("/application-configuration/background-image", authMiddleware.Add(), acc.updateBackgroundImageHandler)
+	group.PUT("/application-configuration/logo", authMiddleware.Add(), fileSizeLimitMiddleware.Add(10<<20), acc.updateLogoHandler)
+	group.PUT("/application-configuration/favicon", authMiddleware.Add(), fileSizeLimitMiddleware.Add(10<<20), acc.updateFaviconHandler)
+	group.PUT("/application-configuration/background-image", authMiddleware.Add(), fileSizeLimitMiddleware.Add(10<<20), acc.updateBackgroundImageHandler)
 
 	group.POST("/application-configuration/test-email", authMiddleware.Add(), acc.testEmailHandler)
 	group.POST("/application-configuration/sync-ldap", authMiddleware.Add(), acc.syncLdapHandler)
+	group.POST("/application-configuration/sync-ldap/trigger", authMiddleware.Add(), acc.triggerLdapSyncHandler)
 }
 
 type AppConfigController struct {
 	appConfigService *service.AppConfigService
 	emailService     *service.EmailService
 	ldapService      *service.LdapService
+	auditLogService  *service.AuditLogService
 }
 
 // listAppConfigHandler godoc
@@ -114,12 +124,14 @@ func (acc *AppConfigController) updateAppConfigHandler(c *gin.Context) {
 		return
 	}
 
-	savedConfigVariables, err := acc.appConfigService.UpdateAppConfig(c.Request.Context(), input)
+	savedConfigVariables, changes, err := acc.appConfigService.UpdateAppConfig(c.Request.Context(), input)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
+	acc.auditLogService.CreateConfigChanged(c.Request.Context(), c.ClientIP(), c.Request.UserAgent(), c.GetString("userID"), changes)
+
 	var configVariablesDto []dto.AppConfigVariableDto
 	if err := dto.MapStructList(savedConfigVariables, &configVariablesDto); err != nil {
 		_ = c.Error(err)
@@ -129,6 +141,104 @@ func (acc *AppConfigController) updateAppConfigHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, configVariablesDto)
 }
 
+// exportAppConfigHandler godoc
+// @Summary Export the application configuration
+// @Description Download all application configuration values as a versioned JSON document that can be imported on another instance.
+// @Description Sensitive values, like passwords, are left out unless "includeSecrets" is true, which isn't allowed if the UI configuration is disabled.
+// @Tags Application Configuration
+// @Param includeSecrets query bool false "Include the sensitive values"
+// @Produce json
+// @Success 200 {object} dto.AppConfigExportDto
+// @Router /api/application-configuration/export [get]
+func (acc *AppConfigController) exportAppConfigHandler(c *gin.Context) {
+	export, err := acc.appConfigService.Export(c.Query("includeSecrets") == "true")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="pocket-id-config.json"`)
+	c.JSON(http.StatusOK, export)
+}
+
+// importAppConfigHandler godoc
+// @Summary Import the application configuration
+// @Description Replace the application configuration with the values of an exported document. Values missing from the document keep their current value.
+// @Tags Application Configuration
+// @Accept json
+// @Produce json
+// @Param body body dto.AppConfigExportDto true "Exported configuration"
+// @Success 200 {object} dto.AppConfigImportResultDto
+// @Router /api/application-configuration/import [post]
+func (acc *AppConfigController) importAppConfigHandler(c *gin.Context) {
+	var input dto.AppConfigExportDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := acc.appConfigService.Import(c.Request.Context(), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	acc.auditLogService.CreateConfigChanged(c.Request.Context(), c.ClientIP(), c.Request.UserAgent(), c.GetString("userID"), result.Changes)
+
+	resultDto := dto.AppConfigImportResultDto{RedactedKeys: result.RedactedKeys}
+	if err := dto.MapStructList(result.Config, &resultDto.Config); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
+
+// listThemePresetsHandler godoc
+// @Summary List theme presets
+// @Description Get the theme presets that can be applied with the themePreset input when updating the configuration
+// @Tags Application Configuration
+// @Produce json
+// @Success 200 {array} dto.ThemePresetDto
+// @Router /api/application-configuration/theme-presets [get]
+func (acc *AppConfigController) listThemePresetsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, service.ThemePresets)
+}
+
+// getAccentColorContrastHandler godoc
+// @Summary Get accent color contrast
+// @Description Get the WCAG contrast information of an accent color, so that the UI can warn about low-contrast choices
+// @Tags Application Configuration
+// @Produce json
+// @Param color query string false "Accent color to check, defaults to the configured one"
+// @Success 200 {object} dto.AccentColorContrastDto
+// @Router /api/application-configuration/accent-color-contrast [get]
+func (acc *AppConfigController) getAccentColorContrastHandler(c *gin.Context) {
+	contrast, err := acc.appConfigService.GetAccentColorContrast(c.Query("color"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, contrast)
+}
+
+// getLocalesHandler godoc
+// @Summary Get supported locales
+// @Description Get the locales users can select and the default locale, which is used for users who haven't selected one
+// @Tags Application Configuration
+// @Produce json
+// @Success 200 {object} dto.LocalesDto
+// @Router /api/application-configuration/locales [get]
+func (acc *AppConfigController) getLocalesHandler(c *gin.Context) {
+	dbConfig := acc.appConfigService.GetDbConfig()
+
+	c.JSON(http.StatusOK, dto.LocalesDto{
+		DefaultLocale:    dbConfig.DefaultLocale.Value,
+		SupportedLocales: dbConfig.SupportedLocaleList(),
+	})
+}
+
 // getLogoHandler godoc
 // @Summary Get logo image
 // @Description Get the logo image for the application
@@ -277,7 +387,7 @@ func (acc *AppConfigController) updateImage(c *gin.Context, imageName string, ol
 // @Success 204 "No Content"
 // @Router /api/application-configuration/sync-ldap [post]
 func (acc *AppConfigController) syncLdapHandler(c *gin.Context) {
-	err := acc.ldapService.SyncAll(c.Request.Context())
+	_, err := acc.ldapService.SyncAll(c.Request.Context())
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -286,6 +396,28 @@ func (acc *AppConfigController) syncLdapHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// triggerLdapSyncHandler godoc
+// @Summary Trigger LDAP synchronization
+// @Description Synchronize LDAP right away, e.g. from a webhook after users were provisioned. Triggers received while a synchronization is running are combined into a single one that runs afterwards.
+// @Tags Application Configuration
+// @Success 200 {object} dto.LdapSyncResultDto
+// @Router /api/application-configuration/sync-ldap/trigger [post]
+func (acc *AppConfigController) triggerLdapSyncHandler(c *gin.Context) {
+	result, err := acc.ldapService.TriggerSync(c.Request.Context(), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var resultDto dto.LdapSyncResultDto
+	if err := dto.MapStruct(result, &resultDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
+
 // testEmailHandler godoc
 // @Summary Send test email
 // @Description Send a test email to verify email configuration
@@ -24,6 +24,7 @@ func NewAuditLogController(group *gin.RouterGroup, auditLogService *service.Audi
 	group.GET("/audit-logs", authMiddleware.WithAdminNotRequired().Add(), alc.listAuditLogsForUserHandler)
 	group.GET("/audit-logs/filters/client-names", authMiddleware.Add(), alc.listClientNamesHandler)
 	group.GET("/audit-logs/filters/users", authMiddleware.Add(), alc.listUserNamesWithIdsHandler)
+	group.GET("/audit-logs/filters/countries", authMiddleware.Add(), alc.listCountriesHandler)
 }
 
 type AuditLogController struct {
@@ -90,6 +91,7 @@ func (alc *AuditLogController) listAuditLogsForUserHandler(c *gin.Context) {
 // @Param filters[event] query string false "Filter by event type"
 // @Param filters[clientName] query string false "Filter by client name"
 // @Param filters[location] query string false "Filter by location type (external or internal)"
+// @Param filters[country] query string false "Filter by country"
 // @Success 200 {object} dto.Paginated[dto.AuditLogDto]
 // @Router /api/audit-logs/all [get]
 func (alc *AuditLogController) listAllAuditLogsHandler(c *gin.Context) {
@@ -161,3 +163,19 @@ func (alc *AuditLogController) listUserNamesWithIdsHandler(c *gin.Context) {
 
 	c.JSON(http.StatusOK, users)
 }
+
+// listCountriesHandler godoc
+// @Summary List countries
+// @Description Get a list of all countries the audit log entries come from, for audit log filtering
+// @Tags Audit Logs
+// @Success 200 {array} string "List of countries"
+// @Router /api/audit-logs/filters/countries [get]
+func (alc *AuditLogController) listCountriesHandler(c *gin.Context) {
+	countries, err := alc.auditLogService.ListCountries(c.Request.Context())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, countries)
+}
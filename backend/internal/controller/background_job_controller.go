@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// NewBackgroundJobController creates a new controller for long-running background jobs
+// @Summary Background job controller
+// @Description Initializes the endpoints to submit background jobs, poll their status, and cancel them
+// @Tags Background Jobs
+func NewBackgroundJobController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, backgroundJobService *service.BackgroundJobService) {
+	bjc := &BackgroundJobController{backgroundJobService: backgroundJobService}
+
+	group.GET("/background-jobs", authMiddleware.Add(), bjc.listHandler)
+	group.POST("/background-jobs", authMiddleware.Add(), bjc.submitHandler)
+	group.GET("/background-jobs/:id", authMiddleware.Add(), bjc.getHandler)
+	group.POST("/background-jobs/:id/cancel", authMiddleware.Add(), bjc.cancelHandler)
+}
+
+type BackgroundJobController struct {
+	backgroundJobService *service.BackgroundJobService
+}
+
+// listHandler godoc
+// @Summary List background jobs
+// @Description Get a paginated list of the background jobs, without their results
+// @Tags Background Jobs
+// @Param pagination[page] query int false "Page number for pagination" default(1)
+// @Param pagination[limit] query int false "Number of items per page" default(20)
+// @Param sort[column] query string false "Column to sort by"
+// @Param sort[direction] query string false "Sort direction (asc or desc)" default("asc")
+// @Success 200 {object} dto.Paginated[dto.BackgroundJobDto]
+// @Router /api/background-jobs [get]
+func (bjc *BackgroundJobController) listHandler(c *gin.Context) {
+	var sortedPaginationRequest utils.SortedPaginationRequest
+	if err := c.ShouldBindQuery(&sortedPaginationRequest); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	jobs, pagination, err := bjc.backgroundJobService.List(c.Request.Context(), sortedPaginationRequest)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	jobsDto := make([]dto.BackgroundJobDto, len(jobs))
+	for i, job := range jobs {
+		jobsDto[i], err = bjc.toDto(job)
+		if err != nil {
+			_ = c.Error(err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, dto.Paginated[dto.BackgroundJobDto]{
+		Data:       jobsDto,
+		Pagination: pagination,
+	})
+}
+
+// submitHandler godoc
+// @Summary Submit background job
+// @Description Queue a long-running job; its status can be polled with the returned ID. Available types are "ldapSync", "pregenerateProfilePictures" and "archiveAuditLogs".
+// @Tags Background Jobs
+// @Accept json
+// @Produce json
+// @Param body body dto.BackgroundJobSubmitDto true "Job to submit"
+// @Success 202 {object} dto.BackgroundJobDto
+// @Router /api/background-jobs [post]
+func (bjc *BackgroundJobController) submitHandler(c *gin.Context) {
+	var input dto.BackgroundJobSubmitDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	job, err := bjc.backgroundJobService.Submit(c.Request.Context(), input.Type, input.Payload, c.GetString("userID"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	jobDto, err := bjc.toDto(job)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, jobDto)
+}
+
+// getHandler godoc
+// @Summary Get background job
+// @Description Get the status and progress of a background job, and its result once it has succeeded
+// @Tags Background Jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} dto.BackgroundJobDto
+// @Router /api/background-jobs/{id} [get]
+func (bjc *BackgroundJobController) getHandler(c *gin.Context) {
+	job, err := bjc.backgroundJobService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	jobDto, err := bjc.toDto(job)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobDto)
+}
+
+// cancelHandler godoc
+// @Summary Cancel background job
+// @Description Cancel a queued job, or stop a running job if its type can be canceled
+// @Tags Background Jobs
+// @Produce json
+// @Param id path string true "Job ID"
+// @Success 200 {object} dto.BackgroundJobDto
+// @Router /api/background-jobs/{id}/cancel [post]
+func (bjc *BackgroundJobController) cancelHandler(c *gin.Context) {
+	job, err := bjc.backgroundJobService.Cancel(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	jobDto, err := bjc.toDto(job)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, jobDto)
+}
+
+func (bjc *BackgroundJobController) toDto(job model.BackgroundJob) (dto.BackgroundJobDto, error) {
+	var jobDto dto.BackgroundJobDto
+	if err := dto.MapStruct(job, &jobDto); err != nil {
+		return dto.BackgroundJobDto{}, err
+	}
+
+	jobDto.Cancellable = bjc.backgroundJobService.IsCancellable(job)
+	if job.Result != nil {
+		jobDto.Result = json.RawMessage(*job.Result)
+	}
+
+	return jobDto, nil
+}
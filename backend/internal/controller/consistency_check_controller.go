@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+// NewConsistencyCheckController creates a new controller for the consistency check
+// @Summary Consistency check controller
+// @Description Initializes the endpoints to find and delete orphaned data
+// @Tags Consistency Check
+func NewConsistencyCheckController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, consistencyCheckService *service.ConsistencyCheckService) {
+	ccc := &ConsistencyCheckController{consistencyCheckService: consistencyCheckService}
+
+	group.GET("/consistency-check", authMiddleware.Add(), ccc.checkHandler)
+	group.POST("/consistency-check/cleanup", authMiddleware.Add(), ccc.cleanupHandler)
+}
+
+type ConsistencyCheckController struct {
+	consistencyCheckService *service.ConsistencyCheckService
+}
+
+// checkHandler godoc
+// @Summary Check for orphaned data
+// @Description Count the orphaned data per category without deleting it
+// @Tags Consistency Check
+// @Produce json
+// @Success 200 {object} dto.ConsistencyCheckReportDto
+// @Router /api/consistency-check [get]
+func (ccc *ConsistencyCheckController) checkHandler(c *gin.Context) {
+	report, err := ccc.consistencyCheckService.Check(c.Request.Context(), false)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
+
+// cleanupHandler godoc
+// @Summary Delete orphaned data
+// @Description Count the orphaned data per category, then delete it
+// @Tags Consistency Check
+// @Produce json
+// @Success 200 {object} dto.ConsistencyCheckReportDto
+// @Router /api/consistency-check/cleanup [post]
+func (ccc *ConsistencyCheckController) cleanupHandler(c *gin.Context) {
+	report, err := ccc.consistencyCheckService.Check(c.Request.Context(), true)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+// NewEmailController creates a new controller for the email notifications
+// @Summary Email controller
+// @Description Initializes the endpoints to manage which notification emails users receive
+// @Tags Email
+func NewEmailController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, rateLimitMiddleware *middleware.RateLimitMiddleware, emailService *service.EmailService) {
+	ec := &EmailController{emailService: emailService}
+
+	group.POST("/email/unsubscribe", rateLimitMiddleware.Add(rate.Every(10*time.Second), 10), ec.unsubscribeHandler)
+	group.GET("/users/me/notification-preferences", authMiddleware.WithAdminNotRequired().Add(), ec.getNotificationPreferencesHandler)
+	group.PUT("/users/me/notification-preferences", authMiddleware.WithAdminNotRequired().Add(), ec.updateNotificationPreferencesHandler)
+}
+
+type EmailController struct {
+	emailService *service.EmailService
+}
+
+// unsubscribeHandler godoc
+// @Summary Unsubscribe from a notification email
+// @Description One-click unsubscribe endpoint (RFC 8058) of the List-Unsubscribe header of notification emails
+// @Tags Email
+// @Param token query string true "Unsubscribe token of the email"
+// @Success 204 "No Content"
+// @Router /api/email/unsubscribe [post]
+func (ec *EmailController) unsubscribeHandler(c *gin.Context) {
+	err := ec.emailService.Unsubscribe(c.Request.Context(), c.Query("token"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// getNotificationPreferencesHandler godoc
+// @Summary Get notification preferences
+// @Description Get which notification emails the current user receives
+// @Tags Email
+// @Produce json
+// @Success 200 {array} dto.NotificationPreferenceDto
+// @Router /api/users/me/notification-preferences [get]
+func (ec *EmailController) getNotificationPreferencesHandler(c *gin.Context) {
+	preferences, err := ec.emailService.GetNotificationPreferences(c.Request.Context(), c.GetString("userID"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
+
+// updateNotificationPreferencesHandler godoc
+// @Summary Update notification preferences
+// @Description Update which notification emails the current user receives
+// @Tags Email
+// @Accept json
+// @Produce json
+// @Param preferences body dto.NotificationPreferencesUpdateDto true "Notification preferences"
+// @Success 200 {array} dto.NotificationPreferenceDto
+// @Router /api/users/me/notification-preferences [put]
+func (ec *EmailController) updateNotificationPreferencesHandler(c *gin.Context) {
+	var input dto.NotificationPreferencesUpdateDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	preferences, err := ec.emailService.UpdateNotificationPreferences(c.Request.Context(), c.GetString("userID"), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, preferences)
+}
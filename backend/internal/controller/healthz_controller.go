@@ -2,28 +2,120 @@ package controller
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/job"
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
 )
 
 // NewHealthzController creates a new controller for the healthcheck endpoints
 // @Summary Healthcheck controller
 // @Description Initializes healthcheck endpoints
 // @Tags Health
-func NewHealthzController(r *gin.Engine) {
-	hc := &HealthzController{}
+func NewHealthzController(r *gin.Engine, authMiddleware *middleware.AuthMiddleware, leaderElector *job.LeaderElector, jwtService *service.JwtService, ldapService *service.LdapService, appConfigService *service.AppConfigService) {
+	hc := &HealthzController{leaderElector: leaderElector, jwtService: jwtService, ldapService: ldapService, appConfigService: appConfigService}
 
-	r.GET("/healthz", hc.healthzHandler)
+	r.GET("/healthz", detailsAuth(authMiddleware.Add()), hc.healthzHandler)
+	r.GET("/healthz/ready", hc.readinessHandler)
 }
 
-type HealthzController struct{}
+type HealthzController struct {
+	leaderElector    *job.LeaderElector
+	jwtService       *service.JwtService
+	ldapService      *service.LdapService
+	appConfigService *service.AppConfigService
+}
 
 // healthzHandler godoc
 // @Summary Responds to healthchecks
-// @Description Responds with a successful status code to healthcheck requests
+// @Description Responds with a successful status code to healthcheck requests.
+// @Description If the "details" query parameter is set, the response contains the replica that holds the leadership for scheduled jobs, the age of the signing key, the generation of the application configuration, and the state of the connection to LDAP.
+// @Description The details are only returned to admins, who can authenticate with an API key.
 // @Tags Health
+// @Param details query bool false "Include details about the replica"
 // @Success 204 ""
+// @Success 200 {object} dto.HealthzDetailsDto
 // @Router /healthz [get]
 func (hc *HealthzController) healthzHandler(c *gin.Context) {
+	if c.Query("details") != "true" || hc.leaderElector == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	leader, err := hc.leaderElector.CurrentLeader(c.Request.Context())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.HealthzDetailsDto{
+		ReplicaID:        hc.leaderElector.ReplicaID(),
+		IsLeader:         hc.leaderElector.IsLeader(c.Request.Context()) == nil,
+		LeaderReplicaID:  leader,
+		SigningKey:       hc.signingKeyStatus(),
+		ConfigGeneration: hc.appConfigService.ConfigGeneration(),
+		Ldap:             hc.ldapStatus(),
+	})
+}
+
+// detailsAuth requires authentication only if the details are requested, so healthchecks don't need credentials
+func detailsAuth(auth gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Query("details") != "true" {
+			c.Next()
+			return
+		}
+		auth(c)
+	}
+}
+
+// readinessHandler godoc
+// @Summary Responds to readiness checks
+// @Description Responds with a successful status code if the instance accepts sign ins, and with 503 if it's in maintenance mode.
+// @Description Unlike /healthz, which is a liveness check, load balancers can use it to stop sending traffic to the instance during maintenance.
+// @Tags Health
+// @Success 204 ""
+// @Failure 503 ""
+// @Router /healthz/ready [get]
+func (hc *HealthzController) readinessHandler(c *gin.Context) {
+	if hc.appConfigService.MaintenanceModeEnabled() {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
 	c.Status(http.StatusNoContent)
 }
+
+func (hc *HealthzController) ldapStatus() *dto.LdapStatusDto {
+	if !hc.appConfigService.GetDbConfig().LdapEnabled.IsTrue() {
+		return nil
+	}
+
+	status := hc.ldapService.CircuitBreakerStatus()
+	return &dto.LdapStatusDto{
+		CircuitBreakerState: string(status.State),
+		ConsecutiveFailures: status.ConsecutiveFailures,
+		RetryAt:             status.RetryAt,
+	}
+}
+
+func (hc *HealthzController) signingKeyStatus() dto.SigningKeyStatusDto {
+	status := hc.jwtService.SigningKeyStatus()
+
+	res := dto.SigningKeyStatusDto{
+		KeyID:          status.KeyID,
+		NextRotationAt: status.NextRotationAt,
+	}
+	// The creation time is known only for keys managed by Pocket ID
+	if !status.CreatedAt.IsZero() {
+		createdAt := status.CreatedAt
+		age := int64(time.Since(createdAt).Seconds())
+		res.CreatedAt = &createdAt
+		res.AgeSeconds = &age
+	}
+
+	return res
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// NewImportReportController creates a new controller for the reports of bulk imports
+// @Summary Import report controller
+// @Description Initializes the endpoints to list and download the reports of bulk imports
+// @Tags Import Reports
+func NewImportReportController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, importReportService *service.ImportReportService) {
+	irc := &ImportReportController{importReportService: importReportService}
+
+	group.GET("/import-reports", authMiddleware.Add(), irc.listHandler)
+	group.GET("/import-reports/:id", authMiddleware.Add(), irc.downloadHandler)
+}
+
+type ImportReportController struct {
+	importReportService *service.ImportReportService
+}
+
+// listHandler godoc
+// @Summary List import reports
+// @Description Get a paginated list of the reports of bulk imports that haven't expired yet
+// @Tags Import Reports
+// @Param pagination[page] query int false "Page number for pagination" default(1)
+// @Param pagination[limit] query int false "Number of items per page" default(20)
+// @Param sort[column] query string false "Column to sort by"
+// @Param sort[direction] query string false "Sort direction (asc or desc)" default("asc")
+// @Success 200 {object} dto.Paginated[dto.ImportReportDto]
+// @Router /api/import-reports [get]
+func (irc *ImportReportController) listHandler(c *gin.Context) {
+	var sortedPaginationRequest utils.SortedPaginationRequest
+	if err := c.ShouldBindQuery(&sortedPaginationRequest); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	reports, pagination, err := irc.importReportService.List(c.Request.Context(), sortedPaginationRequest)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var reportsDto []dto.ImportReportDto
+	if err := dto.MapStructList(reports, &reportsDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.Paginated[dto.ImportReportDto]{
+		Data:       reportsDto,
+		Pagination: pagination,
+	})
+}
+
+// downloadHandler godoc
+// @Summary Download import report
+// @Description Download the report of a bulk import, with the outcome of every row
+// @Tags Import Reports
+// @Produce json
+// @Param id path string true "Report ID"
+// @Success 200 {object} dto.ImportReportDownloadDto
+// @Router /api/import-reports/{id} [get]
+func (irc *ImportReportController) downloadHandler(c *gin.Context) {
+	report, err := irc.importReportService.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var reportDto dto.ImportReportDownloadDto
+	if err := dto.MapStruct(report, &reportDto.ImportReportDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	reportDto.Entries = json.RawMessage(report.Entries)
+
+	c.Header("Content-Disposition", `attachment; filename="import-report-`+report.ID+`.json"`)
+	c.JSON(http.StatusOK, reportDto)
+}
@@ -25,7 +25,8 @@ import (
 func NewOidcController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, fileSizeLimitMiddleware *middleware.FileSizeLimitMiddleware, oidcService *service.OidcService, jwtService *service.JwtService) {
 	oc := &OidcController{oidcService: oidcService, jwtService: jwtService}
 
-	group.POST("/oidc/authorize", authMiddleware.WithAdminNotRequired().Add(), oc.authorizeHandler)
+	// Authentication is optional so that silent authentication requests of signed out users can be answered with an error for the client
+	group.POST("/oidc/authorize", authMiddleware.WithAdminNotRequired().WithSuccessOptional().Add(), oc.authorizeHandler)
 	group.POST("/oidc/authorization-required", authMiddleware.WithAdminNotRequired().Add(), oc.authorizationConfirmationRequiredHandler)
 
 	group.POST("/oidc/token", oc.createTokensHandler)
@@ -34,19 +35,25 @@ func NewOidcController(group *gin.RouterGroup, authMiddleware *middleware.AuthMi
 	group.POST("/oidc/end-session", authMiddleware.WithAdminNotRequired().WithSuccessOptional().Add(), oc.EndSessionHandler)
 	group.GET("/oidc/end-session", authMiddleware.WithAdminNotRequired().WithSuccessOptional().Add(), oc.EndSessionHandler)
 	group.POST("/oidc/introspect", oc.introspectTokenHandler)
+	group.GET("/oidc/login-branding", oc.getLoginBrandingHandler)
 
 	group.GET("/oidc/clients", authMiddleware.Add(), oc.listClientsHandler)
 	group.POST("/oidc/clients", authMiddleware.Add(), oc.createClientHandler)
+	group.POST("/oidc/clients/validate", authMiddleware.Add(), oc.validateClientHandler)
 	group.GET("/oidc/clients/:id", authMiddleware.Add(), oc.getClientHandler)
 	group.GET("/oidc/clients/:id/meta", oc.getClientMetaDataHandler)
 	group.PUT("/oidc/clients/:id", authMiddleware.Add(), oc.updateClientHandler)
 	group.DELETE("/oidc/clients/:id", authMiddleware.Add(), oc.deleteClientHandler)
 
 	group.PUT("/oidc/clients/:id/allowed-user-groups", authMiddleware.Add(), oc.updateAllowedUserGroupsHandler)
+	group.POST("/oidc/clients/:id/federated-identities", authMiddleware.Add(), oc.addFederatedIdentityHandler)
+	group.DELETE("/oidc/clients/:id/federated-identities", authMiddleware.Add(), oc.removeFederatedIdentityHandler)
 	group.POST("/oidc/clients/:id/secret", authMiddleware.Add(), oc.createClientSecretHandler)
+	group.POST("/oidc/clients/:id/clone", authMiddleware.Add(), oc.cloneClientHandler)
+	group.POST("/oidc/clients/:id/revoke-tokens", authMiddleware.Add(), oc.revokeClientTokensHandler)
 
 	group.GET("/oidc/clients/:id/logo", oc.getClientLogoHandler)
-	group.DELETE("/oidc/clients/:id/logo", oc.deleteClientLogoHandler)
+	group.DELETE("/oidc/clients/:id/logo", authMiddleware.Add(), oc.deleteClientLogoHandler)
 	group.POST("/oidc/clients/:id/logo", authMiddleware.Add(), fileSizeLimitMiddleware.Add(2<<20), oc.updateClientLogoHandler)
 
 	group.GET("/oidc/clients/:id/preview/:userId", authMiddleware.Add(), oc.getClientPreviewHandler)
@@ -57,6 +64,8 @@ func NewOidcController(group *gin.RouterGroup, authMiddleware *middleware.AuthMi
 
 	group.GET("/oidc/users/me/clients", authMiddleware.WithAdminNotRequired().Add(), oc.listOwnAuthorizedClientsHandler)
 	group.GET("/oidc/users/:id/clients", authMiddleware.Add(), oc.listAuthorizedClientsHandler)
+	group.GET("/oidc/users/:id/sessions", authMiddleware.Add(), oc.listSessionsHandler)
+	group.DELETE("/oidc/users/:id/sessions/:sessionId", authMiddleware.Add(), oc.revokeSessionHandler)
 }
 
 type OidcController struct {
@@ -80,7 +89,39 @@ func (oc *OidcController) authorizeHandler(c *gin.Context) {
 		return
 	}
 
-	code, callbackURL, err := oc.oidcService.Authorize(c.Request.Context(), input, c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	code, callbackURL, err := oc.oidcService.Authorize(c.Request.Context(), input, c.GetString("userID"), c.GetTime("authTime"), c.GetStringSlice("authMethods"), c.ClientIP(), c.Request.UserAgent())
+
+	// If silent authentication failed, the error is sent to the client through the callback URL
+	var silentAuthErr *common.OidcSilentAuthenticationError
+	if errors.As(err, &silentAuthErr) && callbackURL != "" {
+		c.JSON(http.StatusOK, dto.AuthorizeOidcClientResponseDto{
+			CallbackURL: callbackURL,
+			Issuer:      common.EnvConfig.AppURL,
+			Error:       silentAuthErr.Code,
+		})
+		return
+	}
+
+	// Requested resources that aren't allowed are reported to the client through the callback URL (RFC 8707, section 2)
+	var targetErr *common.OidcInvalidTargetError
+	if errors.As(err, &targetErr) && callbackURL != "" {
+		c.JSON(http.StatusOK, dto.AuthorizeOidcClientResponseDto{
+			CallbackURL: callbackURL,
+			Issuer:      common.EnvConfig.AppURL,
+			Error:       "invalid_target",
+		})
+		return
+	}
+
+	// The frontend asks the user to sign in again and retries the authorization
+	var reauthErr *common.OidcReauthenticationRequiredError
+	if errors.As(err, &reauthErr) {
+		c.JSON(http.StatusOK, dto.AuthorizeOidcClientResponseDto{
+			ReauthenticationRequired: true,
+		})
+		return
+	}
+
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -128,11 +169,12 @@ func (oc *OidcController) authorizationConfirmationRequiredHandler(c *gin.Contex
 // @Param client_id formData string false "Client ID (if not using Basic Auth)"
 // @Param client_secret formData string false "Client secret (if not using Basic Auth or client assertions)"
 // @Param code formData string false "Authorization code (required for 'authorization_code' grant)"
-// @Param grant_type formData string true "Grant type ('authorization_code' or 'refresh_token')"
+// @Param grant_type formData string true "Grant type ('authorization_code', 'refresh_token', 'client_credentials' or 'urn:ietf:params:oauth:grant-type:device_code')"
 // @Param code_verifier formData string false "PKCE code verifier (for authorization_code with PKCE)"
 // @Param refresh_token formData string false "Refresh token (required for 'refresh_token' grant)"
 // @Param client_assertion formData string false "Client assertion type (for 'authorization_code' grant when using client assertions)"
 // @Param client_assertion_type formData string false "Client assertion type (for 'authorization_code' grant when using client assertions)"
+// @Param scope formData string false "Space-separated scopes (for 'client_credentials' grant; defaults to all the scopes of the client)"
 // @Success 200 {object} dto.OidcTokenResponseDto "Token response with access_token and optional id_token and refresh_token"
 // @Router /api/oidc/token [post]
 func (oc *OidcController) createTokensHandler(c *gin.Context) {
@@ -159,7 +201,7 @@ func (oc *OidcController) createTokensHandler(c *gin.Context) {
 		input.ClientID, input.ClientSecret, _ = c.Request.BasicAuth()
 	}
 
-	tokens, err := oc.oidcService.CreateTokens(c.Request.Context(), input)
+	tokens, err := oc.oidcService.CreateTokens(c.Request.Context(), input, c.ClientIP(), c.Request.UserAgent())
 
 	switch {
 	case errors.Is(err, &common.OidcAuthorizationPendingError{}):
@@ -172,17 +214,42 @@ func (oc *OidcController) createTokensHandler(c *gin.Context) {
 			"error": "slow_down",
 		})
 		return
+	case errors.Is(err, &common.OidcUnauthorizedClientError{}):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "unauthorized_client",
+		})
+		return
+	case errors.As(err, new(*common.OidcInvalidScopeError)):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_scope",
+			"error_description": err.Error(),
+		})
+		return
+	case errors.As(err, new(*common.OidcInvalidTargetError)):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_target",
+			"error_description": err.Error(),
+		})
+		return
+	case errors.As(err, new(*common.OidcInvalidTokenExchangeError)):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":             "invalid_request",
+			"error_description": err.Error(),
+		})
+		return
 	case err != nil:
 		_ = c.Error(err)
 		return
 	}
 
 	c.JSON(http.StatusOK, dto.OidcTokenResponseDto{
-		AccessToken:  tokens.AccessToken,
-		TokenType:    "Bearer",
-		ExpiresIn:    int(tokens.ExpiresIn.Seconds()),
-		IdToken:      tokens.IdToken,      // May be empty
-		RefreshToken: tokens.RefreshToken, // May be empty
+		AccessToken:     tokens.AccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(tokens.ExpiresIn.Seconds()),
+		IdToken:         tokens.IdToken,         // May be empty
+		RefreshToken:    tokens.RefreshToken,    // May be empty
+		Scope:           tokens.Scope,           // May be empty
+		IssuedTokenType: tokens.IssuedTokenType, // May be empty
 	})
 }
 
@@ -202,7 +269,7 @@ func (oc *OidcController) userInfoHandler(c *gin.Context) {
 		return
 	}
 
-	token, err := oc.jwtService.VerifyOAuthAccessToken(authToken)
+	token, err := oc.oidcService.VerifyOAuthAccessToken(c.Request.Context(), authToken)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -212,8 +279,9 @@ func (oc *OidcController) userInfoHandler(c *gin.Context) {
 		_ = c.Error(&common.TokenInvalidError{})
 		return
 	}
+	// The client ID is always the first audience of the access token
 	clientID, ok := token.Audience()
-	if !ok || len(clientID) != 1 {
+	if !ok || len(clientID) == 0 {
 		_ = c.Error(&common.TokenInvalidError{})
 		return
 	}
@@ -359,6 +427,36 @@ func (oc *OidcController) getClientMetaDataHandler(c *gin.Context) {
 	_ = c.Error(err)
 }
 
+// getLoginBrandingHandler godoc
+// @Summary Get the client shown on the login pages
+// @Description Get the metadata of the client that the user authorizes after signing in, based on the redirect of the login pages
+// @Tags OIDC
+// @Produce json
+// @Param redirect query string true "Path the user is redirected to after signing in"
+// @Success 200 {object} dto.OidcClientMetaDataDto "Client metadata"
+// @Success 204 "No client to show"
+// @Router /api/oidc/login-branding [get]
+func (oc *OidcController) getLoginBrandingHandler(c *gin.Context) {
+	client, err := oc.oidcService.GetClientForLoginRedirect(c.Request.Context(), c.Query("redirect"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	if client == nil {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	var clientDto dto.OidcClientMetaDataDto
+	err = dto.MapStruct(client, &clientDto)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, clientDto)
+}
+
 // getClientHandler godoc
 // @Summary Get OIDC client
 // @Description Get detailed information about an OIDC client
@@ -530,6 +628,63 @@ func (oc *OidcController) createClientSecretHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"secret": secret})
 }
 
+// cloneClientHandler godoc
+// @Summary Clone OIDC client
+// @Description Create a new OIDC client with the configuration of an existing one. The secret of the new client is only returned once.
+// @Tags OIDC
+// @Produce json
+// @Param id path string true "ID of the client to clone"
+// @Success 201 {object} dto.OidcClientCloneDto "Cloned client"
+// @Router /api/oidc/clients/{id}/clone [post]
+func (oc *OidcController) cloneClientHandler(c *gin.Context) {
+	client, secret, err := oc.oidcService.CloneClient(c.Request.Context(), c.Param("id"), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var clientDto dto.OidcClientCloneDto
+	if err := dto.MapStruct(client, &clientDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+	clientDto.Secret = secret
+
+	c.JSON(http.StatusCreated, clientDto)
+}
+
+// revokeClientTokensHandler godoc
+// @Summary Revoke all tokens of an OIDC client
+// @Description Delete the refresh tokens, authorization codes and device codes of a client, and optionally reject its access tokens and require the users to consent again
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param body body dto.OidcRevokeClientTokensDto true "Revocation options"
+// @Success 200 {object} dto.OidcRevokeClientTokensResponseDto "Number of revoked tokens"
+// @Router /api/oidc/clients/{id}/revoke-tokens [post]
+func (oc *OidcController) revokeClientTokensHandler(c *gin.Context) {
+	var input dto.OidcRevokeClientTokensDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	revoked, err := oc.oidcService.RevokeAllClientTokens(c.Request.Context(), c.Param("id"), input, c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var revokedDto dto.OidcRevokeClientTokensResponseDto
+	if err := dto.MapStruct(revoked, &revokedDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, revokedDto)
+}
+
 // getClientLogoHandler godoc
 // @Summary Get client logo
 // @Description Get the logo image for an OIDC client
@@ -539,18 +694,22 @@ func (oc *OidcController) createClientSecretHandler(c *gin.Context) {
 // @Produce image/svg+xml
 // @Param id path string true "Client ID"
 // @Success 200 {file} binary "Logo image"
+// @Success 304 "Not Modified, if the ETag in If-None-Match still matches"
 // @Router /api/oidc/clients/{id}/logo [get]
 func (oc *OidcController) getClientLogoHandler(c *gin.Context) {
-	imagePath, mimeType, err := oc.oidcService.GetClientLogo(c.Request.Context(), c.Param("id"))
+	data, mimeType, err := oc.oidcService.GetClientLogo(c.Request.Context(), c.Param("id"))
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
 	utils.SetCacheControlHeader(c, 15*time.Minute, 12*time.Hour)
+	if utils.SetETagHeader(c, data) {
+		c.Status(http.StatusNotModified)
+		return
+	}
 
-	c.Header("Content-Type", mimeType)
-	c.File(imagePath)
+	c.Data(http.StatusOK, mimeType, data)
 }
 
 // updateClientLogoHandler godoc
@@ -627,6 +786,71 @@ func (oc *OidcController) updateAllowedUserGroupsHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, oidcClientDto)
 }
 
+// addFederatedIdentityHandler godoc
+// @Summary Add federated identity
+// @Description Add a federated identity to an OIDC client, so it can authenticate with the client assertions of another issuer
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param identity body dto.OidcClientFederatedIdentityCreateDto true "Federated identity"
+// @Success 200 {object} dto.OidcClientDto "Updated client"
+// @Router /api/oidc/clients/{id}/federated-identities [post]
+func (oc *OidcController) addFederatedIdentityHandler(c *gin.Context) {
+	var input dto.OidcClientFederatedIdentityCreateDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	oidcClient, err := oc.oidcService.AddClientFederatedIdentity(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var oidcClientDto dto.OidcClientDto
+	if err := dto.MapStruct(oidcClient, &oidcClientDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, oidcClientDto)
+}
+
+// removeFederatedIdentityHandler godoc
+// @Summary Remove federated identity
+// @Description Remove the federated identity with exactly the given issuer, subject and audience from an OIDC client
+// @Tags OIDC
+// @Produce json
+// @Param id path string true "Client ID"
+// @Param issuer query string true "Issuer of the federated identity"
+// @Param subject query string false "Subject of the federated identity"
+// @Param audience query string false "Audience of the federated identity"
+// @Success 200 {object} dto.OidcClientDto "Updated client"
+// @Router /api/oidc/clients/{id}/federated-identities [delete]
+func (oc *OidcController) removeFederatedIdentityHandler(c *gin.Context) {
+	var input dto.OidcClientFederatedIdentityDeleteDto
+	if err := c.ShouldBindQuery(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	oidcClient, err := oc.oidcService.RemoveClientFederatedIdentity(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var oidcClientDto dto.OidcClientDto
+	if err := dto.MapStruct(oidcClient, &oidcClientDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, oidcClientDto)
+}
+
 func (oc *OidcController) deviceAuthorizationHandler(c *gin.Context) {
 	var input dto.OidcDeviceAuthorizationRequestDto
 	if err := c.ShouldBind(&input); err != nil {
@@ -640,7 +864,12 @@ func (oc *OidcController) deviceAuthorizationHandler(c *gin.Context) {
 	}
 
 	response, err := oc.oidcService.CreateDeviceAuthorization(c.Request.Context(), input)
-	if err != nil {
+	if errors.Is(err, &common.OidcUnauthorizedClientError{}) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "unauthorized_client",
+		})
+		return
+	} else if err != nil {
 		_ = c.Error(err)
 		return
 	}
@@ -679,6 +908,62 @@ func (oc *OidcController) listAuthorizedClientsHandler(c *gin.Context) {
 	oc.listAuthorizedClients(c, userID)
 }
 
+// listSessionsHandler godoc
+// @Summary List sessions of a user
+// @Description Get the active sessions of a user with OIDC clients, without the tokens
+// @Tags OIDC
+// @Param id path string true "User ID"
+// @Success 200 {array} dto.OidcSessionDto
+// @Router /api/oidc/users/{id}/sessions [get]
+func (oc *OidcController) listSessionsHandler(c *gin.Context) {
+	sessions, err := oc.oidcService.ListActiveSessions(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	sessionsDto := make([]dto.OidcSessionDto, len(sessions))
+	for i, session := range sessions {
+		var clientDto dto.OidcClientMetaDataDto
+		if err := dto.MapStruct(session.Client, &clientDto); err != nil {
+			_ = c.Error(err)
+			return
+		}
+
+		sessionsDto[i] = dto.OidcSessionDto{
+			ID:         session.ID,
+			Client:     clientDto,
+			Scope:      session.Scope,
+			StartedAt:  session.StartedAt,
+			LastUsedAt: session.CreatedAt,
+			ExpiresAt:  session.ExpiresAt,
+			IpAddress:  session.IpAddress,
+			Country:    session.Country,
+			City:       session.City,
+		}
+	}
+
+	c.JSON(http.StatusOK, sessionsDto)
+}
+
+// revokeSessionHandler godoc
+// @Summary Revoke a session of a user
+// @Description Revoke a session of a user with an OIDC client, which can't refresh its tokens anymore
+// @Tags OIDC
+// @Param id path string true "User ID"
+// @Param sessionId path string true "Session ID"
+// @Success 204 "No Content"
+// @Router /api/oidc/users/{id}/sessions/{sessionId} [delete]
+func (oc *OidcController) revokeSessionHandler(c *gin.Context) {
+	err := oc.oidcService.RevokeSession(c.Request.Context(), c.Param("id"), c.Param("sessionId"), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 func (oc *OidcController) listAuthorizedClients(c *gin.Context, userID string) {
 	var sortedPaginationRequest utils.SortedPaginationRequest
 	if err := c.ShouldBindQuery(&sortedPaginationRequest); err != nil {
@@ -742,12 +1027,12 @@ func (oc *OidcController) getDeviceCodeInfoHandler(c *gin.Context) {
 
 // getClientPreviewHandler godoc
 // @Summary Preview OIDC client data for user
-// @Description Get a preview of the OIDC data (ID token, access token, userinfo) that would be sent to the client for a specific user
+// @Description Get a preview of the OIDC data (ID token, access token, userinfo) that would be sent to the client for a specific user, and where each claim comes from, without issuing any token
 // @Tags OIDC
 // @Produce json
 // @Param id path string true "Client ID"
 // @Param userId path string true "User ID to preview data for"
-// @Param scopes query string false "Scopes to include in the preview (comma-separated)"
+// @Param scopes query string false "Scopes to include in the preview (space-separated); defaults to the scopes the user has authorized for the client"
 // @Success 200 {object} dto.OidcClientPreviewDto "Preview data including ID token, access token, and userinfo payloads"
 // @Security BearerAuth
 // @Router /api/oidc/clients/{id}/preview/{userId} [get]
@@ -766,16 +1051,37 @@ func (oc *OidcController) getClientPreviewHandler(c *gin.Context) {
 		return
 	}
 
-	if scopes == "" {
-		_ = c.Error(&common.ValidationError{Message: "scopes are required"})
+	preview, err := oc.oidcService.GetClientPreview(c.Request.Context(), clientID, userID, scopes)
+	if err != nil {
+		_ = c.Error(err)
 		return
 	}
 
-	preview, err := oc.oidcService.GetClientPreview(c.Request.Context(), clientID, userID, scopes)
+	c.JSON(http.StatusOK, preview)
+}
+
+// validateClientHandler godoc
+// @Summary Validate an OIDC client configuration
+// @Description Check the callback URLs, the JWK sets of the federated identities, the allowed user groups and the scopes of a client configuration without saving it
+// @Tags OIDC
+// @Accept json
+// @Produce json
+// @Param client body dto.OidcClientValidateDto true "Client configuration"
+// @Success 200 {object} dto.OidcClientValidationResultDto "Issues found in the configuration"
+// @Security BearerAuth
+// @Router /api/oidc/clients/validate [post]
+func (oc *OidcController) validateClientHandler(c *gin.Context) {
+	var input dto.OidcClientValidateDto
+	if err := dto.ShouldBindWithNormalizedJSON(c, &input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := oc.oidcService.ValidateClient(c.Request.Context(), input)
 	if err != nil {
 		_ = c.Error(err)
 		return
 	}
 
-	c.JSON(http.StatusOK, preview)
+	c.JSON(http.StatusOK, result)
 }
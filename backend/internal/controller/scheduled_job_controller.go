@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/job"
+	"github.com/pocket-id/pocket-id/backend/internal/middleware"
+)
+
+// NewScheduledJobController creates a new controller for the scheduled jobs
+// @Summary Scheduled jobs controller
+// @Description Initializes the endpoints to inspect the scheduled maintenance jobs
+// @Tags Scheduled Jobs
+func NewScheduledJobController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, scheduler *job.Scheduler) {
+	sjc := &ScheduledJobController{scheduler: scheduler}
+
+	group.GET("/scheduled-jobs", authMiddleware.Add(), sjc.listScheduledJobsHandler)
+}
+
+type ScheduledJobController struct {
+	scheduler *job.Scheduler
+}
+
+// listScheduledJobsHandler godoc
+// @Summary List scheduled jobs
+// @Description Get the status of all scheduled jobs, including their last and next run
+// @Tags Scheduled Jobs
+// @Produce json
+// @Success 200 {array} dto.ScheduledJobDto
+// @Router /api/scheduled-jobs [get]
+func (sjc *ScheduledJobController) listScheduledJobsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, sjc.scheduler.ListJobs())
+}
@@ -1,12 +1,17 @@
 package controller
 
 import (
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pocket-id/pocket-id/backend/internal/utils/cookie"
 
 	"github.com/gin-gonic/gin"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/middleware"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
@@ -18,7 +23,7 @@ import (
 // @Summary User management controller
 // @Description Initializes all user-related API endpoints
 // @Tags Users
-func NewUserController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, rateLimitMiddleware *middleware.RateLimitMiddleware, userService *service.UserService, appConfigService *service.AppConfigService) {
+func NewUserController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, rateLimitMiddleware *middleware.RateLimitMiddleware, fileSizeLimitMiddleware *middleware.FileSizeLimitMiddleware, userService *service.UserService, appConfigService *service.AppConfigService) {
 	uc := UserController{
 		userService:      userService,
 		appConfigService: appConfigService,
@@ -27,26 +32,38 @@ func NewUserController(group *gin.RouterGroup, authMiddleware *middleware.AuthMi
 	group.GET("/users", authMiddleware.Add(), uc.listUsersHandler)
 	group.GET("/users/me", authMiddleware.WithAdminNotRequired().Add(), uc.getCurrentUserHandler)
 	group.GET("/users/:id", authMiddleware.Add(), uc.getUserHandler)
+	group.GET("/users/me/export", authMiddleware.WithAdminNotRequired().Add(), uc.exportCurrentUserDataHandler)
+	group.GET("/users/:id/export", authMiddleware.Add(), uc.exportUserDataHandler)
 	group.POST("/users", authMiddleware.Add(), uc.createUserHandler)
 	group.PUT("/users/:id", authMiddleware.Add(), uc.updateUserHandler)
 	group.GET("/users/:id/groups", authMiddleware.Add(), uc.getUserGroupsHandler)
 	group.PUT("/users/me", authMiddleware.WithAdminNotRequired().Add(), uc.updateCurrentUserHandler)
 	group.DELETE("/users/:id", authMiddleware.Add(), uc.deleteUserHandler)
+	group.POST("/users/me/deletion-request", authMiddleware.WithAdminNotRequired().Add(), uc.requestAccountDeletionHandler)
+	group.POST("/users/me/deletion-confirm", authMiddleware.WithAdminNotRequired().Add(), uc.confirmAccountDeletionHandler)
 
 	group.PUT("/users/:id/user-groups", authMiddleware.Add(), uc.updateUserGroups)
 
 	group.GET("/users/:id/profile-picture.png", uc.getUserProfilePictureHandler)
 
-	group.PUT("/users/:id/profile-picture", authMiddleware.Add(), uc.updateUserProfilePictureHandler)
-	group.PUT("/users/me/profile-picture", authMiddleware.WithAdminNotRequired().Add(), uc.updateCurrentUserProfilePictureHandler)
+	group.PUT("/users/:id/profile-picture", authMiddleware.Add(), fileSizeLimitMiddleware.Add(2<<20), uc.updateUserProfilePictureHandler)
+	group.PUT("/users/me/profile-picture", authMiddleware.WithAdminNotRequired().Add(), fileSizeLimitMiddleware.Add(2<<20), uc.updateCurrentUserProfilePictureHandler)
 
 	group.POST("/users/me/one-time-access-token", authMiddleware.WithAdminNotRequired().Add(), uc.createOwnOneTimeAccessTokenHandler)
 	group.POST("/users/:id/one-time-access-token", authMiddleware.Add(), uc.createAdminOneTimeAccessTokenHandler)
 	group.POST("/users/:id/one-time-access-email", authMiddleware.Add(), uc.RequestOneTimeAccessEmailAsAdminHandler)
-	group.POST("/one-time-access-token/:token", rateLimitMiddleware.Add(rate.Every(10*time.Second), 5), uc.exchangeOneTimeAccessTokenHandler)
+	group.POST("/users/:id/passkey-reenrollment", authMiddleware.Add(), uc.requirePasskeyReenrollmentHandler)
+	group.DELETE("/users/:id/passkey-reenrollment", authMiddleware.Add(), uc.cancelPasskeyReenrollmentHandler)
+	group.PUT("/users/:id/credential-limits", authMiddleware.Add(), uc.updateCredentialLimitsHandler)
+
+	// Checking and exchanging tokens share the rate limit, so checking tokens doesn't allow more guesses
+	oneTimeAccessTokenRateLimit := rateLimitMiddleware.Add(rate.Every(10*time.Second), 5)
+	group.GET("/one-time-access-token/:token", oneTimeAccessTokenRateLimit, uc.peekOneTimeAccessTokenHandler)
+	group.POST("/one-time-access-token/:token", oneTimeAccessTokenRateLimit, uc.exchangeOneTimeAccessTokenHandler)
 	group.POST("/one-time-access-email", rateLimitMiddleware.Add(rate.Every(10*time.Minute), 3), uc.RequestOneTimeAccessEmailAsUnauthenticatedUserHandler)
 
 	group.DELETE("/users/:id/profile-picture", authMiddleware.Add(), uc.resetUserProfilePictureHandler)
+	group.POST("/users/:id/profile-picture/regenerate", authMiddleware.Add(), uc.regenerateDefaultProfilePictureHandler)
 	group.DELETE("/users/me/profile-picture", authMiddleware.WithAdminNotRequired().Add(), uc.resetCurrentUserProfilePictureHandler)
 
 	group.POST("/signup-tokens", authMiddleware.Add(), uc.createSignupTokenHandler)
@@ -91,6 +108,8 @@ func (uc *UserController) getUserGroupsHandler(c *gin.Context) {
 // @Description Get a paginated list of users with optional search and sorting
 // @Tags Users
 // @Param search query string false "Search term to filter users"
+// @Param associations query bool false "Whether to include the groups and custom claims of the users" default(true)
+// @Param fields query string false "Comma-separated list of the fields to include; the ID is always included and the response contains dto.UserProjectionDto items"
 // @Param pagination[page] query int false "Page number for pagination" default(1)
 // @Param pagination[limit] query int false "Number of items per page" default(20)
 // @Param sort[column] query string false "Column to sort by"
@@ -105,7 +124,20 @@ func (uc *UserController) listUsersHandler(c *gin.Context) {
 		return
 	}
 
-	users, pagination, err := uc.userService.ListUsers(c.Request.Context(), searchTerm, sortedPaginationRequest)
+	opts := service.ListUsersOptions{}
+	if associations := c.Query("associations"); associations != "" {
+		includeAssociations, err := strconv.ParseBool(associations)
+		if err != nil {
+			_ = c.Error(&common.ValidationError{Message: "associations must be a boolean"})
+			return
+		}
+		opts.SkipAssociations = !includeAssociations
+	}
+	if fields := c.Query("fields"); fields != "" {
+		opts.Fields = strings.Split(fields, ",")
+	}
+
+	users, pagination, err := uc.userService.ListUsers(c.Request.Context(), searchTerm, sortedPaginationRequest, opts)
 	if err != nil {
 		_ = c.Error(err)
 		return
@@ -117,15 +149,61 @@ func (uc *UserController) listUsersHandler(c *gin.Context) {
 		return
 	}
 
+	// If only some fields are selected, the other ones are omitted instead of being returned with empty values
+	if len(opts.Fields) > 0 {
+		projections := make([]dto.UserProjectionDto, len(usersDto))
+		for i, user := range usersDto {
+			projections[i] = projectUserDto(user, opts)
+		}
+
+		c.JSON(http.StatusOK, dto.Paginated[dto.UserProjectionDto]{
+			Data:       projections,
+			Pagination: pagination,
+		})
+		return
+	}
+
 	c.JSON(http.StatusOK, dto.Paginated[dto.UserDto]{
 		Data:       usersDto,
 		Pagination: pagination,
 	})
 }
 
+// projectUserDto returns the fields of the user that were selected in the options
+func projectUserDto(user dto.UserDto, opts service.ListUsersOptions) dto.UserProjectionDto {
+	projection := dto.UserProjectionDto{ID: user.ID}
+	for _, field := range opts.Fields {
+		switch field {
+		case "username":
+			projection.Username = &user.Username
+		case "email":
+			projection.Email = &user.Email
+		case "firstName":
+			projection.FirstName = &user.FirstName
+		case "lastName":
+			projection.LastName = &user.LastName
+		case "isAdmin":
+			projection.IsAdmin = &user.IsAdmin
+		case "locale":
+			projection.Locale = user.Locale
+		case "ldapId":
+			projection.LdapID = user.LdapID
+		case "disabled":
+			projection.Disabled = &user.Disabled
+		}
+	}
+
+	if !opts.SkipAssociations {
+		projection.CustomClaims = &user.CustomClaims
+		projection.UserGroups = &user.UserGroups
+	}
+
+	return projection
+}
+
 // getUserHandler godoc
 // @Summary Get user by ID
-// @Description Retrieve detailed information about a specific user
+// @Description Retrieve detailed information about a specific user, including the number of active API keys and passkeys
 // @Tags Users
 // @Param id path string true "User ID"
 // @Success 200 {object} dto.UserDto
@@ -143,6 +221,14 @@ func (uc *UserController) getUserHandler(c *gin.Context) {
 		return
 	}
 
+	apiKeyCount, passkeyCount, err := uc.userService.GetCredentialCounts(c.Request.Context(), user.ID)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	userDto.ApiKeyCount = &apiKeyCount
+	userDto.PasskeyCount = &passkeyCount
+
 	c.JSON(http.StatusOK, userDto)
 }
 
@@ -168,6 +254,52 @@ func (uc *UserController) getCurrentUserHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, userDto)
 }
 
+// exportCurrentUserDataHandler godoc
+// @Summary Export current user's data
+// @Description Download all data stored about the currently authenticated user as a JSON document
+// @Tags Users
+// @Produce json
+// @Success 200 {file} file "JSON document"
+// @Router /api/users/me/export [get]
+func (uc *UserController) exportCurrentUserDataHandler(c *gin.Context) {
+	uc.exportUserData(c, c.GetString("userID"))
+}
+
+// exportUserDataHandler godoc
+// @Summary Export user data
+// @Description Download all data stored about a specific user as a JSON document
+// @Tags Users
+// @Param id path string true "User ID"
+// @Produce json
+// @Success 200 {file} file "JSON document"
+// @Router /api/users/{id}/export [get]
+func (uc *UserController) exportUserDataHandler(c *gin.Context) {
+	uc.exportUserData(c, c.Param("id"))
+}
+
+func (uc *UserController) exportUserData(c *gin.Context, userID string) {
+	// The headers are set only once the export starts, so errors that happen before can still be returned as JSON
+	w := &firstWriteWriter{
+		w: c.Writer,
+		onFirstWrite: func() {
+			c.Header("Content-Type", "application/json")
+			c.Header("Content-Disposition", `attachment; filename="pocket-id-export-`+userID+`.json"`)
+			c.Status(http.StatusOK)
+		},
+	}
+
+	err := uc.userService.ExportUserData(c.Request.Context(), userID, w)
+	if err != nil && w.written {
+		// The response has been partially sent already, so the error can't be returned to the client
+		slog.ErrorContext(c.Request.Context(), "Failed to export user data", slog.String("userID", userID), slog.Any("error", err))
+		c.Abort()
+		return
+	} else if err != nil {
+		_ = c.Error(err)
+		return
+	}
+}
+
 // deleteUserHandler godoc
 // @Summary Delete user
 // @Description Delete a specific user by ID
@@ -367,6 +499,49 @@ func (uc *UserController) createAdminOneTimeAccessTokenHandler(c *gin.Context) {
 	uc.createOneTimeAccessTokenHandler(c, false)
 }
 
+// requestAccountDeletionHandler godoc
+// @Summary Request deletion of current user's account
+// @Description Start the deletion of the currently authenticated user's account. A token is sent to the user's email address, which must be passed to the confirmation endpoint.
+// @Tags Users
+// @Success 204 "No Content"
+// @Router /api/users/me/deletion-request [post]
+func (uc *UserController) requestAccountDeletionHandler(c *gin.Context) {
+	err := uc.userService.RequestAccountDeletion(c.Request.Context(), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// confirmAccountDeletionHandler godoc
+// @Summary Confirm deletion of current user's account
+// @Description Delete the currently authenticated user's account using the token sent by the deletion request
+// @Tags Users
+// @Accept json
+// @Param body body dto.AccountDeletionConfirmDto true "Confirmation token"
+// @Success 204 "No Content"
+// @Router /api/users/me/deletion-confirm [post]
+func (uc *UserController) confirmAccountDeletionHandler(c *gin.Context) {
+	var input dto.AccountDeletionConfirmDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	err := uc.userService.ConfirmAccountDeletion(c.Request.Context(), c.GetString("userID"), input.Token, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	// The account doesn't exist anymore, so the session is ended
+	cookie.AddAccessTokenCookie(c, 0, "")
+
+	c.Status(http.StatusNoContent)
+}
+
 // RequestOneTimeAccessEmailAsUnauthenticatedUserHandler godoc
 // @Summary Request one-time access email
 // @Description Request a one-time access email for unauthenticated users
@@ -420,6 +595,87 @@ func (uc *UserController) RequestOneTimeAccessEmailAsAdminHandler(c *gin.Context
 	c.Status(http.StatusNoContent)
 }
 
+// requirePasskeyReenrollmentHandler godoc
+// @Summary Require passkey re-enrollment
+// @Description Delete the passkeys and sessions of a user, who must sign in with a one-time access token and add a new passkey
+// @Tags Users
+// @Param id path string true "User ID"
+// @Success 204 "No Content"
+// @Router /api/users/{id}/passkey-reenrollment [post]
+func (uc *UserController) requirePasskeyReenrollmentHandler(c *gin.Context) {
+	err := uc.userService.RequirePasskeyReenrollment(c.Request.Context(), c.Param("id"), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// cancelPasskeyReenrollmentHandler godoc
+// @Summary Cancel passkey re-enrollment
+// @Description Clear the requirement of a user to add a new passkey
+// @Tags Users
+// @Param id path string true "User ID"
+// @Success 204 "No Content"
+// @Router /api/users/{id}/passkey-reenrollment [delete]
+func (uc *UserController) cancelPasskeyReenrollmentHandler(c *gin.Context) {
+	err := uc.userService.CancelPasskeyReenrollment(c.Request.Context(), c.Param("id"), c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// updateCredentialLimitsHandler godoc
+// @Summary Update credential limits
+// @Description Set the maximum number of API keys and passkeys of a user, overriding the limits of the app config
+// @Tags Users
+// @Param id path string true "User ID"
+// @Param limits body dto.UserCredentialLimitsDto true "Credential limits"
+// @Success 200 {object} dto.UserDto
+// @Router /api/users/{id}/credential-limits [put]
+func (uc *UserController) updateCredentialLimitsHandler(c *gin.Context) {
+	var input dto.UserCredentialLimitsDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	user, err := uc.userService.UpdateCredentialLimits(c.Request.Context(), c.Param("id"), input)
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var userDto dto.UserDto
+	if err := dto.MapStruct(user, &userDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, userDto)
+}
+
+// peekOneTimeAccessTokenHandler godoc
+// @Summary Check one-time access token
+// @Description Check if a one-time access token is valid without consuming it
+// @Tags Users
+// @Param token path string true "One-time access token"
+// @Success 200 {object} dto.OneTimeAccessTokenPeekDto
+// @Router /api/one-time-access-token/{token} [get]
+func (uc *UserController) peekOneTimeAccessTokenHandler(c *gin.Context) {
+	valid, err := uc.userService.PeekOneTimeAccessToken(c.Request.Context(), c.Param("token"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.OneTimeAccessTokenPeekDto{Valid: valid})
+}
+
 // exchangeOneTimeAccessTokenHandler godoc
 // @Summary Exchange one-time access token
 // @Description Exchange a one-time access token for a session token
@@ -681,6 +937,22 @@ func (uc *UserController) resetUserProfilePictureHandler(c *gin.Context) {
 	c.Status(http.StatusNoContent)
 }
 
+// regenerateDefaultProfilePictureHandler godoc
+// @Summary Regenerate default profile picture
+// @Description Recreate the default profile picture with the initials of a specific user, e.g. if it's outdated
+// @Tags Users
+// @Param id path string true "User ID"
+// @Success 204 "No Content"
+// @Router /api/users/{id}/profile-picture/regenerate [post]
+func (uc *UserController) regenerateDefaultProfilePictureHandler(c *gin.Context) {
+	if err := uc.userService.RegenerateDefaultProfilePicture(c.Request.Context(), c.Param("id")); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 // resetCurrentUserProfilePictureHandler godoc
 // @Summary Reset current user's profile picture
 // @Description Reset the currently authenticated user's profile picture to the default
@@ -698,3 +970,18 @@ func (uc *UserController) resetCurrentUserProfilePictureHandler(c *gin.Context)
 
 	c.Status(http.StatusNoContent)
 }
+
+// firstWriteWriter invokes a callback before the first write to the underlying writer
+type firstWriteWriter struct {
+	w            io.Writer
+	onFirstWrite func()
+	written      bool
+}
+
+func (fw *firstWriteWriter) Write(p []byte) (int, error) {
+	if !fw.written {
+		fw.written = true
+		fw.onFirstWrite()
+	}
+	return fw.w.Write(p)
+}
@@ -2,8 +2,13 @@ package controller
 
 import (
 	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/middleware"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
@@ -14,7 +19,7 @@ import (
 // @Summary User group management controller
 // @Description Initializes all user group-related API endpoints
 // @Tags User Groups
-func NewUserGroupController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, userGroupService *service.UserGroupService) {
+func NewUserGroupController(group *gin.RouterGroup, authMiddleware *middleware.AuthMiddleware, fileSizeLimitMiddleware *middleware.FileSizeLimitMiddleware, userGroupService *service.UserGroupService) {
 	ugc := UserGroupController{
 		UserGroupService: userGroupService,
 	}
@@ -25,9 +30,12 @@ func NewUserGroupController(group *gin.RouterGroup, authMiddleware *middleware.A
 		userGroupsGroup.GET("", ugc.list)
 		userGroupsGroup.GET("/:id", ugc.get)
 		userGroupsGroup.POST("", ugc.create)
+		// The import files can list thousands of groups and members, which exceeds the limit of regular requests
+		userGroupsGroup.POST("/import", fileSizeLimitMiddleware.Add(5<<20), ugc.importGroups)
 		userGroupsGroup.PUT("/:id", ugc.update)
 		userGroupsGroup.DELETE("/:id", ugc.delete)
 		userGroupsGroup.PUT("/:id/users", ugc.updateUsers)
+		userGroupsGroup.POST("/:id/users/bulk", ugc.bulkAssign)
 	}
 }
 
@@ -221,3 +229,95 @@ func (ugc *UserGroupController) updateUsers(c *gin.Context) {
 
 	c.JSON(http.StatusOK, groupDto)
 }
+
+// bulkAssign godoc
+// @Summary Add or remove many users
+// @Description Add or remove the group membership of many users at once; users that are already members, or aren't members when removing them, are skipped
+// @Tags User Groups
+// @Accept json
+// @Produce json
+// @Param id path string true "User Group ID"
+// @Param request body dto.UserGroupBulkAssignDto true "Users and action"
+// @Success 200 {array} dto.UserGroupBulkAssignResultDto
+// @Router /api/user-groups/{id}/users/bulk [post]
+func (ugc *UserGroupController) bulkAssign(c *gin.Context) {
+	var input dto.UserGroupBulkAssignDto
+	if err := c.ShouldBindJSON(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	results, err := ugc.UserGroupService.BulkAssign(c.Request.Context(), c.Param("id"), input.UserIDs, input.Action == "add", c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var resultsDto []dto.UserGroupBulkAssignResultDto
+	if err := dto.MapStructList(results, &resultsDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resultsDto)
+}
+
+// importGroups godoc
+// @Summary Import user groups
+// @Description Create user groups and add their members from a CSV or JSON file. Members are resolved by username or email.
+// @Tags User Groups
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV file with the columns name, friendlyName and members, or JSON array of groups"
+// @Param allOrNothing formData bool false "Don't import anything if a group can't be imported or a member can't be resolved"
+// @Success 200 {object} dto.UserGroupImportResultDto
+// @Router /api/user-groups/import [post]
+func (ugc *UserGroupController) importGroups(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	allOrNothing, _ := strconv.ParseBool(c.PostForm("allOrNothing"))
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+	defer file.Close()
+
+	var input dto.UserGroupImportDto
+	switch strings.ToLower(filepath.Ext(fileHeader.Filename)) {
+	case ".csv":
+		input, err = service.ParseUserGroupImportCSV(file)
+	case ".json":
+		input, err = service.ParseUserGroupImportJSON(file)
+	default:
+		err = &common.ValidationError{Message: "The file must be a CSV or JSON file"}
+	}
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	dto.Normalize(&input.Groups)
+	if err := binding.Validator.ValidateStruct(&input); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	result, err := ugc.UserGroupService.Import(c.Request.Context(), input, allOrNothing, c.GetString("userID"), c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	var resultDto dto.UserGroupImportResultDto
+	if err := dto.MapStruct(result, &resultDto); err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.JSON(http.StatusOK, resultDto)
+}
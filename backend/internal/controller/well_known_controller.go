@@ -6,10 +6,13 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"strings"
+	"sync"
 
 	"github.com/gin-gonic/gin"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
 )
 
@@ -17,12 +20,11 @@ import (
 // @Summary OIDC Discovery controller
 // @Description Initializes OIDC discovery and JWKS endpoints
 // @Tags Well Known
-func NewWellKnownController(group *gin.RouterGroup, jwtService *service.JwtService) {
+func NewWellKnownController(group *gin.RouterGroup, jwtService *service.JwtService, routes func() gin.RoutesInfo) {
 	wkc := &WellKnownController{jwtService: jwtService}
 
-	// Pre-compute the OIDC configuration document, which is static
-	var err error
-	wkc.oidcConfig, err = wkc.computeOIDCConfiguration()
+	// Pre-compute the OIDC configuration document, which only changes if the signing algorithms change
+	_, err := wkc.getOIDCConfiguration()
 	if err != nil {
 		slog.Error("Failed to pre-compute OpenID Connect configuration document", slog.Any("error", err))
 		os.Exit(1)
@@ -31,11 +33,26 @@ func NewWellKnownController(group *gin.RouterGroup, jwtService *service.JwtServi
 
 	group.GET("/.well-known/jwks.json", wkc.jwksHandler)
 	group.GET("/.well-known/openid-configuration", wkc.openIDConfigurationHandler)
+	group.GET("/.well-known/openapi.json", wkc.openAPIHandler)
+
+	// The OpenAPI document only depends on the routes and the DTOs, so it can be pre-computed too
+	// The API routes are registered before this controller, so they're all included in the document
+	wkc.openAPISpec, err = dto.GenerateOpenAPISpec(routes())
+	if err != nil {
+		slog.Error("Failed to pre-compute OpenAPI document", slog.Any("error", err))
+		os.Exit(1)
+		return
+	}
 }
 
 type WellKnownController struct {
-	jwtService *service.JwtService
-	oidcConfig []byte
+	jwtService  *service.JwtService
+	openAPISpec []byte
+
+	// oidcConfig is computed again when the signing algorithms change, e.g. after the key is rotated to another algorithm
+	oidcConfigLock sync.Mutex
+	oidcConfig     []byte
+	oidcConfigAlgs string
 }
 
 // jwksHandler godoc
@@ -62,15 +79,54 @@ func (wkc *WellKnownController) jwksHandler(c *gin.Context) {
 // @Success 200 {object} object "OpenID Connect configuration"
 // @Router /.well-known/openid-configuration [get]
 func (wkc *WellKnownController) openIDConfigurationHandler(c *gin.Context) {
-	c.Data(http.StatusOK, "application/json; charset=utf-8", wkc.oidcConfig)
+	oidcConfig, err := wkc.getOIDCConfiguration()
+	if err != nil {
+		_ = c.Error(err)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/json; charset=utf-8", oidcConfig)
 }
 
-func (wkc *WellKnownController) computeOIDCConfiguration() ([]byte, error) {
-	appUrl := common.EnvConfig.AppURL
-	alg, err := wkc.jwtService.GetKeyAlg()
+// openAPIHandler godoc
+// @Summary Get OpenAPI document
+// @Description Returns the OpenAPI document describing the routes and the request and response schemas of the API, including their validation rules
+// @Tags Well Known
+// @Success 200 {object} object "OpenAPI document"
+// @Router /.well-known/openapi.json [get]
+func (wkc *WellKnownController) openAPIHandler(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json; charset=utf-8", wkc.openAPISpec)
+}
+
+// getOIDCConfiguration returns the cached OIDC configuration document, computing it again if the signing algorithms changed
+func (wkc *WellKnownController) getOIDCConfiguration() ([]byte, error) {
+	algs, err := wkc.jwtService.GetSigningAlgs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signing algorithms: %w", err)
+	}
+	algsKey := strings.Join(algs, ",")
+
+	wkc.oidcConfigLock.Lock()
+	defer wkc.oidcConfigLock.Unlock()
+
+	if wkc.oidcConfig != nil && wkc.oidcConfigAlgs == algsKey {
+		return wkc.oidcConfig, nil
+	}
+
+	oidcConfig, err := computeOIDCConfiguration(algs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get key algorithm: %w", err)
+		return nil, err
 	}
+	wkc.oidcConfig = oidcConfig
+	wkc.oidcConfigAlgs = algsKey
+
+	return oidcConfig, nil
+}
+
+// computeOIDCConfiguration builds the OIDC configuration document.
+// The signing algorithms are the ones of the keys in the JWKS, so that relying parties accept every token that can be verified.
+func computeOIDCConfiguration(signingAlgs []string) ([]byte, error) {
+	appUrl := common.EnvConfig.AppURL
 	config := map[string]any{
 		"issuer":                                         appUrl,
 		"authorization_endpoint":                         appUrl + "/authorize",
@@ -80,13 +136,16 @@ func (wkc *WellKnownController) computeOIDCConfiguration() ([]byte, error) {
 		"introspection_endpoint":                         appUrl + "/api/oidc/introspect",
 		"device_authorization_endpoint":                  appUrl + "/api/oidc/device/authorize",
 		"jwks_uri":                                       appUrl + "/.well-known/jwks.json",
-		"grant_types_supported":                          []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeDeviceCode},
+		"grant_types_supported":                          []string{service.GrantTypeAuthorizationCode, service.GrantTypeRefreshToken, service.GrantTypeDeviceCode, service.GrantTypeClientCredentials, service.GrantTypeTokenExchange},
 		"scopes_supported":                               []string{"openid", "profile", "email", "groups"},
-		"claims_supported":                               []string{"sub", "given_name", "family_name", "name", "email", "email_verified", "preferred_username", "picture", "groups"},
+		"claims_supported":                               []string{"sub", "given_name", "family_name", "name", "email", "email_verified", "preferred_username", "picture", "groups", "auth_time", "acr", "amr"},
 		"response_types_supported":                       []string{"code", "id_token"},
-		"subject_types_supported":                        []string{"public"},
-		"id_token_signing_alg_values_supported":          []string{alg.String()},
+		"subject_types_supported":                        []string{"public", "pairwise"},
+		"id_token_signing_alg_values_supported":          signingAlgs,
 		"authorization_response_iss_parameter_supported": true,
+		"acr_values_supported":                           []string{common.EnvConfig.OidcAcrPasskey, common.EnvConfig.OidcAcrOneTimeCode},
+		"request_parameter_supported":                    true,
+		"request_uri_parameter_supported":                true,
 	}
 	return json.Marshal(config)
 }
@@ -10,14 +10,20 @@ type ApiKeyCreateDto struct {
 	ExpiresAt   datatype.DateTime `json:"expiresAt" binding:"required"`
 }
 
+type ApiKeyRotateDto struct {
+	// Number of seconds during which the previous secret remains valid
+	GracePeriodSeconds int `json:"gracePeriodSeconds" binding:"min=0,max=604800"`
+}
+
 type ApiKeyDto struct {
-	ID                  string             `json:"id"`
-	Name                string             `json:"name"`
-	Description         string             `json:"description"`
-	ExpiresAt           datatype.DateTime  `json:"expiresAt"`
-	LastUsedAt          *datatype.DateTime `json:"lastUsedAt"`
-	CreatedAt           datatype.DateTime  `json:"createdAt"`
-	ExpirationEmailSent bool               `json:"expirationEmailSent"`
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	Description          string             `json:"description"`
+	ExpiresAt            datatype.DateTime  `json:"expiresAt"`
+	LastUsedAt           *datatype.DateTime `json:"lastUsedAt"`
+	CreatedAt            datatype.DateTime  `json:"createdAt"`
+	ExpirationEmailSent  bool               `json:"expirationEmailSent"`
+	PreviousKeyExpiresAt *datatype.DateTime `json:"previousKeyExpiresAt"`
 }
 
 type ApiKeyResponseDto struct {
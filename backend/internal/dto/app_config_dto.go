@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 type PublicAppConfigVariableDto struct {
 	Key   string `json:"key"`
 	Type  string `json:"type"`
@@ -13,15 +15,56 @@ type AppConfigVariableDto struct {
 
 type AppConfigUpdateDto struct {
 	AppName                                    string `json:"appName" binding:"required,min=1,max=30" unorm:"nfc"`
+	AppNameLocalized                           string `json:"appNameLocalized" binding:"omitempty,json"`
 	SessionDuration                            string `json:"sessionDuration" binding:"required"`
+	SessionIdleTimeout                         string `json:"sessionIdleTimeout" binding:"omitempty,number"`
+	AuthorizationCodeLifetime                  string `json:"authorizationCodeLifetime" binding:"omitempty,number"`
 	EmailsVerified                             string `json:"emailsVerified" binding:"required"`
 	DisableAnimations                          string `json:"disableAnimations" binding:"required"`
 	AllowOwnAccountEdit                        string `json:"allowOwnAccountEdit" binding:"required"`
 	AllowUserSignups                           string `json:"allowUserSignups" binding:"required,oneof=disabled withToken open"`
-	AccentColor                                string `json:"accentColor"`
+	GenerateUsernameFromEmail                  string `json:"generateUsernameFromEmail"`
+	AllowUserSelfDeletion                      string `json:"allowUserSelfDeletion"`
+	LoginEmailEnabled                          string `json:"loginEmailEnabled"`
+	UnicodeNormalizationForm                   string `json:"unicodeNormalizationForm" binding:"omitempty,oneof=nfc nfkc"`
+	DefaultLocale                              string `json:"defaultLocale"`
+	SupportedLocales                           string `json:"supportedLocales"`
+	PreferredUsernameClaim                     string `json:"preferredUsernameClaim" binding:"omitempty,oneof=username emailLocalPart"`
+	NameClaimTemplate                          string `json:"nameClaimTemplate" binding:"max=255"`
+	AllowedEmailDomains                        string `json:"allowedEmailDomains"`
+	AllowedEmailDomainsExemptLdap              string `json:"allowedEmailDomainsExemptLdap"`
+	SignupBlockedEmailDomains                  string `json:"signupBlockedEmailDomains"`
+	SignupBlockDisposableEmailDomains          string `json:"signupBlockDisposableEmailDomains"`
+	UserGroupFriendlyNameUnique                string `json:"userGroupFriendlyNameUnique"`
+	UserGroupNameSlugEnforced                  string `json:"userGroupNameSlugEnforced"`
+	InactiveUserDisableDays                    string `json:"inactiveUserDisableDays" binding:"omitempty,number"`
+	InactiveUserWarningDays                    string `json:"inactiveUserWarningDays" binding:"omitempty,number"`
+	InactiveUserExemptAdmins                   string `json:"inactiveUserExemptAdmins"`
+	InactiveUserExemptGroups                   string `json:"inactiveUserExemptGroups"`
+	MaxApiKeysPerUser                          string `json:"maxApiKeysPerUser" binding:"omitempty,number"`
+	MaxPasskeysPerUser                         string `json:"maxPasskeysPerUser" binding:"omitempty,number"`
+	CredentialLimitsExemptAdmins               string `json:"credentialLimitsExemptAdmins"`
+	GeoblockingMode                            string `json:"geoblockingMode" binding:"omitempty,oneof=disabled allowList denyList"`
+	GeoblockingCountries                       string `json:"geoblockingCountries"`
+	GeoblockingAllowUnknown                    string `json:"geoblockingAllowUnknown"`
+	GeoblockingExemptAdmins                    string `json:"geoblockingExemptAdmins"`
+	SuspiciousSignInDetectionEnabled           string `json:"suspiciousSignInDetectionEnabled"`
+	SuspiciousSignInMaxSpeed                   string `json:"suspiciousSignInMaxSpeed" binding:"omitempty,number"`
+	SuspiciousSignInMinDistance                string `json:"suspiciousSignInMinDistance" binding:"omitempty,number"`
+	SuspiciousSignInWebhookUrl                 string `json:"suspiciousSignInWebhookUrl" binding:"omitempty,url"`
+	TokenIssuanceAuditEnabled                  string `json:"tokenIssuanceAuditEnabled"`
+	TokenIssuanceAuditSampleRate               string `json:"tokenIssuanceAuditSampleRate" binding:"omitempty,number"`
+	MaintenanceModeEnabled                     string `json:"maintenanceModeEnabled"`
+	MaintenanceModeMessage                     string `json:"maintenanceModeMessage" binding:"max=1000"`
+	AccentColor                                string `json:"accentColor" binding:"omitempty,accentcolor"`
+	ThemePreset                                string `json:"themePreset"`
 	SmtpHost                                   string `json:"smtpHost"`
 	SmtpPort                                   string `json:"smtpPort"`
 	SmtpFrom                                   string `json:"smtpFrom" binding:"omitempty,email"`
+	SmtpFromOnboarding                         string `json:"smtpFromOnboarding" binding:"omitempty,email"`
+	SmtpFromSecurity                           string `json:"smtpFromSecurity" binding:"omitempty,email"`
+	SmtpFromNotifications                      string `json:"smtpFromNotifications" binding:"omitempty,email"`
+	SmtpReplyToNotifications                   string `json:"smtpReplyToNotifications" binding:"omitempty,email"`
 	SmtpUser                                   string `json:"smtpUser"`
 	SmtpPassword                               string `json:"smtpPassword"`
 	SmtpTls                                    string `json:"smtpTls" binding:"required,oneof=none starttls tls"`
@@ -45,8 +88,69 @@ type AppConfigUpdateDto struct {
 	LdapAttributeGroupName                     string `json:"ldapAttributeGroupName"`
 	LdapAttributeAdminGroup                    string `json:"ldapAttributeAdminGroup"`
 	LdapSoftDeleteUsers                        string `json:"ldapSoftDeleteUsers"`
+	LdapMissingUserGraceSyncs                  string `json:"ldapMissingUserGraceSyncs" binding:"omitempty,number"`
+	LdapMissingUserGraceDays                   string `json:"ldapMissingUserGraceDays" binding:"omitempty,number"`
+	LdapSyncNotification                       string `json:"ldapSyncNotification" binding:"omitempty,oneof=disabled always onChange onError"`
+	LdapSyncNotificationWebhookUrl             string `json:"ldapSyncNotificationWebhookUrl" binding:"omitempty,url"`
+	LdapSyncRemovalAlertThreshold              string `json:"ldapSyncRemovalAlertThreshold" binding:"omitempty,number"`
+	LdapRateLimit                              string `json:"ldapRateLimit" binding:"omitempty,number"`
+	LdapCircuitBreakerThreshold                string `json:"ldapCircuitBreakerThreshold" binding:"omitempty,number"`
+	LdapCircuitBreakerCooldown                 string `json:"ldapCircuitBreakerCooldown" binding:"omitempty,number"`
+	LdapDuplicateIdentifierBehavior            string `json:"ldapDuplicateIdentifierBehavior" binding:"omitempty,oneof=skip abort"`
 	EmailOneTimeAccessAsAdminEnabled           string `json:"emailOneTimeAccessAsAdminEnabled" binding:"required"`
 	EmailOneTimeAccessAsUnauthenticatedEnabled string `json:"emailOneTimeAccessAsUnauthenticatedEnabled" binding:"required"`
 	EmailLoginNotificationEnabled              string `json:"emailLoginNotificationEnabled" binding:"required"`
 	EmailApiKeyExpirationEnabled               string `json:"emailApiKeyExpirationEnabled" binding:"required"`
+	EmailWelcomeEnabled                        string `json:"emailWelcomeEnabled"`
+	EmailUnavailableBehavior                   string `json:"emailUnavailableBehavior" binding:"omitempty,oneof=queue fail"`
+	EmailLoginNotificationUserDefault          string `json:"emailLoginNotificationUserDefault" binding:"omitempty,oneof=enabled disabled mandatory"`
+	EmailApiKeyExpirationUserDefault           string `json:"emailApiKeyExpirationUserDefault" binding:"omitempty,oneof=enabled disabled mandatory"`
+}
+
+// AppConfigExportDto is a versioned document with the whole application configuration, used to move it to another instance
+type AppConfigExportDto struct {
+	Version    int               `json:"version" binding:"required"`
+	ExportedAt time.Time         `json:"exportedAt"`
+	Values     map[string]string `json:"values" binding:"required"`
+	// Keys of the sensitive values that were left out of the export, and must be entered again after the import
+	RedactedKeys []string `json:"redactedKeys"`
+}
+
+type AppConfigImportResultDto struct {
+	Config []AppConfigVariableDto `json:"config"`
+	// Keys of the sensitive values that weren't imported, and must be entered again
+	RedactedKeys []string `json:"redactedKeys"`
+}
+
+type LdapSyncResultDto struct {
+	UsersCreated  int      `json:"usersCreated"`
+	UsersUpdated  int      `json:"usersUpdated"`
+	UsersDisabled int      `json:"usersDisabled"`
+	UsersDeleted  int      `json:"usersDeleted"`
+	GroupsCreated int      `json:"groupsCreated"`
+	GroupsUpdated int      `json:"groupsUpdated"`
+	GroupsDeleted int      `json:"groupsDeleted"`
+	RemovedUsers  []string `json:"removedUsers"`
+	Errors        []string `json:"errors"`
+}
+
+type ThemePresetDto struct {
+	Name              string `json:"name"`
+	AccentColor       string `json:"accentColor"`
+	DisableAnimations bool   `json:"disableAnimations"`
+}
+
+type AccentColorContrastDto struct {
+	AccentColor             string  `json:"accentColor"`
+	Foreground              string  `json:"foreground"`
+	ForegroundContrast      float64 `json:"foregroundContrast"`
+	LightBackgroundContrast float64 `json:"lightBackgroundContrast"`
+	DarkBackgroundContrast  float64 `json:"darkBackgroundContrast"`
+	MeetsWcagAA             bool    `json:"meetsWcagAA"`
+}
+
+// LocalesDto contains the locales users can select, for the language pickers of the UI
+type LocalesDto struct {
+	DefaultLocale    string   `json:"defaultLocale"`
+	SupportedLocales []string `json:"supportedLocales"`
 }
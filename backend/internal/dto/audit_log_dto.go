@@ -23,4 +23,5 @@ type AuditLogFilterDto struct {
 	Event      string `form:"filters[event]"`
 	ClientName string `form:"filters[clientName]"`
 	Location   string `form:"filters[location]"`
+	Country    string `form:"filters[country]"`
 }
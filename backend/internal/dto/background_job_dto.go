@@ -0,0 +1,29 @@
+package dto
+
+import (
+	"encoding/json"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+type BackgroundJobDto struct {
+	ID            string             `json:"id"`
+	CreatedAt     datatype.DateTime  `json:"createdAt"`
+	Type          string             `json:"type"`
+	Status        string             `json:"status"`
+	ActorUserID   *string            `json:"actorUserId"`
+	ProgressDone  int                `json:"progressDone"`
+	ProgressTotal int                `json:"progressTotal"`
+	Error         *string            `json:"error"`
+	Cancellable   bool               `json:"cancellable"`
+	StartedAt     *datatype.DateTime `json:"startedAt"`
+	FinishedAt    *datatype.DateTime `json:"finishedAt"`
+	// Result of the job if it succeeded, whose format depends on the type
+	Result json.RawMessage `json:"result,omitempty"`
+}
+
+type BackgroundJobSubmitDto struct {
+	Type string `json:"type" binding:"required"`
+	// Input of the job, whose format depends on the type
+	Payload json.RawMessage `json:"payload"`
+}
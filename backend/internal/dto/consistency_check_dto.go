@@ -0,0 +1,11 @@
+package dto
+
+type ConsistencyCheckCategoryDto struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+type ConsistencyCheckReportDto struct {
+	Cleaned    bool                          `json:"cleaned"`
+	Categories []ConsistencyCheckCategoryDto `json:"categories"`
+}
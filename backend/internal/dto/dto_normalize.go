@@ -46,6 +46,9 @@ loop:
 		}
 
 		fv := v.Field(i)
+		if fv.Kind() == reflect.Ptr && !fv.IsNil() {
+			fv = fv.Elem()
+		}
 		if !fv.CanSet() || fv.Kind() != reflect.String {
 			continue
 		}
@@ -11,10 +11,12 @@ type testDto struct {
 	Name        string `unorm:"nfc"`
 	Description string `unorm:"nfd"`
 	Other       string
-	BadForm     string `unorm:"bad"`
+	BadForm     string  `unorm:"bad"`
+	Nickname    *string `unorm:"nfd"`
 }
 
 func TestNormalize(t *testing.T) {
+	nickname := norm.NFC.String("Zoë")
 	input := testDto{
 		// Is in NFC form already
 		Name: norm.NFC.String("Café"),
@@ -24,6 +26,8 @@ func TestNormalize(t *testing.T) {
 		Other: "NöTag",
 		// Should be unchanged
 		BadForm: "BåD",
+		// Pointers to strings are normalized as well
+		Nickname: &nickname,
 	}
 
 	Normalize(&input)
@@ -32,6 +36,7 @@ func TestNormalize(t *testing.T) {
 	assert.Equal(t, norm.NFD.String("vërø"), input.Description)
 	assert.Equal(t, "NöTag", input.Other)
 	assert.Equal(t, "BåD", input.BadForm)
+	assert.Equal(t, norm.NFD.String("Zoë"), *input.Nickname)
 }
 
 func TestNormalizeSlice(t *testing.T) {
@@ -0,0 +1,24 @@
+package dto
+
+import (
+	"encoding/json"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+type ImportReportDto struct {
+	ID          string            `json:"id"`
+	CreatedAt   datatype.DateTime `json:"createdAt"`
+	ExpiresAt   datatype.DateTime `json:"expiresAt"`
+	Type        string            `json:"type"`
+	ActorUserID *string           `json:"actorUserId"`
+	Committed   bool              `json:"committed"`
+	TotalCount  int               `json:"totalCount"`
+	FailedCount int               `json:"failedCount"`
+}
+
+// ImportReportDownloadDto is the downloaded report, with the outcome of every row of the import
+type ImportReportDownloadDto struct {
+	ImportReportDto
+	Entries json.RawMessage `json:"entries"`
+}
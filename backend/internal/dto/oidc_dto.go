@@ -1,5 +1,9 @@
 package dto
 
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
 type OidcClientMetaDataDto struct {
 	ID      string `json:"id"`
 	Name    string `json:"name"`
@@ -13,6 +17,18 @@ type OidcClientDto struct {
 	IsPublic           bool                     `json:"isPublic"`
 	PkceEnabled        bool                     `json:"pkceEnabled"`
 	Credentials        OidcClientCredentialsDto `json:"credentials"`
+	GrantTypes         []string                 `json:"grantTypes"`
+	// Scopes of the access tokens issued with the client_credentials grant
+	ClientCredentialsScopes []string `json:"clientCredentialsScopes"`
+	// Additional audiences of the issued access tokens
+	Audiences []string `json:"audiences"`
+	// URL of the JWK set used to verify signed request objects
+	JwksURL                    string `json:"jwksUrl"`
+	RequireSignedRequestObject bool   `json:"requireSignedRequestObject"`
+	LoginBrandingEnabled       bool   `json:"loginBrandingEnabled"`
+	RequireEmail               bool   `json:"requireEmail"`
+	SubjectType                string `json:"subjectType"`
+	PairwiseSectorIdentifier   string `json:"pairwiseSectorIdentifier"`
 }
 
 type OidcClientWithAllowedUserGroupsDto struct {
@@ -20,6 +36,12 @@ type OidcClientWithAllowedUserGroupsDto struct {
 	AllowedUserGroups []UserGroupDtoWithUserCount `json:"allowedUserGroups"`
 }
 
+type OidcClientCloneDto struct {
+	OidcClientWithAllowedUserGroupsDto
+	// Secret of the clone, only returned once and empty for public clients
+	Secret string `json:"secret,omitempty"`
+}
+
 type OidcClientWithAllowedGroupsCountDto struct {
 	OidcClientDto
 	AllowedUserGroupsCount int64 `json:"allowedUserGroupsCount"`
@@ -32,6 +54,24 @@ type OidcClientCreateDto struct {
 	IsPublic           bool                     `json:"isPublic"`
 	PkceEnabled        bool                     `json:"pkceEnabled"`
 	Credentials        OidcClientCredentialsDto `json:"credentials"`
+	// Grant types the client can use; if omitted, new clients can use authorization_code and refresh_token, and existing clients keep their grant types
+	GrantTypes []string `json:"grantTypes" binding:"omitempty,dive,oneof=authorization_code refresh_token client_credentials urn:ietf:params:oauth:grant-type:device_code urn:ietf:params:oauth:grant-type:token-exchange"`
+	// Scopes of the access tokens issued with the client_credentials grant
+	ClientCredentialsScopes []string `json:"clientCredentialsScopes" binding:"omitempty,dive,min=1,max=100,excludesall= "`
+	// Additional audiences of the issued access tokens, e.g. the identifiers of the resource servers; the client ID is always included
+	Audiences []string `json:"audiences" binding:"omitempty,dive,min=1,max=255"`
+	// URL of the JWK set used to verify signed request objects; if omitted, existing clients keep theirs
+	JwksURL *string `json:"jwksUrl" binding:"omitempty,url"`
+	// If true, the authorization parameters must be sent in a signed request object; if omitted, existing clients keep their setting
+	RequireSignedRequestObject *bool `json:"requireSignedRequestObject"`
+	// If true, the name and logo of the client are shown on the login pages; if omitted, new clients enable it and existing clients keep their setting
+	LoginBrandingEnabled *bool `json:"loginBrandingEnabled"`
+	// If true, users without an email address can't authorize the client; if omitted, new clients don't require it and existing clients keep their setting
+	RequireEmail *bool `json:"requireEmail"`
+	// "public" to use the user ID as subject, or "pairwise" to derive a different subject for every sector; if omitted, new clients are public and existing clients keep their setting
+	SubjectType *string `json:"subjectType" binding:"omitempty,oneof=public pairwise"`
+	// Clients with the same sector identifier get the same pairwise subjects; if empty, the client ID is used
+	PairwiseSectorIdentifier *string `json:"pairwiseSectorIdentifier" binding:"omitempty,max=255"`
 }
 
 type OidcClientCredentialsDto struct {
@@ -45,6 +85,24 @@ type OidcClientFederatedIdentityDto struct {
 	JWKS     string `json:"jwks,omitempty"`
 }
 
+// OidcClientFederatedIdentityCreateDto adds a federated identity to a client
+type OidcClientFederatedIdentityCreateDto struct {
+	Issuer string `json:"issuer" binding:"required,max=255"`
+	// Expected subject of the client assertions; defaults to the client ID
+	Subject string `json:"subject" binding:"max=255"`
+	// Expected audience of the client assertions; defaults to the Pocket ID's URL
+	Audience string `json:"audience" binding:"max=255"`
+	// URL of the JWK set of the issuer; defaults to the well-known URL of the issuer
+	JWKS string `json:"jwks" binding:"omitempty,url"`
+}
+
+// OidcClientFederatedIdentityDeleteDto identifies the federated identity of a client to remove; the subject and the audience must match exactly, including when they're empty
+type OidcClientFederatedIdentityDeleteDto struct {
+	Issuer   string `form:"issuer" binding:"required"`
+	Subject  string `form:"subject"`
+	Audience string `form:"audience"`
+}
+
 type AuthorizeOidcClientRequestDto struct {
 	ClientID            string `json:"clientID" binding:"required"`
 	Scope               string `json:"scope" binding:"required"`
@@ -52,12 +110,28 @@ type AuthorizeOidcClientRequestDto struct {
 	Nonce               string `json:"nonce"`
 	CodeChallenge       string `json:"codeChallenge"`
 	CodeChallengeMethod string `json:"codeChallengeMethod"`
+	// Space-separated list of prompts; with "none", an error is returned instead of asking the user to sign in or consent
+	Prompt string `json:"prompt"`
+	// Maximum time in seconds since the user signed in; if it's exceeded, the user has to sign in again
+	MaxAge *int `json:"maxAge" binding:"omitempty,min=0"`
+	// Space-separated list of ACR values; the user has to sign in with a method that satisfies one of them
+	AcrValues string `json:"acrValues"`
+	// Resource servers (RFC 8707) the access tokens are requested for; they must be in the audiences of the client
+	Resource []string `json:"resource"`
+	// Signed JWT with the authorization parameters (RFC 9101); its parameters replace the ones above
+	Request string `json:"request"`
+	// URL from which the signed JWT with the authorization parameters is fetched, instead of passing it in Request
+	RequestURI string `json:"requestUri"`
 }
 
 type AuthorizeOidcClientResponseDto struct {
 	Code        string `json:"code"`
 	CallbackURL string `json:"callbackURL"`
 	Issuer      string `json:"issuer"`
+	// Error is set instead of the code if silent authentication failed or a resource isn't allowed, and must be sent to the callback URL
+	Error string `json:"error,omitempty"`
+	// ReauthenticationRequired is set if the user has to sign in again because of the max_age or acr_values parameters
+	ReauthenticationRequired bool `json:"reauthenticationRequired,omitempty"`
 }
 
 type AuthorizationRequiredDto struct {
@@ -75,12 +149,37 @@ type OidcCreateTokensDto struct {
 	RefreshToken        string `form:"refresh_token"`
 	ClientAssertion     string `form:"client_assertion"`
 	ClientAssertionType string `form:"client_assertion_type"`
+	// Space-separated scopes requested with the client_credentials grant
+	Scope string `form:"scope"`
+	// Resource servers (RFC 8707) and audiences the access token is requested for; they must be in the audiences of the client
+	Resource []string `form:"resource"`
+	Audience []string `form:"audience"`
+	// Parameters of the token exchange grant (RFC 8693)
+	SubjectToken       string `form:"subject_token"`
+	SubjectTokenType   string `form:"subject_token_type"`
+	ActorToken         string `form:"actor_token"`
+	ActorTokenType     string `form:"actor_token_type"`
+	RequestedTokenType string `form:"requested_token_type"`
 }
 
 type OidcIntrospectDto struct {
 	Token string `form:"token" binding:"required"`
 }
 
+type OidcRevokeClientTokensDto struct {
+	// If true, the access tokens issued until now are rejected until they expire
+	RevokeAccessTokens bool `json:"revokeAccessTokens"`
+	// If true, the authorizations of the users are deleted, so they have to consent again
+	RequireConsent bool `json:"requireConsent"`
+}
+
+type OidcRevokeClientTokensResponseDto struct {
+	RefreshTokens      int64 `json:"refreshTokens"`
+	AuthorizationCodes int64 `json:"authorizationCodes"`
+	DeviceCodes        int64 `json:"deviceCodes"`
+	Authorizations     int64 `json:"authorizations"`
+}
+
 type OidcUpdateAllowedUserGroupsDto struct {
 	UserGroupIDs []string `json:"userGroupIds" binding:"required"`
 }
@@ -98,6 +197,9 @@ type OidcTokenResponseDto struct {
 	IdToken      string `json:"id_token,omitempty"`
 	RefreshToken string `json:"refresh_token,omitempty"`
 	ExpiresIn    int    `json:"expires_in"`
+	Scope        string `json:"scope,omitempty"`
+	// IssuedTokenType is only returned for the token exchange grant
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
 }
 
 type OidcIntrospectionResponseDto struct {
@@ -111,6 +213,8 @@ type OidcIntrospectionResponseDto struct {
 	Audience   []string `json:"aud,omitempty"`
 	Issuer     string   `json:"iss,omitempty"`
 	Identifier string   `json:"jti,omitempty"`
+	// Actor is the current actor of tokens issued with the token exchange grant, with the previous actors nested
+	Actor map[string]any `json:"act,omitempty"`
 }
 
 type OidcDeviceAuthorizationRequestDto struct {
@@ -149,8 +253,45 @@ type AuthorizedOidcClientDto struct {
 	Client OidcClientMetaDataDto `json:"client"`
 }
 
+// OidcSessionDto is a session of a user with an OIDC client; the refresh token itself is never included
+type OidcSessionDto struct {
+	ID        string                `json:"id"`
+	Client    OidcClientMetaDataDto `json:"client"`
+	Scope     string                `json:"scope"`
+	StartedAt datatype.DateTime     `json:"startedAt"`
+	// LastUsedAt is when the tokens were last refreshed, or when the session started
+	LastUsedAt datatype.DateTime `json:"lastUsedAt"`
+	ExpiresAt  datatype.DateTime `json:"expiresAt"`
+	IpAddress  *string           `json:"ipAddress"`
+	Country    string            `json:"country"`
+	City       string            `json:"city"`
+}
+
 type OidcClientPreviewDto struct {
+	Scopes      []string       `json:"scopes"`
 	IdToken     map[string]any `json:"idToken"`
 	AccessToken map[string]any `json:"accessToken"`
 	UserInfo    map[string]any `json:"userInfo"`
+	// ClaimSources describes where each claim of the userinfo comes from, e.g. a scope or a custom claim of a group
+	ClaimSources map[string]string `json:"claimSources"`
+}
+
+type OidcClientValidateDto struct {
+	OidcClientCreateDto
+	AllowedUserGroupIDs []string `json:"allowedUserGroupIds"`
+	// Space-separated scopes the client is expected to request
+	Scope string `json:"scope"`
+}
+
+type OidcClientValidationResultDto struct {
+	// Valid is false if at least one issue is an error
+	Valid  bool                           `json:"valid"`
+	Issues []OidcClientValidationIssueDto `json:"issues"`
+}
+
+type OidcClientValidationIssueDto struct {
+	// Severity is "error" if the configuration won't work, or "warning" if it may not work as expected
+	Severity string `json:"severity"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
 }
@@ -0,0 +1,355 @@
+package dto
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// openAPISchemaTypes contains the DTOs that are described in the OpenAPI document.
+// DTOs referenced by these ones are added automatically.
+var openAPISchemaTypes = []any{
+	ApiKeyCreateDto{},
+	ApiKeyResponseDto{},
+	AppConfigUpdateDto{},
+	AppConfigVariableDto{},
+	LocalesDto{},
+	AccentColorContrastDto{},
+	LdapSyncResultDto{},
+	ThemePresetDto{},
+	AuditLogDto{},
+	CustomClaimCreateDto{},
+	OidcClientCreateDto{},
+	OidcClientWithAllowedUserGroupsDto{},
+	OidcClientWithAllowedGroupsCountDto{},
+	AuthorizeOidcClientRequestDto{},
+	AuthorizeOidcClientResponseDto{},
+	OidcCreateTokensDto{},
+	OidcTokenResponseDto{},
+	OidcIntrospectionResponseDto{},
+	OidcDeviceAuthorizationRequestDto{},
+	OidcDeviceAuthorizationResponseDto{},
+	OidcUpdateAllowedUserGroupsDto{},
+	AuthorizedOidcClientDto{},
+	OidcClientPreviewDto{},
+	OidcClientValidateDto{},
+	OidcClientValidationResultDto{},
+	SignupTokenCreateDto{},
+	SignupTokenDto{},
+	UserDto{},
+	UserProjectionDto{},
+	UserCreateDto{},
+	SignUpDto{},
+	OneTimeAccessTokenCreateDto{},
+	OneTimeAccessTokenPeekDto{},
+	OneTimeAccessEmailAsUnauthenticatedUserDto{},
+	OneTimeAccessEmailAsAdminDto{},
+	AccountDeletionConfirmDto{},
+	UserUpdateUserGroupDto{},
+	UserGroupCreateDto{},
+	UserGroupDtoWithUsers{},
+	UserGroupDtoWithUserCount{},
+	UserGroupUpdateUsersDto{},
+	UserGroupBulkAssignDto{},
+	UserGroupBulkAssignResultDto{},
+	UserGroupImportDto{},
+	UserGroupImportResultDto{},
+	ImportReportDto{},
+	ImportReportDownloadDto{},
+	BackgroundJobDto{},
+	BackgroundJobSubmitDto{},
+	WebauthnCredentialDto{},
+	WebauthnCredentialUpdateDto{},
+	NotificationPreferenceDto{},
+	NotificationPreferencesUpdateDto{},
+}
+
+var (
+	timeType     = reflect.TypeFor[time.Time]()
+	dateTimeType = reflect.TypeFor[datatype.DateTime]()
+)
+
+// GenerateOpenAPISpec generates an OpenAPI document describing the routes and the DTOs of the API.
+// The constraints of the "binding" struct tags are translated to the corresponding schema validations,
+// so that clients can validate their requests with the same rules that are enforced by the server.
+func GenerateOpenAPISpec(routes gin.RoutesInfo) ([]byte, error) {
+	schemas := make(map[string]any, len(openAPISchemaTypes))
+	for _, t := range openAPISchemaTypes {
+		openAPISchemaForStruct(reflect.TypeOf(t), schemas)
+	}
+
+	spec := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Pocket ID API",
+			"version": common.Version,
+		},
+		"servers": []map[string]any{
+			{"url": common.EnvConfig.AppURL},
+		},
+		"paths": openAPIPaths(routes),
+		"components": map[string]any{
+			"schemas": schemas,
+		},
+	}
+
+	return json.Marshal(spec)
+}
+
+// openAPIPaths describes the routes of the API and the well-known endpoints.
+// The routes don't tell which DTOs are used by the handlers, so only the path parameters are described.
+func openAPIPaths(routes gin.RoutesInfo) map[string]any {
+	paths := map[string]any{}
+	operationIDs := map[string]int{}
+	for _, route := range routes {
+		if !strings.HasPrefix(route.Path, "/api/") && !strings.HasPrefix(route.Path, "/.well-known/") {
+			continue
+		}
+
+		path, parameters := openAPIPathAndParameters(route.Path)
+		operations, ok := paths[path].(map[string]any)
+		if !ok {
+			operations = map[string]any{}
+			paths[path] = operations
+		}
+
+		operation := map[string]any{
+			"responses": map[string]any{
+				"default": map[string]any{"description": "Response of the endpoint"},
+			},
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		tag, operationID := openAPIOperationName(route.Handler)
+		if operationID != "" {
+			// Operation IDs must be unique, but a handler can be registered for multiple routes
+			operationIDs[operationID]++
+			if n := operationIDs[operationID]; n > 1 {
+				operationID += strconv.Itoa(n)
+			}
+			operation["operationId"] = operationID
+			operation["tags"] = []string{tag}
+		}
+
+		operations[strings.ToLower(route.Method)] = operation
+	}
+	return paths
+}
+
+// openAPIPathAndParameters converts the gin path parameters like ":id" or "*path" to the OpenAPI syntax
+func openAPIPathAndParameters(ginPath string) (string, []map[string]any) {
+	segments := strings.Split(ginPath, "/")
+	var parameters []map[string]any
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") && !strings.HasPrefix(segment, "*") {
+			continue
+		}
+
+		name := segment[1:]
+		segments[i] = "{" + name + "}"
+		parameters = append(parameters, map[string]any{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]any{"type": "string"},
+		})
+	}
+	return strings.Join(segments, "/"), parameters
+}
+
+// openAPIOperationName derives the tag and the operation ID from the name of the handler,
+// e.g. "github.com/.../controller.(*UserController).listUsersHandler-fm" becomes "User" and "listUsers"
+func openAPIOperationName(handler string) (tag string, operationID string) {
+	handler = handler[strings.LastIndex(handler, "/")+1:]
+
+	start := strings.Index(handler, "(*")
+	end := strings.Index(handler, ").")
+	if start == -1 || end < start {
+		return "", ""
+	}
+
+	tag = strings.TrimSuffix(handler[start+2:end], "Controller")
+	operationID = strings.TrimSuffix(handler[end+2:], "-fm")
+	operationID = strings.TrimSuffix(operationID, "Handler")
+	return tag, operationID
+}
+
+// openAPISchemaForStruct adds the schema of the struct to the schemas map, and returns a reference to it
+func openAPISchemaForStruct(t reflect.Type, schemas map[string]any) map[string]any {
+	ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	if _, ok := schemas[t.Name()]; ok {
+		return ref
+	}
+
+	properties := map[string]any{}
+	schema := map[string]any{
+		"type":       "object",
+		"properties": properties,
+	}
+
+	// Register the schema before processing the fields, to support recursive types
+	schemas[t.Name()] = schema
+
+	var required []string
+	addOpenAPIProperties(t, properties, &required, schemas)
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return ref
+}
+
+func addOpenAPIProperties(t reflect.Type, properties map[string]any, required *[]string, schemas map[string]any) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+
+		// Embedded structs have their fields added to the parent
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addOpenAPIProperties(field.Type, properties, required, schemas)
+			continue
+		}
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name := openAPIFieldName(field)
+		if name == "" {
+			continue
+		}
+
+		property := openAPISchemaForType(field.Type, schemas)
+		if applyOpenAPIBindingRules(field, property) {
+			*required = append(*required, name)
+		}
+		properties[name] = property
+	}
+}
+
+func openAPIFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "" {
+		// DTOs that are bound from forms only have the "form" tag
+		tag = field.Tag.Get("form")
+	}
+	name, _, _ := strings.Cut(tag, ",")
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func openAPISchemaForType(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == timeType || t == dateTimeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := openAPISchemaForType(t.Elem(), schemas)
+		if _, isRef := schema["$ref"]; isRef {
+			// Siblings of $ref are ignored in OpenAPI 3.0
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+		return schema
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": openAPISchemaForType(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": openAPISchemaForType(t.Elem(), schemas)}
+	case reflect.Struct:
+		return openAPISchemaForStruct(t, schemas)
+	default:
+		return map[string]any{}
+	}
+}
+
+// applyOpenAPIBindingRules translates the validation rules in the "binding" tag to the schema.
+// It returns true if the field is required.
+func applyOpenAPIBindingRules(field reflect.StructField, schema map[string]any) (isRequired bool) {
+	tag := field.Tag.Get("binding")
+	if tag == "" {
+		return false
+	}
+
+	kind := field.Type.Kind()
+	if kind == reflect.Pointer {
+		kind = field.Type.Elem().Kind()
+	}
+
+	for rule := range strings.SplitSeq(tag, ",") {
+		name, param, _ := strings.Cut(rule, "=")
+		switch name {
+		case "dive":
+			// The following rules apply to the elements of the collection, which we don't describe
+			return isRequired
+		case "required":
+			isRequired = true
+		case "email":
+			schema["format"] = "email"
+		case "url":
+			schema["format"] = "uri"
+		case "uuid":
+			schema["format"] = "uuid"
+		case "username":
+			schema["pattern"] = validateUsernameRegex.String()
+		case "oneof":
+			schema["enum"] = strings.Fields(param)
+		case "eq":
+			schema["enum"] = []string{param}
+		case "min", "max", "len":
+			setOpenAPILengthRule(kind, name, param, schema)
+		}
+	}
+
+	return isRequired
+}
+
+func setOpenAPILengthRule(kind reflect.Kind, rule string, param string, schema map[string]any) {
+	value, err := strconv.ParseFloat(param, 64)
+	if err != nil {
+		return
+	}
+
+	var minKey, maxKey string
+	switch kind {
+	case reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case reflect.Slice, reflect.Array, reflect.Map:
+		minKey, maxKey = "minItems", "maxItems"
+	default:
+		minKey, maxKey = "minimum", "maximum"
+	}
+
+	switch rule {
+	case "min":
+		schema[minKey] = value
+	case "max":
+		schema[maxKey] = value
+	case "len":
+		schema[minKey] = value
+		schema[maxKey] = value
+	}
+}
@@ -0,0 +1,93 @@
+package dto
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateOpenAPISpec(t *testing.T) {
+	specJSON, err := GenerateOpenAPISpec(gin.RoutesInfo{
+		{Method: "GET", Path: "/api/users", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*UserController).listUsersHandler-fm"},
+		{Method: "PUT", Path: "/api/users/:id", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*UserController).updateUserHandler-fm"},
+		{Method: "GET", Path: "/api/users/:id", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*UserController).getUserHandler-fm"},
+		{Method: "GET", Path: "/api/users/me", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*UserController).getUserHandler-fm"},
+		{Method: "GET", Path: "/.well-known/jwks.json", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*WellKnownController).jwksHandler-fm"},
+		{Method: "GET", Path: "/healthz", Handler: "github.com/pocket-id/pocket-id/backend/internal/controller.(*HealthzController).healthzHandler-fm"},
+	})
+	require.NoError(t, err)
+
+	var spec struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Type       string                    `json:"type"`
+				Required   []string                  `json:"required"`
+				Properties map[string]map[string]any `json:"properties"`
+			} `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.Unmarshal(specJSON, &spec)
+	require.NoError(t, err)
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+
+	t.Run("describes the routes", func(t *testing.T) {
+		assert.ElementsMatch(t, []string{"/api/users", "/api/users/{id}", "/api/users/me", "/.well-known/jwks.json"}, slices.Collect(maps.Keys(spec.Paths)))
+
+		listUsers := spec.Paths["/api/users"]["get"]
+		assert.Equal(t, "listUsers", listUsers["operationId"])
+		assert.Equal(t, []any{"User"}, listUsers["tags"])
+		assert.NotContains(t, listUsers, "parameters")
+
+		user := spec.Paths["/api/users/{id}"]
+		assert.Contains(t, user, "put")
+		assert.Equal(t, []any{
+			map[string]any{"name": "id", "in": "path", "required": true, "schema": map[string]any{"type": "string"}},
+		}, user["get"]["parameters"])
+
+		// Operation IDs stay unique if a handler is used for multiple routes
+		assert.Equal(t, "getUser", user["get"]["operationId"])
+		assert.Equal(t, "getUser2", spec.Paths["/api/users/me"]["get"]["operationId"])
+	})
+
+	t.Run("translates binding rules", func(t *testing.T) {
+		schema, ok := spec.Components.Schemas["UserCreateDto"]
+		require.True(t, ok)
+		assert.Equal(t, "object", schema.Type)
+		assert.ElementsMatch(t, []string{"username", "email", "firstName"}, schema.Required)
+
+		username := schema.Properties["username"]
+		assert.Equal(t, "string", username["type"])
+		assert.InDelta(t, 2, username["minLength"], 0)
+		assert.InDelta(t, 50, username["maxLength"], 0)
+		assert.Equal(t, validateUsernameRegex.String(), username["pattern"])
+
+		assert.Equal(t, "email", schema.Properties["email"]["format"])
+		assert.Equal(t, true, schema.Properties["locale"]["nullable"])
+
+		// Fields excluded from JSON must not be described
+		assert.NotContains(t, schema.Properties, "LdapID")
+	})
+
+	t.Run("translates oneof to enum", func(t *testing.T) {
+		schema := spec.Components.Schemas["AppConfigUpdateDto"]
+		assert.Equal(t, []any{"none", "starttls", "tls"}, schema.Properties["smtpTls"]["enum"])
+	})
+
+	t.Run("adds referenced DTOs", func(t *testing.T) {
+		assert.Contains(t, spec.Components.Schemas, "CustomClaimDto")
+		assert.Contains(t, spec.Components.Schemas, "UserGroupDto")
+	})
+
+	t.Run("uses form tags for form DTOs", func(t *testing.T) {
+		schema := spec.Components.Schemas["OidcCreateTokensDto"]
+		assert.Contains(t, schema.Properties, "grant_type")
+		assert.Contains(t, schema.Required, "grant_type")
+	})
+}
@@ -0,0 +1,35 @@
+package dto
+
+import "time"
+
+type ScheduledJobDto struct {
+	Name      string     `json:"name"`
+	Running   bool       `json:"running"`
+	LastRunAt *time.Time `json:"lastRunAt"`
+	NextRunAt *time.Time `json:"nextRunAt"`
+	LastError *string    `json:"lastError"`
+}
+
+type HealthzDetailsDto struct {
+	ReplicaID       string              `json:"replicaId"`
+	IsLeader        bool                `json:"isLeader"`
+	LeaderReplicaID string              `json:"leaderReplicaId"`
+	SigningKey      SigningKeyStatusDto `json:"signingKey"`
+	// ConfigGeneration is the generation of the application configuration loaded by the replica, which is the same on all replicas once they're in sync
+	ConfigGeneration int64 `json:"configGeneration"`
+	// Ldap is set only if LDAP is enabled
+	Ldap *LdapStatusDto `json:"ldap,omitempty"`
+}
+
+type LdapStatusDto struct {
+	CircuitBreakerState string     `json:"circuitBreakerState"`
+	ConsecutiveFailures int        `json:"consecutiveFailures"`
+	RetryAt             *time.Time `json:"retryAt"`
+}
+
+type SigningKeyStatusDto struct {
+	KeyID          string     `json:"keyId"`
+	CreatedAt      *time.Time `json:"createdAt"`
+	AgeSeconds     *int64     `json:"ageSeconds"`
+	NextRotationAt *time.Time `json:"nextRotationAt"`
+}
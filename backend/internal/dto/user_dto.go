@@ -2,6 +2,8 @@ package dto
 
 import (
 	"time"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
 )
 
 type UserDto struct {
@@ -16,6 +18,41 @@ type UserDto struct {
 	UserGroups   []UserGroupDto   `json:"userGroups"`
 	LdapID       *string          `json:"ldapId"`
 	Disabled     bool             `json:"disabled"`
+	LoginEmail   *string          `json:"loginEmail"`
+	// Time and IP address of the last sign in
+	LastLoginAt *datatype.DateTime `json:"lastLoginAt"`
+	LastLoginIP *string            `json:"lastLoginIp"`
+	// Set if the user must enroll a new passkey
+	PasskeyReenrollmentRequiredAt *datatype.DateTime `json:"passkeyReenrollmentRequiredAt"`
+	// Limits set for this user, overriding the ones of the app config
+	MaxApiKeys  *int `json:"maxApiKeys"`
+	MaxPasskeys *int `json:"maxPasskeys"`
+	// Number of active API keys and passkeys, only returned for a single user
+	ApiKeyCount  *int64 `json:"apiKeyCount,omitempty"`
+	PasskeyCount *int64 `json:"passkeyCount,omitempty"`
+}
+
+// UserProjectionDto is returned when only some fields of the users are selected.
+// Fields that weren't selected are omitted, as well as the ones that are null.
+type UserProjectionDto struct {
+	ID           string            `json:"id"`
+	Username     *string           `json:"username,omitempty"`
+	Email        *string           `json:"email,omitempty"`
+	FirstName    *string           `json:"firstName,omitempty"`
+	LastName     *string           `json:"lastName,omitempty"`
+	IsAdmin      *bool             `json:"isAdmin,omitempty"`
+	Locale       *string           `json:"locale,omitempty"`
+	LdapID       *string           `json:"ldapId,omitempty"`
+	Disabled     *bool             `json:"disabled,omitempty"`
+	CustomClaims *[]CustomClaimDto `json:"customClaims,omitempty"`
+	UserGroups   *[]UserGroupDto   `json:"userGroups,omitempty"`
+}
+
+type UserCredentialLimitsDto struct {
+	// An empty limit means the limit of the app config applies. Unlike in the app config, 0 isn't accepted,
+	// as it would be ambiguous between no limit and no credentials at all
+	MaxApiKeys  *int `json:"maxApiKeys" binding:"omitempty,min=1"`
+	MaxPasskeys *int `json:"maxPasskeys" binding:"omitempty,min=1"`
 }
 
 type UserCreateDto struct {
@@ -26,7 +63,10 @@ type UserCreateDto struct {
 	IsAdmin   bool    `json:"isAdmin"`
 	Locale    *string `json:"locale"`
 	Disabled  bool    `json:"disabled"`
-	LdapID    string  `json:"-"`
+	// LoginEmail is only used if separate login emails are enabled; if empty, the email is used to log in.
+	// If it's omitted when updating a user, the current login email is kept.
+	LoginEmail *string `json:"loginEmail" binding:"omitempty,eq=|email" unorm:"nfc"`
+	LdapID     string  `json:"-"`
 }
 
 type OneTimeAccessTokenCreateDto struct {
@@ -39,18 +79,45 @@ type OneTimeAccessEmailAsUnauthenticatedUserDto struct {
 	RedirectPath string `json:"redirectPath"`
 }
 
+type OneTimeAccessTokenPeekDto struct {
+	Valid bool `json:"valid"`
+}
+
 type OneTimeAccessEmailAsAdminDto struct {
 	ExpiresAt time.Time `json:"expiresAt" binding:"required"`
 }
 
+type AccountDeletionConfirmDto struct {
+	Token string `json:"token" binding:"required"`
+}
+
 type UserUpdateUserGroupDto struct {
 	UserGroupIds []string `json:"userGroupIds" binding:"required"`
 }
 
 type SignUpDto struct {
-	Username  string `json:"username" binding:"required,username,min=2,max=50" unorm:"nfc"`
+	// Username can be omitted if usernames are generated from the email address
+	Username  string `json:"username" binding:"omitempty,username,min=2,max=50" unorm:"nfc"`
 	Email     string `json:"email" binding:"required,email" unorm:"nfc"`
 	FirstName string `json:"firstName" binding:"required,min=1,max=50" unorm:"nfc"`
 	LastName  string `json:"lastName" binding:"max=50" unorm:"nfc"`
 	Token     string `json:"token"`
+	// SetupSecret is required to sign up the initial admin if the SETUP_SECRET env var is set
+	SetupSecret string `json:"setupSecret"`
+}
+
+type NotificationPreferenceDto struct {
+	Notification string `json:"notification"`
+	Enabled      bool   `json:"enabled"`
+	// Mandatory notifications can't be disabled by the user
+	Mandatory bool `json:"mandatory"`
+}
+
+type NotificationPreferenceUpdateDto struct {
+	Notification string `json:"notification" binding:"required,oneof=newLogin apiKeyExpiration"`
+	Enabled      bool   `json:"enabled"`
+}
+
+type NotificationPreferencesUpdateDto struct {
+	Preferences []NotificationPreferenceUpdateDto `json:"preferences" binding:"required,dive"`
 }
@@ -0,0 +1,15 @@
+package dto
+
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// UserExportRefreshTokenDto contains the metadata of a refresh token, without the token itself
+type UserExportRefreshTokenDto struct {
+	ID         string            `json:"id"`
+	ClientID   string            `json:"clientId"`
+	ClientName string            `json:"clientName"`
+	Scope      string            `json:"scope"`
+	CreatedAt  datatype.DateTime `json:"createdAt"`
+	ExpiresAt  datatype.DateTime `json:"expiresAt"`
+}
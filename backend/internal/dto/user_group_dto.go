@@ -35,10 +35,54 @@ type UserGroupDtoWithUserCount struct {
 
 type UserGroupCreateDto struct {
 	FriendlyName string `json:"friendlyName" binding:"required,min=2,max=50" unorm:"nfc"`
-	Name         string `json:"name" binding:"required,min=2,max=255" unorm:"nfc"`
-	LdapID       string `json:"-"`
+	// If empty, a name is generated from the friendly name when creating the group, or the current name is kept when updating it
+	Name   string `json:"name" binding:"omitempty,min=2,max=255" unorm:"nfc"`
+	LdapID string `json:"-"`
 }
 
 type UserGroupUpdateUsersDto struct {
 	UserIDs []string `json:"userIds" binding:"required"`
 }
+
+type UserGroupBulkAssignDto struct {
+	UserIDs []string `json:"userIds" binding:"required,min=1,max=1000"`
+	// "add" to add the users to the group, or "remove" to remove them
+	Action string `json:"action" binding:"required,oneof=add remove"`
+}
+
+type UserGroupBulkAssignResultDto struct {
+	UserID string `json:"userId"`
+	Status string `json:"status"`
+}
+
+type UserGroupImportDto struct {
+	Groups []UserGroupImportGroupDto `json:"groups" binding:"required,min=1,max=1000,dive"`
+}
+
+type UserGroupImportGroupDto struct {
+	Name         string `json:"name" binding:"required,min=2,max=255" unorm:"nfc"`
+	FriendlyName string `json:"friendlyName" binding:"required,min=2,max=50" unorm:"nfc"`
+	// Usernames or emails of the members
+	Members []string `json:"members" binding:"max=10000"`
+}
+
+type UserGroupImportResultDto struct {
+	// Committed is false if the import was all-or-nothing and at least one group couldn't be imported
+	Committed bool                            `json:"committed"`
+	Groups    []UserGroupImportGroupResultDto `json:"groups"`
+	// ID of the stored report, which can be downloaded until it expires
+	ReportID string `json:"reportId"`
+}
+
+type UserGroupImportGroupResultDto struct {
+	// Indexes of the rows of the imported file with this group, starting at 0 and not counting the CSV header
+	RowIndexes []int  `json:"rowIndexes"`
+	Name       string `json:"name"`
+	// Friendly name of the group after the import, which is kept for existing groups
+	FriendlyName      string   `json:"friendlyName"`
+	Status            string   `json:"status"`
+	AddedMembers      int      `json:"addedMembers"`
+	AddedUsernames    []string `json:"addedUsernames"`
+	UnresolvedMembers []string `json:"unresolvedMembers"`
+	Error             string   `json:"error,omitempty"`
+}
@@ -7,6 +7,8 @@ import (
 
 	"github.com/gin-gonic/gin/binding"
 	"github.com/go-playground/validator/v10"
+
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
 // [a-zA-Z0-9]      : The username must start with an alphanumeric character
@@ -18,6 +20,21 @@ var validateUsername validator.Func = func(fl validator.FieldLevel) bool {
 	return validateUsernameRegex.MatchString(fl.Field().String())
 }
 
+// validateAccentColor accepts either the "default" accent color or a color that can be parsed
+var validateAccentColor validator.Func = func(fl validator.FieldLevel) bool {
+	value := fl.Field().String()
+	if value == "default" {
+		return true
+	}
+	_, err := utils.ParseColor(value)
+	return err == nil
+}
+
+// ValidateStruct validates the struct against its binding constraints, like the request bodies bound by the controllers
+func ValidateStruct(obj any) error {
+	return binding.Validator.ValidateStruct(obj)
+}
+
 func init() {
 	v, _ := binding.Validator.Engine().(*validator.Validate)
 	err := v.RegisterValidation("username", validateUsername)
@@ -26,4 +43,11 @@ func init() {
 		os.Exit(1)
 		return
 	}
+
+	err = v.RegisterValidation("accentcolor", validateAccentColor)
+	if err != nil {
+		slog.Error("Failed to register custom validation", slog.Any("error", err))
+		os.Exit(1)
+		return
+	}
 }
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+type AuditLogArchiveJobs struct {
+	auditLogArchiveService *service.AuditLogArchiveService
+}
+
+func (s *Scheduler) RegisterAuditLogArchiveJob(ctx context.Context, auditLogArchiveService *service.AuditLogArchiveService) error {
+	// Skip if audit logs aren't archived
+	if auditLogArchiveService == nil {
+		return nil
+	}
+
+	jobs := &AuditLogArchiveJobs{auditLogArchiveService: auditLogArchiveService}
+
+	// Run every 24 hours (but with some jitter so it doesn't run at the exact same time as the other jobs), and now
+	def := gocron.DurationRandomJob(24*time.Hour-2*time.Minute, 24*time.Hour+2*time.Minute)
+	return s.registerJob(ctx, "ArchiveAuditLogs", def, jobs.archiveAuditLogs, true)
+}
+
+func (j *AuditLogArchiveJobs) archiveAuditLogs(ctx context.Context) error {
+	_, err := j.auditLogArchiveService.ArchiveOldAuditLogs(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to archive audit logs: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+type AuditLogLocationJobs struct {
+	auditLogService *service.AuditLogService
+}
+
+func (s *Scheduler) RegisterAuditLogLocationJob(ctx context.Context, auditLogService *service.AuditLogService) error {
+	jobs := &AuditLogLocationJobs{auditLogService: auditLogService}
+
+	// Run every hour, and now, so entries created while the GeoLite database wasn't available get their location soon
+	return s.registerJob(ctx, "ResolveAuditLogLocations", gocron.DurationJob(time.Hour), jobs.resolveMissingLocations, true)
+}
+
+// resolveMissingLocations looks up the locations of the audit log entries that were created without one
+func (j *AuditLogLocationJobs) resolveMissingLocations(ctx context.Context) error {
+	count, err := j.auditLogService.ResolveMissingLocations(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to resolve audit log locations: %w", err)
+	}
+
+	if count > 0 {
+		slog.InfoContext(ctx, "Resolved locations of audit logs", slog.Int("ipAddresses", count))
+	}
+	return nil
+}
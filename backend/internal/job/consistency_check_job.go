@@ -0,0 +1,37 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+func (s *Scheduler) RegisterConsistencyCheckJob(ctx context.Context, consistencyCheckService *service.ConsistencyCheckService) error {
+	jobs := &ConsistencyCheckJob{consistencyCheckService: consistencyCheckService}
+
+	// Run every 24 hours, with some jitter
+	def := gocron.DurationRandomJob(24*time.Hour-10*time.Minute, 24*time.Hour+10*time.Minute)
+	return errors.Join(
+		s.registerJob(ctx, "CleanOrphanedData", def, jobs.cleanOrphanedData, false),
+		// The files are stored in the upload directory of each replica
+		s.registerReplicaJob(ctx, "CleanOrphanedFiles", def, jobs.cleanOrphanedFiles, false),
+	)
+}
+
+type ConsistencyCheckJob struct {
+	consistencyCheckService *service.ConsistencyCheckService
+}
+
+// cleanOrphanedData deletes rows that reference users or OIDC clients that don't exist anymore
+func (j *ConsistencyCheckJob) cleanOrphanedData(ctx context.Context) error {
+	return j.consistencyCheckService.CleanOrphanedRows(ctx)
+}
+
+// cleanOrphanedFiles deletes the profile pictures and client logos of users or OIDC clients that don't exist anymore
+func (j *ConsistencyCheckJob) cleanOrphanedFiles(ctx context.Context) error {
+	return j.consistencyCheckService.CleanOrphanedFiles(ctx)
+}
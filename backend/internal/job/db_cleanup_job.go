@@ -10,8 +10,11 @@ import (
 	"github.com/go-co-op/gocron/v2"
 	"gorm.io/gorm"
 
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
 func (s *Scheduler) RegisterDbCleanupJobs(ctx context.Context, db *gorm.DB) error {
@@ -22,10 +25,17 @@ func (s *Scheduler) RegisterDbCleanupJobs(ctx context.Context, db *gorm.DB) erro
 	return errors.Join(
 		s.registerJob(ctx, "ClearWebauthnSessions", def, jobs.clearWebauthnSessions, true),
 		s.registerJob(ctx, "ClearOneTimeAccessTokens", def, jobs.clearOneTimeAccessTokens, true),
+		s.registerJob(ctx, "ClearOneTimeAccessFailures", def, jobs.clearOneTimeAccessFailures, true),
 		s.registerJob(ctx, "ClearSignupTokens", def, jobs.clearSignupTokens, true),
+		s.registerJob(ctx, "ClearAccountDeletionTokens", def, jobs.clearAccountDeletionTokens, true),
 		s.registerJob(ctx, "ClearOidcAuthorizationCodes", def, jobs.clearOidcAuthorizationCodes, true),
 		s.registerJob(ctx, "ClearOidcRefreshTokens", def, jobs.clearOidcRefreshTokens, true),
+		s.registerJob(ctx, "ClearOidcTokenExchanges", def, jobs.clearOidcTokenExchanges, true),
 		s.registerJob(ctx, "ClearAuditLogs", def, jobs.clearAuditLogs, true),
+		s.registerJob(ctx, "ClearPreviousApiKeys", def, jobs.clearPreviousApiKeys, true),
+		s.registerJob(ctx, "ClearImportReports", def, jobs.clearImportReports, true),
+		s.registerJob(ctx, "ClearBackgroundJobs", def, jobs.clearBackgroundJobs, true),
+		s.registerJob(ctx, "ClearOutboxMessages", def, jobs.clearOutboxMessages, true),
 	)
 }
 
@@ -33,30 +43,64 @@ type DbCleanupJobs struct {
 	db *gorm.DB
 }
 
+// exec runs a cleanup query with the query timeout of maintenance jobs, as the first cleanup in a long time can affect many rows,
+// and returns the number of affected rows
+func (j *DbCleanupJobs) exec(ctx context.Context, query func(tx *gorm.DB) *gorm.DB) (count int64, err error) {
+	timeout := common.EnvConfig.DbQueryTimeoutMaintenance
+	ctx = utils.WithQueryTimeout(ctx, timeout)
+
+	// The statement timeout of Postgres can only be overridden for a transaction
+	err = j.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := utils.SetLocalStatementTimeout(ctx, tx, timeout)
+		if err != nil {
+			return fmt.Errorf("failed to set the statement timeout: %w", err)
+		}
+
+		st := query(tx.WithContext(ctx))
+		count = st.RowsAffected
+		return st.Error
+	})
+	return count, err
+}
+
 // ClearWebauthnSessions deletes WebAuthn sessions that have expired
 func (j *DbCleanupJobs) clearWebauthnSessions(ctx context.Context) error {
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.WebauthnSession{}, "expires_at < ?", datatype.DateTime(time.Now()))
-	if st.Error != nil {
-		return fmt.Errorf("failed to clean expired WebAuthn sessions: %w", st.Error)
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.WebauthnSession{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired WebAuthn sessions: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Cleaned expired WebAuthn sessions", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned expired WebAuthn sessions", slog.Int64("count", count))
 
 	return nil
 }
 
 // ClearOneTimeAccessTokens deletes one-time access tokens that have expired
 func (j *DbCleanupJobs) clearOneTimeAccessTokens(ctx context.Context) error {
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.OneTimeAccessToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
-	if st.Error != nil {
-		return fmt.Errorf("failed to clean expired one-time access tokens: %w", st.Error)
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OneTimeAccessToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired one-time access tokens: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned expired one-time access tokens", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearOneTimeAccessFailures deletes failed one-time access attempts that no longer count towards a lockout
+func (j *DbCleanupJobs) clearOneTimeAccessFailures(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OneTimeAccessFailure{}, "created_at < ?", datatype.DateTime(time.Now().Add(-24*time.Hour)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean failed one-time access attempts: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Cleaned expired one-time access tokens", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned failed one-time access attempts", slog.Int64("count", count))
 
 	return nil
 }
@@ -64,56 +108,151 @@ func (j *DbCleanupJobs) clearOneTimeAccessTokens(ctx context.Context) error {
 // ClearSignupTokens deletes signup tokens that have expired
 func (j *DbCleanupJobs) clearSignupTokens(ctx context.Context) error {
 	// Delete tokens that are expired OR have reached their usage limit
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.SignupToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
-	if st.Error != nil {
-		return fmt.Errorf("failed to clean expired tokens: %w", st.Error)
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.SignupToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired tokens: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned expired tokens", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearAccountDeletionTokens deletes account deletion tokens that have expired
+func (j *DbCleanupJobs) clearAccountDeletionTokens(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.AccountDeletionToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired account deletion tokens: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Cleaned expired tokens", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned expired account deletion tokens", slog.Int64("count", count))
 
 	return nil
 }
 
 // ClearOidcAuthorizationCodes deletes OIDC authorization codes that have expired
 func (j *DbCleanupJobs) clearOidcAuthorizationCodes(ctx context.Context) error {
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.OidcAuthorizationCode{}, "expires_at < ?", datatype.DateTime(time.Now()))
-	if st.Error != nil {
-		return fmt.Errorf("failed to clean expired OIDC authorization codes: %w", st.Error)
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OidcAuthorizationCode{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired OIDC authorization codes: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Cleaned expired OIDC authorization codes", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned expired OIDC authorization codes", slog.Int64("count", count))
 
 	return nil
 }
 
 // ClearOidcAuthorizationCodes deletes OIDC authorization codes that have expired
 func (j *DbCleanupJobs) clearOidcRefreshTokens(ctx context.Context) error {
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.OidcRefreshToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
-	if st.Error != nil {
-		return fmt.Errorf("failed to clean expired OIDC refresh tokens: %w", st.Error)
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OidcRefreshToken{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired OIDC refresh tokens: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned expired OIDC refresh tokens", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearOidcTokenExchanges deletes the records of exchanged access tokens that have expired
+func (j *DbCleanupJobs) clearOidcTokenExchanges(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OidcTokenExchange{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired OIDC token exchanges: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Cleaned expired OIDC refresh tokens", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned expired OIDC token exchanges", slog.Int64("count", count))
 
 	return nil
 }
 
 // ClearAuditLogs deletes audit logs older than 90 days
 func (j *DbCleanupJobs) clearAuditLogs(ctx context.Context) error {
-	st := j.db.
-		WithContext(ctx).
-		Delete(&model.AuditLog{}, "created_at < ?", datatype.DateTime(time.Now().AddDate(0, 0, -90)))
-	if st.Error != nil {
-		return fmt.Errorf("failed to delete old audit logs: %w", st.Error)
+	// When audit logs are archived, they're deleted by the archive job once they've been stored
+	if common.EnvConfig.AuditLogArchiveStorage != "" {
+		return nil
+	}
+
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.AuditLog{}, "created_at < ?", datatype.DateTime(time.Now().AddDate(0, 0, -90)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete old audit logs: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Deleted old audit logs", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearPreviousApiKeys removes the previous secrets of rotated API keys whose grace period has ended
+func (j *DbCleanupJobs) clearPreviousApiKeys(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.
+			Model(&model.ApiKey{}).
+			Where("previous_key_expires_at < ?", datatype.DateTime(time.Now())).
+			Updates(map[string]any{
+				"previous_key":            nil,
+				"previous_key_expires_at": nil,
+			})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean previous API key secrets: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned previous API key secrets", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearImportReports deletes import reports that have expired
+func (j *DbCleanupJobs) clearImportReports(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.ImportReport{}, "expires_at < ?", datatype.DateTime(time.Now()))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean expired import reports: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned expired import reports", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearBackgroundJobs deletes background jobs that finished longer ago than the retention period
+func (j *DbCleanupJobs) clearBackgroundJobs(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.BackgroundJob{}, "finished_at < ?", datatype.DateTime(time.Now().Add(-service.BackgroundJobRetention)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean finished background jobs: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Cleaned finished background jobs", slog.Int64("count", count))
+
+	return nil
+}
+
+// ClearOutboxMessages deletes outbox messages that are older than the retention period, like the ones that failed at the maximum number of attempts
+func (j *DbCleanupJobs) clearOutboxMessages(ctx context.Context) error {
+	count, err := j.exec(ctx, func(tx *gorm.DB) *gorm.DB {
+		return tx.Delete(&model.OutboxMessage{}, "created_at < ?", datatype.DateTime(time.Now().Add(-service.OutboxMessageRetention)))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clean old outbox messages: %w", err)
 	}
 
-	slog.InfoContext(ctx, "Deleted old audit logs", slog.Int64("count", st.RowsAffected))
+	slog.InfoContext(ctx, "Cleaned old outbox messages", slog.Int64("count", count))
 
 	return nil
 }
@@ -12,22 +12,22 @@ import (
 	"github.com/go-co-op/gocron/v2"
 	"gorm.io/gorm"
 
-	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/service"
 )
 
 func (s *Scheduler) RegisterFileCleanupJobs(ctx context.Context, db *gorm.DB) error {
 	jobs := &FileCleanupJobs{db: db}
 
-	// Run every 24 hours
-	return s.registerJob(ctx, "ClearUnusedDefaultProfilePictures", gocron.DurationJob(24*time.Hour), jobs.clearUnusedDefaultProfilePictures, false)
+	// Run every 24 hours, on every replica as the pictures are stored locally
+	return s.registerReplicaJob(ctx, "ClearUnusedDefaultProfilePictures", gocron.DurationJob(24*time.Hour), jobs.clearUnusedDefaultProfilePictures, false)
 }
 
 type FileCleanupJobs struct {
 	db *gorm.DB
 }
 
-// ClearUnusedDefaultProfilePictures deletes default profile pictures that don't match any user's initials
+// ClearUnusedDefaultProfilePictures deletes default profile pictures that don't belong to any user, or that were created by another generator
 func (j *FileCleanupJobs) clearUnusedDefaultProfilePictures(ctx context.Context) error {
 	var users []model.User
 	err := j.db.
@@ -38,13 +38,15 @@ func (j *FileCleanupJobs) clearUnusedDefaultProfilePictures(ctx context.Context)
 		return fmt.Errorf("failed to fetch users: %w", err)
 	}
 
-	// Create a map to track which initials are in use
-	initialsInUse := make(map[string]struct{})
+	// Create a map to track which pictures are in use
+	keysInUse := make(map[string]struct{})
 	for _, user := range users {
-		initialsInUse[user.Initials()] = struct{}{}
+		keysInUse[service.DefaultProfilePictureKey(user)] = struct{}{}
 	}
 
-	defaultPicturesDir := common.EnvConfig.UploadPath + "/profile-pictures/defaults"
+	defaultPicturesDir := service.DefaultProfilePicturesDir()
+	filesDeleted := j.clearDefaultProfilePicturesOfOtherGenerators(ctx, defaultPicturesDir)
+
 	if _, err := os.Stat(defaultPicturesDir); os.IsNotExist(err) {
 		return nil
 	}
@@ -54,17 +56,16 @@ func (j *FileCleanupJobs) clearUnusedDefaultProfilePictures(ctx context.Context)
 		return fmt.Errorf("failed to read default profile pictures directory: %w", err)
 	}
 
-	filesDeleted := 0
 	for _, file := range files {
 		if file.IsDir() {
 			continue // Skip directories
 		}
 
 		filename := file.Name()
-		initials := strings.TrimSuffix(filename, ".png")
+		key := strings.TrimSuffix(filename, ".png")
 
-		// If these initials aren't used by any user, delete the file
-		if _, ok := initialsInUse[initials]; !ok {
+		// If this picture isn't used by any user, delete the file
+		if _, ok := keysInUse[key]; !ok {
 			filePath := filepath.Join(defaultPicturesDir, filename)
 			if err := os.Remove(filePath); err != nil {
 				slog.ErrorContext(ctx, "Failed to delete unused default profile picture", slog.String("path", filePath), slog.Any("error", err))
@@ -77,3 +78,28 @@ func (j *FileCleanupJobs) clearUnusedDefaultProfilePictures(ctx context.Context)
 	slog.Info("Done deleting unused default profile pictures", slog.Int("count", filesDeleted))
 	return nil
 }
+
+// clearDefaultProfilePicturesOfOtherGenerators deletes the default profile pictures next to the directory of the configured generator.
+// These were created by a previously configured generator, or before pictures were stored per generator.
+func (j *FileCleanupJobs) clearDefaultProfilePicturesOfOtherGenerators(ctx context.Context, defaultPicturesDir string) (deleted int) {
+	parentDir := filepath.Dir(defaultPicturesDir)
+	entries, err := os.ReadDir(parentDir)
+	if err != nil {
+		return 0
+	}
+
+	for _, entry := range entries {
+		if entry.Name() == filepath.Base(defaultPicturesDir) {
+			continue
+		}
+
+		path := filepath.Join(parentDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			slog.ErrorContext(ctx, "Failed to delete default profile picture of another generator", slog.String("path", path), slog.Any("error", err))
+		} else {
+			deleted++
+		}
+	}
+
+	return deleted
+}
@@ -22,8 +22,10 @@ func (s *Scheduler) RegisterGeoLiteUpdateJobs(ctx context.Context, geoLiteServic
 
 	jobs := &GeoLiteUpdateJobs{geoLiteService: geoLiteService}
 
-	// Run every 24 hours (and right away)
-	return s.registerJob(ctx, "UpdateGeoLiteDB", gocron.DurationJob(24*time.Hour), jobs.updateGoeLiteDB, true)
+	// Run every 24 hours (with some jitter so replicas don't all download the database at the same time), and right away
+	// Every replica has its own copy of the database, so the job runs on all of them
+	def := gocron.DurationRandomJob(24*time.Hour-10*time.Minute, 24*time.Hour+10*time.Minute)
+	return s.registerReplicaJob(ctx, "UpdateGeoLiteDB", def, jobs.updateGoeLiteDB, true)
 }
 
 func (j *GeoLiteUpdateJobs) updateGoeLiteDB(ctx context.Context) error {
@@ -0,0 +1,36 @@
+package job
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+type InactiveUserJobs struct {
+	userService *service.UserService
+}
+
+func (s *Scheduler) RegisterInactiveUserJob(ctx context.Context, userService *service.UserService) error {
+	jobs := &InactiveUserJobs{
+		userService: userService,
+	}
+
+	// Run every day at 1 AM
+	return s.registerJob(ctx, "DisableInactiveUsersJob", gocron.CronJob("0 1 * * *", false), jobs.disableInactiveUsers, false)
+}
+
+func (j *InactiveUserJobs) disableInactiveUsers(ctx context.Context) error {
+	result, err := j.userService.DisableInactiveUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to disable inactive users: %w", err)
+	}
+
+	if result.Disabled > 0 || result.Warned > 0 {
+		slog.InfoContext(ctx, "Processed inactive users", slog.Int("disabled", result.Disabled), slog.Int("warned", result.Warned))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package job
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+
+	"github.com/pocket-id/pocket-id/backend/internal/service"
+)
+
+type KeyRotationJobs struct {
+	jwtService *service.JwtService
+}
+
+func (s *Scheduler) RegisterKeyRotationJob(ctx context.Context, jwtService *service.JwtService) error {
+	// Skip if the key isn't rotated automatically
+	if !jwtService.KeyRotationEnabled() {
+		return nil
+	}
+
+	jobs := &KeyRotationJobs{jwtService: jwtService}
+
+	// Check every hour if the key is due for rotation, and right away
+	return s.registerJob(ctx, "RotateSigningKey", gocron.DurationJob(time.Hour), jobs.rotateSigningKey, true)
+}
+
+func (j *KeyRotationJobs) rotateSigningKey(ctx context.Context) error {
+	_, err := j.jwtService.RotateKeyIfDue(ctx)
+	return err
+}
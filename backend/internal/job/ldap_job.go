@@ -26,5 +26,7 @@ func (j *LdapJobs) syncLdap(ctx context.Context) error {
 		return nil
 	}
 
-	return j.ldapService.SyncAll(ctx)
+	// Share the sync with the ones triggered by webhooks, so they don't run at the same time
+	_, err := j.ldapService.SyncAllCoalesced(ctx)
+	return err
 }
@@ -0,0 +1,313 @@
+package job
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+const (
+	// Key of the row in the kv table that contains the lease of the current leader
+	leaderLeaseKVKey = "scheduler_leader"
+
+	// ID of the Postgres advisory lock that is held by the leader
+	leaderAdvisoryLockID int64 = 0x706f636b65746964
+
+	leaderLeaseDuration = 30 * time.Second
+	leaderRenewInterval = 10 * time.Second
+)
+
+// ErrNotLeader is returned by LeaderElector.IsLeader when the current replica doesn't hold the leadership
+var ErrNotLeader = errors.New("this replica is not the leader")
+
+// leaderLease is stored in the kv table so that every replica can tell which one is the leader
+type leaderLease struct {
+	ReplicaID string    `json:"replicaId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// LeaderElector makes sure that only one replica runs the scheduled jobs at a time.
+// On Postgres the leadership is tied to an advisory lock held on a dedicated connection, so it's released as soon as the leader goes away.
+// On SQLite the leader holds a lease in the kv table, which other replicas can take over once it expires.
+// The leader renews its lease periodically; jobs that only run on the leader check IsLeader before every run.
+type LeaderElector struct {
+	db        *gorm.DB
+	replicaID string
+
+	lock           sync.RWMutex
+	leaseExpiresAt time.Time
+	leaseValue     string
+	pgConn         *sql.Conn
+
+	// Allows overriding the clock in tests
+	now func() time.Time
+}
+
+func NewLeaderElector(db *gorm.DB) *LeaderElector {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "pocket-id"
+	}
+
+	return &LeaderElector{
+		db:        db,
+		replicaID: hostname + "-" + uuid.NewString()[:8],
+		now:       time.Now,
+	}
+}
+
+// ReplicaID returns the unique ID of the current replica
+func (e *LeaderElector) ReplicaID() string {
+	return e.replicaID
+}
+
+// IsLeader returns nil if the current replica holds a valid lease, and ErrNotLeader otherwise
+func (e *LeaderElector) IsLeader(_ context.Context) error {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.leaseExpiresAt.IsZero() || !e.now().Before(e.leaseExpiresAt) {
+		return ErrNotLeader
+	}
+	return nil
+}
+
+// CurrentLeader returns the ID of the replica that currently holds the leadership, or an empty string if none does
+func (e *LeaderElector) CurrentLeader(ctx context.Context) (string, error) {
+	lease, _, err := e.loadLease(ctx)
+	if err != nil {
+		return "", err
+	}
+	if lease == nil || !e.now().Before(lease.ExpiresAt) {
+		return "", nil
+	}
+	return lease.ReplicaID, nil
+}
+
+// Run tries to acquire or renew the leadership periodically.
+// This function blocks until the context is canceled, then gives up the leadership.
+func (e *LeaderElector) Run(ctx context.Context) error {
+	ticker := time.NewTicker(leaderRenewInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// Use a background context as the run context has been canceled already
+			resignCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			e.resign(resignCtx) //nolint:contextcheck
+			cancel()
+			return nil
+		case <-ticker.C:
+			e.campaign(ctx)
+		}
+	}
+}
+
+// campaign tries to acquire the leadership, or to renew it if the replica is already the leader
+func (e *LeaderElector) campaign(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, leaderRenewInterval)
+	defer cancel()
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	wasLeader := !e.leaseExpiresAt.IsZero() && e.now().Before(e.leaseExpiresAt)
+
+	var (
+		acquired bool
+		err      error
+	)
+	if common.EnvConfig.DbProvider == common.DbProviderPostgres {
+		acquired, err = e.tryAcquireAdvisoryLock(ctx)
+	} else {
+		acquired, err = e.tryAcquireLease(ctx)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to acquire the scheduler leadership", slog.Any("error", err))
+	}
+
+	switch {
+	case acquired && !wasLeader:
+		slog.InfoContext(ctx, "This replica is now the leader for scheduled jobs", slog.String("replicaId", e.replicaID))
+	case !acquired && wasLeader:
+		slog.WarnContext(ctx, "This replica lost the leadership for scheduled jobs", slog.String("replicaId", e.replicaID))
+	}
+
+	if !acquired {
+		e.leaseExpiresAt = time.Time{}
+	}
+}
+
+// tryAcquireLease acquires or renews the lease stored in the kv table.
+// The row is updated with a compare-and-swap, so only one replica can take over an expired lease.
+// Must be called while holding the lock.
+func (e *LeaderElector) tryAcquireLease(ctx context.Context) (bool, error) {
+	current, currentValue, err := e.loadLease(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	now := e.now()
+	if current != nil && current.ReplicaID != e.replicaID && now.Before(current.ExpiresAt) {
+		// Another replica holds a valid lease
+		return false, nil
+	}
+
+	lease := leaderLease{ReplicaID: e.replicaID, ExpiresAt: now.Add(leaderLeaseDuration)}
+	newValue, err := json.Marshal(lease)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode lease: %w", err)
+	}
+	newValueStr := string(newValue)
+
+	var res *gorm.DB
+	if current == nil {
+		res = e.db.
+			WithContext(ctx).
+			Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.KV{Key: leaderLeaseKVKey, Value: &newValueStr})
+	} else {
+		res = e.db.
+			WithContext(ctx).
+			Model(&model.KV{}).
+			Where("key = ? AND value = ?", leaderLeaseKVKey, currentValue).
+			Update("value", newValueStr)
+	}
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to store lease: %w", res.Error)
+	}
+	if res.RowsAffected != 1 {
+		// Another replica updated the lease in the meantime
+		return false, nil
+	}
+
+	e.leaseExpiresAt = lease.ExpiresAt
+	e.leaseValue = newValueStr
+	return true, nil
+}
+
+// tryAcquireAdvisoryLock acquires the advisory lock on a dedicated connection, or checks that the connection holding it is still alive.
+// The lease in the kv table is then updated so other replicas know which one is the leader.
+// Must be called while holding the lock.
+func (e *LeaderElector) tryAcquireAdvisoryLock(ctx context.Context) (bool, error) {
+	if e.pgConn != nil {
+		err := e.pgConn.PingContext(ctx)
+		if err != nil {
+			// The lock is released by Postgres when the connection is closed
+			_ = e.pgConn.Close()
+			e.pgConn = nil
+			return false, fmt.Errorf("lost the connection holding the advisory lock: %w", err)
+		}
+	} else {
+		sqlDB, err := e.db.DB()
+		if err != nil {
+			return false, fmt.Errorf("failed to get database connection: %w", err)
+		}
+		conn, err := sqlDB.Conn(ctx)
+		if err != nil {
+			return false, fmt.Errorf("failed to get database connection: %w", err)
+		}
+
+		var acquired bool
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", leaderAdvisoryLockID).Scan(&acquired)
+		if err != nil || !acquired {
+			_ = conn.Close()
+			if err != nil {
+				return false, fmt.Errorf("failed to acquire advisory lock: %w", err)
+			}
+			return false, nil
+		}
+		e.pgConn = conn
+	}
+
+	lease := leaderLease{ReplicaID: e.replicaID, ExpiresAt: e.now().Add(leaderLeaseDuration)}
+	value, err := json.Marshal(lease)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode lease: %w", err)
+	}
+	valueStr := string(value)
+
+	err = e.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&model.KV{Key: leaderLeaseKVKey, Value: &valueStr}).
+		Error
+	if err != nil {
+		// We still hold the advisory lock, so we keep the leadership
+		slog.WarnContext(ctx, "Failed to store the scheduler leader lease", slog.Any("error", err))
+	}
+
+	e.leaseExpiresAt = lease.ExpiresAt
+	e.leaseValue = valueStr
+	return true, nil
+}
+
+// resign gives up the leadership, if held, so another replica can take over right away
+func (e *LeaderElector) resign(ctx context.Context) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.leaseExpiresAt.IsZero() {
+		return
+	}
+
+	err := e.db.
+		WithContext(ctx).
+		Where("key = ? AND value = ?", leaderLeaseKVKey, e.leaseValue).
+		Delete(&model.KV{}).
+		Error
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to release the scheduler leader lease", slog.Any("error", err))
+	}
+
+	if e.pgConn != nil {
+		_, err = e.pgConn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", leaderAdvisoryLockID)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to release the scheduler advisory lock", slog.Any("error", err))
+		}
+		_ = e.pgConn.Close()
+		e.pgConn = nil
+	}
+
+	e.leaseExpiresAt = time.Time{}
+	e.leaseValue = ""
+}
+
+func (e *LeaderElector) loadLease(ctx context.Context) (*leaderLease, string, error) {
+	row := model.KV{Key: leaderLeaseKVKey}
+	err := e.db.WithContext(ctx).First(&row).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to load lease: %w", err)
+	}
+
+	if row.Value == nil || *row.Value == "" {
+		return nil, "", nil
+	}
+
+	var lease leaderLease
+	err = json.Unmarshal([]byte(*row.Value), &lease)
+	if err != nil {
+		// Treat invalid values as an expired lease, so they can be replaced
+		return &leaderLease{}, *row.Value, nil
+	}
+	return &lease, *row.Value, nil
+}
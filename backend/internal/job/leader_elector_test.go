@@ -0,0 +1,57 @@
+package job
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestLeaderElector_Lease(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	now := time.Now()
+	clock := func() time.Time { return now }
+
+	first := NewLeaderElector(db)
+	first.now = clock
+	second := NewLeaderElector(db)
+	second.now = clock
+
+	// The first replica acquires the leadership
+	first.campaign(t.Context())
+	second.campaign(t.Context())
+	require.NoError(t, first.IsLeader(t.Context()))
+	require.ErrorIs(t, second.IsLeader(t.Context()), ErrNotLeader)
+
+	leader, err := second.CurrentLeader(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, first.ReplicaID(), leader)
+
+	// The leader renews its lease
+	now = now.Add(leaderRenewInterval)
+	first.campaign(t.Context())
+	second.campaign(t.Context())
+	require.NoError(t, first.IsLeader(t.Context()))
+	require.ErrorIs(t, second.IsLeader(t.Context()), ErrNotLeader)
+
+	// The leader stops renewing its lease, so the second replica takes over once it expires
+	now = now.Add(leaderLeaseDuration)
+	require.ErrorIs(t, first.IsLeader(t.Context()), ErrNotLeader)
+	second.campaign(t.Context())
+	first.campaign(t.Context())
+	require.NoError(t, second.IsLeader(t.Context()))
+	require.ErrorIs(t, first.IsLeader(t.Context()), ErrNotLeader)
+
+	// After resigning, another replica can take over right away
+	second.resign(t.Context())
+	leader, err = first.CurrentLeader(t.Context())
+	require.NoError(t, err)
+	assert.Empty(t, leader)
+
+	first.campaign(t.Context())
+	require.NoError(t, first.IsLeader(t.Context()))
+}
@@ -4,16 +4,37 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-co-op/gocron/v2"
 	"github.com/google/uuid"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
 )
 
 type Scheduler struct {
 	scheduler gocron.Scheduler
+	elector   *LeaderElector
+
+	jobsLock sync.RWMutex
+	jobs     map[string]*jobStatus
+}
+
+// jobStatus contains the status of a registered job, which is updated by the job's event listeners
+type jobStatus struct {
+	job       gocron.Job
+	running   bool
+	lastRunAt time.Time
+	lastError error
 }
 
-func NewScheduler() (*Scheduler, error) {
+// NewScheduler creates a new job scheduler.
+// If an elector is passed, jobs registered with registerJob are run only while the current replica is the leader,
+// while jobs registered with registerReplicaJob are run on every replica.
+func NewScheduler(elector *LeaderElector) (*Scheduler, error) {
 	scheduler, err := gocron.NewScheduler()
 	if err != nil {
 		return nil, fmt.Errorf("failed to create a new scheduler: %w", err)
@@ -21,12 +42,34 @@ func NewScheduler() (*Scheduler, error) {
 
 	return &Scheduler{
 		scheduler: scheduler,
+		elector:   elector,
+		jobs:      make(map[string]*jobStatus),
 	}, nil
 }
 
+// LeaderElector returns the leader elector used by the scheduler, which may be nil
+func (s *Scheduler) LeaderElector() *LeaderElector {
+	return s.elector
+}
+
 // Run the scheduler.
 // This function blocks until the context is canceled.
 func (s *Scheduler) Run(ctx context.Context) error {
+	electorDone := make(chan struct{})
+	electorCtx, electorCancel := context.WithCancel(context.Background())
+	defer electorCancel()
+	if s.elector != nil {
+		// Try to acquire the leadership before starting, so jobs that run immediately aren't skipped on the leader
+		s.elector.campaign(ctx)
+
+		go func() {
+			defer close(electorDone)
+			_ = s.elector.Run(electorCtx) //nolint:contextcheck
+		}()
+	} else {
+		close(electorDone)
+	}
+
 	slog.Info("Starting job scheduler")
 	s.scheduler.Start()
 
@@ -40,26 +83,62 @@ func (s *Scheduler) Run(ctx context.Context) error {
 		slog.Info("Job scheduler shut down")
 	}
 
+	// Give up the leadership only after the running jobs have completed
+	electorCancel()
+	<-electorDone
+
 	return nil
 }
 
+// registerJob registers a job that works on the shared data, so it's only run on the leader
 func (s *Scheduler) registerJob(ctx context.Context, name string, def gocron.JobDefinition, job func(ctx context.Context) error, runImmediately bool) error {
+	return s.registerJobInternal(ctx, name, def, job, runImmediately, true)
+}
+
+// registerReplicaJob registers a job that works on the local files of the replica, so it's run on every replica
+func (s *Scheduler) registerReplicaJob(ctx context.Context, name string, def gocron.JobDefinition, job func(ctx context.Context) error, runImmediately bool) error {
+	return s.registerJobInternal(ctx, name, def, job, runImmediately, false)
+}
+
+func (s *Scheduler) registerJobInternal(ctx context.Context, name string, def gocron.JobDefinition, job func(ctx context.Context) error, runImmediately bool, leaderOnly bool) error {
 	jobOptions := []gocron.JobOption{
 		gocron.WithContext(ctx),
 		gocron.WithEventListeners(
-			gocron.BeforeJobRuns(func(jobID uuid.UUID, jobName string) {
+			gocron.BeforeJobRunsSkipIfBeforeFuncErrors(func(jobID uuid.UUID, jobName string) error {
+				// Runs on other replicas are skipped without updating the status
+				if leaderOnly && s.elector != nil {
+					err := s.elector.IsLeader(ctx)
+					if err != nil {
+						return err
+					}
+				}
+
+				s.updateJobStatus(name, func(status *jobStatus) {
+					status.running = true
+				})
 				slog.Info("Starting job",
 					slog.String("name", name),
 					slog.String("id", jobID.String()),
 				)
+				return nil
 			}),
 			gocron.AfterJobRuns(func(jobID uuid.UUID, jobName string) {
+				s.updateJobStatus(name, func(status *jobStatus) {
+					status.running = false
+					status.lastRunAt = time.Now()
+					status.lastError = nil
+				})
 				slog.Info("Job run successfully",
 					slog.String("name", name),
 					slog.String("id", jobID.String()),
 				)
 			}),
 			gocron.AfterJobRunsWithError(func(jobID uuid.UUID, jobName string, err error) {
+				s.updateJobStatus(name, func(status *jobStatus) {
+					status.running = false
+					status.lastRunAt = time.Now()
+					status.lastError = err
+				})
 				slog.Error("Job failed with error",
 					slog.String("name", name),
 					slog.String("id", jobID.String()),
@@ -73,11 +152,71 @@ func (s *Scheduler) registerJob(ctx context.Context, name string, def gocron.Job
 		jobOptions = append(jobOptions, gocron.JobOption(gocron.WithStartImmediately()))
 	}
 
-	_, err := s.scheduler.NewJob(def, gocron.NewTask(job), jobOptions...)
+	// Register the status before the job is created, as it may start running right away
+	status := &jobStatus{}
+	s.jobsLock.Lock()
+	s.jobs[name] = status
+	s.jobsLock.Unlock()
 
+	j, err := s.scheduler.NewJob(def, gocron.NewTask(job), append(jobOptions, gocron.WithName(name))...)
 	if err != nil {
+		s.jobsLock.Lock()
+		delete(s.jobs, name)
+		s.jobsLock.Unlock()
 		return fmt.Errorf("failed to register job %q: %w", name, err)
 	}
 
+	s.updateJobStatus(name, func(status *jobStatus) {
+		status.job = j
+	})
+
 	return nil
 }
+
+func (s *Scheduler) updateJobStatus(name string, fn func(status *jobStatus)) {
+	s.jobsLock.Lock()
+	defer s.jobsLock.Unlock()
+
+	status, ok := s.jobs[name]
+	if !ok {
+		return
+	}
+	fn(status)
+}
+
+// ListJobs returns the status of all registered jobs, sorted by name
+func (s *Scheduler) ListJobs() []dto.ScheduledJobDto {
+	s.jobsLock.RLock()
+	defer s.jobsLock.RUnlock()
+
+	res := make([]dto.ScheduledJobDto, 0, len(s.jobs))
+	for name, status := range s.jobs {
+		item := dto.ScheduledJobDto{
+			Name:    name,
+			Running: status.running,
+		}
+
+		if !status.lastRunAt.IsZero() {
+			lastRunAt := status.lastRunAt
+			item.LastRunAt = &lastRunAt
+		}
+		if status.lastError != nil {
+			lastError := status.lastError.Error()
+			item.LastError = &lastError
+		}
+		if status.job != nil {
+			nextRun, err := status.job.NextRun()
+			if err == nil && !nextRun.IsZero() {
+				item.NextRunAt = &nextRun
+			}
+		}
+
+		res = append(res, item)
+	}
+
+	slices.SortFunc(res, func(a, b dto.ScheduledJobDto) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return res
+}
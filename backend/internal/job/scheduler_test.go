@@ -0,0 +1,92 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-co-op/gocron/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestScheduler_ListJobs(t *testing.T) {
+	scheduler, err := NewScheduler(nil)
+	require.NoError(t, err)
+
+	def := gocron.DurationJob(time.Hour)
+	err = scheduler.registerJob(t.Context(), "Succeeds", def, func(ctx context.Context) error { return nil }, true)
+	require.NoError(t, err)
+	err = scheduler.registerJob(t.Context(), "Fails", def, func(ctx context.Context) error { return errors.New("boom") }, true)
+	require.NoError(t, err)
+	err = scheduler.registerJob(t.Context(), "NotStarted", def, func(ctx context.Context) error { return nil }, false)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() {
+		_ = scheduler.Run(ctx)
+	}()
+
+	// Wait for the jobs that start immediately to complete
+	require.Eventually(t, func() bool {
+		jobs := scheduler.ListJobs()
+		return jobs[0].LastRunAt != nil && jobs[2].LastRunAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	jobs := scheduler.ListJobs()
+	require.Len(t, jobs, 3)
+
+	// Jobs are sorted by name
+	assert.Equal(t, "Fails", jobs[0].Name)
+	require.NotNil(t, jobs[0].LastError)
+	assert.Equal(t, "boom", *jobs[0].LastError)
+
+	assert.Equal(t, "NotStarted", jobs[1].Name)
+	assert.Nil(t, jobs[1].LastRunAt)
+	require.NotNil(t, jobs[1].NextRunAt)
+
+	assert.Equal(t, "Succeeds", jobs[2].Name)
+	assert.Nil(t, jobs[2].LastError)
+	assert.False(t, jobs[2].Running)
+	require.NotNil(t, jobs[2].NextRunAt)
+	assert.True(t, jobs[2].NextRunAt.After(time.Now()))
+}
+
+func TestScheduler_ReplicaJobs(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	// Another replica holds the leadership
+	leader := NewLeaderElector(db)
+	leader.campaign(t.Context())
+	require.NoError(t, leader.IsLeader(t.Context()))
+
+	scheduler, err := NewScheduler(NewLeaderElector(db))
+	require.NoError(t, err)
+
+	def := gocron.DurationJob(time.Hour)
+	err = scheduler.registerJob(t.Context(), "LeaderOnly", def, func(ctx context.Context) error { return nil }, true)
+	require.NoError(t, err)
+	err = scheduler.registerReplicaJob(t.Context(), "EveryReplica", def, func(ctx context.Context) error { return nil }, true)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() {
+		_ = scheduler.Run(ctx)
+	}()
+
+	// Jobs are sorted by name
+	require.Eventually(t, func() bool {
+		return scheduler.ListJobs()[0].LastRunAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	jobs := scheduler.ListJobs()
+	assert.Equal(t, "EveryReplica", jobs[0].Name)
+	assert.Equal(t, "LeaderOnly", jobs[1].Name)
+	assert.Nil(t, jobs[1].LastRunAt)
+	assert.False(t, jobs[1].Running)
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// CorrelationIDHeader is the header that contains the correlation ID of a request, in both the request and the response
+const CorrelationIDHeader = "X-Request-ID"
+
+// Correlation IDs sent by clients are accepted only if they are reasonably short and can't inject anything into the logs
+var correlationIDRegex = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// CorrelationIDMiddleware assigns a correlation ID to each request, which is added to the logs of the request.
+// The ID is taken from the request header if present, or else it's the OpenTelemetry trace ID, or a random one.
+type CorrelationIDMiddleware struct{}
+
+func NewCorrelationIDMiddleware() *CorrelationIDMiddleware {
+	return &CorrelationIDMiddleware{}
+}
+
+func (m *CorrelationIDMiddleware) Add() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		span := trace.SpanFromContext(ctx)
+
+		correlationID := c.GetHeader(CorrelationIDHeader)
+		switch {
+		case correlationIDRegex.MatchString(correlationID):
+			// Use the ID from the client
+		case span.SpanContext().IsValid():
+			correlationID = span.SpanContext().TraceID().String()
+		default:
+			correlationID = uuid.NewString()
+		}
+
+		// Record the ID in the trace too, so traces can be found from the ID in the logs
+		span.SetAttributes(attribute.String("correlation_id", correlationID))
+
+		c.Request = c.Request.WithContext(utils.WithCorrelationID(ctx, correlationID))
+		c.Header(CorrelationIDHeader, correlationID)
+
+		c.Next()
+	}
+}
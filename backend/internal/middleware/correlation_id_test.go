@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+func TestCorrelationIDMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var logs bytes.Buffer
+	logger := slog.New(utils.LogCorrelationHandler{Handler: slog.NewTextHandler(&logs, nil)})
+
+	r := gin.New()
+	r.Use(NewCorrelationIDMiddleware().Add())
+	r.GET("/", func(c *gin.Context) {
+		logger.InfoContext(c.Request.Context(), "handled")
+		c.String(http.StatusOK, utils.CorrelationIDFromContext(c.Request.Context()))
+	})
+
+	t.Run("uses the ID from the request", func(t *testing.T) {
+		logs.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(CorrelationIDHeader, "abc-123")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, "abc-123", w.Body.String())
+		assert.Equal(t, "abc-123", w.Header().Get(CorrelationIDHeader))
+		assert.Contains(t, logs.String(), "correlation_id=abc-123")
+	})
+
+	t.Run("generates an ID if the request doesn't have a valid one", func(t *testing.T) {
+		for _, header := range []string{"", "bad id\nwith=newline"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if header != "" {
+				req.Header.Set(CorrelationIDHeader, header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			require.NotEmpty(t, w.Body.String())
+			assert.NotEqual(t, header, w.Body.String())
+			assert.Equal(t, w.Body.String(), w.Header().Get(CorrelationIDHeader))
+		}
+	})
+}
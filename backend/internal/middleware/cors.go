@@ -2,14 +2,77 @@ package middleware
 
 import (
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 )
 
-type CorsMiddleware struct{}
+// How long browsers can cache the result of a preflight request, in seconds
+const corsMaxAge = 600
+
+// CorsPolicy configures which cross-origin requests are allowed for the API
+type CorsPolicy struct {
+	// Allowed origins, in the format "scheme://host[:port]"
+	// The host can start with "*." to allow all direct subdomains of a domain
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+}
+
+type CorsMiddleware struct {
+	policy          CorsPolicy
+	exactOrigins    map[string]struct{}
+	wildcardOrigins []wildcardOrigin
+}
+
+// wildcardOrigin matches origins with the same scheme and port whose host is a direct subdomain of the base domain
+type wildcardOrigin struct {
+	scheme string
+	base   string
+	port   string
+}
+
+func NewCorsMiddleware(policy CorsPolicy) *CorsMiddleware {
+	m := &CorsMiddleware{
+		policy:       policy,
+		exactOrigins: make(map[string]struct{}, len(policy.AllowedOrigins)),
+	}
+
+	for _, origin := range policy.AllowedOrigins {
+		origin = strings.TrimSuffix(strings.ToLower(origin), "/")
+		u, err := url.Parse(origin)
+		if err != nil {
+			continue
+		}
+
+		base, isWildcard := strings.CutPrefix(u.Hostname(), "*.")
+		if !isWildcard {
+			m.exactOrigins[origin] = struct{}{}
+			continue
+		}
+		m.wildcardOrigins = append(m.wildcardOrigins, wildcardOrigin{
+			scheme: u.Scheme,
+			base:   base,
+			port:   u.Port(),
+		})
+	}
+
+	return m
+}
 
-func NewCorsMiddleware() *CorsMiddleware {
-	return &CorsMiddleware{}
+// NewCorsPolicyFromEnv returns the CORS policy configured with environment variables
+func NewCorsPolicyFromEnv() CorsPolicy {
+	return CorsPolicy{
+		AllowedOrigins:   common.EnvConfig.CorsAllowedOrigins,
+		AllowedMethods:   common.EnvConfig.CorsAllowedMethods,
+		AllowedHeaders:   common.EnvConfig.CorsAllowedHeaders,
+		AllowCredentials: common.EnvConfig.CorsAllowCredentials,
+	}
 }
 
 func (m *CorsMiddleware) Add() gin.HandlerFunc {
@@ -20,18 +83,53 @@ func (m *CorsMiddleware) Add() gin.HandlerFunc {
 			path = c.Request.URL.Path
 		}
 
-		if !isCorsPath(path) {
+		isPreflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+
+		// Public OIDC endpoints can be called from any origin, without credentials
+		if isPublicCorsPath(path) {
+			c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+			c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization")
+			c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+
+			// Preflight request
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
+
+			c.Next()
+			return
+		}
+
+		origin := c.GetHeader("Origin")
+		if origin == "" || !strings.HasPrefix(path, "/api/") {
+			c.Next()
+			return
+		}
+
+		// The response depends on the origin, so it must not be shared between origins by caches
+		c.Writer.Header().Add("Vary", "Origin")
+
+		if !m.isOriginAllowed(origin) {
+			// Without the CORS headers the browser blocks the response
+			if isPreflight {
+				c.AbortWithStatus(http.StatusNoContent)
+				return
+			}
 			c.Next()
 			return
 		}
 
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Authorization")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		if m.policy.AllowCredentials {
+			c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
 
-		// Preflight request
-		if c.Request.Method == http.MethodOptions {
-			c.AbortWithStatus(204)
+		if isPreflight {
+			c.Writer.Header().Set("Access-Control-Allow-Methods", strings.Join(m.policy.AllowedMethods, ", "))
+			c.Writer.Header().Set("Access-Control-Allow-Headers", strings.Join(m.policy.AllowedHeaders, ", "))
+			c.Writer.Header().Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			c.AbortWithStatus(http.StatusNoContent)
 			return
 		}
 
@@ -39,14 +137,58 @@ func (m *CorsMiddleware) Add() gin.HandlerFunc {
 	}
 }
 
-func isCorsPath(path string) bool {
+func (m *CorsMiddleware) isOriginAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	if _, ok := m.exactOrigins[origin]; ok {
+		return true
+	}
+
+	if len(m.wildcardOrigins) == 0 {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Path != "" || u.User != nil {
+		return false
+	}
+
+	host := u.Hostname()
+	for _, w := range m.wildcardOrigins {
+		if u.Scheme != w.scheme || u.Port() != w.port {
+			continue
+		}
+
+		// Only a single label is allowed in place of the wildcard, and the base domain itself doesn't match
+		label, ok := strings.CutSuffix(host, "."+w.base)
+		if ok && isValidDNSLabel(label) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isValidDNSLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, r := range label {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isPublicCorsPath(path string) bool {
 	switch path {
 	case "/api/oidc/token",
 		"/api/oidc/userinfo",
 		"/oidc/end-session",
 		"/api/oidc/introspect",
 		"/.well-known/jwks.json",
-		"/.well-known/openid-configuration":
+		"/.well-known/openid-configuration",
+		"/.well-known/openapi.json":
 		return true
 	default:
 		return false
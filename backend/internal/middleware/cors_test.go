@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.Use(NewCorsMiddleware(CorsPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{"GET", "POST"},
+		AllowedHeaders:   []string{"Content-Type"},
+		AllowCredentials: true,
+	}).Add())
+	r.GET("/api/users/me", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.POST("/api/oidc/token", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		origin        string
+		expectedAllow string
+		expectedCode  int
+	}{
+		{"exact origin", http.MethodGet, "/api/users/me", "https://app.example.com", "https://app.example.com", http.StatusOK},
+		{"other origin", http.MethodGet, "/api/users/me", "https://evil.example.com", "", http.StatusOK},
+		{"wildcard subdomain", http.MethodGet, "/api/users/me", "https://foo.example.org", "https://foo.example.org", http.StatusOK},
+		{"wildcard nested subdomain", http.MethodGet, "/api/users/me", "https://a.b.example.org", "", http.StatusOK},
+		{"wildcard base domain", http.MethodGet, "/api/users/me", "https://example.org", "", http.StatusOK},
+		{"wildcard other scheme", http.MethodGet, "/api/users/me", "http://foo.example.org", "", http.StatusOK},
+		{"wildcard suffix attack", http.MethodGet, "/api/users/me", "https://fooexample.org", "", http.StatusOK},
+		{"preflight for unmapped route", http.MethodOptions, "/api/application-configuration/logo", "https://app.example.com", "https://app.example.com", http.StatusNoContent},
+		{"preflight from other origin", http.MethodOptions, "/api/users/me", "https://evil.example.com", "", http.StatusNoContent},
+		{"public endpoint", http.MethodPost, "/api/oidc/token", "https://evil.example.com", "*", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			if tt.method == http.MethodOptions {
+				req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+			assert.Equal(t, tt.expectedAllow, w.Header().Get("Access-Control-Allow-Origin"))
+
+			if tt.expectedAllow != "" && tt.expectedAllow != "*" {
+				assert.Equal(t, "true", w.Header().Get("Access-Control-Allow-Credentials"))
+				if tt.method == http.MethodOptions {
+					assert.Equal(t, "GET, POST", w.Header().Get("Access-Control-Allow-Methods"))
+					assert.Equal(t, "Content-Type", w.Header().Get("Access-Control-Allow-Headers"))
+				}
+			}
+		})
+	}
+}
@@ -54,6 +54,14 @@ func (m *ErrorHandlerMiddleware) Add() gin.HandlerFunc {
 				return
 			}
 
+			// Check for request bodies that exceeded the limit while being parsed
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				appErr = &common.RequestBodyTooLargeError{MaxSize: formatFileSize(maxBytesErr.Limit)}
+				errorResponse(c, appErr.HttpStatusCode(), appErr.Error())
+				return
+			}
+
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong"})
 		}
 	}
@@ -78,6 +86,8 @@ func handleValidationError(validationErrors validator.ValidationErrors) string {
 			errorMessage = fmt.Sprintf("%s must be a valid email address", fieldName)
 		case "username":
 			errorMessage = fmt.Sprintf("%s must only contain lowercase letters, numbers, underscores, dots, hyphens, and '@' symbols and not start or end with a special character", fieldName)
+		case "accentcolor":
+			errorMessage = fmt.Sprintf("%s must be \"default\", a hex color like #1a2b3c or an oklch() color", fieldName)
 		case "url":
 			errorMessage = fmt.Sprintf("%s must be a valid URL", fieldName)
 		case "min":
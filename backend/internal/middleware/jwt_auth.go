@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"log/slog"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/service"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/cookie"
 )
@@ -36,6 +40,7 @@ func (m *JwtAuthMiddleware) Add(adminRequired bool) gin.HandlerFunc {
 func (m *JwtAuthMiddleware) Verify(c *gin.Context, adminRequired bool) (subject string, isAdmin bool, err error) {
 	// Extract the token from the cookie
 	accessToken, err := c.Cookie(cookie.AccessTokenCookieName)
+	fromCookie := err == nil
 	if err != nil {
 		// Try to extract the token from the Authorization header if it's not in the cookie
 		var ok bool
@@ -69,5 +74,47 @@ func (m *JwtAuthMiddleware) Verify(c *gin.Context, adminRequired bool) (subject
 		return "", false, &common.MissingPermissionError{}
 	}
 
+	// The time and methods of the sign in are needed to honor the max_age and acr_values parameters of OIDC authorization requests
+	authTime, err := service.GetAuthTime(token)
+	if err != nil {
+		return "", false, &common.TokenInvalidError{}
+	}
+	c.Set("authTime", authTime)
+
+	// Sessions issued before e.g. an admin required the user to enroll new passkeys are no longer valid,
+	// even after the requirement has been cleared
+	if user.SessionsValidAfter != nil {
+		issuedAt, ok := token.IssuedAt()
+		if !ok || issuedAt.Before(user.SessionsValidAfter.ToTime().Truncate(time.Second)) {
+			return "", false, &common.NotSignedInError{}
+		}
+	}
+
+	authMethods, err := service.GetAuthMethods(token)
+	if err != nil {
+		return "", false, &common.TokenInvalidError{}
+	}
+	c.Set("authMethods", authMethods)
+
+	// Every request resets the idle timeout of the session, which is only possible if the token is stored in the cookie
+	if fromCookie {
+		m.renewSession(c, user, token)
+	}
+
 	return subject, isAdmin, nil
 }
+
+func (m *JwtAuthMiddleware) renewSession(c *gin.Context, user model.User, token jwt.Token) {
+	renewed, expiration, err := m.jwtService.RenewAccessToken(user, token)
+	if err != nil {
+		// The current token is still valid
+		slog.WarnContext(c.Request.Context(), "Failed to renew the session", slog.Any("error", err))
+		return
+	}
+	if renewed == "" {
+		return
+	}
+
+	maxAge := int(time.Until(expiration).Seconds())
+	cookie.AddAccessTokenCookie(c, maxAge, renewed)
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// Key in the gin context where the original request body is stored
+const originalRequestBodyKey = "originalRequestBody"
+
+// RequestLimitsMiddleware limits the size of the request body and the nesting depth of JSON bodies.
+// It can be added to a route group to set the defaults, and again to a sub-group or route to override them.
+type RequestLimitsMiddleware struct{}
+
+func NewRequestLimitsMiddleware() *RequestLimitsMiddleware {
+	return &RequestLimitsMiddleware{}
+}
+
+func (m *RequestLimitsMiddleware) Add(maxBodySize int64, maxJSONDepth int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		body := limitRequestBody(c, maxBodySize)
+		if maxJSONDepth > 0 && isJSONRequest(c.Request) {
+			body = &jsonDepthLimitReader{ReadCloser: body, maxDepth: maxJSONDepth}
+		}
+		c.Request.Body = body
+
+		c.Next()
+	}
+}
+
+// limitRequestBody wraps the original request body in a reader that fails once maxSize bytes are read.
+// The original body is used (rather than the current one) so that a more specific limit can replace a previous one.
+func limitRequestBody(c *gin.Context, maxSize int64) io.ReadCloser {
+	original, ok := c.Get(originalRequestBodyKey)
+	if !ok {
+		original = c.Request.Body
+		c.Set(originalRequestBodyKey, original)
+	}
+
+	originalBody, _ := original.(io.ReadCloser)
+	if originalBody == nil {
+		originalBody = http.NoBody
+	}
+
+	return &maxBytesReader{
+		ReadCloser: http.MaxBytesReader(c.Writer, originalBody, maxSize),
+		maxSize:    maxSize,
+	}
+}
+
+func isJSONRequest(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/json")
+}
+
+// maxBytesReader converts the error returned by http.MaxBytesReader to an error that is returned to the client
+type maxBytesReader struct {
+	io.ReadCloser
+	maxSize int64
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return n, &common.RequestBodyTooLargeError{MaxSize: formatFileSize(r.maxSize)}
+	}
+	return n, err
+}
+
+// jsonDepthLimitReader returns an error if the JSON document that is read is nested deeper than maxDepth.
+// It tracks the depth while the data is streamed, so the body doesn't need to be buffered.
+type jsonDepthLimitReader struct {
+	io.ReadCloser
+	maxDepth int
+
+	depth    int
+	inString bool
+	escaped  bool
+}
+
+func (r *jsonDepthLimitReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	for _, b := range p[:n] {
+		if r.inString {
+			switch {
+			case r.escaped:
+				r.escaped = false
+			case b == '\\':
+				r.escaped = true
+			case b == '"':
+				r.inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			r.inString = true
+		case '{', '[':
+			r.depth++
+			if r.depth > r.maxDepth {
+				return 0, &common.JSONTooDeepError{MaxDepth: r.maxDepth}
+			}
+		case '}', ']':
+			r.depth--
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestLimitsMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	newRouter := func() *gin.Engine {
+		r := gin.New()
+		r.Use(NewErrorHandlerMiddleware().Add())
+
+		handler := func(c *gin.Context) {
+			var body any
+			if err := c.ShouldBindJSON(&body); err != nil {
+				_ = c.Error(err)
+				return
+			}
+			c.Status(http.StatusNoContent)
+		}
+
+		group := r.Group("/", NewRequestLimitsMiddleware().Add(64, 3))
+		group.POST("/default", handler)
+		group.POST("/override", NewRequestLimitsMiddleware().Add(1024, 3), handler)
+		return r
+	}
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		expectedStatus int
+	}{
+		{"small body", "/default", `{"a":[1,2,3]}`, http.StatusNoContent},
+		{"body too large", "/default", `{"a":"` + strings.Repeat("x", 100) + `"}`, http.StatusRequestEntityTooLarge},
+		{"override allows larger body", "/override", `{"a":"` + strings.Repeat("x", 100) + `"}`, http.StatusNoContent},
+		{"max depth", "/default", `{"a":{"b":[1]}}`, http.StatusNoContent},
+		{"too deep", "/default", `{"a":{"b":[[1]]}}`, http.StatusRequestEntityTooLarge},
+		{"brackets in strings are ignored", "/default", `{"a":"[[[[{{{{\"[["}`, http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			newRouter().ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code, w.Body.String())
+		})
+	}
+}
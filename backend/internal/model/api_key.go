@@ -12,6 +12,13 @@ type ApiKey struct {
 	LastUsedAt          *datatype.DateTime `sortable:"true"`
 	ExpirationEmailSent bool
 
+	// After a rotation, the previous secret remains valid until PreviousKeyExpiresAt
+	PreviousKey          *string
+	PreviousKeyExpiresAt *datatype.DateTime
+
 	UserID string
 	User   User
 }
+
+// DefaultSort lists the newest API keys first
+func (a ApiKey) DefaultSort() (string, bool) { return "created_at", true }
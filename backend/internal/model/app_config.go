@@ -1,6 +1,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
@@ -14,7 +15,12 @@ import (
 
 type AppConfigVariable struct {
 	Key   string `gorm:"primaryKey;not null"`
-	Value string
+	Value string `gorm:"serializer:encrypted"`
+}
+
+// ShouldEncrypt returns true if the value is encrypted at rest, which is the case of the sensitive values only
+func (a *AppConfigVariable) ShouldEncrypt() bool {
+	return IsSensitiveAppConfigKey(a.Key)
 }
 
 // IsTrue returns true if the value is a truthy string, such as "true", "t", "yes", "1", etc.
@@ -23,6 +29,20 @@ func (a *AppConfigVariable) IsTrue() bool {
 	return ok
 }
 
+// AsLocalizedStrings returns the value as a map of locale codes to strings, interpreting the string as a JSON object.
+func (a *AppConfigVariable) AsLocalizedStrings() (map[string]string, error) {
+	if a.Value == "" {
+		return map[string]string{}, nil
+	}
+
+	var res map[string]string
+	err := json.Unmarshal([]byte(a.Value), &res)
+	if err != nil {
+		return nil, fmt.Errorf("invalid localized strings: %w", err)
+	}
+	return res, nil
+}
+
 // AsDurationMinutes returns the value as a time.Duration, interpreting the string as a whole number of minutes.
 func (a *AppConfigVariable) AsDurationMinutes() time.Duration {
 	val, err := strconv.Atoi(a.Value)
@@ -32,24 +52,87 @@ func (a *AppConfigVariable) AsDurationMinutes() time.Duration {
 	return time.Duration(val) * time.Minute
 }
 
+// AsDurationSeconds returns the value as a time.Duration, interpreting the string as a whole number of seconds.
+func (a *AppConfigVariable) AsDurationSeconds() time.Duration {
+	val, err := strconv.Atoi(a.Value)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(val) * time.Second
+}
+
 type AppConfig struct {
 	// General
-	AppName             AppConfigVariable `key:"appName,public"` // Public
-	SessionDuration     AppConfigVariable `key:"sessionDuration"`
-	EmailsVerified      AppConfigVariable `key:"emailsVerified"`
-	AccentColor         AppConfigVariable `key:"accentColor,public"`         // Public
-	DisableAnimations   AppConfigVariable `key:"disableAnimations,public"`   // Public
-	AllowOwnAccountEdit AppConfigVariable `key:"allowOwnAccountEdit,public"` // Public
-	AllowUserSignups    AppConfigVariable `key:"allowUserSignups,public"`    // Public
+	AppName                   AppConfigVariable `key:"appName,public"`          // Public
+	AppNameLocalized          AppConfigVariable `key:"appNameLocalized,public"` // Public
+	SessionDuration           AppConfigVariable `key:"sessionDuration"`
+	SessionIdleTimeout        AppConfigVariable `key:"sessionIdleTimeout"`
+	AuthorizationCodeLifetime AppConfigVariable `key:"authorizationCodeLifetime"` // In seconds
+	EmailsVerified            AppConfigVariable `key:"emailsVerified"`
+	AccentColor               AppConfigVariable `key:"accentColor,public"`               // Public
+	DisableAnimations         AppConfigVariable `key:"disableAnimations,public"`         // Public
+	AllowOwnAccountEdit       AppConfigVariable `key:"allowOwnAccountEdit,public"`       // Public
+	AllowUserSignups          AppConfigVariable `key:"allowUserSignups,public"`          // Public
+	GenerateUsernameFromEmail AppConfigVariable `key:"generateUsernameFromEmail,public"` // Public
+	AllowUserSelfDeletion     AppConfigVariable `key:"allowUserSelfDeletion,public"`     // Public
+	LoginEmailEnabled         AppConfigVariable `key:"loginEmailEnabled,public"`         // Public
+	UnicodeNormalizationForm  AppConfigVariable `key:"unicodeNormalizationForm"`
+	// Locales
+	DefaultLocale AppConfigVariable `key:"defaultLocale"`
+	// Comma-separated list of the locales users can select
+	SupportedLocales AppConfigVariable `key:"supportedLocales"`
+	// Claims
+	PreferredUsernameClaim AppConfigVariable `key:"preferredUsernameClaim"`
+	NameClaimTemplate      AppConfigVariable `key:"nameClaimTemplate"`
+	// Email domains
+	AllowedEmailDomains           AppConfigVariable `key:"allowedEmailDomains"`
+	AllowedEmailDomainsExemptLdap AppConfigVariable `key:"allowedEmailDomainsExemptLdap"`
+	// Signups
+	SignupBlockedEmailDomains         AppConfigVariable `key:"signupBlockedEmailDomains"`
+	SignupBlockDisposableEmailDomains AppConfigVariable `key:"signupBlockDisposableEmailDomains"`
+	// User groups
+	UserGroupFriendlyNameUnique AppConfigVariable `key:"userGroupFriendlyNameUnique"`
+	UserGroupNameSlugEnforced   AppConfigVariable `key:"userGroupNameSlugEnforced"`
+	// Inactive users
+	InactiveUserDisableDays  AppConfigVariable `key:"inactiveUserDisableDays"`
+	InactiveUserWarningDays  AppConfigVariable `key:"inactiveUserWarningDays"`
+	InactiveUserExemptAdmins AppConfigVariable `key:"inactiveUserExemptAdmins"`
+	InactiveUserExemptGroups AppConfigVariable `key:"inactiveUserExemptGroups"`
+	// Geoblocking
+	GeoblockingMode         AppConfigVariable `key:"geoblockingMode"`
+	GeoblockingCountries    AppConfigVariable `key:"geoblockingCountries"`
+	GeoblockingAllowUnknown AppConfigVariable `key:"geoblockingAllowUnknown"`
+	GeoblockingExemptAdmins AppConfigVariable `key:"geoblockingExemptAdmins"`
+	// Suspicious sign ins
+	SuspiciousSignInDetectionEnabled AppConfigVariable `key:"suspiciousSignInDetectionEnabled"`
+	SuspiciousSignInMaxSpeed         AppConfigVariable `key:"suspiciousSignInMaxSpeed"`
+	SuspiciousSignInMinDistance      AppConfigVariable `key:"suspiciousSignInMinDistance"`
+	SuspiciousSignInWebhookUrl       AppConfigVariable `key:"suspiciousSignInWebhookUrl,sensitive"`
+	// Token issuance audit
+	TokenIssuanceAuditEnabled    AppConfigVariable `key:"tokenIssuanceAuditEnabled"`
+	TokenIssuanceAuditSampleRate AppConfigVariable `key:"tokenIssuanceAuditSampleRate"`
+	// Credential limits
+	MaxApiKeysPerUser            AppConfigVariable `key:"maxApiKeysPerUser"`
+	MaxPasskeysPerUser           AppConfigVariable `key:"maxPasskeysPerUser"`
+	CredentialLimitsExemptAdmins AppConfigVariable `key:"credentialLimitsExemptAdmins"`
+	// Maintenance mode
+	MaintenanceModeEnabled AppConfigVariable `key:"maintenanceModeEnabled,public"` // Public
+	MaintenanceModeMessage AppConfigVariable `key:"maintenanceModeMessage,public"` // Public
 	// Internal
 	BackgroundImageType AppConfigVariable `key:"backgroundImageType,internal"` // Internal
 	LogoLightImageType  AppConfigVariable `key:"logoLightImageType,internal"`  // Internal
 	LogoDarkImageType   AppConfigVariable `key:"logoDarkImageType,internal"`   // Internal
 	InstanceID          AppConfigVariable `key:"instanceId,internal"`          // Internal
+	PairwiseSubjectSalt AppConfigVariable `key:"pairwiseSubjectSalt,internal"` // Internal
+	EmailUnsubscribeKey AppConfigVariable `key:"emailUnsubscribeKey,internal"` // Internal
 	// Email
 	SmtpHost                                   AppConfigVariable `key:"smtpHost"`
 	SmtpPort                                   AppConfigVariable `key:"smtpPort"`
 	SmtpFrom                                   AppConfigVariable `key:"smtpFrom"`
+	SmtpFromOnboarding                         AppConfigVariable `key:"smtpFromOnboarding"`
+	SmtpFromSecurity                           AppConfigVariable `key:"smtpFromSecurity"`
+	SmtpFromNotifications                      AppConfigVariable `key:"smtpFromNotifications"`
+	SmtpReplyToNotifications                   AppConfigVariable `key:"smtpReplyToNotifications"`
 	SmtpUser                                   AppConfigVariable `key:"smtpUser"`
 	SmtpPassword                               AppConfigVariable `key:"smtpPassword,sensitive"`
 	SmtpTls                                    AppConfigVariable `key:"smtpTls"`
@@ -58,6 +141,12 @@ type AppConfig struct {
 	EmailOneTimeAccessAsUnauthenticatedEnabled AppConfigVariable `key:"emailOneTimeAccessAsUnauthenticatedEnabled,public"` // Public
 	EmailOneTimeAccessAsAdminEnabled           AppConfigVariable `key:"emailOneTimeAccessAsAdminEnabled,public"`           // Public
 	EmailApiKeyExpirationEnabled               AppConfigVariable `key:"emailApiKeyExpirationEnabled"`
+	EmailWelcomeEnabled                        AppConfigVariable `key:"emailWelcomeEnabled"`
+	// What happens to one-time access emails if the SMTP server can't be reached: "queue" to retry later, or "fail" to reject the request
+	EmailUnavailableBehavior AppConfigVariable `key:"emailUnavailableBehavior"`
+	// Whether users receive notifications they haven't set a preference for: "enabled", "disabled", or "mandatory" to prevent users from unsubscribing
+	EmailLoginNotificationUserDefault AppConfigVariable `key:"emailLoginNotificationUserDefault"`
+	EmailApiKeyExpirationUserDefault  AppConfigVariable `key:"emailApiKeyExpirationUserDefault"`
 	// LDAP
 	LdapEnabled                        AppConfigVariable `key:"ldapEnabled,public"` // Public
 	LdapUrl                            AppConfigVariable `key:"ldapUrl"`
@@ -78,6 +167,16 @@ type AppConfig struct {
 	LdapAttributeGroupName             AppConfigVariable `key:"ldapAttributeGroupName"`
 	LdapAttributeAdminGroup            AppConfigVariable `key:"ldapAttributeAdminGroup"`
 	LdapSoftDeleteUsers                AppConfigVariable `key:"ldapSoftDeleteUsers"`
+	LdapMissingUserGraceSyncs          AppConfigVariable `key:"ldapMissingUserGraceSyncs"`
+	LdapMissingUserGraceDays           AppConfigVariable `key:"ldapMissingUserGraceDays"`
+	LdapSyncNotification               AppConfigVariable `key:"ldapSyncNotification"`
+	LdapSyncNotificationWebhookUrl     AppConfigVariable `key:"ldapSyncNotificationWebhookUrl,sensitive"`
+	LdapSyncRemovalAlertThreshold      AppConfigVariable `key:"ldapSyncRemovalAlertThreshold"`
+	LdapRateLimit                      AppConfigVariable `key:"ldapRateLimit"`
+	LdapCircuitBreakerThreshold        AppConfigVariable `key:"ldapCircuitBreakerThreshold"`
+	LdapCircuitBreakerCooldown         AppConfigVariable `key:"ldapCircuitBreakerCooldown"`
+	// "skip" or "abort"; what the sync does with users that share the same unique identifier
+	LdapDuplicateIdentifierBehavior AppConfigVariable `key:"ldapDuplicateIdentifierBehavior"`
 }
 
 func (c *AppConfig) ToAppConfigVariableSlice(showAll bool, redactSensitiveValues bool) []AppConfigVariable {
@@ -118,6 +217,95 @@ func (c *AppConfig) ToAppConfigVariableSlice(showAll bool, redactSensitiveValues
 	return res
 }
 
+// AppNameForLocale returns the app name to show to users with the given locale.
+// It looks for an override matching the full locale (e.g. "de-CH") first, then the language only (e.g. "de"),
+// then does the same for the default locale, and falls back to the default app name.
+func (c *AppConfig) AppNameForLocale(locale *string) string {
+	overrides, err := c.AppNameLocalized.AsLocalizedStrings()
+	if err != nil || len(overrides) == 0 {
+		return c.AppName.Value
+	}
+
+	// The keys are validated to be unique once normalized
+	normalizedOverrides := make(map[string]string, len(overrides))
+	for key, name := range overrides {
+		normalizedOverrides[NormalizeLocale(key)] = name
+	}
+
+	if locale != nil && *locale != "" {
+		if name, ok := localizedAppName(normalizedOverrides, *locale); ok {
+			return name
+		}
+	}
+	if name, ok := localizedAppName(normalizedOverrides, c.DefaultLocale.Value); ok {
+		return name
+	}
+	return c.AppName.Value
+}
+
+// localizedAppName returns the override matching the full locale, or else the language of the locale
+func localizedAppName(normalizedOverrides map[string]string, locale string) (string, bool) {
+	normalized := NormalizeLocale(locale)
+	if name, ok := normalizedOverrides[normalized]; ok {
+		return name, true
+	}
+
+	language, _, _ := strings.Cut(normalized, "-")
+	name, ok := normalizedOverrides[language]
+	return name, ok
+}
+
+// SupportedLocaleList returns the locales users can select
+func (c *AppConfig) SupportedLocaleList() []string {
+	var locales []string
+	for locale := range strings.SplitSeq(c.SupportedLocales.Value, ",") {
+		locale = strings.TrimSpace(locale)
+		if locale != "" {
+			locales = append(locales, locale)
+		}
+	}
+	return locales
+}
+
+// MatchSupportedLocale returns the supported locale matching the given one, ignoring the case and whether "-" or "_" is used as separator.
+// It returns false if the locale isn't supported.
+func (c *AppConfig) MatchSupportedLocale(locale string) (string, bool) {
+	normalized := NormalizeLocale(locale)
+	for _, supported := range c.SupportedLocaleList() {
+		if NormalizeLocale(supported) == normalized {
+			return supported, true
+		}
+	}
+	return "", false
+}
+
+// LocaleFor returns the locale to use for a user with the given locale, which is the default locale if the user's one isn't set or supported
+func (c *AppConfig) LocaleFor(locale *string) string {
+	if locale != nil {
+		if supported, ok := c.MatchSupportedLocale(*locale); ok {
+			return supported
+		}
+	}
+	return c.DefaultLocale.Value
+}
+
+// NormalizeLocale returns the locale in lower case, with hyphens as separators, so locales can be compared
+func NormalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
+
+// IsSensitiveAppConfigKey returns true if the value of the key is a secret, like a password
+func IsSensitiveAppConfigKey(key string) bool {
+	rt := reflect.TypeFor[AppConfig]()
+	for i := range rt.NumField() {
+		keyFromTag, attrs, _ := strings.Cut(rt.Field(i).Tag.Get("key"), ",")
+		if keyFromTag == key {
+			return attrs == "sensitive"
+		}
+	}
+	return false
+}
+
 func (c *AppConfig) FieldByKey(key string) (defaultValue string, isInternal bool, err error) {
 	rv := reflect.ValueOf(c).Elem()
 	rt := rv.Type()
@@ -11,6 +11,7 @@ import (
 
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
 func TestAppConfigVariable_AsMinutesDuration(t *testing.T) {
@@ -65,6 +66,77 @@ func TestAppConfigVariable_AsMinutesDuration(t *testing.T) {
 	}
 }
 
+func TestAppConfig_AppNameForLocale(t *testing.T) {
+	config := &model.AppConfig{
+		AppName:          model.AppConfigVariable{Value: "Pocket ID"},
+		AppNameLocalized: model.AppConfigVariable{Value: `{"de":"Pocket ID DE","fr-CA":"Pocket ID Québec"}`},
+	}
+
+	tests := []struct {
+		name     string
+		locale   *string
+		expected string
+	}{
+		{name: "nil locale", locale: nil, expected: "Pocket ID"},
+		{name: "exact match", locale: utils.Ptr("de"), expected: "Pocket ID DE"},
+		{name: "region falls back to language", locale: utils.Ptr("de-CH"), expected: "Pocket ID DE"},
+		{name: "region match", locale: utils.Ptr("fr_ca"), expected: "Pocket ID Québec"},
+		{name: "language without override", locale: utils.Ptr("fr"), expected: "Pocket ID"},
+		{name: "unknown locale", locale: utils.Ptr("it"), expected: "Pocket ID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, config.AppNameForLocale(tt.locale))
+		})
+	}
+
+	t.Run("falls back to the default locale", func(t *testing.T) {
+		withDefault := &model.AppConfig{
+			AppName:          model.AppConfigVariable{Value: "Pocket ID"},
+			AppNameLocalized: config.AppNameLocalized,
+			DefaultLocale:    model.AppConfigVariable{Value: "de-AT"},
+		}
+		assert.Equal(t, "Pocket ID DE", withDefault.AppNameForLocale(nil))
+		assert.Equal(t, "Pocket ID DE", withDefault.AppNameForLocale(utils.Ptr("it")))
+		assert.Equal(t, "Pocket ID Québec", withDefault.AppNameForLocale(utils.Ptr("fr-CA")))
+	})
+
+	t.Run("invalid overrides fall back to the default", func(t *testing.T) {
+		invalid := &model.AppConfig{
+			AppName:          model.AppConfigVariable{Value: "Pocket ID"},
+			AppNameLocalized: model.AppConfigVariable{Value: "not json"},
+		}
+		assert.Equal(t, "Pocket ID", invalid.AppNameForLocale(utils.Ptr("de")))
+	})
+}
+
+func TestAppConfig_LocaleFor(t *testing.T) {
+	config := &model.AppConfig{
+		DefaultLocale:    model.AppConfigVariable{Value: "en"},
+		SupportedLocales: model.AppConfigVariable{Value: "de, en,pt-BR,"},
+	}
+
+	assert.Equal(t, []string{"de", "en", "pt-BR"}, config.SupportedLocaleList())
+
+	tests := []struct {
+		name     string
+		locale   *string
+		expected string
+	}{
+		{name: "nil locale", locale: nil, expected: "en"},
+		{name: "supported locale", locale: utils.Ptr("de"), expected: "de"},
+		{name: "different case and separator", locale: utils.Ptr("pt_br"), expected: "pt-BR"},
+		{name: "unsupported locale", locale: utils.Ptr("fr"), expected: "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, config.LocaleFor(tt.locale))
+		})
+	}
+}
+
 // This test ensures that the model.AppConfig and dto.AppConfigUpdateDto structs match:
 // - They should have the same properties, where the "json" tag of dto.AppConfigUpdateDto should match the "key" tag in model.AppConfig
 // - dto.AppConfigDto should not include "internal" fields from model.AppConfig
@@ -103,7 +175,7 @@ func TestAppConfigStructMatchesUpdateDto(t *testing.T) {
 
 	// Verify every AppConfig field has a matching DTO field with the same name
 	for fieldName, keyName := range appConfigFields {
-		if strings.HasSuffix(fieldName, "ImageType") || keyName == "instanceId" {
+		if strings.HasSuffix(fieldName, "ImageType") || keyName == "instanceId" || keyName == "pairwiseSubjectSalt" || keyName == "emailUnsubscribeKey" {
 			// Skip internal fields that shouldn't be in the DTO
 			continue
 		}
@@ -115,6 +187,11 @@ func TestAppConfigStructMatchesUpdateDto(t *testing.T) {
 
 	// Verify every DTO field has a matching AppConfig field
 	for jsonName, fieldName := range dtoFields {
+		if jsonName == "themePreset" {
+			// The theme preset is only applied when updating and isn't stored
+			continue
+		}
+
 		// Find a matching field in AppConfig by key tag
 		found := false
 		for _, keyName := range appConfigFields {
@@ -16,23 +16,51 @@ type AuditLog struct {
 	UserAgent string        `sortable:"true"`
 	Username  string        `gorm:"-"`
 	Data      AuditLogData
+	// True if the location of the IP address was looked up but isn't known, so it isn't looked up again
+	LocationUnknown bool
 
 	UserID string
 	User   User
 }
 
+// DefaultSort lists the newest entries first
+func (a AuditLog) DefaultSort() (string, bool) { return "created_at", true }
+
 type AuditLogData map[string]string //nolint:recvcheck
 
 type AuditLogEvent string //nolint:recvcheck
 
 const (
-	AuditLogEventSignIn                     AuditLogEvent = "SIGN_IN"
-	AuditLogEventOneTimeAccessTokenSignIn   AuditLogEvent = "TOKEN_SIGN_IN"
-	AuditLogEventAccountCreated             AuditLogEvent = "ACCOUNT_CREATED"
-	AuditLogEventClientAuthorization        AuditLogEvent = "CLIENT_AUTHORIZATION"
-	AuditLogEventNewClientAuthorization     AuditLogEvent = "NEW_CLIENT_AUTHORIZATION"
-	AuditLogEventDeviceCodeAuthorization    AuditLogEvent = "DEVICE_CODE_AUTHORIZATION"
-	AuditLogEventNewDeviceCodeAuthorization AuditLogEvent = "NEW_DEVICE_CODE_AUTHORIZATION"
+	AuditLogEventSignIn                      AuditLogEvent = "SIGN_IN"
+	AuditLogEventOneTimeAccessTokenSignIn    AuditLogEvent = "TOKEN_SIGN_IN"
+	AuditLogEventAccountCreated              AuditLogEvent = "ACCOUNT_CREATED"
+	AuditLogEventClientAuthorization         AuditLogEvent = "CLIENT_AUTHORIZATION"
+	AuditLogEventNewClientAuthorization      AuditLogEvent = "NEW_CLIENT_AUTHORIZATION"
+	AuditLogEventDeviceCodeAuthorization     AuditLogEvent = "DEVICE_CODE_AUTHORIZATION"
+	AuditLogEventNewDeviceCodeAuthorization  AuditLogEvent = "NEW_DEVICE_CODE_AUTHORIZATION"
+	AuditLogEventAccountDeletionRequested    AuditLogEvent = "ACCOUNT_DELETION_REQUESTED"
+	AuditLogEventAccountDeleted              AuditLogEvent = "ACCOUNT_DELETED"
+	AuditLogEventUserGroupBulkAssignment     AuditLogEvent = "USER_GROUP_BULK_ASSIGNMENT"
+	AuditLogEventUserGroupImport             AuditLogEvent = "USER_GROUP_IMPORT"
+	AuditLogEventConfigChanged               AuditLogEvent = "CONFIG_CHANGED"
+	AuditLogEventClientCredentialsToken      AuditLogEvent = "CLIENT_CREDENTIALS_TOKEN"
+	AuditLogEventInactiveUserDisabled        AuditLogEvent = "INACTIVE_USER_DISABLED"
+	AuditLogEventTokenExchange               AuditLogEvent = "TOKEN_EXCHANGE"
+	AuditLogEventSignInGeoblocked            AuditLogEvent = "SIGN_IN_GEOBLOCKED"
+	AuditLogEventSuspiciousSignIn            AuditLogEvent = "SUSPICIOUS_SIGN_IN"
+	AuditLogEventPasskeyReenrollmentRequired AuditLogEvent = "PASSKEY_REENROLLMENT_REQUIRED"
+	AuditLogEventPasskeyReenrollmentCleared  AuditLogEvent = "PASSKEY_REENROLLMENT_CLEARED"
+	AuditLogEventSessionRevoked              AuditLogEvent = "SESSION_REVOKED"
+	AuditLogEventTokenIssued                 AuditLogEvent = "TOKEN_ISSUED"
+	AuditLogEventOidcClientCloned            AuditLogEvent = "OIDC_CLIENT_CLONED"
+	AuditLogEventClientTokensRevoked         AuditLogEvent = "CLIENT_TOKENS_REVOKED"
+	AuditLogEventAuthorizationCodeReused     AuditLogEvent = "AUTHORIZATION_CODE_REUSED"
+	AuditLogEventOneTimeAccessLockout        AuditLogEvent = "ONE_TIME_ACCESS_LOCKOUT"
+	AuditLogEventOneTimeAccessCodeRevoked    AuditLogEvent = "ONE_TIME_ACCESS_CODE_REVOKED"
+	AuditLogEventApiKeyRotated               AuditLogEvent = "API_KEY_ROTATED"
+	AuditLogEventLdapSyncTriggered           AuditLogEvent = "LDAP_SYNC_TRIGGERED"
+	AuditLogEventLdapDuplicateIdentifier     AuditLogEvent = "LDAP_DUPLICATE_IDENTIFIER"
+	AuditLogEventClientAuthorizationBlocked  AuditLogEvent = "CLIENT_AUTHORIZATION_BLOCKED"
 )
 
 // Scan and Value methods for GORM to handle the custom type
@@ -0,0 +1,46 @@
+package model
+
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// BackgroundJobStatus is the state of a background job
+type BackgroundJobStatus string
+
+const (
+	BackgroundJobStatusQueued    BackgroundJobStatus = "queued"
+	BackgroundJobStatusRunning   BackgroundJobStatus = "running"
+	BackgroundJobStatusSucceeded BackgroundJobStatus = "succeeded"
+	BackgroundJobStatusFailed    BackgroundJobStatus = "failed"
+	BackgroundJobStatusCanceled  BackgroundJobStatus = "canceled"
+)
+
+// IsFinished returns true if the job won't run anymore
+func (s BackgroundJobStatus) IsFinished() bool {
+	return s == BackgroundJobStatusSucceeded || s == BackgroundJobStatusFailed || s == BackgroundJobStatusCanceled
+}
+
+// BackgroundJob is a long-running operation that is submitted by a request and run by a background worker
+type BackgroundJob struct {
+	Base
+
+	Type   string
+	Status BackgroundJobStatus
+	// JSON-encoded input of the job, whose format depends on the type
+	Payload     string
+	ActorUserID *string
+
+	ProgressDone  int
+	ProgressTotal int
+	// JSON-encoded result of the job, set if it succeeded
+	Result *string
+	Error  *string
+
+	CancelRequested bool
+	// The worker running the job extends the lock periodically; if it expires, the worker has stopped
+	LockedUntil *datatype.DateTime
+	StartedAt   *datatype.DateTime
+	FinishedAt  *datatype.DateTime
+}
+
+func (j BackgroundJob) DefaultSort() (string, bool) { return "created_at", true }
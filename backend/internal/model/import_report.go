@@ -0,0 +1,29 @@
+package model
+
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// ImportReportType is the kind of bulk import a report belongs to
+type ImportReportType string
+
+const (
+	ImportReportTypeUserGroups ImportReportType = "userGroups"
+)
+
+// ImportReport is the outcome of every row of a bulk import, kept until it expires so admins can download it
+type ImportReport struct {
+	Base
+
+	ExpiresAt   datatype.DateTime
+	Type        ImportReportType
+	ActorUserID *string
+	// Committed is false if the import was all-or-nothing and nothing was saved
+	Committed   bool
+	TotalCount  int
+	FailedCount int
+	// JSON-encoded list of the outcomes of the rows, whose format depends on the type
+	Entries string
+}
+
+func (r ImportReport) DefaultSort() (string, bool) { return "created_at", true }
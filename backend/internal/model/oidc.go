@@ -14,6 +14,8 @@ type UserAuthorizedOidcClient struct {
 	Scope  string
 	UserID string `gorm:"primary_key;"`
 	User   User
+	// Subject is the pairwise subject of the user for clients that use pairwise subjects, so that the user of an access token can be found
+	Subject *string
 
 	ClientID string `gorm:"primary_key;"`
 	Client   OidcClient
@@ -28,6 +30,14 @@ type OidcAuthorizationCode struct {
 	CodeChallenge             *string
 	CodeChallengeMethodSha256 *bool
 	ExpiresAt                 datatype.DateTime
+	AuthTime                  *datatype.DateTime
+	// Space-separated AMR values of the sign in, and the resulting ACR value
+	AuthMethods string
+	Acr         string
+	// Resources are the resource servers (RFC 8707) requested in the authorization request; if empty, all the audiences of the client are allowed
+	Resources StringList
+	// UsedAt is when the code was exchanged for tokens; the code is kept until it expires to detect reuse
+	UsedAt *datatype.DateTime
 
 	UserID string
 	User   User
@@ -47,18 +57,58 @@ type OidcClient struct {
 	IsPublic           bool
 	PkceEnabled        bool
 	Credentials        OidcClientCredentials
+	// Grant types the client can use at the token endpoint
+	GrantTypes StringList
+	// Scopes of the access tokens issued to the client itself with the client_credentials grant
+	ClientCredentialsScopes StringList
+	// Additional audiences of the access tokens issued to the client, e.g. the identifiers of the resource servers
+	Audiences StringList
+	// URL of the JWK set of the client, used to verify signed request objects
+	JwksURL string
+	// If true, the authorization parameters must be sent in a signed request object
+	RequireSignedRequestObject bool
+	// If true, the name and logo of the client are shown on the login pages when the user signs in to authorize it
+	LoginBrandingEnabled bool
+	// If true, users without an email address can't authorize the client, e.g. because it identifies its accounts by email
+	RequireEmail bool
+	// SubjectType is "public" if the subject of the tokens is the user ID, or "pairwise" if it's derived for the sector of the client
+	SubjectType string
+	// PairwiseSectorIdentifier groups the clients that get the same pairwise subjects; the client ID is used if it's empty
+	PairwiseSectorIdentifier string
+	// TokensRevokedAt is when all tokens of the client were last revoked; access tokens issued before are rejected
+	TokensRevokedAt *datatype.DateTime
 
 	AllowedUserGroups []UserGroup `gorm:"many2many:oidc_clients_allowed_user_groups;"`
 	CreatedByID       string
 	CreatedBy         User
 }
 
+// OidcTokenExchange records the delegation of an access token issued with the token exchange grant.
+// Its ID is the "jti" claim of the token, so the "act" claim can be checked against the stored chain.
+type OidcTokenExchange struct {
+	Base
+
+	ClientID string
+	UserID   string
+	// ActorID is the subject of the "act" claim of the token, or empty if the token has no actor
+	ActorID string
+	// SubjectTokenID is the ID of the exchange that issued the subject token, if it was itself exchanged
+	SubjectTokenID *string
+	ExpiresAt      datatype.DateTime
+}
+
 type OidcRefreshToken struct {
 	Base
 
 	Token     string
 	ExpiresAt datatype.DateTime
 	Scope     string
+	// IpAddress is the IP address of the request that issued the token
+	IpAddress *string
+	// SessionStartedAt is when the first token of the session was issued; rotated tokens keep it
+	SessionStartedAt *datatype.DateTime
+	// Resources are the resource servers the session is limited to; rotated tokens keep them
+	Resources StringList
 
 	UserID string
 	User   User
@@ -67,6 +117,9 @@ type OidcRefreshToken struct {
 	Client   OidcClient
 }
 
+// DefaultSort lists the clients alphabetically
+func (c *OidcClient) DefaultSort() (string, bool) { return "name", false }
+
 func (c *OidcClient) AfterFind(_ *gorm.DB) (err error) {
 	// Compute HasLogo field
 	c.HasLogo = c.ImageType != nil && *c.ImageType != ""
@@ -84,18 +137,21 @@ type OidcClientFederatedIdentity struct {
 	JWKS     string `json:"jwks,omitempty"` // URL of the JWKS
 }
 
-func (occ OidcClientCredentials) FederatedIdentityForIssuer(issuer string) (OidcClientFederatedIdentity, bool) {
+// FederatedIdentitiesForIssuer returns all federated identities of the issuer.
+// A client can trust the same issuer several times, e.g. with different subjects.
+func (occ OidcClientCredentials) FederatedIdentitiesForIssuer(issuer string) []OidcClientFederatedIdentity {
 	if issuer == "" {
-		return OidcClientFederatedIdentity{}, false
+		return nil
 	}
 
+	var res []OidcClientFederatedIdentity
 	for _, fi := range occ.FederatedIdentities {
 		if fi.Issuer == issuer {
-			return fi, true
+			res = append(res, fi)
 		}
 	}
 
-	return OidcClientFederatedIdentity{}, false
+	return res
 }
 
 func (occ *OidcClientCredentials) Scan(value any) error {
@@ -130,6 +186,23 @@ func (cu UrlList) Value() (driver.Value, error) {
 	return json.Marshal(cu)
 }
 
+type StringList []string //nolint:recvcheck
+
+func (sl *StringList) Scan(value any) error {
+	switch v := value.(type) {
+	case []byte:
+		return json.Unmarshal(v, sl)
+	case string:
+		return json.Unmarshal([]byte(v), sl)
+	default:
+		return fmt.Errorf("unsupported type: %T", value)
+	}
+}
+
+func (sl StringList) Value() (driver.Value, error) {
+	return json.Marshal(sl)
+}
+
 type OidcDeviceCode struct {
 	Base
 	DeviceCode   string
@@ -0,0 +1,19 @@
+package model
+
+import (
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// OutboxMessage is a side effect that is stored together with the change that caused it, and delivered by a background worker
+type OutboxMessage struct {
+	Base
+
+	Type        string
+	Payload     string
+	Attempts    int
+	AvailableAt datatype.DateTime
+	LockedUntil *datatype.DateTime
+	LastError   *string
+	// Correlation ID of the request that enqueued the message
+	CorrelationID *string
+}
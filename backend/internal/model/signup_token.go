@@ -15,6 +15,9 @@ type SignupToken struct {
 	UsageCount int               `json:"usageCount" sortable:"true"`
 }
 
+// DefaultSort lists the newest tokens first
+func (st *SignupToken) DefaultSort() (string, bool) { return "created_at", true }
+
 func (st *SignupToken) IsExpired() bool {
 	return time.Time(st.ExpiresAt).Before(time.Now())
 }
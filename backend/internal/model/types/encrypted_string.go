@@ -0,0 +1,158 @@
+package datatype
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha3"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync/atomic"
+
+	"gorm.io/gorm/schema"
+
+	cryptoutils "github.com/pocket-id/pocket-id/backend/internal/utils/crypto"
+)
+
+// Prefix of the encrypted values stored in the database, which distinguishes them from the values stored before encryption was enabled
+const encryptedValuePrefix = "enc:v1:"
+
+// ErrEncryptionKeyMissing is returned when reading an encrypted value while no encryption key is configured
+var ErrEncryptionKeyMissing = errors.New("the value is encrypted, but DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE is not set")
+
+// Encryptable is implemented by models whose serialized values must only be encrypted in some rows.
+// Values of models that don't implement it are always encrypted.
+type Encryptable interface {
+	ShouldEncrypt() bool
+}
+
+var encryptionKey atomic.Pointer[[]byte]
+
+func init() {
+	schema.RegisterSerializer("encrypted", EncryptedSerializer{})
+}
+
+// SetEncryptionKey sets the key used by the "encrypted" serializer; if nil, new values are stored unencrypted.
+// The key must be derived with DeriveEncryptionKey.
+func SetEncryptionKey(key []byte) {
+	if len(key) == 0 {
+		encryptionKey.Store(nil)
+		return
+	}
+	encryptionKey.Store(&key)
+}
+
+// EncryptionEnabled returns true if an encryption key is set
+func EncryptionEnabled() bool {
+	return encryptionKey.Load() != nil
+}
+
+// DeriveEncryptionKey derives the 256-bit key used to encrypt the values from the key provided by the user
+func DeriveEncryptionKey(input []byte) []byte {
+	// Same construction as the key encryption key of the JWKs, with a different context so the keys are never the same
+	h := hmac.New(func() hash.Hash { return sha3.New256() }, input)
+	h.Write([]byte("pocketid/db-encryption"))
+	return h.Sum(nil)
+}
+
+// IsEncryptedValue returns true if the value stored in the database is encrypted
+func IsEncryptedValue(value string) bool {
+	return strings.HasPrefix(value, encryptedValuePrefix)
+}
+
+// EncryptValue encrypts a value with AES-GCM, in the format stored in the database
+func EncryptValue(key []byte, value string) (string, error) {
+	enc, err := cryptoutils.Encrypt(key, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	return encryptedValuePrefix + base64.StdEncoding.EncodeToString(enc), nil
+}
+
+// DecryptValue decrypts a value stored in the database.
+// Values that aren't encrypted are returned as-is, so that the values stored before encryption was enabled can still be read.
+func DecryptValue(key []byte, value string) (string, error) {
+	if !IsEncryptedValue(value) {
+		return value, nil
+	}
+	if len(key) == 0 {
+		return "", ErrEncryptionKeyMissing
+	}
+
+	enc, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, encryptedValuePrefix))
+	if err != nil {
+		return "", fmt.Errorf("not a valid base64-encoded value: %w", err)
+	}
+
+	data, err := cryptoutils.Decrypt(key, enc, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// EncryptedSerializer is a GORM serializer for string fields, used with the tag `gorm:"serializer:encrypted"`.
+// Values are encrypted on write if an encryption key is set, and decrypted on read.
+type EncryptedSerializer struct{}
+
+func (EncryptedSerializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue any) error {
+	var stored string
+	switch v := dbValue.(type) {
+	case nil:
+		// Keep the empty string
+	case string:
+		stored = v
+	case []byte:
+		stored = string(v)
+	default:
+		return fmt.Errorf("unexpected type for encrypted value: %T", dbValue)
+	}
+
+	var key []byte
+	if k := encryptionKey.Load(); k != nil {
+		key = *k
+	}
+	value, err := DecryptValue(key, stored)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt column '%s': %w", field.DBName, err)
+	}
+
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+func (EncryptedSerializer) Value(_ context.Context, field *schema.Field, dst reflect.Value, fieldValue any) (any, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected type for encrypted value: %T", fieldValue)
+	}
+	if value == "" || !shouldEncrypt(dst) {
+		return value, nil
+	}
+
+	k := encryptionKey.Load()
+	if k == nil {
+		slog.Warn("Storing a sensitive value unencrypted in the database; set DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE to encrypt it",
+			slog.String("table", field.Schema.Table),
+			slog.String("column", field.DBName),
+		)
+		return value, nil
+	}
+
+	return EncryptValue(*k, value)
+}
+
+func shouldEncrypt(dst reflect.Value) bool {
+	if dst.CanAddr() {
+		dst = dst.Addr()
+	}
+	if !dst.IsValid() || !dst.CanInterface() {
+		return true
+	}
+	e, ok := dst.Interface().(Encryptable)
+	return !ok || e.ShouldEncrypt()
+}
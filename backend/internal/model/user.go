@@ -21,12 +21,33 @@ type User struct {
 	Locale    *string
 	LdapID    *string
 	Disabled  bool `sortable:"true"`
+	// LoginEmail is used instead of Email to look up the user for one-time access emails, if separate login emails are enabled
+	LoginEmail *string
+	// LastLoginAt is the time of the last successful sign in, which is used to disable inactive users
+	LastLoginAt *datatype.DateTime `sortable:"true"`
+	// LastLoginIP is the IP address of the last interactive sign in
+	LastLoginIP *string
+	// InactivityEmailSent is true if the user has been warned that the account will be disabled because of inactivity
+	InactivityEmailSent bool
+	// PasskeyReenrollmentRequiredAt is set when an admin requires the user to enroll new passkeys, until the user adds one
+	PasskeyReenrollmentRequiredAt *datatype.DateTime
+	// SessionsValidAfter invalidates all sessions issued before it; it's kept when the re-enrollment requirement is cleared
+	SessionsValidAfter *datatype.DateTime
+	// LdapMissingSince is set when an LDAP user is missing from a sync, and LdapMissingSyncs counts the consecutive syncs it has been missing from
+	LdapMissingSince *datatype.DateTime
+	LdapMissingSyncs int
+	// MaxApiKeys and MaxPasskeys override the limits of the app config for this user, if set to at least 1
+	MaxApiKeys  *int
+	MaxPasskeys *int
 
 	CustomClaims []CustomClaim
 	UserGroups   []UserGroup `gorm:"many2many:user_groups_users;"`
 	Credentials  []WebauthnCredential
 }
 
+// DefaultSort lists the newest users first
+func (u User) DefaultSort() (string, bool) { return "created_at", true }
+
 func (u User) WebAuthnID() []byte { return []byte(u.ID) }
 
 func (u User) WebAuthnName() string { return u.Username }
@@ -77,6 +98,31 @@ func (u User) Initials() string {
 	return strings.ToUpper(first + last)
 }
 
+// AccountDeletionToken confirms a user's request to delete their own account
+// Only the hash of the token is stored
+type AccountDeletionToken struct {
+	Base
+	TokenHash string
+	ExpiresAt datatype.DateTime
+
+	UserID string
+}
+
+// UserNotificationPreference records whether a user receives a kind of notification email.
+// Notifications without a preference are sent.
+type UserNotificationPreference struct {
+	UserID       string `gorm:"primaryKey"`
+	Notification string `gorm:"primaryKey"`
+	Enabled      bool
+	UpdatedAt    datatype.DateTime
+}
+
+// OneTimeAccessFailure is a failed attempt to sign in with a one-time access token, from the IP address
+type OneTimeAccessFailure struct {
+	Base
+	IpAddress string
+}
+
 type OneTimeAccessToken struct {
 	Base
 	Token     string
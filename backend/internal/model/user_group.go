@@ -8,3 +8,6 @@ type UserGroup struct {
 	Users        []User `gorm:"many2many:user_groups_users;"`
 	CustomClaims []CustomClaim
 }
+
+// DefaultSort lists the groups alphabetically
+func (g UserGroup) DefaultSort() (string, bool) { return "friendly_name", false }
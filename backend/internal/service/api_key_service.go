@@ -17,12 +17,14 @@ import (
 )
 
 type ApiKeyService struct {
-	db           *gorm.DB
-	emailService *EmailService
+	db               *gorm.DB
+	emailService     *EmailService
+	auditLogService  *AuditLogService
+	appConfigService *AppConfigService
 }
 
-func NewApiKeyService(db *gorm.DB, emailService *EmailService) *ApiKeyService {
-	return &ApiKeyService{db: db, emailService: emailService}
+func NewApiKeyService(db *gorm.DB, emailService *EmailService, auditLogService *AuditLogService, appConfigService *AppConfigService) *ApiKeyService {
+	return &ApiKeyService{db: db, emailService: emailService, auditLogService: auditLogService, appConfigService: appConfigService}
 }
 
 func (s *ApiKeyService) ListApiKeys(ctx context.Context, userID string, sortedPaginationRequest utils.SortedPaginationRequest) ([]model.ApiKey, utils.PaginationResponse, error) {
@@ -46,8 +48,34 @@ func (s *ApiKeyService) CreateApiKey(ctx context.Context, userID string, input d
 		return model.ApiKey{}, "", &common.APIKeyExpirationDateError{}
 	}
 
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var user model.User
+	err := tx.
+		WithContext(ctx).
+		First(&user, "id = ?", userID).
+		Error
+	if err != nil {
+		return model.ApiKey{}, "", err
+	}
+
+	dbConfig := s.appConfigService.GetDbConfig()
+	limit := credentialLimit(dbConfig, user, user.MaxApiKeys, dbConfig.MaxApiKeysPerUser)
+	if limit > 0 {
+		count, err := countActiveApiKeysInternal(ctx, userID, tx)
+		if err != nil {
+			return model.ApiKey{}, "", err
+		}
+		if count >= int64(limit) {
+			return model.ApiKey{}, "", &common.APIKeyLimitReachedError{Limit: limit}
+		}
+	}
+
 	// Generate a secure random API key
-	token, err := utils.GenerateRandomAlphanumericString(32)
+	token, err := utils.GenerateApiKey(common.EnvConfig.ApiKeyEnvironment)
 	if err != nil {
 		return model.ApiKey{}, "", err
 	}
@@ -60,7 +88,7 @@ func (s *ApiKeyService) CreateApiKey(ctx context.Context, userID string, input d
 		UserID:      userID,
 	}
 
-	err = s.db.
+	err = tx.
 		WithContext(ctx).
 		Create(&apiKey).
 		Error
@@ -68,6 +96,82 @@ func (s *ApiKeyService) CreateApiKey(ctx context.Context, userID string, input d
 		return model.ApiKey{}, "", err
 	}
 
+	err = tx.Commit().Error
+	if err != nil {
+		return model.ApiKey{}, "", err
+	}
+
+	// Return the raw token only once - it cannot be retrieved later
+	return apiKey, token, nil
+}
+
+// RotateApiKey generates a new secret for an existing API key, keeping its ID, name, description and expiration.
+// The previous secret remains valid for the grace period, so that integrations can be switched over without downtime.
+func (s *ApiKeyService) RotateApiKey(ctx context.Context, userID, apiKeyID string, input dto.ApiKeyRotateDto, ipAddress, userAgent string) (model.ApiKey, string, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var apiKey model.ApiKey
+	err := tx.
+		WithContext(ctx).
+		Where("id = ? AND user_id = ?", apiKeyID, userID).
+		First(&apiKey).
+		Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.ApiKey{}, "", &common.APIKeyNotFoundError{}
+		}
+		return model.ApiKey{}, "", err
+	}
+
+	now := time.Now()
+	if !apiKey.ExpiresAt.ToTime().After(now) {
+		return model.ApiKey{}, "", &common.APIKeyExpirationDateError{}
+	}
+
+	token, err := utils.GenerateApiKey(common.EnvConfig.ApiKeyEnvironment)
+	if err != nil {
+		return model.ApiKey{}, "", err
+	}
+
+	// A secret that was still in its grace period from an earlier rotation is replaced, so at most two secrets are valid at once
+	apiKey.PreviousKey = nil
+	apiKey.PreviousKeyExpiresAt = nil
+	if input.GracePeriodSeconds > 0 {
+		apiKey.PreviousKey = utils.Ptr(apiKey.Key)
+		apiKey.PreviousKeyExpiresAt = utils.Ptr(datatype.DateTime(now.Add(time.Duration(input.GracePeriodSeconds) * time.Second)))
+	}
+	apiKey.Key = utils.CreateSha256Hash(token)
+
+	// The last use refers to the new secret only, which shows whether the integrations have been switched over
+	apiKey.LastUsedAt = nil
+
+	err = tx.
+		WithContext(ctx).
+		Model(&apiKey).
+		Select("Key", "PreviousKey", "PreviousKeyExpiresAt", "LastUsedAt").
+		Updates(&apiKey).
+		Error
+	if err != nil {
+		return model.ApiKey{}, "", err
+	}
+
+	auditLogData := model.AuditLogData{
+		"apiKeyName": apiKey.Name,
+		"apiKeyId":   apiKey.ID,
+	}
+	if apiKey.PreviousKeyExpiresAt != nil {
+		auditLogData["previousKeyExpiresAt"] = apiKey.PreviousKeyExpiresAt.ToTime().UTC().Format(time.RFC3339)
+	}
+	s.auditLogService.Create(ctx, model.AuditLogEventApiKeyRotated, ipAddress, userAgent, userID, auditLogData, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return model.ApiKey{}, "", err
+	}
+
 	// Return the raw token only once - it cannot be retrieved later
 	return apiKey, token, nil
 }
@@ -94,11 +198,23 @@ func (s *ApiKeyService) ValidateApiKey(ctx context.Context, apiKey string) (mode
 		return model.User{}, &common.NoAPIKeyProvidedError{}
 	}
 
+	// Reject malformed keys and keys of another environment without looking them up
+	environment, legacy, err := utils.ParseApiKey(apiKey)
+	if err != nil {
+		return model.User{}, &common.InvalidAPIKeyError{}
+	}
+	if legacy && !common.EnvConfig.ApiKeyLegacyFormatAllowed {
+		return model.User{}, &common.InvalidAPIKeyError{}
+	}
+	if !legacy && environment != common.EnvConfig.ApiKeyEnvironment {
+		return model.User{}, &common.InvalidAPIKeyError{}
+	}
+
 	now := time.Now()
 	hashedKey := utils.CreateSha256Hash(apiKey)
 
 	var key model.ApiKey
-	err := s.db.
+	err = s.db.
 		WithContext(ctx).
 		Model(&model.ApiKey{}).
 		Clauses(clause.Returning{}).
@@ -109,6 +225,27 @@ func (s *ApiKeyService) ValidateApiKey(ctx context.Context, apiKey string) (mode
 		Preload("User").
 		First(&key).
 		Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return s.validatePreviousApiKey(ctx, hashedKey, now)
+		}
+
+		return model.User{}, err
+	}
+
+	return key.User, nil
+}
+
+// validatePreviousApiKey looks up an API key by the secret it had before it was rotated, as long as the grace period hasn't ended.
+// The last use isn't updated, as it refers to the current secret.
+func (s *ApiKeyService) validatePreviousApiKey(ctx context.Context, hashedKey string, now time.Time) (model.User, error) {
+	var key model.ApiKey
+	err := s.db.
+		WithContext(ctx).
+		Preload("User").
+		Where("previous_key = ? AND previous_key_expires_at > ? AND expires_at > ?", hashedKey, datatype.DateTime(now), datatype.DateTime(now)).
+		First(&key).
+		Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return model.User{}, &common.InvalidAPIKeyError{}
@@ -145,8 +282,10 @@ func (s *ApiKeyService) SendApiKeyExpiringSoonEmail(ctx context.Context, apiKey
 	}
 
 	err := SendEmail(ctx, s.emailService, email.Address{
-		Name:  user.FullName(),
-		Email: user.Email,
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+		UserID: user.ID,
 	}, ApiKeyExpiringSoonTemplate, &ApiKeyExpiringSoonTemplateData{
 		ApiKeyName: apiKey.Name,
 		ExpiresAt:  apiKey.ExpiresAt.ToTime(),
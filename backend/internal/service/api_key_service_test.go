@@ -0,0 +1,147 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestApiKeyService_ValidateApiKey(t *testing.T) {
+	originalEnvConfig := common.EnvConfig
+	t.Cleanup(func() {
+		common.EnvConfig = originalEnvConfig
+	})
+
+	db := testutils.NewDatabaseForTest(t)
+	s := NewApiKeyService(db, nil, &AuditLogService{db: db, geoliteService: &GeoLiteService{}}, NewTestAppConfigService(&model.AppConfig{}))
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	_, token, err := s.CreateApiKey(t.Context(), user.ID, dto.ApiKeyCreateDto{
+		Name:      "Key",
+		ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	const legacyToken = "aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0eF"
+	legacyKey := model.ApiKey{
+		Name:      "Legacy key",
+		Key:       utils.CreateSha256Hash(legacyToken),
+		ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+		UserID:    user.ID,
+	}
+	require.NoError(t, db.Create(&legacyKey).Error)
+
+	t.Run("accepts prefixed keys", func(t *testing.T) {
+		assert.True(t, strings.HasPrefix(token, "pid_live_"))
+
+		validatedUser, err := s.ValidateApiKey(t.Context(), token)
+		require.NoError(t, err)
+		assert.Equal(t, user.ID, validatedUser.ID)
+	})
+
+	t.Run("rejects keys of another environment", func(t *testing.T) {
+		common.EnvConfig.ApiKeyEnvironment = "test"
+		t.Cleanup(func() {
+			common.EnvConfig.ApiKeyEnvironment = "live"
+		})
+
+		_, err := s.ValidateApiKey(t.Context(), token)
+		require.ErrorIs(t, err, &common.InvalidAPIKeyError{})
+	})
+
+	t.Run("accepts legacy keys only during the migration window", func(t *testing.T) {
+		_, err := s.ValidateApiKey(t.Context(), legacyToken)
+		require.NoError(t, err)
+
+		common.EnvConfig.ApiKeyLegacyFormatAllowed = false
+		t.Cleanup(func() {
+			common.EnvConfig.ApiKeyLegacyFormatAllowed = true
+		})
+
+		_, err = s.ValidateApiKey(t.Context(), legacyToken)
+		require.ErrorIs(t, err, &common.InvalidAPIKeyError{})
+	})
+}
+
+func TestApiKeyService_RotateApiKey(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	s := NewApiKeyService(db, nil, &AuditLogService{db: db, geoliteService: &GeoLiteService{}}, NewTestAppConfigService(&model.AppConfig{}))
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createApiKey := func(t *testing.T) (model.ApiKey, string) {
+		t.Helper()
+		apiKey, token, err := s.CreateApiKey(t.Context(), user.ID, dto.ApiKeyCreateDto{
+			Name:      "Key",
+			ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+		})
+		require.NoError(t, err)
+		_, err = s.ValidateApiKey(t.Context(), token)
+		require.NoError(t, err)
+		return apiKey, token
+	}
+
+	t.Run("replaces the secret immediately without a grace period", func(t *testing.T) {
+		apiKey, oldToken := createApiKey(t)
+
+		rotated, newToken, err := s.RotateApiKey(t.Context(), user.ID, apiKey.ID, dto.ApiKeyRotateDto{}, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, apiKey.ID, rotated.ID)
+		assert.Equal(t, apiKey.Name, rotated.Name)
+		assert.NotEqual(t, oldToken, newToken)
+		assert.Nil(t, rotated.LastUsedAt)
+		assert.Nil(t, rotated.PreviousKeyExpiresAt)
+
+		_, err = s.ValidateApiKey(t.Context(), oldToken)
+		require.ErrorIs(t, err, &common.InvalidAPIKeyError{})
+		_, err = s.ValidateApiKey(t.Context(), newToken)
+		require.NoError(t, err)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventApiKeyRotated).Last(&auditLog).Error)
+		assert.Equal(t, apiKey.ID, auditLog.Data["apiKeyId"])
+	})
+
+	t.Run("keeps the previous secret valid during the grace period", func(t *testing.T) {
+		apiKey, oldToken := createApiKey(t)
+
+		_, newToken, err := s.RotateApiKey(t.Context(), user.ID, apiKey.ID, dto.ApiKeyRotateDto{GracePeriodSeconds: 60}, "", "")
+		require.NoError(t, err)
+
+		_, err = s.ValidateApiKey(t.Context(), oldToken)
+		require.NoError(t, err)
+
+		// Using the previous secret doesn't count as a use of the rotated key
+		var stored model.ApiKey
+		require.NoError(t, db.First(&stored, "id = ?", apiKey.ID).Error)
+		assert.Nil(t, stored.LastUsedAt)
+
+		// Once the grace period has ended, the previous secret is rejected
+		require.NoError(t, db.Model(&model.ApiKey{}).Where("id = ?", apiKey.ID).
+			Update("previous_key_expires_at", datatype.DateTime(time.Now().Add(-time.Second))).Error)
+		_, err = s.ValidateApiKey(t.Context(), oldToken)
+		require.ErrorIs(t, err, &common.InvalidAPIKeyError{})
+		_, err = s.ValidateApiKey(t.Context(), newToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("only rotates keys of the user", func(t *testing.T) {
+		apiKey, _ := createApiKey(t)
+
+		_, _, err := s.RotateApiKey(t.Context(), "other-user", apiKey.ID, dto.ApiKeyRotateDto{}, "", "")
+		require.ErrorIs(t, err, &common.APIKeyNotFoundError{})
+	})
+}
@@ -1,16 +1,21 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"maps"
 	"mime/multipart"
 	"os"
 	"reflect"
+	"regexp"
 	"slices"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	"github.com/hashicorp/go-uuid"
 	"gorm.io/gorm"
@@ -20,11 +25,23 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	imageutil "github.com/pocket-id/pocket-id/backend/internal/utils/image"
 )
 
+// Application images, like the logo or the favicon, are stored as they were uploaded
+var applicationImageOptions = imageutil.Options{
+	AllowedTypes: imageutil.SupportedTypes,
+	MaxInputSize: 10 << 20, // 10 MB
+}
+
+// localeRegex matches language tags like "de" or "pt-BR"
+var localeRegex = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
 type AppConfigService struct {
 	dbConfig atomic.Pointer[model.AppConfig]
-	db       *gorm.DB
+	// generation is the generation of the configuration in dbConfig, which is incremented every time the configuration is updated
+	generation atomic.Int64
+	db         *gorm.DB
 }
 
 func NewAppConfigService(ctx context.Context, db *gorm.DB) (*AppConfigService, error) {
@@ -42,6 +59,16 @@ func NewAppConfigService(ctx context.Context, db *gorm.DB) (*AppConfigService, e
 		return nil, fmt.Errorf("failed to initialize instance ID: %w", err)
 	}
 
+	err = service.initPairwiseSubjectSalt(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize pairwise subject salt: %w", err)
+	}
+
+	err = service.initEmailUnsubscribeKey(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize email unsubscribe key: %w", err)
+	}
+
 	return service, nil
 }
 
@@ -61,22 +88,75 @@ func (s *AppConfigService) getDefaultDbConfig() *model.AppConfig {
 	// Values are the default ones
 	return &model.AppConfig{
 		// General
-		AppName:             model.AppConfigVariable{Value: "Pocket ID"},
-		SessionDuration:     model.AppConfigVariable{Value: "60"},
-		EmailsVerified:      model.AppConfigVariable{Value: "false"},
-		DisableAnimations:   model.AppConfigVariable{Value: "false"},
-		AllowOwnAccountEdit: model.AppConfigVariable{Value: "true"},
-		AllowUserSignups:    model.AppConfigVariable{Value: "disabled"},
-		AccentColor:         model.AppConfigVariable{Value: "default"},
+		AppName:                   model.AppConfigVariable{Value: "Pocket ID"},
+		AppNameLocalized:          model.AppConfigVariable{},
+		SessionDuration:           model.AppConfigVariable{Value: "60"},
+		SessionIdleTimeout:        model.AppConfigVariable{Value: "0"},
+		AuthorizationCodeLifetime: model.AppConfigVariable{Value: "60"},
+		EmailsVerified:            model.AppConfigVariable{Value: "false"},
+		DisableAnimations:         model.AppConfigVariable{Value: "false"},
+		AllowOwnAccountEdit:       model.AppConfigVariable{Value: "true"},
+		AllowUserSignups:          model.AppConfigVariable{Value: "disabled"},
+		GenerateUsernameFromEmail: model.AppConfigVariable{Value: "false"},
+		AllowUserSelfDeletion:     model.AppConfigVariable{Value: "false"},
+		LoginEmailEnabled:         model.AppConfigVariable{Value: "false"},
+		UnicodeNormalizationForm:  model.AppConfigVariable{Value: "nfc"},
+		AccentColor:               model.AppConfigVariable{Value: "default"},
+		// Locales
+		DefaultLocale:    model.AppConfigVariable{Value: "en"},
+		SupportedLocales: model.AppConfigVariable{Value: "cs,da,de,en,es,fr,it,nl,pl,pt-BR,ru,uk,vi,zh-CN,zh-TW"},
+		// Claims
+		PreferredUsernameClaim: model.AppConfigVariable{Value: "username"},
+		NameClaimTemplate:      model.AppConfigVariable{},
+		// Email domains
+		AllowedEmailDomains:           model.AppConfigVariable{},
+		AllowedEmailDomainsExemptLdap: model.AppConfigVariable{Value: "true"},
+		// Signups
+		SignupBlockedEmailDomains:         model.AppConfigVariable{},
+		SignupBlockDisposableEmailDomains: model.AppConfigVariable{Value: "false"},
+		// User groups
+		UserGroupFriendlyNameUnique: model.AppConfigVariable{Value: "false"},
+		UserGroupNameSlugEnforced:   model.AppConfigVariable{Value: "false"},
+		// Inactive users
+		InactiveUserDisableDays:  model.AppConfigVariable{Value: "0"},
+		InactiveUserWarningDays:  model.AppConfigVariable{Value: "0"},
+		InactiveUserExemptAdmins: model.AppConfigVariable{Value: "true"},
+		InactiveUserExemptGroups: model.AppConfigVariable{},
+		// Geoblocking
+		GeoblockingMode:         model.AppConfigVariable{Value: "disabled"},
+		GeoblockingCountries:    model.AppConfigVariable{},
+		GeoblockingAllowUnknown: model.AppConfigVariable{Value: "true"},
+		GeoblockingExemptAdmins: model.AppConfigVariable{Value: "false"},
+		// Suspicious sign ins
+		SuspiciousSignInDetectionEnabled: model.AppConfigVariable{Value: "false"},
+		SuspiciousSignInMaxSpeed:         model.AppConfigVariable{Value: "1000"},
+		SuspiciousSignInMinDistance:      model.AppConfigVariable{Value: "500"},
+		SuspiciousSignInWebhookUrl:       model.AppConfigVariable{},
+		// Token issuance audit
+		TokenIssuanceAuditEnabled:    model.AppConfigVariable{Value: "false"},
+		TokenIssuanceAuditSampleRate: model.AppConfigVariable{Value: "100"},
+		// Credential limits
+		MaxApiKeysPerUser:            model.AppConfigVariable{Value: "0"},
+		MaxPasskeysPerUser:           model.AppConfigVariable{Value: "0"},
+		CredentialLimitsExemptAdmins: model.AppConfigVariable{Value: "false"},
+		// Maintenance mode
+		MaintenanceModeEnabled: model.AppConfigVariable{Value: "false"},
+		MaintenanceModeMessage: model.AppConfigVariable{},
 		// Internal
 		BackgroundImageType: model.AppConfigVariable{Value: "jpg"},
 		LogoLightImageType:  model.AppConfigVariable{Value: "svg"},
 		LogoDarkImageType:   model.AppConfigVariable{Value: "svg"},
 		InstanceID:          model.AppConfigVariable{Value: ""},
+		PairwiseSubjectSalt: model.AppConfigVariable{Value: ""},
+		EmailUnsubscribeKey: model.AppConfigVariable{Value: ""},
 		// Email
 		SmtpHost:                      model.AppConfigVariable{},
 		SmtpPort:                      model.AppConfigVariable{},
 		SmtpFrom:                      model.AppConfigVariable{},
+		SmtpFromOnboarding:            model.AppConfigVariable{},
+		SmtpFromSecurity:              model.AppConfigVariable{},
+		SmtpFromNotifications:         model.AppConfigVariable{},
+		SmtpReplyToNotifications:      model.AppConfigVariable{},
 		SmtpUser:                      model.AppConfigVariable{},
 		SmtpPassword:                  model.AppConfigVariable{},
 		SmtpTls:                       model.AppConfigVariable{Value: "none"},
@@ -85,6 +165,10 @@ func (s *AppConfigService) getDefaultDbConfig() *model.AppConfig {
 		EmailOneTimeAccessAsUnauthenticatedEnabled: model.AppConfigVariable{Value: "false"},
 		EmailOneTimeAccessAsAdminEnabled:           model.AppConfigVariable{Value: "false"},
 		EmailApiKeyExpirationEnabled:               model.AppConfigVariable{Value: "false"},
+		EmailWelcomeEnabled:                        model.AppConfigVariable{Value: "false"},
+		EmailUnavailableBehavior:                   model.AppConfigVariable{Value: "queue"},
+		EmailLoginNotificationUserDefault:          model.AppConfigVariable{Value: "enabled"},
+		EmailApiKeyExpirationUserDefault:           model.AppConfigVariable{Value: "enabled"},
 		// LDAP
 		LdapEnabled:                        model.AppConfigVariable{Value: "false"},
 		LdapUrl:                            model.AppConfigVariable{},
@@ -105,6 +189,15 @@ func (s *AppConfigService) getDefaultDbConfig() *model.AppConfig {
 		LdapAttributeGroupName:             model.AppConfigVariable{},
 		LdapAttributeAdminGroup:            model.AppConfigVariable{},
 		LdapSoftDeleteUsers:                model.AppConfigVariable{Value: "true"},
+		LdapMissingUserGraceSyncs:          model.AppConfigVariable{Value: "0"},
+		LdapMissingUserGraceDays:           model.AppConfigVariable{Value: "0"},
+		LdapSyncNotification:               model.AppConfigVariable{Value: "disabled"},
+		LdapSyncNotificationWebhookUrl:     model.AppConfigVariable{},
+		LdapSyncRemovalAlertThreshold:      model.AppConfigVariable{Value: "0"},
+		LdapRateLimit:                      model.AppConfigVariable{Value: "10"},
+		LdapCircuitBreakerThreshold:        model.AppConfigVariable{Value: "5"},
+		LdapCircuitBreakerCooldown:         model.AppConfigVariable{Value: "60"},
+		LdapDuplicateIdentifierBehavior:    model.AppConfigVariable{Value: "skip"},
 	}
 }
 
@@ -140,7 +233,8 @@ func (s *AppConfigService) updateAppConfigStartTransaction(ctx context.Context)
 	return tx, nil
 }
 
-func (s *AppConfigService) updateAppConfigUpdateDatabase(ctx context.Context, tx *gorm.DB, dbUpdate *[]model.AppConfigVariable) error {
+// updateAppConfigUpdateDatabase saves the values and increments the generation of the configuration, which is returned
+func (s *AppConfigService) updateAppConfigUpdateDatabase(ctx context.Context, tx *gorm.DB, dbUpdate *[]model.AppConfigVariable) (int64, error) {
 	err := tx.
 		WithContext(ctx).
 		Clauses(clause.OnConflict{
@@ -151,21 +245,59 @@ func (s *AppConfigService) updateAppConfigUpdateDatabase(ctx context.Context, tx
 		Create(&dbUpdate).
 		Error
 	if err != nil {
-		return fmt.Errorf("failed to update config in database: %w", err)
+		return 0, fmt.Errorf("failed to update config in database: %w", err)
 	}
 
-	return nil
+	return incrementAppConfigGeneration(ctx, tx)
 }
 
-func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppConfigUpdateDto) ([]model.AppConfigVariable, error) {
+// UpdateAppConfig updates the configuration with the values from the input.
+// It returns the updated configuration and the keys that changed with their previous and new values, redacting sensitive values.
+func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppConfigUpdateDto) ([]model.AppConfigVariable, model.AuditLogData, error) {
 	if common.EnvConfig.UiConfigDisabled {
-		return nil, &common.UiConfigDisabledError{}
+		return nil, nil, &common.UiConfigDisabledError{}
+	}
+
+	err := applyThemePreset(&input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = validateAppNameLocalized(input.AppNameLocalized)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = utils.ValidateNameTemplate(input.NameClaimTemplate)
+	if err != nil {
+		return nil, nil, &common.ValidationError{Message: "invalid name claim template: " + err.Error()}
+	}
+
+	// Empty values are reset to the default
+	err = validateSessionTimeouts(cmp.Or(input.SessionDuration, s.getDefaultDbConfig().SessionDuration.Value), input.SessionIdleTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = validateAuthorizationCodeLifetime(input.AuthorizationCodeLifetime)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = validateLocales(cmp.Or(input.DefaultLocale, s.getDefaultDbConfig().DefaultLocale.Value), cmp.Or(input.SupportedLocales, s.getDefaultDbConfig().SupportedLocales.Value))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = validateTokenIssuanceAuditSampleRate(input.TokenIssuanceAuditSampleRate)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// Start the transaction
 	tx, err := s.updateAppConfigStartTransaction(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer func() {
 		tx.Rollback()
@@ -175,8 +307,9 @@ func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppCon
 	// Re-load the config from the database to be sure we have the correct data
 	cfg, err := s.loadDbConfigInternal(ctx, tx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to reload config from database: %w", err)
+		return nil, nil, fmt.Errorf("failed to reload config from database: %w", err)
 	}
+	previousCfg := *cfg
 
 	defaultCfg := s.getDefaultDbConfig()
 
@@ -192,6 +325,11 @@ func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppCon
 		// Get the value of the json tag, taking only what's before the comma
 		key, _, _ := strings.Cut(field.Tag.Get("json"), ",")
 
+		// The theme preset was already applied to the values it overrides and isn't stored itself
+		if key == "themePreset" {
+			continue
+		}
+
 		// Update the in-memory config value
 		// If the new value is an empty string, then we set the in-memory value to the default one
 		// Skip values that are internal only and can't be updated
@@ -207,7 +345,7 @@ func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppCon
 		if errors.Is(err, model.AppConfigInternalForbiddenError{}) {
 			continue
 		} else if err != nil {
-			return nil, fmt.Errorf("failed to update in-memory config for key '%s': %w", key, err)
+			return nil, nil, fmt.Errorf("failed to update in-memory config for key '%s': %w", key, err)
 		}
 
 		// We always save "value" which can be an empty string
@@ -218,22 +356,54 @@ func (s *AppConfigService) UpdateAppConfig(ctx context.Context, input dto.AppCon
 	}
 
 	// Update the values in the database
-	err = s.updateAppConfigUpdateDatabase(ctx, tx, &dbUpdate)
+	generation, err := s.updateAppConfigUpdateDatabase(ctx, tx, &dbUpdate)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Commit the changes to the DB, then finally save the updated config in the object
 	err = tx.Commit().Error
 	if err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	s.dbConfig.Store(cfg)
+	s.storeDbConfig(cfg, generation)
+
+	warnLowAccentColorContrast(cfg.AccentColor.Value)
 
 	// Return the updated config
 	res := cfg.ToAppConfigVariableSlice(true, false)
-	return res, nil
+	return res, diffAppConfig(&previousCfg, cfg), nil
+}
+
+// diffAppConfig returns the keys whose value differs between the two configurations, formatted as "previous → new".
+// The values of sensitive keys are never included.
+func diffAppConfig(previous, updated *model.AppConfig) model.AuditLogData {
+	previousValue := reflect.ValueOf(previous).Elem()
+	updatedValue := reflect.ValueOf(updated).Elem()
+	cfgType := updatedValue.Type()
+
+	changes := make(model.AuditLogData)
+	for i := range cfgType.NumField() {
+		key, attrs, _ := strings.Cut(cfgType.Field(i).Tag.Get("key"), ",")
+		if key == "" {
+			continue
+		}
+
+		before := previousValue.Field(i).FieldByName("Value").String()
+		after := updatedValue.Field(i).FieldByName("Value").String()
+		if before == after {
+			continue
+		}
+
+		if attrs == "sensitive" {
+			changes[key] = utils.RedactedValue
+			continue
+		}
+		changes[key] = fmt.Sprintf("%q → %q", before, after)
+	}
+
+	return changes
 }
 
 // UpdateAppConfigValues updates the application configuration values in the database.
@@ -300,7 +470,7 @@ func (s *AppConfigService) UpdateAppConfigValues(ctx context.Context, keysAndVal
 	}
 
 	// Update the values in the database
-	err = s.updateAppConfigUpdateDatabase(ctx, tx, &dbUpdate)
+	generation, err := s.updateAppConfigUpdateDatabase(ctx, tx, &dbUpdate)
 	if err != nil {
 		return err
 	}
@@ -311,7 +481,7 @@ func (s *AppConfigService) UpdateAppConfigValues(ctx context.Context, keysAndVal
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	s.dbConfig.Store(cfg)
+	s.storeDbConfig(cfg, generation)
 
 	return nil
 }
@@ -321,11 +491,11 @@ func (s *AppConfigService) ListAppConfig(showAll bool) []model.AppConfigVariable
 }
 
 func (s *AppConfigService) UpdateImage(ctx context.Context, uploadedFile *multipart.FileHeader, imageName string, oldImageType string) (err error) {
-	fileType := strings.ToLower(utils.GetFileExtension(uploadedFile.Filename))
-	mimeType := utils.GetImageMimeType(fileType)
-	if mimeType == "" {
-		return &common.FileTypeNotSupportedError{}
+	img, err := utils.ValidateImageUpload(uploadedFile, applicationImageOptions)
+	if err != nil {
+		return err
 	}
+	fileType := img.Type
 
 	// Save the updated image
 	imagePath := common.EnvConfig.UploadPath + "/application-images/" + imageName + "." + fileType
@@ -355,12 +525,18 @@ func (s *AppConfigService) UpdateImage(ctx context.Context, uploadedFile *multip
 
 // LoadDbConfig loads the configuration values from the database into the DbConfig struct.
 func (s *AppConfigService) LoadDbConfig(ctx context.Context) (err error) {
+	// Read the generation first: if the configuration changes in the meantime, it's loaded again by the next sync
+	generation, err := loadAppConfigGeneration(ctx, s.db)
+	if err != nil {
+		return err
+	}
+
 	dest, err := s.loadDbConfigInternal(ctx, s.db)
 	if err != nil {
 		return err
 	}
 
-	s.dbConfig.Store(dest)
+	s.storeDbConfig(dest, generation)
 
 	return nil
 }
@@ -461,3 +637,127 @@ func (s *AppConfigService) initInstanceID(ctx context.Context) error {
 
 	return nil
 }
+
+// initPairwiseSubjectSalt generates the secret salt used to derive pairwise subjects, which must never change once clients use them
+func (s *AppConfigService) initPairwiseSubjectSalt(ctx context.Context) error {
+	if s.GetDbConfig().PairwiseSubjectSalt.Value != "" {
+		return nil
+	}
+
+	salt, err := utils.GenerateRandomAlphanumericString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate pairwise subject salt: %w", err)
+	}
+
+	err = s.UpdateAppConfigValues(ctx, "pairwiseSubjectSalt", salt)
+	if err != nil {
+		return fmt.Errorf("failed to update pairwise subject salt in the database: %w", err)
+	}
+
+	return nil
+}
+
+// initEmailUnsubscribeKey generates the secret key used to sign the unsubscribe links of notification emails.
+// Links in emails that were already sent stop working if it changes.
+func (s *AppConfigService) initEmailUnsubscribeKey(ctx context.Context) error {
+	if s.GetDbConfig().EmailUnsubscribeKey.Value != "" {
+		return nil
+	}
+
+	key, err := utils.GenerateRandomAlphanumericString(32)
+	if err != nil {
+		return fmt.Errorf("failed to generate email unsubscribe key: %w", err)
+	}
+
+	err = s.UpdateAppConfigValues(ctx, "emailUnsubscribeKey", key)
+	if err != nil {
+		return fmt.Errorf("failed to update email unsubscribe key in the database: %w", err)
+	}
+
+	return nil
+}
+
+// validateSessionTimeouts ensures the idle timeout of sessions, if set, isn't longer than their absolute lifetime
+func validateSessionTimeouts(sessionDuration string, sessionIdleTimeout string) error {
+	duration := model.AppConfigVariable{Value: sessionDuration}
+	idleTimeout := model.AppConfigVariable{Value: sessionIdleTimeout}
+	if idleTimeout.AsDurationMinutes() < 0 {
+		return &common.ValidationError{Message: "sessionIdleTimeout must not be negative"}
+	}
+	if idleTimeout.AsDurationMinutes() > duration.AsDurationMinutes() {
+		return &common.ValidationError{Message: "sessionIdleTimeout must not be longer than sessionDuration"}
+	}
+	return nil
+}
+
+// validateAuthorizationCodeLifetime ensures the lifetime of authorization codes, if set, is between 10 seconds and 10 minutes (RFC 6749, section 4.1.2)
+func validateAuthorizationCodeLifetime(value string) error {
+	if value == "" {
+		return nil
+	}
+	lifetime, err := strconv.Atoi(value)
+	if err != nil || lifetime < 10 || lifetime > 600 {
+		return &common.ValidationError{Message: "authorizationCodeLifetime must be between 10 and 600 seconds"}
+	}
+	return nil
+}
+
+// validateTokenIssuanceAuditSampleRate ensures the sample rate, if set, is a percentage
+func validateTokenIssuanceAuditSampleRate(value string) error {
+	if value == "" {
+		return nil
+	}
+	sampleRate, err := strconv.Atoi(value)
+	if err != nil || sampleRate < 1 || sampleRate > 100 {
+		return &common.ValidationError{Message: "tokenIssuanceAuditSampleRate must be a percentage between 1 and 100"}
+	}
+	return nil
+}
+
+// validateLocales ensures the supported locales are valid language tags and include the default locale
+func validateLocales(defaultLocale string, supportedLocales string) error {
+	cfg := model.AppConfig{
+		DefaultLocale:    model.AppConfigVariable{Value: defaultLocale},
+		SupportedLocales: model.AppConfigVariable{Value: supportedLocales},
+	}
+
+	for _, locale := range cfg.SupportedLocaleList() {
+		if !localeRegex.MatchString(locale) {
+			return &common.ValidationError{Message: fmt.Sprintf("invalid locale '%s' in supportedLocales", locale)}
+		}
+	}
+
+	supported, ok := cfg.MatchSupportedLocale(defaultLocale)
+	if !ok || supported != defaultLocale {
+		return &common.ValidationError{Message: "defaultLocale must be one of the supported locales"}
+	}
+
+	return nil
+}
+
+// validateAppNameLocalized ensures the per-locale app names are a JSON object of non-empty names,
+// with no two locales that are the same once normalized, like "de-CH" and "de_ch"
+func validateAppNameLocalized(value string) error {
+	v := model.AppConfigVariable{Value: value}
+	overrides, err := v.AsLocalizedStrings()
+	if err != nil {
+		return &common.ValidationError{Message: "appNameLocalized must be a JSON object mapping locales to app names"}
+	}
+
+	// Sort the locales, so the error is always about the same one
+	seen := make(map[string]string, len(overrides))
+	for _, locale := range slices.Sorted(maps.Keys(overrides)) {
+		name := overrides[locale]
+		if strings.TrimSpace(locale) == "" || name == "" || utf8.RuneCountInString(name) > 30 {
+			return &common.ValidationError{Message: fmt.Sprintf("invalid app name for locale '%s'", locale)}
+		}
+
+		normalized := model.NormalizeLocale(locale)
+		if other, ok := seen[normalized]; ok {
+			return &common.ValidationError{Message: fmt.Sprintf("the locales '%s' and '%s' of the app names are the same", other, locale)}
+		}
+		seen[normalized] = locale
+	}
+
+	return nil
+}
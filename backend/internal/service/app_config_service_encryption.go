@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// storedAppConfigVariable is a row of the app_config_variables table as stored, without decrypting the value
+type storedAppConfigVariable struct {
+	Key   string
+	Value string
+}
+
+func loadStoredSensitiveAppConfigValues(ctx context.Context, tx *gorm.DB) ([]storedAppConfigVariable, error) {
+	var rows []storedAppConfigVariable
+	err := tx.
+		WithContext(ctx).
+		Table("app_config_variables").
+		Where("value <> ''").
+		Find(&rows).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration from the database: %w", err)
+	}
+
+	sensitive := rows[:0]
+	for _, row := range rows {
+		if model.IsSensitiveAppConfigKey(row.Key) {
+			sensitive = append(sensitive, row)
+		}
+	}
+	return sensitive, nil
+}
+
+// WarnIfSecretsUnencrypted logs a warning if sensitive values, like the SMTP password, are stored unencrypted in the database
+func (s *AppConfigService) WarnIfSecretsUnencrypted(ctx context.Context) error {
+	if common.EnvConfig.UiConfigDisabled {
+		// Values are read from the environment
+		return nil
+	}
+
+	rows, err := loadStoredSensitiveAppConfigValues(ctx, s.db)
+	if err != nil {
+		return err
+	}
+
+	unencrypted := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if !datatype.IsEncryptedValue(row.Value) {
+			unencrypted = append(unencrypted, row.Key)
+		}
+	}
+	if len(unencrypted) == 0 {
+		return nil
+	}
+
+	if datatype.EncryptionEnabled() {
+		slog.WarnContext(ctx, "Some sensitive values were stored before encryption was enabled and are still unencrypted. Run 'pocket-id db-encryption-rotate' to encrypt them.", slog.Any("keys", unencrypted))
+	} else {
+		slog.WarnContext(ctx, "Sensitive values are stored unencrypted in the database. Set DB_ENCRYPTION_KEY or DB_ENCRYPTION_KEY_FILE, then run 'pocket-id db-encryption-rotate' to encrypt them.", slog.Any("keys", unencrypted))
+	}
+
+	return nil
+}
+
+// ReencryptSensitiveAppConfigValues re-encrypts the sensitive values stored in the database with newKey.
+// Values encrypted with oldKey are decrypted first, and unencrypted values are encrypted; if newKey is nil, all values are stored unencrypted.
+// Values already encrypted with newKey are left unchanged, so the operation can be repeated safely.
+// It returns the number of values that were updated.
+// This doesn't use the AppConfigService, which can't load the configuration until the values are encrypted with the current key.
+func ReencryptSensitiveAppConfigValues(ctx context.Context, db *gorm.DB, oldKey []byte, newKey []byte) (updated int, err error) {
+	ctx = utils.WithQueryTimeout(ctx, common.EnvConfig.DbQueryTimeoutMaintenance)
+
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := utils.SetLocalStatementTimeout(ctx, tx, common.EnvConfig.DbQueryTimeoutMaintenance)
+		if err != nil {
+			return fmt.Errorf("failed to set the statement timeout: %w", err)
+		}
+
+		rows, err := loadStoredSensitiveAppConfigValues(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			encrypted := datatype.IsEncryptedValue(row.Value)
+
+			// Skip the values that are already stored as requested
+			if !encrypted && newKey == nil {
+				continue
+			}
+			if encrypted && newKey != nil {
+				if _, err := datatype.DecryptValue(newKey, row.Value); err == nil {
+					continue
+				}
+			}
+
+			value, err := datatype.DecryptValue(oldKey, row.Value)
+			if err != nil {
+				return fmt.Errorf("failed to decrypt the value of '%s': %w", row.Key, err)
+			}
+
+			if newKey != nil {
+				value, err = datatype.EncryptValue(newKey, value)
+				if err != nil {
+					return fmt.Errorf("failed to encrypt the value of '%s': %w", row.Key, err)
+				}
+			}
+
+			// Update the table directly, so the value isn't processed by the serializer of the model again
+			err = tx.
+				WithContext(ctx).
+				Table("app_config_variables").
+				Where("key = ?", row.Key).
+				Update("value", value).
+				Error
+			if err != nil {
+				return fmt.Errorf("failed to update the value of '%s': %w", row.Key, err)
+			}
+			updated++
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return updated, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAppConfigService_SensitiveValueEncryption(t *testing.T) {
+	oldKey := datatype.DeriveEncryptionKey([]byte("old-key"))
+	newKey := datatype.DeriveEncryptionKey([]byte("new-key"))
+	t.Cleanup(func() { datatype.SetEncryptionKey(nil) })
+
+	storedValue := func(t *testing.T, db *gorm.DB, key string) string {
+		t.Helper()
+		var value string
+		err := db.Table("app_config_variables").Where("key = ?", key).Select("value").Scan(&value).Error
+		require.NoError(t, err)
+		return value
+	}
+
+	db := testutils.NewDatabaseForTest(t)
+	service := &AppConfigService{db: db}
+	require.NoError(t, service.LoadDbConfig(t.Context()))
+
+	// Values stored before encryption was enabled
+	datatype.SetEncryptionKey(nil)
+	require.NoError(t, service.UpdateAppConfigValues(t.Context(),
+		"smtpHost", "smtp.example.com",
+		"smtpPassword", "smtp-secret",
+	))
+	assert.Equal(t, "smtp-secret", storedValue(t, db, "smtpPassword"))
+
+	t.Run("encrypts new sensitive values only", func(t *testing.T) {
+		datatype.SetEncryptionKey(oldKey)
+		require.NoError(t, service.UpdateAppConfigValues(t.Context(), "ldapBindPassword", "ldap-secret"))
+
+		assert.True(t, datatype.IsEncryptedValue(storedValue(t, db, "ldapBindPassword")))
+		assert.Equal(t, "smtp.example.com", storedValue(t, db, "smtpHost"))
+
+		// Values stored unencrypted can still be read
+		require.NoError(t, service.LoadDbConfig(t.Context()))
+		assert.Equal(t, "ldap-secret", service.GetDbConfig().LdapBindPassword.Value)
+		assert.Equal(t, "smtp-secret", service.GetDbConfig().SmtpPassword.Value)
+	})
+
+	t.Run("fails to load encrypted values without the key", func(t *testing.T) {
+		datatype.SetEncryptionKey(nil)
+		require.ErrorIs(t, service.LoadDbConfig(t.Context()), datatype.ErrEncryptionKeyMissing)
+	})
+
+	t.Run("encrypts the existing values with the current key", func(t *testing.T) {
+		updated, err := ReencryptSensitiveAppConfigValues(t.Context(), db, nil, oldKey)
+		require.NoError(t, err)
+		assert.Equal(t, 1, updated)
+		assert.True(t, datatype.IsEncryptedValue(storedValue(t, db, "smtpPassword")))
+	})
+
+	t.Run("re-encrypts the values with a new key", func(t *testing.T) {
+		updated, err := ReencryptSensitiveAppConfigValues(t.Context(), db, oldKey, newKey)
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated)
+
+		// Running it again doesn't change anything
+		updated, err = ReencryptSensitiveAppConfigValues(t.Context(), db, oldKey, newKey)
+		require.NoError(t, err)
+		assert.Equal(t, 0, updated)
+
+		datatype.SetEncryptionKey(oldKey)
+		require.Error(t, service.LoadDbConfig(t.Context()))
+
+		datatype.SetEncryptionKey(newKey)
+		require.NoError(t, service.LoadDbConfig(t.Context()))
+		assert.Equal(t, "ldap-secret", service.GetDbConfig().LdapBindPassword.Value)
+		assert.Equal(t, "smtp-secret", service.GetDbConfig().SmtpPassword.Value)
+	})
+
+	t.Run("fails with the wrong old key", func(t *testing.T) {
+		_, err := ReencryptSensitiveAppConfigValues(t.Context(), db, oldKey, datatype.DeriveEncryptionKey([]byte("other-key")))
+		require.Error(t, err)
+		assert.True(t, datatype.IsEncryptedValue(storedValue(t, db, "smtpPassword")))
+	})
+
+	t.Run("decrypts the values when encryption is disabled", func(t *testing.T) {
+		updated, err := ReencryptSensitiveAppConfigValues(t.Context(), db, newKey, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, updated)
+		assert.Equal(t, "smtp-secret", storedValue(t, db, "smtpPassword"))
+		assert.Equal(t, "ldap-secret", storedValue(t, db, "ldapBindPassword"))
+	})
+}
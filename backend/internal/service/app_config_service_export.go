@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// AppConfigExportVersion is the version of the format of the exported configuration
+const AppConfigExportVersion = 1
+
+type AppConfigImportResult struct {
+	Config  []model.AppConfigVariable
+	Changes model.AuditLogData
+	// Keys of the sensitive values that weren't imported, and must be entered again
+	RedactedKeys []string
+}
+
+// appConfigUpdateKeys returns the keys that can be updated, which are the ones in AppConfigUpdateDto
+func appConfigUpdateKeys() []string {
+	rt := reflect.TypeFor[dto.AppConfigUpdateDto]()
+	keys := make([]string, 0, rt.NumField())
+	for i := range rt.NumField() {
+		key, _, _ := strings.Cut(rt.Field(i).Tag.Get("json"), ",")
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Export returns all the configuration values that can be updated.
+// Sensitive values, like passwords, are left out unless includeSecrets is true, and their keys are listed so they can be entered again after the import.
+// If the UI configuration is disabled, the sensitive values are redacted everywhere, so they can't be exported either.
+func (s *AppConfigService) Export(includeSecrets bool) (dto.AppConfigExportDto, error) {
+	if includeSecrets && common.EnvConfig.UiConfigDisabled {
+		return dto.AppConfigExportDto{}, &common.UiConfigDisabledSecretsExportError{}
+	}
+
+	cfg := s.GetDbConfig()
+
+	export := dto.AppConfigExportDto{
+		Version:      AppConfigExportVersion,
+		ExportedAt:   time.Now().UTC(),
+		Values:       make(map[string]string),
+		RedactedKeys: []string{},
+	}
+	for _, key := range appConfigUpdateKeys() {
+		value, _, err := cfg.FieldByKey(key)
+		if err != nil {
+			// All keys of the update DTO exist in the config, which is checked by the tests
+			continue
+		}
+
+		if !includeSecrets && model.IsSensitiveAppConfigKey(key) {
+			if value != "" {
+				export.RedactedKeys = append(export.RedactedKeys, key)
+			}
+			continue
+		}
+		export.Values[key] = value
+	}
+
+	return export, nil
+}
+
+// Import replaces the configuration with the values of an exported document.
+// Values missing from the document keep their current value, and all values are validated like an update of the configuration.
+func (s *AppConfigService) Import(ctx context.Context, export dto.AppConfigExportDto) (AppConfigImportResult, error) {
+	if export.Version != AppConfigExportVersion {
+		return AppConfigImportResult{}, &common.ValidationError{Message: fmt.Sprintf("unsupported configuration version %d, expected %d", export.Version, AppConfigExportVersion)}
+	}
+
+	keys := appConfigUpdateKeys()
+	unknownKeys := make([]string, 0)
+	for key := range export.Values {
+		if !slices.Contains(keys, key) {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return AppConfigImportResult{}, &common.ValidationError{Message: "unknown configuration keys: " + strings.Join(unknownKeys, ", ")}
+	}
+
+	// Start from the current configuration, so that the values missing from the document are kept
+	cfg := s.GetDbConfig()
+	var input dto.AppConfigUpdateDto
+	inputValue := reflect.ValueOf(&input).Elem()
+	for i, key := range keys {
+		value, ok := export.Values[key]
+		if !ok {
+			value, _, _ = cfg.FieldByKey(key)
+		}
+		inputValue.Field(i).SetString(value)
+	}
+
+	dto.Normalize(&input)
+	err := dto.ValidateStruct(&input)
+	if err != nil {
+		return AppConfigImportResult{}, err
+	}
+
+	config, changes, err := s.UpdateAppConfig(ctx, input)
+	if err != nil {
+		return AppConfigImportResult{}, err
+	}
+
+	redactedKeys := slices.Clone(export.RedactedKeys)
+	if redactedKeys == nil {
+		redactedKeys = []string{}
+	}
+
+	return AppConfigImportResult{
+		Config:       config,
+		Changes:      changes,
+		RedactedKeys: redactedKeys,
+	}, nil
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAppConfigService_ExportImport(t *testing.T) {
+	newService := func(t *testing.T) *AppConfigService {
+		t.Helper()
+		service := &AppConfigService{db: testutils.NewDatabaseForTest(t)}
+		require.NoError(t, service.LoadDbConfig(t.Context()))
+		return service
+	}
+
+	source := newService(t)
+	require.NoError(t, source.UpdateAppConfigValues(t.Context(),
+		"appName", "Source",
+		"smtpHost", "smtp.example.com",
+		"smtpPassword", "secret",
+	))
+
+	t.Run("exports the values without the secrets", func(t *testing.T) {
+		export, err := source.Export(false)
+		require.NoError(t, err)
+		assert.Equal(t, AppConfigExportVersion, export.Version)
+		assert.Equal(t, "Source", export.Values["appName"])
+		assert.NotContains(t, export.Values, "smtpPassword")
+		assert.NotContains(t, export.Values, "instanceId")
+		assert.Equal(t, []string{"smtpPassword"}, export.RedactedKeys)
+
+		export, err = source.Export(true)
+		require.NoError(t, err)
+		assert.Equal(t, "secret", export.Values["smtpPassword"])
+	})
+
+	t.Run("doesn't export the secrets if the UI configuration is disabled", func(t *testing.T) {
+		common.EnvConfig.UiConfigDisabled = true
+		t.Cleanup(func() {
+			common.EnvConfig.UiConfigDisabled = false
+		})
+
+		var exportErr *common.UiConfigDisabledSecretsExportError
+		_, err := source.Export(true)
+		require.ErrorAs(t, err, &exportErr)
+
+		export, err := source.Export(false)
+		require.NoError(t, err)
+		assert.NotContains(t, export.Values, "smtpPassword")
+	})
+
+	t.Run("imports the values on another instance", func(t *testing.T) {
+		target := newService(t)
+		export, err := source.Export(false)
+		require.NoError(t, err)
+		result, err := target.Import(t.Context(), export)
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"smtpPassword"}, result.RedactedKeys)
+		assert.Contains(t, result.Changes, "appName")
+		assert.Equal(t, "Source", target.GetDbConfig().AppName.Value)
+		assert.Equal(t, "smtp.example.com", target.GetDbConfig().SmtpHost.Value)
+		assert.Empty(t, target.GetDbConfig().SmtpPassword.Value)
+	})
+
+	t.Run("keeps the values missing from the document", func(t *testing.T) {
+		target := newService(t)
+		require.NoError(t, target.UpdateAppConfigValues(t.Context(), "smtpHost", "smtp.target.com"))
+
+		_, err := target.Import(t.Context(), dto.AppConfigExportDto{
+			Version: AppConfigExportVersion,
+			Values:  map[string]string{"appName": "Partial"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "Partial", target.GetDbConfig().AppName.Value)
+		assert.Equal(t, "smtp.target.com", target.GetDbConfig().SmtpHost.Value)
+	})
+
+	t.Run("rejects invalid documents", func(t *testing.T) {
+		target := newService(t)
+		var validationErr *common.ValidationError
+
+		_, err := target.Import(t.Context(), dto.AppConfigExportDto{Version: 2, Values: map[string]string{}})
+		require.ErrorAs(t, err, &validationErr)
+
+		_, err = target.Import(t.Context(), dto.AppConfigExportDto{
+			Version: AppConfigExportVersion,
+			Values:  map[string]string{"instanceId": "other", "unknownKey": "value"},
+		})
+		require.ErrorAs(t, err, &validationErr)
+		assert.Contains(t, validationErr.Message, "instanceId, unknownKey")
+
+		_, err = target.Import(t.Context(), dto.AppConfigExportDto{
+			Version: AppConfigExportVersion,
+			Values:  map[string]string{"allowUserSignups": "sometimes"},
+		})
+		var fieldErrs validator.ValidationErrors
+		require.ErrorAs(t, err, &fieldErrs)
+		assert.Equal(t, "Pocket ID", target.GetDbConfig().AppName.Value)
+	})
+}
@@ -0,0 +1,24 @@
+package service
+
+import (
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// MaintenanceModeEnabled returns true if the instance is in maintenance mode
+func (s *AppConfigService) MaintenanceModeEnabled() bool {
+	return s.GetDbConfig().MaintenanceModeEnabled.IsTrue()
+}
+
+// CheckMaintenanceMode returns an error if the instance is in maintenance mode and the user isn't an admin.
+// A nil user, like for tokens that represent a client, is never allowed during maintenance.
+func (s *AppConfigService) CheckMaintenanceMode(user *model.User) error {
+	cfg := s.GetDbConfig()
+	if !cfg.MaintenanceModeEnabled.IsTrue() {
+		return nil
+	}
+	if user != nil && user.IsAdmin {
+		return nil
+	}
+	return &common.MaintenanceModeError{Message: cfg.MaintenanceModeMessage.Value}
+}
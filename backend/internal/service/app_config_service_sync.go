@@ -0,0 +1,114 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// Key in the kv table of the generation of the configuration, which every replica compares with the one it loaded
+const appConfigGenerationKVKey = "app_config_generation"
+
+// ConfigGeneration returns the generation of the configuration loaded by this replica
+func (s *AppConfigService) ConfigGeneration() int64 {
+	return s.generation.Load()
+}
+
+func (s *AppConfigService) storeDbConfig(cfg *model.AppConfig, generation int64) {
+	s.dbConfig.Store(cfg)
+	s.generation.Store(generation)
+}
+
+// SyncConfig loads the configuration again if it was updated by another replica
+func (s *AppConfigService) SyncConfig(ctx context.Context) error {
+	generation, err := loadAppConfigGeneration(ctx, s.db)
+	if err != nil {
+		return err
+	}
+	if generation == s.generation.Load() {
+		return nil
+	}
+
+	slog.DebugContext(ctx, "Reloading the application configuration updated by another replica", slog.Int64("generation", generation))
+	return s.LoadDbConfig(ctx)
+}
+
+// RunConfigSync keeps the configuration in sync with the other replicas until the context is canceled
+func (s *AppConfigService) RunConfigSync(ctx context.Context) error {
+	if common.EnvConfig.AppConfigSyncInterval <= 0 {
+		return nil
+	}
+
+	ticker := time.NewTicker(common.EnvConfig.AppConfigSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := s.SyncConfig(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.ErrorContext(ctx, "Failed to sync the application configuration", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func loadAppConfigGeneration(ctx context.Context, tx *gorm.DB) (int64, error) {
+	var row model.KV
+	err := tx.
+		WithContext(ctx).
+		Where("key = ?", appConfigGenerationKVKey).
+		Take(&row).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The configuration was never updated
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("failed to load the configuration generation: %w", err)
+	}
+
+	if row.Value == nil {
+		return 0, nil
+	}
+	generation, err := strconv.ParseInt(*row.Value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid configuration generation '%s': %w", *row.Value, err)
+	}
+	return generation, nil
+}
+
+// incrementAppConfigGeneration increments the generation of the configuration.
+// It must be called in the transaction that holds the lock on the configuration.
+func incrementAppConfigGeneration(ctx context.Context, tx *gorm.DB) (int64, error) {
+	generation, err := loadAppConfigGeneration(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	generation++
+
+	value := strconv.FormatInt(generation, 10)
+	err = tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&model.KV{Key: appConfigGenerationKVKey, Value: &value}).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to update the configuration generation: %w", err)
+	}
+
+	return generation, nil
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAppConfigService_SyncConfig(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	// Two replicas sharing the same database
+	replica1 := &AppConfigService{db: db}
+	require.NoError(t, replica1.LoadDbConfig(t.Context()))
+	replica2 := &AppConfigService{db: db}
+	require.NoError(t, replica2.LoadDbConfig(t.Context()))
+
+	initialGeneration := replica1.ConfigGeneration()
+	assert.Equal(t, initialGeneration, replica2.ConfigGeneration())
+
+	require.NoError(t, replica1.UpdateAppConfigValues(t.Context(), "appName", "Updated"))
+	assert.Equal(t, initialGeneration+1, replica1.ConfigGeneration())
+	assert.Equal(t, "Updated", replica1.GetDbConfig().AppName.Value)
+
+	// The other replica serves its configuration until it syncs
+	assert.NotEqual(t, "Updated", replica2.GetDbConfig().AppName.Value)
+
+	require.NoError(t, replica2.SyncConfig(t.Context()))
+	assert.Equal(t, "Updated", replica2.GetDbConfig().AppName.Value)
+	assert.Equal(t, replica1.ConfigGeneration(), replica2.ConfigGeneration())
+
+	t.Run("does nothing if the configuration didn't change", func(t *testing.T) {
+		cfg := replica2.GetDbConfig()
+		require.NoError(t, replica2.SyncConfig(t.Context()))
+		assert.Same(t, cfg, replica2.GetDbConfig())
+	})
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -333,7 +334,7 @@ func TestUpdateAppConfig(t *testing.T) {
 		}
 
 		// Update config
-		updatedVars, err := service.UpdateAppConfig(t.Context(), input)
+		updatedVars, _, err := service.UpdateAppConfig(t.Context(), input)
 		require.NoError(t, err)
 
 		// Verify returned updated variables
@@ -411,7 +412,7 @@ func TestUpdateAppConfig(t *testing.T) {
 		}
 
 		// Update config
-		updatedVars, err := service.UpdateAppConfig(t.Context(), input)
+		updatedVars, _, err := service.UpdateAppConfig(t.Context(), input)
 		require.NoError(t, err)
 
 		// Verify returned updated variables (they should be empty strings in DB)
@@ -443,6 +444,31 @@ func TestUpdateAppConfig(t *testing.T) {
 		}
 	})
 
+	t.Run("returns the changed keys with redacted sensitive values", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		service := &AppConfigService{
+			db: db,
+		}
+		err := service.LoadDbConfig(t.Context())
+		require.NoError(t, err)
+
+		err = service.UpdateAppConfigValues(t.Context(), "smtpHost", "smtp.example.com")
+		require.NoError(t, err)
+
+		_, changes, err := service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			AppName:         "Pocket ID",
+			SessionDuration: "120",
+			SmtpHost:        "smtp.example.com",
+			SmtpPassword:    "secret",
+		})
+		require.NoError(t, err)
+
+		require.Equal(t, model.AuditLogData{
+			"sessionDuration": `"60" → "120"`,
+			"smtpPassword":    "[redacted]",
+		}, changes)
+	})
+
 	t.Run("cannot update when UiConfigDisabled is true", func(t *testing.T) {
 		// Save the original state and restore it after the test
 		originalUiConfigDisabled := common.EnvConfig.UiConfigDisabled
@@ -461,7 +487,7 @@ func TestUpdateAppConfig(t *testing.T) {
 		require.NoError(t, err)
 
 		// Try to update config
-		_, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+		_, _, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
 			AppName: "Should Not Update",
 		})
 
@@ -471,3 +497,144 @@ func TestUpdateAppConfig(t *testing.T) {
 		require.ErrorAs(t, err, &uiConfigDisabledErr)
 	})
 }
+
+func TestUpdateAppConfigThemePreset(t *testing.T) {
+	t.Run("preset overrides accent color and animations", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		service := &AppConfigService{
+			db: db,
+		}
+		err := service.LoadDbConfig(t.Context())
+		require.NoError(t, err)
+
+		_, _, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			AccentColor: "#ff0000",
+			ThemePreset: "high-contrast",
+		})
+		require.NoError(t, err)
+
+		config := service.GetDbConfig()
+		require.Equal(t, "#000000", config.AccentColor.Value)
+		require.Equal(t, "true", config.DisableAnimations.Value)
+
+		// The preset isn't stored
+		var count int64
+		require.NoError(t, db.Model(&model.AppConfigVariable{}).Where("key = ?", "themePreset").Count(&count).Error)
+		require.Zero(t, count)
+	})
+
+	t.Run("values of the preset can be changed afterwards", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		service := &AppConfigService{
+			db: db,
+		}
+		err := service.LoadDbConfig(t.Context())
+		require.NoError(t, err)
+
+		_, _, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			ThemePreset: "high-contrast",
+		})
+		require.NoError(t, err)
+
+		_, _, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			AccentColor:       "#ff0000",
+			DisableAnimations: "false",
+		})
+		require.NoError(t, err)
+
+		config := service.GetDbConfig()
+		require.Equal(t, "#ff0000", config.AccentColor.Value)
+		require.Equal(t, "false", config.DisableAnimations.Value)
+	})
+
+	t.Run("unknown preset is rejected", func(t *testing.T) {
+		db := testutils.NewDatabaseForTest(t)
+		service := &AppConfigService{
+			db: db,
+		}
+		err := service.LoadDbConfig(t.Context())
+		require.NoError(t, err)
+
+		_, _, err = service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			ThemePreset: "does-not-exist",
+		})
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestGetAccentColorContrast(t *testing.T) {
+	service := NewTestAppConfigService(&model.AppConfig{
+		AccentColor: model.AppConfigVariable{Value: "default"},
+	})
+
+	t.Run("uses the configured accent color by default", func(t *testing.T) {
+		contrast, err := service.GetAccentColorContrast("")
+		require.NoError(t, err)
+		require.Equal(t, "default", contrast.AccentColor)
+		require.Equal(t, accentForegroundLight, contrast.Foreground)
+		require.True(t, contrast.MeetsWcagAA)
+	})
+
+	t.Run("low contrast color does not meet WCAG AA", func(t *testing.T) {
+		// A mid-gray sits right below the luminance threshold, so the light foreground is used
+		contrast, err := service.GetAccentColorContrast("#bababa")
+		require.NoError(t, err)
+		require.Equal(t, accentForegroundLight, contrast.Foreground)
+		require.False(t, contrast.MeetsWcagAA)
+	})
+
+	t.Run("invalid color", func(t *testing.T) {
+		_, err := service.GetAccentColorContrast("not-a-color")
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestUpdateAppConfigSessionIdleTimeout(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := &AppConfigService{
+		db: db,
+	}
+	err := service.LoadDbConfig(t.Context())
+	require.NoError(t, err)
+
+	t.Run("idle timeout can't be longer than the session duration", func(t *testing.T) {
+		_, _, err := service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			SessionDuration:    "30",
+			SessionIdleTimeout: "45",
+		})
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("idle timeout is saved", func(t *testing.T) {
+		_, _, err := service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			SessionDuration:    "30",
+			SessionIdleTimeout: "15",
+		})
+		require.NoError(t, err)
+		require.Equal(t, 15*time.Minute, service.GetDbConfig().SessionIdleTimeout.AsDurationMinutes())
+	})
+
+	t.Run("empty session duration is compared as the default", func(t *testing.T) {
+		_, _, err := service.UpdateAppConfig(t.Context(), dto.AppConfigUpdateDto{
+			SessionIdleTimeout: "60",
+		})
+		require.NoError(t, err)
+	})
+}
+
+func TestValidateAppNameLocalized(t *testing.T) {
+	require.NoError(t, validateAppNameLocalized(""))
+	require.NoError(t, validateAppNameLocalized(`{"de":"Pocket ID DE","de-CH":"Pocket ID CH"}`))
+
+	var validationErr *common.ValidationError
+	require.ErrorAs(t, validateAppNameLocalized(`{"de":""}`), &validationErr)
+	require.ErrorAs(t, validateAppNameLocalized(`{" ":"Pocket ID"}`), &validationErr)
+
+	// Locales that are the same once normalized would make the override that is used random
+	err := validateAppNameLocalized(`{"de-CH":"Pocket ID CH","de_ch":"Pocket ID ch"}`)
+	require.ErrorAs(t, err, &validationErr)
+	require.ErrorContains(t, err, "'de-CH' and 'de_ch'")
+}
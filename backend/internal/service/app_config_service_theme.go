@@ -0,0 +1,125 @@
+package service
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+const (
+	// The minimum contrast ratio for normal text as defined by WCAG 2.x level AA
+	wcagAAContrastRatio = 4.5
+
+	// Colors used by the frontend, see frontend/src/app.css and frontend/src/lib/utils/accent-color-util.ts
+	defaultAccentColor                 = "oklch(0.205 0 0)"
+	accentForegroundLight              = "oklch(0.98 0 0)"
+	accentForegroundDark               = "oklch(0.09 0 0)"
+	lightThemeBackgroundColor          = "oklch(1 0 0)"
+	darkThemeBackgroundColor           = "oklch(0.145 0 0)"
+	accentForegroundLuminanceThreshold = 0.55
+)
+
+// ThemePresets contains the named presets that can be selected with the "themePreset" input when updating the config.
+// Selecting a preset overrides the accent color and the related values once; the preset itself isn't stored, so they can be changed afterwards.
+var ThemePresets = []dto.ThemePresetDto{
+	{Name: "default", AccentColor: "default"},
+	{Name: "rose", AccentColor: "oklch(0.63 0.2 15)"},
+	{Name: "orange", AccentColor: "oklch(0.68 0.2 50)"},
+	{Name: "amber", AccentColor: "oklch(0.75 0.18 80)"},
+	{Name: "green", AccentColor: "oklch(0.65 0.2 150)"},
+	{Name: "teal", AccentColor: "oklch(0.6 0.15 180)"},
+	{Name: "blue", AccentColor: "oklch(0.6 0.2 240)"},
+	{Name: "purple", AccentColor: "oklch(0.6 0.24 300)"},
+	{Name: "high-contrast", AccentColor: "#000000", DisableAnimations: true},
+}
+
+func getThemePreset(name string) (dto.ThemePresetDto, bool) {
+	for _, preset := range ThemePresets {
+		if preset.Name == name {
+			return preset, true
+		}
+	}
+	return dto.ThemePresetDto{}, false
+}
+
+// applyThemePreset overrides the values in the input with the ones of the selected theme preset, if any
+func applyThemePreset(input *dto.AppConfigUpdateDto) error {
+	if input.ThemePreset == "" {
+		return nil
+	}
+
+	preset, ok := getThemePreset(input.ThemePreset)
+	if !ok {
+		return &common.ValidationError{Message: fmt.Sprintf("unknown theme preset '%s'", input.ThemePreset)}
+	}
+
+	input.AccentColor = preset.AccentColor
+	input.DisableAnimations = strconv.FormatBool(preset.DisableAnimations)
+
+	return nil
+}
+
+// GetAccentColorContrast computes the WCAG contrast information for the given accent color.
+// If the accent color is empty, the currently configured one is used.
+func (s *AppConfigService) GetAccentColorContrast(accentColor string) (dto.AccentColorContrastDto, error) {
+	if accentColor == "" {
+		accentColor = s.GetDbConfig().AccentColor.Value
+	}
+
+	return computeAccentColorContrast(accentColor)
+}
+
+func computeAccentColorContrast(accentColor string) (dto.AccentColorContrastDto, error) {
+	colorValue := accentColor
+	if colorValue == "default" {
+		colorValue = defaultAccentColor
+	}
+
+	color, err := utils.ParseColor(colorValue)
+	if err != nil {
+		return dto.AccentColorContrastDto{}, &common.ValidationError{Message: err.Error()}
+	}
+
+	// The frontend picks a light or dark foreground depending on the brightness of the accent color
+	foreground := accentForegroundDark
+	if color.RelativeLuminance() < accentForegroundLuminanceThreshold {
+		foreground = accentForegroundLight
+	}
+
+	foregroundColor, _ := utils.ParseColor(foreground)
+	lightBackground, _ := utils.ParseColor(lightThemeBackgroundColor)
+	darkBackground, _ := utils.ParseColor(darkThemeBackgroundColor)
+
+	foregroundContrast := utils.ContrastRatio(color, foregroundColor)
+
+	return dto.AccentColorContrastDto{
+		AccentColor:             accentColor,
+		Foreground:              foreground,
+		ForegroundContrast:      roundContrast(foregroundContrast),
+		LightBackgroundContrast: roundContrast(utils.ContrastRatio(color, lightBackground)),
+		DarkBackgroundContrast:  roundContrast(utils.ContrastRatio(color, darkBackground)),
+		MeetsWcagAA:             foregroundContrast >= wcagAAContrastRatio,
+	}, nil
+}
+
+// warnLowAccentColorContrast logs a warning if the text on top of the accent color isn't readable enough
+func warnLowAccentColorContrast(accentColor string) {
+	contrast, err := computeAccentColorContrast(accentColor)
+	if err != nil || contrast.MeetsWcagAA {
+		return
+	}
+
+	slog.Warn("The accent color does not meet the WCAG AA contrast requirements",
+		slog.String("accentColor", accentColor),
+		slog.Float64("contrast", contrast.ForegroundContrast),
+	)
+}
+
+func roundContrast(v float64) float64 {
+	return math.Round(v*100) / 100
+}
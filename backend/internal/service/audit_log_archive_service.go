@@ -0,0 +1,250 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/storage"
+)
+
+// Key of the KV entry with the last day whose audit logs have been archived
+const auditLogArchiveCheckpointKVKey = "audit_log_archive_checkpoint"
+
+const auditLogArchiveDayLayout = "2006-01-02"
+
+// AuditLogArchiveService archives old audit logs to a storage before deleting them from the database.
+// Each day is written once to its own gzip-compressed NDJSON object, and the last archived day is saved as a checkpoint,
+// so archives are never modified once the day has been completed.
+type AuditLogArchiveService struct {
+	db           *gorm.DB
+	storage      storage.Storage
+	archiveAfter time.Duration
+}
+
+func NewAuditLogArchiveService(db *gorm.DB, storage storage.Storage, archiveAfter time.Duration) *AuditLogArchiveService {
+	return &AuditLogArchiveService{
+		db:           db,
+		storage:      storage,
+		archiveAfter: archiveAfter,
+	}
+}
+
+// auditLogArchiveRecord is a line of an archive
+type auditLogArchiveRecord struct {
+	ID        string             `json:"id"`
+	CreatedAt time.Time          `json:"createdAt"`
+	Event     string             `json:"event"`
+	IpAddress *string            `json:"ipAddress"`
+	Country   string             `json:"country"`
+	City      string             `json:"city"`
+	UserAgent string             `json:"userAgent"`
+	UserID    string             `json:"userId"`
+	Data      model.AuditLogData `json:"data"`
+}
+
+// ArchiveOldAuditLogs archives the audit logs of the days that are entirely older than the archive age, and deletes them.
+// It returns the number of archived entries.
+func (s *AuditLogArchiveService) ArchiveOldAuditLogs(ctx context.Context) (int, error) {
+	// The entries of a day can take much longer to load and delete than regular queries
+	ctx = utils.WithQueryTimeout(ctx, common.EnvConfig.DbQueryTimeoutMaintenance)
+
+	// Only complete days are archived, so no entry can be added to a day after its archive has been written
+	cutoff := time.Now().UTC().Add(-s.archiveAfter).Truncate(24 * time.Hour)
+
+	checkpoint, err := s.loadCheckpoint(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for {
+		day, ok, err := s.nextDayToArchive(ctx, checkpoint, cutoff)
+		if err != nil {
+			return total, err
+		}
+		if !ok {
+			return total, nil
+		}
+
+		count, err := s.archiveDay(ctx, day)
+		if err != nil {
+			return total, err
+		}
+		total += count
+		checkpoint = day.Add(24 * time.Hour)
+
+		slog.InfoContext(ctx, "Archived audit logs", slog.String("day", day.Format(auditLogArchiveDayLayout)), slog.Int("count", count))
+	}
+}
+
+// nextDayToArchive returns the day of the oldest entry created after the checkpoint and before the cutoff
+func (s *AuditLogArchiveService) nextDayToArchive(ctx context.Context, checkpoint, cutoff time.Time) (time.Time, bool, error) {
+	var oldest model.AuditLog
+	err := s.db.
+		WithContext(ctx).
+		Select("created_at").
+		Where("created_at >= ? AND created_at < ?", datatype.DateTime(checkpoint), datatype.DateTime(cutoff)).
+		Order("created_at ASC").
+		First(&oldest).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to find audit logs to archive: %w", err)
+	}
+
+	return oldest.CreatedAt.ToTime().UTC().Truncate(24 * time.Hour), true, nil
+}
+
+// archiveDay writes the entries of the day to the storage, then deletes them and moves the checkpoint past the day
+func (s *AuditLogArchiveService) archiveDay(ctx context.Context, day time.Time) (int, error) {
+	start := datatype.DateTime(day)
+	end := datatype.DateTime(day.Add(24 * time.Hour))
+
+	// The statement timeout of Postgres can only be overridden for a transaction
+	var auditLogs []model.AuditLog
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := utils.SetLocalStatementTimeout(ctx, tx, common.EnvConfig.DbQueryTimeoutMaintenance)
+		if err != nil {
+			return err
+		}
+
+		return tx.
+			WithContext(ctx).
+			Where("created_at >= ? AND created_at < ?", start, end).
+			Order("created_at ASC, id ASC").
+			Find(&auditLogs).
+			Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to load audit logs to archive: %w", err)
+	}
+
+	data, err := encodeAuditLogArchive(auditLogs)
+	if err != nil {
+		return 0, err
+	}
+
+	// If the deletion below fails, the next run writes the same entries to the same object again, which is harmless
+	key := "audit-logs/" + day.Format("2006") + "/" + day.Format(auditLogArchiveDayLayout) + ".ndjson.gz"
+	err = s.storage.Put(ctx, key, data, "application/gzip")
+	if err != nil {
+		return 0, fmt.Errorf("failed to store audit log archive: %w", err)
+	}
+
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	err = utils.SetLocalStatementTimeout(ctx, tx, common.EnvConfig.DbQueryTimeoutMaintenance)
+	if err != nil {
+		return 0, fmt.Errorf("failed to set the statement timeout: %w", err)
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Delete(&model.AuditLog{}, "created_at >= ? AND created_at < ?", start, end).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete archived audit logs: %w", err)
+	}
+
+	err = s.saveCheckpoint(ctx, tx, day.Add(24*time.Hour))
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return len(auditLogs), nil
+}
+
+func encodeAuditLogArchive(auditLogs []model.AuditLog) ([]byte, error) {
+	var buf bytes.Buffer
+	// The gzip header doesn't contain a timestamp, so archiving the same entries again results in the same object
+	gz := gzip.NewWriter(&buf)
+	enc := json.NewEncoder(gz)
+	for _, auditLog := range auditLogs {
+		err := enc.Encode(auditLogArchiveRecord{
+			ID:        auditLog.ID,
+			CreatedAt: auditLog.CreatedAt.ToTime().UTC(),
+			Event:     string(auditLog.Event),
+			IpAddress: auditLog.IpAddress,
+			Country:   auditLog.Country,
+			City:      auditLog.City,
+			UserAgent: auditLog.UserAgent,
+			UserID:    auditLog.UserID,
+			Data:      auditLog.Data,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode audit log: %w", err)
+		}
+	}
+
+	err := gz.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compress audit log archive: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// loadCheckpoint returns the time before which all audit logs have been archived, or the zero time if nothing was archived yet
+func (s *AuditLogArchiveService) loadCheckpoint(ctx context.Context) (time.Time, error) {
+	var row model.KV
+	err := s.db.
+		WithContext(ctx).
+		Where("key = ?", auditLogArchiveCheckpointKVKey).
+		First(&row).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load audit log archive checkpoint: %w", err)
+	}
+
+	if row.Value == nil || *row.Value == "" {
+		return time.Time{}, nil
+	}
+
+	checkpoint, err := time.Parse(auditLogArchiveDayLayout, *row.Value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse audit log archive checkpoint: %w", err)
+	}
+
+	return checkpoint, nil
+}
+
+func (s *AuditLogArchiveService) saveCheckpoint(ctx context.Context, tx *gorm.DB, checkpoint time.Time) error {
+	value := checkpoint.Format(auditLogArchiveDayLayout)
+	err := tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&model.KV{Key: auditLogArchiveCheckpointKVKey, Value: &value}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to save audit log archive checkpoint: %w", err)
+	}
+
+	return nil
+}
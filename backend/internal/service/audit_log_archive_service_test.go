@@ -0,0 +1,106 @@
+package service
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/storage"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAuditLogArchiveService_ArchiveOldAuditLogs(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	dir := t.TempDir()
+	service := NewAuditLogArchiveService(db, &storage.FileStorage{Dir: dir}, 30*24*time.Hour)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	firstDay := today.AddDate(0, 0, -40)
+	secondDay := today.AddDate(0, 0, -35)
+
+	createAuditLog := func(t *testing.T, createdAt time.Time) model.AuditLog {
+		t.Helper()
+		auditLog := model.AuditLog{Event: model.AuditLogEventSignIn, IpAddress: utils.Ptr("203.0.113.1"), UserID: user.ID, Data: model.AuditLogData{"foo": "bar"}}
+		require.NoError(t, db.Create(&auditLog).Error)
+		// The creation time is always set to the current time when creating a row
+		require.NoError(t, db.Model(&auditLog).UpdateColumn("created_at", datatype.DateTime(createdAt)).Error)
+		return auditLog
+	}
+
+	first := createAuditLog(t, firstDay.Add(2*time.Hour))
+	second := createAuditLog(t, firstDay.Add(20*time.Hour))
+	third := createAuditLog(t, secondDay.Add(time.Hour))
+	recent := createAuditLog(t, today.AddDate(0, 0, -5))
+
+	readArchive := func(t *testing.T, day time.Time) []auditLogArchiveRecord {
+		t.Helper()
+		f, err := os.Open(filepath.Join(dir, "audit-logs", day.Format("2006"), day.Format("2006-01-02")+".ndjson.gz"))
+		require.NoError(t, err)
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		require.NoError(t, err)
+
+		var records []auditLogArchiveRecord
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var record auditLogArchiveRecord
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+			records = append(records, record)
+		}
+		require.NoError(t, scanner.Err())
+		return records
+	}
+
+	count, err := service.ArchiveOldAuditLogs(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	firstRecords := readArchive(t, firstDay)
+	require.Len(t, firstRecords, 2)
+	assert.Equal(t, first.ID, firstRecords[0].ID)
+	assert.Equal(t, second.ID, firstRecords[1].ID)
+	assert.Equal(t, "203.0.113.1", *firstRecords[0].IpAddress)
+	assert.Equal(t, model.AuditLogData{"foo": "bar"}, firstRecords[0].Data)
+
+	secondRecords := readArchive(t, secondDay)
+	require.Len(t, secondRecords, 1)
+	assert.Equal(t, third.ID, secondRecords[0].ID)
+
+	var remaining []model.AuditLog
+	require.NoError(t, db.Find(&remaining).Error)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, recent.ID, remaining[0].ID)
+
+	checkpoint, err := service.loadCheckpoint(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, secondDay.AddDate(0, 0, 1), checkpoint)
+
+	t.Run("does nothing if there is nothing new to archive", func(t *testing.T) {
+		count, err := service.ArchiveOldAuditLogs(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		assert.Len(t, readArchive(t, firstDay), 2)
+	})
+
+	t.Run("doesn't rewrite days before the checkpoint", func(t *testing.T) {
+		createAuditLog(t, firstDay.Add(time.Hour))
+
+		count, err := service.ArchiveOldAuditLogs(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+		assert.Len(t, readArchive(t, firstDay), 2)
+	})
+}
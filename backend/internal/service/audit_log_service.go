@@ -2,40 +2,66 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"net/netip"
+	"time"
 
 	userAgentParser "github.com/mileusna/useragent"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
-	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 )
 
+// Type of the outbox messages that send the email notifying users of a sign in from a new device
+const outboxMessageNewLoginEmail = "newLoginEmail"
+
 type AuditLogService struct {
 	db               *gorm.DB
 	appConfigService *AppConfigService
 	emailService     *EmailService
 	geoliteService   *GeoLiteService
+	outboxService    *OutboxService
+	httpClient       *http.Client
 }
 
-func NewAuditLogService(db *gorm.DB, appConfigService *AppConfigService, emailService *EmailService, geoliteService *GeoLiteService) *AuditLogService {
-	return &AuditLogService{
+func NewAuditLogService(db *gorm.DB, httpClient *http.Client, appConfigService *AppConfigService, emailService *EmailService, geoliteService *GeoLiteService, outboxService *OutboxService) *AuditLogService {
+	s := &AuditLogService{
 		db:               db,
 		appConfigService: appConfigService,
 		emailService:     emailService,
 		geoliteService:   geoliteService,
+		outboxService:    outboxService,
+		httpClient:       httpClient,
 	}
+
+	outboxService.RegisterHandler(outboxMessageNewLoginEmail, outboxHandlerFor(s.sendNewLoginEmail))
+	outboxService.RegisterHandler(outboxMessageSuspiciousSignInCheck, outboxHandlerFor(s.checkSuspiciousSignIn))
+	outboxService.RegisterHandler(outboxMessageSuspiciousSignInWebhook, outboxHandlerFor(s.sendSuspiciousSignInWebhook))
+
+	return s
+}
+
+// newLoginEmailPayload is the payload of the outbox messages that send the new login email
+type newLoginEmailPayload struct {
+	UserID    string    `json:"userId"`
+	IPAddress string    `json:"ipAddress"`
+	Country   string    `json:"country"`
+	City      string    `json:"city"`
+	Device    string    `json:"device"`
+	DateTime  time.Time `json:"dateTime"`
 }
 
 // Create creates a new audit log entry in the database
 func (s *AuditLogService) Create(ctx context.Context, event model.AuditLogEvent, ipAddress, userAgent, userID string, data model.AuditLogData, tx *gorm.DB) (model.AuditLog, bool) {
-	country, city, err := s.geoliteService.GetLocationByIP(ipAddress)
+	country, city, err := s.geoliteService.GetLocationByIP(ctx, ipAddress)
 	if err != nil {
 		// Log the error but don't interrupt the operation
-		slog.Warn("Failed to get IP location", "error", err)
+		slog.WarnContext(ctx, "Failed to get IP location", "error", err)
 	}
 
 	auditLog := model.AuditLog{
@@ -45,6 +71,8 @@ func (s *AuditLogService) Create(ctx context.Context, event model.AuditLogEvent,
 		UserAgent: userAgent,
 		UserID:    userID,
 		Data:      data,
+		// If the lookup failed, e.g. because the GeoLite database isn't available yet, the location is resolved later
+		LocationUnknown: err == nil && ipAddress != "" && country == "",
 	}
 
 	if ipAddress != "" {
@@ -53,18 +81,31 @@ func (s *AuditLogService) Create(ctx context.Context, event model.AuditLogEvent,
 	}
 
 	// Save the audit log in the database
-	err = tx.
-		WithContext(ctx).
+	stmt := tx.WithContext(ctx)
+	if userID == "" {
+		// Events that aren't caused by a user, like tokens issued to clients, are stored with a NULL user ID
+		stmt = stmt.Omit("UserID")
+	}
+	err = stmt.
 		Create(&auditLog).
 		Error
 	if err != nil {
-		slog.Error("Failed to create audit log", "error", err)
+		slog.ErrorContext(ctx, "Failed to create audit log", "error", err)
 		return model.AuditLog{}, false
 	}
 
 	return auditLog, true
 }
 
+// CreateConfigChanged creates an audit log entry recording the configuration keys changed by an admin
+func (s *AuditLogService) CreateConfigChanged(ctx context.Context, ipAddress, userAgent, userID string, changes model.AuditLogData) {
+	if len(changes) == 0 {
+		return
+	}
+
+	s.Create(ctx, model.AuditLogEventConfigChanged, ipAddress, userAgent, userID, changes, s.db)
+}
+
 // CreateNewSignInWithEmail creates a new audit log entry in the database and sends an email if the device hasn't been used before
 func (s *AuditLogService) CreateNewSignInWithEmail(ctx context.Context, ipAddress, userAgent, userID string, tx *gorm.DB) model.AuditLog {
 	createdAuditLog, ok := s.Create(ctx, model.AuditLogEventSignIn, ipAddress, userAgent, userID, model.AuditLogData{}, tx)
@@ -92,45 +133,57 @@ func (s *AuditLogService) CreateNewSignInWithEmail(ctx context.Context, ipAddres
 	}
 
 	// If the user hasn't logged in from the same device before and email notifications are enabled, send an email
+	// The email is sent by the outbox worker once the transaction has been committed
 	if s.appConfigService.GetDbConfig().EmailLoginNotificationEnabled.IsTrue() && count <= 1 {
-		// We use a background context here as this is running in a goroutine
-		//nolint:contextcheck
-		go func() {
-			span := trace.SpanFromContext(ctx)
-			innerCtx := trace.ContextWithSpan(context.Background(), span)
-
-			// Note we don't use the transaction here because this is running in background
-			var user model.User
-			innerErr := s.db.
-				WithContext(innerCtx).
-				Where("id = ?", userID).
-				First(&user).
-				Error
-			if innerErr != nil {
-				slog.ErrorContext(innerCtx, "Failed to load user from database to send notification email", slog.Any("error", innerErr))
-				return
-			}
-
-			innerErr = SendEmail(innerCtx, s.emailService, email.Address{
-				Name:  user.FullName(),
-				Email: user.Email,
-			}, NewLoginTemplate, &NewLoginTemplateData{
-				IPAddress: ipAddress,
-				Country:   createdAuditLog.Country,
-				City:      createdAuditLog.City,
-				Device:    s.DeviceStringFromUserAgent(userAgent),
-				DateTime:  createdAuditLog.CreatedAt.UTC(),
-			})
-			if innerErr != nil {
-				slog.ErrorContext(innerCtx, "Failed to send notification email", slog.Any("error", innerErr), slog.String("address", user.Email))
-				return
-			}
-		}()
+		err = s.outboxService.Enqueue(ctx, outboxMessageNewLoginEmail, newLoginEmailPayload{
+			UserID:    userID,
+			IPAddress: ipAddress,
+			Country:   createdAuditLog.Country,
+			City:      createdAuditLog.City,
+			Device:    s.DeviceStringFromUserAgent(userAgent),
+			DateTime:  createdAuditLog.CreatedAt.UTC(),
+		}, tx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to enqueue notification email", slog.Any("error", err))
+		}
 	}
 
 	return createdAuditLog
 }
 
+func (s *AuditLogService) sendNewLoginEmail(ctx context.Context, payload newLoginEmailPayload) error {
+	var user model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ?", payload.UserID).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The user has been deleted in the meantime, so there's no one to notify
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load user from database to send notification email: %w", err)
+	}
+
+	err = SendEmail(ctx, s.emailService, email.Address{
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+		UserID: user.ID,
+	}, NewLoginTemplate, &NewLoginTemplateData{
+		IPAddress: payload.IPAddress,
+		Country:   payload.Country,
+		City:      payload.City,
+		Device:    payload.Device,
+		DateTime:  payload.DateTime,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send notification email to '%s': %w", user.Email, err)
+	}
+
+	return nil
+}
+
 // ListAuditLogsForUser retrieves all audit logs for a given user ID
 func (s *AuditLogService) ListAuditLogsForUser(ctx context.Context, userID string, sortedPaginationRequest utils.SortedPaginationRequest) ([]model.AuditLog, utils.PaginationResponse, error) {
 	var logs []model.AuditLog
@@ -173,6 +226,9 @@ func (s *AuditLogService) ListAllAuditLogs(ctx context.Context, sortedPagination
 			return nil, utils.PaginationResponse{}, fmt.Errorf("unsupported database dialect: %s", dialect)
 		}
 	}
+	if filters.Country != "" {
+		query = query.Where("country = ?", filters.Country)
+	}
 	if filters.Location != "" {
 		switch filters.Location {
 		case "external":
@@ -190,6 +246,78 @@ func (s *AuditLogService) ListAllAuditLogs(ctx context.Context, sortedPagination
 	return logs, pagination, nil
 }
 
+// maxLocationsResolvedPerBatch limits the IP addresses loaded at once when resolving missing locations
+const maxLocationsResolvedPerBatch = 500
+
+// ResolveMissingLocations stores the location of the audit log entries that have an IP address but no location,
+// e.g. because the GeoLite database wasn't available yet when they were created.
+// IP addresses without a known location are marked, so they aren't looked up again.
+// It returns the number of IP addresses that were looked up.
+func (s *AuditLogService) ResolveMissingLocations(ctx context.Context) (int, error) {
+	resolved := 0
+	for {
+		var ipAddresses []string
+		err := s.db.
+			WithContext(ctx).
+			Model(&model.AuditLog{}).
+			Where("ip_address IS NOT NULL AND (country IS NULL OR country = '') AND NOT location_unknown").
+			Distinct().
+			Limit(maxLocationsResolvedPerBatch).
+			Pluck("ip_address", &ipAddresses).
+			Error
+		if err != nil {
+			return resolved, fmt.Errorf("failed to load audit logs without location: %w", err)
+		}
+
+		for _, ipAddress := range ipAddresses {
+			updates := map[string]any{"location_unknown": true}
+			if _, err := netip.ParseAddr(ipAddress); err == nil {
+				country, city, err := s.geoliteService.GetLocationByIP(ctx, ipAddress)
+				if err != nil {
+					// The GeoLite database is most likely not available, so the next run tries again
+					return resolved, fmt.Errorf("failed to get IP location of audit logs: %w", err)
+				}
+				if country != "" {
+					updates = map[string]any{"country": country, "city": city}
+				}
+			}
+
+			err = s.db.
+				WithContext(ctx).
+				Model(&model.AuditLog{}).
+				Where("ip_address = ? AND (country IS NULL OR country = '') AND NOT location_unknown", ipAddress).
+				Updates(updates).
+				Error
+			if err != nil {
+				return resolved, fmt.Errorf("failed to store IP location of audit logs: %w", err)
+			}
+			resolved++
+		}
+
+		if len(ipAddresses) < maxLocationsResolvedPerBatch {
+			return resolved, nil
+		}
+	}
+}
+
+// ListCountries returns the countries the audit log entries come from
+func (s *AuditLogService) ListCountries(ctx context.Context) (countries []string, err error) {
+	countries = make([]string, 0)
+	err = s.db.
+		WithContext(ctx).
+		Model(&model.AuditLog{}).
+		Where("country IS NOT NULL AND country <> ''").
+		Distinct().
+		Order("country").
+		Pluck("country", &countries).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query countries: %w", err)
+	}
+
+	return countries, nil
+}
+
 func (s *AuditLogService) ListUsernamesWithIds(ctx context.Context) (users map[string]string, err error) {
 	query := s.db.
 		WithContext(ctx).
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+const (
+	GeoblockingModeDisabled  = "disabled"
+	GeoblockingModeAllowList = "allowList"
+	GeoblockingModeDenyList  = "denyList"
+)
+
+// CheckSignInLocation returns an error if the geoblocking policy doesn't allow the user to sign in from the IP address.
+// Private IP addresses are always allowed. The caller records blocked sign ins with LogSignInGeoblocked,
+// after it rolled back its transaction.
+func (s *AuditLogService) CheckSignInLocation(ctx context.Context, ipAddress string, user *model.User) error {
+	if common.EnvConfig.GeoblockingDisabled {
+		return nil
+	}
+
+	cfg := s.appConfigService.GetDbConfig()
+	mode := cfg.GeoblockingMode.Value
+	if mode != GeoblockingModeAllowList && mode != GeoblockingModeDenyList {
+		return nil
+	}
+	if user != nil && user.IsAdmin && cfg.GeoblockingExemptAdmins.IsTrue() {
+		return nil
+	}
+
+	location, err := s.geoliteService.LookupIP(ctx, ipAddress)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to get IP location for geoblocking", slog.Any("error", err))
+	}
+
+	var allowed bool
+	switch {
+	case location.Private:
+		allowed = true
+	case err != nil || !location.Found:
+		allowed = cfg.GeoblockingAllowUnknown.IsTrue()
+	default:
+		listed := geoblockingCountriesContain(cfg.GeoblockingCountries.Value, location)
+		allowed = listed == (mode == GeoblockingModeAllowList)
+	}
+	if allowed {
+		return nil
+	}
+
+	return &common.GeoblockedError{CountryCode: location.CountryCode}
+}
+
+// LogSignInGeoblocked records a sign in that was blocked by CheckSignInLocation in the audit log.
+// Other errors are ignored, so the callers can pass the error of CheckSignInLocation as is.
+func (s *AuditLogService) LogSignInGeoblocked(ctx context.Context, err error, ipAddress, userAgent string, user *model.User) {
+	var geoblockedErr *common.GeoblockedError
+	if !errors.As(err, &geoblockedErr) {
+		return
+	}
+
+	var userID string
+	if user != nil {
+		userID = user.ID
+	}
+	s.Create(ctx, model.AuditLogEventSignInGeoblocked, ipAddress, userAgent, userID, model.AuditLogData{
+		"countryCode": geoblockedErr.CountryCode,
+	}, s.db)
+}
+
+// geoblockingCountriesContain returns true if the country of the location is in the comma-separated list, which can contain ISO codes or English names
+func geoblockingCountriesContain(countries string, location IPLocation) bool {
+	for _, country := range strings.Split(countries, ",") {
+		country = strings.TrimSpace(country)
+		if country == "" {
+			continue
+		}
+		if strings.EqualFold(country, location.CountryCode) || strings.EqualFold(country, location.Country) {
+			return true
+		}
+	}
+	return false
+}
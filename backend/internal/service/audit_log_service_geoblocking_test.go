@@ -0,0 +1,89 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAuditLogService_CheckSignInLocation(t *testing.T) {
+	// Without a database, the location of public IP addresses is unknown
+	originalPath := common.EnvConfig.GeoLiteDBPath
+	common.EnvConfig.GeoLiteDBPath = filepath.Join(t.TempDir(), "missing.mmdb")
+	defer func() {
+		common.EnvConfig.GeoLiteDBPath = originalPath
+	}()
+
+	db := testutils.NewDatabaseForTest(t)
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	admin := model.User{Username: "admin", Email: "admin@example.com", FirstName: "Admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+
+	newService := func(cfg *model.AppConfig) *AuditLogService {
+		return &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: NewTestAppConfigService(cfg)}
+	}
+	blockUnknown := &model.AppConfig{
+		GeoblockingMode:         model.AppConfigVariable{Value: GeoblockingModeAllowList},
+		GeoblockingCountries:    model.AppConfigVariable{Value: "CH"},
+		GeoblockingAllowUnknown: model.AppConfigVariable{Value: "false"},
+		GeoblockingExemptAdmins: model.AppConfigVariable{Value: "true"},
+	}
+
+	t.Run("allows everyone if disabled", func(t *testing.T) {
+		service := newService(&model.AppConfig{GeoblockingMode: model.AppConfigVariable{Value: GeoblockingModeDisabled}})
+		require.NoError(t, service.CheckSignInLocation(t.Context(), "203.0.113.1", &user))
+	})
+
+	t.Run("always allows private IP addresses", func(t *testing.T) {
+		require.NoError(t, newService(blockUnknown).CheckSignInLocation(t.Context(), "192.168.1.1", &user))
+	})
+
+	t.Run("allows unknown locations if configured", func(t *testing.T) {
+		cfg := *blockUnknown
+		cfg.GeoblockingAllowUnknown = model.AppConfigVariable{Value: "true"}
+		require.NoError(t, newService(&cfg).CheckSignInLocation(t.Context(), "203.0.113.1", &user))
+	})
+
+	t.Run("blocks unknown locations and records it", func(t *testing.T) {
+		service := newService(blockUnknown)
+		err := service.CheckSignInLocation(t.Context(), "203.0.113.1", &user)
+		var geoblockedErr *common.GeoblockedError
+		require.ErrorAs(t, err, &geoblockedErr)
+
+		service.LogSignInGeoblocked(t.Context(), err, "203.0.113.1", "test-agent", &user)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventSignInGeoblocked).First(&auditLog).Error)
+		assert.Equal(t, user.ID, auditLog.UserID)
+		assert.Equal(t, "test-agent", auditLog.UserAgent)
+	})
+
+	t.Run("exempts admins if configured", func(t *testing.T) {
+		require.NoError(t, newService(blockUnknown).CheckSignInLocation(t.Context(), "203.0.113.1", &admin))
+	})
+
+	t.Run("is disabled by the emergency override", func(t *testing.T) {
+		common.EnvConfig.GeoblockingDisabled = true
+		defer func() {
+			common.EnvConfig.GeoblockingDisabled = false
+		}()
+		require.NoError(t, newService(blockUnknown).CheckSignInLocation(t.Context(), "203.0.113.1", &user))
+	})
+}
+
+func TestGeoblockingCountriesContain(t *testing.T) {
+	location := IPLocation{Country: "Switzerland", CountryCode: "CH", Found: true}
+
+	assert.True(t, geoblockingCountriesContain("DE, ch", location))
+	assert.True(t, geoblockingCountriesContain("Germany,switzerland", location))
+	assert.False(t, geoblockingCountriesContain("DE,AT", location))
+	assert.False(t, geoblockingCountriesContain("", location))
+	assert.False(t, geoblockingCountriesContain(",", IPLocation{}))
+}
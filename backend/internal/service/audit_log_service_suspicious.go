@@ -0,0 +1,256 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+const (
+	// Type of the outbox messages that check if a sign in is suspicious, which is done after the sign in so it doesn't slow it down
+	outboxMessageSuspiciousSignInCheck = "suspiciousSignInCheck"
+	// Type of the outbox messages that notify the configured webhook of a suspicious sign in
+	outboxMessageSuspiciousSignInWebhook = "suspiciousSignInWebhook"
+)
+
+// Reasons why a sign in is considered suspicious
+const (
+	SuspiciousSignInReasonNewCountry       = "newCountry"
+	SuspiciousSignInReasonImpossibleTravel = "impossibleTravel"
+)
+
+// Sign ins that happen less than a minute apart are considered a minute apart, so the speed stays finite
+const minTravelDuration = time.Minute
+
+type suspiciousSignInCheckPayload struct {
+	UserID            string     `json:"userId"`
+	IPAddress         string     `json:"ipAddress"`
+	UserAgent         string     `json:"userAgent"`
+	SignInAt          time.Time  `json:"signInAt"`
+	PreviousIPAddress string     `json:"previousIpAddress"`
+	PreviousSignInAt  *time.Time `json:"previousSignInAt"`
+}
+
+type suspiciousSignInWebhookPayload struct {
+	Event      string    `json:"event"`
+	UserID     string    `json:"userId"`
+	Username   string    `json:"username"`
+	IPAddress  string    `json:"ipAddress"`
+	Country    string    `json:"country"`
+	City       string    `json:"city"`
+	Reasons    []string  `json:"reasons"`
+	DistanceKm *int      `json:"distanceKm,omitempty"`
+	SpeedKmh   *int      `json:"speedKmh,omitempty"`
+	SignInAt   time.Time `json:"signInAt"`
+}
+
+// EnqueueSuspiciousSignInCheck schedules the check of a sign in for signs of a compromised account, if enabled.
+// The user must be loaded before its last sign in is updated, as the check compares the sign in with the previous one.
+func (s *AuditLogService) EnqueueSuspiciousSignInCheck(ctx context.Context, user *model.User, ipAddress, userAgent string, tx *gorm.DB) {
+	if !s.appConfigService.GetDbConfig().SuspiciousSignInDetectionEnabled.IsTrue() || ipAddress == "" {
+		return
+	}
+
+	payload := suspiciousSignInCheckPayload{
+		UserID:    user.ID,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		SignInAt:  time.Now().UTC(),
+	}
+	if user.LastLoginIP != nil && user.LastLoginAt != nil {
+		previousSignInAt := user.LastLoginAt.UTC()
+		payload.PreviousIPAddress = *user.LastLoginIP
+		payload.PreviousSignInAt = &previousSignInAt
+	}
+
+	err := s.outboxService.Enqueue(ctx, outboxMessageSuspiciousSignInCheck, payload, tx)
+	if err != nil {
+		// The sign in isn't interrupted if the check can't be scheduled
+		slog.ErrorContext(ctx, "Failed to enqueue suspicious sign in check", slog.Any("error", err))
+	}
+}
+
+func (s *AuditLogService) checkSuspiciousSignIn(ctx context.Context, payload suspiciousSignInCheckPayload) error {
+	cfg := s.appConfigService.GetDbConfig()
+
+	location, err := s.geoliteService.LookupIP(ctx, payload.IPAddress)
+	if err != nil {
+		return fmt.Errorf("failed to get IP location: %w", err)
+	}
+	if location.Private || !location.Found {
+		// There's nothing to compare
+		return nil
+	}
+
+	var reasons []string
+	data := model.AuditLogData{}
+
+	newCountry, err := s.isNewSignInCountry(ctx, payload.UserID, location.Country, payload.SignInAt)
+	if err != nil {
+		return err
+	}
+	if newCountry {
+		reasons = append(reasons, SuspiciousSignInReasonNewCountry)
+	}
+
+	var distanceKm, speedKmh *int
+	if payload.PreviousSignInAt != nil && payload.PreviousIPAddress != payload.IPAddress {
+		previousLocation, err := s.geoliteService.LookupIP(ctx, payload.PreviousIPAddress)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to get location of the previous sign in", slog.Any("error", err))
+		}
+
+		maxSpeed, _ := strconv.ParseFloat(cfg.SuspiciousSignInMaxSpeed.Value, 64)
+		minDistance, _ := strconv.ParseFloat(cfg.SuspiciousSignInMinDistance.Value, 64)
+		distance, speed, impossible := isImpossibleTravel(previousLocation, location, payload.SignInAt.Sub(*payload.PreviousSignInAt), maxSpeed, minDistance)
+		if impossible {
+			reasons = append(reasons, SuspiciousSignInReasonImpossibleTravel)
+			distanceKm, speedKmh = new(int), new(int)
+			*distanceKm, *speedKmh = int(distance), int(speed)
+			data["previousCountry"] = previousLocation.Country
+			data["distanceKm"] = strconv.Itoa(*distanceKm)
+			data["speedKmh"] = strconv.Itoa(*speedKmh)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+	data["reasons"] = strings.Join(reasons, ",")
+
+	var user model.User
+	err = s.db.WithContext(ctx).Where("id = ?", payload.UserID).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The user has been deleted in the meantime
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load user from database: %w", err)
+	}
+
+	slog.WarnContext(ctx, "Suspicious sign in detected", slog.String("user", user.Username), slog.Any("reasons", reasons))
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		_, ok := s.Create(ctx, model.AuditLogEventSuspiciousSignIn, payload.IPAddress, payload.UserAgent, user.ID, data, tx)
+		if !ok {
+			return errors.New("failed to create audit log")
+		}
+
+		if cfg.SuspiciousSignInWebhookUrl.Value == "" {
+			return nil
+		}
+		return s.outboxService.Enqueue(ctx, outboxMessageSuspiciousSignInWebhook, suspiciousSignInWebhookPayload{
+			Event:      "suspicious_sign_in",
+			UserID:     user.ID,
+			Username:   user.Username,
+			IPAddress:  payload.IPAddress,
+			Country:    location.Country,
+			City:       location.City,
+			Reasons:    reasons,
+			DistanceKm: distanceKm,
+			SpeedKmh:   speedKmh,
+			SignInAt:   payload.SignInAt,
+		}, tx)
+	})
+}
+
+// isNewSignInCountry returns true if the user signed in before, but never from the country
+func (s *AuditLogService) isNewSignInCountry(ctx context.Context, userID string, country string, before time.Time) (bool, error) {
+	var previous []string
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.AuditLog{}).
+		Where("user_id = ? AND created_at < ?", userID, datatype.DateTime(before)).
+		Where("event IN ?", []model.AuditLogEvent{model.AuditLogEventSignIn, model.AuditLogEventOneTimeAccessTokenSignIn}).
+		Distinct().
+		Pluck("country", &previous).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load the countries of previous sign ins: %w", err)
+	}
+
+	// Users without previous sign ins, or whose previous sign ins have no known location, are never flagged
+	known := false
+	for _, c := range previous {
+		if c == country {
+			return false, nil
+		}
+		if c != "" {
+			known = true
+		}
+	}
+	return known, nil
+}
+
+// isImpossibleTravel returns true if the user would have needed to travel faster than maxSpeedKmh between the two locations.
+// As IP locations are inaccurate, the distance is reduced by the accuracy radius of both locations, and distances below minDistanceKm are ignored.
+func isImpossibleTravel(from, to IPLocation, elapsed time.Duration, maxSpeedKmh, minDistanceKm float64) (distanceKm, speedKmh float64, impossible bool) {
+	if !from.HasCoordinates || !to.HasCoordinates || maxSpeedKmh <= 0 {
+		return 0, 0, false
+	}
+
+	distanceKm = haversineDistanceKm(from.Latitude, from.Longitude, to.Latitude, to.Longitude)
+	distanceKm -= float64(from.AccuracyRadiusKm) + float64(to.AccuracyRadiusKm)
+	if distanceKm <= 0 || distanceKm < minDistanceKm {
+		return 0, 0, false
+	}
+
+	speedKmh = distanceKm / max(elapsed, minTravelDuration).Hours()
+	return distanceKm, speedKmh, speedKmh > maxSpeedKmh
+}
+
+// haversineDistanceKm returns the great-circle distance between two coordinates
+func haversineDistanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	const earthRadiusKm = 6371
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := toRad(lat2 - lat1)
+	dLon := toRad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+func (s *AuditLogService) sendSuspiciousSignInWebhook(ctx context.Context, payload suspiciousSignInWebhookPayload) error {
+	webhookUrl := s.appConfigService.GetDbConfig().SuspiciousSignInWebhookUrl.Value
+	if webhookUrl == "" {
+		// The webhook was removed in the meantime
+		return nil
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
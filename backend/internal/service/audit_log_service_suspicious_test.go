@@ -0,0 +1,110 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestIsImpossibleTravel(t *testing.T) {
+	zurich := IPLocation{Latitude: 47.3769, Longitude: 8.5417, HasCoordinates: true, Found: true}
+	newYork := IPLocation{Latitude: 40.7128, Longitude: -74.0060, HasCoordinates: true, Found: true}
+
+	t.Run("flags travel faster than the maximum speed", func(t *testing.T) {
+		distance, speed, impossible := isImpossibleTravel(zurich, newYork, time.Hour, 1000, 500)
+		assert.True(t, impossible)
+		assert.InDelta(t, 6330, distance, 20)
+		assert.InDelta(t, 6330, speed, 20)
+	})
+
+	t.Run("allows travel slower than the maximum speed", func(t *testing.T) {
+		_, _, impossible := isImpossibleTravel(zurich, newYork, 10*time.Hour, 1000, 500)
+		assert.False(t, impossible)
+	})
+
+	t.Run("ignores short distances", func(t *testing.T) {
+		geneva := IPLocation{Latitude: 46.2044, Longitude: 6.1432, HasCoordinates: true, Found: true}
+		_, _, impossible := isImpossibleTravel(zurich, geneva, time.Second, 1000, 500)
+		assert.False(t, impossible)
+	})
+
+	t.Run("subtracts the accuracy radius", func(t *testing.T) {
+		inaccurate := newYork
+		inaccurate.AccuracyRadiusKm = 1000
+		distance, _, _ := isImpossibleTravel(zurich, inaccurate, time.Hour, 1000, 500)
+		assert.InDelta(t, 5330, distance, 20)
+	})
+
+	t.Run("ignores locations without coordinates", func(t *testing.T) {
+		_, _, impossible := isImpossibleTravel(zurich, IPLocation{Found: true}, time.Second, 1000, 500)
+		assert.False(t, impossible)
+	})
+}
+
+func TestAuditLogService_IsNewSignInCountry(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := &AuditLogService{db: db}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	// Users who never signed in before aren't flagged
+	isNew, err := service.isNewSignInCountry(t.Context(), user.ID, "Switzerland", time.Now())
+	require.NoError(t, err)
+	assert.False(t, isNew)
+
+	require.NoError(t, db.Create(&model.AuditLog{Event: model.AuditLogEventSignIn, Country: "Switzerland", UserID: user.ID, Data: model.AuditLogData{}}).Error)
+	// Other events don't count as sign ins
+	require.NoError(t, db.Create(&model.AuditLog{Event: model.AuditLogEventSignInGeoblocked, Country: "Germany", UserID: user.ID, Data: model.AuditLogData{}}).Error)
+
+	now := time.Now().Add(time.Second)
+
+	isNew, err = service.isNewSignInCountry(t.Context(), user.ID, "Switzerland", now)
+	require.NoError(t, err)
+	assert.False(t, isNew)
+
+	isNew, err = service.isNewSignInCountry(t.Context(), user.ID, "Germany", now)
+	require.NoError(t, err)
+	assert.True(t, isNew)
+
+	// Sign ins after the checked one are ignored
+	isNew, err = service.isNewSignInCountry(t.Context(), user.ID, "Germany", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.False(t, isNew)
+}
+
+func TestAuditLogService_SendSuspiciousSignInWebhook(t *testing.T) {
+	var received suspiciousSignInWebhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	service := &AuditLogService{
+		httpClient: server.Client(),
+		appConfigService: NewTestAppConfigService(&model.AppConfig{
+			SuspiciousSignInWebhookUrl: model.AppConfigVariable{Value: server.URL},
+		}),
+	}
+
+	payload := suspiciousSignInWebhookPayload{
+		Event:    "suspicious_sign_in",
+		UserID:   "user-id",
+		Username: "john",
+		Reasons:  []string{SuspiciousSignInReasonNewCountry},
+		SignInAt: time.Now().UTC().Truncate(time.Second),
+	}
+	require.NoError(t, service.sendSuspiciousSignInWebhook(t.Context(), payload))
+	assert.Equal(t, payload, received)
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestAuditLogService_ListAllAuditLogs_Location(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := &AuditLogService{db: db, geoliteService: &GeoLiteService{}}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	swiss := model.AuditLog{Event: model.AuditLogEventSignIn, IpAddress: utils.Ptr("203.0.113.1"), Country: "Switzerland", City: "Zurich", UserID: user.ID, Data: model.AuditLogData{}}
+	// Created while the location couldn't be resolved, so it's resolved in the background
+	unresolved := model.AuditLog{Event: model.AuditLogEventSignIn, IpAddress: utils.Ptr("192.168.1.10"), UserID: user.ID, Data: model.AuditLogData{}}
+	unknown := model.AuditLog{Event: model.AuditLogEventSignIn, IpAddress: utils.Ptr("not-an-ip"), UserID: user.ID, Data: model.AuditLogData{}}
+	require.NoError(t, db.Create(&[]*model.AuditLog{&swiss, &unresolved, &unknown}).Error)
+
+	list := func(t *testing.T, filters dto.AuditLogFilterDto) []string {
+		t.Helper()
+		logs, _, err := service.ListAllAuditLogs(t.Context(), utils.SortedPaginationRequest{}, filters)
+		require.NoError(t, err)
+
+		ids := make([]string, len(logs))
+		for i, log := range logs {
+			ids[i] = log.ID
+		}
+		return ids
+	}
+
+	t.Run("filters by country", func(t *testing.T) {
+		assert.Equal(t, []string{swiss.ID}, list(t, dto.AuditLogFilterDto{Country: "Switzerland"}))
+		assert.Empty(t, list(t, dto.AuditLogFilterDto{Country: "Germany"}))
+	})
+
+	t.Run("doesn't resolve the locations when listing", func(t *testing.T) {
+		assert.Empty(t, list(t, dto.AuditLogFilterDto{Location: "internal"}))
+	})
+
+	t.Run("resolves the missing locations once", func(t *testing.T) {
+		count, err := service.ResolveMissingLocations(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		var stored model.AuditLog
+		require.NoError(t, db.First(&stored, "id = ?", unresolved.ID).Error)
+		assert.Equal(t, "Internal Network", stored.Country)
+		var storedUnknown model.AuditLog
+		require.NoError(t, db.First(&storedUnknown, "id = ?", unknown.ID).Error)
+		assert.Empty(t, storedUnknown.Country)
+		assert.True(t, storedUnknown.LocationUnknown)
+
+		// Addresses without a known location aren't looked up again
+		count, err = service.ResolveMissingLocations(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+	})
+
+	t.Run("filters by network", func(t *testing.T) {
+		assert.Equal(t, []string{unresolved.ID}, list(t, dto.AuditLogFilterDto{Location: "internal"}))
+		// Addresses without a known location aren't in the internal network
+		assert.ElementsMatch(t, []string{swiss.ID, unknown.ID}, list(t, dto.AuditLogFilterDto{Location: "external"}))
+	})
+
+	t.Run("lists the countries", func(t *testing.T) {
+		countries, err := service.ListCountries(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Internal Network", "Switzerland"}, countries)
+	})
+}
@@ -0,0 +1,527 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+const (
+	// Interval at which the queue is polled for jobs to run
+	backgroundJobPollInterval = 5 * time.Second
+	// How often the worker extends the lock of the running job, saves its progress, and checks whether it was canceled
+	backgroundJobHeartbeatInterval = 2 * time.Second
+	// How long a job is locked by the worker after each heartbeat; if the lock expires, the worker has stopped
+	backgroundJobLockDuration = time.Minute
+	// Timeout for saving the outcome of a job when the worker is stopping
+	backgroundJobSaveTimeout = 10 * time.Second
+
+	// BackgroundJobRetention is how long finished jobs are kept, so clients can fetch their result
+	BackgroundJobRetention = 7 * 24 * time.Hour
+)
+
+// BackgroundJobProgressFunc reports how many of the total steps of a job are done
+type BackgroundJobProgressFunc func(done, total int)
+
+type backgroundJobProgressContextKey struct{}
+
+// WithBackgroundJobProgress passes the progress function of a job to operations that don't take it as an argument,
+// e.g. because they're coalesced with calls that don't run in a job
+func WithBackgroundJobProgress(ctx context.Context, progress BackgroundJobProgressFunc) context.Context {
+	return context.WithValue(ctx, backgroundJobProgressContextKey{}, progress)
+}
+
+// backgroundJobProgressFromContext returns the progress function passed with WithBackgroundJobProgress, or one that does nothing
+func backgroundJobProgressFromContext(ctx context.Context) BackgroundJobProgressFunc {
+	if progress, ok := ctx.Value(backgroundJobProgressContextKey{}).(BackgroundJobProgressFunc); ok && progress != nil {
+		return progress
+	}
+	return func(int, int) {}
+}
+
+// BackgroundJobHandler runs the jobs of a type
+type BackgroundJobHandler struct {
+	// Run runs a job; the payload is the JSON-encoded value passed to Submit, and the returned result is stored as JSON
+	Run func(ctx context.Context, payload []byte, progress BackgroundJobProgressFunc) (any, error)
+	// If true, running jobs can be canceled; Run must return once the context is canceled
+	Cancellable bool
+	// If true, jobs whose worker stopped are queued again, otherwise they are marked as failed
+	Resumable bool
+}
+
+// LeaderChecker returns nil if the current replica is the leader of the scheduler
+type LeaderChecker interface {
+	IsLeader(ctx context.Context) error
+}
+
+// BackgroundJobService runs long-running operations outside of the requests that submit them.
+// Jobs are stored in the database, so clients can poll their status and the queue survives restarts.
+// With multiple replicas, only the leader of the scheduler runs jobs.
+type BackgroundJobService struct {
+	db *gorm.DB
+
+	handlersLock sync.RWMutex
+	handlers     map[string]BackgroundJobHandler
+
+	// Nil if every replica can run jobs
+	leader LeaderChecker
+
+	// Wakes up the worker when a new job is submitted
+	wakeup chan struct{}
+
+	// Allows overriding the heartbeat interval in tests
+	heartbeatInterval time.Duration
+}
+
+func NewBackgroundJobService(db *gorm.DB) *BackgroundJobService {
+	return &BackgroundJobService{
+		db:                db,
+		handlers:          make(map[string]BackgroundJobHandler),
+		wakeup:            make(chan struct{}, 1),
+		heartbeatInterval: backgroundJobHeartbeatInterval,
+	}
+}
+
+// SetLeaderChecker makes the worker run jobs only while the current replica is the leader; it must be called before Run
+func (s *BackgroundJobService) SetLeaderChecker(leader LeaderChecker) {
+	s.leader = leader
+}
+
+// RegisterHandler registers the handler that runs jobs of the given type
+func (s *BackgroundJobService) RegisterHandler(jobType string, handler BackgroundJobHandler) {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	s.handlers[jobType] = handler
+}
+
+func (s *BackgroundJobService) getHandler(jobType string) (BackgroundJobHandler, bool) {
+	s.handlersLock.RLock()
+	defer s.handlersLock.RUnlock()
+
+	handler, ok := s.handlers[jobType]
+	return handler, ok
+}
+
+// Submit queues a job of the given type; it's run by the worker as soon as the jobs submitted before it have completed
+func (s *BackgroundJobService) Submit(ctx context.Context, jobType string, payload any, actorUserID string) (model.BackgroundJob, error) {
+	if _, ok := s.getHandler(jobType); !ok {
+		return model.BackgroundJob{}, &common.BackgroundJobTypeUnknownError{Type: jobType}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return model.BackgroundJob{}, fmt.Errorf("failed to encode background job payload: %w", err)
+	}
+
+	job := model.BackgroundJob{
+		Type:    jobType,
+		Status:  model.BackgroundJobStatusQueued,
+		Payload: string(data),
+	}
+	if actorUserID != "" {
+		job.ActorUserID = &actorUserID
+	}
+
+	err = s.db.
+		WithContext(ctx).
+		Create(&job).
+		Error
+	if err != nil {
+		return model.BackgroundJob{}, fmt.Errorf("failed to store background job: %w", err)
+	}
+
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+
+	return job, nil
+}
+
+// List returns the jobs, without their payload and result
+func (s *BackgroundJobService) List(ctx context.Context, sortedPaginationRequest utils.SortedPaginationRequest) ([]model.BackgroundJob, utils.PaginationResponse, error) {
+	var jobs []model.BackgroundJob
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.BackgroundJob{}).
+		Omit("payload", "result")
+
+	pagination, err := utils.PaginateAndSort(sortedPaginationRequest, query, &jobs)
+	return jobs, pagination, err
+}
+
+// Get returns a job, including its result
+func (s *BackgroundJobService) Get(ctx context.Context, id string) (model.BackgroundJob, error) {
+	var job model.BackgroundJob
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ?", id).
+		First(&job).
+		Error
+	return job, err
+}
+
+// IsCancellable returns true if the job can be canceled in its current state
+func (s *BackgroundJobService) IsCancellable(job model.BackgroundJob) bool {
+	switch job.Status {
+	case model.BackgroundJobStatusQueued:
+		return true
+	case model.BackgroundJobStatusRunning:
+		handler, ok := s.getHandler(job.Type)
+		return ok && handler.Cancellable
+	default:
+		return false
+	}
+}
+
+// Cancel cancels a queued job right away, or asks the worker to stop a running job if its type is cancellable
+func (s *BackgroundJobService) Cancel(ctx context.Context, id string) (model.BackgroundJob, error) {
+	job, err := s.Get(ctx, id)
+	if err != nil {
+		return model.BackgroundJob{}, err
+	}
+	if !s.IsCancellable(job) {
+		return model.BackgroundJob{}, &common.BackgroundJobNotCancellableError{}
+	}
+
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.BackgroundJob{}).
+		Where("id = ? AND status = ?", job.ID, job.Status)
+
+	var res *gorm.DB
+	if job.Status == model.BackgroundJobStatusQueued {
+		res = query.Updates(map[string]any{
+			"status":           model.BackgroundJobStatusCanceled,
+			"cancel_requested": true,
+			"finished_at":      datatype.DateTime(time.Now()),
+		})
+	} else {
+		// The worker cancels the job with its next heartbeat
+		res = query.Update("cancel_requested", true)
+	}
+	if res.Error != nil {
+		return model.BackgroundJob{}, fmt.Errorf("failed to cancel background job: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		// The status of the job changed in the meantime, e.g. because the worker started it
+		return s.Cancel(ctx, id)
+	}
+
+	return s.Get(ctx, id)
+}
+
+// Run runs the submitted jobs until the context is canceled
+func (s *BackgroundJobService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(backgroundJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := s.ProcessPending(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "Failed to process background jobs", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.wakeup:
+		}
+	}
+}
+
+// ProcessPending runs the queued jobs one after the other and returns the number of jobs that were run.
+// Nothing is run if the current replica isn't the leader.
+func (s *BackgroundJobService) ProcessPending(ctx context.Context) (int, error) {
+	if !s.isLeader(ctx) {
+		return 0, nil
+	}
+
+	err := s.recoverInterrupted(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ran := 0
+	for ctx.Err() == nil && s.isLeader(ctx) {
+		job, ok, err := s.claimNext(ctx)
+		if err != nil {
+			return ran, err
+		}
+		if !ok {
+			return ran, nil
+		}
+
+		err = s.runJob(ctx, job)
+		if err != nil {
+			return ran, err
+		}
+		ran++
+	}
+
+	return ran, ctx.Err()
+}
+
+func (s *BackgroundJobService) isLeader(ctx context.Context) bool {
+	return s.leader == nil || s.leader.IsLeader(ctx) == nil
+}
+
+// recoverInterrupted finishes or queues again the jobs whose worker stopped while running them
+func (s *BackgroundJobService) recoverInterrupted(ctx context.Context) error {
+	var jobs []model.BackgroundJob
+	err := s.db.
+		WithContext(ctx).
+		Where("status = ? AND locked_until < ?", model.BackgroundJobStatusRunning, datatype.DateTime(time.Now())).
+		Find(&jobs).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load interrupted background jobs: %w", err)
+	}
+
+	for _, job := range jobs {
+		handler, ok := s.getHandler(job.Type)
+
+		switch {
+		case job.CancelRequested:
+			err = s.finish(ctx, job, model.BackgroundJobStatusCanceled, nil, nil)
+		case ok && handler.Resumable:
+			slog.WarnContext(ctx, "Resuming interrupted background job", slog.String("id", job.ID), slog.String("type", job.Type))
+			err = s.requeue(ctx, job)
+		default:
+			slog.WarnContext(ctx, "Background job was interrupted", slog.String("id", job.ID), slog.String("type", job.Type))
+			err = s.finish(ctx, job, model.BackgroundJobStatusFailed, nil, errors.New("the job was interrupted because the server stopped"))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// claimNext locks the oldest queued job, so other replicas don't run it too.
+// It returns false if there is no queued job.
+func (s *BackgroundJobService) claimNext(ctx context.Context) (model.BackgroundJob, bool, error) {
+	for {
+		var job model.BackgroundJob
+		err := s.db.
+			WithContext(ctx).
+			Where("status = ?", model.BackgroundJobStatusQueued).
+			Order("created_at ASC").
+			First(&job).
+			Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.BackgroundJob{}, false, nil
+		} else if err != nil {
+			return model.BackgroundJob{}, false, fmt.Errorf("failed to load queued background job: %w", err)
+		}
+
+		now := time.Now()
+		startedAt := datatype.DateTime(now)
+		lockedUntil := datatype.DateTime(now.Add(backgroundJobLockDuration))
+		res := s.db.
+			WithContext(ctx).
+			Model(&model.BackgroundJob{}).
+			Where("id = ? AND status = ?", job.ID, model.BackgroundJobStatusQueued).
+			Updates(map[string]any{
+				"status":       model.BackgroundJobStatusRunning,
+				"started_at":   startedAt,
+				"locked_until": lockedUntil,
+			})
+		if res.Error != nil {
+			return model.BackgroundJob{}, false, fmt.Errorf("failed to lock background job: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			// The job was claimed or canceled in the meantime
+			continue
+		}
+
+		job.Status = model.BackgroundJobStatusRunning
+		job.StartedAt = &startedAt
+		job.LockedUntil = &lockedUntil
+		return job, true, nil
+	}
+}
+
+// runJob runs a claimed job and stores its outcome
+func (s *BackgroundJobService) runJob(ctx context.Context, job model.BackgroundJob) error {
+	handler, ok := s.getHandler(job.Type)
+	if !ok {
+		return s.finish(ctx, job, model.BackgroundJobStatusFailed, nil, fmt.Errorf("no handler registered for background jobs of type '%s'", job.Type))
+	}
+
+	slog.InfoContext(ctx, "Starting background job", slog.String("id", job.ID), slog.String("type", job.Type))
+
+	jobCtx, cancelJob := context.WithCancel(ctx)
+	defer cancelJob()
+
+	// The progress can be reported after the handler returned, e.g. by a coalesced LDAP sync that reuses its context, which is ignored
+	var progressLock sync.Mutex
+	runFinished := false
+	progress := func(done, total int) {
+		progressLock.Lock()
+		if !runFinished {
+			job.ProgressDone, job.ProgressTotal = done, total
+		}
+		progressLock.Unlock()
+	}
+
+	// Save the progress and extend the lock periodically, and stop the job if it was canceled
+	heartbeatDone := make(chan struct{})
+	heartbeatStopped := make(chan struct{})
+	go func() {
+		defer close(heartbeatStopped)
+
+		ticker := time.NewTicker(s.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-heartbeatDone:
+				return
+			case <-ticker.C:
+			}
+
+			progressLock.Lock()
+			done, total := job.ProgressDone, job.ProgressTotal
+			progressLock.Unlock()
+
+			canceled, err := s.heartbeat(jobCtx, job.ID, done, total)
+			if err != nil {
+				slog.WarnContext(ctx, "Failed to update running background job", slog.String("id", job.ID), slog.Any("error", err))
+				continue
+			}
+			if canceled && handler.Cancellable {
+				cancelJob()
+			}
+		}
+	}()
+
+	result, runErr := handler.Run(jobCtx, []byte(job.Payload), progress)
+	progressLock.Lock()
+	runFinished = true
+	progressLock.Unlock()
+
+	close(heartbeatDone)
+	<-heartbeatStopped
+
+	// The outcome is saved even if the worker is stopping
+	saveCtx, cancelSave := context.WithTimeout(context.WithoutCancel(ctx), backgroundJobSaveTimeout)
+	defer cancelSave()
+
+	switch {
+	case runErr == nil:
+		slog.InfoContext(ctx, "Background job succeeded", slog.String("id", job.ID), slog.String("type", job.Type))
+		return s.finish(saveCtx, job, model.BackgroundJobStatusSucceeded, result, nil)
+	case ctx.Err() != nil && handler.Resumable:
+		slog.InfoContext(ctx, "Background job interrupted, it will be resumed", slog.String("id", job.ID), slog.String("type", job.Type))
+		return s.requeue(saveCtx, job)
+	case ctx.Err() != nil:
+		slog.WarnContext(ctx, "Background job interrupted", slog.String("id", job.ID), slog.String("type", job.Type))
+		return s.finish(saveCtx, job, model.BackgroundJobStatusFailed, nil, errors.New("the job was interrupted because the server stopped"))
+	case jobCtx.Err() != nil:
+		slog.InfoContext(ctx, "Background job canceled", slog.String("id", job.ID), slog.String("type", job.Type))
+		return s.finish(saveCtx, job, model.BackgroundJobStatusCanceled, nil, nil)
+	default:
+		slog.ErrorContext(ctx, "Background job failed", slog.String("id", job.ID), slog.String("type", job.Type), slog.Any("error", runErr))
+		return s.finish(saveCtx, job, model.BackgroundJobStatusFailed, nil, runErr)
+	}
+}
+
+// heartbeat extends the lock of a running job and saves its progress; it returns true if the job should be canceled
+func (s *BackgroundJobService) heartbeat(ctx context.Context, id string, done int, total int) (bool, error) {
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.BackgroundJob{}).
+		Where("id = ?", id).
+		Updates(map[string]any{
+			"locked_until":   datatype.DateTime(time.Now().Add(backgroundJobLockDuration)),
+			"progress_done":  done,
+			"progress_total": total,
+		}).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to update background job: %w", err)
+	}
+
+	var job model.BackgroundJob
+	err = s.db.
+		WithContext(ctx).
+		Select("cancel_requested").
+		Where("id = ?", id).
+		First(&job).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load background job: %w", err)
+	}
+
+	return job.CancelRequested, nil
+}
+
+// finish stores the outcome of a job
+func (s *BackgroundJobService) finish(ctx context.Context, job model.BackgroundJob, status model.BackgroundJobStatus, result any, jobErr error) error {
+	updates := map[string]any{
+		"status":         status,
+		"progress_done":  job.ProgressDone,
+		"progress_total": job.ProgressTotal,
+		"locked_until":   nil,
+		"finished_at":    datatype.DateTime(time.Now()),
+	}
+
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return s.finish(ctx, job, model.BackgroundJobStatusFailed, nil, fmt.Errorf("failed to encode result: %w", err))
+		}
+		updates["result"] = string(data)
+	}
+	if jobErr != nil {
+		updates["error"] = jobErr.Error()
+	}
+
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.BackgroundJob{}).
+		Where("id = ?", job.ID).
+		Updates(updates).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to save outcome of background job: %w", err)
+	}
+
+	return nil
+}
+
+// requeue queues a job again, so it's run from the beginning
+func (s *BackgroundJobService) requeue(ctx context.Context, job model.BackgroundJob) error {
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.BackgroundJob{}).
+		Where("id = ?", job.ID).
+		Updates(map[string]any{
+			"status":         model.BackgroundJobStatusQueued,
+			"progress_done":  0,
+			"progress_total": 0,
+			"locked_until":   nil,
+			"started_at":     nil,
+		}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to queue background job again: %w", err)
+	}
+
+	return nil
+}
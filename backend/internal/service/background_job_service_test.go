@@ -0,0 +1,234 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+type testLeaderChecker struct {
+	leader bool
+}
+
+func (c *testLeaderChecker) IsLeader(_ context.Context) error {
+	if !c.leader {
+		return errors.New("not the leader")
+	}
+	return nil
+}
+
+func TestBackgroundJobService(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := NewBackgroundJobService(db)
+	service.heartbeatInterval = 10 * time.Millisecond
+
+	type payload struct {
+		Value string `json:"value"`
+	}
+
+	service.RegisterHandler("echo", BackgroundJobHandler{
+		Run: func(ctx context.Context, data []byte, progress BackgroundJobProgressFunc) (any, error) {
+			var p payload
+			if err := json.Unmarshal(data, &p); err != nil {
+				return nil, err
+			}
+			progress(1, 1)
+			return p, nil
+		},
+	})
+	service.RegisterHandler("fail", BackgroundJobHandler{
+		Run: func(ctx context.Context, _ []byte, _ BackgroundJobProgressFunc) (any, error) {
+			return nil, errors.New("something went wrong")
+		},
+	})
+
+	t.Run("jobs of unknown types can't be submitted", func(t *testing.T) {
+		_, err := service.Submit(t.Context(), "unknown", nil, "")
+		var unknownErr *common.BackgroundJobTypeUnknownError
+		require.ErrorAs(t, err, &unknownErr)
+	})
+
+	t.Run("result of succeeded jobs is stored", func(t *testing.T) {
+		job, err := service.Submit(t.Context(), "echo", payload{Value: "hello"}, "")
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusQueued, job.Status)
+
+		count, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		job, err = service.Get(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusSucceeded, job.Status)
+		require.NotNil(t, job.Result)
+		assert.JSONEq(t, `{"value":"hello"}`, *job.Result)
+		assert.Equal(t, 1, job.ProgressDone)
+		assert.Equal(t, 1, job.ProgressTotal)
+		assert.NotNil(t, job.FinishedAt)
+		assert.Nil(t, job.LockedUntil)
+	})
+
+	t.Run("progress can be passed with the context", func(t *testing.T) {
+		service.RegisterHandler("contextProgress", BackgroundJobHandler{
+			Run: func(ctx context.Context, _ []byte, progress BackgroundJobProgressFunc) (any, error) {
+				ctx = WithBackgroundJobProgress(ctx, progress)
+				backgroundJobProgressFromContext(ctx)(2, 3)
+				return nil, nil
+			},
+		})
+
+		job, err := service.Submit(t.Context(), "contextProgress", nil, "")
+		require.NoError(t, err)
+		_, err = service.ProcessPending(t.Context())
+		require.NoError(t, err)
+
+		job, err = service.Get(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 2, job.ProgressDone)
+		assert.Equal(t, 3, job.ProgressTotal)
+
+		// Operations outside of jobs can report progress too
+		backgroundJobProgressFromContext(t.Context())(1, 1)
+	})
+
+	t.Run("error of failed jobs is stored", func(t *testing.T) {
+		job, err := service.Submit(t.Context(), "fail", nil, "")
+		require.NoError(t, err)
+
+		_, err = service.ProcessPending(t.Context())
+		require.NoError(t, err)
+
+		job, err = service.Get(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusFailed, job.Status)
+		require.NotNil(t, job.Error)
+		assert.Equal(t, "something went wrong", *job.Error)
+	})
+
+	t.Run("queued jobs can be canceled", func(t *testing.T) {
+		job, err := service.Submit(t.Context(), "echo", payload{}, "")
+		require.NoError(t, err)
+
+		job, err = service.Cancel(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusCanceled, job.Status)
+
+		count, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		// Finished jobs can't be canceled
+		_, err = service.Cancel(t.Context(), job.ID)
+		var notCancellableErr *common.BackgroundJobNotCancellableError
+		require.ErrorAs(t, err, &notCancellableErr)
+	})
+
+	t.Run("running jobs are canceled if their type is cancellable", func(t *testing.T) {
+		started := make(chan struct{})
+		service.RegisterHandler("wait", BackgroundJobHandler{
+			Run: func(ctx context.Context, _ []byte, _ BackgroundJobProgressFunc) (any, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			},
+			Cancellable: true,
+		})
+
+		job, err := service.Submit(t.Context(), "wait", nil, "")
+		require.NoError(t, err)
+
+		processed := make(chan error, 1)
+		go func() {
+			_, err := service.ProcessPending(t.Context())
+			processed <- err
+		}()
+
+		<-started
+		_, err = service.Cancel(t.Context(), job.ID)
+		require.NoError(t, err)
+
+		select {
+		case err := <-processed:
+			require.NoError(t, err)
+		case <-time.After(5 * time.Second):
+			t.Fatal("job was not canceled")
+		}
+
+		job, err = service.Get(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusCanceled, job.Status)
+	})
+
+	t.Run("running jobs can't be canceled if their type isn't cancellable", func(t *testing.T) {
+		job := model.BackgroundJob{Type: "echo", Status: model.BackgroundJobStatusRunning, Payload: "null"}
+		require.NoError(t, db.Create(&job).Error)
+
+		_, err := service.Cancel(t.Context(), job.ID)
+		var notCancellableErr *common.BackgroundJobNotCancellableError
+		require.ErrorAs(t, err, &notCancellableErr)
+
+		require.NoError(t, db.Delete(&job).Error)
+	})
+
+	t.Run("interrupted jobs are resumed or marked as failed", func(t *testing.T) {
+		resumed := 0
+		service.RegisterHandler("resumable", BackgroundJobHandler{
+			Run: func(ctx context.Context, _ []byte, _ BackgroundJobProgressFunc) (any, error) {
+				resumed++
+				return nil, nil
+			},
+			Resumable: true,
+		})
+
+		// The worker that was running the jobs stopped, so their locks expired
+		expired := datatype.DateTime(time.Now().Add(-time.Minute))
+		resumable := model.BackgroundJob{Type: "resumable", Status: model.BackgroundJobStatusRunning, Payload: "null", LockedUntil: &expired}
+		interrupted := model.BackgroundJob{Type: "echo", Status: model.BackgroundJobStatusRunning, Payload: "null", LockedUntil: &expired}
+		require.NoError(t, db.Create(&resumable).Error)
+		require.NoError(t, db.Create(&interrupted).Error)
+
+		_, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, resumed)
+
+		job, err := service.Get(t.Context(), resumable.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusSucceeded, job.Status)
+
+		job, err = service.Get(t.Context(), interrupted.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusFailed, job.Status)
+	})
+
+	t.Run("jobs are only run by the leader", func(t *testing.T) {
+		leader := &testLeaderChecker{}
+		service.SetLeaderChecker(leader)
+		defer service.SetLeaderChecker(nil)
+
+		job, err := service.Submit(t.Context(), "echo", payload{}, "")
+		require.NoError(t, err)
+
+		count, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		leader.leader = true
+		count, err = service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+
+		job, err = service.Get(t.Context(), job.ID)
+		require.NoError(t, err)
+		assert.Equal(t, model.BackgroundJobStatusSucceeded, job.Status)
+	})
+}
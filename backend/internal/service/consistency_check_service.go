@@ -0,0 +1,201 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// ConsistencyCheckService finds data that references users or OIDC clients that don't exist anymore
+type ConsistencyCheckService struct {
+	db *gorm.DB
+}
+
+func NewConsistencyCheckService(db *gorm.DB) *ConsistencyCheckService {
+	return &ConsistencyCheckService{db: db}
+}
+
+// orphanedRowsCheck describes rows of a table that reference a user or OIDC client that doesn't exist
+type orphanedRowsCheck struct {
+	category string
+	model    any
+	where    string
+}
+
+var orphanedRowsChecks = []orphanedRowsCheck{
+	{
+		category: "authorizedOidcClients",
+		model:    &model.UserAuthorizedOidcClient{},
+		where:    "user_id NOT IN (SELECT id FROM users) OR client_id NOT IN (SELECT id FROM oidc_clients)",
+	},
+	{
+		category: "oidcRefreshTokens",
+		model:    &model.OidcRefreshToken{},
+		where:    "user_id NOT IN (SELECT id FROM users) OR client_id NOT IN (SELECT id FROM oidc_clients)",
+	},
+	{
+		category: "oidcAuthorizationCodes",
+		model:    &model.OidcAuthorizationCode{},
+		where:    "user_id NOT IN (SELECT id FROM users) OR client_id NOT IN (SELECT id FROM oidc_clients)",
+	},
+}
+
+// Check looks for orphaned data and returns the number of orphans per category.
+// If cleanup is true, the orphans are deleted after they have been counted.
+func (s *ConsistencyCheckService) Check(ctx context.Context, cleanup bool) (dto.ConsistencyCheckReportDto, error) {
+	report := dto.ConsistencyCheckReportDto{
+		Cleaned:    cleanup,
+		Categories: make([]dto.ConsistencyCheckCategoryDto, 0, len(orphanedRowsChecks)+2),
+	}
+
+	for _, check := range orphanedRowsChecks {
+		var count int64
+		err := s.db.
+			WithContext(ctx).
+			Model(check.model).
+			Where(check.where).
+			Count(&count).
+			Error
+		if err != nil {
+			return report, fmt.Errorf("failed to count orphaned %s: %w", check.category, err)
+		}
+		report.Categories = append(report.Categories, dto.ConsistencyCheckCategoryDto{Name: check.category, Count: count})
+	}
+
+	profilePictures, err := s.findOrphanedFiles(ctx, "profile-pictures", &model.User{})
+	if err != nil {
+		return report, err
+	}
+	report.Categories = append(report.Categories, dto.ConsistencyCheckCategoryDto{Name: "profilePictures", Count: int64(len(profilePictures))})
+
+	// Only client logos in the local upload directory are checked, not the ones stored in S3
+	clientImages, err := s.findOrphanedFiles(ctx, "oidc-client-images", &model.OidcClient{})
+	if err != nil {
+		return report, err
+	}
+	report.Categories = append(report.Categories, dto.ConsistencyCheckCategoryDto{Name: "oidcClientImages", Count: int64(len(clientImages))})
+
+	// Report the counts before anything is deleted
+	for _, category := range report.Categories {
+		if category.Count > 0 {
+			slog.InfoContext(ctx, "Found orphaned data", slog.String("category", category.Name), slog.Int64("count", category.Count))
+		}
+	}
+
+	if !cleanup {
+		return report, nil
+	}
+
+	err = s.deleteOrphanedRows(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	return report, deleteOrphanedFiles(append(profilePictures, clientImages...))
+}
+
+// CleanOrphanedRows deletes the rows that reference users or OIDC clients that don't exist anymore
+func (s *ConsistencyCheckService) CleanOrphanedRows(ctx context.Context) error {
+	return s.deleteOrphanedRows(ctx)
+}
+
+// CleanOrphanedFiles deletes the files in the local upload directory that belong to users or OIDC clients that don't exist anymore.
+// Every replica has its own upload directory, so it must be run on each of them.
+func (s *ConsistencyCheckService) CleanOrphanedFiles(ctx context.Context) error {
+	profilePictures, err := s.findOrphanedFiles(ctx, "profile-pictures", &model.User{})
+	if err != nil {
+		return err
+	}
+	clientImages, err := s.findOrphanedFiles(ctx, "oidc-client-images", &model.OidcClient{})
+	if err != nil {
+		return err
+	}
+
+	paths := slices.Concat(profilePictures, clientImages)
+	if len(paths) > 0 {
+		slog.InfoContext(ctx, "Deleting orphaned files", slog.Int("count", len(paths)))
+	}
+
+	return deleteOrphanedFiles(paths)
+}
+
+func (s *ConsistencyCheckService) deleteOrphanedRows(ctx context.Context) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, check := range orphanedRowsChecks {
+			err := tx.Where(check.where).Delete(check.model).Error
+			if err != nil {
+				return fmt.Errorf("failed to delete orphaned %s: %w", check.category, err)
+			}
+		}
+		return nil
+	})
+}
+
+func deleteOrphanedFiles(paths []string) error {
+	var errs []error
+	for _, path := range paths {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to delete orphaned file '%s': %w", path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// findOrphanedFiles returns the paths of the files in the upload directory that are named after the ID of a row that doesn't exist
+func (s *ConsistencyCheckService) findOrphanedFiles(ctx context.Context, dirName string, owner any) ([]string, error) {
+	dir := filepath.Join(common.EnvConfig.UploadPath, dirName)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read directory '%s': %w", dir, err)
+	}
+
+	// Only consider files named after a UUID, so unrelated files are never deleted
+	filesByID := make(map[string][]string)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), filepath.Ext(entry.Name()))
+		if uuid.Validate(id) != nil {
+			continue
+		}
+		filesByID[id] = append(filesByID[id], filepath.Join(dir, entry.Name()))
+	}
+	if len(filesByID) == 0 {
+		return nil, nil
+	}
+
+	var existingIDs []string
+	err = s.db.
+		WithContext(ctx).
+		Model(owner).
+		Pluck("id", &existingIDs).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load existing IDs: %w", err)
+	}
+	for _, id := range existingIDs {
+		delete(filesByID, id)
+	}
+
+	orphans := make([]string, 0, len(filesByID))
+	for _, paths := range filesByID {
+		orphans = append(orphans, paths...)
+	}
+	return orphans, nil
+}
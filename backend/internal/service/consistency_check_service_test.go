@@ -0,0 +1,124 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestConsistencyCheckService_Check(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	originalUploadPath := common.EnvConfig.UploadPath
+	common.EnvConfig.UploadPath = t.TempDir()
+	t.Cleanup(func() {
+		common.EnvConfig.UploadPath = originalUploadPath
+	})
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: user.ID}
+	require.NoError(t, db.Create(&client).Error)
+
+	deletedID := uuid.NewString()
+	require.NoError(t, db.Create(&[]model.UserAuthorizedOidcClient{
+		{UserID: user.ID, ClientID: client.ID},
+		{UserID: user.ID, ClientID: deletedID},
+	}).Error)
+	require.NoError(t, db.Create(&[]model.OidcRefreshToken{
+		{Token: "valid", UserID: user.ID, ClientID: client.ID},
+		{Token: "orphan", UserID: deletedID, ClientID: client.ID},
+	}).Error)
+
+	profilePicturesDir := filepath.Join(common.EnvConfig.UploadPath, "profile-pictures")
+	require.NoError(t, os.MkdirAll(profilePicturesDir, 0o700))
+	for _, name := range []string{user.ID + ".png", deletedID + ".png", "not-a-uuid.png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(profilePicturesDir, name), []byte("png"), 0o600))
+	}
+
+	service := NewConsistencyCheckService(db)
+	expected := []dto.ConsistencyCheckCategoryDto{
+		{Name: "authorizedOidcClients", Count: 1},
+		{Name: "oidcRefreshTokens", Count: 1},
+		{Name: "oidcAuthorizationCodes", Count: 0},
+		{Name: "profilePictures", Count: 1},
+		{Name: "oidcClientImages", Count: 0},
+	}
+
+	// Checking doesn't delete anything
+	report, err := service.Check(t.Context(), false)
+	require.NoError(t, err)
+	assert.False(t, report.Cleaned)
+	assert.Equal(t, expected, report.Categories)
+
+	report, err = service.Check(t.Context(), false)
+	require.NoError(t, err)
+	assert.Equal(t, expected, report.Categories)
+
+	// Cleaning reports the counts, then deletes the orphans
+	report, err = service.Check(t.Context(), true)
+	require.NoError(t, err)
+	assert.True(t, report.Cleaned)
+	assert.Equal(t, expected, report.Categories)
+
+	report, err = service.Check(t.Context(), false)
+	require.NoError(t, err)
+	for _, category := range report.Categories {
+		assert.Zero(t, category.Count, category.Name)
+	}
+
+	var refreshTokens []model.OidcRefreshToken
+	require.NoError(t, db.Find(&refreshTokens).Error)
+	require.Len(t, refreshTokens, 1)
+	assert.Equal(t, "valid", refreshTokens[0].Token)
+
+	assert.FileExists(t, filepath.Join(profilePicturesDir, user.ID+".png"))
+	assert.FileExists(t, filepath.Join(profilePicturesDir, "not-a-uuid.png"))
+	assert.NoFileExists(t, filepath.Join(profilePicturesDir, deletedID+".png"))
+}
+
+func TestConsistencyCheckService_CleanOrphaned(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	originalUploadPath := common.EnvConfig.UploadPath
+	common.EnvConfig.UploadPath = t.TempDir()
+	t.Cleanup(func() {
+		common.EnvConfig.UploadPath = originalUploadPath
+	})
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: user.ID}
+	require.NoError(t, db.Create(&client).Error)
+
+	deletedID := uuid.NewString()
+	require.NoError(t, db.Create(&model.OidcRefreshToken{Token: "orphan", UserID: deletedID, ClientID: client.ID}).Error)
+
+	profilePicturesDir := filepath.Join(common.EnvConfig.UploadPath, "profile-pictures")
+	require.NoError(t, os.MkdirAll(profilePicturesDir, 0o700))
+	for _, name := range []string{user.ID + ".png", deletedID + ".png"} {
+		require.NoError(t, os.WriteFile(filepath.Join(profilePicturesDir, name), []byte("png"), 0o600))
+	}
+
+	service := NewConsistencyCheckService(db)
+
+	// The rows are cleaned by the leader, and the files by every replica
+	require.NoError(t, service.CleanOrphanedRows(t.Context()))
+	var count int64
+	require.NoError(t, db.Model(&model.OidcRefreshToken{}).Count(&count).Error)
+	assert.Zero(t, count)
+	assert.FileExists(t, filepath.Join(profilePicturesDir, deletedID+".png"))
+
+	require.NoError(t, service.CleanOrphanedFiles(t.Context()))
+	assert.FileExists(t, filepath.Join(profilePicturesDir, user.ID+".png"))
+	assert.NoFileExists(t, filepath.Join(profilePicturesDir, deletedID+".png"))
+}
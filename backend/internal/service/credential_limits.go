@@ -0,0 +1,109 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// credentialLimit returns the maximum number of credentials of a kind the user can have, or 0 if there's no limit.
+// The limit set for the user takes precedence over the one of the app config, also for admins exempt from the latter.
+// A limit of the user below 1 is ignored, so it can't be mistaken for lifting the limit of the app config.
+func credentialLimit(cfg *model.AppConfig, user model.User, userLimit *int, globalLimit model.AppConfigVariable) int {
+	if userLimit != nil && *userLimit > 0 {
+		return *userLimit
+	}
+
+	if user.IsAdmin && cfg.CredentialLimitsExemptAdmins.IsTrue() {
+		return 0
+	}
+
+	limit, _ := strconv.Atoi(globalLimit.Value)
+	return max(limit, 0)
+}
+
+func countActiveApiKeysInternal(ctx context.Context, userID string, tx *gorm.DB) (int64, error) {
+	var count int64
+	err := tx.
+		WithContext(ctx).
+		Model(&model.ApiKey{}).
+		Where("user_id = ? AND expires_at > ?", userID, datatype.DateTime(time.Now())).
+		Count(&count).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count API keys: %w", err)
+	}
+	return count, nil
+}
+
+func countPasskeysInternal(ctx context.Context, userID string, tx *gorm.DB) (int64, error) {
+	var count int64
+	err := tx.
+		WithContext(ctx).
+		Model(&model.WebauthnCredential{}).
+		Where("user_id = ?", userID).
+		Count(&count).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to count passkeys: %w", err)
+	}
+	return count, nil
+}
+
+// GetCredentialCounts returns the number of active API keys and passkeys of the user
+func (s *UserService) GetCredentialCounts(ctx context.Context, userID string) (apiKeys int64, passkeys int64, err error) {
+	apiKeys, err = countActiveApiKeysInternal(ctx, userID, s.db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	passkeys, err = countPasskeysInternal(ctx, userID, s.db)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return apiKeys, passkeys, nil
+}
+
+// UpdateCredentialLimits sets the limits of the number of API keys and passkeys of the user, overriding the ones of the app config.
+// Credentials the user already has are kept even if they exceed the new limits.
+func (s *UserService) UpdateCredentialLimits(ctx context.Context, userID string, input dto.UserCredentialLimitsDto) (model.User, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	user, err := s.getUserInternal(ctx, userID, tx)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{
+			"max_api_keys": input.MaxApiKeys,
+			"max_passkeys": input.MaxPasskeys,
+		}).
+		Error
+	if err != nil {
+		return model.User{}, fmt.Errorf("failed to update user: %w", err)
+	}
+	user.MaxApiKeys = input.MaxApiKeys
+	user.MaxPasskeys = input.MaxPasskeys
+
+	err = tx.Commit().Error
+	if err != nil {
+		return model.User{}, err
+	}
+
+	return user, nil
+}
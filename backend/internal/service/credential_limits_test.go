@@ -0,0 +1,92 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestCredentialLimit(t *testing.T) {
+	cfg := &model.AppConfig{
+		MaxApiKeysPerUser:            model.AppConfigVariable{Value: "5"},
+		CredentialLimitsExemptAdmins: model.AppConfigVariable{Value: "true"},
+	}
+
+	t.Run("uses the limit of the app config", func(t *testing.T) {
+		assert.Equal(t, 5, credentialLimit(cfg, model.User{}, nil, cfg.MaxApiKeysPerUser))
+	})
+
+	t.Run("uses the limit of the user", func(t *testing.T) {
+		assert.Equal(t, 10, credentialLimit(cfg, model.User{}, utils.Ptr(10), cfg.MaxApiKeysPerUser))
+		assert.Equal(t, 2, credentialLimit(cfg, model.User{IsAdmin: true}, utils.Ptr(2), cfg.MaxApiKeysPerUser))
+	})
+
+	t.Run("ignores a limit of 0 of the user", func(t *testing.T) {
+		assert.Equal(t, 5, credentialLimit(cfg, model.User{}, utils.Ptr(0), cfg.MaxApiKeysPerUser))
+	})
+
+	t.Run("exempts admins", func(t *testing.T) {
+		assert.Equal(t, 0, credentialLimit(cfg, model.User{IsAdmin: true}, nil, cfg.MaxApiKeysPerUser))
+	})
+
+	t.Run("has no limit if not configured", func(t *testing.T) {
+		assert.Equal(t, 0, credentialLimit(cfg, model.User{}, nil, cfg.MaxPasskeysPerUser))
+	})
+}
+
+func TestApiKeyService_CreateApiKeyLimit(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{
+		MaxApiKeysPerUser: model.AppConfigVariable{Value: "2"},
+	})
+	s := NewApiKeyService(db, nil, &AuditLogService{db: db, geoliteService: &GeoLiteService{}}, appConfig)
+	userService := &UserService{db: db}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createApiKey := func() error {
+		_, _, err := s.CreateApiKey(t.Context(), user.ID, dto.ApiKeyCreateDto{
+			Name:      "Key",
+			ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+		})
+		return err
+	}
+
+	// Expired keys don't count towards the limit
+	require.NoError(t, db.Create(&model.ApiKey{
+		Name:      "Expired key",
+		Key:       "expired",
+		ExpiresAt: datatype.DateTime(time.Now().Add(-time.Hour)),
+		UserID:    user.ID,
+	}).Error)
+
+	require.NoError(t, createApiKey())
+	require.NoError(t, createApiKey())
+
+	err := createApiKey()
+	var limitErr *common.APIKeyLimitReachedError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Equal(t, 2, limitErr.Limit)
+
+	apiKeys, passkeys, err := userService.GetCredentialCounts(t.Context(), user.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, apiKeys)
+	assert.EqualValues(t, 0, passkeys)
+
+	// The limit of the user overrides the one of the app config
+	_, err = userService.UpdateCredentialLimits(t.Context(), user.ID, dto.UserCredentialLimitsDto{MaxApiKeys: utils.Ptr(3)})
+	require.NoError(t, err)
+	require.NoError(t, createApiKey())
+	require.ErrorAs(t, createApiKey(), &limitErr)
+	assert.Equal(t, 3, limitErr.Limit)
+}
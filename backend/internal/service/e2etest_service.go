@@ -167,6 +167,7 @@ func (s *TestService) SeedDatabase(baseURL string) error {
 				Name:         "Immich",
 				Secret:       "$2a$10$Ak.FP8riD1ssy2AGGbG.gOpnp/rBpymd74j0nxNMtW0GG1Lb4gzxe", // PYjrE9u4v9GVqXKi52eur0eb2Ci4kc0x
 				CallbackURLs: model.UrlList{"http://immich/auth/callback"},
+				GrantTypes:   model.StringList{GrantTypeAuthorizationCode, GrantTypeRefreshToken, GrantTypeDeviceCode},
 				CreatedByID:  users[1].ID,
 				AllowedUserGroups: []model.UserGroup{
 					userGroups[1],
@@ -478,7 +479,8 @@ func (s *TestService) getCborPublicKey(base64PublicKey string) ([]byte, error) {
 
 // SyncLdap triggers an LDAP synchronization
 func (s *TestService) SyncLdap(ctx context.Context) error {
-	return s.ldapService.SyncAll(ctx)
+	_, err := s.ldapService.SyncAll(ctx)
+	return err
 }
 
 // SetLdapTestConfig writes the test LDAP config variables directly to the database.
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	htemplate "html/template"
 	"io"
+	"log/slog"
 	"mime/multipart"
 	"mime/quotedprintable"
 	"net/textproto"
@@ -24,6 +25,7 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/secrets"
 )
 
 type EmailService struct {
@@ -31,9 +33,10 @@ type EmailService struct {
 	db               *gorm.DB
 	htmlTemplates    map[string]*htemplate.Template
 	textTemplates    map[string]*ttemplate.Template
+	secretsProvider  secrets.Provider
 }
 
-func NewEmailService(db *gorm.DB, appConfigService *AppConfigService) (*EmailService, error) {
+func NewEmailService(db *gorm.DB, appConfigService *AppConfigService, secretsProvider secrets.Provider) (*EmailService, error) {
 	htmlTemplates, err := email.PrepareHTMLTemplates(emailTemplatesPaths)
 	if err != nil {
 		return nil, fmt.Errorf("prepare html templates: %w", err)
@@ -49,6 +52,7 @@ func NewEmailService(db *gorm.DB, appConfigService *AppConfigService) (*EmailSer
 		db:               db,
 		htmlTemplates:    htmlTemplates,
 		textTemplates:    textTemplates,
+		secretsProvider:  secretsProvider,
 	}, nil
 }
 
@@ -64,32 +68,62 @@ func (srv *EmailService) SendTestEmail(ctx context.Context, recipientUserId stri
 
 	return SendEmail(ctx, srv,
 		email.Address{
-			Email: user.Email,
-			Name:  user.FullName(),
+			Email:  user.Email,
+			Name:   user.FullName(),
+			Locale: user.Locale,
 		}, TestTemplate, nil)
 }
 
+// VerifyTransport checks that the SMTP server can be reached and accepts the credentials, without sending an email
+func (srv *EmailService) VerifyTransport(ctx context.Context) error {
+	client, err := srv.getSmtpClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.Quit()
+}
+
 func SendEmail[V any](ctx context.Context, srv *EmailService, toEmail email.Address, template email.Template[V], tData *V) error {
 	dbConfig := srv.appConfigService.GetDbConfig()
 
+	// Recipients who haven't selected a locale get the emails in the default locale
+	locale := dbConfig.LocaleFor(toEmail.Locale)
+	appName := dbConfig.AppNameForLocale(&locale)
+
 	data := &email.TemplateData[V]{
-		AppName: dbConfig.AppName.Value,
+		AppName: appName,
 		LogoURL: common.EnvConfig.AppURL + "/api/application-configuration/logo",
 		Data:    tData,
 	}
 
+	if template.Notification != "" {
+		enabled, err := srv.isNotificationEnabled(ctx, toEmail.UserID, template.Notification)
+		if err != nil {
+			return err
+		}
+		if !enabled {
+			slog.DebugContext(ctx, "Not sending notification email because the user unsubscribed from it", slog.String("notification", string(template.Notification)), slog.String("user", toEmail.UserID))
+			return nil
+		}
+	}
+
 	body, boundary, err := prepareBody(srv, template, data)
 	if err != nil {
 		return fmt.Errorf("prepare email body for '%s': %w", template.Path, err)
 	}
 
+	// The same address is used for the envelope and the header, so SPF and DKIM checks are aligned with the visible sender
+	fromAddress := senderAddress(dbConfig, template.Purpose)
+
 	// Construct the email message
 	c := email.NewComposer()
 	c.AddHeader("Subject", template.Title(data))
 	c.AddAddressHeader("From", []email.Address{
 		{
-			Email: dbConfig.SmtpFrom.Value,
-			Name:  dbConfig.AppName.Value,
+			Email: fromAddress,
+			Name:  appName,
 		},
 	})
 	c.AddAddressHeader("To", []email.Address{toEmail})
@@ -104,7 +138,6 @@ func SendEmail[V any](ctx context.Context, srv *EmailService, toEmail email.Addr
 	// so we use the domain of the from address instead (the same as Thunderbird does)
 	// if the address does not have an @ (which would be unusual), we use hostname
 
-	fromAddress := dbConfig.SmtpFrom.Value
 	domain := ""
 	if strings.Contains(fromAddress, "@") {
 		domain = strings.Split(fromAddress, "@")[1]
@@ -119,6 +152,20 @@ func SendEmail[V any](ctx context.Context, srv *EmailService, toEmail email.Addr
 	}
 	c.AddHeader("Message-ID", "<"+uuid.New().String()+"@"+domain+">")
 
+	if template.Notification != "" {
+		if dbConfig.SmtpReplyToNotifications.Value != "" {
+			c.AddHeaderRaw("Reply-To", "<"+dbConfig.SmtpReplyToNotifications.Value+">")
+		}
+
+		// One-click unsubscribe as defined in RFC 8058, so mail clients can show an unsubscribe button
+		if !isNotificationMandatory(dbConfig, template.Notification) {
+			token := srv.unsubscribeToken(toEmail.UserID, template.Notification)
+			// The URL is added as raw header because AddHeader would encode it if the line is long
+			c.AddHeaderRaw("List-Unsubscribe", "<"+common.EnvConfig.AppURL+"/api/email/unsubscribe?token="+token+">")
+			c.AddHeader("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+		}
+	}
+
 	c.Body(body)
 
 	// Check if the context is still valid before attemtping to connect
@@ -131,7 +178,7 @@ func SendEmail[V any](ctx context.Context, srv *EmailService, toEmail email.Addr
 	}
 
 	// Connect to the SMTP server
-	client, err := srv.getSmtpClient()
+	client, err := srv.getSmtpClient(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to connect to SMTP server: %w", err)
 	}
@@ -146,14 +193,32 @@ func SendEmail[V any](ctx context.Context, srv *EmailService, toEmail email.Addr
 	}
 
 	// Send the email
-	if err := srv.sendEmailContent(client, toEmail, c); err != nil {
+	if err := srv.sendEmailContent(client, fromAddress, toEmail, c); err != nil {
 		return fmt.Errorf("send email content: %w", err)
 	}
 
 	return nil
 }
 
-func (srv *EmailService) getSmtpClient() (client *smtp.Client, err error) {
+// senderAddress returns the sender address configured for the purpose of an email, or the default sender address
+func senderAddress(dbConfig *model.AppConfig, purpose email.Purpose) string {
+	var from string
+	switch purpose {
+	case email.PurposeOnboarding:
+		from = dbConfig.SmtpFromOnboarding.Value
+	case email.PurposeSecurity:
+		from = dbConfig.SmtpFromSecurity.Value
+	case email.PurposeNotification:
+		from = dbConfig.SmtpFromNotifications.Value
+	}
+
+	if from == "" {
+		return dbConfig.SmtpFrom.Value
+	}
+	return from
+}
+
+func (srv *EmailService) getSmtpClient(ctx context.Context) (client *smtp.Client, err error) {
 	dbConfig := srv.appConfigService.GetDbConfig()
 
 	port := dbConfig.SmtpPort.Value
@@ -191,7 +256,10 @@ func (srv *EmailService) getSmtpClient() (client *smtp.Client, err error) {
 
 	// Set up the authentication if user or password are set
 	smtpUser := dbConfig.SmtpUser.Value
-	smtpPassword := dbConfig.SmtpPassword.Value
+	smtpPassword, err := secrets.Resolve(ctx, srv.secretsProvider, secrets.SmtpPassword, dbConfig.SmtpPassword.Value)
+	if err != nil {
+		return nil, err
+	}
 
 	if smtpUser != "" || smtpPassword != "" {
 		// Authenticate with plain auth
@@ -225,9 +293,9 @@ func (srv *EmailService) sendHelloCommand(client *smtp.Client) error {
 	return nil
 }
 
-func (srv *EmailService) sendEmailContent(client *smtp.Client, toEmail email.Address, c *email.Composer) error {
+func (srv *EmailService) sendEmailContent(client *smtp.Client, fromAddress string, toEmail email.Address, c *email.Composer) error {
 	// Set the sender
-	if err := client.Mail(srv.appConfigService.GetDbConfig().SmtpFrom.Value, nil); err != nil {
+	if err := client.Mail(fromAddress, nil); err != nil {
 		return fmt.Errorf("failed to set sender: %w", err)
 	}
 
@@ -0,0 +1,213 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+)
+
+// Values of the app config settings with the default preference of a notification
+const (
+	notificationUserDefaultEnabled   = "enabled"
+	notificationUserDefaultDisabled  = "disabled"
+	notificationUserDefaultMandatory = "mandatory"
+)
+
+// notificationSettings returns whether the notification is sent at all, and the preference of users who haven't chosen one
+func notificationSettings(dbConfig *model.AppConfig, notification email.Notification) (sent bool, userDefault string) {
+	switch notification {
+	case email.NotificationNewLogin:
+		return dbConfig.EmailLoginNotificationEnabled.IsTrue(), dbConfig.EmailLoginNotificationUserDefault.Value
+	case email.NotificationApiKeyExpiration:
+		return dbConfig.EmailApiKeyExpirationEnabled.IsTrue(), dbConfig.EmailApiKeyExpirationUserDefault.Value
+	default:
+		return false, ""
+	}
+}
+
+func isNotificationMandatory(dbConfig *model.AppConfig, notification email.Notification) bool {
+	_, userDefault := notificationSettings(dbConfig, notification)
+	return userDefault == notificationUserDefaultMandatory
+}
+
+// unsubscribeToken creates the token of the unsubscribe link of a notification email.
+// The token is signed instead of being stored, so it stays valid for every email that has been sent to the user.
+func (srv *EmailService) unsubscribeToken(userID string, notification email.Notification) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(userID + ":" + string(notification)))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(srv.unsubscribeSignature(payload))
+}
+
+func (srv *EmailService) unsubscribeSignature(payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(srv.appConfigService.GetDbConfig().EmailUnsubscribeKey.Value))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// parseUnsubscribeToken verifies the signature of an unsubscribe token and returns the user and notification it's for
+func (srv *EmailService) parseUnsubscribeToken(token string) (userID string, notification email.Notification, err error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", "", &common.TokenInvalidError{}
+	}
+
+	signatureBytes, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(signatureBytes, srv.unsubscribeSignature(payload)) {
+		return "", "", &common.TokenInvalidError{}
+	}
+
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", &common.TokenInvalidError{}
+	}
+
+	userID, notificationStr, ok := strings.Cut(string(payloadBytes), ":")
+	if !ok || userID == "" || !slices.Contains(email.Notifications, email.Notification(notificationStr)) {
+		return "", "", &common.TokenInvalidError{}
+	}
+
+	return userID, email.Notification(notificationStr), nil
+}
+
+// Unsubscribe disables the notification of the unsubscribe token for its user
+func (srv *EmailService) Unsubscribe(ctx context.Context, token string) error {
+	userID, notification, err := srv.parseUnsubscribeToken(token)
+	if err != nil {
+		return err
+	}
+
+	// The notification may have become mandatory after the email was sent
+	if isNotificationMandatory(srv.appConfigService.GetDbConfig(), notification) {
+		return &common.NotificationMandatoryError{}
+	}
+
+	return srv.saveNotificationPreference(ctx, srv.db, userID, notification, false)
+}
+
+// isNotificationEnabled returns whether the user receives the notification, according to their preference or the default one
+func (srv *EmailService) isNotificationEnabled(ctx context.Context, userID string, notification email.Notification) (bool, error) {
+	_, userDefault := notificationSettings(srv.appConfigService.GetDbConfig(), notification)
+	if userDefault == notificationUserDefaultMandatory || userID == "" {
+		return true, nil
+	}
+
+	var preferences []model.UserNotificationPreference
+	err := srv.db.
+		WithContext(ctx).
+		Where("user_id = ? AND notification = ?", userID, string(notification)).
+		Limit(1).
+		Find(&preferences).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to load notification preference: %w", err)
+	}
+
+	if len(preferences) == 0 {
+		return userDefault != notificationUserDefaultDisabled, nil
+	}
+
+	return preferences[0].Enabled, nil
+}
+
+// GetNotificationPreferences returns whether the user receives each kind of notification email.
+// Notifications that are disabled for all users aren't included.
+func (srv *EmailService) GetNotificationPreferences(ctx context.Context, userID string) ([]dto.NotificationPreferenceDto, error) {
+	var preferences []model.UserNotificationPreference
+	err := srv.db.
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Find(&preferences).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load notification preferences: %w", err)
+	}
+
+	dbConfig := srv.appConfigService.GetDbConfig()
+	result := make([]dto.NotificationPreferenceDto, 0, len(email.Notifications))
+	for _, notification := range email.Notifications {
+		sent, userDefault := notificationSettings(dbConfig, notification)
+		if !sent {
+			continue
+		}
+
+		preferenceDto := dto.NotificationPreferenceDto{
+			Notification: string(notification),
+			Enabled:      userDefault != notificationUserDefaultDisabled,
+			Mandatory:    userDefault == notificationUserDefaultMandatory,
+		}
+		if !preferenceDto.Mandatory {
+			for _, preference := range preferences {
+				if preference.Notification == string(notification) {
+					preferenceDto.Enabled = preference.Enabled
+				}
+			}
+		}
+		result = append(result, preferenceDto)
+	}
+
+	return result, nil
+}
+
+// UpdateNotificationPreferences saves whether the user receives the given kinds of notification emails.
+// Mandatory notifications can't be disabled.
+func (srv *EmailService) UpdateNotificationPreferences(ctx context.Context, userID string, input dto.NotificationPreferencesUpdateDto) ([]dto.NotificationPreferenceDto, error) {
+	dbConfig := srv.appConfigService.GetDbConfig()
+	for _, preference := range input.Preferences {
+		if !preference.Enabled && isNotificationMandatory(dbConfig, email.Notification(preference.Notification)) {
+			return nil, &common.NotificationMandatoryError{}
+		}
+	}
+
+	tx := srv.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	for _, preference := range input.Preferences {
+		err := srv.saveNotificationPreference(ctx, tx, userID, email.Notification(preference.Notification), preference.Enabled)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := tx.Commit().Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return srv.GetNotificationPreferences(ctx, userID)
+}
+
+func (srv *EmailService) saveNotificationPreference(ctx context.Context, tx *gorm.DB, userID string, notification email.Notification, enabled bool) error {
+	err := tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "notification"}},
+			DoUpdates: clause.AssignmentColumns([]string{"enabled", "updated_at"}),
+		}).
+		Create(&model.UserNotificationPreference{
+			UserID:       userID,
+			Notification: string(notification),
+			Enabled:      enabled,
+			UpdatedAt:    datatype.DateTime(time.Now()),
+		}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to save notification preference: %w", err)
+	}
+
+	return nil
+}
@@ -13,6 +13,8 @@ How to add new template:
 - in backend/resources/email-templates/ create "${name}_html.tmpl" and "${name}_text.tmpl"
 - create xxxxTemplate and xxxxTemplateData (for example NewLoginTemplate and NewLoginTemplateData)
   - Path *must* be ${name}
+  - set Purpose if the email should be sent from the sender address of onboarding, security or notification emails
+  - set Notification if users can unsubscribe from the email, and pass the UserID of the recipient to SendEmail
 - add xxxTemplate.Path to "emailTemplatePaths" at the end
 
 Notes:
@@ -26,6 +28,8 @@ var NewLoginTemplate = email.Template[NewLoginTemplateData]{
 	Title: func(data *email.TemplateData[NewLoginTemplateData]) string {
 		return fmt.Sprintf("New device login with %s", data.AppName)
 	},
+	Purpose:      email.PurposeSecurity,
+	Notification: email.NotificationNewLogin,
 }
 
 var OneTimeAccessTemplate = email.Template[OneTimeAccessTemplateData]{
@@ -33,6 +37,23 @@ var OneTimeAccessTemplate = email.Template[OneTimeAccessTemplateData]{
 	Title: func(data *email.TemplateData[OneTimeAccessTemplateData]) string {
 		return "Login Code"
 	},
+	Purpose: email.PurposeOnboarding,
+}
+
+var WelcomeTemplate = email.Template[WelcomeTemplateData]{
+	Path: "welcome",
+	Title: func(data *email.TemplateData[WelcomeTemplateData]) string {
+		return fmt.Sprintf("Welcome to %s", data.AppName)
+	},
+	Purpose: email.PurposeOnboarding,
+}
+
+var AccountDeletionTemplate = email.Template[AccountDeletionTemplateData]{
+	Path: "account-deletion",
+	Title: func(data *email.TemplateData[AccountDeletionTemplateData]) string {
+		return fmt.Sprintf("Confirm the deletion of your %s account", data.AppName)
+	},
+	Purpose: email.PurposeSecurity,
 }
 
 var TestTemplate = email.Template[struct{}]{
@@ -47,6 +68,31 @@ var ApiKeyExpiringSoonTemplate = email.Template[ApiKeyExpiringSoonTemplateData]{
 	Title: func(data *email.TemplateData[ApiKeyExpiringSoonTemplateData]) string {
 		return fmt.Sprintf("API Key \"%s\" Expiring Soon", data.Data.ApiKeyName)
 	},
+	Purpose:      email.PurposeNotification,
+	Notification: email.NotificationApiKeyExpiration,
+}
+
+var InactiveUserWarningTemplate = email.Template[InactiveUserWarningTemplateData]{
+	Path: "inactive-user-warning",
+	Title: func(data *email.TemplateData[InactiveUserWarningTemplateData]) string {
+		return fmt.Sprintf("Your %s account will be disabled", data.AppName)
+	},
+	Purpose: email.PurposeNotification,
+}
+
+var LdapSyncResultTemplate = email.Template[LdapSyncResultTemplateData]{
+	Path: "ldap-sync-result",
+	Title: func(data *email.TemplateData[LdapSyncResultTemplateData]) string {
+		switch {
+		case data.Data.Error != "":
+			return "LDAP Sync Failed"
+		case data.Data.RemovalAlert:
+			return fmt.Sprintf("LDAP Sync Removed %d Users", len(data.Data.Result.RemovedUsers))
+		default:
+			return "LDAP Sync Summary"
+		}
+	},
+	Purpose: email.PurposeNotification,
 }
 
 type NewLoginTemplateData struct {
@@ -64,11 +110,39 @@ type OneTimeAccessTemplateData = struct {
 	ExpirationString  string
 }
 
+type WelcomeTemplateData struct {
+	Name              string
+	Username          string
+	LoginLink         string
+	LoginLinkWithCode string
+	ExpirationString  string
+}
+
+type AccountDeletionTemplateData struct {
+	Name             string
+	Token            string
+	ExpirationString string
+}
+
 type ApiKeyExpiringSoonTemplateData struct {
 	Name       string
 	ApiKeyName string
 	ExpiresAt  time.Time
 }
 
+type InactiveUserWarningTemplateData struct {
+	Name       string
+	DisabledAt time.Time
+	LoginLink  string
+}
+
+type LdapSyncResultTemplateData struct {
+	Name                  string
+	Result                LdapSyncResult
+	Error                 string
+	RemovalAlert          bool
+	RemovalAlertThreshold int
+}
+
 // this is list of all template paths used for preloading templates
-var emailTemplatesPaths = []string{NewLoginTemplate.Path, OneTimeAccessTemplate.Path, TestTemplate.Path, ApiKeyExpiringSoonTemplate.Path}
+var emailTemplatesPaths = []string{NewLoginTemplate.Path, OneTimeAccessTemplate.Path, TestTemplate.Path, ApiKeyExpiringSoonTemplate.Path, InactiveUserWarningTemplate.Path, LdapSyncResultTemplate.Path, WelcomeTemplate.Path, AccountDeletionTemplate.Path}
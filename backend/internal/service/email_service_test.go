@@ -0,0 +1,287 @@
+package service
+
+import (
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+// recordingSmtpSession records the sender and content of the emails it receives
+type recordingSmtpSession struct {
+	lock *sync.Mutex
+	from *string
+	data *string
+}
+
+func (s recordingSmtpSession) Reset()        {}
+func (s recordingSmtpSession) Logout() error { return nil }
+func (s recordingSmtpSession) Mail(from string, _ *smtp.MailOptions) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	*s.from = from
+	return nil
+}
+func (s recordingSmtpSession) Rcpt(string, *smtp.RcptOptions) error { return nil }
+func (s recordingSmtpSession) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	*s.data = string(data)
+	return err
+}
+
+// startRecordingSmtpServer starts an SMTP server that records the emails it receives, and returns its port
+func startRecordingSmtpServer(t *testing.T, session recordingSmtpSession) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := smtp.NewServer(smtp.BackendFunc(func(*smtp.Conn) (smtp.Session, error) {
+		return session, nil
+	}))
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+	return port
+}
+
+func TestSendEmail_SenderAddress(t *testing.T) {
+	var lock sync.Mutex
+	var from, data string
+	port := startRecordingSmtpServer(t, recordingSmtpSession{lock: &lock, from: &from, data: &data})
+
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		AppName:          model.AppConfigVariable{Value: "Pocket ID"},
+		SmtpHost:         model.AppConfigVariable{Value: "127.0.0.1"},
+		SmtpPort:         model.AppConfigVariable{Value: port},
+		SmtpTls:          model.AppConfigVariable{Value: "none"},
+		SmtpFrom:         model.AppConfigVariable{Value: "noreply@example.com"},
+		SmtpFromSecurity: model.AppConfigVariable{Value: "security@example.com"},
+	})
+	emailService, err := NewEmailService(nil, appConfigService, nil)
+	require.NoError(t, err)
+
+	to := email.Address{Name: "John Doe", Email: "john@example.com"}
+
+	t.Run("uses the sender address of the purpose", func(t *testing.T) {
+		err := SendEmail(t.Context(), emailService, to, NewLoginTemplate, &NewLoginTemplateData{DateTime: time.Now()})
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "security@example.com", from)
+		assert.Contains(t, data, "From: Pocket ID <security@example.com>\r\n")
+		assert.Regexp(t, `Message-ID: <[^@>]+@example\.com>`, data)
+	})
+
+	t.Run("falls back to the default sender address", func(t *testing.T) {
+		err := SendEmail(t.Context(), emailService, to, OneTimeAccessTemplate, &OneTimeAccessTemplateData{Code: "123456"})
+		require.NoError(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "noreply@example.com", from)
+		assert.Contains(t, data, "From: Pocket ID <noreply@example.com>\r\n")
+	})
+}
+
+func TestSendEmail_Notifications(t *testing.T) {
+	var lock sync.Mutex
+	var from, data string
+	port := startRecordingSmtpServer(t, recordingSmtpSession{lock: &lock, from: &from, data: &data})
+
+	db := testutils.NewDatabaseForTest(t)
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		AppName:                  model.AppConfigVariable{Value: "Pocket ID"},
+		SmtpHost:                 model.AppConfigVariable{Value: "127.0.0.1"},
+		SmtpPort:                 model.AppConfigVariable{Value: port},
+		SmtpTls:                  model.AppConfigVariable{Value: "none"},
+		SmtpFrom:                 model.AppConfigVariable{Value: "noreply@example.com"},
+		SmtpReplyToNotifications: model.AppConfigVariable{Value: "support@example.com"},
+		EmailUnsubscribeKey:      model.AppConfigVariable{Value: "test-key"},
+
+		EmailLoginNotificationEnabled: model.AppConfigVariable{Value: "true"},
+		EmailApiKeyExpirationEnabled:  model.AppConfigVariable{Value: "true"},
+	})
+	emailService, err := NewEmailService(db, appConfigService, nil)
+	require.NoError(t, err)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	to := email.Address{Name: "John Doe", Email: user.Email, UserID: user.ID}
+
+	// receivedEmail returns the email received since the last call, if any
+	receivedEmail := func() string {
+		lock.Lock()
+		defer lock.Unlock()
+		received := data
+		data = ""
+		return received
+	}
+
+	t.Run("adds the reply-to and unsubscribe headers to notification emails", func(t *testing.T) {
+		err := SendEmail(t.Context(), emailService, to, NewLoginTemplate, &NewLoginTemplateData{DateTime: time.Now()})
+		require.NoError(t, err)
+
+		received := receivedEmail()
+		assert.Contains(t, received, "Reply-To: <support@example.com>\r\n")
+		assert.Contains(t, received, "List-Unsubscribe: <"+common.EnvConfig.AppURL+"/api/email/unsubscribe?token="+emailService.unsubscribeToken(user.ID, email.NotificationNewLogin)+">\r\n")
+		assert.Contains(t, received, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	})
+
+	t.Run("doesn't add the headers to other emails", func(t *testing.T) {
+		err := SendEmail(t.Context(), emailService, to, OneTimeAccessTemplate, &OneTimeAccessTemplateData{Code: "123456"})
+		require.NoError(t, err)
+
+		received := receivedEmail()
+		assert.NotEmpty(t, received)
+		assert.NotContains(t, received, "Reply-To")
+		assert.NotContains(t, received, "List-Unsubscribe")
+	})
+
+	t.Run("doesn't send notifications the user unsubscribed from", func(t *testing.T) {
+		err := emailService.Unsubscribe(t.Context(), emailService.unsubscribeToken(user.ID, email.NotificationNewLogin))
+		require.NoError(t, err)
+
+		err = SendEmail(t.Context(), emailService, to, NewLoginTemplate, &NewLoginTemplateData{DateTime: time.Now()})
+		require.NoError(t, err)
+		assert.Empty(t, receivedEmail())
+
+		preferences, err := emailService.GetNotificationPreferences(t.Context(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []dto.NotificationPreferenceDto{
+			{Notification: "newLogin", Enabled: false},
+			{Notification: "apiKeyExpiration", Enabled: true},
+		}, preferences)
+
+		// Login codes are always sent
+		err = SendEmail(t.Context(), emailService, to, OneTimeAccessTemplate, &OneTimeAccessTemplateData{Code: "123456"})
+		require.NoError(t, err)
+		assert.NotEmpty(t, receivedEmail())
+	})
+
+	t.Run("sends notifications again once they are enabled", func(t *testing.T) {
+		_, err := emailService.UpdateNotificationPreferences(t.Context(), user.ID, dto.NotificationPreferencesUpdateDto{
+			Preferences: []dto.NotificationPreferenceUpdateDto{{Notification: "newLogin", Enabled: true}},
+		})
+		require.NoError(t, err)
+
+		err = SendEmail(t.Context(), emailService, to, NewLoginTemplate, &NewLoginTemplateData{DateTime: time.Now()})
+		require.NoError(t, err)
+		assert.NotEmpty(t, receivedEmail())
+	})
+}
+
+func TestEmailService_UnsubscribeToken(t *testing.T) {
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		EmailUnsubscribeKey: model.AppConfigVariable{Value: "test-key"},
+	})
+	emailService := &EmailService{appConfigService: appConfigService}
+
+	token := emailService.unsubscribeToken("user-id", email.NotificationApiKeyExpiration)
+
+	userID, notification, err := emailService.parseUnsubscribeToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, "user-id", userID)
+	assert.Equal(t, email.NotificationApiKeyExpiration, notification)
+
+	t.Run("rejects tampered tokens", func(t *testing.T) {
+		payload, signature, _ := strings.Cut(token, ".")
+		otherPayload, _, _ := strings.Cut(emailService.unsubscribeToken("other-user-id", email.NotificationApiKeyExpiration), ".")
+
+		for _, tampered := range []string{otherPayload + "." + signature, payload, payload + ".", ""} {
+			_, _, err := emailService.parseUnsubscribeToken(tampered)
+			var invalidErr *common.TokenInvalidError
+			require.ErrorAs(t, err, &invalidErr, tampered)
+		}
+	})
+
+	t.Run("rejects tokens signed with another key", func(t *testing.T) {
+		otherService := &EmailService{appConfigService: NewTestAppConfigService(&model.AppConfig{
+			EmailUnsubscribeKey: model.AppConfigVariable{Value: "other-key"},
+		})}
+		_, _, err := otherService.parseUnsubscribeToken(token)
+		var invalidErr *common.TokenInvalidError
+		require.ErrorAs(t, err, &invalidErr)
+	})
+}
+
+func TestEmailService_NotificationUserDefaults(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := &model.AppConfig{
+		EmailUnsubscribeKey:               model.AppConfigVariable{Value: "test-key"},
+		EmailLoginNotificationEnabled:     model.AppConfigVariable{Value: "true"},
+		EmailLoginNotificationUserDefault: model.AppConfigVariable{Value: "mandatory"},
+		EmailApiKeyExpirationEnabled:      model.AppConfigVariable{Value: "true"},
+		EmailApiKeyExpirationUserDefault:  model.AppConfigVariable{Value: "disabled"},
+	}
+	emailService, err := NewEmailService(db, NewTestAppConfigService(appConfig), nil)
+	require.NoError(t, err)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	t.Run("applies the defaults to users without preferences", func(t *testing.T) {
+		preferences, err := emailService.GetNotificationPreferences(t.Context(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []dto.NotificationPreferenceDto{
+			{Notification: "newLogin", Enabled: true, Mandatory: true},
+			{Notification: "apiKeyExpiration", Enabled: false},
+		}, preferences)
+
+		enabled, err := emailService.isNotificationEnabled(t.Context(), user.ID, email.NotificationApiKeyExpiration)
+		require.NoError(t, err)
+		assert.False(t, enabled)
+	})
+
+	t.Run("users can enable notifications that are disabled by default", func(t *testing.T) {
+		_, err := emailService.UpdateNotificationPreferences(t.Context(), user.ID, dto.NotificationPreferencesUpdateDto{
+			Preferences: []dto.NotificationPreferenceUpdateDto{{Notification: "apiKeyExpiration", Enabled: true}},
+		})
+		require.NoError(t, err)
+
+		enabled, err := emailService.isNotificationEnabled(t.Context(), user.ID, email.NotificationApiKeyExpiration)
+		require.NoError(t, err)
+		assert.True(t, enabled)
+	})
+
+	t.Run("mandatory notifications can't be disabled", func(t *testing.T) {
+		var mandatoryErr *common.NotificationMandatoryError
+
+		_, err := emailService.UpdateNotificationPreferences(t.Context(), user.ID, dto.NotificationPreferencesUpdateDto{
+			Preferences: []dto.NotificationPreferenceUpdateDto{{Notification: "newLogin", Enabled: false}},
+		})
+		require.ErrorAs(t, err, &mandatoryErr)
+
+		err = emailService.Unsubscribe(t.Context(), emailService.unsubscribeToken(user.ID, email.NotificationNewLogin))
+		require.ErrorAs(t, err, &mandatoryErr)
+
+		enabled, err := emailService.isNotificationEnabled(t.Context(), user.ID, email.NotificationNewLogin)
+		require.NoError(t, err)
+		assert.True(t, enabled)
+	})
+
+	t.Run("notifications that aren't sent aren't listed", func(t *testing.T) {
+		appConfig.EmailApiKeyExpirationEnabled = model.AppConfigVariable{Value: "false"}
+
+		preferences, err := emailService.GetNotificationPreferences(t.Context(), user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []dto.NotificationPreferenceDto{
+			{Notification: "newLogin", Enabled: true, Mandatory: true},
+		}, preferences)
+	})
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// Results of the GeoLite lookups, used as the only attribute of the metrics to keep the cardinality bounded
+const (
+	geoLiteResultPrivate     = "private"
+	geoLiteResultHit         = "hit"
+	geoLiteResultMiss        = "miss"
+	geoLiteResultInvalidIP   = "invalid_ip"
+	geoLiteResultDBError     = "db_error"
+	geoLiteResultDecodeError = "decode_error"
+)
+
+type geoLiteMetrics struct {
+	lookups        metric.Int64Counter
+	lookupDuration metric.Float64Histogram
+}
+
+// The instruments are created with the global meter provider, which forwards them to the provider configured at startup
+var geoLiteLookupMetrics = newGeoLiteMetrics(otel.Meter(common.MeterName))
+
+func newGeoLiteMetrics(meter metric.Meter) *geoLiteMetrics {
+	m := &geoLiteMetrics{}
+
+	var err error
+	m.lookups, err = meter.Int64Counter(
+		"pocket_id.geolite.lookups",
+		metric.WithDescription("Number of IP address lookups in the GeoLite2 City database, by result"),
+	)
+	if err != nil {
+		slog.Warn("Failed to register GeoLite lookups metric", slog.Any("error", err))
+	}
+
+	m.lookupDuration, err = meter.Float64Histogram(
+		"pocket_id.geolite.lookup.duration",
+		metric.WithDescription("Duration of the IP address lookups in the GeoLite2 City database, by result"),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		slog.Warn("Failed to register GeoLite lookup duration metric", slog.Any("error", err))
+	}
+
+	_, err = meter.Float64ObservableGauge(
+		"pocket_id.geolite.database.age",
+		metric.WithDescription("Time since the GeoLite2 City database was last updated"),
+		metric.WithUnit("s"),
+		metric.WithFloat64Callback(func(_ context.Context, o metric.Float64Observer) error {
+			info, err := os.Stat(common.EnvConfig.GeoLiteDBPath)
+			if err != nil {
+				// There is no database yet
+				return nil
+			}
+			o.Observe(time.Since(info.ModTime()).Seconds())
+			return nil
+		}),
+	)
+	if err != nil {
+		slog.Warn("Failed to register GeoLite database age metric", slog.Any("error", err))
+	}
+
+	return m
+}
+
+// record records the result of a lookup that started at the given time
+func (m *geoLiteMetrics) record(ctx context.Context, result string, start time.Time) {
+	attrs := metric.WithAttributes(attribute.String("result", result))
+	if m.lookups != nil {
+		m.lookups.Add(ctx, 1, attrs)
+	}
+	if m.lookupDuration != nil {
+		m.lookupDuration.Record(ctx, time.Since(start).Seconds(), attrs)
+	}
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+const (
+	// Hostnames change rarely, but failed lookups are retried soon in case the failure was transient
+	reverseDNSCacheTTL         = time.Hour
+	reverseDNSNegativeCacheTTL = 5 * time.Minute
+	reverseDNSCacheMaxEntries  = 4096
+)
+
+type reverseDNSCacheEntry struct {
+	hostname  string
+	expiresAt time.Time
+}
+
+// reverseDNSCache caches the results of reverse DNS lookups, including the failed ones
+type reverseDNSCache struct {
+	mutex   sync.Mutex
+	entries map[string]reverseDNSCacheEntry
+}
+
+func (c *reverseDNSCache) get(ip string) (hostname string, ok bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[ip]
+	if !ok || time.Now().After(entry.expiresAt) {
+		return "", false
+	}
+	return entry.hostname, true
+}
+
+func (c *reverseDNSCache) set(ip, hostname string) {
+	ttl := reverseDNSCacheTTL
+	if hostname == "" {
+		ttl = reverseDNSNegativeCacheTTL
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]reverseDNSCacheEntry)
+	}
+
+	// Remove the expired entries when the cache is full, and start over if that's not enough
+	if len(c.entries) >= reverseDNSCacheMaxEntries {
+		now := time.Now()
+		for key, entry := range c.entries {
+			if now.After(entry.expiresAt) {
+				delete(c.entries, key)
+			}
+		}
+		if len(c.entries) >= reverseDNSCacheMaxEntries {
+			clear(c.entries)
+		}
+	}
+
+	c.entries[ip] = reverseDNSCacheEntry{hostname: hostname, expiresAt: time.Now().Add(ttl)}
+}
+
+// unknownLocation returns the location of a public IP address that isn't in the database.
+// The country is the configured label, and the city is the hostname of the IP address if the reverse DNS fallback is enabled.
+func (s *GeoLiteService) unknownLocation(ctx context.Context, ipAddress string) (country, city string) {
+	country = common.EnvConfig.GeoLiteUnknownLocationLabel
+	if !common.EnvConfig.GeoLiteReverseDNS {
+		return country, ""
+	}
+
+	return country, s.reverseDNS(ctx, ipAddress)
+}
+
+// reverseDNS returns the hostname of the IP address, or an empty string if it has none or the lookup failed
+func (s *GeoLiteService) reverseDNS(ctx context.Context, ipAddress string) string {
+	if hostname, ok := s.reverseDNSCache.get(ipAddress); ok {
+		return hostname
+	}
+
+	lookupAddr := s.lookupAddr
+	if lookupAddr == nil {
+		lookupAddr = net.DefaultResolver.LookupAddr
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, common.EnvConfig.GeoLiteReverseDNSTimeout)
+	defer cancel()
+
+	var hostname string
+	names, err := lookupAddr(lookupCtx, ipAddress)
+	if err != nil {
+		slog.DebugContext(ctx, "Reverse DNS lookup failed", slog.Any("error", err))
+	} else if len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	s.reverseDNSCache.set(ipAddress, hostname)
+	return hostname
+}
@@ -4,10 +4,13 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"net/netip"
@@ -18,8 +21,12 @@ import (
 	"time"
 
 	"github.com/oschwald/maxminddb-golang/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
 type GeoLiteService struct {
@@ -27,6 +34,9 @@ type GeoLiteService struct {
 	disableUpdater  bool
 	mutex           sync.RWMutex
 	localIPv6Ranges []*net.IPNet
+	// Fallback for the IP addresses that aren't in the database
+	reverseDNSCache reverseDNSCache
+	lookupAddr      func(ctx context.Context, addr string) ([]string, error)
 }
 
 var localhostIPNets = []*net.IPNet{
@@ -117,53 +127,116 @@ func (s *GeoLiteService) isLocalIPv6(ip net.IP) bool {
 	return false
 }
 
+// IsPrivateIP returns true if the IP address is in a private network, including the configured local IPv6 ranges.
+// Loopback addresses are not considered private.
+func (s *GeoLiteService) IsPrivateIP(ip net.IP) bool {
+	if s.isLocalIPv6(ip) || ip.IsPrivate() || ip.IsLinkLocalUnicast() {
+		return true
+	}
+	for _, ipNet := range tailscaleIPNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	for _, ipNet := range privateLanIPNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *GeoLiteService) DisableUpdater() bool {
 	return s.disableUpdater
 }
 
+// IPLocation is the location of an IP address
+type IPLocation struct {
+	Country string
+	// CountryCode is the ISO 3166-1 alpha-2 code of the country, if the IP address is in the database
+	CountryCode string
+	City        string
+	// Private is true if the IP address is in a private network, which isn't in the database
+	Private bool
+	// Found is true if the location is known, either because the IP address is private or because it's in the database
+	Found bool
+	// Coordinates of the location, if HasCoordinates is true, and the radius in km around them where the IP address is likely to be
+	Latitude         float64
+	Longitude        float64
+	AccuracyRadiusKm uint16
+	HasCoordinates   bool
+}
+
 // GetLocationByIP returns the country and city of the given IP address.
-func (s *GeoLiteService) GetLocationByIP(ipAddress string) (country, city string, err error) {
+// If the IP address isn't in the database, the configured unknown location is returned, with the hostname as city if the reverse DNS fallback is enabled.
+func (s *GeoLiteService) GetLocationByIP(ctx context.Context, ipAddress string) (country, city string, err error) {
+	location, err := s.LookupIP(ctx, ipAddress)
+	if err != nil {
+		return "", "", err
+	}
+	return location.Country, location.City, nil
+}
+
+// LookupIP returns the location of the given IP address, like GetLocationByIP, with whether it's known.
+// Every lookup is recorded in the metrics and traced, without the IP address.
+func (s *GeoLiteService) LookupIP(ctx context.Context, ipAddress string) (location IPLocation, err error) {
 	if ipAddress == "" {
-		return "", "", nil
+		return IPLocation{}, nil
 	}
 
-	// Check the IP address against known private IP ranges
-	if ip := net.ParseIP(ipAddress); ip != nil {
-		// Check IPv6 local ranges first
-		if s.isLocalIPv6(ip) {
-			return "Internal Network", "LAN", nil
-		}
+	ctx, span := otel.Tracer(common.TracerName).Start(ctx, "GeoLiteService.LookupIP")
+	defer span.End()
 
-		// Check existing IPv4 ranges
-		for _, ipNet := range tailscaleIPNets {
-			if ipNet.Contains(ip) {
-				return "Internal Network", "Tailscale", nil
-			}
-		}
-		for _, ipNet := range privateLanIPNets {
-			if ipNet.Contains(ip) {
-				return "Internal Network", "LAN", nil
-			}
-		}
-		for _, ipNet := range localhostIPNets {
-			if ipNet.Contains(ip) {
-				return "Internal Network", "localhost", nil
-			}
+	start := time.Now()
+	result := geoLiteResultHit
+	defer func() {
+		span.SetAttributes(attribute.String("geolite.result", result))
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
 		}
+		geoLiteLookupMetrics.record(ctx, result, start)
+	}()
+
+	country, city, ok := s.getPrivateLocation(ipAddress)
+	if ok {
+		result = geoLiteResultPrivate
+		return IPLocation{Country: country, City: city, Private: true, Found: true}, nil
 	}
 
 	addr, err := netip.ParseAddr(ipAddress)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to parse IP address: %w", err)
+		result = geoLiteResultInvalidIP
+		return IPLocation{}, fmt.Errorf("failed to parse IP address: %w", err)
 	}
 
+	location, err = s.lookupDatabase(addr)
+	if err != nil {
+		result = geoLiteResultDBError
+		if errors.Is(err, errGeoLiteDecode) {
+			result = geoLiteResultDecodeError
+		}
+		return IPLocation{}, err
+	}
+	if !location.Found {
+		// The fallback is used after the database is released, so a slow DNS lookup doesn't block updates
+		result = geoLiteResultMiss
+		location.Country, location.City = s.unknownLocation(ctx, ipAddress)
+	}
+
+	return location, nil
+}
+
+var errGeoLiteDecode = errors.New("failed to decode GeoLite record")
+
+// lookupDatabase returns the location of the IP address from the database
+func (s *GeoLiteService) lookupDatabase(addr netip.Addr) (IPLocation, error) {
 	// Race condition between reading and writing the database.
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
 	db, err := maxminddb.Open(common.EnvConfig.GeoLiteDBPath)
 	if err != nil {
-		return "", "", err
+		return IPLocation{}, err
 	}
 	defer db.Close()
 
@@ -172,16 +245,68 @@ func (s *GeoLiteService) GetLocationByIP(ipAddress string) (country, city string
 			Names map[string]string `maxminddb:"names"`
 		} `maxminddb:"city"`
 		Country struct {
-			Names map[string]string `maxminddb:"names"`
+			IsoCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
 		} `maxminddb:"country"`
+		Location struct {
+			Latitude       *float64 `maxminddb:"latitude"`
+			Longitude      *float64 `maxminddb:"longitude"`
+			AccuracyRadius uint16   `maxminddb:"accuracy_radius"`
+		} `maxminddb:"location"`
 	}
 
-	err = db.Lookup(addr).Decode(&record)
+	lookup := db.Lookup(addr)
+	err = lookup.Decode(&record)
 	if err != nil {
-		return "", "", err
+		return IPLocation{}, fmt.Errorf("%w: %w", errGeoLiteDecode, err)
+	}
+
+	location := IPLocation{
+		Country:     record.Country.Names["en"],
+		CountryCode: record.Country.IsoCode,
+		City:        record.City.Names["en"],
+		Found:       lookup.Found(),
+	}
+	if record.Location.Latitude != nil && record.Location.Longitude != nil {
+		location.Latitude = *record.Location.Latitude
+		location.Longitude = *record.Location.Longitude
+		location.AccuracyRadiusKm = record.Location.AccuracyRadius
+		location.HasCoordinates = true
 	}
 
-	return record.Country.Names["en"], record.City.Names["en"], nil
+	return location, nil
+}
+
+// getPrivateLocation returns the location of IP addresses in private ranges, which aren't in the database
+func (s *GeoLiteService) getPrivateLocation(ipAddress string) (country, city string, ok bool) {
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		return "", "", false
+	}
+
+	// Check IPv6 local ranges first
+	if s.isLocalIPv6(ip) {
+		return "Internal Network", "LAN", true
+	}
+
+	// Check existing IPv4 ranges
+	for _, ipNet := range tailscaleIPNets {
+		if ipNet.Contains(ip) {
+			return "Internal Network", "Tailscale", true
+		}
+	}
+	for _, ipNet := range privateLanIPNets {
+		if ipNet.Contains(ip) {
+			return "Internal Network", "LAN", true
+		}
+	}
+	for _, ipNet := range localhostIPNets {
+		if ipNet.Contains(ip) {
+			return "Internal Network", "localhost", true
+		}
+	}
+
+	return "", "", false
 }
 
 // UpdateDatabase checks the age of the database and updates it if it's older than 14 days.
@@ -194,13 +319,94 @@ func (s *GeoLiteService) UpdateDatabase(parentCtx context.Context) error {
 	slog.Info("Updating GeoLite2 City database")
 	downloadUrl := fmt.Sprintf(common.EnvConfig.GeoLiteDBUrl, common.EnvConfig.MaxMindLicenseKey)
 
+	// The archive is downloaded to a file next to the database, so an interrupted download can be resumed
+	archivePath := common.EnvConfig.GeoLiteDBPath + ".download"
+
+	attempts := common.EnvConfig.GeoLiteDownloadAttempts
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			delay := geoLiteRetryDelay(attempt - 1)
+			slog.Warn("Failed to download GeoLite2 City database, retrying",
+				slog.Int("attempt", attempt-1),
+				slog.Duration("delay", delay),
+				slog.Any("error", err),
+			)
+			select {
+			case <-parentCtx.Done():
+				return parentCtx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		// The URLs contain the license key, which would otherwise be part of the error messages
+		err = utils.RedactError(s.downloadArchive(parentCtx, downloadUrl, archivePath), common.EnvConfig.MaxMindLicenseKey)
+		if err != nil {
+			continue
+		}
+
+		err = utils.RedactError(s.verifyArchiveChecksum(parentCtx, archivePath), common.EnvConfig.MaxMindLicenseKey)
+		if err == nil {
+			break
+		}
+		// The archive is corrupted, so the next attempt must not resume it
+		os.Remove(archivePath)
+		os.Remove(geoLiteETagPath(archivePath))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to download database after %d attempts: %w", attempts, err)
+	}
+
+	// Extract the database file directly to the target path
+	err = s.extractDatabaseFromFile(parentCtx, archivePath)
+	// The archive is removed even if it's invalid, so the next update starts from scratch
+	os.Remove(archivePath)
+	os.Remove(geoLiteETagPath(archivePath))
+	if err != nil {
+		return fmt.Errorf("failed to extract database: %w", err)
+	}
+
+	slog.Info("GeoLite2 City database successfully updated.")
+	return nil
+}
+
+// downloadArchive downloads the archive to the given path.
+// If the file contains a partial download already, only the remaining bytes are requested using a range request.
+// The ETag of the response is saved next to the archive, so that the server can tell if the archive changed in the meantime.
+func (s *GeoLiteService) downloadArchive(parentCtx context.Context, downloadUrl, archivePath string) error {
 	ctx, cancel := context.WithTimeout(parentCtx, 10*time.Minute)
 	defer cancel()
 
+	file, err := os.OpenFile(archivePath, os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek archive file: %w", err)
+	}
+
+	// Without the ETag of the partial download, it isn't known whether it belongs to the current archive, so it's downloaded again
+	etagPath := geoLiteETagPath(archivePath)
+	var etag []byte
+	if offset > 0 {
+		etag, err = os.ReadFile(etagPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to read ETag file: %w", err)
+		}
+	}
+
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
+	if offset > 0 && len(etag) > 0 {
+		// If the archive changed, the server ignores the range and sends the whole new archive
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", string(etag))
+	}
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
@@ -208,20 +414,151 @@ func (s *GeoLiteService) UpdateDatabase(parentCtx context.Context) error {
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The file has been downloaded completely already
+		return nil
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			return s.restartDownload(file, fmt.Errorf("unexpected content range '%s'", resp.Header.Get("Content-Range")))
+		}
+		slog.Info("Resuming GeoLite2 City database download", slog.Int64("offset", offset))
+	case resp.StatusCode == http.StatusOK:
+		// The server doesn't support range requests, the archive changed, or there is no partial download: start from the beginning
+		if offset > 0 {
+			err = file.Truncate(0)
+			if err != nil {
+				return fmt.Errorf("failed to truncate archive file: %w", err)
+			}
+			_, err = file.Seek(0, io.SeekStart)
+			if err != nil {
+				return fmt.Errorf("failed to seek archive file: %w", err)
+			}
+		}
+
+		err = saveGeoLiteETag(etagPath, resp.Header.Get("ETag"))
+		if err != nil {
+			return err
+		}
+	default:
 		return fmt.Errorf("failed to download database, received HTTP %d", resp.StatusCode)
 	}
 
-	// Extract the database file directly to the target path
-	err = s.extractDatabase(resp.Body)
+	// The partially written data is kept if the copy fails, so the next attempt can resume from there
+	_, err = utils.CopyWithContext(ctx, file, resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to extract database: %w", err)
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	return file.Sync()
+}
+
+// verifyArchiveChecksum compares the SHA-256 checksum of the archive with the one published by the provider.
+// Verification is skipped if no checksum URL is configured, as not all providers publish one.
+func (s *GeoLiteService) verifyArchiveChecksum(parentCtx context.Context, archivePath string) error {
+	if common.EnvConfig.GeoLiteDBChecksumUrl == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, time.Minute)
+	defer cancel()
+
+	checksumUrl := fmt.Sprintf(common.EnvConfig.GeoLiteDBChecksumUrl, common.EnvConfig.MaxMindLicenseKey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checksumUrl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download checksum: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download checksum, received HTTP %d", resp.StatusCode)
+	}
+
+	// The file has the format of sha256sum: "<checksum>  <file name>"
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+	fields := strings.Fields(string(body))
+	if len(fields) == 0 {
+		return errors.New("checksum file is empty")
+	}
+	expected := strings.ToLower(fields[0])
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = utils.CopyWithContext(parentCtx, hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to hash archive file: %w", err)
+	}
+	actual := hex.EncodeToString(hash.Sum(nil))
+
+	if actual != expected {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", expected, actual)
 	}
 
-	slog.Info("GeoLite2 City database successfully updated.")
 	return nil
 }
 
+// restartDownload discards the partial download, so the next attempt starts from the beginning
+func (s *GeoLiteService) restartDownload(file *os.File, cause error) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return fmt.Errorf("failed to truncate archive file: %w", err)
+	}
+	return cause
+}
+
+// geoLiteETagPath returns the path of the file that contains the ETag of the archive at the given path
+func geoLiteETagPath(archivePath string) string {
+	return archivePath + ".etag"
+}
+
+// saveGeoLiteETag saves the ETag of a new download, or removes the saved one if the response has none.
+// Weak ETags aren't saved, as they can't be used in an If-Range header.
+func saveGeoLiteETag(etagPath string, etag string) error {
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		err := os.Remove(etagPath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove ETag file: %w", err)
+		}
+		return nil
+	}
+
+	err := os.WriteFile(etagPath, []byte(etag), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write ETag file: %w", err)
+	}
+	return nil
+}
+
+// geoLiteRetryDelay returns the delay before the given retry, growing exponentially with some jitter
+func geoLiteRetryDelay(retry int) time.Duration {
+	delay := min(time.Duration(1<<min(retry, 6))*2*time.Second, 2*time.Minute)
+	return delay/2 + rand.N(delay/2+1) //nolint:gosec
+}
+
+// extractDatabaseFromFile extracts the database from the downloaded archive
+func (s *GeoLiteService) extractDatabaseFromFile(ctx context.Context, archivePath string) error {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	defer file.Close()
+
+	return s.extractDatabase(ctx, file)
+}
+
 // isDatabaseUpToDate checks if the database file is older than 14 days.
 func (s *GeoLiteService) isDatabaseUpToDate() bool {
 	info, err := os.Stat(common.EnvConfig.GeoLiteDBPath)
@@ -233,7 +570,8 @@ func (s *GeoLiteService) isDatabaseUpToDate() bool {
 }
 
 // extractDatabase extracts the database file from the tar.gz archive directly to the target location.
-func (s *GeoLiteService) extractDatabase(reader io.Reader) error {
+// The extraction stops if the context is canceled, leaving the current database in place.
+func (s *GeoLiteService) extractDatabase(ctx context.Context, reader io.Reader) error {
 	gzr, err := gzip.NewReader(reader)
 	if err != nil {
 		return fmt.Errorf("failed to create gzip reader: %w", err)
@@ -262,15 +600,14 @@ func (s *GeoLiteService) extractDatabase(reader io.Reader) error {
 			}
 
 			// extract to a temporary file to avoid having a corrupted db in case of write failure.
-			baseDir := filepath.Dir(common.EnvConfig.GeoLiteDBPath)
-			tmpFile, err := os.CreateTemp(baseDir, "geolite.*.mmdb.tmp")
+			tmpFile, err := utils.CreateTempFile(common.EnvConfig.GeoLiteDBPath)
 			if err != nil {
 				return fmt.Errorf("failed to create temporary database file: %w", err)
 			}
 			tempName := tmpFile.Name()
 
 			// Write the file contents directly to the target location
-			if _, err := io.Copy(tmpFile, tarReader); err != nil { //nolint:gosec
+			if _, err := utils.CopyWithContext(ctx, tmpFile, tarReader); err != nil { //nolint:gosec
 				// if fails to write, then cleanup and throw an error
 				tmpFile.Close()
 				os.Remove(tempName)
@@ -291,7 +628,8 @@ func (s *GeoLiteService) extractDatabase(reader io.Reader) error {
 			// to prevent race conditions between reading and writing the mmdb.
 			s.mutex.Lock()
 			// replace the old file with the new file
-			err = os.Rename(tempName, common.EnvConfig.GeoLiteDBPath)
+			// if the temporary file is on another filesystem, the file is copied and then renamed atomically
+			err = utils.MoveFile(tempName, common.EnvConfig.GeoLiteDBPath)
 			s.mutex.Unlock()
 
 			if err != nil {
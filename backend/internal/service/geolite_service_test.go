@@ -1,13 +1,25 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
 )
 
 func TestGeoLiteService_IPv6LocalRanges(t *testing.T) {
@@ -75,7 +87,7 @@ func TestGeoLiteService_IPv6LocalRanges(t *testing.T) {
 
 			service := NewGeoLiteService(&http.Client{})
 
-			country, city, err := service.GetLocationByIP(tt.testIP)
+			country, city, err := service.GetLocationByIP(t.Context(), tt.testIP)
 
 			if tt.expectError {
 				if err == nil && country != "Internal Network" {
@@ -218,3 +230,255 @@ func TestGeoLiteService_initializeIPv6LocalRanges(t *testing.T) {
 		})
 	}
 }
+
+func TestGeoLiteService_downloadArchive(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+	etag := `"v1"`
+
+	var ranges, ifRanges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+		w.Header().Set("ETag", etag)
+		if len(ranges) == 1 {
+			// Interrupt the first download halfway
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(content[:len(content)/2])
+			w.(http.Flusher).Flush()
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err == nil {
+				conn.Close()
+			}
+			return
+		}
+		http.ServeContent(w, r, "archive.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	service := &GeoLiteService{httpClient: server.Client()}
+	archivePath := filepath.Join(t.TempDir(), "archive.download")
+
+	err := service.downloadArchive(t.Context(), server.URL, archivePath)
+	require.Error(t, err)
+
+	// The second attempt resumes the partial download if the archive didn't change
+	err = service.downloadArchive(t.Context(), server.URL, archivePath)
+	require.NoError(t, err)
+
+	require.Len(t, ranges, 2)
+	assert.Empty(t, ranges[0])
+	assert.Empty(t, ifRanges[0])
+	assert.Equal(t, "bytes="+strconv.Itoa(len(content)/2)+"-", ranges[1])
+	assert.Equal(t, etag, ifRanges[1])
+
+	downloaded, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	// Once complete, the server reports that the range can't be satisfied
+	err = service.downloadArchive(t.Context(), server.URL, archivePath)
+	require.NoError(t, err)
+	downloaded, err = os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	// If the archive changed, the server sends the new archive instead of the range
+	require.NoError(t, os.Truncate(archivePath, int64(len(content)/2)))
+	content = bytes.Repeat([]byte("abcdefghij"), 1200)
+	etag = `"v2"`
+
+	err = service.downloadArchive(t.Context(), server.URL, archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, `"v1"`, ifRanges[len(ifRanges)-1])
+	downloaded, err = os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+
+	savedETag, err := os.ReadFile(archivePath + ".etag")
+	require.NoError(t, err)
+	assert.Equal(t, etag, string(savedETag))
+}
+
+func TestGeoLiteService_downloadArchive_WithoutETag(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 1000)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		http.ServeContent(w, r, "archive.tar.gz", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// A partial download without a saved ETag can't be resumed safely
+	archivePath := filepath.Join(t.TempDir(), "archive.download")
+	require.NoError(t, os.WriteFile(archivePath, []byte("stale"), 0o600))
+
+	service := &GeoLiteService{httpClient: server.Client()}
+	err := service.downloadArchive(t.Context(), server.URL, archivePath)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{""}, ranges)
+	downloaded, err := os.ReadFile(archivePath)
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded)
+}
+
+func TestGeoLiteService_UpdateDatabase_RedactsLicenseKey(t *testing.T) {
+	const licenseKey = "my-secret-license-key"
+
+	// The server isn't reachable, so the error contains the download URL
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	originalConfig := common.EnvConfig
+	common.EnvConfig.MaxMindLicenseKey = licenseKey
+	common.EnvConfig.GeoLiteDBUrl = server.URL + "/download?license_key=%s&suffix=tar.gz"
+	common.EnvConfig.GeoLiteDBChecksumUrl = ""
+	common.EnvConfig.GeoLiteDBPath = filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	common.EnvConfig.GeoLiteDownloadAttempts = 1
+	defer func() { common.EnvConfig = originalConfig }()
+
+	var logs bytes.Buffer
+	originalLogger := slog.Default()
+	slog.SetDefault(slog.New(utils.LogRedactionHandler{Handler: slog.NewJSONHandler(&logs, nil)}))
+	defer slog.SetDefault(originalLogger)
+
+	service := &GeoLiteService{httpClient: http.DefaultClient}
+	err := service.UpdateDatabase(t.Context())
+	require.Error(t, err)
+	assert.NotContains(t, err.Error(), licenseKey)
+	assert.Contains(t, err.Error(), "license_key=[redacted]")
+
+	// Like the scheduler does when the job fails
+	slog.Error("Job failed with error", slog.Any("error", err))
+	assert.NotContains(t, logs.String(), licenseKey)
+}
+
+func TestGeoLiteService_verifyArchiveChecksum(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "archive.download")
+	require.NoError(t, os.WriteFile(archivePath, []byte("archive"), 0o600))
+	// sha256 of "archive"
+	const checksum = "0eb3e36bfb24dcd9bb1d1bece1531216b59539a8fde17ee80224af0653c92aa3"
+
+	var published string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(published))
+	}))
+	defer server.Close()
+
+	originalChecksumUrl := common.EnvConfig.GeoLiteDBChecksumUrl
+	t.Cleanup(func() {
+		common.EnvConfig.GeoLiteDBChecksumUrl = originalChecksumUrl
+	})
+
+	service := &GeoLiteService{httpClient: server.Client()}
+
+	t.Run("is skipped without a checksum URL", func(t *testing.T) {
+		common.EnvConfig.GeoLiteDBChecksumUrl = ""
+		published = "invalid"
+		require.NoError(t, service.verifyArchiveChecksum(t.Context(), archivePath))
+	})
+
+	common.EnvConfig.GeoLiteDBChecksumUrl = server.URL + "?key=%s"
+
+	t.Run("accepts a matching checksum", func(t *testing.T) {
+		published = checksum + "  GeoLite2-City_20250101.tar.gz\n"
+		require.NoError(t, service.verifyArchiveChecksum(t.Context(), archivePath))
+	})
+
+	t.Run("rejects a mismatching checksum", func(t *testing.T) {
+		published = strings.Repeat("0", 64) + "  GeoLite2-City_20250101.tar.gz\n"
+		require.ErrorContains(t, service.verifyArchiveChecksum(t.Context(), archivePath), "checksum mismatch")
+	})
+}
+
+func TestGeoLiteService_LookupMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	provider := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+	originalMetrics := geoLiteLookupMetrics
+	geoLiteLookupMetrics = newGeoLiteMetrics(provider.Meter("test"))
+	defer func() {
+		geoLiteLookupMetrics = originalMetrics
+	}()
+
+	originalPath := common.EnvConfig.GeoLiteDBPath
+	common.EnvConfig.GeoLiteDBPath = filepath.Join(t.TempDir(), "missing.mmdb")
+	defer func() {
+		common.EnvConfig.GeoLiteDBPath = originalPath
+	}()
+
+	service := &GeoLiteService{}
+	for _, ip := range []string{"192.168.1.1", "10.0.0.1", "not-an-ip", "8.8.8.8", ""} {
+		_, _, _ = service.GetLocationByIP(t.Context(), ip)
+	}
+
+	var rm metricdata.ResourceMetrics
+	require.NoError(t, reader.Collect(t.Context(), &rm))
+
+	counts := map[string]int64{}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != "pocket_id.geolite.lookups" {
+				continue
+			}
+			for _, dp := range m.Data.(metricdata.Sum[int64]).DataPoints {
+				result, _ := dp.Attributes.Value("result")
+				counts[result.AsString()] = dp.Value
+			}
+		}
+	}
+
+	// Empty IP addresses aren't looked up
+	assert.Equal(t, map[string]int64{
+		geoLiteResultPrivate:   2,
+		geoLiteResultInvalidIP: 1,
+		geoLiteResultDBError:   1,
+	}, counts)
+}
+
+func TestGeoLiteService_unknownLocation(t *testing.T) {
+	originalConfig := common.EnvConfig
+	defer func() {
+		common.EnvConfig = originalConfig
+	}()
+	common.EnvConfig.GeoLiteUnknownLocationLabel = "Unknown"
+	common.EnvConfig.GeoLiteReverseDNSTimeout = time.Second
+
+	lookups := 0
+	service := &GeoLiteService{
+		lookupAddr: func(_ context.Context, addr string) ([]string, error) {
+			lookups++
+			if addr == "203.0.113.1" {
+				return []string{"host.example.com."}, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", IsNotFound: true}
+		},
+	}
+
+	t.Run("returns the label without reverse DNS", func(t *testing.T) {
+		common.EnvConfig.GeoLiteReverseDNS = false
+		country, city := service.unknownLocation(t.Context(), "203.0.113.1")
+		assert.Equal(t, "Unknown", country)
+		assert.Empty(t, city)
+		assert.Zero(t, lookups)
+	})
+
+	t.Run("returns the hostname with reverse DNS", func(t *testing.T) {
+		common.EnvConfig.GeoLiteReverseDNS = true
+		country, city := service.unknownLocation(t.Context(), "203.0.113.1")
+		assert.Equal(t, "Unknown", country)
+		assert.Equal(t, "host.example.com", city)
+	})
+
+	t.Run("caches the results, including failed lookups", func(t *testing.T) {
+		lookups = 0
+		for range 3 {
+			_, city := service.unknownLocation(t.Context(), "203.0.113.2")
+			assert.Empty(t, city)
+			_, city = service.unknownLocation(t.Context(), "203.0.113.1")
+			assert.Equal(t, "host.example.com", city)
+		}
+		assert.Equal(t, 1, lookups)
+	})
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// How long import reports can be downloaded after the import
+const importReportRetention = 7 * 24 * time.Hour
+
+// ImportReportService stores the reports of bulk imports, so admins can download them after the import has completed
+type ImportReportService struct {
+	db *gorm.DB
+}
+
+func NewImportReportService(db *gorm.DB) *ImportReportService {
+	return &ImportReportService{db: db}
+}
+
+// Create stores the report of an import; entries are the outcomes of the rows and are stored as JSON
+func (s *ImportReportService) Create(ctx context.Context, reportType model.ImportReportType, actorUserID string, committed bool, failedCount int, entries []any, tx *gorm.DB) (model.ImportReport, error) {
+	entriesJSON, err := json.Marshal(entries)
+	if err != nil {
+		return model.ImportReport{}, fmt.Errorf("failed to encode import report: %w", err)
+	}
+
+	report := model.ImportReport{
+		ExpiresAt:   datatype.DateTime(time.Now().Add(importReportRetention)),
+		Type:        reportType,
+		Committed:   committed,
+		TotalCount:  len(entries),
+		FailedCount: failedCount,
+		Entries:     string(entriesJSON),
+	}
+	if actorUserID != "" {
+		report.ActorUserID = &actorUserID
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Create(&report).
+		Error
+	if err != nil {
+		return model.ImportReport{}, fmt.Errorf("failed to save import report: %w", err)
+	}
+
+	return report, nil
+}
+
+// List returns the reports that haven't expired yet, without their entries
+func (s *ImportReportService) List(ctx context.Context, sortedPaginationRequest utils.SortedPaginationRequest) ([]model.ImportReport, utils.PaginationResponse, error) {
+	var reports []model.ImportReport
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.ImportReport{}).
+		Omit("entries").
+		Where("expires_at > ?", datatype.DateTime(time.Now()))
+
+	pagination, err := utils.PaginateAndSort(sortedPaginationRequest, query, &reports)
+	return reports, pagination, err
+}
+
+// Get returns a report that hasn't expired yet, including its entries
+func (s *ImportReportService) Get(ctx context.Context, id string) (model.ImportReport, error) {
+	var report model.ImportReport
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ? AND expires_at > ?", id, datatype.DateTime(time.Now())).
+		First(&report).
+		Error
+	return report, err
+}
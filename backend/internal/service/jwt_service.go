@@ -2,19 +2,25 @@ package service
 
 import (
 	"context"
+	"crypto"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"gorm.io/gorm"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/secrets"
 )
 
 const (
@@ -33,6 +39,21 @@ const (
 	// This may be omitted on non-admin tokens
 	IsAdminClaim = "isAdmin"
 
+	// AuthTimeClaim is the claim with the time at which the user signed in interactively
+	AuthTimeClaim = "auth_time"
+
+	// AuthMethodsClaim is the claim with the methods the user signed in with, as defined in RFC 8176
+	AuthMethodsClaim = "amr"
+
+	// AuthContextClassClaim is the claim with the authentication context class reference of the sign in
+	AuthContextClassClaim = "acr"
+
+	// ActorClaim is the claim with the party acting on behalf of the subject, as defined in RFC 8693
+	ActorClaim = "act"
+
+	// MayActClaim is the claim with the party that is allowed to act on behalf of the subject, as defined in RFC 8693
+	MayActClaim = "may_act"
+
 	// TokenTypeClaim is the claim used to identify the type of token
 	TokenTypeClaim = "type"
 
@@ -57,14 +78,32 @@ const (
 
 type JwtService struct {
 	envConfig        *common.EnvConfigSchema
-	privateKey       jwk.Key
-	keyId            string
 	appConfigService *AppConfigService
-	jwksEncoded      []byte
+	secretsProvider  secrets.Provider
+	httpClient       *http.Client
+	db               *gorm.DB
+	// keyProvider is set only if the key is managed by Pocket ID and can be rotated
+	keyProvider jwkutils.KeyProvider
+
+	// keyLock protects the keys, which change when the key is rotated
+	keyLock    sync.RWMutex
+	privateKey jwk.Key
+	// kmsSigner signs tokens instead of the private key if the key is stored in a KMS
+	kmsSigner    crypto.Signer
+	publicKey    jwk.Key
+	keyId        string
+	keyCreatedAt time.Time
+	retiredKeys  []retiredSigningKey
+	// keySet contains the current public key and the retired keys that are still accepted
+	keySet      jwk.Set
+	jwksEncoded []byte
 }
 
-func NewJwtService(db *gorm.DB, appConfigService *AppConfigService) (*JwtService, error) {
-	service := &JwtService{}
+func NewJwtService(db *gorm.DB, httpClient *http.Client, appConfigService *AppConfigService, secretsProvider secrets.Provider) (*JwtService, error) {
+	service := &JwtService{
+		secretsProvider: secretsProvider,
+		httpClient:      httpClient,
+	}
 
 	// Ensure keys are generated or loaded
 	err := service.init(db, appConfigService, &common.EnvConfig)
@@ -78,18 +117,53 @@ func NewJwtService(db *gorm.DB, appConfigService *AppConfigService) (*JwtService
 func (s *JwtService) init(db *gorm.DB, appConfigService *AppConfigService, envConfig *common.EnvConfigSchema) (err error) {
 	s.appConfigService = appConfigService
 	s.envConfig = envConfig
+	s.db = db
 
 	// Ensure keys are generated or loaded
-	return s.loadOrGenerateKey(db)
+	err = s.loadOrGenerateKey(db)
+	if err != nil {
+		return err
+	}
+
+	return s.initKeyRotation()
 }
 
 func (s *JwtService) loadOrGenerateKey(db *gorm.DB) error {
+	// With a KMS, the private key is never loaded
+	if s.envConfig.KeysStorage == "kms" {
+		return s.loadKmsKey()
+	}
+
+	// A key from the secrets provider takes precedence over the key storage
+	// The key is read only at startup, so rotating it requires a restart
+	if s.secretsProvider != nil {
+		encoded, err := s.secretsProvider.Get(context.Background(), secrets.JwtPrivateKey)
+		if err == nil {
+			key, err := jwk.ParseKey([]byte(encoded))
+			if err != nil {
+				return fmt.Errorf("failed to parse private key from secrets provider: %w", err)
+			}
+			err = s.SetKey(key)
+			if err != nil {
+				return fmt.Errorf("failed to set private key from secrets provider: %w", err)
+			}
+			return nil
+		} else if !errors.Is(err, secrets.ErrNotFound) {
+			return fmt.Errorf("failed to load private key from secrets provider: %w", err)
+		}
+	}
+
 	// Get the key provider
 	keyProvider, err := jwkutils.GetKeyProvider(db, s.envConfig, s.appConfigService.GetDbConfig().InstanceID.Value)
 	if err != nil {
 		return fmt.Errorf("failed to get key provider: %w", err)
 	}
 
+	// Keys that aren't provided by the user can be rotated
+	if s.envConfig.KeysStorage == "file" || s.envConfig.KeysStorage == "database" {
+		s.keyProvider = keyProvider
+	}
+
 	// Try loading a key
 	key, err := keyProvider.LoadKey()
 	if err != nil {
@@ -117,15 +191,65 @@ func (s *JwtService) loadOrGenerateKey(db *gorm.DB) error {
 		return fmt.Errorf("failed to save private key (provider type '%s'): %w", s.envConfig.KeysStorage, err)
 	}
 
+	// Reload the key, so it's the same as after a restart (e.g. PEM files don't store the key ID)
+	key, err = keyProvider.LoadKey()
+	if err != nil {
+		return fmt.Errorf("failed to load saved key (provider type '%s'): %w", s.envConfig.KeysStorage, err)
+	}
+	err = s.SetKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to set private key: %w", err)
+	}
+
 	return nil
 }
 
+// loadKmsKey configures the service to sign tokens with a key stored in a KMS
+func (s *JwtService) loadKmsKey() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	signer, err := jwkutils.NewVaultTransitSigner(ctx, s.httpClient, s.envConfig.KeysKmsVaultAddr, s.envConfig.KeysKmsVaultToken, s.envConfig.KeysKmsVaultMount, s.envConfig.KeysKmsVaultKey)
+	if err != nil {
+		return fmt.Errorf("failed to load key from KMS: %w", err)
+	}
+
+	return s.setKmsSigner(signer)
+}
+
+// setKmsSigner sets the signer used to sign tokens with a key that is stored in a KMS
+func (s *JwtService) setKmsSigner(signer crypto.Signer) error {
+	publicKey, err := jwk.Import(signer.Public())
+	if err != nil {
+		return fmt.Errorf("failed to import public key: %w", err)
+	}
+
+	// The key ID must be the same after a restart
+	err = jwkutils.SetThumbprintKeyID(publicKey)
+	if err != nil {
+		return err
+	}
+	_ = publicKey.Set(jwk.KeyUsageKey, KeyUsageSigning)
+	alg := s.envConfig.KeysAlgorithm
+	if alg == "" {
+		alg = jwkutils.DefaultKeyAlgorithm(signer.Public())
+	}
+	_ = publicKey.Set(jwk.AlgorithmKey, alg)
+
+	err = jwkutils.ValidateKeyAlgorithm(publicKey)
+	if err != nil {
+		return fmt.Errorf("key is not valid: %w", err)
+	}
+
+	return s.setSigningKey(nil, signer, publicKey)
+}
+
 // generateKey generates a new key and stores it in the object
 func (s *JwtService) generateKey() error {
 	// Default is to generate RS256 (RSA-2048) keys
-	key, err := jwkutils.GenerateKey(jwa.RS256().String(), "")
+	key, err := s.newPrivateKey(jwa.RS256().String())
 	if err != nil {
-		return fmt.Errorf("failed to generate new private key: %w", err)
+		return err
 	}
 
 	// Set the key in the object, which also validates it
@@ -137,6 +261,24 @@ func (s *JwtService) generateKey() error {
 	return nil
 }
 
+// newPrivateKey generates a new private key with the configured algorithm, or with the default algorithm if none is configured
+func (s *JwtService) newPrivateKey(defaultAlg string) (jwk.Key, error) {
+	alg := s.envConfig.KeysAlgorithm
+	if alg == "" {
+		alg = defaultAlg
+	}
+	var crv string
+	if alg == jwa.EdDSA().String() {
+		crv = jwa.Ed25519().String()
+	}
+
+	key, err := jwkutils.GenerateKey(alg, crv)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new private key: %w", err)
+	}
+	return key, nil
+}
+
 func ValidateKey(privateKey jwk.Key) error {
 	// Validate the loaded key
 	err := privateKey.Validate()
@@ -155,6 +297,10 @@ func ValidateKey(privateKey jwk.Key) error {
 	if err != nil || !ok {
 		return errors.New("key object is not a private key")
 	}
+	err = jwkutils.ValidateKeyAlgorithm(privateKey)
+	if err != nil {
+		return fmt.Errorf("key object is invalid: %w", err)
+	}
 
 	return nil
 }
@@ -166,39 +312,163 @@ func (s *JwtService) SetKey(privateKey jwk.Key) error {
 		return fmt.Errorf("private key is not valid: %w", err)
 	}
 
-	// Set the private key and key id in the object
-	s.privateKey = privateKey
+	publicKey, err := privateKey.PublicKey()
+	if err != nil {
+		return fmt.Errorf("failed to get public key: %w", err)
+	}
+	jwkutils.EnsureAlgInKey(publicKey, "", "")
 
-	keyId, ok := privateKey.KeyID()
+	return s.setSigningKey(privateKey, nil, publicKey)
+}
+
+// setSigningKey replaces the key used to sign tokens; either the private key or the KMS signer must be set
+func (s *JwtService) setSigningKey(privateKey jwk.Key, kmsSigner crypto.Signer, publicKey jwk.Key) error {
+	keyId, ok := publicKey.KeyID()
 	if !ok {
 		return errors.New("key object does not contain a key ID")
 	}
+
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+
+	s.privateKey = privateKey
+	s.kmsSigner = kmsSigner
+	s.publicKey = publicKey
 	s.keyId = keyId
 
-	// Create and encode a JWKS containing the public key
-	publicKey, err := s.GetPublicJWK()
-	if err != nil {
-		return fmt.Errorf("failed to get public JWK: %w", err)
-	}
-	jwks := jwk.NewSet()
-	err = jwks.AddKey(publicKey)
+	return s.updateKeySetLocked()
+}
+
+// updateKeySetLocked builds the key set, and the encoded JWKS, with the current key and the retired keys that are still accepted.
+// The caller must hold the key lock.
+func (s *JwtService) updateKeySetLocked() error {
+	keySet := jwk.NewSet()
+	err := keySet.AddKey(s.publicKey)
 	if err != nil {
 		return fmt.Errorf("failed to add public key to JWKS: %w", err)
 	}
-	s.jwksEncoded, err = json.Marshal(jwks)
+
+	now := time.Now()
+	for _, retired := range s.retiredKeys {
+		if retired.keyID == s.keyId || s.retiredKeyExpired(retired, now) {
+			continue
+		}
+		err = keySet.AddKey(retired.publicKey)
+		if err != nil {
+			return fmt.Errorf("failed to add retired public key to JWKS: %w", err)
+		}
+	}
+
+	jwksEncoded, err := json.Marshal(keySet)
 	if err != nil {
 		return fmt.Errorf("failed to encode JWKS to JSON: %w", err)
 	}
 
+	s.keySet = keySet
+	s.jwksEncoded = jwksEncoded
+
 	return nil
 }
 
-func (s *JwtService) GenerateAccessToken(user model.User) (string, error) {
+// signingKey returns the option to sign tokens with the private key, or with the KMS
+func (s *JwtService) signingKey() jwt.SignEncryptParseOption {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
+	alg, _ := s.publicKey.Algorithm()
+	if s.kmsSigner != nil {
+		// The key ID is set by jwx only for JWKs
+		headers := jws.NewHeaders()
+		_ = headers.Set(jws.KeyIDKey, s.keyId)
+		return jwt.WithKey(alg, s.kmsSigner, jws.WithProtectedHeaders(headers))
+	}
+	return jwt.WithKey(alg, s.privateKey)
+}
+
+// verificationKeys returns the option to verify tokens with the current key, or with a retired key that is still accepted
+func (s *JwtService) verificationKeys() jwt.ParseOption {
+	s.keyLock.RLock()
+	keySet := s.keySet
+	publicKey := s.publicKey
+	s.keyLock.RUnlock()
+
+	return jwt.WithKeyProvider(jws.KeyProviderFunc(func(_ context.Context, sink jws.KeySink, sig *jws.Signature, _ *jws.Message) error {
+		// Tokens with an unknown key ID are verified with the current key, which fails
+		key := publicKey
+		if kid, ok := sig.ProtectedHeaders().KeyID(); ok {
+			if retiredKey, found := keySet.LookupKeyID(kid); found {
+				key = retiredKey
+			}
+		}
+
+		keyAlg, ok := key.Algorithm()
+		if !ok {
+			return errors.New("key does not have an algorithm")
+		}
+		alg, ok := jwa.LookupSignatureAlgorithm(keyAlg.String())
+		if !ok {
+			return fmt.Errorf("unsupported key algorithm '%s'", keyAlg.String())
+		}
+		sink.Key(alg, key)
+		return nil
+	}))
+}
+
+// GenerateAccessToken generates the token of the session of the user.
+// authMethods are the AMR values of the sign in method; they're empty if the user didn't authenticate, e.g. after signing up.
+func (s *JwtService) GenerateAccessToken(user model.User, authMethods []string) (string, error) {
+	now := time.Now()
+	return s.generateAccessToken(user, authMethods, now, now, s.SessionExpiration(now, now))
+}
+
+// RenewAccessToken issues a new token for the session of the token, to slide the idle timeout of the session.
+// The time and methods of the sign in are kept, so the absolute lifetime of the session isn't extended.
+// It returns an empty string if the session doesn't have an idle timeout, or if the token was issued less than a minute ago.
+func (s *JwtService) RenewAccessToken(user model.User, token jwt.Token) (renewed string, expiration time.Time, err error) {
+	if s.appConfigService.GetDbConfig().SessionIdleTimeout.AsDurationMinutes() <= 0 {
+		return "", time.Time{}, nil
+	}
+
 	now := time.Now()
+	issuedAt, _ := token.IssuedAt()
+	if now.Sub(issuedAt) < time.Minute {
+		// Avoid signing a new token on every request
+		return "", time.Time{}, nil
+	}
+
+	authTime, err := GetAuthTime(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	authMethods, err := GetAuthMethods(token)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiration = s.SessionExpiration(authTime, now)
+	renewed, err = s.generateAccessToken(user, authMethods, authTime, now, expiration)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return renewed, expiration, nil
+}
+
+// SessionExpiration returns the time at which a session started at authTime expires if it's inactive from now on.
+// That's after the idle timeout, if set, but never after the absolute lifetime of the session.
+func (s *JwtService) SessionExpiration(authTime time.Time, now time.Time) time.Time {
+	cfg := s.appConfigService.GetDbConfig()
+	expiration := authTime.Add(cfg.SessionDuration.AsDurationMinutes())
+	if idleTimeout := cfg.SessionIdleTimeout.AsDurationMinutes(); idleTimeout > 0 && now.Add(idleTimeout).Before(expiration) {
+		expiration = now.Add(idleTimeout)
+	}
+	return expiration
+}
+
+func (s *JwtService) generateAccessToken(user model.User, authMethods []string, authTime time.Time, issuedAt time.Time, expiration time.Time) (string, error) {
 	token, err := jwt.NewBuilder().
 		Subject(user.ID).
-		Expiration(now.Add(s.appConfigService.GetDbConfig().SessionDuration.AsDurationMinutes())).
-		IssuedAt(now).
+		Expiration(expiration).
+		IssuedAt(issuedAt).
 		Issuer(s.envConfig.AppURL).
 		Build()
 	if err != nil {
@@ -220,8 +490,20 @@ func (s *JwtService) GenerateAccessToken(user model.User) (string, error) {
 		return "", fmt.Errorf("failed to set 'isAdmin' claim in token: %w", err)
 	}
 
-	alg, _ := s.privateKey.Algorithm()
-	signed, err := jwt.Sign(token, jwt.WithKey(alg, s.privateKey))
+	// Access tokens are only issued after an interactive sign in
+	err = token.Set(AuthTimeClaim, authTime.Unix())
+	if err != nil {
+		return "", fmt.Errorf("failed to set 'auth_time' claim in token: %w", err)
+	}
+
+	if len(authMethods) > 0 {
+		err = token.Set(AuthMethodsClaim, authMethods)
+		if err != nil {
+			return "", fmt.Errorf("failed to set 'amr' claim in token: %w", err)
+		}
+	}
+
+	signed, err := jwt.Sign(token, s.signingKey())
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -230,11 +512,10 @@ func (s *JwtService) GenerateAccessToken(user model.User) (string, error) {
 }
 
 func (s *JwtService) VerifyAccessToken(tokenString string) (jwt.Token, error) {
-	alg, _ := s.privateKey.Algorithm()
 	token, err := jwt.ParseString(
 		tokenString,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.privateKey),
+		s.verificationKeys(),
 		jwt.WithAcceptableSkew(clockSkew),
 		jwt.WithAudience(s.envConfig.AppURL),
 		jwt.WithIssuer(s.envConfig.AppURL),
@@ -293,8 +574,7 @@ func (s *JwtService) GenerateIDToken(userClaims map[string]any, clientID string,
 		return "", err
 	}
 
-	alg, _ := s.privateKey.Algorithm()
-	signed, err := jwt.Sign(token, jwt.WithKey(alg, s.privateKey))
+	signed, err := jwt.Sign(token, s.signingKey())
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -303,14 +583,12 @@ func (s *JwtService) GenerateIDToken(userClaims map[string]any, clientID string,
 }
 
 func (s *JwtService) VerifyIdToken(tokenString string, acceptExpiredTokens bool) (jwt.Token, error) {
-	alg, _ := s.privateKey.Algorithm()
-
 	opts := make([]jwt.ParseOption, 0)
 
 	// These options are always present
 	opts = append(opts,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.privateKey),
+		s.verificationKeys(),
 		jwt.WithAcceptableSkew(clockSkew),
 		jwt.WithIssuer(s.envConfig.AppURL),
 		jwt.WithValidator(TokenTypeValidator(IDTokenJWTType)),
@@ -335,11 +613,13 @@ func (s *JwtService) VerifyIdToken(tokenString string, acceptExpiredTokens bool)
 	return token, nil
 }
 
-// BuildOAuthAccessToken creates an OAuth access token with all claims
-func (s *JwtService) BuildOAuthAccessToken(user model.User, clientID string) (jwt.Token, error) {
+// BuildOAuthAccessToken creates an OAuth access token with all claims.
+// The subject is the ID of the user, or the pairwise subject of the user for clients that use pairwise subjects.
+// The client ID is the first audience, followed by the additional audiences if any.
+func (s *JwtService) BuildOAuthAccessToken(subject string, clientID string, audiences []string) (jwt.Token, error) {
 	now := time.Now()
 	token, err := jwt.NewBuilder().
-		Subject(user.ID).
+		Subject(subject).
 		Expiration(now.Add(1 * time.Hour)).
 		IssuedAt(now).
 		Issuer(s.envConfig.AppURL).
@@ -348,7 +628,7 @@ func (s *JwtService) BuildOAuthAccessToken(user model.User, clientID string) (jw
 		return nil, fmt.Errorf("failed to build token: %w", err)
 	}
 
-	err = SetAudienceString(token, clientID)
+	err = setAccessTokenAudience(token, clientID, audiences)
 	if err != nil {
 		return nil, fmt.Errorf("failed to set 'aud' claim in token: %w", err)
 	}
@@ -362,14 +642,80 @@ func (s *JwtService) BuildOAuthAccessToken(user model.User, clientID string) (jw
 }
 
 // GenerateOAuthAccessToken creates and signs an OAuth access token
-func (s *JwtService) GenerateOAuthAccessToken(user model.User, clientID string) (string, error) {
-	token, err := s.BuildOAuthAccessToken(user, clientID)
+func (s *JwtService) GenerateOAuthAccessToken(subject string, clientID string, audiences []string) (string, error) {
+	token, err := s.BuildOAuthAccessToken(subject, clientID, audiences)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := jwt.Sign(token, s.signingKey())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return string(signed), nil
+}
+
+// GenerateExchangedOAuthAccessToken creates and signs an OAuth access token issued with the token exchange grant.
+// If actor isn't nil, it's set as the "act" claim, which records the delegation chain (RFC 8693, section 4.1).
+// The token ID is set as the "jti" claim, so the chain can be checked against the stored exchange.
+func (s *JwtService) GenerateExchangedOAuthAccessToken(subject string, clientID string, audiences []string, actor map[string]any, tokenID string) (string, error) {
+	token, err := s.BuildOAuthAccessToken(subject, clientID, audiences)
 	if err != nil {
 		return "", err
 	}
 
-	alg, _ := s.privateKey.Algorithm()
-	signed, err := jwt.Sign(token, jwt.WithKey(alg, s.privateKey))
+	err = token.Set(jwt.JwtIDKey, tokenID)
+	if err != nil {
+		return "", fmt.Errorf("failed to set 'jti' claim in token: %w", err)
+	}
+
+	if actor != nil {
+		err = token.Set(ActorClaim, actor)
+		if err != nil {
+			return "", fmt.Errorf("failed to set 'act' claim in token: %w", err)
+		}
+	}
+
+	signed, err := jwt.Sign(token, s.signingKey())
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return string(signed), nil
+}
+
+// GenerateClientCredentialsAccessToken creates and signs an OAuth access token that represents the client itself, issued with the client_credentials grant
+func (s *JwtService) GenerateClientCredentialsAccessToken(clientID string, scope string, audiences []string) (string, error) {
+	now := time.Now()
+	token, err := jwt.NewBuilder().
+		Subject(clientID).
+		Expiration(now.Add(1 * time.Hour)).
+		IssuedAt(now).
+		Issuer(s.envConfig.AppURL).
+		Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build token: %w", err)
+	}
+
+	err = setAccessTokenAudience(token, clientID, audiences)
+	if err != nil {
+		return "", fmt.Errorf("failed to set 'aud' claim in token: %w", err)
+	}
+
+	if scope != "" {
+		err = token.Set("scope", scope)
+		if err != nil {
+			return "", fmt.Errorf("failed to set 'scope' claim in token: %w", err)
+		}
+	}
+
+	err = SetTokenType(token, OAuthAccessTokenJWTType)
+	if err != nil {
+		return "", fmt.Errorf("failed to set 'type' claim in token: %w", err)
+	}
+
+	signed, err := jwt.Sign(token, s.signingKey())
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -378,11 +724,10 @@ func (s *JwtService) GenerateOAuthAccessToken(user model.User, clientID string)
 }
 
 func (s *JwtService) VerifyOAuthAccessToken(tokenString string) (jwt.Token, error) {
-	alg, _ := s.privateKey.Algorithm()
 	token, err := jwt.ParseString(
 		tokenString,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.privateKey),
+		s.verificationKeys(),
 		jwt.WithAcceptableSkew(clockSkew),
 		jwt.WithIssuer(s.envConfig.AppURL),
 		jwt.WithValidator(TokenTypeValidator(OAuthAccessTokenJWTType)),
@@ -421,8 +766,7 @@ func (s *JwtService) GenerateOAuthRefreshToken(userID string, clientID string, r
 		return "", fmt.Errorf("failed to set 'type' claim in token: %w", err)
 	}
 
-	alg, _ := s.privateKey.Algorithm()
-	signed, err := jwt.Sign(token, jwt.WithKey(alg, s.privateKey))
+	signed, err := jwt.Sign(token, s.signingKey())
 	if err != nil {
 		return "", fmt.Errorf("failed to sign token: %w", err)
 	}
@@ -431,11 +775,10 @@ func (s *JwtService) GenerateOAuthRefreshToken(userID string, clientID string, r
 }
 
 func (s *JwtService) VerifyOAuthRefreshToken(tokenString string) (userID, clientID, rt string, err error) {
-	alg, _ := s.privateKey.Algorithm()
 	token, err := jwt.ParseString(
 		tokenString,
 		jwt.WithValidate(true),
-		jwt.WithKey(alg, s.privateKey),
+		s.verificationKeys(),
 		jwt.WithAcceptableSkew(clockSkew),
 		jwt.WithIssuer(s.envConfig.AppURL),
 		jwt.WithValidator(TokenTypeValidator(OAuthRefreshTokenJWTType)),
@@ -486,23 +829,22 @@ func (s *JwtService) GetTokenType(tokenString string) (string, jwt.Token, error)
 
 // GetPublicJWK returns the JSON Web Key (JWK) for the public key.
 func (s *JwtService) GetPublicJWK() (jwk.Key, error) {
-	if s.privateKey == nil {
-		return nil, errors.New("key is not initialized")
-	}
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
 
-	pubKey, err := s.privateKey.PublicKey()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get public key: %w", err)
+	if s.publicKey == nil {
+		return nil, errors.New("key is not initialized")
 	}
 
-	jwkutils.EnsureAlgInKey(pubKey, "", "")
-
-	return pubKey, nil
+	return s.publicKey, nil
 }
 
-// GetPublicJWKSAsJSON returns the JSON Web Key Set (JWKS) for the public key, encoded as JSON.
-// The value is cached since the key is static.
+// GetPublicJWKSAsJSON returns the JSON Web Key Set (JWKS) for the public key and the retired keys that are still accepted, encoded as JSON.
+// The value is cached and updated when the key is rotated.
 func (s *JwtService) GetPublicJWKSAsJSON() ([]byte, error) {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
 	if len(s.jwksEncoded) == 0 {
 		return nil, errors.New("key is not initialized")
 	}
@@ -512,11 +854,14 @@ func (s *JwtService) GetPublicJWKSAsJSON() ([]byte, error) {
 
 // GetKeyAlg returns the algorithm of the key
 func (s *JwtService) GetKeyAlg() (jwa.KeyAlgorithm, error) {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
 	if len(s.jwksEncoded) == 0 {
 		return nil, errors.New("key is not initialized")
 	}
 
-	alg, ok := s.privateKey.Algorithm()
+	alg, ok := s.publicKey.Algorithm()
 	if !ok || alg == nil {
 		return nil, errors.New("failed to retrieve algorithm for key")
 	}
@@ -524,6 +869,36 @@ func (s *JwtService) GetKeyAlg() (jwa.KeyAlgorithm, error) {
 	return alg, nil
 }
 
+// GetSigningAlgs returns the algorithms of the keys in the JWKS, starting with the algorithm of the current key.
+// Tokens signed with a retired key are still valid, so their algorithm is included too until the key is removed.
+func (s *JwtService) GetSigningAlgs() ([]string, error) {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
+	if s.keySet == nil {
+		return nil, errors.New("key is not initialized")
+	}
+
+	currentAlg, ok := s.publicKey.Algorithm()
+	if !ok || currentAlg == nil {
+		return nil, errors.New("failed to retrieve algorithm for key")
+	}
+
+	algs := []string{currentAlg.String()}
+	for i := range s.keySet.Len() {
+		key, _ := s.keySet.Key(i)
+		alg, ok := key.Algorithm()
+		if !ok || alg == nil {
+			continue
+		}
+		if !slices.Contains(algs, alg.String()) {
+			algs = append(algs, alg.String())
+		}
+	}
+
+	return algs, nil
+}
+
 // GetIsAdmin returns the value of the "isAdmin" claim in the token
 func GetIsAdmin(token jwt.Token) (bool, error) {
 	if !token.Has(IsAdminClaim) {
@@ -537,6 +912,43 @@ func GetIsAdmin(token jwt.Token) (bool, error) {
 	return isAdmin, nil
 }
 
+// GetAuthTime returns the value of the "auth_time" claim in the token
+// Tokens issued before the claim was added fall back to the "iat" claim, because they were never renewed
+func GetAuthTime(token jwt.Token) (time.Time, error) {
+	if !token.Has(AuthTimeClaim) {
+		issuedAt, _ := token.IssuedAt()
+		return issuedAt, nil
+	}
+	var authTime float64
+	err := token.Get(AuthTimeClaim, &authTime)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get 'auth_time' claim from token: %w", err)
+	}
+	return time.Unix(int64(authTime), 0), nil
+}
+
+// GetAuthMethods returns the value of the "amr" claim in the token
+func GetAuthMethods(token jwt.Token) ([]string, error) {
+	if !token.Has(AuthMethodsClaim) {
+		return nil, nil
+	}
+	// Parsed tokens contain a generic slice
+	var values []any
+	err := token.Get(AuthMethodsClaim, &values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get 'amr' claim from token: %w", err)
+	}
+	authMethods := make([]string, len(values))
+	for i, v := range values {
+		value, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid value in 'amr' claim: %v", v)
+		}
+		authMethods[i] = value
+	}
+	return authMethods, nil
+}
+
 // SetTokenType sets the "type" claim in the token
 func SetTokenType(token jwt.Token, tokenType string) error {
 	if tokenType == "" {
@@ -560,6 +972,15 @@ func SetAudienceString(token jwt.Token, audience string) error {
 	return token.Set(jwt.AudienceKey, audience)
 }
 
+// setAccessTokenAudience sets the "aud" claim of an access token.
+// Without additional audiences, the claim is the client ID as a string, like before audiences could be configured.
+func setAccessTokenAudience(token jwt.Token, clientID string, audiences []string) error {
+	if len(audiences) == 0 {
+		return SetAudienceString(token, clientID)
+	}
+	return token.Set(jwt.AudienceKey, append([]string{clientID}, audiences...))
+}
+
 // TokenTypeValidator is a validator function that checks the "type" claim in the token
 func TokenTypeValidator(expectedTokenType string) jwt.ValidatorFunc {
 	return func(_ context.Context, t jwt.Token) error {
@@ -0,0 +1,346 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	jwkutils "github.com/pocket-id/pocket-id/backend/internal/utils/jwk"
+)
+
+const (
+	// Key of the row in the kv table that contains the state of the key rotation
+	keyRotationKVKey = "jwt_key_rotation.json"
+
+	// Interval at which every replica checks whether the key was rotated by another replica
+	keySyncInterval = time.Minute
+)
+
+// keyRotationState is stored in the kv table so that every replica knows when the current key was created and which keys were retired.
+// Retired keys are public keys, so they don't need to be encrypted.
+type keyRotationState struct {
+	KeyID       string            `json:"keyId"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	RetiredKeys []retiredKeyState `json:"retiredKeys,omitempty"`
+}
+
+type retiredKeyState struct {
+	Key       json.RawMessage `json:"key"`
+	RetiredAt time.Time       `json:"retiredAt"`
+}
+
+// retiredSigningKey is a key that isn't used to sign tokens anymore, but is still accepted until its grace period ends
+type retiredSigningKey struct {
+	keyID     string
+	publicKey jwk.Key
+	retiredAt time.Time
+}
+
+// SigningKeyStatus describes the key used to sign tokens
+type SigningKeyStatus struct {
+	KeyID     string
+	CreatedAt time.Time
+	// NextRotationAt is nil if the key isn't rotated automatically
+	NextRotationAt *time.Time
+}
+
+// KeyRotationEnabled returns true if the key is rotated automatically
+func (s *JwtService) KeyRotationEnabled() bool {
+	return s.keyProvider != nil && s.db != nil && s.envConfig.KeysRotationInterval > 0
+}
+
+// SigningKeyStatus returns the ID and age of the key used to sign tokens, and when it's rotated next
+func (s *JwtService) SigningKeyStatus() SigningKeyStatus {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
+	status := SigningKeyStatus{
+		KeyID:     s.keyId,
+		CreatedAt: s.keyCreatedAt,
+	}
+	if s.KeyRotationEnabled() && !s.keyCreatedAt.IsZero() {
+		nextRotationAt := s.keyCreatedAt.Add(s.envConfig.KeysRotationInterval)
+		status.NextRotationAt = &nextRotationAt
+	}
+
+	return status
+}
+
+// initKeyRotation loads the retired keys and records when the current key was created, if the key is managed by Pocket ID
+func (s *JwtService) initKeyRotation() error {
+	if s.keyProvider == nil || s.db == nil {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	state, err := s.loadKeyRotationState(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The key is new, or it was replaced manually
+	if state.KeyID != s.keyId {
+		state.KeyID = s.keyId
+		state.CreatedAt = time.Now()
+		err = s.saveKeyRotationState(ctx, state)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.applyKeyRotationState(state)
+}
+
+// RotateKeyIfDue replaces the current key with a new one if the rotation interval has passed, and removes the retired keys whose grace period has ended.
+// This should be run by one replica only; the other replicas pick up the new key with SyncKeys.
+func (s *JwtService) RotateKeyIfDue(ctx context.Context) (rotated bool, err error) {
+	if !s.KeyRotationEnabled() {
+		return false, nil
+	}
+
+	state, err := s.loadKeyRotationState(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	// Make sure we're using the latest key before replacing it
+	if state.KeyID != s.currentKeyID() {
+		err = s.SyncKeys(ctx)
+		if err != nil {
+			return false, err
+		}
+		state, err = s.loadKeyRotationState(ctx)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	now := time.Now()
+	if now.Before(state.CreatedAt.Add(s.envConfig.KeysRotationInterval)) {
+		// Not due yet, but retired keys may have expired
+		if s.pruneRetiredKeys(&state, now) {
+			err = s.saveKeyRotationState(ctx, state)
+			if err != nil {
+				return false, err
+			}
+		}
+		return false, s.applyKeyRotationState(state)
+	}
+
+	err = s.rotateKey(ctx, state, now)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *JwtService) rotateKey(ctx context.Context, state keyRotationState, now time.Time) error {
+	currentKey, err := s.GetPublicJWK()
+	if err != nil {
+		return err
+	}
+	currentKeyID, _ := currentKey.KeyID()
+	currentKeyData, err := json.Marshal(currentKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode current public key: %w", err)
+	}
+
+	// Keep the algorithm of the current key, unless one is configured
+	defaultAlg, ok := currentKey.Algorithm()
+	if !ok {
+		return errors.New("current key does not have an algorithm")
+	}
+	newKey, err := s.newPrivateKey(defaultAlg.String())
+	if err != nil {
+		return err
+	}
+	newKeyID, _ := newKey.KeyID()
+
+	// The state is saved before the key: if saving the key fails, the current key remains in use and is treated as a new key after a restart
+	state.RetiredKeys = append(state.RetiredKeys, retiredKeyState{Key: currentKeyData, RetiredAt: now})
+	state.KeyID = newKeyID
+	state.CreatedAt = now
+	s.pruneRetiredKeys(&state, now)
+	err = s.saveKeyRotationState(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	err = jwkutils.ReplaceKey(s.keyProvider, newKey)
+	if err != nil {
+		return fmt.Errorf("failed to save new private key: %w", err)
+	}
+
+	err = s.SetKey(newKey)
+	if err != nil {
+		return fmt.Errorf("failed to set new private key: %w", err)
+	}
+
+	slog.InfoContext(ctx, "Rotated the signing key", slog.String("keyId", newKeyID), slog.String("previousKeyId", currentKeyID))
+
+	return s.applyKeyRotationState(state)
+}
+
+// SyncKeys reloads the key if it was rotated by another replica, and the list of retired keys
+func (s *JwtService) SyncKeys(ctx context.Context) error {
+	if s.keyProvider == nil || s.db == nil {
+		return nil
+	}
+
+	state, err := s.loadKeyRotationState(ctx)
+	if err != nil {
+		return err
+	}
+
+	if state.KeyID != "" && state.KeyID != s.currentKeyID() {
+		key, err := s.keyProvider.LoadKey()
+		if err != nil {
+			return fmt.Errorf("failed to load rotated key: %w", err)
+		}
+		if key == nil {
+			return errors.New("rotated key not found")
+		}
+
+		// The state may be saved before the key, so load the new key only once it's there
+		keyID, _ := key.KeyID()
+		if keyID == state.KeyID {
+			err = s.SetKey(key)
+			if err != nil {
+				return fmt.Errorf("failed to set rotated key: %w", err)
+			}
+		}
+	}
+
+	return s.applyKeyRotationState(state)
+}
+
+// RunKeySync keeps the key in sync with the other replicas until the context is canceled
+func (s *JwtService) RunKeySync(ctx context.Context) error {
+	if !s.KeyRotationEnabled() {
+		return nil
+	}
+
+	ticker := time.NewTicker(keySyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := s.SyncKeys(ctx)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.ErrorContext(ctx, "Failed to sync signing keys", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (s *JwtService) currentKeyID() string {
+	s.keyLock.RLock()
+	defer s.keyLock.RUnlock()
+
+	return s.keyId
+}
+
+// applyKeyRotationState sets the retired keys and the creation time of the current key from the state
+func (s *JwtService) applyKeyRotationState(state keyRotationState) error {
+	retiredKeys := make([]retiredSigningKey, 0, len(state.RetiredKeys))
+	for _, retired := range state.RetiredKeys {
+		publicKey, err := jwk.ParseKey(retired.Key)
+		if err != nil {
+			return fmt.Errorf("failed to parse retired public key: %w", err)
+		}
+		keyID, _ := publicKey.KeyID()
+		retiredKeys = append(retiredKeys, retiredSigningKey{
+			keyID:     keyID,
+			publicKey: publicKey,
+			retiredAt: retired.RetiredAt,
+		})
+	}
+
+	s.keyLock.Lock()
+	defer s.keyLock.Unlock()
+
+	s.retiredKeys = retiredKeys
+	if state.KeyID == s.keyId {
+		s.keyCreatedAt = state.CreatedAt
+	}
+
+	return s.updateKeySetLocked()
+}
+
+// pruneRetiredKeys removes the retired keys whose grace period has ended and returns true if any key was removed
+func (s *JwtService) pruneRetiredKeys(state *keyRotationState, now time.Time) bool {
+	retiredKeys := state.RetiredKeys[:0]
+	for _, retired := range state.RetiredKeys {
+		if now.Before(retired.RetiredAt.Add(s.envConfig.KeysRotationGracePeriod)) {
+			retiredKeys = append(retiredKeys, retired)
+		}
+	}
+
+	pruned := len(retiredKeys) != len(state.RetiredKeys)
+	state.RetiredKeys = retiredKeys
+	return pruned
+}
+
+func (s *JwtService) retiredKeyExpired(retired retiredSigningKey, now time.Time) bool {
+	return !now.Before(retired.retiredAt.Add(s.envConfig.KeysRotationGracePeriod))
+}
+
+func (s *JwtService) loadKeyRotationState(ctx context.Context) (state keyRotationState, err error) {
+	var row model.KV
+	err = s.db.
+		WithContext(ctx).
+		Where("key = ?", keyRotationKVKey).
+		First(&row).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return state, nil
+	} else if err != nil {
+		return state, fmt.Errorf("failed to load key rotation state: %w", err)
+	}
+
+	if row.Value == nil || *row.Value == "" {
+		return state, nil
+	}
+
+	err = json.Unmarshal([]byte(*row.Value), &state)
+	if err != nil {
+		return state, fmt.Errorf("failed to decode key rotation state: %w", err)
+	}
+
+	return state, nil
+}
+
+func (s *JwtService) saveKeyRotationState(ctx context.Context, state keyRotationState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode key rotation state: %w", err)
+	}
+	value := string(data)
+
+	err = s.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&model.KV{Key: keyRotationKVKey, Value: &value}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to save key rotation state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestJwtService_KeyRotation(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	mockConfig := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration: model.AppConfigVariable{Value: "60"},
+	})
+	envConfig := &common.EnvConfigSchema{
+		AppURL:                  "https://test.example.com",
+		KeysStorage:             "database",
+		EncryptionKey:           "0123456789abcdef0123456789abcdef",
+		KeysAlgorithm:           "ES256",
+		KeysRotationInterval:    24 * time.Hour,
+		KeysRotationGracePeriod: time.Hour,
+	}
+
+	leader := &JwtService{}
+	require.NoError(t, leader.init(db, mockConfig, envConfig))
+	replica := &JwtService{}
+	require.NoError(t, replica.init(db, mockConfig, envConfig))
+
+	require.True(t, leader.KeyRotationEnabled())
+	oldKeyID := leader.keyId
+	assert.Equal(t, oldKeyID, replica.keyId)
+
+	status := leader.SigningKeyStatus()
+	assert.Equal(t, oldKeyID, status.KeyID)
+	assert.WithinDuration(t, time.Now(), status.CreatedAt, time.Minute)
+	require.NotNil(t, status.NextRotationAt)
+	assert.Equal(t, status.CreatedAt.Add(24*time.Hour), *status.NextRotationAt)
+
+	oldToken, err := leader.GenerateAccessToken(model.User{Base: model.Base{ID: "user"}}, nil)
+	require.NoError(t, err)
+
+	t.Run("does not rotate the key before the interval", func(t *testing.T) {
+		rotated, err := leader.RotateKeyIfDue(t.Context())
+		require.NoError(t, err)
+		assert.False(t, rotated)
+		assert.Equal(t, oldKeyID, leader.keyId)
+	})
+
+	t.Run("rotates the key after the interval", func(t *testing.T) {
+		state, err := leader.loadKeyRotationState(t.Context())
+		require.NoError(t, err)
+		state.CreatedAt = time.Now().Add(-25 * time.Hour)
+		require.NoError(t, leader.saveKeyRotationState(t.Context(), state))
+
+		rotated, err := leader.RotateKeyIfDue(t.Context())
+		require.NoError(t, err)
+		assert.True(t, rotated)
+		assert.NotEqual(t, oldKeyID, leader.keyId)
+
+		// Tokens signed with the retired key are still valid, and the JWKS contains both keys
+		_, err = leader.VerifyAccessToken(oldToken)
+		require.NoError(t, err)
+		assert.Equal(t, 2, leader.keySet.Len())
+	})
+
+	t.Run("other replicas pick up the new key", func(t *testing.T) {
+		require.NoError(t, replica.SyncKeys(t.Context()))
+		assert.Equal(t, leader.keyId, replica.keyId)
+
+		newToken, err := leader.GenerateAccessToken(model.User{Base: model.Base{ID: "user"}}, nil)
+		require.NoError(t, err)
+		_, err = replica.VerifyAccessToken(newToken)
+		require.NoError(t, err)
+		_, err = replica.VerifyAccessToken(oldToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("the new key is loaded after a restart", func(t *testing.T) {
+		restarted := &JwtService{}
+		require.NoError(t, restarted.init(db, mockConfig, envConfig))
+		assert.Equal(t, leader.keyId, restarted.keyId)
+		_, err := restarted.VerifyAccessToken(oldToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("retired keys are removed after the grace period", func(t *testing.T) {
+		state, err := leader.loadKeyRotationState(t.Context())
+		require.NoError(t, err)
+		require.Len(t, state.RetiredKeys, 1)
+		state.RetiredKeys[0].RetiredAt = time.Now().Add(-2 * time.Hour)
+		require.NoError(t, leader.saveKeyRotationState(t.Context(), state))
+
+		_, err = leader.RotateKeyIfDue(t.Context())
+		require.NoError(t, err)
+
+		state, err = leader.loadKeyRotationState(t.Context())
+		require.NoError(t, err)
+		assert.Empty(t, state.RetiredKeys)
+		assert.Equal(t, 1, leader.keySet.Len())
+		_, err = leader.VerifyAccessToken(oldToken)
+		require.Error(t, err)
+	})
+}
+
+func TestJwtService_KeyRotationDisabled(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	mockConfig := NewTestAppConfigService(&model.AppConfig{})
+	envConfig := &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	}
+
+	svc := &JwtService{}
+	require.NoError(t, svc.init(db, mockConfig, envConfig))
+
+	assert.False(t, svc.KeyRotationEnabled())
+	assert.Nil(t, svc.SigningKeyStatus().NextRotationAt)
+	assert.False(t, svc.SigningKeyStatus().CreatedAt.IsZero())
+
+	rotated, err := svc.RotateKeyIfDue(t.Context())
+	require.NoError(t, err)
+	assert.False(t, rotated)
+}
+
+func TestJwtService_GetSigningAlgs(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	mockConfig := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration: model.AppConfigVariable{Value: "60"},
+	})
+	envConfig := &common.EnvConfigSchema{
+		AppURL:                  "https://test.example.com",
+		KeysStorage:             "database",
+		EncryptionKey:           "0123456789abcdef0123456789abcdef",
+		KeysAlgorithm:           "ES256",
+		KeysRotationInterval:    24 * time.Hour,
+		KeysRotationGracePeriod: time.Hour,
+	}
+
+	service := &JwtService{}
+	require.NoError(t, service.init(db, mockConfig, envConfig))
+
+	// jwksAlgs returns the algorithms of the keys published in the JWKS
+	jwksAlgs := func(t *testing.T) []string {
+		t.Helper()
+		jwksJSON, err := service.GetPublicJWKSAsJSON()
+		require.NoError(t, err)
+		set, err := jwk.Parse(jwksJSON)
+		require.NoError(t, err)
+
+		var algs []string
+		for i := range set.Len() {
+			key, _ := set.Key(i)
+			alg, ok := key.Algorithm()
+			require.True(t, ok)
+			if !slices.Contains(algs, alg.String()) {
+				algs = append(algs, alg.String())
+			}
+		}
+		return algs
+	}
+
+	t.Run("advertises the algorithm of the key", func(t *testing.T) {
+		algs, err := service.GetSigningAlgs()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"ES256"}, algs)
+		assert.ElementsMatch(t, jwksAlgs(t), algs)
+	})
+
+	t.Run("advertises the algorithms of the retired keys after switching algorithm", func(t *testing.T) {
+		envConfig.KeysAlgorithm = "EdDSA"
+		state, err := service.loadKeyRotationState(t.Context())
+		require.NoError(t, err)
+		state.CreatedAt = time.Now().Add(-25 * time.Hour)
+		require.NoError(t, service.saveKeyRotationState(t.Context(), state))
+
+		rotated, err := service.RotateKeyIfDue(t.Context())
+		require.NoError(t, err)
+		require.True(t, rotated)
+
+		algs, err := service.GetSigningAlgs()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"EdDSA", "ES256"}, algs)
+		assert.ElementsMatch(t, jwksAlgs(t), algs)
+	})
+}
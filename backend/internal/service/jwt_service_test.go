@@ -15,6 +15,7 @@ import (
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -164,6 +165,70 @@ func TestJwtService_Init(t *testing.T) {
 		_ = assert.True(t, ok) &&
 			assert.Equal(t, origKeyID, loadedKeyID, "Loaded key should have the same ID as the original")
 	})
+
+	t.Run("should generate and persist PEM key", func(t *testing.T) {
+		tempDir := t.TempDir()
+		mockEnvConfig := &common.EnvConfigSchema{
+			AppURL:        "https://test.example.com",
+			KeysStorage:   "pem",
+			KeysPemFile:   filepath.Join(tempDir, "jwt_private_key.pem"),
+			KeysAlgorithm: jwa.ES256().String(),
+		}
+
+		svc := &JwtService{}
+		err := svc.init(nil, mockConfig, mockEnvConfig)
+		require.NoError(t, err, "Failed to initialize JWT service")
+
+		alg, ok := svc.privateKey.Algorithm()
+		require.True(t, ok)
+		assert.Equal(t, jwa.ES256().String(), alg.String())
+
+		// After a restart, the same key with the same ID is used
+		svc2 := &JwtService{}
+		err = svc2.init(nil, mockConfig, mockEnvConfig)
+		require.NoError(t, err, "Failed to initialize JWT service")
+		assert.Equal(t, svc.keyId, svc2.keyId)
+	})
+
+	t.Run("should sign with a key in a KMS", func(t *testing.T) {
+		// Any crypto.Signer behaves like a KMS-backed key
+		signer, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+
+		svc := &JwtService{
+			envConfig:        &common.EnvConfigSchema{AppURL: "https://test.example.com"},
+			appConfigService: mockConfig,
+		}
+		err = svc.setKmsSigner(signer)
+		require.NoError(t, err)
+		assert.Nil(t, svc.privateKey)
+
+		tokenString, err := svc.GenerateAccessToken(model.User{Base: model.Base{ID: "user123"}}, nil)
+		require.NoError(t, err)
+
+		// The token must contain the key ID, so clients can find the key in the JWKS
+		msg, err := jws.Parse([]byte(tokenString))
+		require.NoError(t, err)
+		kid, ok := msg.Signatures()[0].ProtectedHeaders().KeyID()
+		_ = assert.True(t, ok) &&
+			assert.Equal(t, svc.keyId, kid)
+		alg, _ := msg.Signatures()[0].ProtectedHeaders().Algorithm()
+		assert.Equal(t, jwa.ES384().String(), alg.String())
+
+		_, err = svc.VerifyAccessToken(tokenString)
+		require.NoError(t, err)
+	})
+
+	t.Run("should reject a key with an algorithm that doesn't match", func(t *testing.T) {
+		signer, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		svc := &JwtService{
+			envConfig: &common.EnvConfigSchema{KeysAlgorithm: jwa.RS256().String()},
+		}
+		err = svc.setKmsSigner(signer)
+		require.Error(t, err)
+	})
 }
 
 func TestJwtService_GetPublicJWK(t *testing.T) {
@@ -347,7 +412,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(user)
+		tokenString, err := service.GenerateAccessToken(user, []string{AmrPasskey})
 		require.NoError(t, err, "Failed to generate access token")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -365,6 +430,12 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		audience, ok := claims.Audience()
 		_ = assert.True(t, ok, "Audience not found in token") &&
 			assert.Equal(t, []string{"https://test.example.com"}, audience, "Audience should contain the app URL")
+		authTime, err := GetAuthTime(claims)
+		_ = assert.NoError(t, err, "Failed to get auth_time claim") &&
+			assert.WithinDuration(t, time.Now(), authTime, 2*time.Second, "auth_time should be the time the token was issued")
+		authMethods, err := GetAuthMethods(claims)
+		_ = assert.NoError(t, err, "Failed to get amr claim") &&
+			assert.Equal(t, []string{AmrPasskey}, authMethods, "amr should contain the sign in method")
 
 		// Check token expiration time is approximately 1 hour from now
 		expectedExp := time.Now().Add(1 * time.Hour)
@@ -390,7 +461,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(adminUser)
+		tokenString, err := service.GenerateAccessToken(adminUser, nil)
 		require.NoError(t, err, "Failed to generate access token")
 
 		// Verify the token
@@ -424,7 +495,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(user)
+		tokenString, err := service.GenerateAccessToken(user, nil)
 		require.NoError(t, err, "Failed to generate access token")
 
 		// Verify the token
@@ -439,6 +510,69 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		assert.InDelta(t, 0, timeDiff, 1.0, "Token should expire in approximately 30 minutes")
 	})
 
+	t.Run("renews token with idle timeout without extending the session", func(t *testing.T) {
+		idleMockConfig := NewTestAppConfigService(&model.AppConfig{
+			SessionDuration:    model.AppConfigVariable{Value: "60"},
+			SessionIdleTimeout: model.AppConfigVariable{Value: "15"},
+		})
+
+		service := &JwtService{}
+		err := service.init(nil, idleMockConfig, mockEnvConfig)
+		require.NoError(t, err, "Failed to initialize JWT service")
+
+		user := model.User{Base: model.Base{ID: "user789"}}
+
+		// New tokens expire after the idle timeout
+		tokenString, err := service.GenerateAccessToken(user, []string{AmrPasskey})
+		require.NoError(t, err, "Failed to generate access token")
+		claims, err := service.VerifyAccessToken(tokenString)
+		require.NoError(t, err, "Failed to verify generated token")
+		expiration, _ := claims.Expiration()
+		assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiration, 2*time.Second, "Token should expire after the idle timeout")
+
+		// Tokens issued less than a minute ago aren't renewed
+		renewed, _, err := service.RenewAccessToken(user, claims)
+		require.NoError(t, err, "Failed to renew access token")
+		assert.Empty(t, renewed, "Recent token should not be renewed")
+
+		// A session started 50 minutes ago can only be renewed until the end of its absolute lifetime
+		authTime := time.Now().Add(-50 * time.Minute)
+		oldTokenString, err := service.generateAccessToken(user, []string{AmrPasskey}, authTime, time.Now().Add(-5*time.Minute), time.Now().Add(10*time.Minute))
+		require.NoError(t, err, "Failed to generate access token")
+		oldClaims, err := service.VerifyAccessToken(oldTokenString)
+		require.NoError(t, err, "Failed to verify generated token")
+
+		renewed, renewedExpiration, err := service.RenewAccessToken(user, oldClaims)
+		require.NoError(t, err, "Failed to renew access token")
+		require.NotEmpty(t, renewed, "Token should be renewed")
+		assert.WithinDuration(t, authTime.Add(time.Hour), renewedExpiration, 2*time.Second, "Renewed token should expire at the end of the session")
+
+		renewedClaims, err := service.VerifyAccessToken(renewed)
+		require.NoError(t, err, "Failed to verify renewed token")
+		renewedAuthTime, err := GetAuthTime(renewedClaims)
+		_ = assert.NoError(t, err, "Failed to get auth_time claim") &&
+			assert.Equal(t, authTime.Unix(), renewedAuthTime.Unix(), "auth_time should be kept")
+		authMethods, err := GetAuthMethods(renewedClaims)
+		_ = assert.NoError(t, err, "Failed to get amr claim") &&
+			assert.Equal(t, []string{AmrPasskey}, authMethods, "amr should be kept")
+	})
+
+	t.Run("doesn't renew tokens without idle timeout", func(t *testing.T) {
+		service := &JwtService{}
+		err := service.init(nil, mockConfig, mockEnvConfig)
+		require.NoError(t, err, "Failed to initialize JWT service")
+
+		user := model.User{Base: model.Base{ID: "user123"}}
+		tokenString, err := service.generateAccessToken(user, nil, time.Now().Add(-10*time.Minute), time.Now().Add(-10*time.Minute), time.Now().Add(50*time.Minute))
+		require.NoError(t, err, "Failed to generate access token")
+		claims, err := service.VerifyAccessToken(tokenString)
+		require.NoError(t, err, "Failed to verify generated token")
+
+		renewed, _, err := service.RenewAccessToken(user, claims)
+		require.NoError(t, err, "Failed to renew access token")
+		assert.Empty(t, renewed, "Token should not be renewed")
+	})
+
 	t.Run("works with Ed25519 keys", func(t *testing.T) {
 		// Create a temporary directory for the test
 		tempDir := t.TempDir()
@@ -469,7 +603,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(user)
+		tokenString, err := service.GenerateAccessToken(user, nil)
 		require.NoError(t, err, "Failed to generate access token with Ed25519 key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -526,7 +660,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(user)
+		tokenString, err := service.GenerateAccessToken(user, nil)
 		require.NoError(t, err, "Failed to generate access token with ECDSA key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -583,7 +717,7 @@ func TestGenerateVerifyAccessToken(t *testing.T) {
 		}
 
 		// Generate a token
-		tokenString, err := service.GenerateAccessToken(user)
+		tokenString, err := service.GenerateAccessToken(user, nil)
 		require.NoError(t, err, "Failed to generate access token with RSA key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -970,7 +1104,7 @@ func TestGenerateVerifyOAuthAccessToken(t *testing.T) {
 		const clientID = "test-client-123"
 
 		// Generate a token
-		tokenString, err := service.GenerateOAuthAccessToken(user, clientID)
+		tokenString, err := service.GenerateOAuthAccessToken(user.ID, clientID, nil)
 		require.NoError(t, err, "Failed to generate OAuth access token")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -1058,7 +1192,7 @@ func TestGenerateVerifyOAuthAccessToken(t *testing.T) {
 		const clientID = "test-client-789"
 
 		// Generate a token with the first service
-		tokenString, err := service1.GenerateOAuthAccessToken(user, clientID)
+		tokenString, err := service1.GenerateOAuthAccessToken(user.ID, clientID, nil)
 		require.NoError(t, err, "Failed to generate OAuth access token")
 
 		// Verify with the second service should fail due to different keys
@@ -1097,7 +1231,7 @@ func TestGenerateVerifyOAuthAccessToken(t *testing.T) {
 		const clientID = "eddsa-oauth-client"
 
 		// Generate a token
-		tokenString, err := service.GenerateOAuthAccessToken(user, clientID)
+		tokenString, err := service.GenerateOAuthAccessToken(user.ID, clientID, nil)
 		require.NoError(t, err, "Failed to generate OAuth access token with key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -1154,7 +1288,7 @@ func TestGenerateVerifyOAuthAccessToken(t *testing.T) {
 		const clientID = "ecdsa-oauth-client"
 
 		// Generate a token
-		tokenString, err := service.GenerateOAuthAccessToken(user, clientID)
+		tokenString, err := service.GenerateOAuthAccessToken(user.ID, clientID, nil)
 		require.NoError(t, err, "Failed to generate OAuth access token with key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
@@ -1211,7 +1345,7 @@ func TestGenerateVerifyOAuthAccessToken(t *testing.T) {
 		const clientID = "rsa-oauth-client"
 
 		// Generate a token
-		tokenString, err := service.GenerateOAuthAccessToken(user, clientID)
+		tokenString, err := service.GenerateOAuthAccessToken(user.ID, clientID, nil)
 		require.NoError(t, err, "Failed to generate OAuth access token with key")
 		assert.NotEmpty(t, tokenString, "Token should not be empty")
 
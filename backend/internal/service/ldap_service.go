@@ -9,20 +9,35 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
+	"net"
 	"net/http"
 	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/go-ldap/ldap/v3"
 	"github.com/google/uuid"
 	"golang.org/x/text/unicode/norm"
+	"golang.org/x/time/rate"
 	"gorm.io/gorm"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
 	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/secrets"
+)
+
+// Timeouts of the connections and requests to LDAP, so that a slow server can't block a sync indefinitely
+const (
+	ldapDialTimeout    = 10 * time.Second
+	ldapRequestTimeout = 30 * time.Second
 )
 
 type LdapService struct {
@@ -31,19 +46,106 @@ type LdapService struct {
 	appConfigService *AppConfigService
 	userService      *UserService
 	groupService     *UserGroupService
+	emailService     *EmailService
+	auditLogService  *AuditLogService
+	bulkWorkerPool   *utils.WorkerPool
+	secretsProvider  secrets.Provider
+	// Limit the connections to LDAP, and stop connecting while it's failing
+	rateLimiterLock sync.Mutex
+	rateLimiter     *rate.Limiter
+	circuitBreaker  *utils.CircuitBreaker
+	// Syncs triggered while one is running are coalesced into a single one
+	syncCoalescer *utils.Coalescer[LdapSyncResult]
 }
 
-func NewLdapService(db *gorm.DB, httpClient *http.Client, appConfigService *AppConfigService, userService *UserService, groupService *UserGroupService) *LdapService {
-	return &LdapService{
+func NewLdapService(db *gorm.DB, httpClient *http.Client, appConfigService *AppConfigService, userService *UserService, groupService *UserGroupService, emailService *EmailService, auditLogService *AuditLogService, bulkWorkerPool *utils.WorkerPool, secretsProvider secrets.Provider) *LdapService {
+	s := &LdapService{
 		db:               db,
 		httpClient:       httpClient,
 		appConfigService: appConfigService,
 		userService:      userService,
 		groupService:     groupService,
+		emailService:     emailService,
+		auditLogService:  auditLogService,
+		bulkWorkerPool:   bulkWorkerPool,
+		secretsProvider:  secretsProvider,
+		circuitBreaker:   utils.NewCircuitBreaker(),
+	}
+	s.syncCoalescer = utils.NewCoalescer(s.syncAllAndNotify)
+	return s
+}
+
+// CircuitBreakerStatus returns the state of the circuit breaker of the connections to LDAP
+func (s *LdapService) CircuitBreakerStatus() utils.CircuitBreakerStatus {
+	return s.circuitBreaker.Status(s.circuitBreakerCooldown())
+}
+
+func (s *LdapService) circuitBreakerCooldown() time.Duration {
+	seconds, _ := strconv.Atoi(s.appConfigService.GetDbConfig().LdapCircuitBreakerCooldown.Value)
+	return time.Duration(seconds) * time.Second
+}
+
+// allowConnection checks the rate limit and the circuit breaker before connecting to LDAP.
+// If it returns nil, the result of the connection must be recorded with recordConnectionResult.
+func (s *LdapService) allowConnection() error {
+	if !s.allowRate() {
+		return &common.TooManyRequestsError{}
+	}
+
+	cooldown := s.circuitBreakerCooldown()
+	if !s.circuitBreaker.Allow(cooldown) {
+		status := s.circuitBreaker.Status(cooldown)
+		retryAt := time.Now()
+		if status.RetryAt != nil {
+			retryAt = *status.RetryAt
+		}
+		return &common.LdapUnavailableError{RetryAt: retryAt}
 	}
+
+	return nil
 }
 
-func (s *LdapService) createClient() (*ldap.Conn, error) {
+// allowRate returns true if the number of connections in the last minute is within the configured rate limit
+func (s *LdapService) allowRate() bool {
+	perMinute, _ := strconv.Atoi(s.appConfigService.GetDbConfig().LdapRateLimit.Value)
+	if perMinute <= 0 {
+		return true
+	}
+
+	s.rateLimiterLock.Lock()
+	defer s.rateLimiterLock.Unlock()
+
+	// Re-create the limiter if the configuration changed
+	if s.rateLimiter == nil || s.rateLimiter.Burst() != perMinute {
+		s.rateLimiter = rate.NewLimiter(rate.Limit(float64(perMinute)/60), perMinute)
+	}
+	return s.rateLimiter.Allow()
+}
+
+// recordConnectionResult updates the circuit breaker with the result of the calls to LDAP.
+// Only errors returned by LDAP count as failures; other errors, like database errors, don't mean that LDAP is unavailable.
+func (s *LdapService) recordConnectionResult(err error) {
+	var ldapErr *ldap.Error
+	if err == nil || !errors.As(err, &ldapErr) {
+		s.circuitBreaker.RecordSuccess()
+		return
+	}
+
+	threshold, _ := strconv.Atoi(s.appConfigService.GetDbConfig().LdapCircuitBreakerThreshold.Value)
+	s.circuitBreaker.RecordFailure(threshold)
+	if s.circuitBreaker.Status(s.circuitBreakerCooldown()).State == utils.CircuitBreakerOpen {
+		slog.Warn("LDAP failed repeatedly, pausing the connections", slog.Any("error", err))
+	}
+}
+
+// ldapProfilePicture is a profile picture of a synced user that still has to be saved
+type ldapProfilePicture struct {
+	userID   string
+	username string
+	picture  string
+}
+
+func (s *LdapService) createClient(ctx context.Context) (*ldap.Conn, error) {
 	dbConfig := s.appConfigService.GetDbConfig()
 
 	if !dbConfig.LdapEnabled.IsTrue() {
@@ -51,56 +153,111 @@ func (s *LdapService) createClient() (*ldap.Conn, error) {
 	}
 
 	// Setup LDAP connection
-	client, err := ldap.DialURL(dbConfig.LdapUrl.Value, ldap.DialWithTLSConfig(&tls.Config{
-		InsecureSkipVerify: dbConfig.LdapSkipCertVerify.IsTrue(), //nolint:gosec
-	}))
+	client, err := ldap.DialURL(dbConfig.LdapUrl.Value,
+		ldap.DialWithTLSConfig(&tls.Config{
+			InsecureSkipVerify: dbConfig.LdapSkipCertVerify.IsTrue(), //nolint:gosec
+		}),
+		ldap.DialWithDialer(&net.Dialer{Timeout: ldapDialTimeout}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to LDAP: %w", err)
 	}
+	client.SetTimeout(ldapRequestTimeout)
+
+	bindPassword, err := secrets.Resolve(ctx, s.secretsProvider, secrets.LdapBindPassword, dbConfig.LdapBindPassword.Value)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
 
 	// Bind as service account
-	err = client.Bind(dbConfig.LdapBindDn.Value, dbConfig.LdapBindPassword.Value)
+	err = client.Bind(dbConfig.LdapBindDn.Value, bindPassword)
 	if err != nil {
+		client.Close()
 		return nil, fmt.Errorf("failed to bind to LDAP: %w", err)
 	}
 	return client, nil
 }
 
-func (s *LdapService) SyncAll(ctx context.Context) error {
+// SyncAll syncs the users and groups from LDAP and returns what changed.
+// If the sync fails, nothing is changed and the returned result is empty.
+// If it runs in a background job, the progress is reported with the steps of the sync.
+func (s *LdapService) SyncAll(ctx context.Context) (LdapSyncResult, error) {
+	// Syncing many users and groups is much slower than the queries of a request
+	ctx = utils.WithQueryTimeout(ctx, common.EnvConfig.DbQueryTimeoutLdapSync)
+
+	// The steps are syncing the users, syncing the groups, committing, and generating the profile pictures
+	const steps = 4
+	progress := backgroundJobProgressFromContext(ctx)
+	progress(0, steps)
+
 	// Start a transaction
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
 	}()
 
+	err := utils.SetLocalStatementTimeout(ctx, tx, common.EnvConfig.DbQueryTimeoutLdapSync)
+	if err != nil {
+		return LdapSyncResult{}, fmt.Errorf("failed to set the statement timeout: %w", err)
+	}
+
+	// Users sign in with the data that was synced last, so they aren't affected if LDAP is unavailable
+	err = s.allowConnection()
+	if err != nil {
+		return LdapSyncResult{}, err
+	}
+
 	// Setup LDAP connection
-	client, err := s.createClient()
+	client, err := s.createClient(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to create LDAP client: %w", err)
+		s.recordConnectionResult(err)
+		return LdapSyncResult{}, fmt.Errorf("failed to create LDAP client: %w", err)
 	}
 	defer client.Close()
 
-	err = s.SyncUsers(ctx, tx, client)
+	var result LdapSyncResult
+
+	err = s.SyncUsers(ctx, tx, client, &result)
+	var duplicateErr *common.LdapDuplicateIdentifierError
+	if errors.As(err, &duplicateErr) {
+		// The audit logs must be stored even though the changes of the sync are rolled back
+		tx.Rollback()
+		s.createDuplicateLdapIdentifierAuditLogs(ctx, duplicateErr.Attribute, duplicateErr.Identifiers, "aborted", s.db)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to sync users: %w", err)
+		s.recordConnectionResult(err)
+		return LdapSyncResult{}, fmt.Errorf("failed to sync users: %w", err)
 	}
+	progress(1, steps)
 
-	err = s.SyncGroups(ctx, tx, client)
+	err = s.SyncGroups(ctx, tx, client, &result)
+	s.recordConnectionResult(err)
 	if err != nil {
-		return fmt.Errorf("failed to sync groups: %w", err)
+		return LdapSyncResult{}, fmt.Errorf("failed to sync groups: %w", err)
 	}
+	progress(2, steps)
 
 	// Commit the changes
 	err = tx.Commit().Error
 	if err != nil {
-		return fmt.Errorf("failed to commit changes to database: %w", err)
+		return LdapSyncResult{}, fmt.Errorf("failed to commit changes to database: %w", err)
 	}
+	progress(3, steps)
 
-	return nil
+	// Create the default profile pictures of new users ahead of time
+	err = s.userService.PregenerateDefaultProfilePictures(ctx, nil)
+	if err != nil {
+		// This is not a fatal error
+		slog.WarnContext(ctx, "Failed to pre-generate default profile pictures", slog.Any("error", err))
+	}
+	progress(steps, steps)
+
+	return result, nil
 }
 
 //nolint:gocognit
-func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.Conn) error {
+func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.Conn, syncResult *LdapSyncResult) error {
 	dbConfig := s.appConfigService.GetDbConfig()
 
 	searchAttrs := []string{
@@ -130,7 +287,7 @@ func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.
 
 		// Skip groups without a valid LDAP ID
 		if ldapId == "" {
-			slog.Warn("Skipping LDAP group without a valid unique identifier", slog.String("attribute", dbConfig.LdapAttributeGroupUniqueIdentifier.Value))
+			slog.WarnContext(ctx, "Skipping LDAP group without a valid unique identifier", slog.String("attribute", dbConfig.LdapAttributeGroupUniqueIdentifier.Value))
 			continue
 		}
 
@@ -212,8 +369,11 @@ func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.
 			if err != nil {
 				return fmt.Errorf("failed to sync users for group '%s': %w", syncGroup.Name, err)
 			}
+			syncResult.GroupsCreated++
 		} else {
-			_, err = s.groupService.updateInternal(ctx, databaseGroup.ID, syncGroup, true, tx)
+			// The returned group still has the members from before the sync
+			var updatedGroup model.UserGroup
+			updatedGroup, err = s.groupService.updateInternal(ctx, databaseGroup.ID, syncGroup, true, tx)
 			if err != nil {
 				return fmt.Errorf("failed to update group '%s': %w", syncGroup.Name, err)
 			}
@@ -222,6 +382,10 @@ func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.
 			if err != nil {
 				return fmt.Errorf("failed to sync users for group '%s': %w", syncGroup.Name, err)
 			}
+
+			if updatedGroup.Name != databaseGroup.Name || updatedGroup.FriendlyName != databaseGroup.FriendlyName || !hasSameMembers(updatedGroup.Users, membersUserId) {
+				syncResult.GroupsUpdated++
+			}
 		}
 	}
 
@@ -250,14 +414,15 @@ func (s *LdapService) SyncGroups(ctx context.Context, tx *gorm.DB, client *ldap.
 			return fmt.Errorf("failed to delete group '%s': %w", group.Name, err)
 		}
 
-		slog.Info("Deleted group", slog.String("group", group.Name))
+		syncResult.GroupsDeleted++
+		slog.InfoContext(ctx, "Deleted group", slog.String("group", group.Name))
 	}
 
 	return nil
 }
 
 //nolint:gocognit
-func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.Conn) error {
+func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.Conn, syncResult *LdapSyncResult) error {
 	dbConfig := s.appConfigService.GetDbConfig()
 
 	searchAttrs := []string{
@@ -287,20 +452,42 @@ func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.C
 		return fmt.Errorf("failed to query LDAP: %w", err)
 	}
 
+	// If multiple users share the same unique identifier, they would all be synced to the same user
+	uniqueIdentifierAttribute := dbConfig.LdapAttributeUserUniqueIdentifier.Value
+	duplicates := findDuplicateLdapIdentifiers(result.Entries, uniqueIdentifierAttribute)
+	if len(duplicates) > 0 {
+		if dbConfig.LdapDuplicateIdentifierBehavior.Value == "abort" {
+			return &common.LdapDuplicateIdentifierError{Attribute: uniqueIdentifierAttribute, Identifiers: duplicates}
+		}
+
+		s.createDuplicateLdapIdentifierAuditLogs(ctx, uniqueIdentifierAttribute, duplicates, "skipped", tx)
+		for _, ldapId := range slices.Sorted(maps.Keys(duplicates)) {
+			dns := duplicates[ldapId]
+			slog.WarnContext(ctx, "Skipping LDAP users that share the same unique identifier", slog.String("attribute", uniqueIdentifierAttribute), slog.String("identifier", ldapId), slog.Any("dns", dns))
+			syncResult.Errors = append(syncResult.Errors, fmt.Sprintf("Skipped users with the same unique identifier '%s': %s", ldapId, strings.Join(dns, ", ")))
+		}
+	}
+
 	// Create a mapping for users that exist
 	ldapUserIDs := make(map[string]struct{}, len(result.Entries))
+	var profilePictures []ldapProfilePicture
 
 	for _, value := range result.Entries {
 		ldapId := convertLdapIdToString(value.GetAttributeValue(dbConfig.LdapAttributeUserUniqueIdentifier.Value))
 
 		// Skip users without a valid LDAP ID
 		if ldapId == "" {
-			slog.Warn("Skipping LDAP user without a valid unique identifier", slog.String("attribute", dbConfig.LdapAttributeUserUniqueIdentifier.Value))
+			slog.WarnContext(ctx, "Skipping LDAP user without a valid unique identifier", slog.String("attribute", dbConfig.LdapAttributeUserUniqueIdentifier.Value))
 			continue
 		}
 
 		ldapUserIDs[ldapId] = struct{}{}
 
+		// The existing user is kept as it is, so it isn't removed or overwritten by the wrong entry
+		if _, duplicate := duplicates[ldapId]; duplicate {
+			continue
+		}
+
 		// Get the user from the database
 		var databaseUser model.User
 		err = tx.
@@ -309,6 +496,19 @@ func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.C
 			First(&databaseUser).
 			Error
 
+		// The user isn't missing anymore, so the grace period starts again the next time it's missing
+		if databaseUser.ID != "" && databaseUser.LdapMissingSince != nil {
+			err = tx.
+				WithContext(ctx).
+				Model(&model.User{}).
+				Where("id = ?", databaseUser.ID).
+				Updates(map[string]any{"ldap_missing_since": nil, "ldap_missing_syncs": 0}).
+				Error
+			if err != nil {
+				return fmt.Errorf("failed to reset missing marker of user %s: %w", databaseUser.Username, err)
+			}
+		}
+
 		// If a user is found (even if disabled), enable them since they're now back in LDAP
 		if databaseUser.ID != "" && databaseUser.Disabled {
 			err = tx.
@@ -348,31 +548,71 @@ func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.C
 		dto.Normalize(newUser)
 
 		if databaseUser.ID == "" {
-			_, err = s.userService.createUserInternal(ctx, newUser, true, tx)
-			if errors.Is(err, &common.AlreadyInUseError{}) {
-				slog.Warn("Skipping creating LDAP user", slog.String("username", newUser.Username), slog.Any("error", err))
+			// A single entry without a username doesn't stop the sync of the other users
+			if newUser.Username == "" && !dbConfig.GenerateUsernameFromEmail.IsTrue() {
+				slog.WarnContext(ctx, "Skipping creating LDAP user without a username", slog.String("ldapId", ldapId), slog.String("attribute", dbConfig.LdapAttributeUserUsername.Value))
+				syncResult.Errors = append(syncResult.Errors, fmt.Sprintf("Skipped creating user with unique identifier '%s': the attribute '%s' is empty", ldapId, dbConfig.LdapAttributeUserUsername.Value))
+				continue
+			}
+
+			databaseUser, err = s.userService.createUserInternal(ctx, newUser, true, tx)
+			if errors.Is(err, &common.AlreadyInUseError{}) || errors.As(err, new(*common.EmailDomainNotAllowedError)) {
+				slog.WarnContext(ctx, "Skipping creating LDAP user", slog.String("username", newUser.Username), slog.Any("error", err))
+				syncResult.Errors = append(syncResult.Errors, fmt.Sprintf("Skipped creating user '%s': %v", newUser.Username, err))
 				continue
 			} else if err != nil {
 				return fmt.Errorf("error creating user '%s': %w", newUser.Username, err)
 			}
+			syncResult.UsersCreated++
 		} else {
-			_, err = s.userService.updateUserInternal(ctx, databaseUser.ID, newUser, false, true, tx)
-			if errors.Is(err, &common.AlreadyInUseError{}) {
-				slog.Warn("Skipping updating LDAP user", slog.String("username", newUser.Username), slog.Any("error", err))
+			// Keep the existing username if LDAP doesn't provide one
+			if newUser.Username == "" {
+				newUser.Username = databaseUser.Username
+			}
+
+			var updatedUser model.User
+			updatedUser, err = s.userService.updateUserInternal(ctx, databaseUser.ID, newUser, false, true, tx)
+			if errors.Is(err, &common.AlreadyInUseError{}) || errors.As(err, new(*common.EmailDomainNotAllowedError)) {
+				slog.WarnContext(ctx, "Skipping updating LDAP user", slog.String("username", newUser.Username), slog.Any("error", err))
+				syncResult.Errors = append(syncResult.Errors, fmt.Sprintf("Skipped updating user '%s': %v", newUser.Username, err))
 				continue
 			} else if err != nil {
 				return fmt.Errorf("error updating user '%s': %w", newUser.Username, err)
 			}
+
+			if databaseUser.Disabled || hasLdapUserChanged(databaseUser, updatedUser) {
+				syncResult.UsersUpdated++
+			}
 		}
 
-		// Save profile picture
+		// Profile pictures are saved once all users have been synced
 		pictureString := value.GetAttributeValue(dbConfig.LdapAttributeUserProfilePicture.Value)
 		if pictureString != "" {
-			err = s.saveProfilePicture(ctx, databaseUser.ID, pictureString)
-			if err != nil {
-				// This is not a fatal error
-				slog.Warn("Error saving profile picture for user", slog.String("username", newUser.Username), slog.Any("error", err))
-			}
+			profilePictures = append(profilePictures, ldapProfilePicture{
+				userID:   databaseUser.ID,
+				username: newUser.Username,
+				picture:  pictureString,
+			})
+		}
+	}
+
+	// Save the profile pictures concurrently, limited by the bulk worker pool
+	profilePictureErrors := make([]string, len(profilePictures))
+	err = s.bulkWorkerPool.Each(ctx, len(profilePictures), func(ctx context.Context, i int) error {
+		err := s.saveProfilePicture(ctx, profilePictures[i].userID, profilePictures[i].picture)
+		if err != nil {
+			// This is not a fatal error
+			slog.WarnContext(ctx, "Error saving profile picture for user", slog.String("username", profilePictures[i].username), slog.Any("error", err))
+			profilePictureErrors[i] = fmt.Sprintf("Failed to save the profile picture of user '%s': %v", profilePictures[i].username, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, pictureErr := range profilePictureErrors {
+		if pictureErr != "" {
+			syncResult.Errors = append(syncResult.Errors, pictureErr)
 		}
 	}
 
@@ -388,19 +628,35 @@ func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.C
 	}
 
 	// Mark users as disabled or delete users that no longer exist in LDAP
+	now := time.Now()
 	for _, user := range ldapUsersInDb {
 		// Skip if the user ID exists in the fetched LDAP results
 		if _, exists := ldapUserIDs[*user.LdapID]; exists {
 			continue
 		}
 
+		// Users that have already been disabled don't change, so they aren't reported again on every sync
+		if dbConfig.LdapSoftDeleteUsers.IsTrue() && user.Disabled {
+			continue
+		}
+
+		// Users are only removed once they have been missing for the grace period, so a bad sync doesn't remove everyone
+		remove, err := s.markLdapUserMissingInternal(ctx, &user, now, tx)
+		if err != nil {
+			return err
+		}
+		if !remove {
+			continue
+		}
+
 		if dbConfig.LdapSoftDeleteUsers.IsTrue() {
 			err = s.userService.disableUserInternal(ctx, user.ID, tx)
 			if err != nil {
 				return fmt.Errorf("failed to disable user %s: %w", user.Username, err)
 			}
 
-			slog.Info("Disabled user", slog.String("username", user.Username))
+			syncResult.UsersDisabled++
+			slog.InfoContext(ctx, "Disabled user", slog.String("username", user.Username))
 		} else {
 			err = s.userService.deleteUserInternal(ctx, user.ID, true, tx)
 			target := &common.LdapUserUpdateError{}
@@ -410,13 +666,65 @@ func (s *LdapService) SyncUsers(ctx context.Context, tx *gorm.DB, client *ldap.C
 				return fmt.Errorf("failed to delete user %s: %w", user.Username, err)
 			}
 
-			slog.Info("Deleted user", slog.String("username", user.Username))
+			syncResult.UsersDeleted++
+			slog.InfoContext(ctx, "Deleted user", slog.String("username", user.Username))
 		}
+		syncResult.RemovedUsers = append(syncResult.RemovedUsers, user.Username)
 	}
 
 	return nil
 }
 
+// createDuplicateLdapIdentifierAuditLogs creates an audit log for each unique identifier that is shared by multiple LDAP users
+func (s *LdapService) createDuplicateLdapIdentifierAuditLogs(ctx context.Context, attribute string, duplicates map[string][]string, action string, tx *gorm.DB) {
+	for ldapId, dns := range duplicates {
+		s.auditLogService.Create(ctx, model.AuditLogEventLdapDuplicateIdentifier, "", "", "", model.AuditLogData{
+			"attribute":  attribute,
+			"identifier": ldapId,
+			"dns":        strings.Join(dns, "; "),
+			"action":     action,
+		}, tx)
+	}
+}
+
+// markLdapUserMissingInternal records that the user is missing from the current sync, and returns true if the grace period is over.
+// The grace period is over once the user has been missing from the configured number of consecutive syncs and for the configured number of days.
+func (s *LdapService) markLdapUserMissingInternal(ctx context.Context, user *model.User, now time.Time, tx *gorm.DB) (bool, error) {
+	dbConfig := s.appConfigService.GetDbConfig()
+
+	missingSince := now
+	if user.LdapMissingSince != nil {
+		missingSince = user.LdapMissingSince.ToTime()
+	}
+	missingSyncs := user.LdapMissingSyncs + 1
+
+	graceSyncs, _ := strconv.Atoi(dbConfig.LdapMissingUserGraceSyncs.Value)
+	graceDays, _ := strconv.Atoi(dbConfig.LdapMissingUserGraceDays.Value)
+	if missingSyncs > graceSyncs && !now.Before(missingSince.AddDate(0, 0, graceDays)) {
+		return true, nil
+	}
+
+	err := tx.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{
+			"ldap_missing_since": datatype.DateTime(missingSince),
+			"ldap_missing_syncs": missingSyncs,
+		}).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to mark user %s as missing: %w", user.Username, err)
+	}
+
+	slog.InfoContext(ctx, "User is missing from LDAP, waiting for the grace period to end",
+		slog.String("username", user.Username),
+		slog.Int("missingSyncs", missingSyncs),
+		slog.Time("missingSince", missingSince),
+	)
+	return false, nil
+}
+
 func (s *LdapService) saveProfilePicture(parentCtx context.Context, userId string, pictureString string) error {
 	var reader io.Reader
 
@@ -491,3 +799,49 @@ func convertLdapIdToString(ldapId string) string {
 	// As a last resort, encode as base64 to make it UTF-8 safe
 	return base64.StdEncoding.EncodeToString([]byte(ldapId))
 }
+
+// findDuplicateLdapIdentifiers returns the DNs of the entries that share the same unique identifier, by identifier
+func findDuplicateLdapIdentifiers(entries []*ldap.Entry, attribute string) map[string][]string {
+	dnsByIdentifier := make(map[string][]string, len(entries))
+	for _, entry := range entries {
+		ldapId := convertLdapIdToString(entry.GetAttributeValue(attribute))
+		if ldapId != "" {
+			dnsByIdentifier[ldapId] = append(dnsByIdentifier[ldapId], entry.DN)
+		}
+	}
+
+	duplicates := make(map[string][]string)
+	for ldapId, dns := range dnsByIdentifier {
+		if len(dns) > 1 {
+			duplicates[ldapId] = dns
+		}
+	}
+	return duplicates
+}
+
+// hasLdapUserChanged returns true if the sync changed the attributes of the user
+func hasLdapUserChanged(before, after model.User) bool {
+	return before.Username != after.Username ||
+		before.Email != after.Email ||
+		before.FirstName != after.FirstName ||
+		before.LastName != after.LastName ||
+		before.IsAdmin != after.IsAdmin
+}
+
+// hasSameMembers returns true if the users are exactly the users with the given IDs
+func hasSameMembers(users []model.User, userIDs []string) bool {
+	ids := make(map[string]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		ids[id] = struct{}{}
+	}
+	if len(ids) != len(users) {
+		return false
+	}
+
+	for _, user := range users {
+		if _, ok := ids[user.ID]; !ok {
+			return false
+		}
+	}
+	return true
+}
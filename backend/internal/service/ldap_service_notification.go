@@ -0,0 +1,151 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+)
+
+// When the admins are notified of the result of an LDAP sync
+const (
+	LdapSyncNotificationDisabled = "disabled"
+	LdapSyncNotificationAlways   = "always"
+	LdapSyncNotificationOnChange = "onChange"
+	LdapSyncNotificationOnError  = "onError"
+)
+
+// LdapSyncResult is what an LDAP sync changed. Users and groups that were skipped or only partially synced are listed in Errors.
+type LdapSyncResult struct {
+	UsersCreated  int      `json:"usersCreated"`
+	UsersUpdated  int      `json:"usersUpdated"`
+	UsersDisabled int      `json:"usersDisabled"`
+	UsersDeleted  int      `json:"usersDeleted"`
+	GroupsCreated int      `json:"groupsCreated"`
+	GroupsUpdated int      `json:"groupsUpdated"`
+	GroupsDeleted int      `json:"groupsDeleted"`
+	RemovedUsers  []string `json:"removedUsers"`
+	Errors        []string `json:"errors"`
+}
+
+// HasChanges returns true if the sync changed any user or group
+func (r LdapSyncResult) HasChanges() bool {
+	return r.UsersCreated+r.UsersUpdated+r.UsersDisabled+r.UsersDeleted+r.GroupsCreated+r.GroupsUpdated+r.GroupsDeleted > 0
+}
+
+type ldapSyncWebhookPayload struct {
+	Event string `json:"event"`
+	LdapSyncResult
+	Error        string    `json:"error,omitempty"`
+	RemovalAlert bool      `json:"removalAlert"`
+	SyncedAt     time.Time `json:"syncedAt"`
+}
+
+// NotifySyncResult sends a summary of the sync to the admins by email, and to the webhook if configured, depending on the notification setting.
+// If more users than the configured threshold were removed, the removed users are listed as a safety alert, whatever the setting.
+// Failures to notify are logged, as the sync itself is done.
+func (s *LdapService) NotifySyncResult(ctx context.Context, result LdapSyncResult, syncErr error) {
+	dbConfig := s.appConfigService.GetDbConfig()
+
+	threshold, _ := strconv.Atoi(dbConfig.LdapSyncRemovalAlertThreshold.Value)
+	removalAlert := threshold > 0 && len(result.RemovedUsers) > threshold
+
+	if !shouldNotifyLdapSyncResult(dbConfig.LdapSyncNotification.Value, result, syncErr, removalAlert) {
+		return
+	}
+
+	var errorMessage string
+	if syncErr != nil {
+		errorMessage = syncErr.Error()
+	}
+
+	if webhookUrl := dbConfig.LdapSyncNotificationWebhookUrl.Value; webhookUrl != "" {
+		err := s.sendSyncResultWebhook(ctx, webhookUrl, ldapSyncWebhookPayload{
+			Event:          "ldap_sync",
+			LdapSyncResult: result,
+			Error:          errorMessage,
+			RemovalAlert:   removalAlert,
+			SyncedAt:       time.Now().UTC().Truncate(time.Second),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to send LDAP sync result webhook", slog.Any("error", err))
+		}
+	}
+
+	var admins []model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("is_admin = ? AND disabled = ? AND email IS NOT NULL AND email <> ''", true, false).
+		Find(&admins).
+		Error
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load the admins to notify of the LDAP sync result", slog.Any("error", err))
+		return
+	}
+
+	for _, admin := range admins {
+		err = SendEmail(ctx, s.emailService, email.Address{
+			Name:   admin.FullName(),
+			Email:  admin.Email,
+			Locale: admin.Locale,
+		}, LdapSyncResultTemplate, &LdapSyncResultTemplateData{
+			Name:                  admin.FirstName,
+			Result:                result,
+			Error:                 errorMessage,
+			RemovalAlert:          removalAlert,
+			RemovalAlertThreshold: threshold,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to send LDAP sync result email", slog.String("user", admin.ID), slog.Any("error", err))
+		}
+	}
+}
+
+// shouldNotifyLdapSyncResult returns true if the admins must be notified of the result of a sync with the given notification setting
+func shouldNotifyLdapSyncResult(mode string, result LdapSyncResult, syncErr error, removalAlert bool) bool {
+	hasErrors := syncErr != nil || len(result.Errors) > 0
+
+	switch mode {
+	case LdapSyncNotificationAlways:
+		return true
+	case LdapSyncNotificationOnChange:
+		return removalAlert || hasErrors || result.HasChanges()
+	case LdapSyncNotificationOnError:
+		return removalAlert || hasErrors
+	default:
+		return false
+	}
+}
+
+func (s *LdapService) sendSyncResultWebhook(ctx context.Context, webhookUrl string, payload ldapSyncWebhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, webhookUrl, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send webhook request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook request failed with status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestShouldNotifyLdapSyncResult(t *testing.T) {
+	unchanged := LdapSyncResult{}
+	changed := LdapSyncResult{UsersCreated: 1}
+	withErrors := LdapSyncResult{Errors: []string{"Skipped creating user 'john'"}}
+	syncErr := errors.New("failed to sync users")
+
+	tests := []struct {
+		name         string
+		mode         string
+		result       LdapSyncResult
+		syncErr      error
+		removalAlert bool
+		expected     bool
+	}{
+		{"disabled never notifies", LdapSyncNotificationDisabled, changed, syncErr, true, false},
+		{"always notifies without changes", LdapSyncNotificationAlways, unchanged, nil, false, true},
+		{"on change skips unchanged syncs", LdapSyncNotificationOnChange, unchanged, nil, false, false},
+		{"on change notifies of changes", LdapSyncNotificationOnChange, changed, nil, false, true},
+		{"on change notifies of errors", LdapSyncNotificationOnChange, unchanged, syncErr, false, true},
+		{"on error skips changes", LdapSyncNotificationOnError, changed, nil, false, false},
+		{"on error notifies of failed syncs", LdapSyncNotificationOnError, unchanged, syncErr, false, true},
+		{"on error notifies of skipped users", LdapSyncNotificationOnError, withErrors, nil, false, true},
+		{"on error notifies of the removal alert", LdapSyncNotificationOnError, changed, nil, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, shouldNotifyLdapSyncResult(tt.mode, tt.result, tt.syncErr, tt.removalAlert))
+		})
+	}
+}
+
+func TestHasSameMembers(t *testing.T) {
+	users := []model.User{{Base: model.Base{ID: "a"}}, {Base: model.Base{ID: "b"}}}
+
+	assert.True(t, hasSameMembers(users, []string{"b", "a"}))
+	assert.True(t, hasSameMembers(users, []string{"a", "b", "a"}))
+	assert.False(t, hasSameMembers(users, []string{"a"}))
+	assert.False(t, hasSameMembers(users, []string{"a", "c"}))
+	assert.True(t, hasSameMembers(nil, nil))
+}
+
+func TestLdapService_NotifySyncResult(t *testing.T) {
+	var received ldapSyncWebhookPayload
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	db := testutils.NewDatabaseForTest(t)
+	newService := func(mode string) *LdapService {
+		appConfig := NewTestAppConfigService(&model.AppConfig{
+			LdapSyncNotification:           model.AppConfigVariable{Value: mode},
+			LdapSyncNotificationWebhookUrl: model.AppConfigVariable{Value: server.URL},
+			LdapSyncRemovalAlertThreshold:  model.AppConfigVariable{Value: "1"},
+		})
+		// There are no admins, so only the webhook is sent
+		return &LdapService{db: db, httpClient: server.Client(), appConfigService: appConfig}
+	}
+
+	t.Run("skips unchanged syncs", func(t *testing.T) {
+		newService(LdapSyncNotificationOnChange).NotifySyncResult(t.Context(), LdapSyncResult{}, nil)
+		assert.Equal(t, 0, requests)
+	})
+
+	t.Run("sends the removal alert", func(t *testing.T) {
+		result := LdapSyncResult{UsersDisabled: 2, RemovedUsers: []string{"alice", "bob"}}
+		newService(LdapSyncNotificationOnError).NotifySyncResult(t.Context(), result, nil)
+
+		assert.Equal(t, 1, requests)
+		assert.Equal(t, "ldap_sync", received.Event)
+		assert.True(t, received.RemovalAlert)
+		assert.Equal(t, []string{"alice", "bob"}, received.RemovedUsers)
+		assert.WithinDuration(t, time.Now(), received.SyncedAt, time.Minute)
+	})
+}
@@ -1,7 +1,20 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
 )
 
 func TestGetDNProperty(t *testing.T) {
@@ -104,3 +117,121 @@ func TestConvertLdapIdToString(t *testing.T) {
 		})
 	}
 }
+
+func TestFindDuplicateLdapIdentifiers(t *testing.T) {
+	entries := []*ldap.Entry{
+		ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{"uuid": {"1"}}),
+		ldap.NewEntry("uid=bob,dc=example,dc=com", map[string][]string{"uuid": {"2"}}),
+		ldap.NewEntry("uid=alice2,dc=example,dc=com", map[string][]string{"uuid": {"1"}}),
+		ldap.NewEntry("uid=nobody,dc=example,dc=com", map[string][]string{}),
+		ldap.NewEntry("uid=nobody2,dc=example,dc=com", map[string][]string{}),
+	}
+
+	duplicates := findDuplicateLdapIdentifiers(entries, "uuid")
+	assert.Equal(t, map[string][]string{
+		"1": {"uid=alice,dc=example,dc=com", "uid=alice2,dc=example,dc=com"},
+	}, duplicates)
+
+	err := &common.LdapDuplicateIdentifierError{Attribute: "uuid", Identifiers: duplicates}
+	assert.Contains(t, err.Error(), `"1" is used by uid=alice,dc=example,dc=com, uid=alice2,dc=example,dc=com`)
+}
+
+func TestLdapService_allowConnection(t *testing.T) {
+	newService := func(rateLimit string) *LdapService {
+		appConfig := NewTestAppConfigService(&model.AppConfig{
+			LdapRateLimit:               model.AppConfigVariable{Value: rateLimit},
+			LdapCircuitBreakerThreshold: model.AppConfigVariable{Value: "2"},
+			LdapCircuitBreakerCooldown:  model.AppConfigVariable{Value: "60"},
+		})
+		return NewLdapService(nil, nil, appConfig, nil, nil, nil, nil, nil, nil)
+	}
+
+	t.Run("opens the circuit after repeated LDAP failures", func(t *testing.T) {
+		s := newService("0")
+		ldapErr := fmt.Errorf("failed to connect to LDAP: %w", ldap.NewError(ldap.ErrorNetwork, errors.New("connection refused")))
+
+		require.NoError(t, s.allowConnection())
+		s.recordConnectionResult(ldapErr)
+		require.NoError(t, s.allowConnection())
+		s.recordConnectionResult(ldapErr)
+
+		var unavailableErr *common.LdapUnavailableError
+		require.ErrorAs(t, s.allowConnection(), &unavailableErr)
+		assert.Equal(t, utils.CircuitBreakerOpen, s.CircuitBreakerStatus().State)
+	})
+
+	t.Run("ignores errors that don't come from LDAP", func(t *testing.T) {
+		s := newService("0")
+		for range 3 {
+			require.NoError(t, s.allowConnection())
+			s.recordConnectionResult(errors.New("database is locked"))
+		}
+		assert.Equal(t, utils.CircuitBreakerClosed, s.CircuitBreakerStatus().State)
+	})
+
+	t.Run("limits the rate of connections", func(t *testing.T) {
+		s := newService("1")
+		require.NoError(t, s.allowConnection())
+		s.recordConnectionResult(nil)
+
+		var tooManyErr *common.TooManyRequestsError
+		require.ErrorAs(t, s.allowConnection(), &tooManyErr)
+	})
+}
+
+func TestLdapService_markLdapUserMissingInternal(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	newService := func(graceSyncs, graceDays string) *LdapService {
+		appConfig := NewTestAppConfigService(&model.AppConfig{
+			LdapMissingUserGraceSyncs: model.AppConfigVariable{Value: graceSyncs},
+			LdapMissingUserGraceDays:  model.AppConfigVariable{Value: graceDays},
+		})
+		return NewLdapService(db, nil, appConfig, nil, nil, nil, nil, nil, nil)
+	}
+	createUser := func(t *testing.T, username string) *model.User {
+		t.Helper()
+		user := model.User{Username: username, Email: username + "@example.com", FirstName: username, LdapID: utils.Ptr(username)}
+		require.NoError(t, db.Create(&user).Error)
+		return &user
+	}
+	markMissing := func(t *testing.T, s *LdapService, user *model.User, now time.Time) bool {
+		t.Helper()
+		remove, err := s.markLdapUserMissingInternal(t.Context(), user, now, db)
+		require.NoError(t, err)
+		require.NoError(t, db.First(user, "id = ?", user.ID).Error)
+		return remove
+	}
+
+	t.Run("removes users immediately without a grace period", func(t *testing.T) {
+		user := createUser(t, "immediate")
+		assert.True(t, markMissing(t, newService("0", "0"), user, time.Now()))
+	})
+
+	t.Run("waits for the configured number of syncs", func(t *testing.T) {
+		s := newService("2", "0")
+		user := createUser(t, "syncs")
+		now := time.Now().Truncate(time.Second)
+
+		assert.False(t, markMissing(t, s, user, now))
+		require.NotNil(t, user.LdapMissingSince)
+		assert.Equal(t, now.Unix(), user.LdapMissingSince.ToTime().Unix())
+		assert.Equal(t, 1, user.LdapMissingSyncs)
+
+		assert.False(t, markMissing(t, s, user, now.Add(time.Hour)))
+		assert.Equal(t, 2, user.LdapMissingSyncs)
+		// The start of the absence is kept
+		assert.Equal(t, now.Unix(), user.LdapMissingSince.ToTime().Unix())
+
+		assert.True(t, markMissing(t, s, user, now.Add(2*time.Hour)))
+	})
+
+	t.Run("waits for the configured number of days", func(t *testing.T) {
+		s := newService("0", "3")
+		user := createUser(t, "days")
+		missingSince := time.Now().Add(-2 * 24 * time.Hour)
+		user.LdapMissingSince = utils.Ptr(datatype.DateTime(missingSince))
+
+		assert.False(t, markMissing(t, s, user, time.Now()))
+		assert.True(t, markMissing(t, s, user, missingSince.Add(3*24*time.Hour)))
+	})
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"context"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// TriggerSync syncs the users and groups from LDAP on request, e.g. from a webhook after changes were provisioned.
+// If a sync is already running, the trigger waits for it to finish and then runs a single sync for all triggers received in the meantime.
+func (s *LdapService) TriggerSync(ctx context.Context, actorUserID, ipAddress, userAgent string) (LdapSyncResult, error) {
+	if !s.appConfigService.GetDbConfig().LdapEnabled.IsTrue() {
+		return LdapSyncResult{}, &common.LdapDisabledError{}
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventLdapSyncTriggered, ipAddress, userAgent, actorUserID, model.AuditLogData{}, s.db)
+
+	return s.syncCoalescer.Do(ctx)
+}
+
+// SyncAllCoalesced syncs the users and groups from LDAP like SyncAll, but shares the sync with triggers received at the same time
+func (s *LdapService) SyncAllCoalesced(ctx context.Context) (LdapSyncResult, error) {
+	return s.syncCoalescer.Do(ctx)
+}
+
+func (s *LdapService) syncAllAndNotify(ctx context.Context) (LdapSyncResult, error) {
+	result, err := s.SyncAll(ctx)
+	s.NotifySyncResult(ctx, result, err)
+	return result, err
+}
@@ -10,8 +10,9 @@ import (
 	"fmt"
 	"log/slog"
 	"mime/multipart"
+	"net"
 	"net/http"
-	"os"
+	"net/url"
 	"regexp"
 	"slices"
 	"strings"
@@ -19,6 +20,7 @@ import (
 
 	"github.com/lestrrat-go/httprc/v3"
 	"github.com/lestrrat-go/httprc/v3/errsink"
+	"github.com/lestrrat-go/jwx/v3/jwa"
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"github.com/lestrrat-go/jwx/v3/jws"
 	"github.com/lestrrat-go/jwx/v3/jwt"
@@ -31,25 +33,55 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	imageutil "github.com/pocket-id/pocket-id/backend/internal/utils/image"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/storage"
 )
 
 const (
 	GrantTypeAuthorizationCode = "authorization_code"
 	GrantTypeRefreshToken      = "refresh_token"
 	GrantTypeDeviceCode        = "urn:ietf:params:oauth:grant-type:device_code"
+	GrantTypeClientCredentials = "client_credentials"
+	GrantTypeTokenExchange     = "urn:ietf:params:oauth:grant-type:token-exchange"
 
 	ClientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer" //nolint:gosec
 
 	RefreshTokenDuration = 30 * 24 * time.Hour // 30 days
 	DeviceCodeDuration   = 15 * time.Minute
+
+	// Tolerance when checking the max_age parameter of authorization requests
+	maxAgeLeeway = 10 * time.Second
+
+	// AMR values (RFC 8176) of the sign in methods
+	AmrPasskey     = "swk"
+	AmrOneTimeCode = "otp"
 )
 
+// Assurance levels of the sign in methods, used to compare them with the ACR values requested by clients
+const (
+	acrLevelNone = iota
+	acrLevelOneTimeCode
+	acrLevelPasskey
+)
+
+// Client logos are stored as they were uploaded
+var clientLogoOptions = imageutil.Options{
+	AllowedTypes: imageutil.SupportedTypes,
+	MaxInputSize: 2 << 20, // 2 MB
+}
+
+// Grant types of clients that don't configure them
+var defaultClientGrantTypes = []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken}
+
 type OidcService struct {
 	db                 *gorm.DB
 	jwtService         *JwtService
 	appConfigService   *AppConfigService
 	auditLogService    *AuditLogService
 	customClaimService *CustomClaimService
+	geoLiteService     *GeoLiteService
+	uploadStorage      storage.Storage
+	federatedJwks      federatedJwksCache
 
 	httpClient *http.Client
 	jwkCache   *jwk.Cache
@@ -62,6 +94,8 @@ func NewOidcService(
 	appConfigService *AppConfigService,
 	auditLogService *AuditLogService,
 	customClaimService *CustomClaimService,
+	geoLiteService *GeoLiteService,
+	uploadStorage storage.Storage,
 ) (s *OidcService, err error) {
 	s = &OidcService{
 		db:                 db,
@@ -69,6 +103,8 @@ func NewOidcService(
 		appConfigService:   appConfigService,
 		auditLogService:    auditLogService,
 		customClaimService: customClaimService,
+		geoLiteService:     geoLiteService,
+		uploadStorage:      uploadStorage,
 	}
 
 	// Note: we don't pass the HTTP Client with OTel instrumented to this because requests are always made in background and not tied to a specific trace
@@ -107,7 +143,9 @@ func (s *OidcService) getJWKCache(ctx context.Context) (*jwk.Cache, error) {
 	)
 }
 
-func (s *OidcService) Authorize(ctx context.Context, input dto.AuthorizeOidcClientRequestDto, userID, ipAddress, userAgent string) (string, string, error) {
+// Authorize creates an authorization code for the user.
+// authTime is the time at which the user signed in interactively, which is checked against the max_age parameter, and authMethods are the AMR values of the sign in.
+func (s *OidcService) Authorize(ctx context.Context, input dto.AuthorizeOidcClientRequestDto, userID string, authTime time.Time, authMethods []string, ipAddress, userAgent string) (string, string, error) {
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
@@ -123,17 +161,73 @@ func (s *OidcService) Authorize(ctx context.Context, input dto.AuthorizeOidcClie
 		return "", "", err
 	}
 
+	if !clientAllowsGrantType(&client, GrantTypeAuthorizationCode) {
+		return "", "", &common.OidcUnauthorizedClientError{}
+	}
+
+	// Replace the parameters with the ones of the signed request object, if any
+	input, err = s.resolveRequestObject(ctx, &client, input)
+	if err != nil {
+		return "", "", err
+	}
+
 	// If the client is not public, the code challenge must be provided
 	if client.IsPublic && input.CodeChallenge == "" {
 		return "", "", &common.OidcMissingCodeChallengeError{}
 	}
 
 	// Get the callback URL of the client. Return an error if the provided callback URL is not allowed
-	callbackURL, err := s.getCallbackURL(&client, input.CallbackURL, tx, ctx)
+	callbackURL, registered, err := s.getCallbackURL(ctx, &client, input.CallbackURL)
 	if err != nil {
 		return "", "", err
 	}
 
+	// Errors are only sent to callback URLs that are registered already, otherwise anyone could redirect users to any URL
+	errorCallbackURL := ""
+	if registered {
+		errorCallbackURL = callbackURL
+	}
+
+	// With prompt=none, errors that would require user interaction are sent to the callback URL instead
+	prompts := strings.Fields(input.Prompt)
+	silent := slices.Contains(prompts, "none")
+	if silent && len(prompts) > 1 {
+		return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "invalid_request"}
+	}
+
+	if userID == "" {
+		if silent {
+			return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "login_required"}
+		}
+		return "", "", &common.NotSignedInError{}
+	}
+
+	// The user has to sign in again if the last sign in is older than max_age
+	if input.MaxAge != nil && authTimeExceedsMaxAge(authTime, *input.MaxAge) {
+		if silent {
+			return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "login_required"}
+		}
+		return "", "", &common.OidcReauthenticationRequiredError{}
+	}
+
+	// The user has to sign in again with a stronger method if the sign in doesn't satisfy the requested ACR values
+	acrLevel := acrLevelForAuthMethods(authMethods)
+	if input.AcrValues != "" {
+		requiredLevel, ok := requiredAcrLevel(input.AcrValues)
+		if !ok {
+			if silent {
+				return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "invalid_request"}
+			}
+			return "", "", &common.OidcAcrValuesNotSupportedError{}
+		}
+		if acrLevel < requiredLevel {
+			if silent {
+				return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "login_required"}
+			}
+			return "", "", &common.OidcReauthenticationRequiredError{}
+		}
+	}
+
 	// Check if the user group is allowed to authorize the client
 	var user model.User
 	err = tx.
@@ -145,26 +239,72 @@ func (s *OidcService) Authorize(ctx context.Context, input dto.AuthorizeOidcClie
 		return "", "", err
 	}
 
+	err = s.appConfigService.CheckMaintenanceMode(&user)
+	if err != nil {
+		return "", "", err
+	}
+	err = s.auditLogService.CheckSignInLocation(ctx, ipAddress, &user)
+	if err != nil {
+		// Release the transaction first, as SQLite allows only one writer
+		tx.Rollback()
+		s.auditLogService.LogSignInGeoblocked(ctx, err, ipAddress, userAgent, &user)
+		return "", "", err
+	}
+
 	if !s.IsUserGroupAllowedToAuthorize(user, client) {
+		if silent {
+			return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "access_denied"}
+		}
 		return "", "", &common.OidcAccessDeniedError{}
 	}
 
+	err = checkClientEmailRequirement(&client, &user)
+	if err != nil {
+		// Release the transaction first, as SQLite allows only one writer
+		tx.Rollback()
+		s.logClientAuthorizationBlocked(ctx, &client, &user, ipAddress, userAgent)
+		if silent {
+			return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "access_denied"}
+		}
+		return "", "", err
+	}
+
+	// The requested resources must be audiences of the client; the error is sent to the callback URL.
+	// This is only checked for signed in users, so that the error can't be used to redirect anyone.
+	resources, err := validateAuthorizationResources(&client, input.Resource)
+	if err != nil {
+		return "", errorCallbackURL, err
+	}
+
 	// Check if the user has already authorized the client with the given scope
 	hasAuthorizedClient, err := s.hasAuthorizedClientInternal(ctx, input.ClientID, userID, input.Scope, tx)
 	if err != nil {
 		return "", "", err
 	}
 
+	// The user has to consent to new clients and scopes, which isn't possible without interaction
+	if !hasAuthorizedClient && silent {
+		return "", errorCallbackURL, &common.OidcSilentAuthenticationError{Code: "consent_required"}
+	}
+
 	// If the user has not authorized the client, create a new authorization in the database
 	if !hasAuthorizedClient {
-		err := s.createAuthorizedClientInternal(ctx, userID, input.ClientID, input.Scope, tx)
+		err := s.createAuthorizedClientInternal(ctx, userID, &client, input.Scope, tx)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	// A callback URL trusted on first use is only stored once the user authorized the client
+	if !registered {
+		err = s.addCallbackURLToClient(ctx, &client, callbackURL, tx)
 		if err != nil {
 			return "", "", err
 		}
 	}
 
 	// Create the authorization code
-	code, err := s.createAuthorizationCode(ctx, input.ClientID, userID, input.Scope, input.Nonce, input.CodeChallenge, input.CodeChallengeMethod, tx)
+	code, err := s.createAuthorizationCode(ctx, input.ClientID, userID, input.Scope, input.Nonce, input.CodeChallenge, input.CodeChallengeMethod, authTime, authMethods, acrValueForLevel(acrLevel), resources, tx)
 	if err != nil {
 		return "", "", err
 	}
@@ -184,6 +324,55 @@ func (s *OidcService) Authorize(ctx context.Context, input dto.AuthorizeOidcClie
 	return code, callbackURL, nil
 }
 
+// authTimeExceedsMaxAge returns true if more than maxAge seconds have passed since authTime.
+// The leeway accounts for the time between the sign in and the authorization request, so that max_age=0 can be satisfied by signing in again.
+func authTimeExceedsMaxAge(authTime time.Time, maxAge int) bool {
+	if authTime.IsZero() {
+		return true
+	}
+	return time.Since(authTime) > time.Duration(maxAge)*time.Second+maxAgeLeeway
+}
+
+// acrLevelForAuthMethods returns the assurance level of a sign in with the given AMR values
+func acrLevelForAuthMethods(authMethods []string) int {
+	switch {
+	case slices.Contains(authMethods, AmrPasskey):
+		return acrLevelPasskey
+	case slices.Contains(authMethods, AmrOneTimeCode):
+		return acrLevelOneTimeCode
+	default:
+		return acrLevelNone
+	}
+}
+
+// acrValueForLevel returns the configured ACR value of the assurance level, or an empty string if there's none
+func acrValueForLevel(level int) string {
+	switch level {
+	case acrLevelPasskey:
+		return common.EnvConfig.OidcAcrPasskey
+	case acrLevelOneTimeCode:
+		return common.EnvConfig.OidcAcrOneTimeCode
+	default:
+		return ""
+	}
+}
+
+// requiredAcrLevel returns the lowest assurance level that satisfies one of the space-separated ACR values.
+// ok is false if none of the values is known.
+func requiredAcrLevel(acrValues string) (level int, ok bool) {
+	level = acrLevelPasskey
+	for _, value := range strings.Fields(acrValues) {
+		switch value {
+		case common.EnvConfig.OidcAcrOneTimeCode:
+			level = min(level, acrLevelOneTimeCode)
+			ok = true
+		case common.EnvConfig.OidcAcrPasskey:
+			ok = true
+		}
+	}
+	return level, ok
+}
+
 // HasAuthorizedClient checks if the user has already authorized the client with the given scope
 func (s *OidcService) HasAuthorizedClient(ctx context.Context, clientID, userID, scope string) (bool, error) {
 	return s.hasAuthorizedClientInternal(ctx, clientID, userID, scope, s.db)
@@ -228,33 +417,65 @@ func (s *OidcService) IsUserGroupAllowedToAuthorize(user model.User, client mode
 	return isAllowedToAuthorize
 }
 
+// checkClientEmailRequirement returns an error if the client requires an email address and the user has none
+func checkClientEmailRequirement(client *model.OidcClient, user *model.User) error {
+	if client.RequireEmail && user.Email == "" {
+		return &common.OidcEmailRequiredError{}
+	}
+	return nil
+}
+
+// logClientAuthorizationBlocked logs that the user couldn't authorize the client because the user has no email address
+func (s *OidcService) logClientAuthorizationBlocked(ctx context.Context, client *model.OidcClient, user *model.User, ipAddress, userAgent string) {
+	s.auditLogService.Create(ctx, model.AuditLogEventClientAuthorizationBlocked, ipAddress, userAgent, user.ID, model.AuditLogData{
+		"clientName": client.Name,
+		"reason":     "email_required",
+	}, s.db)
+}
+
 type CreatedTokens struct {
 	IdToken      string
 	AccessToken  string
 	RefreshToken string
 	ExpiresIn    time.Duration
+	// Scope is set for tokens issued with the client_credentials grant
+	Scope string
+	// IssuedTokenType is set for tokens issued with the token exchange grant
+	IssuedTokenType string
 }
 
-func (s *OidcService) CreateTokens(ctx context.Context, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+func (s *OidcService) CreateTokens(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
 	switch input.GrantType {
 	case GrantTypeAuthorizationCode:
-		return s.createTokenFromAuthorizationCode(ctx, input)
+		return s.createTokenFromAuthorizationCode(ctx, input, ipAddress, userAgent)
 	case GrantTypeRefreshToken:
-		return s.createTokenFromRefreshToken(ctx, input)
+		return s.createTokenFromRefreshToken(ctx, input, ipAddress, userAgent)
 	case GrantTypeDeviceCode:
-		return s.createTokenFromDeviceCode(ctx, input)
+		return s.createTokenFromDeviceCode(ctx, input, ipAddress, userAgent)
+	case GrantTypeClientCredentials:
+		return s.createTokenFromClientCredentials(ctx, input, ipAddress, userAgent)
+	case GrantTypeTokenExchange:
+		return s.createTokenFromTokenExchange(ctx, input, ipAddress, userAgent)
 	default:
 		return CreatedTokens{}, &common.OidcGrantTypeNotSupportedError{}
 	}
 }
 
-func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
 	}()
 
-	_, err := s.verifyClientCredentialsInternal(ctx, tx, clientAuthCredentialsFromCreateTokensDto(&input), true)
+	client, err := s.verifyClientCredentialsInternal(ctx, tx, clientAuthCredentialsFromCreateTokensDto(&input), true)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	if !clientAllowsGrantType(client, GrantTypeDeviceCode) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
+
+	audiences, err := resolveAccessTokenAudiences(client, &input, nil)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
@@ -284,6 +505,11 @@ func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.O
 		return CreatedTokens{}, &common.OidcAuthorizationPendingError{}
 	}
 
+	err = s.appConfigService.CheckMaintenanceMode(&deviceAuth.User)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
 	// Get user claims for the ID token - ensure UserID is not nil
 	if deviceAuth.UserID == nil {
 		return CreatedTokens{}, &common.OidcAuthorizationPendingError{}
@@ -300,12 +526,12 @@ func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.O
 		return CreatedTokens{}, err
 	}
 
-	refreshToken, err := s.createRefreshToken(ctx, input.ClientID, *deviceAuth.UserID, deviceAuth.Scope, tx)
+	refreshToken, err := s.createRefreshTokenIfAllowed(ctx, client, *deviceAuth.UserID, deviceAuth.Scope, nil, ipAddress, time.Now(), tx)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
 
-	accessToken, err := s.jwtService.GenerateOAuthAccessToken(deviceAuth.User, input.ClientID)
+	accessToken, err := s.jwtService.GenerateOAuthAccessToken(s.clientSubject(client, *deviceAuth.UserID), input.ClientID, audiences)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
@@ -316,6 +542,8 @@ func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.O
 		return CreatedTokens{}, err
 	}
 
+	s.auditTokenIssuance(ctx, GrantTypeDeviceCode, client, *deviceAuth.UserID, deviceAuth.Scope, refreshToken != "", ipAddress, userAgent, tx)
+
 	err = tx.Commit().Error
 	if err != nil {
 		return CreatedTokens{}, err
@@ -329,7 +557,69 @@ func (s *OidcService) createTokenFromDeviceCode(ctx context.Context, input dto.O
 	}, nil
 }
 
-func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+// createTokenFromClientCredentials issues an access token that represents the client itself.
+// Only confidential clients that authenticate and have the client_credentials grant type enabled can use it.
+func (s *OidcService) createTokenFromClientCredentials(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	client, err := s.verifyClientCredentialsInternal(ctx, tx, clientAuthCredentialsFromCreateTokensDto(&input), false)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	if client.IsPublic || !clientAllowsGrantType(client, GrantTypeClientCredentials) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
+
+	// The token represents the client, which isn't an admin
+	err = s.appConfigService.CheckMaintenanceMode(nil)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	audiences, err := resolveAccessTokenAudiences(client, &input, nil)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	// If no scope is requested, all the scopes of the client are granted
+	scopes := strings.Fields(input.Scope)
+	if len(scopes) == 0 {
+		scopes = client.ClientCredentialsScopes
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(client.ClientCredentialsScopes, scope) {
+			return CreatedTokens{}, &common.OidcInvalidScopeError{Scope: scope}
+		}
+	}
+	scope := strings.Join(scopes, " ")
+
+	accessToken, err := s.jwtService.GenerateClientCredentialsAccessToken(client.ID, scope, audiences)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventClientCredentialsToken, ipAddress, userAgent, "", model.AuditLogData{
+		"clientName": client.Name,
+		"clientId":   client.ID,
+		"scope":      scope,
+	}, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	return CreatedTokens{
+		AccessToken: accessToken,
+		ExpiresIn:   time.Hour,
+		Scope:       scope,
+	}, nil
+}
+
+func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
@@ -339,6 +629,9 @@ func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, inpu
 	if err != nil {
 		return CreatedTokens{}, err
 	}
+	if !clientAllowsGrantType(client, GrantTypeAuthorizationCode) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
 
 	var authorizationCodeMetaData model.OidcAuthorizationCode
 	err = tx.
@@ -350,6 +643,28 @@ func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, inpu
 		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
 	}
 
+	if authorizationCodeMetaData.ClientID != client.ID {
+		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
+	}
+
+	// Codes can only be used once; reuse means the code was intercepted, so the tokens issued with it are revoked (RFC 6749, section 4.1.2)
+	// This is checked before the expiration, because a replayed code has usually expired already
+	if authorizationCodeMetaData.UsedAt != nil {
+		err = s.revokeReusedAuthorizationCodeInternal(ctx, &authorizationCodeMetaData, client, ipAddress, userAgent, tx)
+		if err != nil {
+			return CreatedTokens{}, err
+		}
+		err = tx.Commit().Error
+		if err != nil {
+			return CreatedTokens{}, err
+		}
+		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
+	}
+
+	if authorizationCodeMetaData.ExpiresAt.ToTime().Before(time.Now()) {
+		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
+	}
+
 	// If the client is public or PKCE is enabled, the code verifier must match the code challenge
 	if client.IsPublic || client.PkceEnabled {
 		if !s.validateCodeVerifier(input.CodeVerifier, *authorizationCodeMetaData.CodeChallenge, *authorizationCodeMetaData.CodeChallengeMethodSha256) {
@@ -357,8 +672,14 @@ func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, inpu
 		}
 	}
 
-	if authorizationCodeMetaData.ClientID != input.ClientID && authorizationCodeMetaData.ExpiresAt.ToTime().Before(time.Now()) {
-		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
+	audiences, err := resolveAccessTokenAudiences(client, &input, authorizationCodeMetaData.Resources)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	err = s.appConfigService.CheckMaintenanceMode(&authorizationCodeMetaData.User)
+	if err != nil {
+		return CreatedTokens{}, err
 	}
 
 	userClaims, err := s.getUserClaimsForClientInternal(ctx, authorizationCodeMetaData.UserID, input.ClientID, tx)
@@ -366,30 +687,51 @@ func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, inpu
 		return CreatedTokens{}, err
 	}
 
+	if authorizationCodeMetaData.AuthTime != nil {
+		userClaims[AuthTimeClaim] = authorizationCodeMetaData.AuthTime.ToTime().Unix()
+	}
+	if authorizationCodeMetaData.AuthMethods != "" {
+		userClaims[AuthMethodsClaim] = strings.Fields(authorizationCodeMetaData.AuthMethods)
+	}
+	if authorizationCodeMetaData.Acr != "" {
+		userClaims[AuthContextClassClaim] = authorizationCodeMetaData.Acr
+	}
+
 	idToken, err := s.jwtService.GenerateIDToken(userClaims, input.ClientID, authorizationCodeMetaData.Nonce)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
 
+	// The session of the refresh token starts when the code is used, which links the tokens to the code in case it's reused
+	usedAt := time.Now()
+
 	// Generate a refresh token
-	refreshToken, err := s.createRefreshToken(ctx, input.ClientID, authorizationCodeMetaData.UserID, authorizationCodeMetaData.Scope, tx)
+	refreshToken, err := s.createRefreshTokenIfAllowed(ctx, client, authorizationCodeMetaData.UserID, authorizationCodeMetaData.Scope, authorizationCodeMetaData.Resources, ipAddress, usedAt, tx)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
 
-	accessToken, err := s.jwtService.GenerateOAuthAccessToken(authorizationCodeMetaData.User, input.ClientID)
+	accessToken, err := s.jwtService.GenerateOAuthAccessToken(s.clientSubject(client, authorizationCodeMetaData.UserID), input.ClientID, audiences)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
 
-	err = tx.
+	// The code is kept until it expires, so that reuse can be detected
+	result := tx.
 		WithContext(ctx).
-		Delete(&authorizationCodeMetaData).
-		Error
-	if err != nil {
-		return CreatedTokens{}, err
+		Model(&model.OidcAuthorizationCode{}).
+		Where("id = ? AND used_at IS NULL", authorizationCodeMetaData.ID).
+		Update("used_at", utils.Ptr(datatype.DateTime(usedAt)))
+	if result.Error != nil {
+		return CreatedTokens{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// The code was used concurrently
+		return CreatedTokens{}, &common.OidcInvalidAuthorizationCodeError{}
 	}
 
+	s.auditTokenIssuance(ctx, GrantTypeAuthorizationCode, client, authorizationCodeMetaData.UserID, authorizationCodeMetaData.Scope, refreshToken != "", ipAddress, userAgent, tx)
+
 	err = tx.Commit().Error
 	if err != nil {
 		return CreatedTokens{}, err
@@ -403,7 +745,7 @@ func (s *OidcService) createTokenFromAuthorizationCode(ctx context.Context, inpu
 	}, nil
 }
 
-func (s *OidcService) createTokenFromRefreshToken(ctx context.Context, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+func (s *OidcService) createTokenFromRefreshToken(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
 	if input.RefreshToken == "" {
 		return CreatedTokens{}, &common.OidcMissingRefreshTokenError{}
 	}
@@ -428,6 +770,9 @@ func (s *OidcService) createTokenFromRefreshToken(ctx context.Context, input dto
 	if client.ID != clientID {
 		return CreatedTokens{}, &common.OidcInvalidRefreshTokenError{}
 	}
+	if !clientAllowsGrantType(client, GrantTypeRefreshToken) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
 
 	// Verify refresh token
 	var storedRefreshToken model.OidcRefreshToken
@@ -455,14 +800,30 @@ func (s *OidcService) createTokenFromRefreshToken(ctx context.Context, input dto
 		return CreatedTokens{}, &common.OidcInvalidRefreshTokenError{}
 	}
 
+	audiences, err := resolveAccessTokenAudiences(client, &input, storedRefreshToken.Resources)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	err = s.appConfigService.CheckMaintenanceMode(&storedRefreshToken.User)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	// Refreshing tokens keeps the user active, but the request is made by the client, so its IP address isn't the one of the user
+	err = updateLastLoginInternal(ctx, storedRefreshToken.UserID, "", tx)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
 	// Generate a new access token
-	accessToken, err := s.jwtService.GenerateOAuthAccessToken(storedRefreshToken.User, input.ClientID)
+	accessToken, err := s.jwtService.GenerateOAuthAccessToken(s.clientSubject(client, storedRefreshToken.UserID), input.ClientID, audiences)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
 
-	// Generate a new refresh token and invalidate the old one
-	newRefreshToken, err := s.createRefreshToken(ctx, input.ClientID, storedRefreshToken.UserID, storedRefreshToken.Scope, tx)
+	// Generate a new refresh token and invalidate the old one; the new token belongs to the same session
+	newRefreshToken, err := s.createRefreshToken(ctx, input.ClientID, storedRefreshToken.UserID, storedRefreshToken.Scope, storedRefreshToken.Resources, ipAddress, sessionStartedAt(&storedRefreshToken), tx)
 	if err != nil {
 		return CreatedTokens{}, err
 	}
@@ -476,6 +837,8 @@ func (s *OidcService) createTokenFromRefreshToken(ctx context.Context, input dto
 		return CreatedTokens{}, err
 	}
 
+	s.auditTokenIssuance(ctx, GrantTypeRefreshToken, client, storedRefreshToken.UserID, storedRefreshToken.Scope, true, ipAddress, userAgent, tx)
+
 	err = tx.Commit().Error
 	if err != nil {
 		return CreatedTokens{}, err
@@ -503,8 +866,9 @@ func (s *OidcService) IntrospectToken(ctx context.Context, creds ClientAuthCrede
 	}
 
 	// Get the audience from the token
+	// The client ID is always the first audience
 	tokenAudiences, _ := token.Audience()
-	if len(tokenAudiences) != 1 || tokenAudiences[0] == "" {
+	if len(tokenAudiences) == 0 || tokenAudiences[0] == "" {
 		introspectDto.Active = false
 		return introspectDto, nil
 	}
@@ -517,7 +881,7 @@ func (s *OidcService) IntrospectToken(ctx context.Context, creds ClientAuthCrede
 	// Introspect the token
 	switch tokenType {
 	case OAuthAccessTokenJWTType:
-		return s.introspectAccessToken(client.ID, tokenString)
+		return s.introspectAccessToken(ctx, client.ID, tokenString)
 	case OAuthRefreshTokenJWTType:
 		return s.introspectRefreshToken(ctx, client.ID, tokenString)
 	default:
@@ -527,8 +891,8 @@ func (s *OidcService) IntrospectToken(ctx context.Context, creds ClientAuthCrede
 	}
 }
 
-func (s *OidcService) introspectAccessToken(clientID string, tokenString string) (introspectDto dto.OidcIntrospectionResponseDto, err error) {
-	token, err := s.jwtService.VerifyOAuthAccessToken(tokenString)
+func (s *OidcService) introspectAccessToken(ctx context.Context, clientID string, tokenString string) (introspectDto dto.OidcIntrospectionResponseDto, err error) {
+	token, err := s.VerifyOAuthAccessToken(ctx, tokenString)
 	if err != nil {
 		// Every failure we get means the token is invalid. Nothing more to do with the error.
 		introspectDto.Active = false
@@ -537,7 +901,7 @@ func (s *OidcService) introspectAccessToken(clientID string, tokenString string)
 
 	// The ID of the client that made the request must match the client ID in the token
 	audience, ok := token.Audience()
-	if !ok || len(audience) != 1 || audience[0] == "" {
+	if !ok || len(audience) == 0 || audience[0] == "" {
 		introspectDto.Active = false
 		return introspectDto, nil
 	}
@@ -545,6 +909,13 @@ func (s *OidcService) introspectAccessToken(clientID string, tokenString string)
 		return introspectDto, &common.OidcMissingClientCredentialsError{}
 	}
 
+	// Tokens with an actor are only active if their delegation chain was recorded by the exchange that issued them
+	_, err = s.verifyTokenExchangeChain(ctx, token, s.db)
+	if err != nil {
+		introspectDto.Active = false
+		return introspectDto, nil //nolint:nilerr
+	}
+
 	introspectDto.Active = true
 	introspectDto.TokenType = "access_token"
 	introspectDto.Audience = audience
@@ -577,6 +948,12 @@ func (s *OidcService) introspectAccessToken(clientID string, tokenString string)
 	if identifier, ok := token.JwtID(); ok {
 		introspectDto.Identifier = identifier
 	}
+	if token.Has(ActorClaim) {
+		var actor map[string]any
+		if err := token.Get(ActorClaim, &actor); err == nil {
+			introspectDto.Actor = actor
+		}
+	}
 
 	return introspectDto, nil
 }
@@ -623,6 +1000,37 @@ func (s *OidcService) GetClient(ctx context.Context, clientID string) (model.Oid
 	return s.getClientInternal(ctx, clientID, s.db)
 }
 
+// GetClientForLoginRedirect returns the client that the user authorizes after signing in, so that the login pages can show its name and logo.
+// The redirect must be a relative path to the authorize page, as the login pages pass it on after signing in.
+// It returns nil if the redirect isn't an authorization, the client doesn't exist or the client disabled the branding of the login pages.
+func (s *OidcService) GetClientForLoginRedirect(ctx context.Context, redirect string) (*model.OidcClient, error) {
+	redirectURL, err := url.Parse(redirect)
+	if err != nil || redirectURL.Scheme != "" || redirectURL.Host != "" || redirectURL.Path != "/authorize" {
+		return nil, nil //nolint:nilerr
+	}
+
+	clientID := redirectURL.Query().Get("client_id")
+	if clientID == "" {
+		return nil, nil
+	}
+
+	var client model.OidcClient
+	err = s.db.
+		WithContext(ctx).
+		First(&client, "id = ?", clientID).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !client.LoginBrandingEnabled {
+		return nil, nil
+	}
+	return &client, nil
+}
+
 func (s *OidcService) getClientInternal(ctx context.Context, clientID string, tx *gorm.DB) (model.OidcClient, error) {
 	var client model.OidcClient
 	err := tx.
@@ -646,7 +1054,8 @@ func (s *OidcService) ListClients(ctx context.Context, name string, sortedPagina
 		Model(&model.OidcClient{})
 
 	if name != "" {
-		query = query.Where("name LIKE ?", "%"+name+"%")
+		searchQuery, searchArgs := utils.CaseInsensitiveSearch(name, "name")
+		query = query.Where(searchQuery, searchArgs...)
 	}
 
 	// As allowedUserGroupsCount is not a column, we need to manually sort it
@@ -666,12 +1075,27 @@ func (s *OidcService) ListClients(ctx context.Context, name string, sortedPagina
 }
 
 func (s *OidcService) CreateClient(ctx context.Context, input dto.OidcClientCreateDto, userID string) (model.OidcClient, error) {
+	err := s.validateCallbackURLs(ctx, input.CallbackURLs, input.LogoutCallbackURLs)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = validateClientGrantTypes(&input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
 	client := model.OidcClient{
-		CreatedByID: userID,
+		CreatedByID:          userID,
+		LoginBrandingEnabled: true,
+		SubjectType:          ClientSubjectTypePublic,
 	}
 	updateOIDCClientModelFromDto(&client, &input)
+	err = validateClientRequestObjectSettings(&client)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
 
-	err := s.db.
+	err = s.db.
 		WithContext(ctx).
 		Create(&client).
 		Error
@@ -683,13 +1107,22 @@ func (s *OidcService) CreateClient(ctx context.Context, input dto.OidcClientCrea
 }
 
 func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input dto.OidcClientCreateDto) (model.OidcClient, error) {
+	err := s.validateCallbackURLs(ctx, input.CallbackURLs, input.LogoutCallbackURLs)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	err = validateClientGrantTypes(&input)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
 	}()
 
 	var client model.OidcClient
-	err := tx.
+	err = tx.
 		WithContext(ctx).
 		Preload("CreatedBy").
 		First(&client, "id = ?", clientID).
@@ -698,7 +1131,12 @@ func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input d
 		return model.OidcClient{}, err
 	}
 
+	previousSubjectType, previousSector := client.SubjectType, client.PairwiseSectorIdentifier
 	updateOIDCClientModelFromDto(&client, &input)
+	err = validateClientRequestObjectSettings(&client)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
 
 	err = tx.
 		WithContext(ctx).
@@ -708,6 +1146,14 @@ func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input d
 		return model.OidcClient{}, err
 	}
 
+	// The subjects of the users change with the subject type and the sector, so the stored ones have to be recomputed
+	if client.SubjectType != previousSubjectType || client.PairwiseSectorIdentifier != previousSector {
+		err = s.updateClientSubjectsInternal(ctx, &client, tx)
+		if err != nil {
+			return model.OidcClient{}, err
+		}
+	}
+
 	err = tx.Commit().Error
 	if err != nil {
 		return model.OidcClient{}, err
@@ -716,9 +1162,58 @@ func (s *OidcService) UpdateClient(ctx context.Context, clientID string, input d
 	return client, nil
 }
 
+// validateClientGrantTypes checks that public clients don't use the client_credentials and token exchange grants, as they can't authenticate
+func validateClientGrantTypes(input *dto.OidcClientCreateDto) error {
+	if input.IsPublic && slices.Contains(input.GrantTypes, GrantTypeClientCredentials) {
+		return &common.ValidationError{Message: "Public clients can't use the client_credentials grant type"}
+	}
+	if input.IsPublic && slices.Contains(input.GrantTypes, GrantTypeTokenExchange) {
+		return &common.ValidationError{Message: "Public clients can't use the token exchange grant type"}
+	}
+	return nil
+}
+
+// validateClientRequestObjectSettings checks that clients that require signed request objects have a JWK set to verify them
+func validateClientRequestObjectSettings(client *model.OidcClient) error {
+	if client.RequireSignedRequestObject && client.JwksURL == "" {
+		return &common.ValidationError{Message: "A JWK set URL is required to verify signed request objects"}
+	}
+	return nil
+}
+
 func updateOIDCClientModelFromDto(client *model.OidcClient, input *dto.OidcClientCreateDto) {
 	// Base fields
 	client.Name = input.Name
+	// If the grant types are omitted, existing clients keep theirs and new clients get the default ones
+	if input.GrantTypes != nil {
+		client.GrantTypes = slices.Compact(slices.Sorted(slices.Values(input.GrantTypes)))
+	} else if client.GrantTypes == nil {
+		client.GrantTypes = slices.Clone(defaultClientGrantTypes)
+	}
+	if input.ClientCredentialsScopes != nil {
+		client.ClientCredentialsScopes = slices.Compact(slices.Sorted(slices.Values(input.ClientCredentialsScopes)))
+	}
+	if input.Audiences != nil {
+		client.Audiences = slices.Compact(slices.Sorted(slices.Values(input.Audiences)))
+	}
+	if input.JwksURL != nil {
+		client.JwksURL = *input.JwksURL
+	}
+	if input.RequireSignedRequestObject != nil {
+		client.RequireSignedRequestObject = *input.RequireSignedRequestObject
+	}
+	if input.LoginBrandingEnabled != nil {
+		client.LoginBrandingEnabled = *input.LoginBrandingEnabled
+	}
+	if input.RequireEmail != nil {
+		client.RequireEmail = *input.RequireEmail
+	}
+	if input.SubjectType != nil {
+		client.SubjectType = *input.SubjectType
+	}
+	if input.PairwiseSectorIdentifier != nil {
+		client.PairwiseSectorIdentifier = *input.PairwiseSectorIdentifier
+	}
 	client.CallbackURLs = input.CallbackURLs
 	client.LogoutCallbackURLs = input.LogoutCallbackURLs
 	client.IsPublic = input.IsPublic
@@ -795,37 +1290,37 @@ func (s *OidcService) CreateClientSecret(ctx context.Context, clientID string) (
 	return clientSecret, nil
 }
 
-func (s *OidcService) GetClientLogo(ctx context.Context, clientID string) (string, string, error) {
+// GetClientLogo returns the logo of the client and its MIME type
+func (s *OidcService) GetClientLogo(ctx context.Context, clientID string) ([]byte, string, error) {
 	var client model.OidcClient
 	err := s.db.
 		WithContext(ctx).
 		First(&client, "id = ?", clientID).
 		Error
 	if err != nil {
-		return "", "", err
+		return nil, "", err
 	}
 
 	if client.ImageType == nil {
-		return "", "", errors.New("image not found")
+		return nil, "", &common.ImageNotFoundError{}
 	}
 
-	imagePath := common.EnvConfig.UploadPath + "/oidc-client-images/" + client.ID + "." + *client.ImageType
-	mimeType := utils.GetImageMimeType(*client.ImageType)
+	data, err := s.uploadStorage.Get(ctx, clientLogoKey(client.ID, *client.ImageType))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, "", &common.ImageNotFoundError{}
+	} else if err != nil {
+		return nil, "", err
+	}
 
-	return imagePath, mimeType, nil
+	return data, utils.GetImageMimeType(*client.ImageType), nil
 }
 
 func (s *OidcService) UpdateClientLogo(ctx context.Context, clientID string, file *multipart.FileHeader) error {
-	fileType := strings.ToLower(utils.GetFileExtension(file.Filename))
-	if mimeType := utils.GetImageMimeType(fileType); mimeType == "" {
-		return &common.FileTypeNotSupportedError{}
-	}
-
-	imagePath := common.EnvConfig.UploadPath + "/oidc-client-images/" + clientID + "." + fileType
-	err := utils.SaveFile(file, imagePath)
+	img, err := utils.ValidateImageUpload(file, clientLogoOptions)
 	if err != nil {
 		return err
 	}
+	fileType := img.Type
 
 	tx := s.db.Begin()
 	defer func() {
@@ -841,9 +1336,14 @@ func (s *OidcService) UpdateClientLogo(ctx context.Context, clientID string, fil
 		return err
 	}
 
+	err = s.uploadStorage.Put(ctx, clientLogoKey(client.ID, fileType), img.Data, utils.GetImageMimeType(fileType))
+	if err != nil {
+		return err
+	}
+
 	if client.ImageType != nil && fileType != *client.ImageType {
-		oldImagePath := fmt.Sprintf("%s/oidc-client-images/%s.%s", common.EnvConfig.UploadPath, client.ID, *client.ImageType)
-		if err := os.Remove(oldImagePath); err != nil {
+		err = s.uploadStorage.Delete(ctx, clientLogoKey(client.ID, *client.ImageType))
+		if err != nil {
 			return err
 		}
 	}
@@ -881,7 +1381,7 @@ func (s *OidcService) DeleteClientLogo(ctx context.Context, clientID string) err
 	}
 
 	if client.ImageType == nil {
-		return errors.New("image not found")
+		return &common.ImageNotFoundError{}
 	}
 
 	oldImageType := *client.ImageType
@@ -894,8 +1394,8 @@ func (s *OidcService) DeleteClientLogo(ctx context.Context, clientID string) err
 		return err
 	}
 
-	imagePath := common.EnvConfig.UploadPath + "/oidc-client-images/" + client.ID + "." + oldImageType
-	if err := os.Remove(imagePath); err != nil {
+	err = s.uploadStorage.Delete(ctx, clientLogoKey(client.ID, oldImageType))
+	if err != nil {
 		return err
 	}
 
@@ -907,6 +1407,11 @@ func (s *OidcService) DeleteClientLogo(ctx context.Context, clientID string) err
 	return nil
 }
 
+// clientLogoKey returns the key of the logo of a client in the upload storage
+func clientLogoKey(clientID, imageType string) string {
+	return "oidc-client-images/" + clientID + "." + imageType
+}
+
 func (s *OidcService) UpdateAllowedUserGroups(ctx context.Context, id string, input dto.OidcUpdateAllowedUserGroupsDto) (client model.OidcClient, err error) {
 	tx := s.db.Begin()
 	defer func() {
@@ -958,6 +1463,74 @@ func (s *OidcService) UpdateAllowedUserGroups(ctx context.Context, id string, in
 	return client, nil
 }
 
+// AddClientFederatedIdentity adds a federated identity to the client, whose client assertions are then accepted too
+func (s *OidcService) AddClientFederatedIdentity(ctx context.Context, clientID string, input dto.OidcClientFederatedIdentityCreateDto) (model.OidcClient, error) {
+	identity := model.OidcClientFederatedIdentity{
+		Issuer:   input.Issuer,
+		Subject:  input.Subject,
+		Audience: input.Audience,
+		JWKS:     input.JWKS,
+	}
+
+	return s.updateClientFederatedIdentities(ctx, clientID, func(identities []model.OidcClientFederatedIdentity) ([]model.OidcClientFederatedIdentity, error) {
+		// Identities that only differ by their JWK set would be ambiguous
+		if slices.ContainsFunc(identities, func(fi model.OidcClientFederatedIdentity) bool {
+			return fi.Issuer == identity.Issuer && fi.Subject == identity.Subject && fi.Audience == identity.Audience
+		}) {
+			return nil, &common.OidcFederatedIdentityAlreadyExistsError{}
+		}
+		return append(identities, identity), nil
+	})
+}
+
+// RemoveClientFederatedIdentity removes the federated identity with exactly the given issuer, subject and audience from the client
+func (s *OidcService) RemoveClientFederatedIdentity(ctx context.Context, clientID string, input dto.OidcClientFederatedIdentityDeleteDto) (model.OidcClient, error) {
+	return s.updateClientFederatedIdentities(ctx, clientID, func(identities []model.OidcClientFederatedIdentity) ([]model.OidcClientFederatedIdentity, error) {
+		remaining := slices.DeleteFunc(identities, func(fi model.OidcClientFederatedIdentity) bool {
+			return fi.Issuer == input.Issuer && fi.Subject == input.Subject && fi.Audience == input.Audience
+		})
+		if len(remaining) == len(identities) {
+			return nil, &common.OidcFederatedIdentityNotFoundError{}
+		}
+		return remaining, nil
+	})
+}
+
+// updateClientFederatedIdentities replaces the federated identities of the client with the ones returned by updateFn
+func (s *OidcService) updateClientFederatedIdentities(ctx context.Context, clientID string, updateFn func(identities []model.OidcClientFederatedIdentity) ([]model.OidcClientFederatedIdentity, error)) (model.OidcClient, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	client, err := s.getClientInternal(ctx, clientID, tx)
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
+	identities, err := updateFn(slices.Clone(client.Credentials.FederatedIdentities))
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+	client.Credentials.FederatedIdentities = identities
+
+	err = tx.
+		WithContext(ctx).
+		Model(&client).
+		Update("credentials", client.Credentials).
+		Error
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
+	err = tx.Commit().Error
+	if err != nil {
+		return model.OidcClient{}, err
+	}
+
+	return client, nil
+}
+
 // ValidateEndSession returns the logout callback URL for the client if all the validations pass
 func (s *OidcService) ValidateEndSession(ctx context.Context, input dto.OidcLogoutDto, userID string) (string, error) {
 	// If no ID token hint is provided, return an error
@@ -1005,7 +1578,7 @@ func (s *OidcService) ValidateEndSession(ctx context.Context, input dto.OidcLogo
 	return callbackURL, nil
 }
 
-func (s *OidcService) createAuthorizationCode(ctx context.Context, clientID string, userID string, scope string, nonce string, codeChallenge string, codeChallengeMethod string, tx *gorm.DB) (string, error) {
+func (s *OidcService) createAuthorizationCode(ctx context.Context, clientID string, userID string, scope string, nonce string, codeChallenge string, codeChallengeMethod string, authTime time.Time, authMethods []string, acr string, resources []string, tx *gorm.DB) (string, error) {
 	randomString, err := utils.GenerateRandomAlphanumericString(32)
 	if err != nil {
 		return "", err
@@ -1014,7 +1587,7 @@ func (s *OidcService) createAuthorizationCode(ctx context.Context, clientID stri
 	codeChallengeMethodSha256 := strings.ToUpper(codeChallengeMethod) == "S256"
 
 	oidcAuthorizationCode := model.OidcAuthorizationCode{
-		ExpiresAt:                 datatype.DateTime(time.Now().Add(15 * time.Minute)),
+		ExpiresAt:                 datatype.DateTime(time.Now().Add(s.authorizationCodeLifetime())),
 		Code:                      randomString,
 		ClientID:                  clientID,
 		UserID:                    userID,
@@ -1022,6 +1595,13 @@ func (s *OidcService) createAuthorizationCode(ctx context.Context, clientID stri
 		Nonce:                     nonce,
 		CodeChallenge:             &codeChallenge,
 		CodeChallengeMethodSha256: &codeChallengeMethodSha256,
+		AuthMethods:               strings.Join(authMethods, " "),
+		Acr:                       acr,
+		Resources:                 resources,
+	}
+	if !authTime.IsZero() {
+		authTimeValue := datatype.DateTime(authTime)
+		oidcAuthorizationCode.AuthTime = &authTimeValue
 	}
 
 	err = tx.
@@ -1055,34 +1635,37 @@ func (s *OidcService) validateCodeVerifier(codeVerifier, codeChallenge string, c
 	return encodedVerifierHash == codeChallenge
 }
 
-func (s *OidcService) getCallbackURL(client *model.OidcClient, inputCallbackURL string, tx *gorm.DB, ctx context.Context) (callbackURL string, err error) {
+// getCallbackURL returns the callback URL of the client that matches the input.
+// If the client has no callback URLs yet, the input is trusted on first use (TOFU) and registered is false;
+// the caller stores the URL with addCallbackURLToClient once the authorization succeeded.
+func (s *OidcService) getCallbackURL(ctx context.Context, client *model.OidcClient, inputCallbackURL string) (callbackURL string, registered bool, err error) {
 	// If no input callback URL provided, use the first configured URL
 	if inputCallbackURL == "" {
 		if len(client.CallbackURLs) > 0 {
-			return client.CallbackURLs[0], nil
+			return client.CallbackURLs[0], true, nil
 		}
 		// If no URLs are configured and no input URL, this is an error
-		return "", &common.OidcMissingCallbackURLError{}
+		return "", false, &common.OidcMissingCallbackURLError{}
 	}
 
 	// If URLs are already configured, validate against them
 	if len(client.CallbackURLs) > 0 {
 		matched, err := s.getCallbackURLFromList(client.CallbackURLs, inputCallbackURL)
 		if err != nil {
-			return "", err
+			return "", false, err
 		} else if matched == "" {
-			return "", &common.OidcInvalidCallbackURLError{}
+			return "", false, &common.OidcInvalidCallbackURLError{}
 		}
 
-		return matched, nil
+		return matched, true, nil
 	}
 
-	// If no URLs are configured, trust and store the first URL (TOFU)
-	err = s.addCallbackURLToClient(ctx, client, inputCallbackURL, tx)
+	// If no URLs are configured, trust the first URL (TOFU)
+	err = s.validateCallbackURLs(ctx, []string{inputCallbackURL})
 	if err != nil {
-		return "", err
+		return "", false, err
 	}
-	return inputCallbackURL, nil
+	return inputCallbackURL, false, nil
 }
 
 func (s *OidcService) getLogoutCallbackURL(client *model.OidcClient, inputLogoutCallbackURL string) (callbackURL string, err error) {
@@ -1115,6 +1698,87 @@ func (s *OidcService) getCallbackURLFromList(urls []string, inputCallbackURL str
 	return "", nil
 }
 
+// validateCallbackURLs checks that the callback URLs are allowed by the redirect policy of the instance
+func (s *OidcService) validateCallbackURLs(ctx context.Context, callbackURLLists ...[]string) error {
+	for _, callbackURLs := range callbackURLLists {
+		for _, callbackURL := range callbackURLs {
+			err := s.validateCallbackURL(ctx, callbackURL)
+			if err != nil {
+				return &common.ValidationError{Message: fmt.Sprintf("Callback URL '%s' is not allowed: %v", callbackURL, err)}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *OidcService) validateCallbackURL(ctx context.Context, callbackURL string) error {
+	scheme, _, ok := strings.Cut(callbackURL, ":")
+	if !ok || scheme == "" {
+		return errors.New("the URL has no scheme")
+	}
+	if strings.Contains(scheme, "*") {
+		return errors.New("the scheme can't contain wildcards")
+	}
+	scheme = strings.ToLower(scheme)
+
+	// Wildcards aren't valid in URLs, so they're replaced to parse the URL
+	parsed, err := url.Parse(strings.ReplaceAll(callbackURL, "*", "x"))
+	if err != nil {
+		return errors.New("the URL is invalid")
+	}
+	host := strings.ToLower(parsed.Hostname())
+	hostHasWildcard := hostPatternHasWildcard(callbackURL)
+	loopback := !hostHasWildcard && isLoopbackHost(host)
+
+	if !slices.Contains(common.EnvConfig.CallbackURLAllowedSchemes, scheme) && (scheme != "http" || !loopback) {
+		return fmt.Errorf("the scheme '%s' is not allowed", scheme)
+	}
+
+	// Hosts with wildcards can't be resolved
+	if !common.EnvConfig.CallbackURLBlockPrivateIPs || host == "" || hostHasWildcard || loopback {
+		return nil
+	}
+
+	var ips []net.IP
+	if ip := net.ParseIP(host); ip != nil {
+		ips = []net.IP{ip}
+	} else {
+		ips, err = net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			// The host may only be resolvable from the network of the client
+			slog.DebugContext(ctx, "Failed to resolve the host of a callback URL", slog.String("host", host), slog.Any("error", err))
+			return nil
+		}
+	}
+
+	for _, ip := range ips {
+		if s.geoLiteService.IsPrivateIP(ip) {
+			return errors.New("the host resolves to a private IP address")
+		}
+	}
+
+	return nil
+}
+
+// hostPatternHasWildcard returns true if the host of the callback URL pattern contains a wildcard
+func hostPatternHasWildcard(callbackURL string) bool {
+	_, rest, ok := strings.Cut(callbackURL, "://")
+	if !ok {
+		return false
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	return strings.Contains(host, "*")
+}
+
+// isLoopbackHost returns true if the host is localhost or a loopback IP address
+func isLoopbackHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func (s *OidcService) addCallbackURLToClient(ctx context.Context, client *model.OidcClient, callbackURL string, tx *gorm.DB) error {
 	// Add the new callback URL to the existing list
 	client.CallbackURLs = append(client.CallbackURLs, callbackURL)
@@ -1137,6 +1801,9 @@ func (s *OidcService) CreateDeviceAuthorization(ctx context.Context, input dto.O
 	if err != nil {
 		return nil, err
 	}
+	if !clientAllowsGrantType(client, GrantTypeDeviceCode) {
+		return nil, &common.OidcUnauthorizedClientError{}
+	}
 
 	// Generate codes
 	deviceCode, err := utils.GenerateRandomAlphanumericString(32)
@@ -1207,6 +1874,14 @@ func (s *OidcService) VerifyDeviceCode(ctx context.Context, userCode string, use
 		return &common.OidcAccessDeniedError{}
 	}
 
+	err = checkClientEmailRequirement(&deviceAuth.Client, &user)
+	if err != nil {
+		// Release the transaction first, as SQLite allows only one writer
+		tx.Rollback()
+		s.logClientAuthorizationBlocked(ctx, &deviceAuth.Client, &user, ipAddress, userAgent)
+		return err
+	}
+
 	err = tx.
 		WithContext(ctx).
 		Preload("Client").
@@ -1239,7 +1914,7 @@ func (s *OidcService) VerifyDeviceCode(ctx context.Context, userCode string, use
 
 	auditLogData := model.AuditLogData{"clientName": deviceAuth.Client.Name}
 	if !hasAuthorizedClient {
-		err = s.createAuthorizedClientInternal(ctx, userID, deviceAuth.ClientID, deviceAuth.Scope, tx)
+		err = s.createAuthorizedClientInternal(ctx, userID, &deviceAuth.Client, deviceAuth.Scope, tx)
 		if err != nil {
 			return err
 		}
@@ -1322,7 +1997,7 @@ func (s *OidcService) ListAuthorizedClients(ctx context.Context, userID string,
 	return authorizedClients, response, err
 }
 
-func (s *OidcService) createRefreshToken(ctx context.Context, clientID string, userID string, scope string, tx *gorm.DB) (string, error) {
+func (s *OidcService) createRefreshToken(ctx context.Context, clientID string, userID string, scope string, resources []string, ipAddress string, sessionStartedAt time.Time, tx *gorm.DB) (string, error) {
 	refreshToken, err := utils.GenerateRandomAlphanumericString(40)
 	if err != nil {
 		return "", err
@@ -1333,11 +2008,16 @@ func (s *OidcService) createRefreshToken(ctx context.Context, clientID string, u
 	refreshTokenHash := utils.CreateSha256Hash(refreshToken)
 
 	m := model.OidcRefreshToken{
-		ExpiresAt: datatype.DateTime(time.Now().Add(RefreshTokenDuration)),
-		Token:     refreshTokenHash,
-		ClientID:  clientID,
-		UserID:    userID,
-		Scope:     scope,
+		ExpiresAt:        datatype.DateTime(time.Now().Add(s.refreshTokenLifetime())),
+		Token:            refreshTokenHash,
+		ClientID:         clientID,
+		UserID:           userID,
+		Scope:            scope,
+		SessionStartedAt: utils.Ptr(datatype.DateTime(sessionStartedAt)),
+		Resources:        resources,
+	}
+	if ipAddress != "" {
+		m.IpAddress = &ipAddress
 	}
 
 	err = tx.
@@ -1357,17 +2037,91 @@ func (s *OidcService) createRefreshToken(ctx context.Context, clientID string, u
 	return signed, nil
 }
 
-func (s *OidcService) createAuthorizedClientInternal(ctx context.Context, userID string, clientID string, scope string, tx *gorm.DB) error {
+// refreshTokenLifetime returns how long a refresh token can be used.
+// With an idle timeout, clients that don't refresh their tokens within the idle timeout after the access token expired lose the session;
+// as refresh tokens are rotated on every use, each refresh resets the idle timeout.
+func (s *OidcService) refreshTokenLifetime() time.Duration {
+	idleTimeout := s.appConfigService.GetDbConfig().SessionIdleTimeout.AsDurationMinutes()
+	if idleTimeout <= 0 {
+		return RefreshTokenDuration
+	}
+	// Access tokens are valid for an hour
+	return min(RefreshTokenDuration, time.Hour+idleTimeout)
+}
+
+// createRefreshTokenIfAllowed creates a refresh token if the client can use the refresh_token grant type, and returns an empty string otherwise
+func (s *OidcService) createRefreshTokenIfAllowed(ctx context.Context, client *model.OidcClient, userID string, scope string, resources []string, ipAddress string, sessionStartedAt time.Time, tx *gorm.DB) (string, error) {
+	if !clientAllowsGrantType(client, GrantTypeRefreshToken) {
+		return "", nil
+	}
+	return s.createRefreshToken(ctx, client.ID, userID, scope, resources, ipAddress, sessionStartedAt, tx)
+}
+
+// resolveAccessTokenAudiences returns the additional audiences of the access token requested with the "resource" and "audience" parameters.
+// If the grant is limited to the resources requested in the authorization request, only those can be requested (RFC 8707, section 2.2).
+// If none are requested, all the allowed audiences are included; the client ID is always the first audience and can be omitted.
+func resolveAccessTokenAudiences(client *model.OidcClient, input *dto.OidcCreateTokensDto, grantedResources []string) ([]string, error) {
+	allowed := client.Audiences
+	if len(grantedResources) > 0 {
+		// The audiences of the client may have changed since the resources were granted
+		allowed = slices.DeleteFunc(slices.Clone(grantedResources), func(resource string) bool {
+			return !slices.Contains(client.Audiences, resource)
+		})
+	}
+
+	requested := make([]string, 0, len(input.Resource)+len(input.Audience))
+	for _, target := range slices.Concat(input.Resource, input.Audience) {
+		if target == client.ID || slices.Contains(requested, target) {
+			continue
+		}
+		if !slices.Contains(allowed, target) {
+			return nil, &common.OidcInvalidTargetError{Target: target}
+		}
+		requested = append(requested, target)
+	}
+
+	if len(requested) == 0 {
+		return allowed, nil
+	}
+	return requested, nil
+}
+
+// validateAuthorizationResources checks that the resources requested in an authorization request are audiences of the client, or the client itself
+func validateAuthorizationResources(client *model.OidcClient, resources []string) ([]string, error) {
+	validated := make([]string, 0, len(resources))
+	for _, resource := range resources {
+		if slices.Contains(validated, resource) {
+			continue
+		}
+		if resource != client.ID && !slices.Contains(client.Audiences, resource) {
+			return nil, &common.OidcInvalidTargetError{Target: resource}
+		}
+		validated = append(validated, resource)
+	}
+	return validated, nil
+}
+
+// clientAllowsGrantType returns true if the client can use the grant type.
+// Clients without grant types can use the default ones.
+func clientAllowsGrantType(client *model.OidcClient, grantType string) bool {
+	if len(client.GrantTypes) == 0 {
+		return slices.Contains(defaultClientGrantTypes, grantType)
+	}
+	return slices.Contains(client.GrantTypes, grantType)
+}
+
+func (s *OidcService) createAuthorizedClientInternal(ctx context.Context, userID string, client *model.OidcClient, scope string, tx *gorm.DB) error {
 	userAuthorizedClient := model.UserAuthorizedOidcClient{
 		UserID:   userID,
-		ClientID: clientID,
+		ClientID: client.ID,
 		Scope:    scope,
+		Subject:  s.storedClientSubject(client, userID),
 	}
 
 	err := tx.WithContext(ctx).
 		Clauses(clause.OnConflict{
 			Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
-			DoUpdates: clause.AssignmentColumns([]string{"scope"}),
+			DoUpdates: clause.AssignmentColumns([]string{"scope", "subject"}),
 		}).
 		Create(&userAuthorizedClient).
 		Error
@@ -1436,9 +2190,14 @@ func (s *OidcService) verifyClientCredentialsInternal(ctx context.Context, tx *g
 	// Next, check if we want to use client assertions from federated identities
 	case isClientAssertion:
 		err = s.verifyClientAssertionFromFederatedIdentities(ctx, client, input)
-		if err != nil {
+		var jwksErr *common.OidcFederatedJwksUnavailableError
+		if errors.As(err, &jwksErr) {
+			// Unlike an invalid assertion, this is most likely a configuration or network issue the admin has to fix
+			slog.ErrorContext(ctx, "Failed to fetch the JWK set of a federated identity", slog.String("client", client.ID), slog.String("issuer", jwksErr.Issuer), slog.String("url", jwksErr.URL), slog.Any("error", jwksErr.Err))
+			return nil, jwksErr
+		} else if err != nil {
 			slog.WarnContext(ctx, "Invalid assertion for client", slog.String("client", client.ID), slog.Any("error", err))
-			return nil, &common.OidcClientAssertionInvalidError{}
+			return nil, &common.OidcClientAssertionInvalidError{Reason: clientAssertionErrorReason(err)}
 		}
 		return client, nil
 
@@ -1481,6 +2240,47 @@ func (s *OidcService) jwkSetForURL(ctx context.Context, url string) (set jwk.Set
 	return jwks, nil
 }
 
+// Signing algorithms accepted for the client assertions of federated identities.
+// Symmetric algorithms aren't accepted, as the keys of the JWK set are public, and neither are unsigned tokens.
+var federatedClientAssertionAlgorithms = []string{
+	jwa.RS256().String(), jwa.RS384().String(), jwa.RS512().String(),
+	jwa.PS256().String(), jwa.PS384().String(), jwa.PS512().String(),
+	jwa.ES256().String(), jwa.ES384().String(), jwa.ES512().String(),
+	jwa.EdDSA().String(),
+}
+
+// Reasons why the client assertion of a federated identity is rejected, which are returned to the client
+var (
+	errClientAssertionIssuerNotAllowed    = errors.New("the issuer is not a federated identity of the client")
+	errClientAssertionAlgorithmNotAllowed = errors.New("the signing algorithm is not allowed")
+	errClientAssertionSignatureInvalid    = errors.New("the signature can't be verified with the keys of the issuer")
+	errClientAssertionSubjectMismatch     = errors.New("the subject doesn't match the federated identity")
+	errClientAssertionAudienceMismatch    = errors.New("the audience doesn't match the federated identity")
+	errClientAssertionExpirationMissing   = errors.New("the assertion has no expiration time")
+	errClientAssertionExpired             = errors.New("the assertion is expired")
+	errClientAssertionNotYetValid         = errors.New("the assertion is not valid yet")
+	errClientAssertionIssuedInFuture      = errors.New("the assertion is issued in the future")
+
+	clientAssertionErrors = []error{
+		errClientAssertionIssuerNotAllowed, errClientAssertionAlgorithmNotAllowed, errClientAssertionSignatureInvalid,
+		errClientAssertionSubjectMismatch, errClientAssertionAudienceMismatch, errClientAssertionExpirationMissing,
+		errClientAssertionExpired, errClientAssertionNotYetValid, errClientAssertionIssuedInFuture,
+	}
+)
+
+// clientAssertionErrorReason returns the reason why a client assertion was rejected, without the values of the claims, or an empty string if the reason is unknown
+func clientAssertionErrorReason(err error) string {
+	for _, reason := range clientAssertionErrors {
+		if errors.Is(err, reason) {
+			return reason.Error()
+		}
+	}
+	return ""
+}
+
+// verifyClientAssertionFromFederatedIdentities verifies a client assertion issued by a federated identity of the client.
+// The issuer, subject and audience must exactly match the ones of a federated identity, and the assertion must have an expiration time.
+// If the client has several federated identities for the issuer, the assertion is accepted if it matches any of them.
 func (s *OidcService) verifyClientAssertionFromFederatedIdentities(ctx context.Context, client *model.OidcClient, input ClientAuthCredentials) error {
 	// First, parse the assertion JWT, without validating it, to check the issuer
 	assertion := []byte(input.ClientAssertion)
@@ -1495,53 +2295,102 @@ func (s *OidcService) verifyClientAssertionFromFederatedIdentities(ctx context.C
 	}
 
 	// Ensure that this client is federated with the one that issued the token
-	ocfi, ok := client.Credentials.FederatedIdentityForIssuer(issuer)
-	if !ok {
-		return fmt.Errorf("client assertion is not from an allowed issuer: %s", issuer)
+	identities := client.Credentials.FederatedIdentitiesForIssuer(issuer)
+	if len(identities) == 0 {
+		return fmt.Errorf("%w: %s", errClientAssertionIssuerNotAllowed, issuer)
 	}
 
-	// Get the JWK set for the issuer
-	jwksURL := ocfi.JWKS
-	if jwksURL == "" {
-		// Default URL is from the issuer
-		if strings.HasSuffix(issuer, "/") {
-			jwksURL = issuer + ".well-known/jwks.json"
-		} else {
-			jwksURL = issuer + "/.well-known/jwks.json"
+	// Check the algorithm before fetching the keys, so unsigned tokens and tokens signed with a symmetric algorithm are rejected early
+	msg, err := jws.Parse(assertion, jws.WithCompact())
+	if err != nil {
+		return fmt.Errorf("failed to parse client assertion JWS: %w", err)
+	}
+	if len(msg.Signatures()) != 1 {
+		return errors.New("client assertion must have exactly one signature")
+	}
+	alg, _ := msg.Signatures()[0].ProtectedHeaders().Algorithm()
+	if !slices.Contains(federatedClientAssertionAlgorithms, alg.String()) {
+		return fmt.Errorf("%w: %s", errClientAssertionAlgorithmNotAllowed, alg.String())
+	}
+
+	errs := make([]error, 0, len(identities))
+	for _, ocfi := range identities {
+		err = s.verifyClientAssertionForFederatedIdentity(ctx, client, ocfi, assertion)
+		if err == nil {
+			return nil
 		}
+		errs = append(errs, err)
 	}
-	jwks, err := s.jwkSetForURL(ctx, jwksURL)
+
+	return errors.Join(errs...)
+}
+
+// verifyClientAssertionForFederatedIdentity verifies the signature and the claims of a client assertion with the JWK set of a federated identity
+func (s *OidcService) verifyClientAssertionForFederatedIdentity(ctx context.Context, client *model.OidcClient, ocfi model.OidcClientFederatedIdentity, assertion []byte) error {
+	// Get the JWK set of the federated identity; identities of the same issuer may use different ones
+	jwks, err := s.federatedJwkSet(ctx, ocfi.Issuer, federatedIdentityJWKSURL(ocfi))
 	if err != nil {
-		return fmt.Errorf("failed to get JWK set for issuer '%s': %w", issuer, err)
+		return err
 	}
 
-	// Set default audience and subject if missing
-	audience := ocfi.Audience
-	if audience == "" {
-		// Default to the Pocket ID's URL
-		audience = common.EnvConfig.AppURL
+	// Now re-parse the token and verify its signature, so its claims can be trusted
+	token, err := jwt.Parse(assertion,
+		jwt.WithValidate(false),
+		jwt.WithKeySet(jwks, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
+	)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errClientAssertionSignatureInvalid, err)
 	}
+
+	// The subject defaults to the client ID, per RFC 7523
 	subject := ocfi.Subject
 	if subject == "" {
-		// Default to the client ID, per RFC 7523
 		subject = client.ID
 	}
+	if sub, _ := token.Subject(); sub != subject {
+		return fmt.Errorf("%w: expected '%s', got '%s'", errClientAssertionSubjectMismatch, subject, sub)
+	}
+
+	// The audience defaults to the Pocket ID's URL
+	audience := ocfi.Audience
+	if audience == "" {
+		audience = common.EnvConfig.AppURL
+	}
+	if aud, _ := token.Audience(); !slices.Contains(aud, audience) {
+		return fmt.Errorf("%w: expected '%s', got %q", errClientAssertionAudienceMismatch, audience, aud)
+	}
 
-	// Now re-parse the token with proper validation
-	// (Note: we don't use jwt.WithIssuer() because that would be redundant)
-	_, err = jwt.Parse(assertion,
-		jwt.WithValidate(true),
+	// Check the "exp", "nbf" and "iat" claims
+	err = jwt.Validate(token,
 		jwt.WithAcceptableSkew(clockSkew),
-		jwt.WithKeySet(jwks, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
-		jwt.WithAudience(audience),
-		jwt.WithSubject(subject),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
 	)
-	if err != nil {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, jwt.MissingRequiredClaimError()):
+		return errClientAssertionExpirationMissing
+	case errors.Is(err, jwt.TokenExpiredError()):
+		return fmt.Errorf("%w: %w", errClientAssertionExpired, err)
+	case errors.Is(err, jwt.TokenNotYetValidError()):
+		return fmt.Errorf("%w: %w", errClientAssertionNotYetValid, err)
+	case errors.Is(err, jwt.InvalidIssuedAtError()):
+		return fmt.Errorf("%w: %w", errClientAssertionIssuedInFuture, err)
+	default:
 		return fmt.Errorf("client assertion is not valid: %w", err)
 	}
+}
 
-	// If we're here, the assertion is valid
-	return nil
+// federatedIdentityJWKSURL returns the URL of the JWK set of a federated identity, which defaults to the well-known URL of the issuer
+func federatedIdentityJWKSURL(ocfi model.OidcClientFederatedIdentity) string {
+	if ocfi.JWKS != "" {
+		return ocfi.JWKS
+	}
+
+	if strings.HasSuffix(ocfi.Issuer, "/") {
+		return ocfi.Issuer + ".well-known/jwks.json"
+	}
+	return ocfi.Issuer + "/.well-known/jwks.json"
 }
 
 // extractClientIDFromAssertion extracts the client_id from the JWT assertion's 'sub' claim
@@ -1561,6 +2410,8 @@ func (s *OidcService) extractClientIDFromAssertion(assertion string) (string, er
 	return sub, nil
 }
 
+// GetClientPreview returns the payloads of the tokens and the userinfo that would be sent to the client for the user, without issuing any token.
+// It also returns where each claim comes from. If the scopes are empty, the scopes that the user has authorized for the client are used.
 func (s *OidcService) GetClientPreview(ctx context.Context, clientID string, userID string, scopes string) (*dto.OidcClientPreviewDto, error) {
 	tx := s.db.Begin()
 	defer func() {
@@ -1586,14 +2437,30 @@ func (s *OidcService) GetClientPreview(ctx context.Context, clientID string, use
 		return nil, &common.OidcAccessDeniedError{}
 	}
 
+	if scopes == "" {
+		var authorizedClient model.UserAuthorizedOidcClient
+		err = tx.
+			WithContext(ctx).
+			First(&authorizedClient, "user_id = ? AND client_id = ?", userID, clientID).
+			Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, &common.ValidationError{Message: "the user hasn't authorized the client, so the scopes are required"}
+		} else if err != nil {
+			return nil, err
+		}
+		scopes = authorizedClient.Scope
+	}
+
 	dummyAuthorizedClient := model.UserAuthorizedOidcClient{
 		UserID:   userID,
 		ClientID: clientID,
 		Scope:    scopes,
+		Subject:  s.storedClientSubject(&client, userID),
 		User:     user,
 	}
 
-	userClaims, err := s.getUserClaimsFromAuthorizedClient(ctx, &dummyAuthorizedClient, tx)
+	claimSources := make(map[string]string)
+	userClaims, err := s.buildUserClaims(ctx, &dummyAuthorizedClient, tx, claimSources)
 	if err != nil {
 		return nil, err
 	}
@@ -1609,7 +2476,7 @@ func (s *OidcService) GetClientPreview(ctx context.Context, clientID string, use
 		return nil, err
 	}
 
-	accessToken, err := s.jwtService.BuildOAuthAccessToken(user, clientID)
+	accessToken, err := s.jwtService.BuildOAuthAccessToken(s.clientSubject(&client, userID), clientID, client.Audiences)
 	if err != nil {
 		return nil, err
 	}
@@ -1625,14 +2492,28 @@ func (s *OidcService) GetClientPreview(ctx context.Context, clientID string, use
 	}
 
 	return &dto.OidcClientPreviewDto{
-		IdToken:     idTokenPayload,
-		AccessToken: accessTokenPayload,
-		UserInfo:    userClaims,
+		Scopes:       strings.Fields(scopes),
+		IdToken:      idTokenPayload,
+		AccessToken:  accessTokenPayload,
+		UserInfo:     userClaims,
+		ClaimSources: claimSources,
 	}, nil
 }
 
-func (s *OidcService) GetUserClaimsForClient(ctx context.Context, userID string, clientID string) (map[string]any, error) {
-	return s.getUserClaimsForClientInternal(ctx, userID, clientID, s.db)
+// GetUserClaimsForClient returns the claims of the user with the subject of an access token issued to the client.
+// The subject is the user ID, or the pairwise subject of the user if the client uses pairwise subjects.
+func (s *OidcService) GetUserClaimsForClient(ctx context.Context, subject string, clientID string) (map[string]any, error) {
+	var authorizedOidcClient model.UserAuthorizedOidcClient
+	err := s.db.
+		WithContext(ctx).
+		Preload("User.UserGroups").
+		First(&authorizedOidcClient, "client_id = ? AND (subject = ? OR (subject IS NULL AND user_id = ?))", clientID, subject, subject).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getUserClaimsFromAuthorizedClient(ctx, &authorizedOidcClient, s.db)
 }
 
 func (s *OidcService) getUserClaimsForClientInternal(ctx context.Context, userID string, clientID string, tx *gorm.DB) (map[string]any, error) {
@@ -1651,15 +2532,31 @@ func (s *OidcService) getUserClaimsForClientInternal(ctx context.Context, userID
 }
 
 func (s *OidcService) getUserClaimsFromAuthorizedClient(ctx context.Context, authorizedClient *model.UserAuthorizedOidcClient, tx *gorm.DB) (map[string]any, error) {
+	return s.buildUserClaims(ctx, authorizedClient, tx, nil)
+}
+
+// buildUserClaims returns the claims of the user for the scopes of the authorized client.
+// If sources is not nil, it's populated with where each claim comes from.
+func (s *OidcService) buildUserClaims(ctx context.Context, authorizedClient *model.UserAuthorizedOidcClient, tx *gorm.DB, sources map[string]string) (map[string]any, error) {
 	user := authorizedClient.User
 	scopes := strings.Split(authorizedClient.Scope, " ")
 
 	claims := make(map[string]any, 10)
+	setClaim := func(key string, value any, source string) {
+		claims[key] = value
+		if sources != nil {
+			sources[key] = source
+		}
+	}
 
-	claims["sub"] = user.ID
+	if authorizedClient.Subject != nil {
+		setClaim("sub", *authorizedClient.Subject, "client:pairwise")
+	} else {
+		setClaim("sub", user.ID, "user")
+	}
 	if slices.Contains(scopes, "email") {
-		claims["email"] = user.Email
-		claims["email_verified"] = s.appConfigService.GetDbConfig().EmailsVerified.IsTrue()
+		setClaim("email", user.Email, "scope:email")
+		setClaim("email_verified", s.appConfigService.GetDbConfig().EmailsVerified.IsTrue(), "scope:email")
 	}
 
 	if slices.Contains(scopes, "groups") {
@@ -1667,16 +2564,16 @@ func (s *OidcService) getUserClaimsFromAuthorizedClient(ctx context.Context, aut
 		for i, group := range user.UserGroups {
 			userGroups[i] = group.Name
 		}
-		claims["groups"] = userGroups
+		setClaim("groups", userGroups, "scope:groups")
 	}
 
 	if slices.Contains(scopes, "profile") {
 		// Add profile claims
-		claims["given_name"] = user.FirstName
-		claims["family_name"] = user.LastName
-		claims["name"] = user.FullName()
-		claims["preferred_username"] = user.Username
-		claims["picture"] = common.EnvConfig.AppURL + "/api/users/" + user.ID + "/profile-picture.png"
+		setClaim("given_name", user.FirstName, "scope:profile")
+		setClaim("family_name", user.LastName, "scope:profile")
+		setClaim("name", s.nameClaim(user), "scope:profile")
+		setClaim("preferred_username", s.preferredUsernameClaim(user), "scope:profile")
+		setClaim("picture", common.EnvConfig.AppURL+"/api/users/"+user.ID+"/profile-picture.png", "scope:profile")
 
 		// Add custom claims
 		customClaims, err := s.customClaimService.GetCustomClaimsForUserWithUserGroups(ctx, user.ID, tx)
@@ -1685,22 +2582,68 @@ func (s *OidcService) getUserClaimsFromAuthorizedClient(ctx context.Context, aut
 		}
 
 		for _, customClaim := range customClaims {
+			source := "custom claim of the user"
+			if customClaim.UserGroupID != nil {
+				source = "custom claim of a group"
+				for _, group := range user.UserGroups {
+					if group.ID == *customClaim.UserGroupID {
+						source = "custom claim of the group '" + group.Name + "'"
+						break
+					}
+				}
+			}
+
 			// The value of the custom claim can be a JSON object or a string
 			var jsonValue any
 			err := json.Unmarshal([]byte(customClaim.Value), &jsonValue)
 			if err == nil {
 				// It's JSON, so we store it as an object
-				claims[customClaim.Key] = jsonValue
+				setClaim(customClaim.Key, jsonValue, source)
 			} else {
 				// Marshaling failed, so we store it as a string
-				claims[customClaim.Key] = customClaim.Value
+				setClaim(customClaim.Key, customClaim.Value, source)
 			}
 		}
 	}
 
 	if slices.Contains(scopes, "email") {
-		claims["email"] = user.Email
+		setClaim("email", user.Email, "scope:email")
 	}
 
 	return claims, nil
 }
+
+// nameClaim returns the name of the user, composed with the name claim template if it's configured.
+// The username is used if the name would be empty.
+func (s *OidcService) nameClaim(user model.User) string {
+	name := strings.TrimSpace(user.FullName())
+	if template := s.appConfigService.GetDbConfig().NameClaimTemplate.Value; template != "" {
+		rendered, err := utils.RenderNameTemplate(template, map[string]string{
+			"firstName": user.FirstName,
+			"lastName":  user.LastName,
+			"username":  user.Username,
+			"email":     user.Email,
+		})
+		if err != nil {
+			// The template is validated when it's saved, so this should never happen
+			slog.Warn("Invalid name claim template, using the full name", slog.Any("error", err))
+		} else {
+			name = rendered
+		}
+	}
+
+	if name == "" {
+		return user.Username
+	}
+	return name
+}
+
+// preferredUsernameClaim returns the username of the user, or the local part of the email address if configured
+func (s *OidcService) preferredUsernameClaim(user model.User) string {
+	if s.appConfigService.GetDbConfig().PreferredUsernameClaim.Value == "emailLocalPart" {
+		if i := strings.LastIndex(user.Email, "@"); i > 0 {
+			return user.Email[:i]
+		}
+	}
+	return user.Username
+}
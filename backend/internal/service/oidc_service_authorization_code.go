@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// Lifetime of authorization codes if the configured one is invalid
+const defaultAuthorizationCodeLifetime = time.Minute
+
+// authorizationCodeLifetime returns how long an authorization code can be exchanged for tokens
+func (s *OidcService) authorizationCodeLifetime() time.Duration {
+	lifetime := s.appConfigService.GetDbConfig().AuthorizationCodeLifetime.AsDurationSeconds()
+	if lifetime <= 0 {
+		return defaultAuthorizationCodeLifetime
+	}
+	return lifetime
+}
+
+// revokeReusedAuthorizationCodeInternal revokes the refresh tokens issued when the authorization code was first used, and records the reuse in the audit log.
+// The refresh tokens are found by the start of their session, which is the time the code was used and is kept when they are rotated.
+// Access tokens aren't stored, so they stay valid until they expire.
+func (s *OidcService) revokeReusedAuthorizationCodeInternal(ctx context.Context, authorizationCode *model.OidcAuthorizationCode, client *model.OidcClient, ipAddress, userAgent string, tx *gorm.DB) error {
+	result := tx.
+		WithContext(ctx).
+		Where("user_id = ? AND client_id = ? AND session_started_at = ?", authorizationCode.UserID, authorizationCode.ClientID, authorizationCode.UsedAt).
+		Delete(&model.OidcRefreshToken{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", result.Error)
+	}
+
+	slog.WarnContext(ctx, "Authorization code was reused",
+		slog.String("client", client.ID),
+		slog.String("user", authorizationCode.UserID),
+		slog.Int64("revokedRefreshTokens", result.RowsAffected),
+	)
+
+	s.auditLogService.Create(ctx, model.AuditLogEventAuthorizationCodeReused, ipAddress, userAgent, authorizationCode.UserID, model.AuditLogData{
+		"clientName":           client.Name,
+		"clientId":             client.ID,
+		"revokedRefreshTokens": strconv.FormatInt(result.RowsAffected, 10),
+	}, tx)
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_AuthorizationCode(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{
+		AuthorizationCodeLifetime: model.AppConfigVariable{Value: "30"},
+	})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		auditLogService:    &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+	}, user.ID)
+	require.NoError(t, err)
+	clientSecret, err := s.CreateClientSecret(t.Context(), client.ID)
+	require.NoError(t, err)
+
+	authorize := func(t *testing.T) string {
+		t.Helper()
+		code, _, err := s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://example.com/callback",
+		}, user.ID, time.Now(), nil, "", "")
+		require.NoError(t, err)
+		return code
+	}
+	exchange := func(t *testing.T, code string) (CreatedTokens, error) {
+		t.Helper()
+		return s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeAuthorizationCode,
+			Code:         code,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+	}
+
+	t.Run("uses the configured lifetime", func(t *testing.T) {
+		code := authorize(t)
+
+		var authorizationCode model.OidcAuthorizationCode
+		require.NoError(t, db.First(&authorizationCode, "code = ?", code).Error)
+		assert.WithinDuration(t, time.Now().Add(30*time.Second), authorizationCode.ExpiresAt.ToTime(), 2*time.Second)
+	})
+
+	t.Run("rejects expired codes", func(t *testing.T) {
+		code := authorize(t)
+		require.NoError(t, db.Model(&model.OidcAuthorizationCode{}).Where("code = ?", code).Update("expires_at", datatype.DateTime(time.Now().Add(-time.Second))).Error)
+
+		_, err := exchange(t, code)
+		require.ErrorIs(t, err, &common.OidcInvalidAuthorizationCodeError{})
+	})
+
+	t.Run("revokes the tokens if a code is reused", func(t *testing.T) {
+		code := authorize(t)
+
+		tokens, err := exchange(t, code)
+		require.NoError(t, err)
+		require.NotEmpty(t, tokens.RefreshToken)
+
+		// The refresh token keeps the session of the code when it's rotated
+		refreshed, err := s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: tokens.RefreshToken,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+		require.NoError(t, err)
+
+		_, err = exchange(t, code)
+		require.ErrorIs(t, err, &common.OidcInvalidAuthorizationCodeError{})
+
+		_, err = s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: refreshed.RefreshToken,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+		require.Error(t, err)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventAuthorizationCodeReused).First(&auditLog).Error)
+		assert.Equal(t, user.ID, auditLog.UserID)
+		assert.Equal(t, client.ID, auditLog.Data["clientId"])
+		assert.Equal(t, "1", auditLog.Data["revokedRefreshTokens"])
+	})
+
+	t.Run("revokes the tokens if an expired code is reused", func(t *testing.T) {
+		code := authorize(t)
+
+		tokens, err := exchange(t, code)
+		require.NoError(t, err)
+		require.NotEmpty(t, tokens.RefreshToken)
+
+		// Replays usually happen after the code expired
+		require.NoError(t, db.Model(&model.OidcAuthorizationCode{}).Where("code = ?", code).Update("expires_at", datatype.DateTime(time.Now().Add(-time.Second))).Error)
+
+		_, err = exchange(t, code)
+		require.ErrorIs(t, err, &common.OidcInvalidAuthorizationCodeError{})
+
+		_, err = s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: tokens.RefreshToken,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+		require.Error(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&model.AuditLog{}).Where("event = ?", model.AuditLogEventAuthorizationCodeReused).Count(&count).Error)
+		assert.EqualValues(t, 2, count)
+	})
+}
+
+func TestValidateAuthorizationCodeLifetime(t *testing.T) {
+	require.NoError(t, validateAuthorizationCodeLifetime(""))
+	require.NoError(t, validateAuthorizationCodeLifetime("60"))
+
+	var validationErr *common.ValidationError
+	require.ErrorAs(t, validateAuthorizationCodeLifetime("5"), &validationErr)
+	require.ErrorAs(t, validateAuthorizationCodeLifetime("3600"), &validationErr)
+}
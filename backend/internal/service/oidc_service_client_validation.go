@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+const (
+	clientValidationSeverityError   = "error"
+	clientValidationSeverityWarning = "warning"
+
+	// Timeout for fetching the JWK set of a federated identity during the validation
+	clientValidationJWKSTimeout = 10 * time.Second
+)
+
+// Scopes that are understood by Pocket ID; other scopes are ignored
+var clientValidationKnownScopes = []string{"openid", "profile", "email", "groups"}
+
+// ValidateClient checks the configuration of a client without saving it.
+// It returns the issues found with the callback URLs, the federated identities, the allowed user groups and the scopes.
+func (s *OidcService) ValidateClient(ctx context.Context, input dto.OidcClientValidateDto) (dto.OidcClientValidationResultDto, error) {
+	result := dto.OidcClientValidationResultDto{
+		Valid:  true,
+		Issues: []dto.OidcClientValidationIssueDto{},
+	}
+	addIssue := func(severity, field, message string) {
+		if severity == clientValidationSeverityError {
+			result.Valid = false
+		}
+		result.Issues = append(result.Issues, dto.OidcClientValidationIssueDto{
+			Severity: severity,
+			Field:    field,
+			Message:  message,
+		})
+	}
+
+	// Callback URLs
+	if len(input.CallbackURLs) == 0 {
+		addIssue(clientValidationSeverityWarning, "callbackURLs", "No callback URL is configured, so the callback URL of the first authorization will be saved")
+	}
+	s.validateClientCallbackURLs(ctx, "callbackURLs", input.CallbackURLs, addIssue)
+	s.validateClientCallbackURLs(ctx, "logoutCallbackURLs", input.LogoutCallbackURLs, addIssue)
+
+	// Federated identities
+	if input.IsPublic && len(input.Credentials.FederatedIdentities) > 0 {
+		addIssue(clientValidationSeverityWarning, "credentials.federatedIdentities", "Public clients don't authenticate, so the federated identities are never used")
+	}
+	// Identities of the same issuer are all tried, but identical ones are redundant
+	identities := make(map[[3]string]struct{}, len(input.Credentials.FederatedIdentities))
+	for i, fi := range input.Credentials.FederatedIdentities {
+		field := fmt.Sprintf("credentials.federatedIdentities[%d]", i)
+		if fi.Issuer == "" {
+			addIssue(clientValidationSeverityError, field+".issuer", "The issuer is required")
+			continue
+		}
+		key := [3]string{fi.Issuer, fi.Subject, fi.Audience}
+		if _, ok := identities[key]; ok {
+			addIssue(clientValidationSeverityWarning, field+".issuer", fmt.Sprintf("The issuer '%s' is configured more than once with the same subject and audience", fi.Issuer))
+		}
+		identities[key] = struct{}{}
+
+		jwksURL := federatedIdentityJWKSURL(model.OidcClientFederatedIdentity{Issuer: fi.Issuer, JWKS: fi.JWKS})
+		err := s.validateFederatedIdentityJWKS(ctx, jwksURL)
+		if err != nil {
+			addIssue(clientValidationSeverityError, field+".jwks", fmt.Sprintf("The JWK set at '%s' can't be used: %v", jwksURL, err))
+		}
+	}
+
+	// Allowed user groups
+	if len(input.AllowedUserGroupIDs) > 0 {
+		var existingIDs []string
+		err := s.db.
+			WithContext(ctx).
+			Model(&model.UserGroup{}).
+			Where("id IN ?", input.AllowedUserGroupIDs).
+			Pluck("id", &existingIDs).
+			Error
+		if err != nil {
+			return dto.OidcClientValidationResultDto{}, err
+		}
+
+		for i, id := range input.AllowedUserGroupIDs {
+			if !slices.Contains(existingIDs, id) {
+				addIssue(clientValidationSeverityError, fmt.Sprintf("allowedUserGroupIds[%d]", i), fmt.Sprintf("The user group '%s' doesn't exist", id))
+			}
+		}
+	}
+
+	// Scopes
+	scopes := strings.Fields(input.Scope)
+	if len(scopes) > 0 && !slices.Contains(scopes, "openid") {
+		addIssue(clientValidationSeverityWarning, "scope", "The scope doesn't include 'openid', so no ID token is issued")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(clientValidationKnownScopes, scope) {
+			addIssue(clientValidationSeverityWarning, "scope", fmt.Sprintf("The scope '%s' isn't supported and will be ignored", scope))
+		}
+	}
+
+	return result, nil
+}
+
+func (s *OidcService) validateClientCallbackURLs(ctx context.Context, field string, callbackURLs []string, addIssue func(severity, field, message string)) {
+	seen := make(map[string]struct{}, len(callbackURLs))
+	for i, callbackURL := range callbackURLs {
+		urlField := fmt.Sprintf("%s[%d]", field, i)
+		if _, ok := seen[callbackURL]; ok {
+			addIssue(clientValidationSeverityWarning, urlField, fmt.Sprintf("The callback URL '%s' is configured more than once", callbackURL))
+		}
+		seen[callbackURL] = struct{}{}
+
+		err := s.validateCallbackURL(ctx, callbackURL)
+		if err != nil {
+			addIssue(clientValidationSeverityError, urlField, fmt.Sprintf("The callback URL '%s' is not allowed: %v", callbackURL, err))
+		}
+	}
+}
+
+// validateFederatedIdentityJWKS fetches the JWK set and checks that it contains at least one key that can verify signatures.
+// The JWK set isn't added to the cache, as the configuration may never be saved.
+func (s *OidcService) validateFederatedIdentityJWKS(ctx context.Context, jwksURL string) error {
+	client := s.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: clientValidationJWKSTimeout}
+	}
+
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, clientValidationJWKSTimeout)
+	defer fetchCancel()
+
+	set, err := jwk.Fetch(fetchCtx, jwksURL, jwk.WithHTTPClient(client))
+	if err != nil {
+		return fmt.Errorf("failed to fetch: %w", err)
+	}
+
+	for i := range set.Len() {
+		key, ok := set.Key(i)
+		if !ok {
+			continue
+		}
+		usage, ok := key.KeyUsage()
+		if !ok || usage == string(jwk.ForSignature) {
+			return nil
+		}
+	}
+
+	return errors.New("it contains no signing keys")
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// CloneClient creates a new client with the configuration of an existing one, e.g. to set up a staging version of an integration.
+// The clone gets a new ID and, unless it's a public client, a new secret that is returned once.
+// The federated identities aren't copied, as they would let the same workloads authenticate as both clients.
+func (s *OidcService) CloneClient(ctx context.Context, clientID, actorUserID, ipAddress, userAgent string) (model.OidcClient, string, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var source model.OidcClient
+	err := tx.
+		WithContext(ctx).
+		Preload("AllowedUserGroups").
+		First(&source, "id = ?", clientID).
+		Error
+	if err != nil {
+		return model.OidcClient{}, "", err
+	}
+
+	// The name of the clone must still fit in the 50 characters allowed for client names
+	name := []rune(source.Name)
+	if len(name) > 43 {
+		name = name[:43]
+	}
+
+	client := model.OidcClient{
+		Name:                       string(name) + " (copy)",
+		CallbackURLs:               slices.Clone(source.CallbackURLs),
+		LogoutCallbackURLs:         slices.Clone(source.LogoutCallbackURLs),
+		IsPublic:                   source.IsPublic,
+		PkceEnabled:                source.PkceEnabled,
+		GrantTypes:                 slices.Clone(source.GrantTypes),
+		ClientCredentialsScopes:    slices.Clone(source.ClientCredentialsScopes),
+		Audiences:                  slices.Clone(source.Audiences),
+		JwksURL:                    source.JwksURL,
+		RequireSignedRequestObject: source.RequireSignedRequestObject,
+		LoginBrandingEnabled:       source.LoginBrandingEnabled,
+		RequireEmail:               source.RequireEmail,
+		SubjectType:                source.SubjectType,
+		PairwiseSectorIdentifier:   source.PairwiseSectorIdentifier,
+		AllowedUserGroups:          source.AllowedUserGroups,
+		ImageType:                  source.ImageType,
+		CreatedByID:                actorUserID,
+	}
+
+	var clientSecret string
+	if !client.IsPublic {
+		clientSecret, err = utils.GenerateRandomAlphanumericString(32)
+		if err != nil {
+			return model.OidcClient{}, "", err
+		}
+
+		hashedSecret, err := bcrypt.GenerateFromPassword([]byte(clientSecret), bcrypt.DefaultCost)
+		if err != nil {
+			return model.OidcClient{}, "", err
+		}
+		client.Secret = string(hashedSecret)
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Create(&client).
+		Error
+	if err != nil {
+		return model.OidcClient{}, "", err
+	}
+
+	if source.ImageType != nil {
+		err = s.copyClientLogo(ctx, source.ID, client.ID, *source.ImageType)
+		if err != nil {
+			return model.OidcClient{}, "", err
+		}
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventOidcClientCloned, ipAddress, userAgent, actorUserID, model.AuditLogData{
+		"clientName":       client.Name,
+		"clientId":         client.ID,
+		"sourceClientName": source.Name,
+		"sourceClientId":   source.ID,
+	}, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return model.OidcClient{}, "", err
+	}
+
+	return client, clientSecret, nil
+}
+
+// copyClientLogo copies the logo of the source client to the cloned client
+func (s *OidcService) copyClientLogo(ctx context.Context, sourceClientID, clientID, imageType string) error {
+	data, err := s.uploadStorage.Get(ctx, clientLogoKey(sourceClientID, imageType))
+	if err != nil {
+		return fmt.Errorf("failed to read client logo: %w", err)
+	}
+
+	return s.uploadStorage.Put(ctx, clientLogoKey(clientID, imageType), data, utils.GetImageMimeType(imageType))
+}
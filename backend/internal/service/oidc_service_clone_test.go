@@ -0,0 +1,85 @@
+package service
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_CloneClient(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	admin := model.User{Username: "admin", Email: "admin@example.com", FirstName: "Admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+	group := model.UserGroup{Name: "developers", FriendlyName: "Developers"}
+	require.NoError(t, db.Create(&group).Error)
+
+	source := model.OidcClient{
+		Name:               "Grafana",
+		Secret:             "old-secret-hash",
+		CallbackURLs:       model.UrlList{"https://grafana.example.com/callback"},
+		LogoutCallbackURLs: model.UrlList{"https://grafana.example.com/logout"},
+		PkceEnabled:        true,
+		GrantTypes:         model.StringList{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Audiences:          model.StringList{"https://api.example.com"},
+		SubjectType:        ClientSubjectTypePublic,
+		Credentials: model.OidcClientCredentials{
+			FederatedIdentities: []model.OidcClientFederatedIdentity{{Issuer: "https://issuer.example.com", Subject: "workload"}},
+		},
+		AllowedUserGroups: []model.UserGroup{group},
+		CreatedByID:       admin.ID,
+	}
+	require.NoError(t, db.Create(&source).Error)
+
+	t.Run("clones the configuration with a new secret", func(t *testing.T) {
+		clone, secret, err := s.CloneClient(t.Context(), source.ID, admin.ID, "", "")
+		require.NoError(t, err)
+
+		assert.NotEqual(t, source.ID, clone.ID)
+		assert.Equal(t, "Grafana (copy)", clone.Name)
+		assert.Equal(t, source.CallbackURLs, clone.CallbackURLs)
+		assert.Equal(t, source.LogoutCallbackURLs, clone.LogoutCallbackURLs)
+		assert.Equal(t, source.GrantTypes, clone.GrantTypes)
+		assert.Equal(t, source.Audiences, clone.Audiences)
+		assert.True(t, clone.PkceEnabled)
+		assert.Empty(t, clone.Credentials.FederatedIdentities)
+
+		require.NotEmpty(t, secret)
+		assert.NotEqual(t, source.Secret, clone.Secret)
+		require.NoError(t, bcrypt.CompareHashAndPassword([]byte(clone.Secret), []byte(secret)))
+
+		var stored model.OidcClient
+		require.NoError(t, db.Preload("AllowedUserGroups").First(&stored, "id = ?", clone.ID).Error)
+		require.Len(t, stored.AllowedUserGroups, 1)
+		assert.Equal(t, group.ID, stored.AllowedUserGroups[0].ID)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventOidcClientCloned).First(&auditLog).Error)
+		assert.Equal(t, admin.ID, auditLog.UserID)
+		assert.Equal(t, source.ID, auditLog.Data["sourceClientId"])
+		assert.Equal(t, clone.ID, auditLog.Data["clientId"])
+	})
+
+	t.Run("doesn't create a secret for public clients", func(t *testing.T) {
+		public := model.OidcClient{Name: strings.Repeat("a", 50), IsPublic: true, PkceEnabled: true, CreatedByID: admin.ID}
+		require.NoError(t, db.Create(&public).Error)
+
+		clone, secret, err := s.CloneClient(t.Context(), public.ID, admin.ID, "", "")
+		require.NoError(t, err)
+		assert.Empty(t, secret)
+		assert.Empty(t, clone.Secret)
+		assert.Equal(t, strings.Repeat("a", 43)+" (copy)", clone.Name)
+	})
+}
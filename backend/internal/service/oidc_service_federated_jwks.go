@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+const (
+	// Age after which a cached JWK set of a federated identity is refreshed
+	federatedJwksRefreshInterval = 15 * time.Minute
+	// Age after which a cached JWK set is no longer used, if it couldn't be refreshed
+	federatedJwksMaxAge = 24 * time.Hour
+	// Delay before retrying to fetch a JWK set, multiplied by the number of the attempt
+	federatedJwksRetryDelay = 500 * time.Millisecond
+	// Maximum size of a JWK set
+	maxJwksSize = 1 << 20 // 1 MB
+)
+
+// federatedJwksCache contains the JWK sets of federated identities, by URL
+type federatedJwksCache struct {
+	mu      sync.Mutex
+	entries map[string]*federatedJwksCacheEntry
+}
+
+type federatedJwksCacheEntry struct {
+	set        jwk.Set
+	fetchedAt  time.Time
+	refreshing bool
+}
+
+// federatedJwkSet returns the JWK set of a federated identity.
+// A cached JWK set that is due for a refresh is still returned while it's refreshed in the background, so a slow identity provider doesn't delay the logins.
+// Otherwise, each attempt to fetch it is limited by FEDERATED_JWKS_FETCH_TIMEOUT, and failed attempts are retried FEDERATED_JWKS_FETCH_RETRIES times.
+func (s *OidcService) federatedJwkSet(ctx context.Context, issuer, jwksURL string) (jwk.Set, error) {
+	set, refresh := s.federatedJwks.get(jwksURL, time.Now())
+	if set != nil {
+		if refresh {
+			go s.refreshFederatedJwkSet(context.WithoutCancel(ctx), jwksURL)
+		}
+		return set, nil
+	}
+
+	set, err := s.fetchJwkSetWithRetries(ctx, jwksURL)
+	if err != nil {
+		return nil, &common.OidcFederatedJwksUnavailableError{Issuer: issuer, URL: jwksURL, Err: err}
+	}
+
+	s.federatedJwks.store(jwksURL, set, time.Now())
+	return set, nil
+}
+
+func (s *OidcService) refreshFederatedJwkSet(ctx context.Context, jwksURL string) {
+	set, err := s.fetchJwkSetWithRetries(ctx, jwksURL)
+	if err != nil {
+		// The cached JWK set is used until it expires
+		slog.WarnContext(ctx, "Failed to refresh the JWK set of a federated identity", slog.String("url", jwksURL), slog.Any("error", err))
+		s.federatedJwks.store(jwksURL, nil, time.Time{})
+		return
+	}
+
+	s.federatedJwks.store(jwksURL, set, time.Now())
+}
+
+// fetchJwkSetWithRetries fetches a JWK set, retrying failed attempts unless the JWK set is malformed
+func (s *OidcService) fetchJwkSetWithRetries(ctx context.Context, jwksURL string) (jwk.Set, error) {
+	var err error
+	for attempt := 0; attempt <= common.EnvConfig.FederatedJwksFetchRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(time.Duration(attempt) * federatedJwksRetryDelay):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+
+		var (
+			set       jwk.Set
+			retryable bool
+		)
+		set, retryable, err = s.fetchJwkSet(ctx, jwksURL)
+		if err == nil {
+			return set, nil
+		}
+		if !retryable {
+			return nil, err
+		}
+		slog.WarnContext(ctx, "Failed to fetch the JWK set of a federated identity", slog.String("url", jwksURL), slog.Int("attempt", attempt+1), slog.Any("error", err))
+	}
+
+	return nil, err
+}
+
+// fetchJwkSet fetches a JWK set within FEDERATED_JWKS_FETCH_TIMEOUT.
+// It returns whether the error is worth retrying, which isn't the case for malformed JWK sets.
+func (s *OidcService) fetchJwkSet(parentCtx context.Context, jwksURL string) (set jwk.Set, retryable bool, err error) {
+	client := s.httpClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithTimeout(parentCtx, common.EnvConfig.FederatedJwksFetchTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, jwksURL, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid JWKS URL: %w", err)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to fetch JWK set: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		// Client errors, like a wrong URL, won't be fixed by retrying
+		retryable = res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests
+		return nil, retryable, fmt.Errorf("failed to fetch JWK set, received HTTP %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxJwksSize))
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to read JWK set: %w", err)
+	}
+	set, err = jwk.Parse(body)
+	if err != nil {
+		return nil, false, fmt.Errorf("malformed JWK set: %w", err)
+	}
+	if set.Len() == 0 {
+		return nil, false, errors.New("the JWK set contains no keys")
+	}
+
+	return set, false, nil
+}
+
+// get returns the cached JWK set for the URL, or nil if there is none or it expired.
+// It also returns whether the caller must refresh the JWK set, which is only the case for one caller at a time.
+func (c *federatedJwksCache) get(jwksURL string, now time.Time) (jwk.Set, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[jwksURL]
+	if !ok || now.Sub(entry.fetchedAt) >= federatedJwksMaxAge {
+		return nil, false
+	}
+
+	refresh := !entry.refreshing && now.Sub(entry.fetchedAt) >= federatedJwksRefreshInterval
+	if refresh {
+		entry.refreshing = true
+	}
+	return entry.set, refresh
+}
+
+// store caches the JWK set for the URL; if set is nil, only the refresh is marked as finished
+func (c *federatedJwksCache) store(jwksURL string, set jwk.Set, fetchedAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if set == nil {
+		if entry, ok := c.entries[jwksURL]; ok {
+			entry.refreshing = false
+		}
+		return
+	}
+
+	if c.entries == nil {
+		c.entries = make(map[string]*federatedJwksCacheEntry)
+	}
+	c.entries[jwksURL] = &federatedJwksCacheEntry{set: set, fetchedAt: fetchedAt}
+}
@@ -0,0 +1,145 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+func TestOidcService_federatedJwkSet(t *testing.T) {
+	originalEnvConfig := common.EnvConfig
+	t.Cleanup(func() {
+		common.EnvConfig = originalEnvConfig
+	})
+	common.EnvConfig.FederatedJwksFetchTimeout = 100 * time.Millisecond
+	common.EnvConfig.FederatedJwksFetchRetries = 1
+
+	_, jwkSetJSON := generateTestECDSAKey(t)
+
+	// newServer starts a JWKS endpoint that counts the requests it receives
+	newServer := func(t *testing.T, handler func(attempt int32, w http.ResponseWriter, r *http.Request)) (*httptest.Server, *atomic.Int32) {
+		var attempts atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler(attempts.Add(1), w, r)
+		}))
+		t.Cleanup(server.Close)
+		return server, &attempts
+	}
+
+	t.Run("fetches and caches the JWK set", func(t *testing.T) {
+		s := &OidcService{}
+		server, attempts := newServer(t, func(_ int32, w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(jwkSetJSON)
+		})
+
+		set, err := s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, 1, set.Len())
+
+		_, err = s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, attempts.Load())
+	})
+
+	t.Run("retries failed attempts", func(t *testing.T) {
+		s := &OidcService{}
+		server, attempts := newServer(t, func(attempt int32, w http.ResponseWriter, _ *http.Request) {
+			if attempt == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write(jwkSetJSON)
+		})
+
+		_, err := s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, attempts.Load())
+	})
+
+	t.Run("fails if the endpoint times out", func(t *testing.T) {
+		s := &OidcService{}
+		server, attempts := newServer(t, func(_ int32, _ http.ResponseWriter, r *http.Request) {
+			<-r.Context().Done()
+		})
+
+		_, err := s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		var unavailableErr *common.OidcFederatedJwksUnavailableError
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.Equal(t, server.URL, unavailableErr.URL)
+		assert.EqualValues(t, 2, attempts.Load())
+
+		// Failures aren't cached
+		_, err = s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.EqualValues(t, 4, attempts.Load())
+	})
+
+	t.Run("doesn't retry malformed JWK sets", func(t *testing.T) {
+		s := &OidcService{}
+		server, attempts := newServer(t, func(_ int32, w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(`{"keys": [{"kty": "unknown"`))
+		})
+
+		_, err := s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		var unavailableErr *common.OidcFederatedJwksUnavailableError
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.ErrorContains(t, unavailableErr.Err, "malformed JWK set")
+		assert.EqualValues(t, 1, attempts.Load())
+	})
+
+	t.Run("doesn't retry client errors", func(t *testing.T) {
+		s := &OidcService{}
+		server, attempts := newServer(t, func(_ int32, w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		})
+
+		_, err := s.federatedJwkSet(t.Context(), "https://idp.example.com", server.URL)
+		var unavailableErr *common.OidcFederatedJwksUnavailableError
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.EqualValues(t, 1, attempts.Load())
+	})
+}
+
+func TestFederatedJwksCache(t *testing.T) {
+	_, jwkSetJSON := generateTestECDSAKey(t)
+	set, err := jwk.Parse(jwkSetJSON)
+	require.NoError(t, err)
+
+	var c federatedJwksCache
+	now := time.Now()
+
+	cached, refresh := c.get("https://idp.example.com/jwks", now)
+	assert.Nil(t, cached)
+	assert.False(t, refresh)
+
+	c.store("https://idp.example.com/jwks", set, now)
+
+	cached, refresh = c.get("https://idp.example.com/jwks", now.Add(time.Minute))
+	assert.NotNil(t, cached)
+	assert.False(t, refresh)
+
+	// A stale JWK set is still returned, and only the first caller refreshes it
+	cached, refresh = c.get("https://idp.example.com/jwks", now.Add(federatedJwksRefreshInterval))
+	assert.NotNil(t, cached)
+	assert.True(t, refresh)
+	cached, refresh = c.get("https://idp.example.com/jwks", now.Add(federatedJwksRefreshInterval))
+	assert.NotNil(t, cached)
+	assert.False(t, refresh)
+
+	// A failed refresh allows the next caller to try again
+	c.store("https://idp.example.com/jwks", nil, time.Time{})
+	_, refresh = c.get("https://idp.example.com/jwks", now.Add(federatedJwksRefreshInterval))
+	assert.True(t, refresh)
+
+	// Expired JWK sets aren't used anymore
+	cached, _ = c.get("https://idp.example.com/jwks", now.Add(federatedJwksMaxAge))
+	assert.Nil(t, cached)
+}
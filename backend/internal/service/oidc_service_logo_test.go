@@ -0,0 +1,101 @@
+package service
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/storage"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+// newTestFileHeader returns an uploaded file like the ones of a multipart form
+func newTestFileHeader(t *testing.T, filename string, data []byte) *multipart.FileHeader {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", filename)
+	require.NoError(t, err)
+	_, err = part.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+
+	form, err := multipart.NewReader(&body, writer.Boundary()).ReadForm(1 << 20)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = form.RemoveAll() })
+	return form.File["file"][0]
+}
+
+func TestOidcService_ClientLogo(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	dir := t.TempDir()
+	s := &OidcService{db: db, uploadStorage: &storage.FileStorage{Dir: dir}}
+
+	admin := model.User{Username: "admin", Email: "admin@example.com", FirstName: "Admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+	client := model.OidcClient{Name: "Grafana", CreatedByID: admin.ID}
+	require.NoError(t, db.Create(&client).Error)
+
+	img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	var pngData, jpegData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, img))
+	require.NoError(t, jpeg.Encode(&jpegData, img, nil))
+
+	t.Run("fails if the client has no logo", func(t *testing.T) {
+		_, _, err := s.GetClientLogo(t.Context(), client.ID)
+		var notFoundErr *common.ImageNotFoundError
+		require.ErrorAs(t, err, &notFoundErr)
+	})
+
+	t.Run("stores the logo in the upload storage", func(t *testing.T) {
+		err := s.UpdateClientLogo(t.Context(), client.ID, newTestFileHeader(t, "logo.png", pngData.Bytes()))
+		require.NoError(t, err)
+
+		data, mimeType, err := s.GetClientLogo(t.Context(), client.ID)
+		require.NoError(t, err)
+		assert.Equal(t, pngData.Bytes(), data)
+		assert.Equal(t, "image/png", mimeType)
+		assert.FileExists(t, filepath.Join(dir, "oidc-client-images", client.ID+".png"))
+	})
+
+	t.Run("replaces the logo of another type", func(t *testing.T) {
+		err := s.UpdateClientLogo(t.Context(), client.ID, newTestFileHeader(t, "logo.jpg", jpegData.Bytes()))
+		require.NoError(t, err)
+
+		_, mimeType, err := s.GetClientLogo(t.Context(), client.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "image/jpeg", mimeType)
+		assert.NoFileExists(t, filepath.Join(dir, "oidc-client-images", client.ID+".png"))
+	})
+
+	t.Run("rejects invalid and large files", func(t *testing.T) {
+		err := s.UpdateClientLogo(t.Context(), client.ID, newTestFileHeader(t, "logo.png", []byte("not an image")))
+		require.Error(t, err)
+
+		err = s.UpdateClientLogo(t.Context(), client.ID, newTestFileHeader(t, "logo.png", make([]byte, clientLogoOptions.MaxInputSize+1)))
+		var tooLargeErr *common.FileTooLargeError
+		require.ErrorAs(t, err, &tooLargeErr)
+	})
+
+	t.Run("deletes the logo", func(t *testing.T) {
+		require.NoError(t, s.DeleteClientLogo(t.Context(), client.ID))
+
+		_, _, err := s.GetClientLogo(t.Context(), client.ID)
+		var notFoundErr *common.ImageNotFoundError
+		require.ErrorAs(t, err, &notFoundErr)
+
+		_, err = os.Stat(filepath.Join(dir, "oidc-client-images", client.ID+".jpg"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
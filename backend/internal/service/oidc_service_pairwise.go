@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// Subject types of clients (OpenID Connect Core, section 8)
+const (
+	ClientSubjectTypePublic   = "public"
+	ClientSubjectTypePairwise = "pairwise"
+)
+
+// clientSubject returns the subject of the user in the tokens issued to the client.
+// Public clients get the user ID. Pairwise clients get a subject derived from the user ID, the sector identifier of the client and a server salt,
+// so the same user has a different subject for every sector, and the subjects can't be correlated across sectors.
+func (s *OidcService) clientSubject(client *model.OidcClient, userID string) string {
+	if client.SubjectType != ClientSubjectTypePairwise {
+		return userID
+	}
+
+	sector := client.PairwiseSectorIdentifier
+	if sector == "" {
+		sector = client.ID
+	}
+
+	mac := hmac.New(sha256.New, []byte(s.appConfigService.GetDbConfig().PairwiseSubjectSalt.Value))
+	mac.Write([]byte(sector))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// storedClientSubject returns the subject to store with the authorization of the user, which is only set for pairwise clients
+func (s *OidcService) storedClientSubject(client *model.OidcClient, userID string) *string {
+	if client.SubjectType != ClientSubjectTypePairwise {
+		return nil
+	}
+	subject := s.clientSubject(client, userID)
+	return &subject
+}
+
+// resolveSubjectUserID returns the ID of the user of a subject in a token issued to the client.
+// Pairwise subjects are looked up in the authorizations of the client; other subjects are the user ID.
+func (s *OidcService) resolveSubjectUserID(ctx context.Context, clientID string, subject string, tx *gorm.DB) (string, error) {
+	var userIDs []string
+	err := tx.
+		WithContext(ctx).
+		Model(&model.UserAuthorizedOidcClient{}).
+		Where("client_id = ? AND subject = ?", clientID, subject).
+		Limit(1).
+		Pluck("user_id", &userIDs).
+		Error
+	if err != nil {
+		return "", err
+	}
+	if len(userIDs) > 0 {
+		return userIDs[0], nil
+	}
+	return subject, nil
+}
+
+// updateClientSubjectsInternal recomputes the subjects stored with the authorizations of the client, after its subject type or sector identifier changed
+func (s *OidcService) updateClientSubjectsInternal(ctx context.Context, client *model.OidcClient, tx *gorm.DB) error {
+	if client.SubjectType != ClientSubjectTypePairwise {
+		return tx.
+			WithContext(ctx).
+			Model(&model.UserAuthorizedOidcClient{}).
+			Where("client_id = ?", client.ID).
+			Update("subject", nil).
+			Error
+	}
+
+	var userIDs []string
+	err := tx.
+		WithContext(ctx).
+		Model(&model.UserAuthorizedOidcClient{}).
+		Where("client_id = ?", client.ID).
+		Pluck("user_id", &userIDs).
+		Error
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		err = tx.
+			WithContext(ctx).
+			Model(&model.UserAuthorizedOidcClient{}).
+			Where("client_id = ? AND user_id = ?", client.ID, userID).
+			Update("subject", s.clientSubject(client, userID)).
+			Error
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
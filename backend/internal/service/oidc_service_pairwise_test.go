@@ -0,0 +1,111 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_PairwiseSubjects(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{
+		PairwiseSubjectSalt: model.AppConfigVariable{Value: "test-salt"},
+	})
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	bob := model.User{Username: "bob", Email: "bob@example.com", FirstName: "Bob"}
+	require.NoError(t, db.Create(&[]*model.User{&alice, &bob}).Error)
+
+	createClient := func(t *testing.T, subjectType, sector string) model.OidcClient {
+		t.Helper()
+		client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:                     "Client",
+			SubjectType:              &subjectType,
+			PairwiseSectorIdentifier: &sector,
+		}, alice.ID)
+		require.NoError(t, err)
+		return client
+	}
+	authorize := func(t *testing.T, client model.OidcClient, user model.User) {
+		t.Helper()
+		require.NoError(t, s.createAuthorizedClientInternal(t.Context(), user.ID, &client, "openid email", db))
+	}
+
+	public := createClient(t, ClientSubjectTypePublic, "")
+	pairwise := createClient(t, ClientSubjectTypePairwise, "")
+	sameSector := createClient(t, ClientSubjectTypePairwise, "example.com")
+	sameSector2 := createClient(t, ClientSubjectTypePairwise, "example.com")
+
+	t.Run("public clients get the user ID", func(t *testing.T) {
+		assert.Equal(t, alice.ID, s.clientSubject(&public, alice.ID))
+	})
+
+	t.Run("pairwise subjects are stable and differ by sector and user", func(t *testing.T) {
+		subject := s.clientSubject(&pairwise, alice.ID)
+		assert.NotEqual(t, alice.ID, subject)
+		assert.Equal(t, subject, s.clientSubject(&pairwise, alice.ID))
+		assert.NotEqual(t, subject, s.clientSubject(&pairwise, bob.ID))
+		assert.NotEqual(t, subject, s.clientSubject(&sameSector, alice.ID))
+		assert.Equal(t, s.clientSubject(&sameSector, alice.ID), s.clientSubject(&sameSector2, alice.ID))
+	})
+
+	t.Run("the claims and userinfo use the pairwise subject", func(t *testing.T) {
+		authorize(t, pairwise, alice)
+		subject := s.clientSubject(&pairwise, alice.ID)
+
+		claims, err := s.getUserClaimsForClientInternal(t.Context(), alice.ID, pairwise.ID, db)
+		require.NoError(t, err)
+		assert.Equal(t, subject, claims["sub"])
+
+		claims, err = s.GetUserClaimsForClient(t.Context(), subject, pairwise.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "alice@example.com", claims["email"])
+
+		// The user ID can't be used as subject for pairwise clients
+		_, err = s.GetUserClaimsForClient(t.Context(), alice.ID, pairwise.ID)
+		require.Error(t, err)
+	})
+
+	t.Run("userinfo of public clients uses the user ID", func(t *testing.T) {
+		authorize(t, public, alice)
+
+		claims, err := s.GetUserClaimsForClient(t.Context(), alice.ID, public.ID)
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, claims["sub"])
+	})
+
+	t.Run("changing the subject type recomputes the stored subjects", func(t *testing.T) {
+		pairwiseType := ClientSubjectTypePairwise
+		updated, err := s.UpdateClient(t.Context(), public.ID, dto.OidcClientCreateDto{
+			Name:        public.Name,
+			SubjectType: &pairwiseType,
+		})
+		require.NoError(t, err)
+
+		subject := s.clientSubject(&updated, alice.ID)
+		claims, err := s.GetUserClaimsForClient(t.Context(), subject, public.ID)
+		require.NoError(t, err)
+		assert.Equal(t, subject, claims["sub"])
+
+		publicType := ClientSubjectTypePublic
+		_, err = s.UpdateClient(t.Context(), public.ID, dto.OidcClientCreateDto{
+			Name:        public.Name,
+			SubjectType: &publicType,
+		})
+		require.NoError(t, err)
+
+		claims, err = s.GetUserClaimsForClient(t.Context(), alice.ID, public.ID)
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, claims["sub"])
+	})
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+const (
+	// Maximum size of a request object fetched from a request_uri
+	requestObjectMaxSize = 64 << 10
+
+	// Timeout for fetching a request object from a request_uri
+	requestObjectFetchTimeout = 10 * time.Second
+)
+
+// resolveRequestObject returns the authorization parameters of the signed request object (RFC 9101) sent with the request or request_uri parameter.
+// The request object must be signed with a key of the JWK set of the client, issued by the client for Pocket ID, and not expired.
+// Its parameters replace the ones of the request; the scope is only kept if the request object doesn't contain one, as OpenID Connect requires it outside of the request object too.
+// The state isn't part of the response of the authorization, so clients must also send it as a query parameter.
+func (s *OidcService) resolveRequestObject(ctx context.Context, client *model.OidcClient, input dto.AuthorizeOidcClientRequestDto) (dto.AuthorizeOidcClientRequestDto, error) {
+	if input.Request == "" && input.RequestURI == "" {
+		if client.RequireSignedRequestObject {
+			return dto.AuthorizeOidcClientRequestDto{}, &common.OidcRequestObjectRequiredError{}
+		}
+		return input, nil
+	}
+	if input.Request != "" && input.RequestURI != "" {
+		return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: "request and request_uri can't be used together"}
+	}
+	if client.JwksURL == "" {
+		return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: "the client has no JWK set to verify it"}
+	}
+
+	requestObject := input.Request
+	if input.RequestURI != "" {
+		var err error
+		requestObject, err = s.fetchRequestObject(ctx, client, input.RequestURI)
+		if err != nil {
+			return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: err.Error()}
+		}
+	}
+
+	jwks, err := s.jwkSetForURL(ctx, client.JwksURL)
+	if err != nil {
+		return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: "failed to get the JWK set of the client"}
+	}
+
+	// Unsigned request objects are rejected, as they can't be verified with the key set
+	token, err := jwt.Parse([]byte(requestObject),
+		jwt.WithValidate(true),
+		jwt.WithAcceptableSkew(clockSkew),
+		jwt.WithKeySet(jwks, jws.WithInferAlgorithmFromKey(true), jws.WithUseDefault(true)),
+		jwt.WithIssuer(client.ID),
+		jwt.WithAudience(common.EnvConfig.AppURL),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+	)
+	if err != nil {
+		return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: err.Error()}
+	}
+
+	resolved := dto.AuthorizeOidcClientRequestDto{
+		ClientID: input.ClientID,
+		Scope:    input.Scope,
+	}
+
+	var clientID string
+	stringClaims := map[string]*string{
+		"client_id":             &clientID,
+		"scope":                 &resolved.Scope,
+		"redirect_uri":          &resolved.CallbackURL,
+		"nonce":                 &resolved.Nonce,
+		"code_challenge":        &resolved.CodeChallenge,
+		"code_challenge_method": &resolved.CodeChallengeMethod,
+		"prompt":                &resolved.Prompt,
+		"acr_values":            &resolved.AcrValues,
+	}
+	for name, dst := range stringClaims {
+		if !token.Has(name) {
+			continue
+		}
+		err = token.Get(name, dst)
+		if err != nil {
+			return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: fmt.Sprintf("the claim '%s' must be a string", name)}
+		}
+	}
+
+	// The client ID of the request object must match the one of the request
+	if clientID != "" && clientID != client.ID {
+		return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: "the client_id doesn't match the one of the request"}
+	}
+
+	if token.Has("max_age") {
+		var maxAge float64
+		err = token.Get("max_age", &maxAge)
+		if err != nil || maxAge < 0 {
+			return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: "the claim 'max_age' must be a positive number"}
+		}
+		maxAgeSeconds := int(maxAge)
+		resolved.MaxAge = &maxAgeSeconds
+	}
+
+	// The resource claim can contain a single resource or a list of them
+	if token.Has("resource") {
+		resolved.Resource, err = requestObjectResources(token)
+		if err != nil {
+			return dto.AuthorizeOidcClientRequestDto{}, &common.OidcInvalidRequestObjectError{Reason: err.Error()}
+		}
+	}
+
+	return resolved, nil
+}
+
+func requestObjectResources(token jwt.Token) ([]string, error) {
+	errInvalid := errors.New("the claim 'resource' must be a string or a list of strings")
+
+	var value any
+	err := token.Get("resource", &value)
+	if err != nil {
+		return nil, errInvalid
+	}
+
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case []any:
+		resources := make([]string, len(v))
+		for i, item := range v {
+			resource, ok := item.(string)
+			if !ok {
+				return nil, errInvalid
+			}
+			resources[i] = resource
+		}
+		return resources, nil
+	default:
+		return nil, errInvalid
+	}
+}
+
+// fetchRequestObject downloads the request object from the request_uri.
+// To avoid sending requests to arbitrary hosts, the request_uri must be an HTTPS URL on the same host as the JWK set of the client.
+func (s *OidcService) fetchRequestObject(ctx context.Context, client *model.OidcClient, requestURI string) (string, error) {
+	parsedURI, err := url.Parse(requestURI)
+	if err != nil || parsedURI.Scheme != "https" {
+		return "", errors.New("the request_uri must be an HTTPS URL")
+	}
+	jwksURL, err := url.Parse(client.JwksURL)
+	if err != nil || !strings.EqualFold(parsedURI.Host, jwksURL.Host) {
+		return "", errors.New("the request_uri must be on the same host as the JWK set of the client")
+	}
+
+	httpClient := s.httpClient
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: requestObjectFetchTimeout}
+	}
+
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, requestObjectFetchTimeout)
+	defer fetchCancel()
+
+	req, err := http.NewRequestWithContext(fetchCtx, http.MethodGet, requestURI, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create the request: %w", err)
+	}
+	req.Header.Set("Accept", "application/oauth-authz-req+jwt")
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch the request_uri: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("the request_uri returned the status code %d", res.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, requestObjectMaxSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the request_uri: %w", err)
+	}
+	if len(body) > requestObjectMaxSize {
+		return "", errors.New("the request object is too large")
+	}
+
+	return strings.TrimSpace(string(body)), nil
+}
@@ -0,0 +1,178 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_Authorize_RequestObject(t *testing.T) {
+	const (
+		jwksURL    = "https://client.example.com/jwks.json"
+		requestURI = "https://client.example.com/requests/1"
+	)
+
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+
+	privateJWK, jwkSetJSON := generateTestECDSAKey(t)
+	otherPrivateJWK, _ := generateTestECDSAKey(t)
+
+	responses := map[string]*http.Response{
+		//nolint:bodyclose
+		jwksURL: testutils.NewMockResponse(http.StatusOK, string(jwkSetJSON)),
+	}
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		httpClient:       &http.Client{Transport: &testutils.MockRoundTripper{Responses: responses}},
+	}
+	var err error
+	s.jwkCache, err = s.getJWKCache(t.Context())
+	require.NoError(t, err)
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	requireSigned := true
+	jwksURLInput := jwksURL
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:                       "Client",
+		CallbackURLs:               []string{"https://example.com/callback", "https://example.com/other-callback"},
+		JwksURL:                    &jwksURLInput,
+		RequireSignedRequestObject: &requireSigned,
+	}, user.ID)
+	require.NoError(t, err)
+
+	signRequestObject := func(t *testing.T, key jwk.Key, expiration time.Time, claims map[string]any) string {
+		t.Helper()
+		builder := jwt.NewBuilder().
+			Issuer(client.ID).
+			Audience([]string{common.EnvConfig.AppURL}).
+			IssuedAt(time.Now()).
+			Expiration(expiration)
+		for name, value := range claims {
+			builder = builder.Claim(name, value)
+		}
+		token, err := builder.Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key))
+		require.NoError(t, err)
+		return string(signed)
+	}
+
+	authorize := func(request, requestURI string) (string, string, error) {
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid",
+			CallbackURL: "https://example.com/callback",
+			Nonce:       "query-nonce",
+			Request:     request,
+			RequestURI:  requestURI,
+		}, user.ID, time.Now(), nil, "", "")
+	}
+
+	t.Run("uses the parameters of the request object", func(t *testing.T) {
+		request := signRequestObject(t, privateJWK, time.Now().Add(time.Minute), map[string]any{
+			"client_id":    client.ID,
+			"scope":        "openid email",
+			"redirect_uri": "https://example.com/other-callback",
+			"nonce":        "signed-nonce",
+			"resource":     []string{client.ID},
+		})
+
+		code, callbackURL, err := authorize(request, "")
+		require.NoError(t, err)
+		assert.Equal(t, "https://example.com/other-callback", callbackURL)
+
+		var authorizationCode model.OidcAuthorizationCode
+		require.NoError(t, db.First(&authorizationCode, "code = ?", code).Error)
+		assert.Equal(t, "openid email", authorizationCode.Scope)
+		assert.Equal(t, "signed-nonce", authorizationCode.Nonce)
+		assert.Equal(t, model.StringList{client.ID}, authorizationCode.Resources)
+	})
+
+	t.Run("fetches the request object from the request_uri", func(t *testing.T) {
+		request := signRequestObject(t, privateJWK, time.Now().Add(time.Minute), map[string]any{"nonce": "fetched-nonce"})
+		//nolint:bodyclose
+		responses[requestURI] = testutils.NewMockResponse(http.StatusOK, request)
+
+		code, _, err := authorize("", requestURI)
+		require.NoError(t, err)
+
+		var authorizationCode model.OidcAuthorizationCode
+		require.NoError(t, db.First(&authorizationCode, "code = ?", code).Error)
+		assert.Equal(t, "fetched-nonce", authorizationCode.Nonce)
+	})
+
+	t.Run("rejects a request_uri on another host", func(t *testing.T) {
+		_, _, err := authorize("", "https://attacker.example.com/request")
+		var requestObjectErr *common.OidcInvalidRequestObjectError
+		require.ErrorAs(t, err, &requestObjectErr)
+	})
+
+	t.Run("rejects a tampered request object", func(t *testing.T) {
+		request := signRequestObject(t, privateJWK, time.Now().Add(time.Minute), map[string]any{"scope": "openid"})
+		tampered := signRequestObject(t, otherPrivateJWK, time.Now().Add(time.Minute), map[string]any{"scope": "openid email groups"})
+
+		// Combine the payload of the second request object with the signature of the first one
+		requestParts := splitCompactJWT(t, request)
+		tamperedParts := splitCompactJWT(t, tampered)
+		_, _, err := authorize(requestParts[0]+"."+tamperedParts[1]+"."+requestParts[2], "")
+		var requestObjectErr *common.OidcInvalidRequestObjectError
+		require.ErrorAs(t, err, &requestObjectErr)
+
+		// A request object signed with a key that isn't in the JWK set of the client is rejected too
+		_, _, err = authorize(tampered, "")
+		require.ErrorAs(t, err, &requestObjectErr)
+	})
+
+	t.Run("rejects an expired request object", func(t *testing.T) {
+		request := signRequestObject(t, privateJWK, time.Now().Add(-time.Hour), map[string]any{"scope": "openid"})
+		_, _, err := authorize(request, "")
+		var requestObjectErr *common.OidcInvalidRequestObjectError
+		require.ErrorAs(t, err, &requestObjectErr)
+	})
+
+	t.Run("rejects a request object for another client", func(t *testing.T) {
+		request := signRequestObject(t, privateJWK, time.Now().Add(time.Minute), map[string]any{"client_id": "other-client"})
+		_, _, err := authorize(request, "")
+		var requestObjectErr *common.OidcInvalidRequestObjectError
+		require.ErrorAs(t, err, &requestObjectErr)
+	})
+
+	t.Run("rejects unsigned requests if the client requires signed request objects", func(t *testing.T) {
+		_, _, err := authorize("", "")
+		require.ErrorIs(t, err, &common.OidcRequestObjectRequiredError{})
+	})
+
+	t.Run("requires a JWK set to require signed request objects", func(t *testing.T) {
+		_, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:                       "Client without JWK set",
+			CallbackURLs:               []string{"https://example.com/callback"},
+			RequireSignedRequestObject: &requireSigned,
+		}, user.ID)
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func splitCompactJWT(t *testing.T, token string) []string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	require.Len(t, parts, 3)
+	return parts
+}
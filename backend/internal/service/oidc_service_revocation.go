@@ -0,0 +1,136 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// RevokedClientTokens contains the number of tokens deleted by RevokeAllClientTokens
+type RevokedClientTokens struct {
+	RefreshTokens      int64
+	AuthorizationCodes int64
+	DeviceCodes        int64
+	Authorizations     int64
+}
+
+// RevokeAllClientTokens revokes all tokens of a client at once, e.g. when it has been compromised.
+// The refresh tokens, authorization codes and device codes of the client are always deleted.
+// Access tokens can't be deleted as they aren't stored, so if requested, the access tokens issued until now are rejected instead.
+// If requested, the authorizations of the users are deleted too, so they have to consent again.
+func (s *OidcService) RevokeAllClientTokens(ctx context.Context, clientID string, input dto.OidcRevokeClientTokensDto, actorUserID, ipAddress, userAgent string) (RevokedClientTokens, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	client, err := s.getClientInternal(ctx, clientID, tx)
+	if err != nil {
+		return RevokedClientTokens{}, err
+	}
+
+	var revoked RevokedClientTokens
+
+	result := tx.WithContext(ctx).Where("client_id = ?", client.ID).Delete(&model.OidcRefreshToken{})
+	if result.Error != nil {
+		return RevokedClientTokens{}, fmt.Errorf("failed to delete refresh tokens: %w", result.Error)
+	}
+	revoked.RefreshTokens = result.RowsAffected
+
+	result = tx.WithContext(ctx).Where("client_id = ?", client.ID).Delete(&model.OidcAuthorizationCode{})
+	if result.Error != nil {
+		return RevokedClientTokens{}, fmt.Errorf("failed to delete authorization codes: %w", result.Error)
+	}
+	revoked.AuthorizationCodes = result.RowsAffected
+
+	result = tx.WithContext(ctx).Where("client_id = ?", client.ID).Delete(&model.OidcDeviceCode{})
+	if result.Error != nil {
+		return RevokedClientTokens{}, fmt.Errorf("failed to delete device codes: %w", result.Error)
+	}
+	revoked.DeviceCodes = result.RowsAffected
+
+	if input.RevokeAccessTokens {
+		now := datatype.DateTime(time.Now())
+		err = tx.
+			WithContext(ctx).
+			Model(&model.OidcClient{}).
+			Where("id = ?", client.ID).
+			Update("tokens_revoked_at", &now).
+			Error
+		if err != nil {
+			return RevokedClientTokens{}, fmt.Errorf("failed to update client: %w", err)
+		}
+	}
+
+	if input.RequireConsent {
+		result = tx.WithContext(ctx).Where("client_id = ?", client.ID).Delete(&model.UserAuthorizedOidcClient{})
+		if result.Error != nil {
+			return RevokedClientTokens{}, fmt.Errorf("failed to delete authorizations: %w", result.Error)
+		}
+		revoked.Authorizations = result.RowsAffected
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventClientTokensRevoked, ipAddress, userAgent, actorUserID, model.AuditLogData{
+		"clientName":         client.Name,
+		"clientId":           client.ID,
+		"refreshTokens":      strconv.FormatInt(revoked.RefreshTokens, 10),
+		"authorizationCodes": strconv.FormatInt(revoked.AuthorizationCodes, 10),
+		"deviceCodes":        strconv.FormatInt(revoked.DeviceCodes, 10),
+		"authorizations":     strconv.FormatInt(revoked.Authorizations, 10),
+		"accessTokens":       strconv.FormatBool(input.RevokeAccessTokens),
+	}, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return RevokedClientTokens{}, err
+	}
+
+	return revoked, nil
+}
+
+// VerifyOAuthAccessToken verifies an OAuth access token, and checks that it wasn't revoked with the other tokens of its client
+func (s *OidcService) VerifyOAuthAccessToken(ctx context.Context, tokenString string) (jwt.Token, error) {
+	return s.verifyOAuthAccessTokenInternal(ctx, tokenString, s.db)
+}
+
+func (s *OidcService) verifyOAuthAccessTokenInternal(ctx context.Context, tokenString string, tx *gorm.DB) (jwt.Token, error) {
+	token, err := s.jwtService.VerifyOAuthAccessToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+
+	// The client ID is always the first audience of the access token
+	audience, ok := token.Audience()
+	if !ok || len(audience) == 0 || audience[0] == "" {
+		return nil, &common.TokenInvalidError{}
+	}
+
+	var client model.OidcClient
+	err = tx.
+		WithContext(ctx).
+		Select("tokens_revoked_at").
+		Where("id = ?", audience[0]).
+		Limit(1).
+		Find(&client).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load client: %w", err)
+	}
+
+	// Both times have a precision of one second, so tokens issued in the second of the revocation are rejected too
+	issuedAt, ok := token.IssuedAt()
+	if client.TokensRevokedAt != nil && (!ok || !issuedAt.After(client.TokensRevokedAt.ToTime())) {
+		return nil, &common.TokenInvalidError{}
+	}
+
+	return token, nil
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_RevokeAllClientTokens(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:               db,
+		jwtService:       jwtService,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John", IsAdmin: true}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Compromised", CreatedByID: user.ID}
+	other := model.OidcClient{Name: "Other", CreatedByID: user.ID}
+	require.NoError(t, db.Create(&[]*model.OidcClient{&client, &other}).Error)
+
+	expiresAt := datatype.DateTime(time.Now().Add(time.Hour))
+	for _, clientID := range []string{client.ID, other.ID} {
+		require.NoError(t, db.Create(&model.OidcRefreshToken{Token: "rt-" + clientID, ExpiresAt: expiresAt, UserID: user.ID, ClientID: clientID}).Error)
+		require.NoError(t, db.Create(&model.OidcAuthorizationCode{Code: "code-" + clientID, ExpiresAt: expiresAt, UserID: user.ID, ClientID: clientID}).Error)
+		require.NoError(t, db.Create(&model.OidcDeviceCode{DeviceCode: "dc-" + clientID, UserCode: "uc-" + clientID, ExpiresAt: expiresAt, ClientID: clientID}).Error)
+		require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{Scope: "openid", UserID: user.ID, ClientID: clientID}).Error)
+	}
+
+	accessToken, err := jwtService.GenerateOAuthAccessToken(user.ID, client.ID, nil)
+	require.NoError(t, err)
+	_, err = s.VerifyOAuthAccessToken(t.Context(), accessToken)
+	require.NoError(t, err)
+
+	t.Run("deletes the tokens of the client only", func(t *testing.T) {
+		revoked, err := s.RevokeAllClientTokens(t.Context(), client.ID, dto.OidcRevokeClientTokensDto{}, user.ID, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, RevokedClientTokens{RefreshTokens: 1, AuthorizationCodes: 1, DeviceCodes: 1}, revoked)
+
+		var count int64
+		require.NoError(t, db.Model(&model.OidcRefreshToken{}).Where("client_id = ?", other.ID).Count(&count).Error)
+		assert.EqualValues(t, 1, count)
+		require.NoError(t, db.Model(&model.UserAuthorizedOidcClient{}).Where("client_id = ?", client.ID).Count(&count).Error)
+		assert.EqualValues(t, 1, count)
+
+		// Access tokens are still accepted
+		_, err = s.VerifyOAuthAccessToken(t.Context(), accessToken)
+		require.NoError(t, err)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventClientTokensRevoked).First(&auditLog).Error)
+		assert.Equal(t, client.ID, auditLog.Data["clientId"])
+		assert.Equal(t, "1", auditLog.Data["refreshTokens"])
+	})
+
+	t.Run("rejects access tokens and requires consent", func(t *testing.T) {
+		revoked, err := s.RevokeAllClientTokens(t.Context(), client.ID, dto.OidcRevokeClientTokensDto{RevokeAccessTokens: true, RequireConsent: true}, user.ID, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, RevokedClientTokens{Authorizations: 1}, revoked)
+
+		_, err = s.VerifyOAuthAccessToken(t.Context(), accessToken)
+		require.ErrorIs(t, err, &common.TokenInvalidError{})
+
+		// Tokens of other clients are still accepted
+		otherAccessToken, err := jwtService.GenerateOAuthAccessToken(user.ID, other.ID, nil)
+		require.NoError(t, err)
+		_, err = s.VerifyOAuthAccessToken(t.Context(), otherAccessToken)
+		require.NoError(t, err)
+	})
+
+	t.Run("accepts access tokens issued after the revocation", func(t *testing.T) {
+		revokedAt := datatype.DateTime(time.Now().Add(-2 * time.Second))
+		require.NoError(t, db.Model(&model.OidcClient{}).Where("id = ?", client.ID).Update("tokens_revoked_at", &revokedAt).Error)
+
+		newAccessToken, err := jwtService.GenerateOAuthAccessToken(user.ID, client.ID, nil)
+		require.NoError(t, err)
+		_, err = s.VerifyOAuthAccessToken(t.Context(), newAccessToken)
+		require.NoError(t, err)
+	})
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// ActiveSession is a session of a user with an OIDC client, with the location of the IP address that last refreshed it
+type ActiveSession struct {
+	model.OidcRefreshToken
+
+	StartedAt datatype.DateTime
+	Country   string
+	City      string
+}
+
+// ListActiveSessions returns the sessions of the user with OIDC clients, which are the refresh tokens that haven't expired.
+// Sessions of the user with Pocket ID itself aren't stored, so they aren't included.
+func (s *OidcService) ListActiveSessions(ctx context.Context, userID string) ([]ActiveSession, error) {
+	var refreshTokens []model.OidcRefreshToken
+	err := s.db.
+		WithContext(ctx).
+		Preload("Client").
+		Where("user_id = ? AND expires_at > ?", userID, datatype.DateTime(time.Now())).
+		Order("created_at DESC").
+		Find(&refreshTokens).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load refresh tokens: %w", err)
+	}
+
+	sessions := make([]ActiveSession, len(refreshTokens))
+	for i, refreshToken := range refreshTokens {
+		sessions[i].OidcRefreshToken = refreshToken
+		sessions[i].StartedAt = datatype.DateTime(sessionStartedAt(&refreshToken))
+		if refreshToken.IpAddress == nil {
+			continue
+		}
+
+		country, city, err := s.geoLiteService.GetLocationByIP(ctx, *refreshToken.IpAddress)
+		if err != nil {
+			// The location is only informative
+			slog.DebugContext(ctx, "Failed to get the location of a session", slog.Any("error", err))
+			continue
+		}
+		sessions[i].Country = country
+		sessions[i].City = city
+	}
+
+	return sessions, nil
+}
+
+// RevokeSession revokes a session of the user with an OIDC client, which can't refresh its tokens anymore
+func (s *OidcService) RevokeSession(ctx context.Context, userID, sessionID, actorUserID, ipAddress, userAgent string) error {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var refreshToken model.OidcRefreshToken
+	err := tx.
+		WithContext(ctx).
+		Preload("Client").
+		Where("id = ? AND user_id = ?", sessionID, userID).
+		First(&refreshToken).
+		Error
+	if err != nil {
+		// gorm.ErrRecordNotFound is returned if the session was refreshed or revoked in the meantime
+		return err
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Delete(&refreshToken).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh token: %w", err)
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventSessionRevoked, ipAddress, userAgent, userID, model.AuditLogData{
+		"clientName":  refreshToken.Client.Name,
+		"actorUserId": actorUserID,
+	}, tx)
+
+	return tx.Commit().Error
+}
+
+// sessionStartedAt returns the time the session of the refresh token started; tokens issued before it was stored use the time they were issued
+func sessionStartedAt(refreshToken *model.OidcRefreshToken) time.Time {
+	if refreshToken.SessionStartedAt != nil {
+		return refreshToken.SessionStartedAt.ToTime()
+	}
+	return refreshToken.CreatedAt.ToTime()
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_Sessions(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		geoLiteService:   &GeoLiteService{},
+	}
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice", IsAdmin: true}
+	bob := model.User{Username: "bob", Email: "bob@example.com", FirstName: "Bob"}
+	require.NoError(t, db.Create(&[]*model.User{&alice, &bob}).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: alice.ID}
+	require.NoError(t, db.Create(&client).Error)
+
+	startedAt := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	active := model.OidcRefreshToken{
+		Token:            "active",
+		ExpiresAt:        datatype.DateTime(time.Now().Add(time.Hour)),
+		Scope:            "openid",
+		IpAddress:        utils.Ptr("192.168.1.10"),
+		SessionStartedAt: utils.Ptr(datatype.DateTime(startedAt)),
+		UserID:           bob.ID,
+		ClientID:         client.ID,
+	}
+	expired := model.OidcRefreshToken{
+		Token:     "expired",
+		ExpiresAt: datatype.DateTime(time.Now().Add(-time.Hour)),
+		UserID:    bob.ID,
+		ClientID:  client.ID,
+	}
+	require.NoError(t, db.Create(&[]*model.OidcRefreshToken{&active, &expired}).Error)
+
+	t.Run("lists the active sessions", func(t *testing.T) {
+		sessions, err := s.ListActiveSessions(t.Context(), bob.ID)
+		require.NoError(t, err)
+		require.Len(t, sessions, 1)
+
+		assert.Equal(t, active.ID, sessions[0].ID)
+		assert.Equal(t, "Client", sessions[0].Client.Name)
+		assert.Equal(t, startedAt.Unix(), sessions[0].StartedAt.ToTime().Unix())
+		assert.Equal(t, "Internal Network", sessions[0].Country)
+	})
+
+	t.Run("only revokes sessions of the user", func(t *testing.T) {
+		err := s.RevokeSession(t.Context(), alice.ID, active.ID, alice.ID, "", "")
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	})
+
+	t.Run("revokes a session", func(t *testing.T) {
+		require.NoError(t, s.RevokeSession(t.Context(), bob.ID, active.ID, alice.ID, "", ""))
+
+		sessions, err := s.ListActiveSessions(t.Context(), bob.ID)
+		require.NoError(t, err)
+		assert.Empty(t, sessions)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventSessionRevoked).First(&auditLog).Error)
+		assert.Equal(t, bob.ID, auditLog.UserID)
+		assert.Equal(t, "Client", auditLog.Data["clientName"])
+	})
+}
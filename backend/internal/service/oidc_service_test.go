@@ -18,6 +18,7 @@ import (
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
 	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
 )
 
@@ -303,8 +304,8 @@ func TestOidcService_verifyClientCredentialsInternal(t *testing.T) {
 				ClientAssertionType: ClientAssertionTypeJWTBearer,
 				ClientAssertion:     "invalid.jwt.token",
 			}, true)
-			require.Error(t, err)
-			require.ErrorIs(t, err, &common.OidcClientAssertionInvalidError{})
+			var assertionErr *common.OidcClientAssertionInvalidError
+			require.ErrorAs(t, err, &assertionErr)
 			assert.Nil(t, client)
 		})
 
@@ -332,8 +333,8 @@ func TestOidcService_verifyClientCredentialsInternal(t *testing.T) {
 					ClientAssertionType: ClientAssertionTypeJWTBearer,
 					ClientAssertion:     string(signedToken),
 				}, true)
-				require.Error(t, err)
-				require.ErrorIs(t, err, &common.OidcClientAssertionInvalidError{})
+				var assertionErr *common.OidcClientAssertionInvalidError
+				require.ErrorAs(t, err, &assertionErr)
 				require.Nil(t, client)
 			}
 		}
@@ -379,3 +380,1355 @@ func TestOidcService_verifyClientCredentialsInternal(t *testing.T) {
 		})
 	})
 }
+
+func TestOidcService_verifyClientAssertionFromFederatedIdentities(t *testing.T) {
+	const (
+		issuer   = "https://external-idp.com"
+		audience = "https://pocket-id.com"
+		subject  = "federated-client"
+		jwksURL  = issuer + "/jwks.json"
+	)
+
+	privateJWK, jwkSetJSON := generateTestECDSAKey(t)
+	otherPrivateJWK, _ := generateTestECDSAKey(t)
+	jwks, err := jwk.Parse(jwkSetJSON)
+	require.NoError(t, err)
+
+	// The JWK set is cached, so it isn't fetched
+	s := &OidcService{}
+	s.federatedJwks.store(jwksURL, jwks, time.Now())
+
+	client := &model.OidcClient{
+		Base: model.Base{ID: "client-id"},
+		Credentials: model.OidcClientCredentials{
+			FederatedIdentities: []model.OidcClientFederatedIdentity{
+				{Issuer: issuer, Audience: audience, Subject: subject, JWKS: jwksURL},
+			},
+		},
+	}
+
+	// newBuilder returns the claims of a valid assertion, like the ones of TestService.SignExternalIdPToken
+	newBuilder := func() *jwt.Builder {
+		now := time.Now()
+		return jwt.NewBuilder().
+			Subject(subject).
+			Expiration(now.Add(time.Hour)).
+			IssuedAt(now).
+			Issuer(issuer).
+			Audience([]string{audience})
+	}
+	sign := func(t *testing.T, builder *jwt.Builder, options ...jwt.SignOption) string {
+		t.Helper()
+		token, err := builder.Build()
+		require.NoError(t, err)
+		if len(options) == 0 {
+			options = []jwt.SignOption{jwt.WithKey(jwa.ES256(), privateJWK)}
+		}
+		signed, err := jwt.Sign(token, options...)
+		require.NoError(t, err)
+		return string(signed)
+	}
+
+	tests := []struct {
+		name          string
+		assertion     func(t *testing.T) string
+		expectedError error
+	}{
+		{
+			name: "valid assertion",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder())
+			},
+		},
+		{
+			name: "valid assertion with multiple audiences",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Audience([]string{"https://other.example.com", audience}))
+			},
+		},
+		{
+			name: "issuer with a trailing slash",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Issuer(issuer+"/"))
+			},
+			expectedError: errClientAssertionIssuerNotAllowed,
+		},
+		{
+			name: "issuer with a prefix of the federated issuer",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Issuer(issuer+".evil.com"))
+			},
+			expectedError: errClientAssertionIssuerNotAllowed,
+		},
+		{
+			name: "audience with a prefix of the federated audience",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Audience([]string{audience + "/extra"}))
+			},
+			expectedError: errClientAssertionAudienceMismatch,
+		},
+		{
+			name: "missing audience",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Audience(nil))
+			},
+			expectedError: errClientAssertionAudienceMismatch,
+		},
+		{
+			name: "subject with a different case",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Subject("Federated-Client"))
+			},
+			expectedError: errClientAssertionSubjectMismatch,
+		},
+		{
+			name: "subject of another client",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Subject(client.ID))
+			},
+			expectedError: errClientAssertionSubjectMismatch,
+		},
+		{
+			name: "expired assertion",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().Expiration(time.Now().Add(-10*time.Minute)))
+			},
+			expectedError: errClientAssertionExpired,
+		},
+		{
+			name: "assertion without expiration",
+			assertion: func(t *testing.T) string {
+				token, err := newBuilder().Build()
+				require.NoError(t, err)
+				require.NoError(t, token.Remove(jwt.ExpirationKey))
+				signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), privateJWK))
+				require.NoError(t, err)
+				return string(signed)
+			},
+			expectedError: errClientAssertionExpirationMissing,
+		},
+		{
+			name: "assertion not valid yet",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().NotBefore(time.Now().Add(10*time.Minute)))
+			},
+			expectedError: errClientAssertionNotYetValid,
+		},
+		{
+			name: "assertion issued in the future",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder().IssuedAt(time.Now().Add(10*time.Minute)))
+			},
+			expectedError: errClientAssertionIssuedInFuture,
+		},
+		{
+			name: "unsigned assertion",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder(), jwt.WithInsecureNoSignature())
+			},
+			expectedError: errClientAssertionAlgorithmNotAllowed,
+		},
+		{
+			name: "assertion signed with a symmetric algorithm",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder(), jwt.WithKey(jwa.HS256(), []byte("a-shared-secret-of-at-least-32-bytes")))
+			},
+			expectedError: errClientAssertionAlgorithmNotAllowed,
+		},
+		{
+			name: "assertion signed with another key",
+			assertion: func(t *testing.T) string {
+				return sign(t, newBuilder(), jwt.WithKey(jwa.ES256(), otherPrivateJWK))
+			},
+			expectedError: errClientAssertionSignatureInvalid,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.verifyClientAssertionFromFederatedIdentities(t.Context(), client, ClientAuthCredentials{
+				ClientAssertionType: ClientAssertionTypeJWTBearer,
+				ClientAssertion:     tt.assertion(t),
+			})
+			if tt.expectedError == nil {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, tt.expectedError)
+			assert.Equal(t, tt.expectedError.Error(), clientAssertionErrorReason(err))
+		})
+	}
+
+	t.Run("defaults to the client ID and the app URL", func(t *testing.T) {
+		defaultsClient := &model.OidcClient{
+			Base: model.Base{ID: "client-id"},
+			Credentials: model.OidcClientCredentials{
+				FederatedIdentities: []model.OidcClientFederatedIdentity{{Issuer: issuer, JWKS: jwksURL}},
+			},
+		}
+
+		err := s.verifyClientAssertionFromFederatedIdentities(t.Context(), defaultsClient, ClientAuthCredentials{
+			ClientAssertion: sign(t, newBuilder().Subject(defaultsClient.ID).Audience([]string{common.EnvConfig.AppURL})),
+		})
+		require.NoError(t, err)
+
+		err = s.verifyClientAssertionFromFederatedIdentities(t.Context(), defaultsClient, ClientAuthCredentials{
+			ClientAssertion: sign(t, newBuilder().Subject(defaultsClient.ID)),
+		})
+		require.ErrorIs(t, err, errClientAssertionAudienceMismatch)
+	})
+}
+
+func TestOidcService_verifyClientAssertionFromMultipleFederatedIdentities(t *testing.T) {
+	const (
+		issuer1 = "https://idp-1.example.com"
+		issuer2 = "https://idp-2.example.com"
+	)
+
+	privateJWK1, jwkSetJSON1 := generateTestECDSAKey(t)
+	privateJWK2, jwkSetJSON2 := generateTestECDSAKey(t)
+	otherPrivateJWK, _ := generateTestECDSAKey(t)
+
+	// Each issuer has its own JWK set, which is cached separately
+	s := &OidcService{}
+	for url, jwkSetJSON := range map[string][]byte{issuer1 + "/jwks.json": jwkSetJSON1, issuer2 + "/.well-known/jwks.json": jwkSetJSON2} {
+		jwks, err := jwk.Parse(jwkSetJSON)
+		require.NoError(t, err)
+		s.federatedJwks.store(url, jwks, time.Now())
+	}
+
+	client := &model.OidcClient{
+		Base: model.Base{ID: "client-id"},
+		Credentials: model.OidcClientCredentials{
+			FederatedIdentities: []model.OidcClientFederatedIdentity{
+				{Issuer: issuer1, Subject: "workload-a", Audience: "pocket-id", JWKS: issuer1 + "/jwks.json"},
+				{Issuer: issuer1, Subject: "workload-b", Audience: "pocket-id", JWKS: issuer1 + "/jwks.json"},
+				{Issuer: issuer2, Subject: "client-id", Audience: "pocket-id"},
+			},
+		},
+	}
+
+	sign := func(t *testing.T, key jwk.Key, issuer, subject string) string {
+		t.Helper()
+		token, err := jwt.NewBuilder().
+			Issuer(issuer).
+			Subject(subject).
+			Audience([]string{"pocket-id"}).
+			IssuedAt(time.Now()).
+			Expiration(time.Now().Add(time.Hour)).
+			Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), key))
+		require.NoError(t, err)
+		return string(signed)
+	}
+	verify := func(assertion string) error {
+		return s.verifyClientAssertionFromFederatedIdentities(t.Context(), client, ClientAuthCredentials{ClientAssertion: assertion})
+	}
+
+	t.Run("accepts assertions of each issuer", func(t *testing.T) {
+		require.NoError(t, verify(sign(t, privateJWK1, issuer1, "workload-a")))
+		require.NoError(t, verify(sign(t, privateJWK2, issuer2, "client-id")))
+	})
+
+	t.Run("accepts assertions matching any identity of the issuer", func(t *testing.T) {
+		require.NoError(t, verify(sign(t, privateJWK1, issuer1, "workload-b")))
+
+		err := verify(sign(t, privateJWK1, issuer1, "workload-c"))
+		require.ErrorIs(t, err, errClientAssertionSubjectMismatch)
+	})
+
+	t.Run("verifies assertions with the keys of their issuer", func(t *testing.T) {
+		err := verify(sign(t, privateJWK1, issuer2, "client-id"))
+		require.ErrorIs(t, err, errClientAssertionSignatureInvalid)
+	})
+
+	t.Run("rejects assertions of other issuers", func(t *testing.T) {
+		err := verify(sign(t, otherPrivateJWK, "https://idp-3.example.com", "client-id"))
+		require.ErrorIs(t, err, errClientAssertionIssuerNotAllowed)
+	})
+}
+
+func TestOidcService_ClientFederatedIdentities(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	s := &OidcService{db: db}
+
+	client := model.OidcClient{Name: "Federated Client"}
+	require.NoError(t, db.Create(&client).Error)
+
+	identityDto := dto.OidcClientFederatedIdentityCreateDto{Issuer: "https://idp-1.example.com", Subject: "workload-a"}
+
+	t.Run("adds federated identities", func(t *testing.T) {
+		updated, err := s.AddClientFederatedIdentity(t.Context(), client.ID, identityDto)
+		require.NoError(t, err)
+		require.Len(t, updated.Credentials.FederatedIdentities, 1)
+
+		updated, err = s.AddClientFederatedIdentity(t.Context(), client.ID, dto.OidcClientFederatedIdentityCreateDto{Issuer: "https://idp-2.example.com"})
+		require.NoError(t, err)
+		require.Len(t, updated.Credentials.FederatedIdentities, 2)
+
+		var stored model.OidcClient
+		require.NoError(t, db.First(&stored, "id = ?", client.ID).Error)
+		assert.Equal(t, []model.OidcClientFederatedIdentity{
+			{Issuer: "https://idp-1.example.com", Subject: "workload-a"},
+			{Issuer: "https://idp-2.example.com"},
+		}, stored.Credentials.FederatedIdentities)
+	})
+
+	t.Run("rejects duplicate federated identities", func(t *testing.T) {
+		duplicate := identityDto
+		duplicate.JWKS = "https://idp-1.example.com/other-jwks.json"
+		_, err := s.AddClientFederatedIdentity(t.Context(), client.ID, duplicate)
+		var alreadyExistsErr *common.OidcFederatedIdentityAlreadyExistsError
+		require.ErrorAs(t, err, &alreadyExistsErr)
+	})
+
+	t.Run("removes federated identities that match exactly", func(t *testing.T) {
+		_, err := s.RemoveClientFederatedIdentity(t.Context(), client.ID, dto.OidcClientFederatedIdentityDeleteDto{Issuer: "https://idp-1.example.com"})
+		var notFoundErr *common.OidcFederatedIdentityNotFoundError
+		require.ErrorAs(t, err, &notFoundErr)
+
+		updated, err := s.RemoveClientFederatedIdentity(t.Context(), client.ID, dto.OidcClientFederatedIdentityDeleteDto{Issuer: "https://idp-1.example.com", Subject: "workload-a"})
+		require.NoError(t, err)
+		assert.Equal(t, []model.OidcClientFederatedIdentity{{Issuer: "https://idp-2.example.com"}}, updated.Credentials.FederatedIdentities)
+
+		var stored model.OidcClient
+		require.NoError(t, db.First(&stored, "id = ?", client.ID).Error)
+		assert.Equal(t, updated.Credentials.FederatedIdentities, stored.Credentials.FederatedIdentities)
+	})
+}
+
+func TestOidcService_GetClientPreview(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+	group := model.UserGroup{Name: "staff", FriendlyName: "Staff", Users: []model.User{user}}
+	require.NoError(t, db.Create(&group).Error)
+	otherGroup := model.UserGroup{Name: "admins", FriendlyName: "Admins"}
+	require.NoError(t, db.Create(&otherGroup).Error)
+	require.NoError(t, db.Create(&[]model.CustomClaim{
+		{Key: "department", Value: "engineering", UserID: &user.ID},
+		{Key: "department", Value: "ignored", UserGroupID: &group.ID},
+		{Key: "roles", Value: `["reader"]`, UserGroupID: &group.ID},
+	}).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+	}, user.ID)
+	require.NoError(t, err)
+
+	t.Run("returns the claims and their sources", func(t *testing.T) {
+		res, err := s.GetClientPreview(t.Context(), client.ID, user.ID, "openid profile groups")
+		require.NoError(t, err)
+
+		assert.Equal(t, []string{"openid", "profile", "groups"}, res.Scopes)
+		assert.Equal(t, "engineering", res.UserInfo["department"])
+		assert.Equal(t, []any{"reader"}, res.UserInfo["roles"])
+		assert.NotContains(t, res.UserInfo, "email")
+		assert.Equal(t, "custom claim of the user", res.ClaimSources["department"])
+		assert.Equal(t, "custom claim of the group 'staff'", res.ClaimSources["roles"])
+		assert.Equal(t, "scope:groups", res.ClaimSources["groups"])
+
+		assert.Equal(t, user.ID, res.IdToken["sub"])
+		assert.Equal(t, "engineering", res.IdToken["department"])
+		assert.Equal(t, "https://test.example.com", res.IdToken["iss"])
+		assert.Equal(t, user.ID, res.AccessToken["sub"])
+	})
+
+	t.Run("requires the scopes if the user hasn't authorized the client", func(t *testing.T) {
+		_, err := s.GetClientPreview(t.Context(), client.ID, user.ID, "")
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("defaults to the scopes the user has authorized", func(t *testing.T) {
+		require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: user.ID, ClientID: client.ID, Scope: "openid email"}).Error)
+
+		res, err := s.GetClientPreview(t.Context(), client.ID, user.ID, "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"openid", "email"}, res.Scopes)
+		assert.Equal(t, "alice@example.com", res.UserInfo["email"])
+		assert.Equal(t, "scope:email", res.ClaimSources["email"])
+	})
+
+	t.Run("rejects users that aren't allowed to use the client", func(t *testing.T) {
+		_, err := s.UpdateAllowedUserGroups(t.Context(), client.ID, dto.OidcUpdateAllowedUserGroupsDto{UserGroupIDs: []string{otherGroup.ID}})
+		require.NoError(t, err)
+
+		_, err = s.GetClientPreview(t.Context(), client.ID, user.ID, "openid email")
+		var accessDeniedErr *common.OidcAccessDeniedError
+		require.ErrorAs(t, err, &accessDeniedErr)
+	})
+}
+
+func TestOidcService_NameClaims(t *testing.T) {
+	newService := func(preferredUsername, nameTemplate string) *OidcService {
+		return &OidcService{appConfigService: NewTestAppConfigService(&model.AppConfig{
+			PreferredUsernameClaim: model.AppConfigVariable{Value: preferredUsername},
+			NameClaimTemplate:      model.AppConfigVariable{Value: nameTemplate},
+		})}
+	}
+	user := model.User{Username: "jdoe", Email: "john.doe@example.com", FirstName: "John", LastName: "Doe"}
+	usernameOnly := model.User{Username: "ghost", Email: "ghost@example.com"}
+
+	t.Run("uses the full name and username by default", func(t *testing.T) {
+		s := newService("username", "")
+		assert.Equal(t, "John Doe", s.nameClaim(user))
+		assert.Equal(t, "jdoe", s.preferredUsernameClaim(user))
+	})
+
+	t.Run("falls back to the username if the name is empty", func(t *testing.T) {
+		assert.Equal(t, "ghost", newService("username", "").nameClaim(usernameOnly))
+		assert.Equal(t, "ghost", newService("username", "{firstName} {lastName}").nameClaim(usernameOnly))
+	})
+
+	t.Run("composes the name with the template", func(t *testing.T) {
+		assert.Equal(t, "Doe, John (jdoe)", newService("username", "{lastName}, {firstName} ({username})").nameClaim(user))
+	})
+
+	t.Run("uses the local part of the email address as preferred username", func(t *testing.T) {
+		s := newService("emailLocalPart", "")
+		assert.Equal(t, "john.doe", s.preferredUsernameClaim(user))
+		assert.Equal(t, "nomail", s.preferredUsernameClaim(model.User{Username: "nomail"}))
+	})
+}
+
+func TestOidcService_Authorize_PromptNone(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+	}, user.ID)
+	require.NoError(t, err)
+
+	authorize := func(userID, prompt string) (string, string, error) {
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://example.com/callback",
+			Prompt:      prompt,
+		}, userID, time.Now(), nil, "", "")
+	}
+
+	requireSilentAuthError := func(t *testing.T, err error, callbackURL, code string) {
+		t.Helper()
+		var silentAuthErr *common.OidcSilentAuthenticationError
+		require.ErrorAs(t, err, &silentAuthErr)
+		assert.Equal(t, code, silentAuthErr.Code)
+		assert.Equal(t, "https://example.com/callback", callbackURL)
+	}
+
+	t.Run("requires a session", func(t *testing.T) {
+		_, callbackURL, err := authorize("", "none")
+		requireSilentAuthError(t, err, callbackURL, "login_required")
+
+		_, _, err = authorize("", "")
+		require.ErrorIs(t, err, &common.NotSignedInError{})
+	})
+
+	t.Run("requires the consent of the user", func(t *testing.T) {
+		_, callbackURL, err := authorize(user.ID, "none")
+		requireSilentAuthError(t, err, callbackURL, "consent_required")
+	})
+
+	t.Run("rejects prompt=none combined with other values", func(t *testing.T) {
+		_, callbackURL, err := authorize(user.ID, "none login")
+		requireSilentAuthError(t, err, callbackURL, "invalid_request")
+	})
+
+	t.Run("returns a code if the user has consented", func(t *testing.T) {
+		code, _, err := authorize(user.ID, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+
+		code, callbackURL, err := authorize(user.ID, "none")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+		assert.Equal(t, "https://example.com/callback", callbackURL)
+	})
+
+	t.Run("doesn't send errors to callback URLs that aren't registered", func(t *testing.T) {
+		tofuClient, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{Name: "TOFU client"}, user.ID)
+		require.NoError(t, err)
+		input := dto.AuthorizeOidcClientRequestDto{
+			ClientID:    tofuClient.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://attacker.example.com/callback",
+			Prompt:      "none",
+		}
+
+		_, callbackURL, err := s.Authorize(t.Context(), input, "", time.Now(), nil, "", "")
+		var silentAuthErr *common.OidcSilentAuthenticationError
+		require.ErrorAs(t, err, &silentAuthErr)
+		assert.Empty(t, callbackURL)
+
+		// The callback URL is only registered once the user authorized the client
+		var stored model.OidcClient
+		require.NoError(t, db.First(&stored, "id = ?", tofuClient.ID).Error)
+		assert.Empty(t, stored.CallbackURLs)
+
+		input.CallbackURL = "https://example.com/callback"
+		input.Prompt = ""
+		code, callbackURL, err := s.Authorize(t.Context(), input, user.ID, time.Now(), nil, "", "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+		assert.Equal(t, "https://example.com/callback", callbackURL)
+
+		require.NoError(t, db.First(&stored, "id = ?", tofuClient.ID).Error)
+		assert.Equal(t, model.UrlList{"https://example.com/callback"}, stored.CallbackURLs)
+	})
+
+	t.Run("denies users that aren't allowed to use the client", func(t *testing.T) {
+		group := model.UserGroup{Name: "admins", FriendlyName: "Admins"}
+		require.NoError(t, db.Create(&group).Error)
+		_, err := s.UpdateAllowedUserGroups(t.Context(), client.ID, dto.OidcUpdateAllowedUserGroupsDto{UserGroupIDs: []string{group.ID}})
+		require.NoError(t, err)
+
+		_, callbackURL, err := authorize(user.ID, "none")
+		requireSilentAuthError(t, err, callbackURL, "access_denied")
+	})
+}
+
+func TestOidcService_Authorize_MaxAge(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		auditLogService:    &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+	}, user.ID)
+	require.NoError(t, err)
+	clientSecret, err := s.CreateClientSecret(t.Context(), client.ID)
+	require.NoError(t, err)
+
+	authorize := func(authTime time.Time, maxAge *int, prompt string) (string, string, error) {
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://example.com/callback",
+			Prompt:      prompt,
+			MaxAge:      maxAge,
+		}, user.ID, authTime, nil, "", "")
+	}
+
+	zero := 0
+	oneHour := 3600
+	signedInAt := time.Now().Add(-5 * time.Minute).Truncate(time.Second)
+
+	t.Run("requires a new sign in with max_age=0", func(t *testing.T) {
+		_, _, err := authorize(signedInAt, &zero, "")
+		var reauthErr *common.OidcReauthenticationRequiredError
+		require.ErrorAs(t, err, &reauthErr)
+
+		_, callbackURL, err := authorize(signedInAt, &zero, "none")
+		var silentAuthErr *common.OidcSilentAuthenticationError
+		require.ErrorAs(t, err, &silentAuthErr)
+		assert.Equal(t, "login_required", silentAuthErr.Code)
+		assert.Equal(t, "https://example.com/callback", callbackURL)
+	})
+
+	t.Run("accepts a sign in that just happened with max_age=0", func(t *testing.T) {
+		code, _, err := authorize(time.Now(), &zero, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+
+	t.Run("accepts a sign in within max_age", func(t *testing.T) {
+		code, _, err := authorize(signedInAt, &oneHour, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+
+	t.Run("emits the auth_time claim in the ID token", func(t *testing.T) {
+		code, _, err := authorize(signedInAt, nil, "")
+		require.NoError(t, err)
+
+		tokens, err := s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeAuthorizationCode,
+			Code:         code,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+		require.NoError(t, err)
+
+		idToken, err := jwtService.VerifyIdToken(tokens.IdToken, false)
+		require.NoError(t, err)
+		authTime, err := GetAuthTime(idToken)
+		require.NoError(t, err)
+		assert.Equal(t, signedInAt.Unix(), authTime.Unix())
+	})
+}
+
+func TestOidcService_Authorize_AcrValues(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		auditLogService:    &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+	}, user.ID)
+	require.NoError(t, err)
+	clientSecret, err := s.CreateClientSecret(t.Context(), client.ID)
+	require.NoError(t, err)
+
+	authorize := func(authMethods []string, acrValues, prompt string) (string, string, error) {
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://example.com/callback",
+			Prompt:      prompt,
+			AcrValues:   acrValues,
+		}, user.ID, time.Now(), authMethods, "", "")
+	}
+
+	passkeyAcr := common.EnvConfig.OidcAcrPasskey
+	oneTimeCodeAcr := common.EnvConfig.OidcAcrOneTimeCode
+
+	t.Run("requires a passkey for the passkey ACR", func(t *testing.T) {
+		_, _, err := authorize([]string{AmrOneTimeCode}, passkeyAcr, "")
+		var reauthErr *common.OidcReauthenticationRequiredError
+		require.ErrorAs(t, err, &reauthErr)
+
+		_, _, err = authorize([]string{AmrOneTimeCode}, passkeyAcr, "none")
+		var silentAuthErr *common.OidcSilentAuthenticationError
+		require.ErrorAs(t, err, &silentAuthErr)
+		assert.Equal(t, "login_required", silentAuthErr.Code)
+
+		code, _, err := authorize([]string{AmrPasskey}, passkeyAcr, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+
+	t.Run("accepts any of the requested ACR values", func(t *testing.T) {
+		code, _, err := authorize([]string{AmrOneTimeCode}, passkeyAcr+" "+oneTimeCodeAcr, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+
+	t.Run("rejects unknown ACR values", func(t *testing.T) {
+		_, _, err := authorize([]string{AmrPasskey}, "urn:unknown", "")
+		var acrErr *common.OidcAcrValuesNotSupportedError
+		require.ErrorAs(t, err, &acrErr)
+	})
+
+	t.Run("emits the acr and amr claims in the ID token", func(t *testing.T) {
+		code, _, err := authorize([]string{AmrPasskey}, "", "")
+		require.NoError(t, err)
+
+		tokens, err := s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeAuthorizationCode,
+			Code:         code,
+			ClientID:     client.ID,
+			ClientSecret: clientSecret,
+		}, "", "")
+		require.NoError(t, err)
+
+		idToken, err := jwtService.VerifyIdToken(tokens.IdToken, false)
+		require.NoError(t, err)
+		authMethods, err := GetAuthMethods(idToken)
+		require.NoError(t, err)
+		assert.Equal(t, []string{AmrPasskey}, authMethods)
+		var acr string
+		require.NoError(t, idToken.Get(AuthContextClassClaim, &acr))
+		assert.Equal(t, passkeyAcr, acr)
+	})
+}
+
+func TestOidcService_Authorize_RequireEmail(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		auditLogService:    &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	userWithEmail := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&userWithEmail).Error)
+	userWithoutEmail := model.User{Username: "bob", FirstName: "Bob"}
+	require.NoError(t, db.Create(&userWithoutEmail).Error)
+
+	// Without callback URLs, the first authorization saves its callback URL in the same transaction
+	requireEmail := true
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		RequireEmail: &requireEmail,
+	}, userWithEmail.ID)
+	require.NoError(t, err)
+	require.True(t, client.RequireEmail)
+
+	authorize := func(userID, prompt string) (string, string, error) {
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid profile",
+			CallbackURL: "https://example.com/callback",
+			Prompt:      prompt,
+		}, userID, time.Now(), []string{AmrPasskey}, "", "")
+	}
+
+	t.Run("blocks users without an email address", func(t *testing.T) {
+		_, _, err := authorize(userWithoutEmail.ID, "")
+		var emailRequiredErr *common.OidcEmailRequiredError
+		require.ErrorAs(t, err, &emailRequiredErr)
+
+		_, _, err = authorize(userWithoutEmail.ID, "none")
+		var silentAuthErr *common.OidcSilentAuthenticationError
+		require.ErrorAs(t, err, &silentAuthErr)
+		assert.Equal(t, "access_denied", silentAuthErr.Code)
+
+		// The blocked authorizations are logged, but nothing else is saved
+		var auditLogs []model.AuditLog
+		require.NoError(t, db.Where("user_id = ?", userWithoutEmail.ID).Find(&auditLogs).Error)
+		require.Len(t, auditLogs, 2)
+		assert.Equal(t, model.AuditLogEventClientAuthorizationBlocked, auditLogs[0].Event)
+		assert.Equal(t, "email_required", auditLogs[0].Data["reason"])
+
+		var stored model.OidcClient
+		require.NoError(t, db.First(&stored, "id = ?", client.ID).Error)
+		assert.Empty(t, stored.CallbackURLs)
+	})
+
+	t.Run("allows users with an email address", func(t *testing.T) {
+		code, _, err := authorize(userWithEmail.ID, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+
+	t.Run("allows users without an email address if the client doesn't require it", func(t *testing.T) {
+		requireEmail = false
+		_, err := s.UpdateClient(t.Context(), client.ID, dto.OidcClientCreateDto{
+			Name:         client.Name,
+			CallbackURLs: []string{"https://example.com/callback"},
+			RequireEmail: &requireEmail,
+		})
+		require.NoError(t, err)
+
+		code, _, err := authorize(userWithoutEmail.ID, "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, code)
+	})
+}
+
+func TestOidcService_ValidateCallbackURLs(t *testing.T) {
+	originalSchemes := common.EnvConfig.CallbackURLAllowedSchemes
+	originalBlockPrivateIPs := common.EnvConfig.CallbackURLBlockPrivateIPs
+	t.Cleanup(func() {
+		common.EnvConfig.CallbackURLAllowedSchemes = originalSchemes
+		common.EnvConfig.CallbackURLBlockPrivateIPs = originalBlockPrivateIPs
+	})
+	common.EnvConfig.CallbackURLAllowedSchemes = []string{"https", "com.example.app"}
+	common.EnvConfig.CallbackURLBlockPrivateIPs = true
+
+	s := &OidcService{geoLiteService: &GeoLiteService{}}
+
+	tests := []struct {
+		name        string
+		callbackURL string
+		allowed     bool
+	}{
+		{name: "https", callbackURL: "https://example.com/callback", allowed: true},
+		{name: "wildcard host", callbackURL: "https://*.example.com/*", allowed: true},
+		{name: "custom scheme", callbackURL: "com.example.app:/callback", allowed: true},
+		{name: "http on localhost", callbackURL: "http://localhost:8080/callback", allowed: true},
+		{name: "http on loopback IP", callbackURL: "http://127.0.0.1/callback", allowed: true},
+		{name: "http", callbackURL: "http://example.com/callback", allowed: false},
+		{name: "http with wildcard host", callbackURL: "http://*.localhost/callback", allowed: false},
+		{name: "unknown scheme", callbackURL: "javascript:alert(1)", allowed: false},
+		{name: "wildcard scheme", callbackURL: "*://example.com/callback", allowed: false},
+		{name: "private IP", callbackURL: "https://192.168.1.10/callback", allowed: false},
+		{name: "link-local IP", callbackURL: "https://169.254.169.254/latest", allowed: false},
+		{name: "private IPv6", callbackURL: "https://[fd00::1]/callback", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := s.validateCallbackURLs(t.Context(), []string{tt.callbackURL})
+			if tt.allowed {
+				require.NoError(t, err)
+				return
+			}
+			var validationErr *common.ValidationError
+			require.ErrorAs(t, err, &validationErr)
+		})
+	}
+
+	t.Run("allows private IPs if they're not blocked", func(t *testing.T) {
+		common.EnvConfig.CallbackURLBlockPrivateIPs = false
+		t.Cleanup(func() {
+			common.EnvConfig.CallbackURLBlockPrivateIPs = true
+		})
+
+		require.NoError(t, s.validateCallbackURLs(t.Context(), []string{"https://192.168.1.10/callback"}))
+	})
+}
+
+func TestOidcService_ValidateClient(t *testing.T) {
+	originalSchemes := common.EnvConfig.CallbackURLAllowedSchemes
+	t.Cleanup(func() {
+		common.EnvConfig.CallbackURLAllowedSchemes = originalSchemes
+	})
+	common.EnvConfig.CallbackURLAllowedSchemes = []string{"https"}
+
+	db := testutils.NewDatabaseForTest(t)
+	group := model.UserGroup{Name: "staff", FriendlyName: "Staff"}
+	require.NoError(t, db.Create(&group).Error)
+
+	_, jwkSetJSON := generateTestECDSAKey(t)
+	s := &OidcService{
+		db:             db,
+		geoLiteService: &GeoLiteService{},
+		httpClient: &http.Client{
+			Transport: &testutils.MockRoundTripper{
+				Responses: map[string]*http.Response{
+					//nolint:bodyclose
+					"https://idp.example.com/.well-known/jwks.json": testutils.NewMockResponse(http.StatusOK, string(jwkSetJSON)),
+				},
+			},
+		},
+	}
+
+	t.Run("accepts a valid configuration", func(t *testing.T) {
+		result, err := s.ValidateClient(t.Context(), dto.OidcClientValidateDto{
+			OidcClientCreateDto: dto.OidcClientCreateDto{
+				Name:         "Client",
+				CallbackURLs: []string{"https://app.example.com/callback"},
+				Credentials: dto.OidcClientCredentialsDto{
+					FederatedIdentities: []dto.OidcClientFederatedIdentityDto{{Issuer: "https://idp.example.com"}},
+				},
+			},
+			AllowedUserGroupIDs: []string{group.ID},
+			Scope:               "openid profile",
+		})
+		require.NoError(t, err)
+		assert.True(t, result.Valid)
+		assert.Empty(t, result.Issues)
+	})
+
+	t.Run("reports the issues", func(t *testing.T) {
+		result, err := s.ValidateClient(t.Context(), dto.OidcClientValidateDto{
+			OidcClientCreateDto: dto.OidcClientCreateDto{
+				Name:         "Client",
+				CallbackURLs: []string{"http://app.example.com/callback"},
+				Credentials: dto.OidcClientCredentialsDto{
+					FederatedIdentities: []dto.OidcClientFederatedIdentityDto{{Issuer: "https://idp.example.com", JWKS: "https://idp.example.com/missing"}},
+				},
+			},
+			AllowedUserGroupIDs: []string{group.ID, "missing"},
+			Scope:               "openid offline_access",
+		})
+		require.NoError(t, err)
+		assert.False(t, result.Valid)
+
+		fields := make(map[string]string, len(result.Issues))
+		for _, issue := range result.Issues {
+			fields[issue.Field] = issue.Severity
+		}
+		assert.Equal(t, map[string]string{
+			"callbackURLs[0]":                         "error",
+			"credentials.federatedIdentities[0].jwks": "error",
+			"allowedUserGroupIds[1]":                  "error",
+			"scope":                                   "warning",
+		}, fields)
+	})
+}
+
+func TestOidcService_GrantTypes(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := &OidcService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+		IsPublic:     true,
+	}, user.ID)
+	require.NoError(t, err)
+
+	t.Run("new clients get the default grant types", func(t *testing.T) {
+		assert.Equal(t, model.StringList{GrantTypeAuthorizationCode, GrantTypeRefreshToken}, client.GrantTypes)
+	})
+
+	t.Run("rejects grant types that aren't allowed", func(t *testing.T) {
+		_, err := s.CreateDeviceAuthorization(t.Context(), dto.OidcDeviceAuthorizationRequestDto{ClientID: client.ID, Scope: "openid"})
+		require.ErrorIs(t, err, &common.OidcUnauthorizedClientError{})
+	})
+
+	t.Run("keeps the grant types if they're omitted", func(t *testing.T) {
+		updated, err := s.UpdateClient(t.Context(), client.ID, dto.OidcClientCreateDto{
+			Name:         "Client",
+			CallbackURLs: []string{"https://example.com/callback"},
+			IsPublic:     true,
+			GrantTypes:   []string{GrantTypeDeviceCode},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.StringList{GrantTypeDeviceCode}, updated.GrantTypes)
+
+		updated, err = s.UpdateClient(t.Context(), client.ID, dto.OidcClientCreateDto{
+			Name:         "Renamed",
+			CallbackURLs: []string{"https://example.com/callback"},
+			IsPublic:     true,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, model.StringList{GrantTypeDeviceCode}, updated.GrantTypes)
+	})
+
+	t.Run("uses the configured grant types", func(t *testing.T) {
+		_, err := s.CreateDeviceAuthorization(t.Context(), dto.OidcDeviceAuthorizationRequestDto{ClientID: client.ID, Scope: "openid"})
+		require.NoError(t, err)
+
+		_, _, err = s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:      client.ID,
+			Scope:         "openid",
+			CallbackURL:   "https://example.com/callback",
+			CodeChallenge: "challenge",
+		}, user.ID, time.Now(), nil, "", "")
+		require.ErrorIs(t, err, &common.OidcUnauthorizedClientError{})
+	})
+}
+
+func TestOidcService_ClientCredentials(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:               db,
+		jwtService:       jwtService,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createClient := func(t *testing.T, grantTypes []string) (model.OidcClient, string) {
+		t.Helper()
+		client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:                    "Service",
+			GrantTypes:              grantTypes,
+			ClientCredentialsScopes: []string{"api:write", "api:read"},
+		}, user.ID)
+		require.NoError(t, err)
+		secret, err := s.CreateClientSecret(t.Context(), client.ID)
+		require.NoError(t, err)
+		return client, secret
+	}
+	client, secret := createClient(t, []string{GrantTypeClientCredentials})
+
+	createTokens := func(clientID, clientSecret, scope string) (CreatedTokens, error) {
+		return s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeClientCredentials,
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			Scope:        scope,
+		}, "", "")
+	}
+
+	t.Run("issues an access token for the client", func(t *testing.T) {
+		tokens, err := createTokens(client.ID, secret, "")
+		require.NoError(t, err)
+		assert.Empty(t, tokens.IdToken)
+		assert.Empty(t, tokens.RefreshToken)
+		assert.Equal(t, "api:read api:write", tokens.Scope)
+
+		token, err := jwtService.VerifyOAuthAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		subject, _ := token.Subject()
+		assert.Equal(t, client.ID, subject)
+
+		// The audit log isn't linked to a user
+		var auditLogs []model.AuditLog
+		require.NoError(t, db.Preload("User").Where("event = ?", model.AuditLogEventClientCredentialsToken).Find(&auditLogs).Error)
+		require.Len(t, auditLogs, 1)
+		assert.Empty(t, auditLogs[0].UserID)
+		assert.Equal(t, client.ID, auditLogs[0].Data["clientId"])
+	})
+
+	t.Run("grants only the requested scopes", func(t *testing.T) {
+		tokens, err := createTokens(client.ID, secret, "api:read")
+		require.NoError(t, err)
+		assert.Equal(t, "api:read", tokens.Scope)
+
+		_, err = createTokens(client.ID, secret, "api:read admin")
+		var scopeErr *common.OidcInvalidScopeError
+		require.ErrorAs(t, err, &scopeErr)
+		assert.Equal(t, "admin", scopeErr.Scope)
+	})
+
+	t.Run("requires the client to authenticate", func(t *testing.T) {
+		_, err := createTokens(client.ID, "", "")
+		require.ErrorIs(t, err, &common.OidcMissingClientCredentialsError{})
+	})
+
+	t.Run("requires the grant type to be enabled", func(t *testing.T) {
+		other, otherSecret := createClient(t, nil)
+		_, err := createTokens(other.ID, otherSecret, "")
+		require.ErrorIs(t, err, &common.OidcUnauthorizedClientError{})
+	})
+
+	t.Run("rejects public clients", func(t *testing.T) {
+		_, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:       "Public",
+			IsPublic:   true,
+			GrantTypes: []string{GrantTypeClientCredentials},
+		}, user.ID)
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestOidcService_Audiences(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:               db,
+		jwtService:       jwtService,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createClient := func(t *testing.T, audiences []string) (model.OidcClient, string) {
+		t.Helper()
+		client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:       "Service",
+			GrantTypes: []string{GrantTypeClientCredentials},
+			Audiences:  audiences,
+		}, user.ID)
+		require.NoError(t, err)
+		secret, err := s.CreateClientSecret(t.Context(), client.ID)
+		require.NoError(t, err)
+		return client, secret
+	}
+	client, secret := createClient(t, []string{"https://api.example.com", "https://files.example.com"})
+
+	tokenAudiences := func(t *testing.T, input dto.OidcCreateTokensDto) []string {
+		t.Helper()
+		input.GrantType = GrantTypeClientCredentials
+		tokens, err := s.CreateTokens(t.Context(), input, "", "")
+		require.NoError(t, err)
+		token, err := jwtService.VerifyOAuthAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		audiences, _ := token.Audience()
+		return audiences
+	}
+
+	t.Run("includes all the audiences of the client by default", func(t *testing.T) {
+		audiences := tokenAudiences(t, dto.OidcCreateTokensDto{ClientID: client.ID, ClientSecret: secret})
+		assert.Equal(t, []string{client.ID, "https://api.example.com", "https://files.example.com"}, audiences)
+	})
+
+	t.Run("includes only the requested audiences", func(t *testing.T) {
+		audiences := tokenAudiences(t, dto.OidcCreateTokensDto{
+			ClientID:     client.ID,
+			ClientSecret: secret,
+			Resource:     []string{"https://files.example.com"},
+			Audience:     []string{client.ID, "https://files.example.com"},
+		})
+		assert.Equal(t, []string{client.ID, "https://files.example.com"}, audiences)
+	})
+
+	t.Run("rejects audiences that aren't allowed", func(t *testing.T) {
+		_, err := s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeClientCredentials,
+			ClientID:     client.ID,
+			ClientSecret: secret,
+			Audience:     []string{"https://other.example.com"},
+		}, "", "")
+		var targetErr *common.OidcInvalidTargetError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, "https://other.example.com", targetErr.Target)
+	})
+
+	t.Run("uses only the client ID without audiences", func(t *testing.T) {
+		other, otherSecret := createClient(t, nil)
+		audiences := tokenAudiences(t, dto.OidcCreateTokensDto{ClientID: other.ID, ClientSecret: otherSecret})
+		assert.Equal(t, []string{other.ID}, audiences)
+	})
+
+	t.Run("accepts tokens with multiple audiences in the introspection", func(t *testing.T) {
+		tokens, err := s.CreateTokens(t.Context(), dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeClientCredentials,
+			ClientID:     client.ID,
+			ClientSecret: secret,
+		}, "", "")
+		require.NoError(t, err)
+
+		introspection, err := s.introspectAccessToken(t.Context(), client.ID, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, introspection.Active)
+	})
+}
+
+func TestOidcService_ResourceIndicators(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:                 db,
+		jwtService:         jwtService,
+		appConfigService:   appConfig,
+		auditLogService:    &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+		customClaimService: NewCustomClaimService(db),
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+		Name:         "Client",
+		CallbackURLs: []string{"https://example.com/callback"},
+		GrantTypes:   []string{GrantTypeAuthorizationCode, GrantTypeRefreshToken},
+		Audiences:    []string{"https://api.example.com", "https://files.example.com", "https://mail.example.com"},
+	}, user.ID)
+	require.NoError(t, err)
+	clientSecret, err := s.CreateClientSecret(t.Context(), client.ID)
+	require.NoError(t, err)
+
+	authorize := func(t *testing.T, resources ...string) (string, string, error) {
+		t.Helper()
+		return s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid",
+			CallbackURL: "https://example.com/callback",
+			Resource:    resources,
+		}, user.ID, time.Now(), nil, "", "")
+	}
+	createTokens := func(t *testing.T, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+		t.Helper()
+		input.ClientID = client.ID
+		input.ClientSecret = clientSecret
+		return s.CreateTokens(t.Context(), input, "", "")
+	}
+	tokenAudiences := func(t *testing.T, accessToken string) []string {
+		t.Helper()
+		token, err := jwtService.VerifyOAuthAccessToken(accessToken)
+		require.NoError(t, err)
+		audiences, _ := token.Audience()
+		return audiences
+	}
+
+	t.Run("limits the tokens to the resources of the authorization request", func(t *testing.T) {
+		code, _, err := authorize(t, "https://api.example.com", "https://files.example.com")
+		require.NoError(t, err)
+
+		tokens, err := createTokens(t, dto.OidcCreateTokensDto{GrantType: GrantTypeAuthorizationCode, Code: code})
+		require.NoError(t, err)
+		assert.Equal(t, []string{client.ID, "https://api.example.com", "https://files.example.com"}, tokenAudiences(t, tokens.AccessToken))
+
+		// Refreshed tokens can be narrowed down further, but not extended
+		refreshed, err := createTokens(t, dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: tokens.RefreshToken,
+			Resource:     []string{"https://files.example.com"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{client.ID, "https://files.example.com"}, tokenAudiences(t, refreshed.AccessToken))
+
+		_, err = createTokens(t, dto.OidcCreateTokensDto{
+			GrantType:    GrantTypeRefreshToken,
+			RefreshToken: refreshed.RefreshToken,
+			Resource:     []string{"https://api.example.com", "https://mail.example.com"},
+		})
+		var targetErr *common.OidcInvalidTargetError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, "https://mail.example.com", targetErr.Target)
+	})
+
+	t.Run("accepts a subset of the resources in the token request", func(t *testing.T) {
+		code, _, err := authorize(t, "https://api.example.com", "https://files.example.com")
+		require.NoError(t, err)
+
+		tokens, err := createTokens(t, dto.OidcCreateTokensDto{
+			GrantType: GrantTypeAuthorizationCode,
+			Code:      code,
+			Resource:  []string{"https://api.example.com", client.ID},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{client.ID, "https://api.example.com"}, tokenAudiences(t, tokens.AccessToken))
+	})
+
+	t.Run("rejects resources that weren't requested in the authorization request", func(t *testing.T) {
+		code, _, err := authorize(t, "https://api.example.com")
+		require.NoError(t, err)
+
+		_, err = createTokens(t, dto.OidcCreateTokensDto{
+			GrantType: GrantTypeAuthorizationCode,
+			Code:      code,
+			Resource:  []string{"https://api.example.com", "https://files.example.com"},
+		})
+		var targetErr *common.OidcInvalidTargetError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, "https://files.example.com", targetErr.Target)
+	})
+
+	t.Run("limits the tokens to the client if only the client is requested", func(t *testing.T) {
+		code, _, err := authorize(t, client.ID)
+		require.NoError(t, err)
+
+		tokens, err := createTokens(t, dto.OidcCreateTokensDto{GrantType: GrantTypeAuthorizationCode, Code: code})
+		require.NoError(t, err)
+		assert.Equal(t, []string{client.ID}, tokenAudiences(t, tokens.AccessToken))
+	})
+
+	t.Run("rejects resources that aren't audiences of the client in the authorization request", func(t *testing.T) {
+		_, callbackURL, err := authorize(t, "https://api.example.com", "https://other.example.com")
+		var targetErr *common.OidcInvalidTargetError
+		require.ErrorAs(t, err, &targetErr)
+		assert.Equal(t, "https://other.example.com", targetErr.Target)
+		assert.Equal(t, "https://example.com/callback", callbackURL)
+	})
+
+	t.Run("checks the resources only after the user signed in", func(t *testing.T) {
+		_, callbackURL, err := s.Authorize(t.Context(), dto.AuthorizeOidcClientRequestDto{
+			ClientID:    client.ID,
+			Scope:       "openid",
+			CallbackURL: "https://example.com/callback",
+			Resource:    []string{"https://other.example.com"},
+		}, "", time.Now(), nil, "", "")
+		require.ErrorIs(t, err, &common.NotSignedInError{})
+		assert.Empty(t, callbackURL)
+	})
+}
+
+func TestOidcService_GetClientForLoginRedirect(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	s := &OidcService{db: db}
+
+	client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{Name: "Immich"}, "test-user-id")
+	require.NoError(t, err)
+	disabled := false
+	hiddenClient, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{Name: "Hidden", LoginBrandingEnabled: &disabled}, "test-user-id")
+	require.NoError(t, err)
+
+	t.Run("returns the client of the authorization", func(t *testing.T) {
+		result, err := s.GetClientForLoginRedirect(t.Context(), "/authorize?client_id="+client.ID+"&scope=openid")
+		require.NoError(t, err)
+		require.NotNil(t, result)
+		assert.Equal(t, "Immich", result.Name)
+	})
+
+	t.Run("ignores clients that disabled the branding", func(t *testing.T) {
+		result, err := s.GetClientForLoginRedirect(t.Context(), "/authorize?client_id="+hiddenClient.ID)
+		require.NoError(t, err)
+		assert.Nil(t, result)
+	})
+
+	t.Run("ignores redirects that aren't authorizations", func(t *testing.T) {
+		for _, redirect := range []string{
+			"",
+			"/settings?client_id=" + client.ID,
+			"https://evil.example.com/authorize?client_id=" + client.ID,
+			"//evil.example.com/authorize?client_id=" + client.ID,
+			"/authorize?client_id=unknown",
+		} {
+			result, err := s.GetClientForLoginRedirect(t.Context(), redirect)
+			require.NoError(t, err)
+			assert.Nil(t, result, redirect)
+		}
+	})
+}
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"math/rand/v2"
+	"strconv"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// auditTokenIssuance records the issuance of tokens to a client in the audit log, if enabled.
+// On busy instances, only a percentage of the issuances can be recorded. The tokens themselves are never recorded.
+func (s *OidcService) auditTokenIssuance(ctx context.Context, grantType string, client *model.OidcClient, userID, scope string, refreshTokenIssued bool, ipAddress, userAgent string, tx *gorm.DB) {
+	cfg := s.appConfigService.GetDbConfig()
+	if !cfg.TokenIssuanceAuditEnabled.IsTrue() {
+		return
+	}
+
+	sampleRate, err := strconv.Atoi(cfg.TokenIssuanceAuditSampleRate.Value)
+	if err != nil || sampleRate <= 0 || sampleRate > 100 {
+		sampleRate = 100
+	}
+	if sampleRate < 100 && rand.IntN(100) >= sampleRate { //nolint:gosec
+		return
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventTokenIssued, ipAddress, userAgent, userID, model.AuditLogData{
+		"clientName":   client.Name,
+		"clientId":     client.ID,
+		"grantType":    grantType,
+		"scope":        scope,
+		"refreshToken": strconv.FormatBool(refreshTokenIssued),
+		"sampleRate":   strconv.Itoa(sampleRate),
+	}, tx)
+}
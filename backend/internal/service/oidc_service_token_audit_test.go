@@ -0,0 +1,74 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_AuditTokenIssuance(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: user.ID}
+	require.NoError(t, db.Create(&client).Error)
+
+	newService := func(appConfig *model.AppConfig) *OidcService {
+		appConfigService := NewTestAppConfigService(appConfig)
+		return &OidcService{
+			db:               db,
+			appConfigService: appConfigService,
+			auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfigService},
+		}
+	}
+
+	countTokenIssuedLogs := func(t *testing.T) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, db.Model(&model.AuditLog{}).Where("event = ?", model.AuditLogEventTokenIssued).Count(&count).Error)
+		return count
+	}
+
+	t.Run("does nothing when disabled", func(t *testing.T) {
+		s := newService(&model.AppConfig{})
+		s.auditTokenIssuance(t.Context(), GrantTypeAuthorizationCode, &client, user.ID, "openid", true, "", "", db)
+		assert.Zero(t, countTokenIssuedLogs(t))
+	})
+
+	t.Run("records the issuance without the tokens", func(t *testing.T) {
+		s := newService(&model.AppConfig{
+			TokenIssuanceAuditEnabled:    model.AppConfigVariable{Value: "true"},
+			TokenIssuanceAuditSampleRate: model.AppConfigVariable{Value: "100"},
+		})
+		s.auditTokenIssuance(t.Context(), GrantTypeRefreshToken, &client, user.ID, "openid email", false, "", "", db)
+
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventTokenIssued).First(&auditLog).Error)
+		assert.Equal(t, user.ID, auditLog.UserID)
+		assert.Equal(t, model.AuditLogData{
+			"clientName":   "Client",
+			"clientId":     client.ID,
+			"grantType":    GrantTypeRefreshToken,
+			"scope":        "openid email",
+			"refreshToken": "false",
+			"sampleRate":   "100",
+		}, auditLog.Data)
+	})
+}
+
+func TestValidateTokenIssuanceAuditSampleRate(t *testing.T) {
+	require.NoError(t, validateTokenIssuanceAuditSampleRate(""))
+	require.NoError(t, validateTokenIssuanceAuditSampleRate("1"))
+	require.NoError(t, validateTokenIssuanceAuditSampleRate("100"))
+
+	var validationErr *common.ValidationError
+	require.ErrorAs(t, validateTokenIssuanceAuditSampleRate("0"), &validationErr)
+	require.ErrorAs(t, validateTokenIssuanceAuditSampleRate("101"), &validationErr)
+	require.ErrorAs(t, validateTokenIssuanceAuditSampleRate("abc"), &validationErr)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// Token type identifiers of the token exchange grant (RFC 8693, section 3)
+const (
+	TokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token" //nolint:gosec
+	TokenTypeJWT         = "urn:ietf:params:oauth:token-type:jwt"          //nolint:gosec
+)
+
+// createTokenFromTokenExchange exchanges an access token issued by Pocket ID for a new one (RFC 8693).
+// Only confidential clients with the token exchange grant type enabled can use it, and they must be an audience of the subject token,
+// so a token can only be exchanged by the services it was issued for. The audiences of the new token must be in the audiences of the client.
+// With an actor token, the new token records the delegation in its "act" claim; without one, the client impersonates the subject.
+func (s *OidcService) createTokenFromTokenExchange(ctx context.Context, input dto.OidcCreateTokensDto, ipAddress, userAgent string) (CreatedTokens, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	client, err := s.verifyClientCredentialsInternal(ctx, tx, clientAuthCredentialsFromCreateTokensDto(&input), false)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	if client.IsPublic || !clientAllowsGrantType(client, GrantTypeTokenExchange) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
+
+	if input.RequestedTokenType != "" && input.RequestedTokenType != TokenTypeAccessToken {
+		return CreatedTokens{}, &common.OidcInvalidTokenExchangeError{Reason: "only access tokens can be requested"}
+	}
+
+	subjectToken, err := s.verifyExchangedToken(ctx, input.SubjectToken, input.SubjectTokenType, "subject", tx)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	subjectExchange, err := s.verifyTokenExchangeChain(ctx, subjectToken, tx)
+	if err != nil {
+		return CreatedTokens{}, &common.OidcInvalidTokenExchangeError{Reason: "the delegation chain of the subject token is unknown"}
+	}
+	subjectAudiences, _ := subjectToken.Audience()
+	if !slices.Contains(subjectAudiences, client.ID) {
+		return CreatedTokens{}, &common.OidcInvalidTokenExchangeError{Reason: "the subject token wasn't issued for this client"}
+	}
+
+	// Tokens issued with the client_credentials grant have the client as subject, which can't be exchanged.
+	// Pairwise subjects are resolved through the client that the subject token was issued to.
+	subject, _ := subjectToken.Subject()
+	subjectID, err := s.resolveSubjectUserID(ctx, subjectAudiences[0], subject, tx)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	var user model.User
+	err = tx.
+		WithContext(ctx).
+		Preload("UserGroups").
+		Where("id = ?", subjectID).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return CreatedTokens{}, &common.OidcInvalidTokenExchangeError{Reason: "the subject of the token must be a user"}
+	} else if err != nil {
+		return CreatedTokens{}, err
+	}
+	if user.Disabled {
+		return CreatedTokens{}, &common.UserDisabledError{}
+	}
+	err = s.appConfigService.CheckMaintenanceMode(&user)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	// Being an audience of the subject token doesn't allow a client to get tokens for users it's restricted from
+	err = tx.
+		WithContext(ctx).
+		Model(client).
+		Association("AllowedUserGroups").
+		Find(&client.AllowedUserGroups)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+	if !s.IsUserGroupAllowedToAuthorize(user, *client) {
+		return CreatedTokens{}, &common.OidcUnauthorizedClientError{}
+	}
+
+	audiences, err := resolveAccessTokenAudiences(client, &input, nil)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	actor, actorID, err := s.tokenExchangeActor(ctx, subjectToken, input, tx)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	// The delegation chain is stored, so later exchanges and introspection can check the "act" claim of the token
+	exchange := model.OidcTokenExchange{
+		ClientID:  client.ID,
+		UserID:    user.ID,
+		ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+	}
+	if actorSubject, ok := actor["sub"].(string); ok {
+		exchange.ActorID = actorSubject
+	}
+	if subjectExchange != nil {
+		exchange.SubjectTokenID = &subjectExchange.ID
+	}
+	err = tx.
+		WithContext(ctx).
+		Create(&exchange).
+		Error
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	accessToken, err := s.jwtService.GenerateExchangedOAuthAccessToken(s.clientSubject(client, user.ID), client.ID, audiences, actor, exchange.ID)
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	auditLogData := model.AuditLogData{
+		"clientName":    client.Name,
+		"clientId":      client.ID,
+		"subjectClient": subjectAudiences[0],
+	}
+	if actorID != "" {
+		auditLogData["actor"] = actorID
+	}
+	if len(audiences) > 0 {
+		auditLogData["audiences"] = strings.Join(audiences, " ")
+	}
+	s.auditLogService.Create(ctx, model.AuditLogEventTokenExchange, ipAddress, userAgent, user.ID, auditLogData, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return CreatedTokens{}, err
+	}
+
+	return CreatedTokens{
+		AccessToken:     accessToken,
+		ExpiresIn:       time.Hour,
+		IssuedTokenType: TokenTypeAccessToken,
+	}, nil
+}
+
+// verifyExchangedToken verifies a subject or actor token of a token exchange request.
+// Only access tokens issued by Pocket ID are accepted.
+func (s *OidcService) verifyExchangedToken(ctx context.Context, tokenString, tokenType, name string, tx *gorm.DB) (jwt.Token, error) {
+	if tokenString == "" {
+		return nil, &common.OidcInvalidTokenExchangeError{Reason: "the " + name + " token is required"}
+	}
+	if tokenType != TokenTypeAccessToken && tokenType != TokenTypeJWT {
+		return nil, &common.OidcInvalidTokenExchangeError{Reason: "the " + name + " token must be an access token"}
+	}
+
+	token, err := s.verifyOAuthAccessTokenInternal(ctx, tokenString, tx)
+	if err != nil {
+		return nil, &common.OidcInvalidTokenExchangeError{Reason: "the " + name + " token is invalid or expired"}
+	}
+	return token, nil
+}
+
+// verifyTokenExchangeChain checks the "act" claim of an access token against the stored exchange that issued it.
+// It returns the stored exchange, or nil if the token has no actor and thus needs no check.
+func (s *OidcService) verifyTokenExchangeChain(ctx context.Context, token jwt.Token, tx *gorm.DB) (*model.OidcTokenExchange, error) {
+	if !token.Has(ActorClaim) {
+		return nil, nil
+	}
+
+	tokenID, ok := token.JwtID()
+	if !ok || tokenID == "" {
+		return nil, errors.New("token with an actor has no ID")
+	}
+
+	var exchange model.OidcTokenExchange
+	err := tx.
+		WithContext(ctx).
+		First(&exchange, "id = ?", tokenID).
+		Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the exchange of the token: %w", err)
+	}
+
+	var actor map[string]any
+	err = token.Get(ActorClaim, &actor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid 'act' claim: %w", err)
+	}
+	audiences, _ := token.Audience()
+	if len(audiences) == 0 || audiences[0] != exchange.ClientID || actor["sub"] != exchange.ActorID {
+		return nil, errors.New("the token doesn't match its exchange")
+	}
+
+	return &exchange, nil
+}
+
+// tokenExchangeActor returns the "act" claim of the exchanged token and the ID of the actor, which are empty if no actor token is provided.
+// If the subject token was itself issued by a token exchange, its actor is nested in the new claim, so the whole delegation chain is kept.
+// If the subject token has a "may_act" claim, only the party it names can act on behalf of the subject.
+func (s *OidcService) tokenExchangeActor(ctx context.Context, subjectToken jwt.Token, input dto.OidcCreateTokensDto, tx *gorm.DB) (map[string]any, string, error) {
+	var previousActor map[string]any
+	if subjectToken.Has(ActorClaim) {
+		err := subjectToken.Get(ActorClaim, &previousActor)
+		if err != nil {
+			return nil, "", &common.OidcInvalidTokenExchangeError{Reason: "the 'act' claim of the subject token is invalid"}
+		}
+	}
+
+	if input.ActorToken == "" {
+		// The client impersonates the subject, so the existing delegation chain is kept as it is
+		return previousActor, "", nil
+	}
+
+	actorToken, err := s.verifyExchangedToken(ctx, input.ActorToken, input.ActorTokenType, "actor", tx)
+	if err != nil {
+		return nil, "", err
+	}
+	actorID, ok := actorToken.Subject()
+	if !ok || actorID == "" {
+		return nil, "", &common.OidcInvalidTokenExchangeError{Reason: "the actor token has no subject"}
+	}
+
+	if subjectToken.Has(MayActClaim) {
+		var mayAct map[string]any
+		err = subjectToken.Get(MayActClaim, &mayAct)
+		if err != nil || mayAct["sub"] != actorID {
+			return nil, "", &common.OidcInvalidTokenExchangeError{Reason: "the actor isn't allowed to act on behalf of the subject"}
+		}
+	}
+
+	actor := map[string]any{"sub": actorID}
+	if previousActor != nil {
+		actor[ActorClaim] = previousActor
+	}
+	return actor, actorID, nil
+}
@@ -0,0 +1,208 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOidcService_TokenExchange(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfig, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	s := &OidcService{
+		db:               db,
+		jwtService:       jwtService,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createClient := func(t *testing.T, grantTypes []string, audiences []string) (model.OidcClient, string) {
+		t.Helper()
+		client, err := s.CreateClient(t.Context(), dto.OidcClientCreateDto{
+			Name:       "Service",
+			GrantTypes: grantTypes,
+			Audiences:  audiences,
+		}, user.ID)
+		require.NoError(t, err)
+		secret, err := s.CreateClientSecret(t.Context(), client.ID)
+		require.NoError(t, err)
+		return client, secret
+	}
+	frontend, frontendSecret := createClient(t, []string{GrantTypeAuthorizationCode, GrantTypeClientCredentials}, nil)
+	api, apiSecret := createClient(t, []string{GrantTypeTokenExchange, GrantTypeClientCredentials}, []string{"https://files.example.com"})
+
+	exchange := func(clientID, clientSecret string, input dto.OidcCreateTokensDto) (CreatedTokens, error) {
+		input.GrantType = GrantTypeTokenExchange
+		input.ClientID = clientID
+		input.ClientSecret = clientSecret
+		if input.SubjectTokenType == "" {
+			input.SubjectTokenType = TokenTypeAccessToken
+		}
+		return s.CreateTokens(t.Context(), input, "", "")
+	}
+
+	// The user signed in to the frontend, which forwards the token to the API
+	userToken, err := jwtService.GenerateOAuthAccessToken(user.ID, frontend.ID, []string{api.ID})
+	require.NoError(t, err)
+
+	t.Run("exchanges a token for a downstream audience", func(t *testing.T) {
+		tokens, err := exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{
+			SubjectToken: userToken,
+			Audience:     []string{"https://files.example.com"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, TokenTypeAccessToken, tokens.IssuedTokenType)
+		assert.Empty(t, tokens.RefreshToken)
+
+		token, err := jwtService.VerifyOAuthAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		subject, _ := token.Subject()
+		assert.Equal(t, user.ID, subject)
+		audiences, _ := token.Audience()
+		assert.Equal(t, []string{api.ID, "https://files.example.com"}, audiences)
+		assert.False(t, token.Has(ActorClaim))
+	})
+
+	t.Run("records the actor and keeps the delegation chain", func(t *testing.T) {
+		actorToken, err := jwtService.GenerateClientCredentialsAccessToken(api.ID, "", nil)
+		require.NoError(t, err)
+
+		tokens, err := exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{
+			SubjectToken:   userToken,
+			ActorToken:     actorToken,
+			ActorTokenType: TokenTypeAccessToken,
+		})
+		require.NoError(t, err)
+
+		token, err := jwtService.VerifyOAuthAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		var actor map[string]any
+		require.NoError(t, token.Get(ActorClaim, &actor))
+		assert.Equal(t, map[string]any{"sub": api.ID}, actor)
+
+		// Exchanging the delegated token again nests the previous actor
+		tokens, err = exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{
+			SubjectToken:   tokens.AccessToken,
+			ActorToken:     actorToken,
+			ActorTokenType: TokenTypeAccessToken,
+		})
+		require.NoError(t, err)
+
+		introspection, err := s.IntrospectToken(t.Context(), ClientAuthCredentials{ClientID: api.ID, ClientSecret: apiSecret}, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.True(t, introspection.Active)
+		assert.Equal(t, map[string]any{"sub": api.ID, "act": map[string]any{"sub": api.ID}}, introspection.Actor)
+
+		var auditLogs []model.AuditLog
+		require.NoError(t, db.Where("event = ? AND user_id = ?", model.AuditLogEventTokenExchange, user.ID).Find(&auditLogs).Error)
+		require.NotEmpty(t, auditLogs)
+		assert.Equal(t, api.ID, auditLogs[len(auditLogs)-1].Data["actor"])
+	})
+
+	t.Run("stores the delegation chain and rejects unknown chains", func(t *testing.T) {
+		actorToken, err := jwtService.GenerateClientCredentialsAccessToken(api.ID, "", nil)
+		require.NoError(t, err)
+
+		tokens, err := exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{
+			SubjectToken:   userToken,
+			ActorToken:     actorToken,
+			ActorTokenType: TokenTypeAccessToken,
+		})
+		require.NoError(t, err)
+
+		token, err := jwtService.VerifyOAuthAccessToken(tokens.AccessToken)
+		require.NoError(t, err)
+		tokenID, ok := token.JwtID()
+		require.True(t, ok)
+
+		var stored model.OidcTokenExchange
+		require.NoError(t, db.First(&stored, "id = ?", tokenID).Error)
+		assert.Equal(t, api.ID, stored.ClientID)
+		assert.Equal(t, user.ID, stored.UserID)
+		assert.Equal(t, api.ID, stored.ActorID)
+		assert.Nil(t, stored.SubjectTokenID)
+
+		// A token whose chain wasn't recorded, e.g. because the record was deleted, is neither exchanged nor active
+		require.NoError(t, db.Delete(&stored).Error)
+
+		_, err = exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{SubjectToken: tokens.AccessToken})
+		var exchangeErr *common.OidcInvalidTokenExchangeError
+		require.ErrorAs(t, err, &exchangeErr)
+
+		introspection, err := s.IntrospectToken(t.Context(), ClientAuthCredentials{ClientID: api.ID, ClientSecret: apiSecret}, tokens.AccessToken)
+		require.NoError(t, err)
+		assert.False(t, introspection.Active)
+	})
+
+	t.Run("rejects users the client isn't allowed for", func(t *testing.T) {
+		group := model.UserGroup{Name: "admins", FriendlyName: "Admins"}
+		require.NoError(t, db.Create(&group).Error)
+		_, err := s.UpdateAllowedUserGroups(t.Context(), api.ID, dto.OidcUpdateAllowedUserGroupsDto{UserGroupIDs: []string{group.ID}})
+		require.NoError(t, err)
+		defer func() {
+			_, err := s.UpdateAllowedUserGroups(t.Context(), api.ID, dto.OidcUpdateAllowedUserGroupsDto{})
+			require.NoError(t, err)
+		}()
+
+		_, err = exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{SubjectToken: userToken})
+		var unauthorizedErr *common.OidcUnauthorizedClientError
+		require.ErrorAs(t, err, &unauthorizedErr)
+	})
+
+	t.Run("rejects clients without the grant type", func(t *testing.T) {
+		_, err := exchange(frontend.ID, frontendSecret, dto.OidcCreateTokensDto{SubjectToken: userToken})
+		require.ErrorIs(t, err, &common.OidcUnauthorizedClientError{})
+	})
+
+	t.Run("rejects tokens that weren't issued for the client", func(t *testing.T) {
+		otherToken, err := jwtService.GenerateOAuthAccessToken(user.ID, frontend.ID, nil)
+		require.NoError(t, err)
+
+		_, err = exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{SubjectToken: otherToken})
+		var exchangeErr *common.OidcInvalidTokenExchangeError
+		require.ErrorAs(t, err, &exchangeErr)
+	})
+
+	t.Run("rejects audiences of other clients", func(t *testing.T) {
+		_, err := exchange(api.ID, apiSecret, dto.OidcCreateTokensDto{
+			SubjectToken: userToken,
+			Audience:     []string{"https://admin.example.com"},
+		})
+		var targetErr *common.OidcInvalidTargetError
+		require.ErrorAs(t, err, &targetErr)
+	})
+
+	t.Run("rejects invalid subject tokens", func(t *testing.T) {
+		clientToken, err := jwtService.GenerateClientCredentialsAccessToken(frontend.ID, "", []string{api.ID})
+		require.NoError(t, err)
+
+		for _, input := range []dto.OidcCreateTokensDto{
+			{SubjectToken: "not-a-token"},
+			{SubjectToken: userToken, SubjectTokenType: "urn:ietf:params:oauth:token-type:id_token"},
+			{SubjectToken: userToken, RequestedTokenType: "urn:ietf:params:oauth:token-type:refresh_token"},
+			// Tokens of clients have no user as subject
+			{SubjectToken: clientToken},
+		} {
+			_, err := exchange(api.ID, apiSecret, input)
+			var exchangeErr *common.OidcInvalidTokenExchangeError
+			require.ErrorAs(t, err, &exchangeErr)
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+const (
+	// Interval at which the outbox is polled for messages to deliver
+	outboxPollInterval = 5 * time.Second
+	// Number of messages that are delivered in each batch
+	outboxBatchSize = 50
+	// How long a message is locked by the worker that is delivering it
+	outboxLockDuration = 2 * time.Minute
+	// After this number of failed attempts, messages are not retried anymore
+	outboxMaxAttempts = 10
+
+	// OutboxMessageRetention is how long messages are kept before they're deleted, even if they couldn't be delivered.
+	// Payloads can contain secrets like one-time codes, and all retries are done long before.
+	OutboxMessageRetention = 24 * time.Hour
+)
+
+// OutboxHandler delivers a message of the outbox; the payload is the JSON-encoded value passed to Enqueue
+type OutboxHandler func(ctx context.Context, payload []byte) error
+
+// OutboxService implements a transactional outbox.
+// Side effects like notification emails are stored in the same transaction as the change that caused them,
+// so they are only delivered if the change is committed, and they are not lost if the process stops before delivering them.
+type OutboxService struct {
+	db *gorm.DB
+
+	handlersLock sync.RWMutex
+	handlers     map[string]OutboxHandler
+
+	// Wakes up the worker when a new message is enqueued
+	wakeup chan struct{}
+}
+
+func NewOutboxService(db *gorm.DB) *OutboxService {
+	return &OutboxService{
+		db:       db,
+		handlers: make(map[string]OutboxHandler),
+		wakeup:   make(chan struct{}, 1),
+	}
+}
+
+// RegisterHandler registers the function that delivers messages of the given type
+func (s *OutboxService) RegisterHandler(messageType string, handler OutboxHandler) {
+	s.handlersLock.Lock()
+	defer s.handlersLock.Unlock()
+
+	s.handlers[messageType] = handler
+}
+
+// Enqueue stores a message in the outbox using the given transaction.
+// The message is delivered by the worker after the transaction has been committed.
+func (s *OutboxService) Enqueue(ctx context.Context, messageType string, payload any, tx *gorm.DB) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox message payload: %w", err)
+	}
+
+	message := model.OutboxMessage{
+		Type:        messageType,
+		Payload:     string(data),
+		AvailableAt: datatype.DateTime(time.Now()),
+	}
+	if correlationID := utils.CorrelationIDFromContext(ctx); correlationID != "" {
+		message.CorrelationID = &correlationID
+	}
+	err = tx.
+		WithContext(ctx).
+		Create(&message).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to store outbox message: %w", err)
+	}
+
+	// Wake up the worker without blocking; if the transaction isn't committed yet, the message is picked up with the next poll
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// Run delivers the messages in the outbox until the context is canceled
+func (s *OutboxService) Run(ctx context.Context) error {
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := s.ProcessPending(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.ErrorContext(ctx, "Failed to process outbox messages", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		case <-s.wakeup:
+			// Give the transaction that enqueued the message a moment to commit
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+}
+
+// ProcessPending delivers the messages that are due and returns the number of messages that were delivered
+func (s *OutboxService) ProcessPending(ctx context.Context) (int, error) {
+	now := time.Now()
+
+	var messages []model.OutboxMessage
+	err := s.db.
+		WithContext(ctx).
+		Where("available_at <= ? AND attempts < ?", datatype.DateTime(now), outboxMaxAttempts).
+		Where("locked_until IS NULL OR locked_until < ?", datatype.DateTime(now)).
+		Order("created_at ASC").
+		Limit(outboxBatchSize).
+		Find(&messages).
+		Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to load outbox messages: %w", err)
+	}
+
+	delivered := 0
+	for _, message := range messages {
+		if ctx.Err() != nil {
+			return delivered, ctx.Err()
+		}
+
+		ok, err := s.deliver(ctx, message)
+		if err != nil {
+			return delivered, err
+		}
+		if ok {
+			delivered++
+		}
+	}
+
+	return delivered, nil
+}
+
+// deliver claims the message, so other replicas don't deliver it too, then invokes its handler.
+// It returns true if the message was delivered.
+func (s *OutboxService) deliver(ctx context.Context, message model.OutboxMessage) (bool, error) {
+	now := time.Now()
+	lockedUntil := datatype.DateTime(now.Add(outboxLockDuration))
+
+	res := s.db.
+		WithContext(ctx).
+		Model(&model.OutboxMessage{}).
+		Where("id = ? AND attempts = ?", message.ID, message.Attempts).
+		Where("locked_until IS NULL OR locked_until < ?", datatype.DateTime(now)).
+		Updates(map[string]any{
+			"locked_until": lockedUntil,
+			"attempts":     message.Attempts + 1,
+		})
+	if res.Error != nil {
+		return false, fmt.Errorf("failed to lock outbox message: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		// Another worker claimed the message
+		return false, nil
+	}
+	message.Attempts++
+
+	s.handlersLock.RLock()
+	handler, ok := s.handlers[message.Type]
+	s.handlersLock.RUnlock()
+
+	// Deliver the message with the correlation ID of the request that enqueued it
+	if message.CorrelationID != nil {
+		ctx = utils.WithCorrelationID(ctx, *message.CorrelationID)
+	}
+
+	var handlerErr error
+	if ok {
+		handlerErr = handler(ctx, []byte(message.Payload))
+	} else {
+		handlerErr = fmt.Errorf("no handler registered for outbox messages of type '%s'", message.Type)
+	}
+
+	if handlerErr == nil {
+		err := s.db.
+			WithContext(ctx).
+			Delete(&model.OutboxMessage{}, "id = ?", message.ID).
+			Error
+		if err != nil {
+			return false, fmt.Errorf("failed to delete delivered outbox message: %w", err)
+		}
+		return true, nil
+	}
+
+	slog.WarnContext(ctx, "Failed to deliver outbox message",
+		slog.String("id", message.ID),
+		slog.String("type", message.Type),
+		slog.Int("attempts", message.Attempts),
+		slog.Any("error", handlerErr),
+	)
+
+	// Retry with an exponential backoff
+	lastError := handlerErr.Error()
+	backoff := time.Duration(1<<min(message.Attempts, 12)) * time.Second
+	err := s.db.
+		WithContext(ctx).
+		Model(&model.OutboxMessage{}).
+		Where("id = ?", message.ID).
+		Updates(map[string]any{
+			"locked_until": nil,
+			"available_at": datatype.DateTime(time.Now().Add(backoff)),
+			"last_error":   lastError,
+		}).
+		Error
+	if err != nil {
+		return false, fmt.Errorf("failed to update outbox message: %w", err)
+	}
+
+	return false, nil
+}
+
+// outboxHandlerFor returns an OutboxHandler that decodes the payload into a value of type T
+func outboxHandlerFor[T any](fn func(ctx context.Context, payload T) error) OutboxHandler {
+	return func(ctx context.Context, data []byte) error {
+		var payload T
+		err := json.Unmarshal(data, &payload)
+		if err != nil {
+			return fmt.Errorf("failed to decode outbox message payload: %w", err)
+		}
+		return fn(ctx, payload)
+	}
+}
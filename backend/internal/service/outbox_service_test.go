@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOutboxService(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := NewOutboxService(db)
+
+	type payload struct {
+		Value string `json:"value"`
+	}
+
+	var delivered []string
+	failNext := false
+	service.RegisterHandler("test", outboxHandlerFor(func(ctx context.Context, p payload) error {
+		if failNext {
+			failNext = false
+			return errors.New("delivery failed")
+		}
+		delivered = append(delivered, p.Value)
+		return nil
+	}))
+
+	t.Run("messages of rolled back transactions are not delivered", func(t *testing.T) {
+		delivered = nil
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, service.Enqueue(t.Context(), "test", payload{Value: "rolled back"}, tx))
+			return errors.New("rollback")
+		})
+		require.Error(t, err)
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return service.Enqueue(t.Context(), "test", payload{Value: "committed"}, tx)
+		})
+		require.NoError(t, err)
+
+		count, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Equal(t, []string{"committed"}, delivered)
+
+		// Delivered messages are removed from the outbox
+		var remaining int64
+		require.NoError(t, db.Model(&model.OutboxMessage{}).Count(&remaining).Error)
+		assert.Zero(t, remaining)
+	})
+
+	t.Run("failed messages are retried later", func(t *testing.T) {
+		delivered = nil
+		failNext = true
+
+		require.NoError(t, service.Enqueue(t.Context(), "test", payload{Value: "retried"}, db))
+
+		count, err := service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+		assert.Empty(t, delivered)
+
+		var message model.OutboxMessage
+		require.NoError(t, db.First(&message).Error)
+		assert.Equal(t, 1, message.Attempts)
+		require.NotNil(t, message.LastError)
+		assert.Equal(t, "delivery failed", *message.LastError)
+
+		// The message is not due yet
+		count, err = service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Zero(t, count)
+
+		// Make the message due and process it again
+		require.NoError(t, db.Model(&model.OutboxMessage{}).Where("id = ?", message.ID).Update("available_at", message.CreatedAt).Error)
+		count, err = service.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		assert.Equal(t, []string{"retried"}, delivered)
+	})
+}
@@ -3,6 +3,10 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
 
 	"gorm.io/gorm"
 
@@ -12,13 +16,39 @@ import (
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
+const (
+	maxGroupNameLength            = 255
+	maxGeneratedGroupNameAttempts = 20
+)
+
+// groupNameSlugRegex matches lowercase slugs like "my-group"
+var groupNameSlugRegex = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
 type UserGroupService struct {
-	db               *gorm.DB
-	appConfigService *AppConfigService
+	db                  *gorm.DB
+	appConfigService    *AppConfigService
+	auditLogService     *AuditLogService
+	importReportService *ImportReportService
+}
+
+func NewUserGroupService(db *gorm.DB, appConfigService *AppConfigService, auditLogService *AuditLogService, importReportService *ImportReportService) *UserGroupService {
+	return &UserGroupService{db: db, appConfigService: appConfigService, auditLogService: auditLogService, importReportService: importReportService}
 }
 
-func NewUserGroupService(db *gorm.DB, appConfigService *AppConfigService) *UserGroupService {
-	return &UserGroupService{db: db, appConfigService: appConfigService}
+// BulkAssignStatus is the outcome of a bulk assignment for a single user
+type BulkAssignStatus string
+
+const (
+	BulkAssignStatusAdded         BulkAssignStatus = "added"
+	BulkAssignStatusRemoved       BulkAssignStatus = "removed"
+	BulkAssignStatusAlreadyMember BulkAssignStatus = "alreadyMember"
+	BulkAssignStatusNotMember     BulkAssignStatus = "notMember"
+	BulkAssignStatusUserNotFound  BulkAssignStatus = "userNotFound"
+)
+
+type BulkAssignResult struct {
+	UserID string
+	Status BulkAssignStatus
 }
 
 func (s *UserGroupService) List(ctx context.Context, name string, sortedPaginationRequest utils.SortedPaginationRequest) (groups []model.UserGroup, response utils.PaginationResponse, err error) {
@@ -28,7 +58,9 @@ func (s *UserGroupService) List(ctx context.Context, name string, sortedPaginati
 		Model(&model.UserGroup{})
 
 	if name != "" {
-		query = query.Where("name LIKE ?", "%"+name+"%")
+		name = utils.NormalizeUnicode(name, s.appConfigService.GetDbConfig().UnicodeNormalizationForm.Value)
+		searchQuery, searchArgs := utils.CaseInsensitiveSearch(name, "name")
+		query = query.Where(searchQuery, searchArgs...)
 	}
 
 	// As userCount is not a column we need to manually sort it
@@ -99,6 +131,16 @@ func (s *UserGroupService) Create(ctx context.Context, input dto.UserGroupCreate
 }
 
 func (s *UserGroupService) createInternal(ctx context.Context, input dto.UserGroupCreateDto, tx *gorm.DB) (group model.UserGroup, err error) {
+	s.normalizeNames(&input)
+
+	// Groups synced from LDAP keep the names of the directory
+	if input.LdapID == "" {
+		err = s.prepareNames(ctx, &input, nil, tx)
+		if err != nil {
+			return model.UserGroup{}, err
+		}
+	}
+
 	group = model.UserGroup{
 		FriendlyName: input.FriendlyName,
 		Name:         input.Name,
@@ -152,6 +194,15 @@ func (s *UserGroupService) updateInternal(ctx context.Context, id string, input
 		return model.UserGroup{}, &common.LdapUserGroupUpdateError{}
 	}
 
+	s.normalizeNames(&input)
+
+	if !isLdapSync {
+		err = s.prepareNames(ctx, &input, &group, tx)
+		if err != nil {
+			return model.UserGroup{}, err
+		}
+	}
+
 	group.Name = input.Name
 	group.FriendlyName = input.FriendlyName
 
@@ -228,6 +279,224 @@ func (s *UserGroupService) updateUsersInternal(ctx context.Context, id string, u
 	return group, nil
 }
 
+// BulkAssign adds the users to the group if add is true, or removes them from it otherwise.
+// Users that are already members, or aren't members when removing them, are skipped.
+func (s *UserGroupService) BulkAssign(ctx context.Context, groupID string, userIDs []string, add bool, actorUserID, ipAddress, userAgent string) ([]BulkAssignResult, error) {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var group model.UserGroup
+	err := tx.
+		WithContext(ctx).
+		Where("id = ?", groupID).
+		First(&group).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	// Disallow updating the group if it is an LDAP group and LDAP is enabled
+	if group.LdapID != nil && s.appConfigService.GetDbConfig().LdapEnabled.IsTrue() {
+		return nil, &common.LdapUserGroupUpdateError{}
+	}
+
+	var users []model.User
+	err = tx.
+		WithContext(ctx).
+		Where("id IN ?", userIDs).
+		Find(&users).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	var memberIDs []string
+	err = tx.
+		WithContext(ctx).
+		Table("user_groups_users").
+		Where("user_group_id = ? AND user_id IN ?", groupID, userIDs).
+		Pluck("user_id", &memberIDs).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	usersByID := make(map[string]model.User, len(users))
+	for _, user := range users {
+		usersByID[user.ID] = user
+	}
+
+	results := make([]BulkAssignResult, 0, len(userIDs))
+	changedUsers := make([]model.User, 0, len(users))
+	seen := make(map[string]struct{}, len(userIDs))
+	for _, userID := range userIDs {
+		if _, ok := seen[userID]; ok {
+			continue
+		}
+		seen[userID] = struct{}{}
+
+		user, ok := usersByID[userID]
+		isMember := slices.Contains(memberIDs, userID)
+		var status BulkAssignStatus
+		switch {
+		case !ok:
+			status = BulkAssignStatusUserNotFound
+		case add && isMember:
+			status = BulkAssignStatusAlreadyMember
+		case add:
+			status = BulkAssignStatusAdded
+			changedUsers = append(changedUsers, user)
+		case !isMember:
+			status = BulkAssignStatusNotMember
+		default:
+			status = BulkAssignStatusRemoved
+			changedUsers = append(changedUsers, user)
+		}
+		results = append(results, BulkAssignResult{UserID: userID, Status: status})
+	}
+
+	if len(changedUsers) > 0 {
+		association := tx.
+			WithContext(ctx).
+			Model(&group).
+			Association("Users")
+		if add {
+			err = association.Append(changedUsers)
+		} else {
+			err = association.Delete(changedUsers)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	action := "remove"
+	if add {
+		action = "add"
+	}
+	s.auditLogService.Create(ctx, model.AuditLogEventUserGroupBulkAssignment, ipAddress, userAgent, actorUserID, model.AuditLogData{
+		"groupName": group.Name,
+		"action":    action,
+		"count":     strconv.Itoa(len(changedUsers)),
+	}, tx)
+
+	err = tx.Commit().Error
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// prepareNames generates the name of the group from the friendly name if it's empty, and checks the names against the configured rules.
+// group is the group being updated, or nil when creating a group.
+func (s *UserGroupService) prepareNames(ctx context.Context, input *dto.UserGroupCreateDto, group *model.UserGroup, tx *gorm.DB) error {
+	dbConfig := s.appConfigService.GetDbConfig()
+
+	var groupID string
+	nameChanged := true
+	if group != nil {
+		groupID = group.ID
+		if input.Name == "" {
+			input.Name = group.Name
+		}
+		nameChanged = input.Name != group.Name
+	}
+
+	if input.Name == "" {
+		name, err := s.generateName(ctx, input.FriendlyName, tx)
+		if err != nil {
+			return err
+		}
+		input.Name = name
+	} else if nameChanged && dbConfig.UserGroupNameSlugEnforced.IsTrue() && !groupNameSlugRegex.MatchString(input.Name) {
+		// Existing names are accepted as they are, so enforcing the format doesn't prevent updating old groups
+		return &common.ValidationError{Message: "The name of the group must only contain lowercase letters, numbers and hyphens"}
+	}
+
+	return s.checkDuplicatedFields(ctx, *input, groupID, dbConfig.UserGroupFriendlyNameUnique.IsTrue(), tx)
+}
+
+// normalizeNames applies the configured Unicode normalization form to the names of the group,
+// so that names that only differ by the composition of their characters are stored the same way
+func (s *UserGroupService) normalizeNames(input *dto.UserGroupCreateDto) {
+	form := s.appConfigService.GetDbConfig().UnicodeNormalizationForm.Value
+	input.Name = utils.NormalizeUnicode(input.Name, form)
+	input.FriendlyName = utils.NormalizeUnicode(input.FriendlyName, form)
+}
+
+// generateName derives a unique name from the friendly name.
+// If the name is already taken, a numeric suffix is appended.
+func (s *UserGroupService) generateName(ctx context.Context, friendlyName string, tx *gorm.DB) (string, error) {
+	// Leave room for the numeric suffix
+	base := utils.Slugify(friendlyName, maxGroupNameLength-len(strconv.Itoa(maxGeneratedGroupNameAttempts))-1)
+	if len(base) < 2 {
+		return "", &common.ValidationError{Message: "A name can't be generated from the friendly name, please enter a name"}
+	}
+
+	for i := 1; i <= maxGeneratedGroupNameAttempts; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = base + "-" + strconv.Itoa(i)
+		}
+
+		var count int64
+		err := tx.
+			WithContext(ctx).
+			Model(&model.UserGroup{}).
+			Where("name = ?", candidate).
+			Count(&count).
+			Error
+		if err != nil {
+			return "", fmt.Errorf("failed to check if group name '%s' is in use: %w", candidate, err)
+		}
+
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", &common.AlreadyInUseError{Property: "name"}
+}
+
+func (s *UserGroupService) checkDuplicatedFields(ctx context.Context, input dto.UserGroupCreateDto, groupID string, friendlyNameUnique bool, tx *gorm.DB) error {
+	// Compare the normalized names, so that a different composition can't be used to bypass the uniqueness
+	s.normalizeNames(&input)
+
+	var result struct {
+		Found bool
+	}
+	err := tx.
+		WithContext(ctx).
+		Raw(`SELECT EXISTS(SELECT 1 FROM user_groups WHERE id != ? AND name = ?) AS found`, groupID, input.Name).
+		First(&result).
+		Error
+	if err != nil {
+		return err
+	}
+	if result.Found {
+		return &common.AlreadyInUseError{Property: "name"}
+	}
+
+	if friendlyNameUnique {
+		err = tx.
+			WithContext(ctx).
+			Raw(`SELECT EXISTS(SELECT 1 FROM user_groups WHERE id != ? AND LOWER(friendly_name) = LOWER(?)) AS found`, groupID, input.FriendlyName).
+			First(&result).
+			Error
+		if err != nil {
+			return err
+		}
+		if result.Found {
+			return &common.AlreadyInUseError{Property: "friendly name"}
+		}
+	}
+
+	return nil
+}
+
 func (s *UserGroupService) GetUserCountOfGroup(ctx context.Context, id string) (int64, error) {
 	// We only perform select queries here, so we can rollback in all cases
 	tx := s.db.Begin()
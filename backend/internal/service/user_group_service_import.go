@@ -0,0 +1,416 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+)
+
+// UserGroupImportStatus is the outcome of the import of a single group
+type UserGroupImportStatus string
+
+const (
+	UserGroupImportStatusCreated UserGroupImportStatus = "created"
+	UserGroupImportStatusUpdated UserGroupImportStatus = "updated"
+	UserGroupImportStatusFailed  UserGroupImportStatus = "failed"
+)
+
+type UserGroupImportResult struct {
+	// Committed is false if the import was all-or-nothing and at least one group couldn't be imported
+	Committed bool
+	Groups    []UserGroupImportGroupResult
+	ReportID  string
+}
+
+type UserGroupImportGroupResult struct {
+	// Indexes of the input rows with this group, as rows with the same name are merged
+	RowIndexes []int
+	Name       string
+	// Friendly name of the group after the import
+	FriendlyName      string
+	Status            UserGroupImportStatus
+	AddedMembers      int
+	AddedUsernames    []string
+	UnresolvedMembers []string
+	Error             string
+}
+
+// userGroupImportGroup is a group to import, merged from the input rows with the same name
+type userGroupImportGroup struct {
+	dto.UserGroupImportGroupDto
+	RowIndexes []int
+}
+
+// ParseUserGroupImportJSON reads the groups to import from a JSON array
+func ParseUserGroupImportJSON(r io.Reader) (dto.UserGroupImportDto, error) {
+	var groups []dto.UserGroupImportGroupDto
+	err := json.NewDecoder(r).Decode(&groups)
+	if err != nil {
+		return dto.UserGroupImportDto{}, &common.ValidationError{Message: "Invalid JSON: " + err.Error()}
+	}
+
+	for i := range groups {
+		if groups[i].FriendlyName == "" {
+			groups[i].FriendlyName = groups[i].Name
+		}
+	}
+
+	return dto.UserGroupImportDto{Groups: groups}, nil
+}
+
+// ParseUserGroupImportCSV reads the groups to import from a CSV file.
+// The first row must be a header with the columns "name", "members" and optionally "friendlyName".
+// Members are separated by semicolons or spaces.
+func ParseUserGroupImportCSV(r io.Reader) (dto.UserGroupImportDto, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return dto.UserGroupImportDto{}, &common.ValidationError{Message: "The CSV file is empty"}
+	} else if err != nil {
+		return dto.UserGroupImportDto{}, &common.ValidationError{Message: "Invalid CSV: " + err.Error()}
+	}
+
+	nameColumn, friendlyNameColumn, membersColumn := -1, -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "name":
+			nameColumn = i
+		case "friendlyname", "friendly_name":
+			friendlyNameColumn = i
+		case "members":
+			membersColumn = i
+		}
+	}
+	if nameColumn == -1 || membersColumn == -1 {
+		return dto.UserGroupImportDto{}, &common.ValidationError{Message: "The CSV file must have the columns 'name' and 'members'"}
+	}
+
+	var groups []dto.UserGroupImportGroupDto
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return dto.UserGroupImportDto{}, &common.ValidationError{Message: "Invalid CSV: " + err.Error()}
+		}
+
+		field := func(column int) string {
+			if column == -1 || column >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[column])
+		}
+
+		group := dto.UserGroupImportGroupDto{
+			Name:         field(nameColumn),
+			FriendlyName: field(friendlyNameColumn),
+			Members: strings.FieldsFunc(field(membersColumn), func(r rune) bool {
+				return r == ';' || unicode.IsSpace(r)
+			}),
+		}
+		if group.FriendlyName == "" {
+			group.FriendlyName = group.Name
+		}
+		groups = append(groups, group)
+	}
+
+	return dto.UserGroupImportDto{Groups: groups}, nil
+}
+
+// Import creates the groups and adds their members, which are resolved by username or email.
+// Groups that already exist keep their friendly name and existing members.
+// If allOrNothing is true, nothing is saved if a group can't be imported or a member can't be resolved; otherwise, every group is imported on its own.
+// The outcome of every group is stored in a report that can be downloaded later, also if nothing was saved.
+func (s *UserGroupService) Import(ctx context.Context, input dto.UserGroupImportDto, allOrNothing bool, actorUserID, ipAddress, userAgent string) (UserGroupImportResult, error) {
+	groups := mergeUserGroupImportGroups(input.Groups)
+
+	usersByIdentifier, err := s.resolveImportMembers(ctx, groups)
+	if err != nil {
+		return UserGroupImportResult{}, err
+	}
+
+	result := UserGroupImportResult{
+		Groups: make([]UserGroupImportGroupResult, 0, len(groups)),
+	}
+
+	if allOrNothing {
+		tx := s.db.Begin()
+		defer func() {
+			tx.Rollback()
+		}()
+
+		complete := true
+		for _, group := range groups {
+			groupResult := s.importGroup(ctx, group, usersByIdentifier, tx)
+			if groupResult.Status == UserGroupImportStatusFailed || len(groupResult.UnresolvedMembers) > 0 {
+				complete = false
+			}
+			result.Groups = append(result.Groups, groupResult)
+		}
+
+		if !complete {
+			// The report is saved even though the import is rolled back, so admins can fix the file
+			tx.Rollback()
+			result.ReportID, err = s.saveImportReport(ctx, result, actorUserID, s.db)
+			if err != nil {
+				return UserGroupImportResult{}, err
+			}
+			return result, nil
+		}
+
+		result.Committed = true
+		result.ReportID, err = s.saveImportReport(ctx, result, actorUserID, tx)
+		if err != nil {
+			return UserGroupImportResult{}, err
+		}
+
+		s.createImportAuditLog(ctx, result, actorUserID, ipAddress, userAgent, tx)
+
+		err = tx.Commit().Error
+		if err != nil {
+			return UserGroupImportResult{}, err
+		}
+		return result, nil
+	}
+
+	for _, group := range groups {
+		groupResult := s.importGroupInTransaction(ctx, group, usersByIdentifier)
+		result.Groups = append(result.Groups, groupResult)
+	}
+	result.Committed = true
+
+	// The groups have already been imported, so the import must not be reported as failed if only the report can't be saved
+	result.ReportID, err = s.saveImportReport(ctx, result, actorUserID, s.db)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to save the user group import report", slog.Any("error", err))
+	}
+
+	s.createImportAuditLog(ctx, result, actorUserID, ipAddress, userAgent, s.db)
+
+	return result, nil
+}
+
+// mergeUserGroupImportGroups merges the groups with the same name, so that every group is imported once
+func mergeUserGroupImportGroups(groups []dto.UserGroupImportGroupDto) []userGroupImportGroup {
+	merged := make([]userGroupImportGroup, 0, len(groups))
+	indexByName := make(map[string]int, len(groups))
+	for rowIndex, group := range groups {
+		i, ok := indexByName[group.Name]
+		if !ok {
+			indexByName[group.Name] = len(merged)
+			merged = append(merged, userGroupImportGroup{
+				UserGroupImportGroupDto: dto.UserGroupImportGroupDto{
+					Name:         group.Name,
+					FriendlyName: group.FriendlyName,
+					Members:      append([]string(nil), group.Members...),
+				},
+				RowIndexes: []int{rowIndex},
+			})
+			continue
+		}
+		merged[i].Members = append(merged[i].Members, group.Members...)
+		merged[i].RowIndexes = append(merged[i].RowIndexes, rowIndex)
+	}
+	return merged
+}
+
+// resolveImportMembers loads the users referenced by the groups, indexed by their lowercase username and email
+func (s *UserGroupService) resolveImportMembers(ctx context.Context, groups []userGroupImportGroup) (map[string]model.User, error) {
+	identifierSet := make(map[string]struct{})
+	for _, group := range groups {
+		for _, member := range group.Members {
+			identifierSet[strings.ToLower(member)] = struct{}{}
+		}
+	}
+	if len(identifierSet) == 0 {
+		return map[string]model.User{}, nil
+	}
+
+	identifiers := make([]string, 0, len(identifierSet))
+	for identifier := range identifierSet {
+		identifiers = append(identifiers, identifier)
+	}
+
+	var users []model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("LOWER(username) IN ? OR LOWER(email) IN ?", identifiers, identifiers).
+		Find(&users).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	usersByIdentifier := make(map[string]model.User, len(users)*2)
+	for _, user := range users {
+		usersByIdentifier[strings.ToLower(user.Username)] = user
+		if user.Email != "" {
+			usersByIdentifier[strings.ToLower(user.Email)] = user
+		}
+	}
+	return usersByIdentifier, nil
+}
+
+func (s *UserGroupService) importGroupInTransaction(ctx context.Context, group userGroupImportGroup, usersByIdentifier map[string]model.User) UserGroupImportGroupResult {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	result := s.importGroup(ctx, group, usersByIdentifier, tx)
+	if result.Status == UserGroupImportStatusFailed {
+		return result
+	}
+
+	err := tx.Commit().Error
+	if err != nil {
+		result.Status = UserGroupImportStatusFailed
+		result.AddedMembers = 0
+		result.AddedUsernames = []string{}
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func (s *UserGroupService) importGroup(ctx context.Context, group userGroupImportGroup, usersByIdentifier map[string]model.User, tx *gorm.DB) UserGroupImportGroupResult {
+	result := UserGroupImportGroupResult{
+		RowIndexes:        group.RowIndexes,
+		Name:              group.Name,
+		FriendlyName:      group.FriendlyName,
+		Status:            UserGroupImportStatusUpdated,
+		AddedUsernames:    []string{},
+		UnresolvedMembers: []string{},
+	}
+	fail := func(err error) UserGroupImportGroupResult {
+		result.Status = UserGroupImportStatusFailed
+		result.AddedMembers = 0
+		result.AddedUsernames = []string{}
+		result.Error = err.Error()
+		return result
+	}
+
+	var existing model.UserGroup
+	err := tx.
+		WithContext(ctx).
+		Where("name = ?", group.Name).
+		First(&existing).
+		Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		existing, err = s.createInternal(ctx, dto.UserGroupCreateDto{Name: group.Name, FriendlyName: group.FriendlyName}, tx)
+		if err != nil {
+			return fail(err)
+		}
+		result.Status = UserGroupImportStatusCreated
+	case err != nil:
+		return fail(err)
+	case existing.LdapID != nil && s.appConfigService.GetDbConfig().LdapEnabled.IsTrue():
+		return fail(&common.LdapUserGroupUpdateError{})
+	default:
+		result.FriendlyName = existing.FriendlyName
+	}
+
+	var memberIDs []string
+	err = tx.
+		WithContext(ctx).
+		Table("user_groups_users").
+		Where("user_group_id = ?", existing.ID).
+		Pluck("user_id", &memberIDs).
+		Error
+	if err != nil {
+		return fail(err)
+	}
+	isMember := make(map[string]struct{}, len(memberIDs))
+	for _, id := range memberIDs {
+		isMember[id] = struct{}{}
+	}
+
+	var newMembers []model.User
+	for _, member := range group.Members {
+		user, ok := usersByIdentifier[strings.ToLower(member)]
+		if !ok {
+			result.UnresolvedMembers = append(result.UnresolvedMembers, member)
+			continue
+		}
+		if _, ok := isMember[user.ID]; ok {
+			continue
+		}
+		isMember[user.ID] = struct{}{}
+		newMembers = append(newMembers, user)
+		result.AddedUsernames = append(result.AddedUsernames, user.Username)
+	}
+
+	if len(newMembers) > 0 {
+		err = tx.
+			WithContext(ctx).
+			Model(&existing).
+			Association("Users").
+			Append(newMembers)
+		if err != nil {
+			return fail(fmt.Errorf("failed to add members: %w", err))
+		}
+	}
+	result.AddedMembers = len(newMembers)
+
+	return result
+}
+
+// saveImportReport stores the outcome of every group, and returns the ID of the report
+func (s *UserGroupService) saveImportReport(ctx context.Context, result UserGroupImportResult, actorUserID string, tx *gorm.DB) (string, error) {
+	entries := make([]any, len(result.Groups))
+	failed := 0
+	for i, groupResult := range result.Groups {
+		var entry dto.UserGroupImportGroupResultDto
+		err := dto.MapStruct(groupResult, &entry)
+		if err != nil {
+			return "", err
+		}
+		entries[i] = entry
+		if groupResult.Status == UserGroupImportStatusFailed {
+			failed++
+		}
+	}
+
+	report, err := s.importReportService.Create(ctx, model.ImportReportTypeUserGroups, actorUserID, result.Committed, failed, entries, tx)
+	if err != nil {
+		return "", err
+	}
+	return report.ID, nil
+}
+
+func (s *UserGroupService) createImportAuditLog(ctx context.Context, result UserGroupImportResult, actorUserID, ipAddress, userAgent string, tx *gorm.DB) {
+	var created, updated, failed int
+	for _, result := range result.Groups {
+		switch result.Status {
+		case UserGroupImportStatusCreated:
+			created++
+		case UserGroupImportStatusUpdated:
+			updated++
+		case UserGroupImportStatusFailed:
+			failed++
+		}
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventUserGroupImport, ipAddress, userAgent, actorUserID, model.AuditLogData{
+		"created":  strconv.Itoa(created),
+		"updated":  strconv.Itoa(updated),
+		"failed":   strconv.Itoa(failed),
+		"reportId": result.ReportID,
+	}, tx)
+}
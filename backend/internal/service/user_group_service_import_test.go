@@ -0,0 +1,211 @@
+package service
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestParseUserGroupImportCSV(t *testing.T) {
+	t.Run("parses the groups", func(t *testing.T) {
+		input, err := ParseUserGroupImportCSV(strings.NewReader("name,friendlyName,members\nstaff,Staff,alice;bob@example.com\ndevs,,carol dave\n"))
+		require.NoError(t, err)
+
+		assert.Equal(t, []dto.UserGroupImportGroupDto{
+			{Name: "staff", FriendlyName: "Staff", Members: []string{"alice", "bob@example.com"}},
+			{Name: "devs", FriendlyName: "devs", Members: []string{"carol", "dave"}},
+		}, input.Groups)
+	})
+
+	t.Run("requires the name and members columns", func(t *testing.T) {
+		_, err := ParseUserGroupImportCSV(strings.NewReader("name,friendlyName\nstaff,Staff\n"))
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestUserGroupService_Import(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := NewUserGroupService(db, appConfig, &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig}, NewImportReportService(db))
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	bob := model.User{Username: "bob", Email: "bob@example.com", FirstName: "Bob"}
+	require.NoError(t, db.Create(&[]*model.User{&alice, &bob}).Error)
+
+	existing := model.UserGroup{Name: "staff", FriendlyName: "Staff", Users: []model.User{alice}}
+	require.NoError(t, db.Create(&existing).Error)
+
+	memberIDs := func(t *testing.T, groupName string) []string {
+		t.Helper()
+		var ids []string
+		require.NoError(t, db.
+			Table("user_groups_users").
+			Joins("JOIN user_groups ON user_groups.id = user_groups_users.user_group_id").
+			Where("user_groups.name = ?", groupName).
+			Pluck("user_id", &ids).
+			Error)
+		return ids
+	}
+	groupExists := func(t *testing.T, name string) bool {
+		t.Helper()
+		var count int64
+		require.NoError(t, db.Model(&model.UserGroup{}).Where("name = ?", name).Count(&count).Error)
+		return count > 0
+	}
+
+	t.Run("rolls back everything if a member can't be resolved", func(t *testing.T) {
+		result, err := s.Import(t.Context(), dto.UserGroupImportDto{Groups: []dto.UserGroupImportGroupDto{
+			{Name: "devs", FriendlyName: "Devs", Members: []string{"alice"}},
+			{Name: "ops", FriendlyName: "Ops", Members: []string{"unknown@example.com"}},
+		}}, true, alice.ID, "", "")
+		require.NoError(t, err)
+
+		assert.False(t, result.Committed)
+		require.Len(t, result.Groups, 2)
+		assert.Equal(t, []string{"unknown@example.com"}, result.Groups[1].UnresolvedMembers)
+		assert.False(t, groupExists(t, "devs"))
+		assert.False(t, groupExists(t, "ops"))
+
+		// The report is kept even though nothing was imported
+		report, err := s.importReportService.Get(t.Context(), result.ReportID)
+		require.NoError(t, err)
+		assert.False(t, report.Committed)
+		assert.Equal(t, 2, report.TotalCount)
+	})
+
+	t.Run("imports the groups and reports unresolved members", func(t *testing.T) {
+		result, err := s.Import(t.Context(), dto.UserGroupImportDto{Groups: []dto.UserGroupImportGroupDto{
+			{Name: "devs", FriendlyName: "Devs", Members: []string{"ALICE", "unknown"}},
+			{Name: "staff", FriendlyName: "Other name", Members: []string{"alice", "bob@example.com"}},
+			{Name: "devs", FriendlyName: "Devs", Members: []string{"bob"}},
+		}}, false, alice.ID, "", "")
+		require.NoError(t, err)
+
+		assert.True(t, result.Committed)
+		assert.Equal(t, []UserGroupImportGroupResult{
+			{RowIndexes: []int{0, 2}, Name: "devs", FriendlyName: "Devs", Status: UserGroupImportStatusCreated, AddedMembers: 2, AddedUsernames: []string{"alice", "bob"}, UnresolvedMembers: []string{"unknown"}},
+			{RowIndexes: []int{1}, Name: "staff", FriendlyName: "Staff", Status: UserGroupImportStatusUpdated, AddedMembers: 1, AddedUsernames: []string{"bob"}, UnresolvedMembers: []string{}},
+		}, result.Groups)
+
+		assert.ElementsMatch(t, []string{alice.ID, bob.ID}, memberIDs(t, "devs"))
+		assert.ElementsMatch(t, []string{alice.ID, bob.ID}, memberIDs(t, "staff"))
+
+		// Existing groups keep their friendly name
+		var staff model.UserGroup
+		require.NoError(t, db.Where("name = ?", "staff").First(&staff).Error)
+		assert.Equal(t, "Staff", staff.FriendlyName)
+	})
+
+	t.Run("skips LDAP groups if LDAP is enabled", func(t *testing.T) {
+		ldapID := "ldap-group"
+		require.NoError(t, db.Create(&model.UserGroup{Name: "ldap", FriendlyName: "LDAP", LdapID: &ldapID}).Error)
+
+		ldapService := NewUserGroupService(db, NewTestAppConfigService(&model.AppConfig{LdapEnabled: model.AppConfigVariable{Value: "true"}}), s.auditLogService, s.importReportService)
+		result, err := ldapService.Import(t.Context(), dto.UserGroupImportDto{Groups: []dto.UserGroupImportGroupDto{
+			{Name: "ldap", FriendlyName: "LDAP", Members: []string{"alice"}},
+			{Name: "support", FriendlyName: "Support", Members: []string{"bob"}},
+		}}, false, alice.ID, "", "")
+		require.NoError(t, err)
+
+		require.Len(t, result.Groups, 2)
+		assert.Equal(t, UserGroupImportStatusFailed, result.Groups[0].Status)
+		assert.Equal(t, UserGroupImportStatusCreated, result.Groups[1].Status)
+		assert.Empty(t, memberIDs(t, "ldap"))
+		assert.Equal(t, []string{bob.ID}, memberIDs(t, "support"))
+	})
+}
+
+func TestUserGroupService_ImportReport(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := NewUserGroupService(db, appConfig, &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig}, NewImportReportService(db))
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&alice).Error)
+
+	result, err := s.Import(t.Context(), dto.UserGroupImportDto{Groups: []dto.UserGroupImportGroupDto{
+		{Name: "devs", FriendlyName: "Devs", Members: []string{"Alice@Example.com", "unknown"}},
+	}}, false, alice.ID, "", "")
+	require.NoError(t, err)
+	require.NotEmpty(t, result.ReportID)
+
+	t.Run("stores the outcome of every row", func(t *testing.T) {
+		report, err := s.importReportService.Get(t.Context(), result.ReportID)
+		require.NoError(t, err)
+		assert.Equal(t, model.ImportReportTypeUserGroups, report.Type)
+		require.NotNil(t, report.ActorUserID)
+		assert.Equal(t, alice.ID, *report.ActorUserID)
+		assert.True(t, report.Committed)
+		assert.Equal(t, 1, report.TotalCount)
+		assert.Zero(t, report.FailedCount)
+
+		var entries []dto.UserGroupImportGroupResultDto
+		require.NoError(t, json.Unmarshal([]byte(report.Entries), &entries))
+		assert.Equal(t, []dto.UserGroupImportGroupResultDto{{
+			RowIndexes:        []int{0},
+			Name:              "devs",
+			FriendlyName:      "Devs",
+			Status:            "created",
+			AddedMembers:      1,
+			AddedUsernames:    []string{"alice"},
+			UnresolvedMembers: []string{"unknown"},
+		}}, entries)
+	})
+
+	t.Run("records the report in the audit log", func(t *testing.T) {
+		var auditLog model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventUserGroupImport).First(&auditLog).Error)
+		assert.Equal(t, alice.ID, auditLog.UserID)
+		assert.Equal(t, result.ReportID, auditLog.Data["reportId"])
+	})
+
+	t.Run("doesn't return expired reports", func(t *testing.T) {
+		require.NoError(t, db.Model(&model.ImportReport{}).Where("id = ?", result.ReportID).Update("expires_at", datatype.DateTime(time.Now().Add(-time.Minute))).Error)
+
+		_, err := s.importReportService.Get(t.Context(), result.ReportID)
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+		reports, _, err := s.importReportService.List(t.Context(), utils.SortedPaginationRequest{})
+		require.NoError(t, err)
+		assert.Empty(t, reports)
+	})
+}
+
+func TestUserGroupService_ImportReportFailure(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := NewUserGroupService(db, appConfig, &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig}, NewImportReportService(db))
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	require.NoError(t, db.Create(&alice).Error)
+
+	// Saving the report fails after the groups have been imported
+	require.NoError(t, db.Migrator().DropTable(&model.ImportReport{}))
+
+	result, err := s.Import(t.Context(), dto.UserGroupImportDto{Groups: []dto.UserGroupImportGroupDto{
+		{Name: "devs", FriendlyName: "Devs", Members: []string{"alice"}},
+	}}, false, alice.ID, "", "")
+	require.NoError(t, err)
+	assert.True(t, result.Committed)
+	assert.Empty(t, result.ReportID)
+	require.Len(t, result.Groups, 1)
+	assert.Equal(t, UserGroupImportStatusCreated, result.Groups[0].Status)
+
+	var auditLog model.AuditLog
+	require.NoError(t, db.Where("event = ?", model.AuditLogEventUserGroupImport).First(&auditLog).Error)
+	assert.Equal(t, "1", auditLog.Data["created"])
+	assert.Empty(t, auditLog.Data["reportId"])
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestUserGroupService_BulkAssign(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{})
+	s := NewUserGroupService(db, appConfig, &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig}, NewImportReportService(db))
+
+	alice := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}
+	bob := model.User{Username: "bob", Email: "bob@example.com", FirstName: "Bob"}
+	carol := model.User{Username: "carol", Email: "carol@example.com", FirstName: "Carol"}
+	require.NoError(t, db.Create(&[]*model.User{&alice, &bob, &carol}).Error)
+
+	group := model.UserGroup{Name: "staff", FriendlyName: "Staff", Users: []model.User{alice}}
+	require.NoError(t, db.Create(&group).Error)
+
+	memberIDs := func(t *testing.T) []string {
+		t.Helper()
+		var ids []string
+		require.NoError(t, db.Table("user_groups_users").Where("user_group_id = ?", group.ID).Pluck("user_id", &ids).Error)
+		return ids
+	}
+
+	t.Run("adds the users that aren't members", func(t *testing.T) {
+		results, err := s.BulkAssign(t.Context(), group.ID, []string{alice.ID, bob.ID, "missing"}, true, alice.ID, "", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []BulkAssignResult{
+			{UserID: alice.ID, Status: BulkAssignStatusAlreadyMember},
+			{UserID: bob.ID, Status: BulkAssignStatusAdded},
+			{UserID: "missing", Status: BulkAssignStatusUserNotFound},
+		}, results)
+		assert.ElementsMatch(t, []string{alice.ID, bob.ID}, memberIDs(t))
+	})
+
+	t.Run("removes the users that are members", func(t *testing.T) {
+		results, err := s.BulkAssign(t.Context(), group.ID, []string{alice.ID, carol.ID}, false, alice.ID, "", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, []BulkAssignResult{
+			{UserID: alice.ID, Status: BulkAssignStatusRemoved},
+			{UserID: carol.ID, Status: BulkAssignStatusNotMember},
+		}, results)
+		assert.ElementsMatch(t, []string{bob.ID}, memberIDs(t))
+	})
+
+	t.Run("records the change in the audit log", func(t *testing.T) {
+		var logs []model.AuditLog
+		require.NoError(t, db.Where("event = ?", model.AuditLogEventUserGroupBulkAssignment).Find(&logs).Error)
+		require.Len(t, logs, 2)
+
+		counts := make(map[string]string, len(logs))
+		for _, log := range logs {
+			assert.Equal(t, "staff", log.Data["groupName"])
+			counts[log.Data["action"]] = log.Data["count"]
+		}
+		assert.Equal(t, map[string]string{"add": "1", "remove": "1"}, counts)
+	})
+
+	t.Run("rejects LDAP groups if LDAP is enabled", func(t *testing.T) {
+		ldapID := "ldap-staff"
+		ldapGroup := model.UserGroup{Name: "ldap-staff", FriendlyName: "LDAP Staff", LdapID: &ldapID}
+		require.NoError(t, db.Create(&ldapGroup).Error)
+
+		ldapService := NewUserGroupService(db, NewTestAppConfigService(&model.AppConfig{LdapEnabled: model.AppConfigVariable{Value: "true"}}), s.auditLogService, s.importReportService)
+		_, err := ldapService.BulkAssign(t.Context(), ldapGroup.ID, []string{bob.ID}, true, alice.ID, "", "")
+		var ldapErr *common.LdapUserGroupUpdateError
+		require.ErrorAs(t, err, &ldapErr)
+	})
+}
+
+func TestUserGroupService_Names(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	newService := func(friendlyNameUnique, slugEnforced string) *UserGroupService {
+		appConfig := NewTestAppConfigService(&model.AppConfig{
+			UserGroupFriendlyNameUnique: model.AppConfigVariable{Value: friendlyNameUnique},
+			UserGroupNameSlugEnforced:   model.AppConfigVariable{Value: slugEnforced},
+		})
+		return NewUserGroupService(db, appConfig, &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig}, NewImportReportService(db))
+	}
+	s := newService("false", "false")
+
+	t.Run("generates a unique name from the friendly name", func(t *testing.T) {
+		group, err := s.Create(t.Context(), dto.UserGroupCreateDto{FriendlyName: "Human Resources"})
+		require.NoError(t, err)
+		assert.Equal(t, "human-resources", group.Name)
+
+		group, err = s.Create(t.Context(), dto.UserGroupCreateDto{FriendlyName: "Human resources"})
+		require.NoError(t, err)
+		assert.Equal(t, "human-resources-2", group.Name)
+	})
+
+	t.Run("returns an error for a duplicated name", func(t *testing.T) {
+		_, err := s.Create(t.Context(), dto.UserGroupCreateDto{Name: "human-resources", FriendlyName: "HR"})
+		var inUseErr *common.AlreadyInUseError
+		require.ErrorAs(t, err, &inUseErr)
+		assert.Equal(t, "name", inUseErr.Property)
+	})
+
+	t.Run("keeps the name when updating without a name", func(t *testing.T) {
+		group, err := s.Create(t.Context(), dto.UserGroupCreateDto{FriendlyName: "Finance"})
+		require.NoError(t, err)
+
+		group, err = s.Update(t.Context(), group.ID, dto.UserGroupCreateDto{FriendlyName: "Finance Team"})
+		require.NoError(t, err)
+		assert.Equal(t, "finance", group.Name)
+		assert.Equal(t, "Finance Team", group.FriendlyName)
+	})
+
+	t.Run("enforces unique friendly names if enabled", func(t *testing.T) {
+		_, err := newService("true", "false").Create(t.Context(), dto.UserGroupCreateDto{Name: "finance-2", FriendlyName: "finance team"})
+		var inUseErr *common.AlreadyInUseError
+		require.ErrorAs(t, err, &inUseErr)
+		assert.Equal(t, "friendly name", inUseErr.Property)
+	})
+
+	t.Run("enforces the slug format if enabled", func(t *testing.T) {
+		legacy, err := s.Create(t.Context(), dto.UserGroupCreateDto{Name: "Legacy Group", FriendlyName: "Legacy"})
+		require.NoError(t, err)
+
+		strict := newService("false", "true")
+		_, err = strict.Create(t.Context(), dto.UserGroupCreateDto{Name: "Not A Slug", FriendlyName: "Not a slug"})
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+
+		// Existing names are accepted if they don't change
+		_, err = strict.Update(t.Context(), legacy.ID, dto.UserGroupCreateDto{Name: "Legacy Group", FriendlyName: "Legacy group"})
+		require.NoError(t, err)
+	})
+
+	t.Run("normalizes the names before comparing them", func(t *testing.T) {
+		appConfig := NewTestAppConfigService(&model.AppConfig{
+			UserGroupFriendlyNameUnique: model.AppConfigVariable{Value: "true"},
+			UnicodeNormalizationForm:    model.AppConfigVariable{Value: "nfkc"},
+		})
+		nfkc := NewUserGroupService(db, appConfig, s.auditLogService, s.importReportService)
+
+		group, err := nfkc.Create(t.Context(), dto.UserGroupCreateDto{Name: "\ufb01nance-eu", FriendlyName: "Cafe\u0301 Team"})
+		require.NoError(t, err)
+		assert.Equal(t, "finance-eu", group.Name)
+		assert.Equal(t, "Caf\u00e9 Team", group.FriendlyName)
+
+		_, err = nfkc.Create(t.Context(), dto.UserGroupCreateDto{Name: "cafe-team", FriendlyName: "Caf\u00e9 team"})
+		var inUseErr *common.AlreadyInUseError
+		require.ErrorAs(t, err, &inUseErr)
+		assert.Equal(t, "friendly name", inUseErr.Property)
+	})
+}
@@ -3,17 +3,21 @@ package service
 import (
 	"bytes"
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"maps"
 	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
 	"github.com/pocket-id/pocket-id/backend/internal/common"
@@ -22,39 +26,121 @@ import (
 	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
 	"github.com/pocket-id/pocket-id/backend/internal/utils"
 	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
-	profilepicture "github.com/pocket-id/pocket-id/backend/internal/utils/image"
+	imageutil "github.com/pocket-id/pocket-id/backend/internal/utils/image"
 )
 
+const (
+	// Maximum length of a username, as enforced by the DTO validation
+	maxUsernameLength = 50
+	// Number of suffixes that are tried when generating a username that is already taken
+	maxGeneratedUsernameAttempts = 20
+
+	// Type of the outbox messages that send the email containing a one-time access token
+	outboxMessageOneTimeAccessEmail = "oneTimeAccessEmail"
+
+	// Maximum time to save a default profile picture in the cache after it has been served
+	defaultProfilePictureSaveTimeout = 30 * time.Second
+)
+
+// Uploaded profile pictures are converted to square PNG images, without the metadata of the original
+var profilePictureOptions = imageutil.Options{
+	AllowedTypes: []string{"png", "jpg"},
+	// Pictures synced from LDAP aren't limited by the size limit of uploads
+	MaxInputSize: 10 << 20, // 10 MB
+	OutputFormat: "png",
+	SquareSize:   imageutil.ProfilePictureSize,
+}
+
 type UserService struct {
 	db               *gorm.DB
 	jwtService       *JwtService
 	auditLogService  *AuditLogService
 	emailService     *EmailService
 	appConfigService *AppConfigService
+	outboxService    *OutboxService
+	bulkWorkerPool   *utils.WorkerPool
 }
 
-func NewUserService(db *gorm.DB, jwtService *JwtService, auditLogService *AuditLogService, emailService *EmailService, appConfigService *AppConfigService) *UserService {
-	return &UserService{
+func NewUserService(db *gorm.DB, jwtService *JwtService, auditLogService *AuditLogService, emailService *EmailService, appConfigService *AppConfigService, outboxService *OutboxService, bulkWorkerPool *utils.WorkerPool) *UserService {
+	s := &UserService{
 		db:               db,
 		jwtService:       jwtService,
 		auditLogService:  auditLogService,
 		emailService:     emailService,
 		appConfigService: appConfigService,
+		outboxService:    outboxService,
+		bulkWorkerPool:   bulkWorkerPool,
 	}
+
+	outboxService.RegisterHandler(outboxMessageOneTimeAccessEmail, outboxHandlerFor(s.sendOneTimeAccessEmail))
+	outboxService.RegisterHandler(outboxMessageWelcomeEmail, outboxHandlerFor(s.sendWelcomeEmail))
+	outboxService.RegisterHandler(outboxMessageAccountDeletionEmail, outboxHandlerFor(s.sendAccountDeletionEmail))
+
+	return s
+}
+
+// oneTimeAccessEmailPayload is the payload of the outbox messages that send the one-time access email
+type oneTimeAccessEmailPayload struct {
+	UserID       string    `json:"userId"`
+	Code         string    `json:"code"`
+	RedirectPath string    `json:"redirectPath"`
+	ExpiresAt    time.Time `json:"expiresAt"`
+}
+
+// ListUsersOptions controls how much data is loaded by ListUsers, so that lightweight listings stay fast
+type ListUsersOptions struct {
+	// SkipAssociations skips loading the groups and custom claims of the users
+	SkipAssociations bool
+	// Fields limits the loaded fields to the given ones, using the names of the JSON fields of the user DTO.
+	// The ID is always loaded. If empty, all fields are loaded.
+	Fields []string
 }
 
-func (s *UserService) ListUsers(ctx context.Context, searchTerm string, sortedPaginationRequest utils.SortedPaginationRequest) ([]model.User, utils.PaginationResponse, error) {
+// userListFields maps the fields that can be selected in ListUsers to their columns
+var userListFields = map[string]string{
+	"id":        "id",
+	"username":  "username",
+	"email":     "email",
+	"firstName": "first_name",
+	"lastName":  "last_name",
+	"isAdmin":   "is_admin",
+	"locale":    "locale",
+	"ldapId":    "ldap_id",
+	"disabled":  "disabled",
+	// Activity
+	"lastLoginAt": "last_login_at",
+	"lastLoginIp": "last_login_ip",
+}
+
+func (s *UserService) ListUsers(ctx context.Context, searchTerm string, sortedPaginationRequest utils.SortedPaginationRequest, opts ListUsersOptions) ([]model.User, utils.PaginationResponse, error) {
 	var users []model.User
 	query := s.db.WithContext(ctx).
-		Model(&model.User{}).
-		Preload("UserGroups").
-		Preload("CustomClaims")
+		Model(&model.User{})
+
+	if !opts.SkipAssociations {
+		query = query.
+			Preload("UserGroups").
+			Preload("CustomClaims")
+	}
+
+	if len(opts.Fields) > 0 {
+		columns := []string{"id"}
+		for _, field := range opts.Fields {
+			column, ok := userListFields[field]
+			if !ok {
+				return nil, utils.PaginationResponse{}, &common.ValidationError{Message: fmt.Sprintf("unknown field '%s'", field)}
+			}
+			if !slices.Contains(columns, column) {
+				columns = append(columns, column)
+			}
+		}
+		query = query.Select(columns)
+	}
 
 	if searchTerm != "" {
-		searchPattern := "%" + searchTerm + "%"
-		query = query.Where(
-			"email LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR username LIKE ?",
-			searchPattern, searchPattern, searchPattern, searchPattern)
+		searchTerm = utils.NormalizeUnicode(searchTerm, s.appConfigService.GetDbConfig().UnicodeNormalizationForm.Value)
+		searchQuery, searchArgs := utils.CaseInsensitiveSearch(searchTerm, "email", "first_name", "last_name", "username")
+		query = query.Where(searchQuery, searchArgs...)
 	}
 
 	pagination, err := utils.PaginateAndSort(sortedPaginationRequest, query, &users)
@@ -97,15 +183,16 @@ func (s *UserService) GetProfilePicture(ctx context.Context, userID string) (io.
 		return file, fileInfo.Size(), nil
 	}
 
-	// If no custom picture exists, get the user's data for creating initials
+	// If no custom picture exists, get the user's data for creating the default picture
 	user, err := s.GetUser(ctx, userID)
 	if err != nil {
 		return nil, 0, err
 	}
 
-	// Check if we have a cached default picture for these initials
-	defaultProfilePicturesDir := common.EnvConfig.UploadPath + "/profile-pictures/defaults/"
-	defaultPicturePath := defaultProfilePicturesDir + user.Initials() + ".png"
+	// Check if we have a cached default picture for this user
+	generator := defaultProfilePictureGenerator()
+	key := generator.Key(user.ID, user.Initials())
+	defaultPicturePath := defaultProfilePicturePath(key)
 	file, err = os.Open(defaultPicturePath)
 	if err == nil {
 		fileInfo, err := file.Stat()
@@ -116,24 +203,27 @@ func (s *UserService) GetProfilePicture(ctx context.Context, userID string) (io.
 		return file, fileInfo.Size(), nil
 	}
 
+	// Don't create the picture if the request was aborted in the meantime
+	err = ctx.Err()
+	if err != nil {
+		return nil, 0, err
+	}
+
 	// If no cached default picture exists, create one and save it for future use
-	defaultPicture, err := profilepicture.CreateDefaultProfilePicture(user.Initials())
+	defaultPicture, err := generator.Generate(key)
 	if err != nil {
 		return nil, 0, err
 	}
 
 	// Save the default picture for future use (in a goroutine to avoid blocking)
+	// The request may complete before the picture is saved, so the goroutine isn't canceled with the request, but it's bounded by a timeout
 	defaultPictureBytes := defaultPicture.Bytes()
+	saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), defaultProfilePictureSaveTimeout)
 	go func() {
-		// Ensure the directory exists
-		errInternal := os.MkdirAll(defaultProfilePicturesDir, os.ModePerm)
+		defer cancel()
+		errInternal := saveDefaultProfilePicture(saveCtx, key, defaultPictureBytes)
 		if errInternal != nil {
-			slog.Error("Failed to create directory for default profile picture", slog.Any("error", errInternal))
-			return
-		}
-		errInternal = utils.SaveFileStream(bytes.NewReader(defaultPictureBytes), defaultPicturePath)
-		if errInternal != nil {
-			slog.Error("Failed to cache default profile picture for initials", slog.String("initials", user.Initials()), slog.Any("error", errInternal))
+			slog.ErrorContext(saveCtx, "Failed to cache default profile picture", slog.String("key", key), slog.Any("error", errInternal))
 		}
 	}()
 
@@ -154,6 +244,126 @@ func (s *UserService) GetUserGroups(ctx context.Context, userID string) ([]model
 	return user.UserGroups, nil
 }
 
+// PregenerateDefaultProfilePictures creates the cached default profile pictures of all users that don't have one yet.
+// The pictures are generated by the bulk worker pool, so this doesn't compete with requests for database connections.
+// If progress isn't nil, it's called with the number of pictures generated so far.
+func (s *UserService) PregenerateDefaultProfilePictures(ctx context.Context, progress BackgroundJobProgressFunc) error {
+	var users []model.User
+	err := s.db.
+		WithContext(ctx).
+		Select("id", "username", "first_name", "last_name").
+		Find(&users).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load users: %w", err)
+	}
+
+	// Users with the same key, e.g. the same initials, share the same default picture
+	generator := defaultProfilePictureGenerator()
+	keysMap := make(map[string]struct{}, len(users))
+	for _, user := range users {
+		key := generator.Key(user.ID, user.Initials())
+		if _, err := os.Stat(defaultProfilePicturePath(key)); err == nil {
+			continue
+		}
+		keysMap[key] = struct{}{}
+	}
+	keys := slices.Collect(maps.Keys(keysMap))
+
+	if progress == nil {
+		progress = func(int, int) {}
+	}
+	progress(0, len(keys))
+
+	var generated atomic.Int64
+	return s.bulkWorkerPool.Each(ctx, len(keys), func(ctx context.Context, i int) error {
+		picture, err := generator.Generate(keys[i])
+		if err != nil {
+			return fmt.Errorf("failed to create default profile picture '%s': %w", keys[i], err)
+		}
+		err = saveDefaultProfilePicture(ctx, keys[i], picture.Bytes())
+		if err != nil {
+			return err
+		}
+
+		progress(int(generated.Add(1)), len(keys))
+		return nil
+	})
+}
+
+// defaultProfilePictureGenerator returns the generator selected with PROFILE_PICTURE_GENERATOR
+func defaultProfilePictureGenerator() imageutil.Generator {
+	generator := imageutil.NewGenerator(common.EnvConfig.ProfilePictureGenerator)
+	if generator == nil {
+		return imageutil.InitialsGenerator{}
+	}
+	return generator
+}
+
+// DefaultProfilePicturesDir returns the directory of the cached default profile pictures of the configured generator.
+// Each generator has its own directory, so switching generators doesn't serve the pictures of the previous one.
+func DefaultProfilePicturesDir() string {
+	return common.EnvConfig.UploadPath + "/profile-pictures/defaults/" + defaultProfilePictureGenerator().Name()
+}
+
+// DefaultProfilePictureKey returns the key of the default profile picture of the user with the configured generator
+func DefaultProfilePictureKey(user model.User) string {
+	return defaultProfilePictureGenerator().Key(user.ID, user.Initials())
+}
+
+// defaultProfilePicturePath returns the path of the cached default profile picture with the given key
+func defaultProfilePicturePath(key string) string {
+	return DefaultProfilePicturesDir() + "/" + key + ".png"
+}
+
+// saveDefaultProfilePicture stores the default profile picture with the given key in the cache
+func saveDefaultProfilePicture(ctx context.Context, key string, data []byte) error {
+	// Ensure the directory exists
+	err := os.MkdirAll(DefaultProfilePicturesDir(), os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for default profile pictures: %w", err)
+	}
+	return utils.SaveFileStream(utils.ContextReader(ctx, bytes.NewReader(data)), defaultProfilePicturePath(key))
+}
+
+// RegenerateDefaultProfilePicture recreates the cached default profile picture of the user
+func (s *UserService) RegenerateDefaultProfilePicture(ctx context.Context, userID string) error {
+	// Validate the user ID to prevent directory traversal
+	if err := uuid.Validate(userID); err != nil {
+		return &common.InvalidUUIDError{}
+	}
+
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	key := DefaultProfilePictureKey(user)
+	return regenerateDefaultProfilePicture(ctx, key, key)
+}
+
+// regenerateDefaultProfilePicture deletes the cached default profile picture with the previous key and creates the one with the current key.
+// Default pictures can be shared by users with the same key, so a deleted picture is just created again when it's requested.
+func regenerateDefaultProfilePicture(ctx context.Context, previousKey, key string) error {
+	err := os.Remove(defaultProfilePicturePath(previousKey))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete default profile picture: %w", err)
+	}
+
+	picture, err := defaultProfilePictureGenerator().Generate(key)
+	if err != nil {
+		return fmt.Errorf("failed to create default profile picture: %w", err)
+	}
+
+	return saveDefaultProfilePicture(ctx, key, picture.Bytes())
+}
+
+// hasCustomProfilePicture returns true if the user uploaded a profile picture
+func hasCustomProfilePicture(userID string) bool {
+	ok, err := utils.FileExists(common.EnvConfig.UploadPath + "/profile-pictures/" + userID + ".png")
+	return err == nil && ok
+}
+
 func (s *UserService) UpdateProfilePicture(userID string, file io.Reader) error {
 	// Validate the user ID to prevent directory traversal
 	err := uuid.Validate(userID)
@@ -161,8 +371,13 @@ func (s *UserService) UpdateProfilePicture(userID string, file io.Reader) error
 		return &common.InvalidUUIDError{}
 	}
 
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return fmt.Errorf("failed to read profile picture: %w", err)
+	}
+
 	// Convert the image to a smaller square image
-	profilePicture, err := profilepicture.CreateProfilePicture(file)
+	profilePicture, err := imageutil.Process(data, profilePictureOptions)
 	if err != nil {
 		return err
 	}
@@ -175,7 +390,7 @@ func (s *UserService) UpdateProfilePicture(userID string, file io.Reader) error
 	}
 
 	// Create the profile picture file
-	err = utils.SaveFileStream(profilePicture, profilePictureDir+"/"+userID+".png")
+	err = utils.SaveFileStream(bytes.NewReader(profilePicture.Data), profilePictureDir+"/"+userID+".png")
 	if err != nil {
 		return err
 	}
@@ -221,18 +436,14 @@ func (s *UserService) deleteUserInternal(ctx context.Context, userID string, all
 	return nil
 }
 
-func (s *UserService) CreateUser(ctx context.Context, input dto.UserCreateDto) (model.User, error) {
-	tx := s.db.Begin()
-	defer func() {
-		tx.Rollback()
-	}()
-
-	user, err := s.createUserInternal(ctx, input, false, tx)
-	if err != nil {
-		return model.User{}, err
-	}
-
-	err = tx.Commit().Error
+func (s *UserService) CreateUser(ctx context.Context, input dto.UserCreateDto) (user model.User, err error) {
+	err = utils.WithRetryableTransaction(ctx, s.db, func(tx *gorm.DB) (err error) {
+		user, err = s.createUserInternal(ctx, input, false, tx)
+		if err != nil {
+			return err
+		}
+		return s.enqueueWelcomeEmail(ctx, user, tx)
+	})
 	if err != nil {
 		return model.User{}, err
 	}
@@ -247,13 +458,38 @@ func (s *UserService) createUserInternal(ctx context.Context, input dto.UserCrea
 		Email:     input.Email,
 		Username:  input.Username,
 		IsAdmin:   input.IsAdmin,
-		Locale:    input.Locale,
 	}
 	if input.LdapID != "" {
 		user.LdapID = &input.LdapID
 	}
+	s.normalizeNames(&user)
+
+	err := s.checkAllowedEmailDomain(user.Email, isLdapSync)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user.Locale, err = s.checkSupportedLocale(input.Locale)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if user.Username == "" {
+		user.Username, err = s.generateUsernameFromEmail(ctx, user.Email, tx)
+		if err != nil {
+			return model.User{}, err
+		}
+	}
+
+	if s.appConfigService.GetDbConfig().LoginEmailEnabled.IsTrue() {
+		user.LoginEmail = loginEmailFromInput(input)
+		err = s.checkLoginEmailConflicts(ctx, user, tx)
+		if err != nil {
+			return model.User{}, err
+		}
+	}
 
-	err := tx.WithContext(ctx).Create(&user).Error
+	err = tx.WithContext(ctx).Create(&user).Error
 	if errors.Is(err, gorm.ErrDuplicatedKey) {
 		// Do not follow this path if we're using LDAP, as we don't want to roll-back the transaction here
 		if !isLdapSync {
@@ -271,20 +507,36 @@ func (s *UserService) createUserInternal(ctx context.Context, input dto.UserCrea
 	return user, nil
 }
 
-func (s *UserService) UpdateUser(ctx context.Context, userID string, updatedUser dto.UserCreateDto, updateOwnUser bool, isLdapSync bool) (model.User, error) {
-	tx := s.db.Begin()
-	defer func() {
-		tx.Rollback()
-	}()
+func (s *UserService) UpdateUser(ctx context.Context, userID string, updatedUser dto.UserCreateDto, updateOwnUser bool, isLdapSync bool) (user model.User, err error) {
+	var previousPictureKey string
+	err = utils.WithRetryableTransaction(ctx, s.db, func(tx *gorm.DB) (err error) {
+		var previousUser model.User
+		err = tx.
+			WithContext(ctx).
+			Select("id", "first_name", "last_name", "username").
+			Where("id = ?", userID).
+			First(&previousUser).
+			Error
+		if err != nil {
+			return err
+		}
+		previousPictureKey = DefaultProfilePictureKey(previousUser)
 
-	user, err := s.updateUserInternal(ctx, userID, updatedUser, updateOwnUser, isLdapSync, tx)
+		user, err = s.updateUserInternal(ctx, userID, updatedUser, updateOwnUser, isLdapSync, tx)
+		return err
+	})
 	if err != nil {
 		return model.User{}, err
 	}
 
-	err = tx.Commit().Error
-	if err != nil {
-		return model.User{}, err
+	// If the default profile picture changed, e.g. because of new initials, replace it unless the user uploaded a custom one
+	pictureKey := DefaultProfilePictureKey(user)
+	if previousPictureKey != pictureKey && !hasCustomProfilePicture(user.ID) {
+		err = regenerateDefaultProfilePicture(ctx, previousPictureKey, pictureKey)
+		if err != nil {
+			// The picture is created on demand anyway, so this doesn't fail the update
+			slog.WarnContext(ctx, "Failed to regenerate default profile picture", slog.String("userID", user.ID), slog.Any("error", err))
+		}
 	}
 
 	return user, nil
@@ -305,25 +557,55 @@ func (s *UserService) updateUserInternal(ctx context.Context, userID string, upd
 	isLdapUser := user.LdapID != nil && s.appConfigService.GetDbConfig().LdapEnabled.IsTrue()
 	allowOwnAccountEdit := s.appConfigService.GetDbConfig().AllowOwnAccountEdit.IsTrue()
 
+	// The locale is only validated if it changed, so users keep their locale if it's removed from the supported ones
+	locale := user.Locale
+	if updatedUser.Locale == nil || user.Locale == nil || *updatedUser.Locale != *user.Locale {
+		locale, err = s.checkSupportedLocale(updatedUser.Locale)
+		if err != nil {
+			return model.User{}, err
+		}
+	}
+
 	if !isLdapSync && (isLdapUser || (!allowOwnAccountEdit && updateOwnUser)) {
 		// Restricted update: Only locale can be changed when:
 		// - User is from LDAP, OR
 		// - User is editing their own account but global setting disallows self-editing
 		// (Exception: LDAP sync operations can update everything)
-		user.Locale = updatedUser.Locale
+		user.Locale = locale
 	} else {
 		// Full update: Allow updating all personal fields
+		if !strings.EqualFold(updatedUser.Email, user.Email) {
+			err = s.checkAllowedEmailDomain(updatedUser.Email, isLdapSync)
+			if err != nil {
+				return model.User{}, err
+			}
+		}
+
 		user.FirstName = updatedUser.FirstName
 		user.LastName = updatedUser.LastName
 		user.Email = updatedUser.Email
 		user.Username = updatedUser.Username
-		user.Locale = updatedUser.Locale
+		user.Locale = locale
+		s.normalizeNames(&user)
 
 		// Admin-only fields: Only allow updates when not updating own account
 		if !updateOwnUser {
 			user.IsAdmin = updatedUser.IsAdmin
 			user.Disabled = updatedUser.Disabled
 		}
+
+		// LDAP doesn't provide a login email, so the sync keeps the one that was set in Pocket ID
+		// The same applies to updates that omit the login email
+		if !isLdapSync && updatedUser.LoginEmail != nil && s.appConfigService.GetDbConfig().LoginEmailEnabled.IsTrue() {
+			user.LoginEmail = loginEmailFromInput(updatedUser)
+		}
+	}
+
+	if s.appConfigService.GetDbConfig().LoginEmailEnabled.IsTrue() {
+		err = s.checkLoginEmailConflicts(ctx, user, tx)
+		if err != nil {
+			return model.User{}, err
+		}
 	}
 
 	err = tx.
@@ -354,6 +636,11 @@ func (s *UserService) RequestOneTimeAccessEmailAsAdmin(ctx context.Context, user
 		return &common.OneTimeAccessDisabledError{}
 	}
 
+	err := s.checkEmailTransport(ctx)
+	if err != nil {
+		return err
+	}
+
 	return s.requestOneTimeAccessEmailInternal(ctx, userID, "", expiration)
 }
 
@@ -363,8 +650,25 @@ func (s *UserService) RequestOneTimeAccessEmailAsUnauthenticatedUser(ctx context
 		return &common.OneTimeAccessDisabledError{}
 	}
 
+	// The transport is checked before looking up the user, so the response doesn't reveal whether the email address exists
+	err := s.checkEmailTransport(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := s.db.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Select("id")
+	if s.appConfigService.GetDbConfig().LoginEmailEnabled.IsTrue() {
+		// Users with a login email can only be looked up by it
+		query = query.Where("login_email = ? OR (login_email IS NULL AND email = ?)", userID, userID)
+	} else {
+		query = query.Where("email = ?", userID)
+	}
+
 	var userId string
-	err := s.db.Model(&model.User{}).Select("id").Where("email = ?", userID).First(&userId).Error
+	err = query.First(&userId).Error
 	if err != nil {
 		// Do not return error if user not found to prevent email enumeration
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -378,6 +682,22 @@ func (s *UserService) RequestOneTimeAccessEmailAsUnauthenticatedUser(ctx context
 	return s.requestOneTimeAccessEmailInternal(ctx, userId, redirectPath, expiration)
 }
 
+// checkEmailTransport checks that the SMTP server is available if requests must fail when emails can't be sent.
+// Otherwise, emails are queued and retried until the SMTP server is available again.
+func (s *UserService) checkEmailTransport(ctx context.Context) error {
+	if s.appConfigService.GetDbConfig().EmailUnavailableBehavior.Value != "fail" {
+		return nil
+	}
+
+	err := s.emailService.VerifyTransport(ctx)
+	if err != nil {
+		slog.WarnContext(ctx, "Can't send email because the SMTP server is unavailable", slog.Any("error", err))
+		return &common.EmailUnavailableError{}
+	}
+
+	return nil
+}
+
 func (s *UserService) requestOneTimeAccessEmailInternal(ctx context.Context, userID, redirectPath string, expiration time.Time) error {
 	tx := s.db.Begin()
 	defer func() {
@@ -394,40 +714,60 @@ func (s *UserService) requestOneTimeAccessEmailInternal(ctx context.Context, use
 		return err
 	}
 
-	err = tx.Commit().Error
+	// The email is sent by the outbox worker once the transaction has been committed
+	err = s.outboxService.Enqueue(ctx, outboxMessageOneTimeAccessEmail, oneTimeAccessEmailPayload{
+		UserID:       user.ID,
+		Code:         oneTimeAccessToken,
+		RedirectPath: redirectPath,
+		ExpiresAt:    expiration,
+	}, tx)
 	if err != nil {
 		return err
 	}
 
-	// We use a background context here as this is running in a goroutine
-	//nolint:contextcheck
-	go func() {
-		span := trace.SpanFromContext(ctx)
-		innerCtx := trace.ContextWithSpan(context.Background(), span)
-
-		link := common.EnvConfig.AppURL + "/lc"
-		linkWithCode := link + "/" + oneTimeAccessToken
-
-		// Add redirect path to the link
-		if strings.HasPrefix(redirectPath, "/") {
-			encodedRedirectPath := url.QueryEscape(redirectPath)
-			linkWithCode = linkWithCode + "?redirect=" + encodedRedirectPath
-		}
-
-		errInternal := SendEmail(innerCtx, s.emailService, email.Address{
-			Name:  user.FullName(),
-			Email: user.Email,
-		}, OneTimeAccessTemplate, &OneTimeAccessTemplateData{
-			Code:              oneTimeAccessToken,
-			LoginLink:         link,
-			LoginLinkWithCode: linkWithCode,
-			ExpirationString:  utils.DurationToString(time.Until(expiration).Round(time.Second)),
-		})
-		if errInternal != nil {
-			slog.ErrorContext(innerCtx, "Failed to send one-time access token email", slog.Any("error", errInternal), slog.String("address", user.Email))
-			return
-		}
-	}()
+	return tx.Commit().Error
+}
+
+func (s *UserService) sendOneTimeAccessEmail(ctx context.Context, payload oneTimeAccessEmailPayload) error {
+	if time.Now().After(payload.ExpiresAt) {
+		// The token has expired before the email could be sent
+		return nil
+	}
+
+	var user model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ?", payload.UserID).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load user from database to send one-time access token email: %w", err)
+	}
+
+	link := common.EnvConfig.AppURL + "/lc"
+	linkWithCode := link + "/" + payload.Code
+
+	// Add redirect path to the link
+	if strings.HasPrefix(payload.RedirectPath, "/") {
+		encodedRedirectPath := url.QueryEscape(payload.RedirectPath)
+		linkWithCode = linkWithCode + "?redirect=" + encodedRedirectPath
+	}
+
+	err = SendEmail(ctx, s.emailService, email.Address{
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+	}, OneTimeAccessTemplate, &OneTimeAccessTemplateData{
+		Code:              payload.Code,
+		LoginLink:         link,
+		LoginLinkWithCode: linkWithCode,
+		ExpirationString:  utils.DurationToString(time.Until(payload.ExpiresAt).Round(time.Second)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send one-time access token email to '%s': %w", user.Email, err)
+	}
 
 	return nil
 }
@@ -449,25 +789,79 @@ func (s *UserService) createOneTimeAccessTokenInternal(ctx context.Context, user
 	return oneTimeAccessToken.Token, nil
 }
 
+// PeekOneTimeAccessToken returns whether the one-time access token exists and hasn't expired, without consuming it.
+// The user of the token isn't returned, so the token can't be used to find out who it belongs to.
+// Unknown tokens count as failed attempts like when exchanging them, so checking tokens doesn't allow more guesses.
+func (s *UserService) PeekOneTimeAccessToken(ctx context.Context, token string, ipAddress, userAgent string) (bool, error) {
+	err := s.checkOneTimeAccessLockout(ctx, ipAddress, s.db)
+	if err != nil {
+		return false, err
+	}
+
+	var count int64
+	err = s.db.
+		WithContext(ctx).
+		Model(&model.OneTimeAccessToken{}).
+		Where("token = ? AND expires_at > ?", token, datatype.DateTime(time.Now())).
+		Count(&count).
+		Error
+	if err != nil {
+		return false, err
+	}
+
+	if count == 0 {
+		err = s.recordOneTimeAccessFailure(ctx, ipAddress, userAgent)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to record failed one-time access attempt", slog.Any("error", err))
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
 func (s *UserService) ExchangeOneTimeAccessToken(ctx context.Context, token string, ipAddress, userAgent string) (model.User, string, error) {
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
 	}()
 
+	// IP addresses with too many failed attempts can't try again for a while, even with a valid token
+	err := s.checkOneTimeAccessLockout(ctx, ipAddress, tx)
+	if err != nil {
+		return model.User{}, "", err
+	}
+
 	var oneTimeAccessToken model.OneTimeAccessToken
-	err := tx.
+	err = tx.
 		WithContext(ctx).
 		Where("token = ? AND expires_at > ?", token, datatype.DateTime(time.Now())).Preload("User").
 		First(&oneTimeAccessToken).
 		Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Release the transaction first, as SQLite allows only one writer
+			tx.Rollback()
+			err = s.recordOneTimeAccessFailure(ctx, ipAddress, userAgent)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to record failed one-time access attempt", slog.Any("error", err))
+			}
 			return model.User{}, "", &common.TokenInvalidOrExpiredError{}
 		}
 		return model.User{}, "", err
 	}
-	accessToken, err := s.jwtService.GenerateAccessToken(oneTimeAccessToken.User)
+	err = s.appConfigService.CheckMaintenanceMode(&oneTimeAccessToken.User)
+	if err != nil {
+		return model.User{}, "", err
+	}
+	err = s.auditLogService.CheckSignInLocation(ctx, ipAddress, &oneTimeAccessToken.User)
+	if err != nil {
+		// Release the transaction first, as SQLite allows only one writer
+		tx.Rollback()
+		s.auditLogService.LogSignInGeoblocked(ctx, err, ipAddress, userAgent, &oneTimeAccessToken.User)
+		return model.User{}, "", err
+	}
+	accessToken, err := s.jwtService.GenerateAccessToken(oneTimeAccessToken.User, []string{AmrOneTimeCode})
 	if err != nil {
 		return model.User{}, "", err
 	}
@@ -480,6 +874,18 @@ func (s *UserService) ExchangeOneTimeAccessToken(ctx context.Context, token stri
 		return model.User{}, "", err
 	}
 
+	s.auditLogService.EnqueueSuspiciousSignInCheck(ctx, &oneTimeAccessToken.User, ipAddress, userAgent, tx)
+
+	err = updateLastLoginInternal(ctx, oneTimeAccessToken.User.ID, ipAddress, tx)
+	if err != nil {
+		return model.User{}, "", err
+	}
+
+	err = clearOneTimeAccessFailuresInternal(ctx, ipAddress, tx)
+	if err != nil {
+		return model.User{}, "", err
+	}
+
 	s.auditLogService.Create(ctx, model.AuditLogEventOneTimeAccessTokenSignIn, ipAddress, userAgent, oneTimeAccessToken.User.ID, model.AuditLogData{}, tx)
 
 	err = tx.Commit().Error
@@ -552,6 +958,11 @@ func (s *UserService) SignUpInitialAdmin(ctx context.Context, signUpData dto.Sig
 		return model.User{}, "", &common.SetupAlreadyCompletedError{}
 	}
 
+	setupSecret := common.EnvConfig.SetupSecret
+	if setupSecret != "" && subtle.ConstantTimeCompare([]byte(signUpData.SetupSecret), []byte(setupSecret)) != 1 {
+		return model.User{}, "", &common.InvalidSetupSecretError{}
+	}
+
 	userToCreate := dto.UserCreateDto{
 		FirstName: signUpData.FirstName,
 		LastName:  signUpData.LastName,
@@ -565,7 +976,7 @@ func (s *UserService) SignUpInitialAdmin(ctx context.Context, signUpData dto.Sig
 		return model.User{}, "", err
 	}
 
-	token, err := s.jwtService.GenerateAccessToken(user)
+	token, err := s.jwtService.GenerateAccessToken(user, nil)
 	if err != nil {
 		return model.User{}, "", err
 	}
@@ -578,7 +989,75 @@ func (s *UserService) SignUpInitialAdmin(ctx context.Context, signUpData dto.Sig
 	return user, token, nil
 }
 
+// WarnIfInitialSetupUnprotected logs a warning if the initial admin hasn't been created yet and anyone can do so
+func (s *UserService) WarnIfInitialSetupUnprotected(ctx context.Context) error {
+	if common.EnvConfig.SetupSecret != "" {
+		return nil
+	}
+
+	var userCount int64
+	err := s.db.WithContext(ctx).Model(&model.User{}).Count(&userCount).Error
+	if err != nil {
+		return err
+	}
+	if userCount == 0 {
+		slog.WarnContext(ctx, "No setup secret is configured: anyone who can reach this instance can sign up as the initial admin until the setup is completed. Set SETUP_SECRET to protect the setup.")
+	}
+
+	return nil
+}
+
+// generateUsernameFromEmail derives a unique username from the local part of the email address.
+// If the username is already taken, a numeric suffix is appended.
+func (s *UserService) generateUsernameFromEmail(ctx context.Context, email string, tx *gorm.DB) (string, error) {
+	if !s.appConfigService.GetDbConfig().GenerateUsernameFromEmail.IsTrue() {
+		return "", &common.ValidationError{Message: "username is required"}
+	}
+
+	// Leave room for the numeric suffix
+	base := utils.UsernameFromEmail(email, maxUsernameLength-len(strconv.Itoa(maxGeneratedUsernameAttempts)))
+	if len(base) < 2 {
+		base = "user"
+	}
+
+	for i := 1; i <= maxGeneratedUsernameAttempts; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = base + strconv.Itoa(i)
+		}
+
+		var count int64
+		err := tx.
+			WithContext(ctx).
+			Model(&model.User{}).
+			Where("username = ?", candidate).
+			Count(&count).
+			Error
+		if err != nil {
+			return "", fmt.Errorf("failed to check if username '%s' is in use: %w", candidate, err)
+		}
+
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+
+	return "", &common.AlreadyInUseError{Property: "username"}
+}
+
+// normalizeNames applies the configured Unicode normalization form to the names and the username of the user,
+// so that values that only differ by the composition of their characters are stored the same way
+func (s *UserService) normalizeNames(user *model.User) {
+	form := s.appConfigService.GetDbConfig().UnicodeNormalizationForm.Value
+	user.FirstName = utils.NormalizeUnicode(user.FirstName, form)
+	user.LastName = utils.NormalizeUnicode(user.LastName, form)
+	user.Username = utils.NormalizeUnicode(user.Username, form)
+}
+
 func (s *UserService) checkDuplicatedFields(ctx context.Context, user model.User, tx *gorm.DB) error {
+	// Compare the normalized username, so that a different composition can't be used to bypass the uniqueness
+	s.normalizeNames(&user)
+
 	var result struct {
 		Found bool
 	}
@@ -606,9 +1085,67 @@ func (s *UserService) checkDuplicatedFields(ctx context.Context, user model.User
 		return &common.AlreadyInUseError{Property: "username"}
 	}
 
+	if user.LoginEmail != nil {
+		err = tx.
+			WithContext(ctx).
+			Raw(`SELECT EXISTS(SELECT 1 FROM users WHERE id != ? AND login_email = ?) AS found`, user.ID, *user.LoginEmail).
+			First(&result).
+			Error
+		if err != nil {
+			return err
+		}
+		if result.Found {
+			return &common.AlreadyInUseError{Property: "login email"}
+		}
+	}
+
 	return nil
 }
 
+// checkLoginEmailConflicts ensures that a login email is not the email or login email of another user, and vice versa.
+// The unique constraints of the columns don't cover this, but one-time access emails are looked up by both.
+func (s *UserService) checkLoginEmailConflicts(ctx context.Context, user model.User, tx *gorm.DB) error {
+	var result struct {
+		Found bool
+	}
+
+	if user.LoginEmail != nil {
+		err := tx.
+			WithContext(ctx).
+			Raw(`SELECT EXISTS(SELECT 1 FROM users WHERE id != ? AND (login_email = ? OR email = ?)) AS found`, user.ID, *user.LoginEmail, *user.LoginEmail).
+			First(&result).
+			Error
+		if err != nil {
+			return err
+		}
+		if result.Found {
+			return &common.AlreadyInUseError{Property: "login email"}
+		}
+	}
+
+	err := tx.
+		WithContext(ctx).
+		Raw(`SELECT EXISTS(SELECT 1 FROM users WHERE id != ? AND login_email = ?) AS found`, user.ID, user.Email).
+		First(&result).
+		Error
+	if err != nil {
+		return err
+	}
+	if result.Found {
+		return &common.AlreadyInUseError{Property: "email"}
+	}
+
+	return nil
+}
+
+// loginEmailFromInput returns the login email of the input, or nil if the user logs in with the email
+func loginEmailFromInput(input dto.UserCreateDto) *string {
+	if input.LoginEmail == nil || *input.LoginEmail == "" || *input.LoginEmail == input.Email {
+		return nil
+	}
+	return utils.Ptr(*input.LoginEmail)
+}
+
 // ResetProfilePicture deletes a user's custom profile picture
 func (s *UserService) ResetProfilePicture(userID string) error {
 	// Validate the user ID to prevent directory traversal
@@ -642,6 +1179,26 @@ func (s *UserService) disableUserInternal(ctx context.Context, userID string, tx
 		Error
 }
 
+// updateLastLoginInternal records a successful authentication of the user, which resets the inactivity of the account.
+// The IP address is only updated if it isn't empty, as authentications that aren't made by the user's device, like refreshing tokens, don't know it.
+// Only the affected columns are updated, so that the rest of the user isn't saved again.
+func updateLastLoginInternal(ctx context.Context, userID string, ipAddress string, tx *gorm.DB) error {
+	columns := map[string]any{
+		"last_login_at":         datatype.DateTime(time.Now()),
+		"inactivity_email_sent": false,
+	}
+	if ipAddress != "" {
+		columns["last_login_ip"] = ipAddress
+	}
+
+	return tx.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", userID).
+		UpdateColumns(columns).
+		Error
+}
+
 func (s *UserService) CreateSignupToken(ctx context.Context, expiresAt time.Time, usageLimit int) (model.SignupToken, error) {
 	return s.createSignupTokenInternal(ctx, expiresAt, usageLimit, s.db)
 }
@@ -659,12 +1216,7 @@ func (s *UserService) createSignupTokenInternal(ctx context.Context, expiresAt t
 	return *signupToken, nil
 }
 
-func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAddress, userAgent string) (model.User, string, error) {
-	tx := s.db.Begin()
-	defer func() {
-		tx.Rollback()
-	}()
-
+func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAddress, userAgent string) (user model.User, accessToken string, err error) {
 	tokenProvided := signupData.Token != ""
 
 	config := s.appConfigService.GetDbConfig()
@@ -672,6 +1224,76 @@ func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAd
 		return model.User{}, "", &common.OpenSignupDisabledError{}
 	}
 
+	err = utils.WithRetryableTransaction(ctx, s.db, func(tx *gorm.DB) (err error) {
+		user, accessToken, err = s.signUpInternal(ctx, signupData, ipAddress, userAgent, tx)
+		return err
+	})
+	if err != nil {
+		return model.User{}, "", err
+	}
+
+	return user, accessToken, nil
+}
+
+// checkSupportedLocale returns the locale as written in the list of supported locales, or an error if it isn't supported.
+// Empty locales are stored as nil, so the default locale is used for the user.
+func (s *UserService) checkSupportedLocale(locale *string) (*string, error) {
+	if locale == nil || *locale == "" {
+		return nil, nil
+	}
+
+	supported, ok := s.appConfigService.GetDbConfig().MatchSupportedLocale(*locale)
+	if !ok {
+		return nil, &common.UnsupportedLocaleError{Locale: *locale}
+	}
+	return &supported, nil
+}
+
+// checkAllowedEmailDomain rejects the email addresses whose domain isn't in the allow-list, if one is configured.
+// Users synced from LDAP are exempt unless the exemption is disabled, as the directory is the source of truth for them.
+func (s *UserService) checkAllowedEmailDomain(email string, isLdapSync bool) error {
+	config := s.appConfigService.GetDbConfig()
+	if isLdapSync && config.AllowedEmailDomainsExemptLdap.IsTrue() {
+		return nil
+	}
+
+	allowedDomains := utils.ParseDomainList(config.AllowedEmailDomains.Value)
+	if len(allowedDomains) == 0 {
+		return nil
+	}
+
+	domain := utils.EmailDomain(email)
+	if !utils.DomainMatchesAny(domain, allowedDomains) {
+		return &common.EmailDomainNotAllowedError{Domain: domain}
+	}
+	return nil
+}
+
+// checkSignupEmailDomain rejects the email addresses of the blocked domains, and of disposable email providers if enabled.
+// The lists are read from the configuration on every signup, so changes apply without a restart.
+func (s *UserService) checkSignupEmailDomain(email string) error {
+	config := s.appConfigService.GetDbConfig()
+	domain := utils.EmailDomain(email)
+
+	blocked := utils.DomainMatchesAny(domain, utils.ParseDomainList(config.SignupBlockedEmailDomains.Value))
+	if !blocked && config.SignupBlockDisposableEmailDomains.IsTrue() {
+		blocked = utils.IsDisposableEmailDomain(domain)
+	}
+	if blocked {
+		return &common.EmailDomainNotAllowedError{Domain: domain}
+	}
+	return nil
+}
+
+func (s *UserService) signUpInternal(ctx context.Context, signupData dto.SignUpDto, ipAddress, userAgent string, tx *gorm.DB) (model.User, string, error) {
+	// New users aren't admins, so they can't sign in during maintenance
+	err := s.appConfigService.CheckMaintenanceMode(nil)
+	if err != nil {
+		return model.User{}, "", err
+	}
+
+	tokenProvided := signupData.Token != ""
+
 	var signupToken model.SignupToken
 	if tokenProvided {
 		err := tx.
@@ -689,6 +1311,11 @@ func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAd
 		if !signupToken.IsValid() {
 			return model.User{}, "", &common.TokenInvalidOrExpiredError{}
 		}
+	} else {
+		err := s.checkSignupEmailDomain(signupData.Email)
+		if err != nil {
+			return model.User{}, "", err
+		}
 	}
 
 	userToCreate := dto.UserCreateDto{
@@ -703,7 +1330,12 @@ func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAd
 		return model.User{}, "", err
 	}
 
-	accessToken, err := s.jwtService.GenerateAccessToken(user)
+	err = s.enqueueWelcomeEmail(ctx, user, tx)
+	if err != nil {
+		return model.User{}, "", err
+	}
+
+	accessToken, err := s.jwtService.GenerateAccessToken(user, nil)
 	if err != nil {
 		return model.User{}, "", err
 	}
@@ -726,11 +1358,6 @@ func (s *UserService) SignUp(ctx context.Context, signupData dto.SignUpDto, ipAd
 		}, tx)
 	}
 
-	err = tx.Commit().Error
-	if err != nil {
-		return model.User{}, "", err
-	}
-
 	return user, accessToken, nil
 }
 
@@ -749,8 +1376,8 @@ func (s *UserService) DeleteSignupToken(ctx context.Context, tokenID string) err
 func NewOneTimeAccessToken(userID string, expiresAt time.Time) (*model.OneTimeAccessToken, error) {
 	// If expires at is less than 15 minutes, use a 6-character token instead of 16
 	tokenLength := 16
-	if time.Until(expiresAt) <= 15*time.Minute {
-		tokenLength = 6
+	if time.Until(expiresAt) <= shortOneTimeAccessTokenMaxAge {
+		tokenLength = shortOneTimeAccessTokenLength
 	}
 
 	randomString, err := utils.GenerateRandomAlphanumericString(tokenLength)
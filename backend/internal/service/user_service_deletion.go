@@ -0,0 +1,220 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+)
+
+const (
+	// How long users have to confirm the deletion of their account
+	accountDeletionTokenDuration = 15 * time.Minute
+	// Type of the outbox messages that send the email containing the confirmation token
+	outboxMessageAccountDeletionEmail = "accountDeletionEmail"
+)
+
+// accountDeletionEmailPayload is the payload of the outbox messages that send the account deletion email
+type accountDeletionEmailPayload struct {
+	UserID    string    `json:"userId"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// RequestAccountDeletion starts the deletion of the user's own account.
+// The token that must be passed to ConfirmAccountDeletion to actually delete the account is sent to the user's email address,
+// so a stolen session alone isn't enough to delete the account.
+func (s *UserService) RequestAccountDeletion(ctx context.Context, userID, ipAddress, userAgent string) error {
+	if !s.appConfigService.GetDbConfig().AllowUserSelfDeletion.IsTrue() {
+		return &common.AccountSelfDeletionDisabledError{}
+	}
+
+	err := s.checkEmailTransport(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	err = s.checkAccountSelfDeletionAllowed(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateRandomAlphanumericString(16)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(accountDeletionTokenDuration)
+
+	// Only the latest request is valid
+	err = tx.
+		WithContext(ctx).
+		Delete(&model.AccountDeletionToken{}, "user_id = ?", userID).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to delete previous account deletion tokens: %w", err)
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Create(&model.AccountDeletionToken{
+			TokenHash: utils.CreateSha256Hash(token),
+			ExpiresAt: datatype.DateTime(expiresAt),
+			UserID:    userID,
+		}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to store account deletion token: %w", err)
+	}
+
+	// The email is sent by the outbox worker once the transaction has been committed
+	err = s.outboxService.Enqueue(ctx, outboxMessageAccountDeletionEmail, accountDeletionEmailPayload{
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: expiresAt,
+	}, tx)
+	if err != nil {
+		return err
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventAccountDeletionRequested, ipAddress, userAgent, userID, model.AuditLogData{}, tx)
+
+	return tx.Commit().Error
+}
+
+func (s *UserService) sendAccountDeletionEmail(ctx context.Context, payload accountDeletionEmailPayload) error {
+	if time.Now().After(payload.ExpiresAt) {
+		// The token has expired before the email could be sent
+		return nil
+	}
+
+	var user model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ?", payload.UserID).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load user from database to send account deletion email: %w", err)
+	}
+
+	err = SendEmail(ctx, s.emailService, email.Address{
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+	}, AccountDeletionTemplate, &AccountDeletionTemplateData{
+		Name:             user.FirstName,
+		Token:            payload.Token,
+		ExpirationString: utils.DurationToString(time.Until(payload.ExpiresAt).Round(time.Second)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send account deletion email to '%s': %w", user.Email, err)
+	}
+
+	return nil
+}
+
+// ConfirmAccountDeletion deletes the user's own account, if the token sent by RequestAccountDeletion is valid
+func (s *UserService) ConfirmAccountDeletion(ctx context.Context, userID, token, ipAddress, userAgent string) error {
+	if !s.appConfigService.GetDbConfig().AllowUserSelfDeletion.IsTrue() {
+		return &common.AccountSelfDeletionDisabledError{}
+	}
+
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	var deletionToken model.AccountDeletionToken
+	err := tx.
+		WithContext(ctx).
+		Where("token_hash = ? AND user_id = ? AND expires_at > ?", utils.CreateSha256Hash(token), userID, datatype.DateTime(time.Now())).
+		First(&deletionToken).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &common.TokenInvalidOrExpiredError{}
+	} else if err != nil {
+		return err
+	}
+
+	// The checks are repeated as things may have changed since the request
+	err = s.checkAccountSelfDeletionAllowed(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	user, err := s.getUserInternal(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	// These are deleted explicitly as foreign keys may not be enforced
+	for _, m := range []any{&model.OidcRefreshToken{}, &model.UserAuthorizedOidcClient{}, &model.AccountDeletionToken{}, &model.UserNotificationPreference{}} {
+		err = tx.
+			WithContext(ctx).
+			Delete(m, "user_id = ?", userID).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to delete data of user: %w", err)
+		}
+	}
+
+	// The audit log is created before the user is deleted, as it must reference an existing user
+	// It keeps the username and email, so it's still known who deleted their account
+	s.auditLogService.Create(ctx, model.AuditLogEventAccountDeleted, ipAddress, userAgent, userID, model.AuditLogData{
+		"username": user.Username,
+		"email":    user.Email,
+	}, tx)
+
+	err = s.deleteUserInternal(ctx, userID, false, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// checkAccountSelfDeletionAllowed returns an error if the user can't delete their own account
+func (s *UserService) checkAccountSelfDeletionAllowed(ctx context.Context, userID string, tx *gorm.DB) error {
+	user, err := s.getUserInternal(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	// LDAP users are managed by the LDAP server
+	if user.LdapID != nil && s.appConfigService.GetDbConfig().LdapEnabled.IsTrue() {
+		return &common.LdapUserUpdateError{}
+	}
+
+	if user.IsAdmin {
+		var adminCount int64
+		err = tx.
+			WithContext(ctx).
+			Model(&model.User{}).
+			Where("is_admin = ? AND disabled = ?", true, false).
+			Count(&adminCount).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to count admins: %w", err)
+		}
+		if adminCount <= 1 {
+			return &common.LastAdminDeletionError{}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// Number of audit log entries that are loaded at once when exporting a user's data
+const userExportAuditLogBatchSize = 500
+
+// ExportUserData writes a JSON document with all data stored about the user to w.
+// Secrets such as tokens, hashes and public keys are never included.
+// The user is loaded before anything is written, so an error is returned before the output starts if the user doesn't exist.
+func (s *UserService) ExportUserData(ctx context.Context, userID string, w io.Writer) error {
+	user, err := s.GetUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	var profile dto.UserDto
+	err = dto.MapStruct(user, &profile)
+	if err != nil {
+		return err
+	}
+
+	var authorizedClients []model.UserAuthorizedOidcClient
+	err = s.db.
+		WithContext(ctx).
+		Preload("Client").
+		Where("user_id = ?", userID).
+		Find(&authorizedClients).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load authorized clients: %w", err)
+	}
+	authorizedClientsDto := make([]dto.AuthorizedOidcClientDto, len(authorizedClients))
+	for i, ac := range authorizedClients {
+		authorizedClientsDto[i] = dto.AuthorizedOidcClientDto{
+			Scope: ac.Scope,
+			Client: dto.OidcClientMetaDataDto{
+				ID:      ac.Client.ID,
+				Name:    ac.Client.Name,
+				HasLogo: ac.Client.HasLogo,
+			},
+		}
+	}
+
+	var refreshTokens []model.OidcRefreshToken
+	err = s.db.
+		WithContext(ctx).
+		Preload("Client").
+		Where("user_id = ? AND expires_at > ?", userID, datatype.DateTime(time.Now())).
+		Find(&refreshTokens).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load refresh tokens: %w", err)
+	}
+	refreshTokensDto := make([]dto.UserExportRefreshTokenDto, len(refreshTokens))
+	for i, rt := range refreshTokens {
+		refreshTokensDto[i] = dto.UserExportRefreshTokenDto{
+			ID:         rt.ID,
+			ClientID:   rt.ClientID,
+			ClientName: rt.Client.Name,
+			Scope:      rt.Scope,
+			CreatedAt:  rt.CreatedAt,
+			ExpiresAt:  rt.ExpiresAt,
+		}
+	}
+
+	var credentials []model.WebauthnCredential
+	err = s.db.
+		WithContext(ctx).
+		Where("user_id = ?", userID).
+		Find(&credentials).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load passkeys: %w", err)
+	}
+	var credentialsDto []dto.WebauthnCredentialDto
+	err = dto.MapStructList(credentials, &credentialsDto)
+	if err != nil {
+		return err
+	}
+
+	// Write the document piece by piece, so the audit logs don't need to be loaded in memory at once
+	ew := &exportWriter{w: w}
+	ew.writeRaw(`{"exportedAt":`)
+	ew.writeJSON(time.Now().UTC())
+	ew.writeRaw(`,"profile":`)
+	ew.writeJSON(profile)
+	ew.writeRaw(`,"userGroups":`)
+	ew.writeJSON(profile.UserGroups)
+	ew.writeRaw(`,"customClaims":`)
+	ew.writeJSON(profile.CustomClaims)
+	ew.writeRaw(`,"authorizedClients":`)
+	ew.writeJSON(authorizedClientsDto)
+	ew.writeRaw(`,"refreshTokens":`)
+	ew.writeJSON(refreshTokensDto)
+	ew.writeRaw(`,"passkeys":`)
+	ew.writeJSON(credentialsDto)
+	ew.writeRaw(`,"auditLogs":[`)
+	if ew.err != nil {
+		return ew.err
+	}
+
+	// The audit logs are paged by their creation date and ID, because FindInBatches only pages by the primary key and would mix up the order
+	first := true
+	var last *model.AuditLog
+	for {
+		query := s.db.
+			WithContext(ctx).
+			Where("user_id = ?", userID)
+		if last != nil {
+			query = query.Where("created_at > ? OR (created_at = ? AND id > ?)", last.CreatedAt, last.CreatedAt, last.ID)
+		}
+
+		var batch []model.AuditLog
+		err = query.
+			Order("created_at ASC, id ASC").
+			Limit(userExportAuditLogBatchSize).
+			Find(&batch).
+			Error
+		if err != nil {
+			return fmt.Errorf("failed to export audit logs: %w", err)
+		}
+
+		var logsDto []dto.AuditLogDto
+		err = dto.MapStructList(batch, &logsDto)
+		if err != nil {
+			return fmt.Errorf("failed to export audit logs: %w", err)
+		}
+		for i := range logsDto {
+			logsDto[i].Device = s.auditLogService.DeviceStringFromUserAgent(batch[i].UserAgent)
+			logsDto[i].Username = user.Username
+
+			if !first {
+				ew.writeRaw(",")
+			}
+			first = false
+			ew.writeJSON(logsDto[i])
+		}
+		if ew.err != nil {
+			return ew.err
+		}
+
+		if len(batch) < userExportAuditLogBatchSize {
+			break
+		}
+		last = &batch[len(batch)-1]
+	}
+
+	ew.writeRaw("]}\n")
+	return ew.err
+}
+
+// exportWriter writes to the underlying writer until an error occurs
+type exportWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *exportWriter) writeRaw(s string) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = io.WriteString(ew.w, s)
+}
+
+func (ew *exportWriter) writeJSON(v any) {
+	if ew.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		ew.err = err
+		return
+	}
+	_, ew.err = ew.w.Write(data)
+}
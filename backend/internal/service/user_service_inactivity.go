@@ -0,0 +1,157 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+)
+
+// InactiveUsersResult is the outcome of a run of DisableInactiveUsers
+type InactiveUsersResult struct {
+	Disabled int
+	Warned   int
+}
+
+// DisableInactiveUsers disables the users who haven't signed in for the configured number of days, and warns the users who will be disabled soon by email.
+// Users who never signed in are inactive since their creation. Admins and the members of the exempt groups are skipped, if configured.
+func (s *UserService) DisableInactiveUsers(ctx context.Context) (InactiveUsersResult, error) {
+	dbConfig := s.appConfigService.GetDbConfig()
+
+	disableDays, _ := strconv.Atoi(dbConfig.InactiveUserDisableDays.Value)
+	if disableDays <= 0 {
+		return InactiveUsersResult{}, nil
+	}
+	disableBefore := time.Now().AddDate(0, 0, -disableDays)
+
+	var result InactiveUsersResult
+
+	users, err := s.listInactiveUsers(ctx, disableBefore, false)
+	if err != nil {
+		return result, fmt.Errorf("failed to list inactive users: %w", err)
+	}
+	for _, user := range users {
+		err = s.disableInactiveUser(ctx, user, disableDays)
+		if err != nil {
+			return result, fmt.Errorf("failed to disable inactive user '%s': %w", user.ID, err)
+		}
+		result.Disabled++
+	}
+
+	// The warning is sent once, when the user has been inactive for all but the configured number of days
+	warningDays, _ := strconv.Atoi(dbConfig.InactiveUserWarningDays.Value)
+	if warningDays <= 0 || warningDays >= disableDays {
+		return result, nil
+	}
+
+	users, err = s.listInactiveUsers(ctx, disableBefore.AddDate(0, 0, warningDays), true)
+	if err != nil {
+		return result, fmt.Errorf("failed to list users to warn about their inactivity: %w", err)
+	}
+	for _, user := range users {
+		err = s.sendInactiveUserWarningEmail(ctx, user, disableDays)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to send inactive user warning email", slog.String("user", user.ID), slog.Any("error", err))
+			continue
+		}
+		result.Warned++
+	}
+
+	return result, nil
+}
+
+// listInactiveUsers returns the enabled users who haven't signed in since the given time, without the exempt users.
+// If notWarned is true, only the users with an email address who haven't been warned yet are returned.
+func (s *UserService) listInactiveUsers(ctx context.Context, inactiveSince time.Time, notWarned bool) ([]model.User, error) {
+	dbConfig := s.appConfigService.GetDbConfig()
+
+	query := s.db.
+		WithContext(ctx).
+		Where("disabled = ?", false).
+		Where("COALESCE(last_login_at, created_at) < ?", datatype.DateTime(inactiveSince))
+
+	if dbConfig.InactiveUserExemptAdmins.IsTrue() {
+		query = query.Where("is_admin = ?", false)
+	}
+
+	exemptGroups := strings.FieldsFunc(dbConfig.InactiveUserExemptGroups.Value, func(r rune) bool {
+		return r == ','
+	})
+	for i := range exemptGroups {
+		exemptGroups[i] = strings.TrimSpace(exemptGroups[i])
+	}
+	if len(exemptGroups) > 0 {
+		exemptUserIDs := s.db.
+			Table("user_groups_users").
+			Select("user_groups_users.user_id").
+			Joins("JOIN user_groups ON user_groups.id = user_groups_users.user_group_id").
+			Where("user_groups.name IN ?", exemptGroups)
+		query = query.Where("id NOT IN (?)", exemptUserIDs)
+	}
+
+	if notWarned {
+		query = query.Where("inactivity_email_sent = ? AND email IS NOT NULL AND email <> ''", false)
+	}
+
+	var users []model.User
+	err := query.Find(&users).Error
+	return users, err
+}
+
+func (s *UserService) disableInactiveUser(ctx context.Context, user model.User, disableDays int) error {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	err := s.disableUserInternal(ctx, user.ID, tx)
+	if err != nil {
+		return err
+	}
+
+	lastLoginAt := "never"
+	if user.LastLoginAt != nil {
+		lastLoginAt = user.LastLoginAt.UTC().Format(time.RFC3339)
+	}
+	s.auditLogService.Create(ctx, model.AuditLogEventInactiveUserDisabled, "", "", user.ID, model.AuditLogData{
+		"lastLoginAt":  lastLoginAt,
+		"inactiveDays": strconv.Itoa(disableDays),
+	}, tx)
+
+	return tx.Commit().Error
+}
+
+func (s *UserService) sendInactiveUserWarningEmail(ctx context.Context, user model.User, disableDays int) error {
+	lastActivity := user.CreatedAt
+	if user.LastLoginAt != nil {
+		lastActivity = *user.LastLoginAt
+	}
+
+	err := SendEmail(ctx, s.emailService, email.Address{
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+	}, InactiveUserWarningTemplate, &InactiveUserWarningTemplateData{
+		Name:       user.FirstName,
+		DisabledAt: lastActivity.ToTime().AddDate(0, 0, disableDays),
+		LoginLink:  common.EnvConfig.AppURL + "/login",
+	})
+	if err != nil {
+		return err
+	}
+
+	// Mark the user as warned, until the next sign in
+	return s.db.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", user.ID).
+		UpdateColumn("inactivity_email_sent", true).
+		Error
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestUserService_DisableInactiveUsers(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfig := NewTestAppConfigService(&model.AppConfig{
+		InactiveUserDisableDays:  model.AppConfigVariable{Value: "90"},
+		InactiveUserExemptAdmins: model.AppConfigVariable{Value: "true"},
+		InactiveUserExemptGroups: model.AppConfigVariable{Value: "service-accounts, robots"},
+	})
+	s := &UserService{
+		db:               db,
+		appConfigService: appConfig,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfig},
+	}
+
+	daysAgo := func(days int) *datatype.DateTime {
+		dt := datatype.DateTime(time.Now().AddDate(0, 0, -days))
+		return &dt
+	}
+	createUser := func(t *testing.T, username string, lastLoginAt *datatype.DateTime, isAdmin bool) model.User {
+		t.Helper()
+		user := model.User{Username: username, Email: username + "@example.com", FirstName: username, IsAdmin: isAdmin, LastLoginAt: lastLoginAt}
+		require.NoError(t, db.Create(&user).Error)
+		return user
+	}
+
+	active := createUser(t, "active", daysAgo(10), false)
+	inactive := createUser(t, "inactive", daysAgo(120), false)
+	admin := createUser(t, "admin", daysAgo(120), true)
+	robot := createUser(t, "robot", daysAgo(120), false)
+	newUser := createUser(t, "new", nil, false)
+
+	// Users who never signed in are inactive since their creation
+	oldUser := createUser(t, "old", nil, false)
+	require.NoError(t, db.Model(&model.User{}).Where("id = ?", oldUser.ID).UpdateColumn("created_at", daysAgo(200)).Error)
+
+	group := model.UserGroup{Name: "robots", FriendlyName: "Robots", Users: []model.User{robot}}
+	require.NoError(t, db.Create(&group).Error)
+
+	isDisabled := func(t *testing.T, userID string) bool {
+		t.Helper()
+		var user model.User
+		require.NoError(t, db.First(&user, "id = ?", userID).Error)
+		return user.Disabled
+	}
+
+	result, err := s.DisableInactiveUsers(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Disabled)
+
+	assert.True(t, isDisabled(t, inactive.ID))
+	assert.True(t, isDisabled(t, oldUser.ID))
+	assert.False(t, isDisabled(t, active.ID))
+	assert.False(t, isDisabled(t, admin.ID), "admins are exempt")
+	assert.False(t, isDisabled(t, robot.ID), "members of exempt groups are exempt")
+	assert.False(t, isDisabled(t, newUser.ID))
+
+	var auditLogs []model.AuditLog
+	require.NoError(t, db.Where("event = ?", model.AuditLogEventInactiveUserDisabled).Find(&auditLogs).Error)
+	require.Len(t, auditLogs, 2)
+	for _, auditLog := range auditLogs {
+		assert.Equal(t, "90", auditLog.Data["inactiveDays"])
+	}
+
+	t.Run("does nothing if the policy is disabled", func(t *testing.T) {
+		createUser(t, "inactive-2", daysAgo(120), false)
+		disabledService := &UserService{db: db, appConfigService: NewTestAppConfigService(&model.AppConfig{})}
+
+		result, err := disabledService.DisableInactiveUsers(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, InactiveUsersResult{}, result)
+	})
+}
+
+func TestUpdateLastLoginInternal(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	user := model.User{Username: "alice", Email: "alice@example.com", FirstName: "Alice", InactivityEmailSent: true}
+	require.NoError(t, db.Create(&user).Error)
+
+	require.NoError(t, updateLastLoginInternal(t.Context(), user.ID, "203.0.113.7", db))
+
+	var updated model.User
+	require.NoError(t, db.First(&updated, "id = ?", user.ID).Error)
+	require.NotNil(t, updated.LastLoginAt)
+	assert.WithinDuration(t, time.Now(), updated.LastLoginAt.ToTime(), time.Minute)
+	assert.False(t, updated.InactivityEmailSent)
+	require.NotNil(t, updated.LastLoginIP)
+	assert.Equal(t, "203.0.113.7", *updated.LastLoginIP)
+
+	// Authentications without an IP address keep the previous one
+	require.NoError(t, updateLastLoginInternal(t.Context(), user.ID, "", db))
+	require.NoError(t, db.First(&updated, "id = ?", user.ID).Error)
+	require.NotNil(t, updated.LastLoginIP)
+	assert.Equal(t, "203.0.113.7", *updated.LastLoginIP)
+}
@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// Short one-time access codes can be guessed, so the failed attempts are limited per IP address and in total
+const (
+	// Failed attempts of an IP address are counted for this long, so it isn't locked out permanently
+	oneTimeAccessFailureWindow = time.Hour
+	// Number of failed attempts of an IP address before it's locked out
+	oneTimeAccessMaxFailures = 5
+	// The lockout starts with this duration and doubles with every further failed attempt, up to the maximum
+	oneTimeAccessBaseLockout = 30 * time.Second
+	oneTimeAccessMaxLockout  = 30 * time.Minute
+	// Once this number of failed attempts from all IP addresses is reached within the lifetime of short codes,
+	// the outstanding short codes are revoked, which limits the total number of guesses for each code.
+	// Only the attempts after the last revocation are counted
+	oneTimeAccessMaxTotalFailures = 100
+	shortOneTimeAccessTokenLength = 6
+	shortOneTimeAccessTokenMaxAge = 15 * time.Minute
+)
+
+// Key of the KV entry with the time the short one-time access codes were last revoked because of too many failed attempts
+const oneTimeAccessRevocationKVKey = "one_time_access_revocation_checkpoint"
+
+// checkOneTimeAccessLockout returns an error if the IP address is locked out because of too many failed attempts
+func (s *UserService) checkOneTimeAccessLockout(ctx context.Context, ipAddress string, tx *gorm.DB) error {
+	var result struct {
+		Failures     int
+		LastFailedAt *datatype.DateTime
+	}
+	err := tx.
+		WithContext(ctx).
+		Model(&model.OneTimeAccessFailure{}).
+		Select("COUNT(*) AS failures, MAX(created_at) AS last_failed_at").
+		Where("ip_address = ? AND created_at > ?", ipAddress, datatype.DateTime(time.Now().Add(-oneTimeAccessFailureWindow))).
+		Scan(&result).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to count failed one-time access attempts: %w", err)
+	}
+
+	if result.Failures < oneTimeAccessMaxFailures || result.LastFailedAt == nil {
+		return nil
+	}
+
+	retryAt := result.LastFailedAt.ToTime().Add(oneTimeAccessLockoutDuration(result.Failures))
+	if time.Now().Before(retryAt) {
+		return &common.OneTimeAccessLockedError{RetryAt: retryAt}
+	}
+	return nil
+}
+
+// recordOneTimeAccessFailure records a failed attempt of the IP address, and locks it out or revokes the short codes if the limits are reached
+func (s *UserService) recordOneTimeAccessFailure(ctx context.Context, ipAddress, userAgent string) error {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	err := tx.
+		WithContext(ctx).
+		Create(&model.OneTimeAccessFailure{IpAddress: ipAddress}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to record failed one-time access attempt: %w", err)
+	}
+
+	var failures int64
+	err = tx.
+		WithContext(ctx).
+		Model(&model.OneTimeAccessFailure{}).
+		Where("ip_address = ? AND created_at > ?", ipAddress, datatype.DateTime(time.Now().Add(-oneTimeAccessFailureWindow))).
+		Count(&failures).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to count failed one-time access attempts: %w", err)
+	}
+
+	if failures >= oneTimeAccessMaxFailures {
+		lockout := oneTimeAccessLockoutDuration(int(failures))
+		s.auditLogService.Create(ctx, model.AuditLogEventOneTimeAccessLockout, ipAddress, userAgent, "", model.AuditLogData{
+			"failures": strconv.FormatInt(failures, 10),
+			"retryAt":  time.Now().Add(lockout).UTC().Format(time.RFC3339),
+		}, tx)
+	}
+
+	// The attempts before the last revocation were made against codes that don't exist anymore
+	since := time.Now().Add(-shortOneTimeAccessTokenMaxAge)
+	lastRevokedAt, err := loadOneTimeAccessRevocationCheckpointInternal(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if lastRevokedAt.After(since) {
+		since = lastRevokedAt
+	}
+
+	var totalFailures int64
+	err = tx.
+		WithContext(ctx).
+		Model(&model.OneTimeAccessFailure{}).
+		Where("created_at > ?", datatype.DateTime(since)).
+		Count(&totalFailures).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to count failed one-time access attempts: %w", err)
+	}
+
+	if totalFailures >= oneTimeAccessMaxTotalFailures {
+		err = s.revokeShortOneTimeAccessTokensInternal(ctx, ipAddress, userAgent, tx)
+		if err != nil {
+			return err
+		}
+
+		err = saveOneTimeAccessRevocationCheckpointInternal(ctx, time.Now(), tx)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
+
+// revokeShortOneTimeAccessTokensInternal deletes the short one-time access codes that haven't expired yet; the users have to request new ones
+func (s *UserService) revokeShortOneTimeAccessTokensInternal(ctx context.Context, ipAddress, userAgent string, tx *gorm.DB) error {
+	var tokens []model.OneTimeAccessToken
+	err := tx.
+		WithContext(ctx).
+		Where("LENGTH(token) = ? AND expires_at > ?", shortOneTimeAccessTokenLength, datatype.DateTime(time.Now())).
+		Find(&tokens).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to load short one-time access tokens: %w", err)
+	}
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Delete(&tokens).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to delete short one-time access tokens: %w", err)
+	}
+
+	for _, token := range tokens {
+		s.auditLogService.Create(ctx, model.AuditLogEventOneTimeAccessCodeRevoked, ipAddress, userAgent, token.UserID, model.AuditLogData{
+			"reason": "tooManyFailedAttempts",
+		}, tx)
+	}
+
+	return nil
+}
+
+// loadOneTimeAccessRevocationCheckpointInternal returns the time the short codes were last revoked, or the zero time if they never were
+func loadOneTimeAccessRevocationCheckpointInternal(ctx context.Context, tx *gorm.DB) (time.Time, error) {
+	var row model.KV
+	err := tx.
+		WithContext(ctx).
+		Where("key = ?", oneTimeAccessRevocationKVKey).
+		First(&row).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to load one-time access revocation checkpoint: %w", err)
+	}
+
+	if row.Value == nil || *row.Value == "" {
+		return time.Time{}, nil
+	}
+
+	checkpoint, err := time.Parse(time.RFC3339Nano, *row.Value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to parse one-time access revocation checkpoint: %w", err)
+	}
+
+	return checkpoint, nil
+}
+
+func saveOneTimeAccessRevocationCheckpointInternal(ctx context.Context, checkpoint time.Time, tx *gorm.DB) error {
+	value := checkpoint.UTC().Format(time.RFC3339Nano)
+	err := tx.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&model.KV{Key: oneTimeAccessRevocationKVKey, Value: &value}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to save one-time access revocation checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+// clearOneTimeAccessFailuresInternal forgets the failed attempts of the IP address after a successful sign in
+func clearOneTimeAccessFailuresInternal(ctx context.Context, ipAddress string, tx *gorm.DB) error {
+	err := tx.
+		WithContext(ctx).
+		Where("ip_address = ?", ipAddress).
+		Delete(&model.OneTimeAccessFailure{}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to clear failed one-time access attempts: %w", err)
+	}
+	return nil
+}
+
+// oneTimeAccessLockoutDuration returns how long an IP address is locked out after the given number of failed attempts
+func oneTimeAccessLockoutDuration(failures int) time.Duration {
+	if failures < oneTimeAccessMaxFailures {
+		return 0
+	}
+
+	// Cap the exponent, so the duration doesn't overflow
+	exponent := min(failures-oneTimeAccessMaxFailures, 16)
+	return min(oneTimeAccessBaseLockout<<exponent, oneTimeAccessMaxLockout)
+}
@@ -0,0 +1,142 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestOneTimeAccessLockoutDuration(t *testing.T) {
+	assert.Zero(t, oneTimeAccessLockoutDuration(oneTimeAccessMaxFailures-1))
+	assert.Equal(t, 30*time.Second, oneTimeAccessLockoutDuration(oneTimeAccessMaxFailures))
+	assert.Equal(t, time.Minute, oneTimeAccessLockoutDuration(oneTimeAccessMaxFailures+1))
+	assert.Equal(t, oneTimeAccessMaxLockout, oneTimeAccessLockoutDuration(oneTimeAccessMaxFailures+100))
+}
+
+func TestUserService_OneTimeAccessLockout(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration: model.AppConfigVariable{Value: "60"},
+	})
+	jwtService := &JwtService{}
+	require.NoError(t, jwtService.init(db, appConfigService, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	}))
+	service := &UserService{
+		db:               db,
+		jwtService:       jwtService,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfigService},
+		appConfigService: appConfigService,
+	}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	createToken := func(t *testing.T, value string) {
+		t.Helper()
+		token := model.OneTimeAccessToken{Token: value, ExpiresAt: datatype.DateTime(time.Now().Add(10 * time.Minute)), UserID: user.ID}
+		require.NoError(t, db.Create(&token).Error)
+	}
+	countAuditLogs := func(t *testing.T, event model.AuditLogEvent) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, db.Model(&model.AuditLog{}).Where("event = ?", event).Count(&count).Error)
+		return count
+	}
+
+	t.Run("locks out the IP address after repeated failures", func(t *testing.T) {
+		createToken(t, "ABC123")
+
+		for range oneTimeAccessMaxFailures {
+			_, _, err := service.ExchangeOneTimeAccessToken(t.Context(), "WRONG1", "203.0.113.1", "")
+			require.ErrorIs(t, err, &common.TokenInvalidOrExpiredError{})
+		}
+		assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventOneTimeAccessLockout))
+
+		// Even the right code is rejected during the lockout
+		_, _, err := service.ExchangeOneTimeAccessToken(t.Context(), "ABC123", "203.0.113.1", "")
+		var lockedErr *common.OneTimeAccessLockedError
+		require.ErrorAs(t, err, &lockedErr)
+		assert.WithinDuration(t, time.Now().Add(oneTimeAccessBaseLockout), lockedErr.RetryAt, 5*time.Second)
+
+		// Other IP addresses aren't affected, and a successful sign in clears the failures
+		_, _, err = service.ExchangeOneTimeAccessToken(t.Context(), "ABC123", "203.0.113.2", "")
+		require.NoError(t, err)
+	})
+
+	t.Run("lets the IP address try again after the lockout", func(t *testing.T) {
+		createToken(t, "DEF456")
+		past := datatype.DateTime(time.Now().Add(-time.Minute))
+		require.NoError(t, db.Model(&model.OneTimeAccessFailure{}).Where("ip_address = ?", "203.0.113.1").Update("created_at", past).Error)
+
+		_, _, err := service.ExchangeOneTimeAccessToken(t.Context(), "DEF456", "203.0.113.1", "")
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessFailure{}).Where("ip_address = ?", "203.0.113.1").Count(&count).Error)
+		assert.Zero(t, count)
+	})
+
+	t.Run("revokes the short codes after too many failures in total", func(t *testing.T) {
+		createToken(t, "GHI789")
+		createToken(t, "long-token-not-revoked")
+
+		failures := make([]model.OneTimeAccessFailure, oneTimeAccessMaxTotalFailures-1)
+		for i := range failures {
+			failures[i].IpAddress = "198.51.100." + string(rune('0'+i%10))
+		}
+		require.NoError(t, db.Create(&failures).Error)
+
+		_, _, err := service.ExchangeOneTimeAccessToken(t.Context(), "WRONG2", "203.0.113.3", "")
+		require.ErrorIs(t, err, &common.TokenInvalidOrExpiredError{})
+
+		var tokens []string
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Pluck("token", &tokens).Error)
+		assert.Equal(t, []string{"long-token-not-revoked"}, tokens)
+		assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventOneTimeAccessCodeRevoked))
+
+		// Only the failures after the revocation count towards the next one
+		createToken(t, "MNO345")
+		_, _, err = service.ExchangeOneTimeAccessToken(t.Context(), "WRONG2", "203.0.113.3", "")
+		require.ErrorIs(t, err, &common.TokenInvalidOrExpiredError{})
+
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Pluck("token", &tokens).Error)
+		assert.ElementsMatch(t, []string{"long-token-not-revoked", "MNO345"}, tokens)
+		assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventOneTimeAccessCodeRevoked))
+
+		require.NoError(t, db.Delete(&model.OneTimeAccessToken{}, "token = ?", "MNO345").Error)
+	})
+
+	t.Run("counts failed checks of tokens", func(t *testing.T) {
+		createToken(t, "JKL012")
+
+		for range oneTimeAccessMaxFailures {
+			valid, err := service.PeekOneTimeAccessToken(t.Context(), "WRONG3", "203.0.113.4", "")
+			require.NoError(t, err)
+			assert.False(t, valid)
+		}
+
+		// Checking the right code and exchanging it is rejected during the lockout
+		var lockedErr *common.OneTimeAccessLockedError
+		_, err := service.PeekOneTimeAccessToken(t.Context(), "JKL012", "203.0.113.4", "")
+		require.ErrorAs(t, err, &lockedErr)
+		_, _, err = service.ExchangeOneTimeAccessToken(t.Context(), "JKL012", "203.0.113.4", "")
+		require.ErrorAs(t, err, &lockedErr)
+
+		// Valid tokens aren't counted as failures
+		valid, err := service.PeekOneTimeAccessToken(t.Context(), "JKL012", "203.0.113.5", "")
+		require.NoError(t, err)
+		assert.True(t, valid)
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessFailure{}).Where("ip_address = ?", "203.0.113.5").Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+)
+
+// RequirePasskeyReenrollment requires the user to enroll new passkeys, e.g. after a security incident.
+// The passkeys and the refresh tokens of the user are deleted, and existing sessions are no longer valid.
+// The user can still sign in with a one-time access token, and the requirement is cleared once a new passkey is added.
+func (s *UserService) RequirePasskeyReenrollment(ctx context.Context, userID, actorUserID, ipAddress, userAgent string) error {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	user, err := s.getUserInternal(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	now := datatype.DateTime(time.Now())
+	err = tx.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", user.ID).
+		Updates(map[string]any{
+			"passkey_reenrollment_required_at": &now,
+			"sessions_valid_after":             &now,
+		}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	err = tx.
+		WithContext(ctx).
+		Where("user_id = ?", user.ID).
+		Delete(&model.WebauthnCredential{}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to delete passkeys: %w", err)
+	}
+
+	// Clients must not be able to keep the session alive either
+	err = tx.
+		WithContext(ctx).
+		Where("user_id = ?", user.ID).
+		Delete(&model.OidcRefreshToken{}).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to delete refresh tokens: %w", err)
+	}
+
+	s.auditLogService.Create(ctx, model.AuditLogEventPasskeyReenrollmentRequired, ipAddress, userAgent, user.ID, model.AuditLogData{
+		"actorUserId": actorUserID,
+	}, tx)
+
+	return tx.Commit().Error
+}
+
+// CancelPasskeyReenrollment clears the requirement to enroll new passkeys; passkeys deleted when it was set aren't restored
+func (s *UserService) CancelPasskeyReenrollment(ctx context.Context, userID, actorUserID, ipAddress, userAgent string) error {
+	tx := s.db.Begin()
+	defer func() {
+		tx.Rollback()
+	}()
+
+	user, err := s.getUserInternal(ctx, userID, tx)
+	if err != nil {
+		return err
+	}
+
+	err = clearPasskeyReenrollmentInternal(ctx, s.auditLogService, &user, "canceled", actorUserID, ipAddress, userAgent, tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// clearPasskeyReenrollmentInternal clears the requirement to enroll new passkeys, if set, and records it in the audit log
+func clearPasskeyReenrollmentInternal(ctx context.Context, auditLogService *AuditLogService, user *model.User, reason, actorUserID, ipAddress, userAgent string, tx *gorm.DB) error {
+	if user.PasskeyReenrollmentRequiredAt == nil {
+		return nil
+	}
+
+	err := tx.
+		WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", user.ID).
+		Update("passkey_reenrollment_required_at", nil).
+		Error
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	user.PasskeyReenrollmentRequiredAt = nil
+
+	auditLogService.Create(ctx, model.AuditLogEventPasskeyReenrollmentCleared, ipAddress, userAgent, user.ID, model.AuditLogData{
+		"reason":      reason,
+		"actorUserId": actorUserID,
+	}, tx)
+
+	return nil
+}
@@ -0,0 +1,1042 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/dto"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	datatype "github.com/pocket-id/pocket-id/backend/internal/model/types"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	testutils "github.com/pocket-id/pocket-id/backend/internal/utils/testing"
+)
+
+func TestUserService_createUserInternal_GenerateUsername(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	newService := func(generateUsername string) *UserService {
+		return &UserService{
+			db: db,
+			appConfigService: NewTestAppConfigService(&model.AppConfig{
+				GenerateUsernameFromEmail: model.AppConfigVariable{Value: generateUsername},
+			}),
+		}
+	}
+
+	t.Run("username is required when generation is disabled", func(t *testing.T) {
+		service := newService("false")
+
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{
+			Email:     "john.doe@example.com",
+			FirstName: "John",
+		}, false, db)
+
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+
+	t.Run("generates usernames with a suffix on collision", func(t *testing.T) {
+		service := newService("true")
+
+		expected := []string{"john.doe", "john.doe2", "john.doe3"}
+		emails := []string{"john.doe@example.com", "John.Doe@example.org", "john.doe+test@example.net"}
+		for i, email := range emails {
+			user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{
+				Email:     email,
+				FirstName: "John",
+			}, false, db)
+			require.NoError(t, err)
+			assert.Equal(t, expected[i], user.Username)
+		}
+	})
+
+	t.Run("falls back to a generic username", func(t *testing.T) {
+		service := newService("true")
+
+		user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{
+			Email:     "!@example.com",
+			FirstName: "Jane",
+		}, false, db)
+		require.NoError(t, err)
+		assert.Equal(t, "user", user.Username)
+	})
+}
+
+func TestUserService_SignUpInitialAdmin_SetupSecret(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	originalSetupSecret := common.EnvConfig.SetupSecret
+	common.EnvConfig.SetupSecret = "s3cret"
+	t.Cleanup(func() {
+		common.EnvConfig.SetupSecret = originalSetupSecret
+	})
+
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration: model.AppConfigVariable{Value: "60"},
+	})
+	jwtService := &JwtService{}
+	err := jwtService.init(nil, appConfigService, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	})
+	require.NoError(t, err)
+
+	service := &UserService{
+		db:               db,
+		jwtService:       jwtService,
+		appConfigService: appConfigService,
+	}
+
+	signUpData := dto.SignUpDto{
+		Username:  "admin",
+		Email:     "admin@example.com",
+		FirstName: "Admin",
+	}
+
+	for _, secret := range []string{"", "wrong"} {
+		signUpData.SetupSecret = secret
+		_, _, err = service.SignUpInitialAdmin(t.Context(), signUpData)
+		var setupSecretErr *common.InvalidSetupSecretError
+		require.ErrorAs(t, err, &setupSecretErr)
+	}
+
+	signUpData.SetupSecret = "s3cret"
+	user, token, err := service.SignUpInitialAdmin(t.Context(), signUpData)
+	require.NoError(t, err)
+	assert.True(t, user.IsAdmin)
+	assert.NotEmpty(t, token)
+
+	// The setup can't be completed again, even with the secret
+	_, _, err = service.SignUpInitialAdmin(t.Context(), signUpData)
+	var setupCompletedErr *common.SetupAlreadyCompletedError
+	require.ErrorAs(t, err, &setupCompletedErr)
+}
+
+func TestUserService_ExportUserData(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Client", Secret: "client-secret-hash", CreatedByID: user.ID}
+	require.NoError(t, db.Create(&client).Error)
+	require.NoError(t, db.Create(&model.OidcRefreshToken{
+		Token:     "refresh-token-hash",
+		Scope:     "openid",
+		ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+		UserID:    user.ID,
+		ClientID:  client.ID,
+	}).Error)
+	require.NoError(t, db.Create(&model.WebauthnCredential{
+		Name:         "Passkey",
+		CredentialID: []byte("credential-id"),
+		PublicKey:    []byte("public-key-bytes"),
+		UserID:       user.ID,
+	}).Error)
+	for range 3 {
+		require.NoError(t, db.Create(&model.AuditLog{Event: model.AuditLogEventSignIn, UserID: user.ID, Data: model.AuditLogData{}}).Error)
+	}
+
+	service := &UserService{
+		db:              db,
+		auditLogService: &AuditLogService{},
+	}
+
+	var buf bytes.Buffer
+	err := service.ExportUserData(t.Context(), user.ID, &buf)
+	require.NoError(t, err)
+
+	var export struct {
+		Profile       dto.UserDto                     `json:"profile"`
+		RefreshTokens []dto.UserExportRefreshTokenDto `json:"refreshTokens"`
+		Passkeys      []dto.WebauthnCredentialDto     `json:"passkeys"`
+		AuditLogs     []dto.AuditLogDto               `json:"auditLogs"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &export))
+	assert.Equal(t, user.ID, export.Profile.ID)
+	require.Len(t, export.RefreshTokens, 1)
+	assert.Equal(t, "Client", export.RefreshTokens[0].ClientName)
+	require.Len(t, export.Passkeys, 1)
+	assert.Len(t, export.AuditLogs, 3)
+
+	// Secrets must not be included
+	assert.NotContains(t, buf.String(), "refresh-token-hash")
+	assert.NotContains(t, buf.String(), "client-secret-hash")
+	assert.NotContains(t, buf.String(), base64.StdEncoding.EncodeToString([]byte("public-key-bytes")))
+
+	t.Run("exports audit logs of multiple batches in order", func(t *testing.T) {
+		other := model.User{Username: "jane", Email: "jane@example.com", FirstName: "Jane"}
+		require.NoError(t, db.Create(&other).Error)
+
+		logs := make([]model.AuditLog, 2*userExportAuditLogBatchSize+10)
+		for i := range logs {
+			logs[i] = model.AuditLog{Event: model.AuditLogEventSignIn, UserID: other.ID, Data: model.AuditLogData{}}
+		}
+		require.NoError(t, db.CreateInBatches(&logs, 100).Error)
+
+		// The creation dates are assigned in the reverse order of the IDs, and several entries share the same date
+		slices.SortFunc(logs, func(a, b model.AuditLog) int { return strings.Compare(b.ID, a.ID) })
+		start := time.Now().Add(-time.Hour)
+		for i := range logs {
+			createdAt := datatype.DateTime(start.Add(time.Duration(i/3) * time.Second))
+			require.NoError(t, db.Model(&logs[i]).Update("created_at", createdAt).Error)
+		}
+
+		var buf bytes.Buffer
+		require.NoError(t, service.ExportUserData(t.Context(), other.ID, &buf))
+
+		var export struct {
+			AuditLogs []dto.AuditLogDto `json:"auditLogs"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &export))
+		require.Len(t, export.AuditLogs, len(logs))
+
+		seen := make(map[string]bool, len(logs))
+		for i, log := range export.AuditLogs {
+			assert.False(t, seen[log.ID], "duplicate audit log %s", log.ID)
+			seen[log.ID] = true
+			if i > 0 {
+				assert.False(t, log.CreatedAt.ToTime().Before(export.AuditLogs[i-1].CreatedAt.ToTime()))
+			}
+		}
+	})
+
+	t.Run("returns an error before writing for unknown users", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := service.ExportUserData(t.Context(), "4b9b7dd8-3bd5-4d4e-8e9f-dd1e1bd0e2f7", &buf)
+		require.Error(t, err)
+		assert.Zero(t, buf.Len())
+	})
+}
+
+func TestUserService_AccountDeletion(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	originalUploadPath := common.EnvConfig.UploadPath
+	common.EnvConfig.UploadPath = t.TempDir()
+	t.Cleanup(func() {
+		common.EnvConfig.UploadPath = originalUploadPath
+	})
+
+	admin := model.User{Username: "admin", Email: "admin@example.com", FirstName: "Admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: admin.ID}
+	require.NoError(t, db.Create(&client).Error)
+	require.NoError(t, db.Create(&model.UserAuthorizedOidcClient{UserID: user.ID, ClientID: client.ID}).Error)
+	require.NoError(t, db.Create(&model.OidcRefreshToken{
+		Token:     "refresh-token",
+		ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)),
+		UserID:    user.ID,
+		ClientID:  client.ID,
+	}).Error)
+
+	newService := func(enabled bool) *UserService {
+		appConfigService := NewTestAppConfigService(&model.AppConfig{
+			AllowUserSelfDeletion: model.AppConfigVariable{Value: strconv.FormatBool(enabled)},
+		})
+		return &UserService{
+			db:               db,
+			appConfigService: appConfigService,
+			auditLogService:  &AuditLogService{geoliteService: &GeoLiteService{}, appConfigService: appConfigService},
+			outboxService:    NewOutboxService(db),
+		}
+	}
+
+	// The token is only sent by email
+	sentToken := func(t *testing.T) string {
+		t.Helper()
+		var message model.OutboxMessage
+		require.NoError(t, db.Where("type = ?", outboxMessageAccountDeletionEmail).Order("created_at DESC").First(&message).Error)
+		var payload accountDeletionEmailPayload
+		require.NoError(t, json.Unmarshal([]byte(message.Payload), &payload))
+		assert.Equal(t, user.ID, payload.UserID)
+		return payload.Token
+	}
+
+	t.Run("fails if self-deletion is disabled", func(t *testing.T) {
+		err := newService(false).RequestAccountDeletion(t.Context(), user.ID, "127.0.0.1", "test")
+		require.ErrorIs(t, err, &common.AccountSelfDeletionDisabledError{})
+	})
+
+	service := newService(true)
+
+	t.Run("the last admin can't delete their account", func(t *testing.T) {
+		err := service.RequestAccountDeletion(t.Context(), admin.ID, "127.0.0.1", "test")
+		require.ErrorIs(t, err, &common.LastAdminDeletionError{})
+	})
+
+	t.Run("deletes the account with a valid token", func(t *testing.T) {
+		err := service.RequestAccountDeletion(t.Context(), user.ID, "127.0.0.1", "test")
+		require.NoError(t, err)
+		token := sentToken(t)
+		require.NotEmpty(t, token)
+
+		err = service.ConfirmAccountDeletion(t.Context(), user.ID, "wrong-token", "127.0.0.1", "test")
+		require.ErrorIs(t, err, &common.TokenInvalidOrExpiredError{})
+
+		// The token can only be used by the user who requested it
+		err = service.ConfirmAccountDeletion(t.Context(), admin.ID, token, "127.0.0.1", "test")
+		require.ErrorIs(t, err, &common.TokenInvalidOrExpiredError{})
+
+		err = service.ConfirmAccountDeletion(t.Context(), user.ID, token, "127.0.0.1", "test")
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&model.User{}).Where("id = ?", user.ID).Count(&count).Error)
+		assert.Zero(t, count)
+		require.NoError(t, db.Model(&model.OidcRefreshToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		assert.Zero(t, count)
+		require.NoError(t, db.Model(&model.UserAuthorizedOidcClient{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		assert.Zero(t, count)
+
+		var auditLogs []model.AuditLog
+		require.NoError(t, db.Where("user_id = ?", user.ID).Order("created_at").Find(&auditLogs).Error)
+		require.Len(t, auditLogs, 2)
+		assert.Equal(t, model.AuditLogEventAccountDeletionRequested, auditLogs[0].Event)
+		assert.Equal(t, model.AuditLogEventAccountDeleted, auditLogs[1].Event)
+		assert.Equal(t, "john", auditLogs[1].Data["username"])
+		assert.Equal(t, "john@example.com", auditLogs[1].Data["email"])
+	})
+}
+
+func TestUserService_ListUsers(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	group := model.UserGroup{Name: "developers", FriendlyName: "Developers"}
+	require.NoError(t, db.Create(&group).Error)
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John", LastName: "Doe", UserGroups: []model.UserGroup{group}}
+	require.NoError(t, db.Create(&user).Error)
+	require.NoError(t, db.Create(&model.CustomClaim{Key: "department", Value: "engineering", UserID: &user.ID}).Error)
+
+	service := &UserService{db: db, appConfigService: NewTestAppConfigService(&model.AppConfig{})}
+
+	t.Run("loads the associations by default", func(t *testing.T) {
+		users, pagination, err := service.ListUsers(t.Context(), "", utils.SortedPaginationRequest{}, ListUsersOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), pagination.TotalItems)
+		require.Len(t, users, 1)
+		assert.Len(t, users[0].UserGroups, 1)
+		assert.Len(t, users[0].CustomClaims, 1)
+		assert.Equal(t, "Doe", users[0].LastName)
+	})
+
+	t.Run("skips the associations", func(t *testing.T) {
+		users, _, err := service.ListUsers(t.Context(), "", utils.SortedPaginationRequest{}, ListUsersOptions{SkipAssociations: true})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Empty(t, users[0].UserGroups)
+		assert.Empty(t, users[0].CustomClaims)
+	})
+
+	t.Run("loads only the selected fields", func(t *testing.T) {
+		users, pagination, err := service.ListUsers(t.Context(), "john", utils.SortedPaginationRequest{}, ListUsersOptions{
+			SkipAssociations: true,
+			Fields:           []string{"username", "firstName"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), pagination.TotalItems)
+		require.Len(t, users, 1)
+		assert.Equal(t, user.ID, users[0].ID)
+		assert.Equal(t, "john", users[0].Username)
+		assert.Equal(t, "John", users[0].FirstName)
+		assert.Empty(t, users[0].Email)
+		assert.Empty(t, users[0].LastName)
+	})
+
+	t.Run("searches regardless of the case and accents", func(t *testing.T) {
+		accented := model.User{Username: "elodie", Email: "elodie@example.com", FirstName: "Élodie", LastName: "Müller"}
+		require.NoError(t, db.Create(&accented).Error)
+
+		for _, searchTerm := range []string{"élodie", "ÉLODIE", "müller", "MÜLLER", "ELODIE@EXAMPLE", "elodie", "MULLER"} {
+			users, _, err := service.ListUsers(t.Context(), searchTerm, utils.SortedPaginationRequest{}, ListUsersOptions{SkipAssociations: true})
+			require.NoError(t, err)
+			require.Len(t, users, 1, searchTerm)
+			assert.Equal(t, accented.ID, users[0].ID)
+		}
+
+		users, _, err := service.ListUsers(t.Context(), "DOE", utils.SortedPaginationRequest{}, ListUsersOptions{SkipAssociations: true})
+		require.NoError(t, err)
+		require.Len(t, users, 1)
+		assert.Equal(t, user.ID, users[0].ID)
+	})
+
+	t.Run("rejects unknown fields", func(t *testing.T) {
+		_, _, err := service.ListUsers(t.Context(), "", utils.SortedPaginationRequest{}, ListUsersOptions{Fields: []string{"password"}})
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
+
+func TestUserService_UpdateUser_RegeneratesDefaultProfilePicture(t *testing.T) {
+	originalUploadPath := common.EnvConfig.UploadPath
+	common.EnvConfig.UploadPath = t.TempDir()
+	t.Cleanup(func() {
+		common.EnvConfig.UploadPath = originalUploadPath
+	})
+
+	db := testutils.NewDatabaseForTest(t)
+	service := &UserService{
+		db:               db,
+		appConfigService: NewTestAppConfigService(&model.AppConfig{}),
+	}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John", LastName: "Doe"}
+	require.NoError(t, db.Create(&user).Error)
+	require.NoError(t, service.RegenerateDefaultProfilePicture(t.Context(), user.ID))
+	require.FileExists(t, defaultProfilePicturePath("JD"))
+
+	update := dto.UserCreateDto{Username: "john", Email: "john@example.com", FirstName: "Mary", LastName: "Doe"}
+	_, err := service.UpdateUser(t.Context(), user.ID, update, false, false)
+	require.NoError(t, err)
+	assert.NoFileExists(t, defaultProfilePicturePath("JD"))
+	assert.FileExists(t, defaultProfilePicturePath("MD"))
+
+	t.Run("keeps the default pictures if the user has a custom picture", func(t *testing.T) {
+		require.NoError(t, os.MkdirAll(filepath.Join(common.EnvConfig.UploadPath, "profile-pictures"), 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(common.EnvConfig.UploadPath, "profile-pictures", user.ID+".png"), []byte("custom"), 0o600))
+
+		update.FirstName = "Alice"
+		_, err := service.UpdateUser(t.Context(), user.ID, update, false, false)
+		require.NoError(t, err)
+		assert.FileExists(t, defaultProfilePicturePath("MD"))
+		assert.NoFileExists(t, defaultProfilePicturePath("AD"))
+	})
+}
+
+func TestUserService_GetProfilePicture_Generator(t *testing.T) {
+	originalUploadPath := common.EnvConfig.UploadPath
+	originalGenerator := common.EnvConfig.ProfilePictureGenerator
+	common.EnvConfig.UploadPath = t.TempDir()
+	t.Cleanup(func() {
+		common.EnvConfig.UploadPath = originalUploadPath
+		common.EnvConfig.ProfilePictureGenerator = originalGenerator
+	})
+
+	db := testutils.NewDatabaseForTest(t)
+	service := &UserService{
+		db:               db,
+		appConfigService: NewTestAppConfigService(&model.AppConfig{}),
+	}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John", LastName: "Doe"}
+	require.NoError(t, db.Create(&user).Error)
+
+	getPicture := func(t *testing.T) []byte {
+		t.Helper()
+		picture, _, err := service.GetProfilePicture(t.Context(), user.ID)
+		require.NoError(t, err)
+		defer picture.Close()
+		data, err := io.ReadAll(picture)
+		require.NoError(t, err)
+		return data
+	}
+
+	common.EnvConfig.ProfilePictureGenerator = "initials"
+	initials := getPicture(t)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.FileExists(c, filepath.Join(common.EnvConfig.UploadPath, "profile-pictures", "defaults", "initials", "JD.png"))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// The pictures of each generator are cached separately, so switching doesn't serve the cached initials
+	common.EnvConfig.ProfilePictureGenerator = "identicon"
+	identicon := getPicture(t)
+	assert.NotEqual(t, initials, identicon)
+	key := DefaultProfilePictureKey(user)
+	require.EventuallyWithT(t, func(c *assert.CollectT) {
+		assert.FileExists(c, filepath.Join(common.EnvConfig.UploadPath, "profile-pictures", "defaults", "identicon", key+".png"))
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, identicon, getPicture(t))
+}
+
+func TestUserService_LoginEmail(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := &UserService{
+		db:            db,
+		outboxService: NewOutboxService(db),
+		appConfigService: NewTestAppConfigService(&model.AppConfig{
+			LoginEmailEnabled:                          model.AppConfigVariable{Value: "true"},
+			EmailOneTimeAccessAsUnauthenticatedEnabled: model.AppConfigVariable{Value: "true"},
+		}),
+	}
+
+	user, err := service.CreateUser(t.Context(), dto.UserCreateDto{
+		Username:   "john",
+		Email:      "john@example.com",
+		LoginEmail: utils.Ptr("john@login.example.com"),
+		FirstName:  "John",
+	})
+	require.NoError(t, err)
+	require.NotNil(t, user.LoginEmail)
+	assert.Equal(t, "john@login.example.com", *user.LoginEmail)
+
+	countTokens := func() int64 {
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		return count
+	}
+
+	t.Run("looks up one-time access emails by the login email", func(t *testing.T) {
+		require.NoError(t, service.RequestOneTimeAccessEmailAsUnauthenticatedUser(t.Context(), "john@example.com", ""))
+		assert.Equal(t, int64(0), countTokens())
+
+		require.NoError(t, service.RequestOneTimeAccessEmailAsUnauthenticatedUser(t.Context(), "john@login.example.com", ""))
+		assert.Equal(t, int64(1), countTokens())
+	})
+
+	t.Run("rejects login emails that are used by other users", func(t *testing.T) {
+		_, err := service.CreateUser(t.Context(), dto.UserCreateDto{
+			Username:   "jane",
+			Email:      "jane@example.com",
+			LoginEmail: utils.Ptr("john@example.com"),
+			FirstName:  "Jane",
+		})
+		var alreadyInUseErr *common.AlreadyInUseError
+		require.ErrorAs(t, err, &alreadyInUseErr)
+		assert.Equal(t, "login email", alreadyInUseErr.Property)
+
+		_, err = service.CreateUser(t.Context(), dto.UserCreateDto{
+			Username:  "jane",
+			Email:     "john@login.example.com",
+			FirstName: "Jane",
+		})
+		require.ErrorAs(t, err, &alreadyInUseErr)
+		assert.Equal(t, "email", alreadyInUseErr.Property)
+	})
+
+	t.Run("keeps the login email if it's omitted", func(t *testing.T) {
+		updated, err := service.UpdateUser(t.Context(), user.ID, dto.UserCreateDto{
+			Username:  "john",
+			Email:     "john@example.com",
+			FirstName: "Johnny",
+		}, false, false)
+		require.NoError(t, err)
+		require.NotNil(t, updated.LoginEmail)
+		assert.Equal(t, "john@login.example.com", *updated.LoginEmail)
+	})
+
+	t.Run("ignores the login email if the feature is disabled", func(t *testing.T) {
+		disabledService := &UserService{
+			db:               db,
+			appConfigService: NewTestAppConfigService(&model.AppConfig{}),
+		}
+		updated, err := disabledService.UpdateUser(t.Context(), user.ID, dto.UserCreateDto{
+			Username:   "john",
+			Email:      "john@example.com",
+			FirstName:  "John",
+			LoginEmail: utils.Ptr(""),
+		}, false, false)
+		require.NoError(t, err)
+		require.NotNil(t, updated.LoginEmail)
+		assert.Equal(t, "john@login.example.com", *updated.LoginEmail)
+	})
+
+	t.Run("clears the login email if it's empty", func(t *testing.T) {
+		updated, err := service.UpdateUser(t.Context(), user.ID, dto.UserCreateDto{
+			Username:   "john",
+			Email:      "john@example.com",
+			FirstName:  "John",
+			LoginEmail: utils.Ptr(""),
+		}, false, false)
+		require.NoError(t, err)
+		assert.Nil(t, updated.LoginEmail)
+	})
+}
+
+func TestUserService_UnicodeNormalization(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	newService := func(form string) *UserService {
+		return &UserService{
+			db: db,
+			appConfigService: NewTestAppConfigService(&model.AppConfig{
+				UnicodeNormalizationForm: model.AppConfigVariable{Value: form},
+			}),
+		}
+	}
+
+	t.Run("stores the names in the configured form", func(t *testing.T) {
+		user, err := newService("nfc").createUserInternal(t.Context(), dto.UserCreateDto{
+			Username:  "zoe",
+			Email:     "zoe@example.com",
+			FirstName: "Zoe\u0308",
+			LastName:  "\ufb01scher",
+		}, false, db)
+		require.NoError(t, err)
+		assert.Equal(t, "Zo\u00eb", user.FirstName)
+		assert.Equal(t, "\ufb01scher", user.LastName)
+
+		user, err = newService("nfkc").updateUserInternal(t.Context(), user.ID, dto.UserCreateDto{
+			Username:  "zoe",
+			Email:     "zoe@example.com",
+			FirstName: "Zoe\u0308",
+			LastName:  "\ufb01scher",
+		}, false, false, db)
+		require.NoError(t, err)
+		assert.Equal(t, "Zo\u00eb", user.FirstName)
+		assert.Equal(t, "fischer", user.LastName)
+	})
+
+	t.Run("a different composition doesn't bypass the uniqueness of usernames", func(t *testing.T) {
+		service := newService("nfkc")
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{
+			Username:  "\uff4a\uff4f\uff4e",
+			Email:     "jon@example.com",
+			FirstName: "Jon",
+		}, true, db)
+		require.NoError(t, err)
+
+		err = service.checkDuplicatedFields(t.Context(), model.User{Username: "\uff4aon", Email: "other@example.com"}, db)
+		var inUseErr *common.AlreadyInUseError
+		require.ErrorAs(t, err, &inUseErr)
+		assert.Equal(t, "username", inUseErr.Property)
+	})
+}
+
+func TestUserService_checkSignupEmailDomain(t *testing.T) {
+	newService := func(blocked, blockDisposable string) *UserService {
+		return &UserService{
+			appConfigService: NewTestAppConfigService(&model.AppConfig{
+				SignupBlockedEmailDomains:         model.AppConfigVariable{Value: blocked},
+				SignupBlockDisposableEmailDomains: model.AppConfigVariable{Value: blockDisposable},
+			}),
+		}
+	}
+
+	t.Run("accepts every domain by default", func(t *testing.T) {
+		require.NoError(t, newService("", "false").checkSignupEmailDomain("john@mailinator.com"))
+	})
+
+	t.Run("rejects the blocked domains", func(t *testing.T) {
+		service := newService("example.org, *.example.net", "false")
+
+		var domainErr *common.EmailDomainNotAllowedError
+		require.ErrorAs(t, service.checkSignupEmailDomain("john@Example.org"), &domainErr)
+		assert.Equal(t, "example.org", domainErr.Domain)
+		require.ErrorAs(t, service.checkSignupEmailDomain("john@mail.example.net"), &domainErr)
+		require.NoError(t, service.checkSignupEmailDomain("john@example.com"))
+	})
+
+	t.Run("rejects disposable domains if enabled", func(t *testing.T) {
+		var domainErr *common.EmailDomainNotAllowedError
+		require.ErrorAs(t, newService("", "true").checkSignupEmailDomain("john@mailinator.com"), &domainErr)
+		require.NoError(t, newService("", "true").checkSignupEmailDomain("john@example.com"))
+	})
+}
+
+func TestUserService_AllowedEmailDomains(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	newService := func(allowed, exemptLdap string) *UserService {
+		return &UserService{
+			db: db,
+			appConfigService: NewTestAppConfigService(&model.AppConfig{
+				AllowedEmailDomains:           model.AppConfigVariable{Value: allowed},
+				AllowedEmailDomainsExemptLdap: model.AppConfigVariable{Value: exemptLdap},
+			}),
+		}
+	}
+	service := newService("example.com, *.Example.org", "true")
+
+	t.Run("creates users with an allowed domain", func(t *testing.T) {
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "john", Email: "john@EXAMPLE.com", FirstName: "John"}, false, db)
+		require.NoError(t, err)
+
+		_, err = service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "jane", Email: "jane@eu.example.org", FirstName: "Jane"}, false, db)
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects other domains", func(t *testing.T) {
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "bob", Email: "bob@gmail.com", FirstName: "Bob"}, false, db)
+		var domainErr *common.EmailDomainNotAllowedError
+		require.ErrorAs(t, err, &domainErr)
+		assert.Equal(t, "gmail.com", domainErr.Domain)
+
+		// The wildcard only matches subdomains
+		_, err = service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "bob", Email: "bob@example.org", FirstName: "Bob"}, false, db)
+		require.ErrorAs(t, err, &domainErr)
+	})
+
+	t.Run("rejects changing the email to another domain", func(t *testing.T) {
+		user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "alice", Email: "alice@example.com", FirstName: "Alice"}, false, db)
+		require.NoError(t, err)
+
+		_, err = service.updateUserInternal(t.Context(), user.ID, dto.UserCreateDto{Username: "alice", Email: "alice@gmail.com", FirstName: "Alice"}, false, false, db)
+		var domainErr *common.EmailDomainNotAllowedError
+		require.ErrorAs(t, err, &domainErr)
+	})
+
+	t.Run("exempts LDAP users unless disabled", func(t *testing.T) {
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "ldap1", Email: "ldap1@gmail.com", FirstName: "LDAP", LdapID: "ldap1"}, true, db)
+		require.NoError(t, err)
+
+		_, err = newService("example.com", "false").createUserInternal(t.Context(), dto.UserCreateDto{Username: "ldap2", Email: "ldap2@gmail.com", FirstName: "LDAP", LdapID: "ldap2"}, true, db)
+		var domainErr *common.EmailDomainNotAllowedError
+		require.ErrorAs(t, err, &domainErr)
+	})
+}
+
+func TestUserService_SupportedLocales(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	config := &model.AppConfig{
+		AllowOwnAccountEdit: model.AppConfigVariable{Value: "true"},
+		DefaultLocale:       model.AppConfigVariable{Value: "en"},
+		SupportedLocales:    model.AppConfigVariable{Value: "de,en,pt-BR"},
+	}
+	service := &UserService{
+		db:               db,
+		appConfigService: NewTestAppConfigService(config),
+	}
+
+	t.Run("stores supported locales as listed", func(t *testing.T) {
+		user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "john", Email: "john@example.com", FirstName: "John", Locale: utils.Ptr("pt_br")}, false, db)
+		require.NoError(t, err)
+		require.NotNil(t, user.Locale)
+		assert.Equal(t, "pt-BR", *user.Locale)
+	})
+
+	t.Run("stores no locale if none is set", func(t *testing.T) {
+		user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "jane", Email: "jane@example.com", FirstName: "Jane", Locale: utils.Ptr("")}, false, db)
+		require.NoError(t, err)
+		assert.Nil(t, user.Locale)
+	})
+
+	t.Run("rejects unsupported locales", func(t *testing.T) {
+		_, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "bob", Email: "bob@example.com", FirstName: "Bob", Locale: utils.Ptr("fr")}, false, db)
+		var localeErr *common.UnsupportedLocaleError
+		require.ErrorAs(t, err, &localeErr)
+		assert.Equal(t, "fr", localeErr.Locale)
+	})
+
+	t.Run("keeps locales that aren't supported anymore unless they are changed", func(t *testing.T) {
+		user, err := service.createUserInternal(t.Context(), dto.UserCreateDto{Username: "alice", Email: "alice@example.com", FirstName: "Alice", Locale: utils.Ptr("de")}, false, db)
+		require.NoError(t, err)
+
+		config.SupportedLocales = model.AppConfigVariable{Value: "en"}
+		defer func() { config.SupportedLocales = model.AppConfigVariable{Value: "de,en,pt-BR"} }()
+
+		user, err = service.updateUserInternal(t.Context(), user.ID, dto.UserCreateDto{Username: "alice", Email: "alice@example.com", FirstName: "Alicia", Locale: utils.Ptr("de")}, false, false, db)
+		require.NoError(t, err)
+		assert.Equal(t, "de", *user.Locale)
+
+		_, err = service.updateUserInternal(t.Context(), user.ID, dto.UserCreateDto{Username: "alice", Email: "alice@example.com", FirstName: "Alicia", Locale: utils.Ptr("pt-BR")}, false, false, db)
+		var localeErr *common.UnsupportedLocaleError
+		require.ErrorAs(t, err, &localeErr)
+	})
+}
+
+func TestUserService_MaintenanceMode(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration:        model.AppConfigVariable{Value: "60"},
+		MaintenanceModeEnabled: model.AppConfigVariable{Value: "true"},
+		MaintenanceModeMessage: model.AppConfigVariable{Value: "Back at 10:00"},
+		AllowUserSignups:       model.AppConfigVariable{Value: "open"},
+	})
+	jwtService := &JwtService{}
+	require.NoError(t, jwtService.init(db, appConfigService, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	}))
+	service := &UserService{
+		db:               db,
+		jwtService:       jwtService,
+		auditLogService:  &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfigService},
+		appConfigService: appConfigService,
+	}
+
+	createToken := func(t *testing.T, username string, isAdmin bool) string {
+		t.Helper()
+		user := model.User{Username: username, Email: username + "@example.com", FirstName: username, IsAdmin: isAdmin}
+		require.NoError(t, db.Create(&user).Error)
+		token := model.OneTimeAccessToken{Token: username + "-token", ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)), UserID: user.ID}
+		require.NoError(t, db.Create(&token).Error)
+		return token.Token
+	}
+
+	t.Run("rejects users that aren't admins", func(t *testing.T) {
+		token := createToken(t, "user", false)
+		_, _, err := service.ExchangeOneTimeAccessToken(t.Context(), token, "", "")
+
+		var maintenanceErr *common.MaintenanceModeError
+		require.ErrorAs(t, err, &maintenanceErr)
+		assert.Equal(t, "Back at 10:00", maintenanceErr.Error())
+
+		// The token can still be used after the maintenance
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Where("token = ?", token).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("lets admins sign in", func(t *testing.T) {
+		_, accessToken, err := service.ExchangeOneTimeAccessToken(t.Context(), createToken(t, "admin", true), "", "")
+		require.NoError(t, err)
+		assert.NotEmpty(t, accessToken)
+	})
+
+	t.Run("rejects signups", func(t *testing.T) {
+		_, _, err := service.SignUp(t.Context(), dto.SignUpDto{Username: "new", Email: "new@example.com", FirstName: "New"}, "", "")
+		var maintenanceErr *common.MaintenanceModeError
+		require.ErrorAs(t, err, &maintenanceErr)
+	})
+}
+
+func TestUserService_PasskeyReenrollment(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	appConfigService := NewTestAppConfigService(&model.AppConfig{
+		SessionDuration: model.AppConfigVariable{Value: "60"},
+	})
+	jwtService := &JwtService{}
+	require.NoError(t, jwtService.init(db, appConfigService, &common.EnvConfigSchema{
+		AppURL:      "https://test.example.com",
+		KeysStorage: "file",
+		KeysPath:    t.TempDir(),
+	}))
+	auditLogService := &AuditLogService{db: db, geoliteService: &GeoLiteService{}, appConfigService: appConfigService}
+	service := &UserService{
+		db:               db,
+		jwtService:       jwtService,
+		auditLogService:  auditLogService,
+		appConfigService: appConfigService,
+	}
+
+	admin := model.User{Username: "admin", Email: "admin@example.com", FirstName: "Admin", IsAdmin: true}
+	require.NoError(t, db.Create(&admin).Error)
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	require.NoError(t, db.Create(&model.WebauthnCredential{Name: "Passkey", CredentialID: []byte("credential-id"), PublicKey: []byte("public-key"), UserID: user.ID}).Error)
+	client := model.OidcClient{Name: "Client", CreatedByID: admin.ID}
+	require.NoError(t, db.Create(&client).Error)
+	require.NoError(t, db.Create(&model.OidcRefreshToken{Token: "hash", ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)), UserID: user.ID, ClientID: client.ID}).Error)
+
+	countAuditLogs := func(t *testing.T, event model.AuditLogEvent) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, db.Model(&model.AuditLog{}).Where("event = ? AND user_id = ?", event, user.ID).Count(&count).Error)
+		return count
+	}
+
+	require.NoError(t, service.RequirePasskeyReenrollment(t.Context(), user.ID, admin.ID, "", ""))
+
+	loaded, err := service.GetUser(t.Context(), user.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, loaded.PasskeyReenrollmentRequiredAt)
+	require.NotNil(t, loaded.SessionsValidAfter)
+	sessionsValidAfter := *loaded.SessionsValidAfter
+	assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventPasskeyReenrollmentRequired))
+
+	var count int64
+	require.NoError(t, db.Model(&model.WebauthnCredential{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Zero(t, count, "passkeys should be deleted")
+	require.NoError(t, db.Model(&model.OidcRefreshToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+	assert.Zero(t, count, "refresh tokens should be deleted")
+
+	// The user can still sign in with a one-time access token
+	token := model.OneTimeAccessToken{Token: "reenroll-token", ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)), UserID: user.ID}
+	require.NoError(t, db.Create(&token).Error)
+	_, _, err = service.ExchangeOneTimeAccessToken(t.Context(), token.Token, "", "")
+	require.NoError(t, err)
+
+	// Adding a passkey clears the requirement
+	require.NoError(t, clearPasskeyReenrollmentInternal(t.Context(), auditLogService, &loaded, "reenrolled", user.ID, "", "", db))
+	loaded, err = service.GetUser(t.Context(), user.ID)
+	require.NoError(t, err)
+	assert.Nil(t, loaded.PasskeyReenrollmentRequiredAt)
+	assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventPasskeyReenrollmentCleared))
+
+	// Sessions issued before the requirement stay invalid
+	require.NotNil(t, loaded.SessionsValidAfter)
+	assert.Equal(t, sessionsValidAfter.ToTime().Unix(), loaded.SessionsValidAfter.ToTime().Unix())
+
+	// Clearing it again isn't recorded
+	require.NoError(t, service.CancelPasskeyReenrollment(t.Context(), user.ID, admin.ID, "", ""))
+	assert.Equal(t, int64(1), countAuditLogs(t, model.AuditLogEventPasskeyReenrollmentCleared))
+}
+
+func TestUserService_PeekOneTimeAccessToken(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+	service := &UserService{db: db}
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+	require.NoError(t, db.Create(&[]model.OneTimeAccessToken{
+		{Token: "valid", ExpiresAt: datatype.DateTime(time.Now().Add(time.Hour)), UserID: user.ID},
+		{Token: "expired", ExpiresAt: datatype.DateTime(time.Now().Add(-time.Hour)), UserID: user.ID},
+	}).Error)
+
+	for token, expected := range map[string]bool{"valid": true, "expired": false, "unknown": false} {
+		valid, err := service.PeekOneTimeAccessToken(t.Context(), token, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, expected, valid, token)
+	}
+
+	// The token isn't consumed
+	var count int64
+	require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Where("token = ?", "valid").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
+
+// testSmtpSession accepts all the commands of an SMTP client and discards the emails
+type testSmtpSession struct{}
+
+func (testSmtpSession) Reset()                               {}
+func (testSmtpSession) Logout() error                        { return nil }
+func (testSmtpSession) Mail(string, *smtp.MailOptions) error { return nil }
+func (testSmtpSession) Rcpt(string, *smtp.RcptOptions) error { return nil }
+func (testSmtpSession) Data(r io.Reader) error               { _, err := io.Copy(io.Discard, r); return err }
+
+func TestUserService_EmailUnavailableBehavior(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	user := model.User{Username: "john", Email: "john@example.com", FirstName: "John"}
+	require.NoError(t, db.Create(&user).Error)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := smtp.NewServer(smtp.BackendFunc(func(*smtp.Conn) (smtp.Session, error) {
+		return testSmtpSession{}, nil
+	}))
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(func() { _ = server.Close() })
+	availablePort := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+
+	// Nothing listens on the port of a closed listener
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	unavailablePort := strconv.Itoa(closedListener.Addr().(*net.TCPAddr).Port)
+	require.NoError(t, closedListener.Close())
+
+	newService := func(behavior, port string) *UserService {
+		appConfigService := NewTestAppConfigService(&model.AppConfig{
+			SmtpHost:                         model.AppConfigVariable{Value: "127.0.0.1"},
+			SmtpPort:                         model.AppConfigVariable{Value: port},
+			SmtpTls:                          model.AppConfigVariable{Value: "none"},
+			EmailOneTimeAccessAsAdminEnabled: model.AppConfigVariable{Value: "true"},
+			EmailOneTimeAccessAsUnauthenticatedEnabled: model.AppConfigVariable{Value: "true"},
+			EmailUnavailableBehavior:                   model.AppConfigVariable{Value: behavior},
+		})
+		return &UserService{
+			db:               db,
+			appConfigService: appConfigService,
+			emailService:     &EmailService{appConfigService: appConfigService},
+			outboxService:    NewOutboxService(db),
+		}
+	}
+
+	countTokens := func(t *testing.T) int64 {
+		t.Helper()
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		return count
+	}
+
+	expiration := time.Now().Add(time.Hour)
+
+	t.Run("queues the email if the SMTP server is unavailable", func(t *testing.T) {
+		before := countTokens(t)
+		service := newService("queue", unavailablePort)
+
+		require.NoError(t, service.RequestOneTimeAccessEmailAsAdmin(t.Context(), user.ID, expiration))
+		assert.Equal(t, before+1, countTokens(t))
+	})
+
+	t.Run("fails if the SMTP server is unavailable", func(t *testing.T) {
+		before := countTokens(t)
+		service := newService("fail", unavailablePort)
+
+		err := service.RequestOneTimeAccessEmailAsAdmin(t.Context(), user.ID, expiration)
+		var unavailableErr *common.EmailUnavailableError
+		require.ErrorAs(t, err, &unavailableErr)
+		assert.Equal(t, before, countTokens(t))
+	})
+
+	t.Run("fails the same way for unknown and known users when unauthenticated", func(t *testing.T) {
+		service := newService("fail", unavailablePort)
+
+		var unavailableErr *common.EmailUnavailableError
+		err := service.RequestOneTimeAccessEmailAsUnauthenticatedUser(t.Context(), "john@example.com", "")
+		require.ErrorAs(t, err, &unavailableErr)
+		err = service.RequestOneTimeAccessEmailAsUnauthenticatedUser(t.Context(), "nobody@example.com", "")
+		require.ErrorAs(t, err, &unavailableErr)
+	})
+
+	t.Run("succeeds if the SMTP server is available", func(t *testing.T) {
+		before := countTokens(t)
+		service := newService("fail", availablePort)
+
+		require.NoError(t, service.RequestOneTimeAccessEmailAsAdmin(t.Context(), user.ID, expiration))
+		assert.Equal(t, before+1, countTokens(t))
+	})
+}
+
+func TestUserService_WelcomeEmail(t *testing.T) {
+	db := testutils.NewDatabaseForTest(t)
+
+	var lock sync.Mutex
+	var from, data string
+	port := startRecordingSmtpServer(t, recordingSmtpSession{lock: &lock, from: &from, data: &data})
+
+	appConfig := &model.AppConfig{
+		AppName:             model.AppConfigVariable{Value: "Pocket ID"},
+		SmtpHost:            model.AppConfigVariable{Value: "127.0.0.1"},
+		SmtpPort:            model.AppConfigVariable{Value: port},
+		SmtpTls:             model.AppConfigVariable{Value: "none"},
+		SmtpFrom:            model.AppConfigVariable{Value: "noreply@example.com"},
+		SmtpFromOnboarding:  model.AppConfigVariable{Value: "welcome@example.com"},
+		EmailWelcomeEnabled: model.AppConfigVariable{Value: "true"},
+	}
+	appConfigService := NewTestAppConfigService(appConfig)
+	emailService, err := NewEmailService(db, appConfigService, nil)
+	require.NoError(t, err)
+	outboxService := NewOutboxService(db)
+	service := NewUserService(db, nil, nil, emailService, appConfigService, outboxService, nil)
+
+	t.Run("sends a welcome email with a login link to new users", func(t *testing.T) {
+		user, err := service.CreateUser(t.Context(), dto.UserCreateDto{Username: "john", Email: "john@example.com", FirstName: "John"})
+		require.NoError(t, err)
+
+		var token model.OneTimeAccessToken
+		require.NoError(t, db.Where("user_id = ?", user.ID).First(&token).Error)
+
+		delivered, err := outboxService.ProcessPending(t.Context())
+		require.NoError(t, err)
+		assert.Equal(t, 1, delivered)
+
+		lock.Lock()
+		defer lock.Unlock()
+		assert.Equal(t, "welcome@example.com", from)
+		assert.Contains(t, data, "Subject: Welcome to Pocket ID")
+		assert.Contains(t, data, "/lc/"+token.Token)
+	})
+
+	t.Run("doesn't send welcome emails if they are disabled", func(t *testing.T) {
+		appConfig.EmailWelcomeEnabled = model.AppConfigVariable{Value: "false"}
+
+		user, err := service.CreateUser(t.Context(), dto.UserCreateDto{Username: "jane", Email: "jane@example.com", FirstName: "Jane"})
+		require.NoError(t, err)
+
+		var count int64
+		require.NoError(t, db.Model(&model.OneTimeAccessToken{}).Where("user_id = ?", user.ID).Count(&count).Error)
+		assert.Zero(t, count)
+		require.NoError(t, db.Model(&model.OutboxMessage{}).Where("type = ?", outboxMessageWelcomeEmail).Count(&count).Error)
+		assert.Zero(t, count)
+	})
+}
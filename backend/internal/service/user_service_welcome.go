@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/model"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+	"github.com/pocket-id/pocket-id/backend/internal/utils/email"
+)
+
+const (
+	// Type of the outbox messages that send the welcome email to new users
+	outboxMessageWelcomeEmail = "welcomeEmail"
+	// How long the login link of the welcome email is valid
+	welcomeEmailTokenExpiration = 72 * time.Hour
+)
+
+// welcomeEmailPayload is the payload of the outbox messages that send the welcome email
+type welcomeEmailPayload struct {
+	UserID    string    `json:"userId"`
+	Code      string    `json:"code"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// enqueueWelcomeEmail creates a one-time access token for the new user and enqueues the welcome email containing it, if welcome emails are enabled.
+// The email is sent by the outbox worker, so the creation of the user doesn't fail if the email can't be sent.
+func (s *UserService) enqueueWelcomeEmail(ctx context.Context, user model.User, tx *gorm.DB) error {
+	if !s.appConfigService.GetDbConfig().EmailWelcomeEnabled.IsTrue() || user.Email == "" {
+		return nil
+	}
+
+	expiresAt := time.Now().Add(welcomeEmailTokenExpiration)
+	code, err := s.createOneTimeAccessTokenInternal(ctx, user.ID, expiresAt, tx)
+	if err != nil {
+		return fmt.Errorf("failed to create one-time access token for welcome email: %w", err)
+	}
+
+	return s.outboxService.Enqueue(ctx, outboxMessageWelcomeEmail, welcomeEmailPayload{
+		UserID:    user.ID,
+		Code:      code,
+		ExpiresAt: expiresAt,
+	}, tx)
+}
+
+func (s *UserService) sendWelcomeEmail(ctx context.Context, payload welcomeEmailPayload) error {
+	if time.Now().After(payload.ExpiresAt) {
+		// The login link has expired before the email could be sent
+		return nil
+	}
+
+	var user model.User
+	err := s.db.
+		WithContext(ctx).
+		Where("id = ?", payload.UserID).
+		First(&user).
+		Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The user has been deleted in the meantime
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to load user from database to send welcome email: %w", err)
+	}
+
+	link := common.EnvConfig.AppURL + "/lc"
+	err = SendEmail(ctx, s.emailService, email.Address{
+		Name:   user.FullName(),
+		Email:  user.Email,
+		Locale: user.Locale,
+	}, WelcomeTemplate, &WelcomeTemplateData{
+		Name:              user.FirstName,
+		Username:          user.Username,
+		LoginLink:         link,
+		LoginLinkWithCode: link + "/" + payload.Code,
+		ExpirationString:  utils.DurationToString(time.Until(payload.ExpiresAt).Round(time.Hour)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send welcome email to '%s': %w", user.Email, err)
+	}
+
+	return nil
+}
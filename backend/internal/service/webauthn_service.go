@@ -77,10 +77,19 @@ func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID string)
 		return nil, fmt.Errorf("failed to load user: %w", err)
 	}
 
+	// Check the limit before the ceremony, so the user doesn't register a passkey on the authenticator that is then rejected
+	dbConfig := s.appConfigService.GetDbConfig()
+	limit := credentialLimit(dbConfig, user, user.MaxPasskeys, dbConfig.MaxPasskeysPerUser)
+	if limit > 0 && len(user.Credentials) >= limit {
+		return nil, &common.PasskeyLimitReachedError{Limit: limit}
+	}
+
+	// The authenticator shows the app name in the language of the user
 	options, session, err := s.webAuthn.BeginRegistration(
 		&user,
 		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
 		webauthn.WithExclusions(user.WebAuthnCredentialDescriptors()),
+		webauthn.WithRegistrationRelyingPartyName(dbConfig.AppNameForLocale(user.Locale)),
 	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin WebAuthn registration: %w", err)
@@ -112,7 +121,7 @@ func (s *WebAuthnService) BeginRegistration(ctx context.Context, userID string)
 	}, nil
 }
 
-func (s *WebAuthnService) VerifyRegistration(ctx context.Context, sessionID, userID string, r *http.Request) (model.WebauthnCredential, error) {
+func (s *WebAuthnService) VerifyRegistration(ctx context.Context, sessionID, userID, ipAddress string, r *http.Request) (model.WebauthnCredential, error) {
 	tx := s.db.Begin()
 	defer func() {
 		tx.Rollback()
@@ -144,6 +153,19 @@ func (s *WebAuthnService) VerifyRegistration(ctx context.Context, sessionID, use
 		return model.WebauthnCredential{}, fmt.Errorf("failed to load user: %w", err)
 	}
 
+	// Check the limit again, as several registrations may have been started at the same time
+	dbConfig := s.appConfigService.GetDbConfig()
+	limit := credentialLimit(dbConfig, user, user.MaxPasskeys, dbConfig.MaxPasskeysPerUser)
+	if limit > 0 {
+		count, err := countPasskeysInternal(ctx, user.ID, tx)
+		if err != nil {
+			return model.WebauthnCredential{}, err
+		}
+		if count >= int64(limit) {
+			return model.WebauthnCredential{}, &common.PasskeyLimitReachedError{Limit: limit}
+		}
+	}
+
 	credential, err := s.webAuthn.FinishRegistration(&user, session, r)
 	if err != nil {
 		return model.WebauthnCredential{}, fmt.Errorf("failed to finish WebAuthn registration: %w", err)
@@ -170,6 +192,12 @@ func (s *WebAuthnService) VerifyRegistration(ctx context.Context, sessionID, use
 		return model.WebauthnCredential{}, fmt.Errorf("failed to store WebAuthn credential: %w", err)
 	}
 
+	// Adding a passkey completes the re-enrollment required by an admin
+	err = clearPasskeyReenrollmentInternal(ctx, s.auditLogService, &user, "reenrolled", user.ID, ipAddress, r.UserAgent(), tx)
+	if err != nil {
+		return model.WebauthnCredential{}, err
+	}
+
 	err = tx.Commit().Error
 	if err != nil {
 		return model.WebauthnCredential{}, fmt.Errorf("failed to commit transaction: %w", err)
@@ -255,8 +283,26 @@ func (s *WebAuthnService) VerifyLogin(ctx context.Context, sessionID string, cre
 	if user.Disabled {
 		return model.User{}, "", &common.UserDisabledError{}
 	}
+	err = s.appConfigService.CheckMaintenanceMode(user)
+	if err != nil {
+		return model.User{}, "", err
+	}
+	err = s.auditLogService.CheckSignInLocation(ctx, ipAddress, user)
+	if err != nil {
+		// Release the transaction first, as SQLite allows only one writer
+		tx.Rollback()
+		s.auditLogService.LogSignInGeoblocked(ctx, err, ipAddress, userAgent, user)
+		return model.User{}, "", err
+	}
+
+	token, err := s.jwtService.GenerateAccessToken(*user, []string{AmrPasskey})
+	if err != nil {
+		return model.User{}, "", err
+	}
+
+	s.auditLogService.EnqueueSuspiciousSignInCheck(ctx, user, ipAddress, userAgent, tx)
 
-	token, err := s.jwtService.GenerateAccessToken(*user)
+	err = updateLastLoginInternal(ctx, user.ID, ipAddress, tx)
 	if err != nil {
 		return model.User{}, "", err
 	}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"errors"
+	"hash/crc32"
+	"strings"
+)
+
+// API keys have the format "pid_<environment>_<secret><checksum>", e.g. "pid_live_...".
+// The prefix makes leaked keys detectable by secret scanners, and the checksum lets malformed keys be rejected without a database lookup.
+// A future format would use a different prefix, so that keys of both formats can be told apart.
+const (
+	ApiKeyPrefix = "pid"
+
+	ApiKeyEnvironmentLive = "live"
+	ApiKeyEnvironmentTest = "test"
+
+	apiKeySecretLength   = 32
+	apiKeyChecksumLength = 6
+	apiKeyBase62Charset  = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+)
+
+var (
+	ErrApiKeyMalformed        = errors.New("API key is malformed")
+	ErrApiKeyChecksumMismatch = errors.New("API key checksum doesn't match")
+)
+
+// GenerateApiKey generates a new API key for the given environment
+func GenerateApiKey(environment string) (string, error) {
+	secret, err := GenerateRandomAlphanumericString(apiKeySecretLength)
+	if err != nil {
+		return "", err
+	}
+
+	body := ApiKeyPrefix + "_" + environment + "_" + secret
+	return body + apiKeyChecksum(body), nil
+}
+
+// ParseApiKey validates the format and the checksum of an API key, and returns its environment.
+// Keys generated before the prefixed format was introduced are plain alphanumeric strings, for which legacy is true.
+func ParseApiKey(key string) (environment string, legacy bool, err error) {
+	if !strings.HasPrefix(key, ApiKeyPrefix+"_") {
+		if len(key) != apiKeySecretLength || !isAlphanumeric(key) {
+			return "", false, ErrApiKeyMalformed
+		}
+		return "", true, nil
+	}
+
+	parts := strings.Split(key, "_")
+	if len(parts) != 3 || parts[1] == "" {
+		return "", false, ErrApiKeyMalformed
+	}
+	environment = parts[1]
+
+	if len(parts[2]) != apiKeySecretLength+apiKeyChecksumLength || !isAlphanumeric(parts[2]) {
+		return "", false, ErrApiKeyMalformed
+	}
+
+	body, checksum := key[:len(key)-apiKeyChecksumLength], key[len(key)-apiKeyChecksumLength:]
+	if apiKeyChecksum(body) != checksum {
+		return "", false, ErrApiKeyChecksumMismatch
+	}
+
+	return environment, false, nil
+}
+
+// apiKeyChecksum returns the CRC32 checksum of the key, encoded in base62 and padded to a fixed length
+func apiKeyChecksum(body string) string {
+	sum := crc32.ChecksumIEEE([]byte(body))
+
+	encoded := make([]byte, apiKeyChecksumLength)
+	for i := apiKeyChecksumLength - 1; i >= 0; i-- {
+		encoded[i] = apiKeyBase62Charset[sum%62]
+		sum /= 62
+	}
+	return string(encoded)
+}
+
+func isAlphanumeric(s string) bool {
+	for _, c := range []byte(s) {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApiKey(t *testing.T) {
+	t.Run("generates keys that can be parsed", func(t *testing.T) {
+		key, err := GenerateApiKey(ApiKeyEnvironmentTest)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(key, "pid_test_"))
+		assert.Len(t, key, len("pid_test_")+apiKeySecretLength+apiKeyChecksumLength)
+
+		environment, legacy, err := ParseApiKey(key)
+		require.NoError(t, err)
+		assert.Equal(t, ApiKeyEnvironmentTest, environment)
+		assert.False(t, legacy)
+	})
+
+	t.Run("detects a wrong checksum", func(t *testing.T) {
+		key, err := GenerateApiKey(ApiKeyEnvironmentLive)
+		require.NoError(t, err)
+
+		// Change a character of the secret
+		i := len("pid_live_")
+		replacement := "a"
+		if key[i] == 'a' {
+			replacement = "b"
+		}
+		_, _, err = ParseApiKey(key[:i] + replacement + key[i+1:])
+		require.ErrorIs(t, err, ErrApiKeyChecksumMismatch)
+	})
+
+	t.Run("accepts legacy keys", func(t *testing.T) {
+		_, legacy, err := ParseApiKey("aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0eF")
+		require.NoError(t, err)
+		assert.True(t, legacy)
+	})
+
+	t.Run("rejects malformed keys", func(t *testing.T) {
+		for _, key := range []string{
+			"",
+			"too-short",
+			"pid_live",
+			"pid__aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0eF123456",
+			"pid_live_aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0eF",
+			"pid_live_extra_aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0eF123456",
+			"aB3dE6gH9jK2mN5pQ8sT1vW4yZ7bC0e!",
+		} {
+			_, _, err := ParseApiKey(key)
+			require.ErrorIs(t, err, ErrApiKeyMalformed, key)
+		}
+	})
+}
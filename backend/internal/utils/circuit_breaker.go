@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+type CircuitBreakerState string
+
+const (
+	// CircuitBreakerClosed lets all calls through
+	CircuitBreakerClosed CircuitBreakerState = "closed"
+	// CircuitBreakerOpen rejects all calls until the cooldown is over
+	CircuitBreakerOpen CircuitBreakerState = "open"
+	// CircuitBreakerHalfOpen lets a single call through to check if the dependency recovered
+	CircuitBreakerHalfOpen CircuitBreakerState = "half-open"
+)
+
+// CircuitBreaker stops calling a dependency after it failed repeatedly, and retries it after a cooldown.
+// The threshold and the cooldown are passed to every call, so they can be changed at runtime.
+type CircuitBreaker struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	openedAt            time.Time
+	probing             bool
+	now                 func() time.Time
+}
+
+type CircuitBreakerStatus struct {
+	State               CircuitBreakerState
+	ConsecutiveFailures int
+	// RetryAt is when calls are let through again, if the circuit is open
+	RetryAt *time.Time
+}
+
+func NewCircuitBreaker() *CircuitBreaker {
+	return &CircuitBreaker{now: time.Now}
+}
+
+// Allow returns true if a call can be made.
+// Once the cooldown of an open circuit is over, a single call is allowed until its result is recorded.
+func (b *CircuitBreaker) Allow(cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.stateInternal(cooldown) {
+	case CircuitBreakerOpen:
+		return false
+	case CircuitBreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// RecordSuccess closes the circuit
+func (b *CircuitBreaker) RecordSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFailures = 0
+	b.openedAt = time.Time{}
+	b.probing = false
+}
+
+// RecordFailure counts a failed call, and opens the circuit once there are threshold consecutive failures.
+// A threshold of 0 or less never opens the circuit.
+func (b *CircuitBreaker) RecordFailure(threshold int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.consecutiveFailures++
+	b.probing = false
+	if threshold > 0 && b.consecutiveFailures >= threshold {
+		b.openedAt = b.now()
+	}
+}
+
+// Status returns the current state of the circuit
+func (b *CircuitBreaker) Status(cooldown time.Duration) CircuitBreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := CircuitBreakerStatus{
+		State:               b.stateInternal(cooldown),
+		ConsecutiveFailures: b.consecutiveFailures,
+	}
+	if status.State == CircuitBreakerOpen {
+		retryAt := b.openedAt.Add(cooldown)
+		status.RetryAt = &retryAt
+	}
+	return status
+}
+
+func (b *CircuitBreaker) stateInternal(cooldown time.Duration) CircuitBreakerState {
+	switch {
+	case b.openedAt.IsZero():
+		return CircuitBreakerClosed
+	case b.now().Before(b.openedAt.Add(cooldown)):
+		return CircuitBreakerOpen
+	default:
+		return CircuitBreakerHalfOpen
+	}
+}
@@ -0,0 +1,62 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	b := NewCircuitBreaker()
+	b.now = func() time.Time { return now }
+	const cooldown = time.Minute
+
+	t.Run("opens after the threshold of consecutive failures", func(t *testing.T) {
+		b.RecordFailure(3)
+		b.RecordFailure(3)
+		assert.True(t, b.Allow(cooldown))
+		assert.Equal(t, CircuitBreakerClosed, b.Status(cooldown).State)
+
+		b.RecordFailure(3)
+		assert.False(t, b.Allow(cooldown))
+
+		status := b.Status(cooldown)
+		assert.Equal(t, CircuitBreakerOpen, status.State)
+		assert.Equal(t, 3, status.ConsecutiveFailures)
+		require.NotNil(t, status.RetryAt)
+		assert.Equal(t, now.Add(cooldown), *status.RetryAt)
+	})
+
+	t.Run("lets a single call through after the cooldown", func(t *testing.T) {
+		now = now.Add(cooldown)
+		assert.Equal(t, CircuitBreakerHalfOpen, b.Status(cooldown).State)
+		assert.True(t, b.Allow(cooldown))
+		assert.False(t, b.Allow(cooldown))
+
+		// The failed probe opens the circuit again
+		b.RecordFailure(3)
+		assert.Equal(t, CircuitBreakerOpen, b.Status(cooldown).State)
+	})
+
+	t.Run("closes after a successful call", func(t *testing.T) {
+		now = now.Add(cooldown)
+		require.True(t, b.Allow(cooldown))
+		b.RecordSuccess()
+
+		status := b.Status(cooldown)
+		assert.Equal(t, CircuitBreakerClosed, status.State)
+		assert.Zero(t, status.ConsecutiveFailures)
+		assert.Nil(t, status.RetryAt)
+	})
+
+	t.Run("never opens with a threshold of 0", func(t *testing.T) {
+		for range 10 {
+			b.RecordFailure(0)
+		}
+		assert.True(t, b.Allow(cooldown))
+		b.RecordSuccess()
+	})
+}
@@ -0,0 +1,67 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Coalescer runs a function at most once at a time.
+// Calls made while it's running are coalesced into a single run that starts once the current one has finished,
+// so every caller gets the result of a run that started after its call, without queuing a run per call.
+type Coalescer[T any] struct {
+	fn func(ctx context.Context) (T, error)
+
+	mu      sync.Mutex
+	running bool
+	next    *coalescedRun[T]
+}
+
+type coalescedRun[T any] struct {
+	done  chan struct{}
+	value T
+	err   error
+}
+
+func NewCoalescer[T any](fn func(ctx context.Context) (T, error)) *Coalescer[T] {
+	return &Coalescer[T]{fn: fn}
+}
+
+// Do runs the function, or waits for the next run if it's already running, and returns its result.
+// The run isn't canceled if ctx is, as other callers may be waiting for it; only the wait is.
+func (c *Coalescer[T]) Do(ctx context.Context) (T, error) {
+	c.mu.Lock()
+	var run *coalescedRun[T]
+	if !c.running {
+		c.running = true
+		run = &coalescedRun[T]{done: make(chan struct{})}
+		go c.runLoop(context.WithoutCancel(ctx), run)
+	} else {
+		if c.next == nil {
+			c.next = &coalescedRun[T]{done: make(chan struct{})}
+		}
+		run = c.next
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.value, run.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// runLoop runs the function until no more calls are waiting for a run
+func (c *Coalescer[T]) runLoop(ctx context.Context, run *coalescedRun[T]) {
+	for run != nil {
+		run.value, run.err = c.fn(ctx)
+		close(run.done)
+
+		c.mu.Lock()
+		run = c.next
+		c.next = nil
+		c.running = run != nil
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoalescer(t *testing.T) {
+	t.Run("coalesces calls made while running", func(t *testing.T) {
+		var runs atomic.Int32
+		release := make(chan struct{})
+		c := NewCoalescer(func(ctx context.Context) (int32, error) {
+			n := runs.Add(1)
+			if n == 1 {
+				<-release
+			}
+			return n, nil
+		})
+
+		firstResult := make(chan int32)
+		go func() {
+			v, _ := c.Do(t.Context())
+			firstResult <- v
+		}()
+		require.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, time.Millisecond)
+
+		// These calls are made while the first run is in progress, so they share the second run
+		var wg sync.WaitGroup
+		results := make([]int32, 5)
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], _ = c.Do(t.Context())
+			}()
+		}
+		require.Eventually(t, func() bool {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			return c.next != nil
+		}, time.Second, time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+
+		close(release)
+		assert.Equal(t, int32(1), <-firstResult)
+		wg.Wait()
+
+		// Every call gets the result of a run that started after it; a goroutine scheduled late may cause one more run
+		for _, r := range results {
+			assert.GreaterOrEqual(t, r, int32(2))
+		}
+		assert.LessOrEqual(t, runs.Load(), int32(3))
+	})
+
+	t.Run("stops waiting when the context is canceled", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		c := NewCoalescer(func(ctx context.Context) (struct{}, error) {
+			<-release
+			return struct{}{}, nil
+		})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+		_, err := c.Do(ctx)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
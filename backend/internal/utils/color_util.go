@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Color is a color in the linear sRGB color space, with each channel in the range [0, 1]
+type Color struct {
+	R float64
+	G float64
+	B float64
+}
+
+var (
+	hexColorRegex   = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+	oklchColorRegex = regexp.MustCompile(`^oklch\(\s*([0-9.]+%?)\s+([0-9.]+)\s+([0-9.]+)(?:deg)?\s*\)$`)
+)
+
+// ParseColor parses a hex color (#rgb or #rrggbb) or an oklch() color as used by the frontend
+func ParseColor(value string) (Color, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+
+	switch {
+	case hexColorRegex.MatchString(value):
+		return parseHexColor(value[1:])
+	case oklchColorRegex.MatchString(value):
+		return parseOklchColor(oklchColorRegex.FindStringSubmatch(value)[1:])
+	default:
+		return Color{}, errors.New("color must be a hex color like #1a2b3c or an oklch() color like oklch(0.6 0.2 240)")
+	}
+}
+
+func parseHexColor(hex string) (Color, error) {
+	// Expand the short form (#abc) to the long one (#aabbcc)
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+
+	rgb, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid hex color: %w", err)
+	}
+
+	return Color{
+		R: srgbToLinear(float64((rgb>>16)&0xff) / 255),
+		G: srgbToLinear(float64((rgb>>8)&0xff) / 255),
+		B: srgbToLinear(float64(rgb&0xff) / 255),
+	}, nil
+}
+
+func parseOklchColor(parts []string) (Color, error) {
+	lightnessStr, isPercent := strings.CutSuffix(parts[0], "%")
+	lightness, err := strconv.ParseFloat(lightnessStr, 64)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid oklch lightness: %w", err)
+	}
+	if isPercent {
+		lightness /= 100
+	}
+
+	chroma, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid oklch chroma: %w", err)
+	}
+
+	hue, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return Color{}, fmt.Errorf("invalid oklch hue: %w", err)
+	}
+
+	// Convert OKLCH to OKLab, then OKLab to linear sRGB
+	// See: https://bottosson.github.io/posts/oklab/
+	hueRad := hue * math.Pi / 180
+	a := chroma * math.Cos(hueRad)
+	b := chroma * math.Sin(hueRad)
+
+	l := math.Pow(lightness+0.3963377774*a+0.2158037573*b, 3)
+	m := math.Pow(lightness-0.1055613458*a-0.0638541728*b, 3)
+	s := math.Pow(lightness-0.0894841775*a-1.2914855480*b, 3)
+
+	return Color{
+		R: clampUnit(+4.0767416621*l - 3.3077115913*m + 0.2309699292*s),
+		G: clampUnit(-1.2684380046*l + 2.6097574011*m - 0.3413193965*s),
+		B: clampUnit(-0.0041960863*l - 0.7034186147*m + 1.7076147010*s),
+	}, nil
+}
+
+// RelativeLuminance returns the relative luminance of the color as defined by WCAG 2.x
+func (c Color) RelativeLuminance() float64 {
+	return 0.2126*c.R + 0.7152*c.G + 0.0722*c.B
+}
+
+// ContrastRatio returns the WCAG contrast ratio between two colors, which ranges from 1 to 21
+func ContrastRatio(a, b Color) float64 {
+	la := a.RelativeLuminance()
+	lb := b.RelativeLuminance()
+	if la < lb {
+		la, lb = lb, la
+	}
+	return (la + 0.05) / (lb + 0.05)
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func clampUnit(v float64) float64 {
+	return math.Min(math.Max(v, 0), 1)
+}
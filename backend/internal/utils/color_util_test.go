@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		expected  Color
+		expectErr bool
+	}{
+		{name: "long hex", input: "#ffffff", expected: Color{R: 1, G: 1, B: 1}},
+		{name: "short hex", input: "#000", expected: Color{}},
+		{name: "uppercase hex", input: "#FF0000", expected: Color{R: 1}},
+		{name: "oklch white", input: "oklch(1 0 0)", expected: Color{R: 1, G: 1, B: 1}},
+		{name: "oklch percent lightness", input: "oklch(0% 0 0)", expected: Color{}},
+		{name: "missing hash", input: "ffffff", expectErr: true},
+		{name: "invalid hex digits", input: "#gggggg", expectErr: true},
+		{name: "wrong length", input: "#ffff", expectErr: true},
+		{name: "named color", input: "red", expectErr: true},
+		{name: "empty", input: "", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			color, err := ParseColor(tt.input)
+			if tt.expectErr {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.InDelta(t, tt.expected.R, color.R, 0.001)
+			assert.InDelta(t, tt.expected.G, color.G, 0.001)
+			assert.InDelta(t, tt.expected.B, color.B, 0.001)
+		})
+	}
+}
+
+func TestContrastRatio(t *testing.T) {
+	white, _ := ParseColor("#ffffff")
+	black, _ := ParseColor("#000000")
+	gray, _ := ParseColor("#777777")
+
+	assert.InDelta(t, 21.0, ContrastRatio(white, black), 0.01)
+	assert.InDelta(t, 21.0, ContrastRatio(black, white), 0.01)
+	assert.InDelta(t, 1.0, ContrastRatio(gray, gray), 0.01)
+	// #777777 on white is a well-known borderline case just below 4.5:1
+	assert.InDelta(t, 4.48, ContrastRatio(gray, white), 0.01)
+}
@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type correlationIDCtxKey struct{}
+
+// WithCorrelationID returns a context that carries the correlation ID, which is added to the logs
+func WithCorrelationID(ctx context.Context, correlationID string) context.Context {
+	return context.WithValue(ctx, correlationIDCtxKey{}, correlationID)
+}
+
+// CorrelationIDFromContext returns the correlation ID in the context, or an empty string if there's none
+func CorrelationIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	correlationID, _ := ctx.Value(correlationIDCtxKey{}).(string)
+	return correlationID
+}
+
+// LogCorrelationHandler is a slog.Handler that adds the correlation ID and the OpenTelemetry trace ID in the context to each record
+type LogCorrelationHandler struct {
+	slog.Handler
+}
+
+// Implements slog.Handler
+func (h LogCorrelationHandler) Handle(ctx context.Context, r slog.Record) error {
+	correlationID := CorrelationIDFromContext(ctx)
+	if correlationID != "" {
+		r.AddAttrs(slog.String("correlation_id", correlationID))
+	}
+
+	// The trace ID is often the correlation ID too, in which case it's not repeated
+	if ctx != nil {
+		spanCtx := trace.SpanContextFromContext(ctx)
+		if spanCtx.IsValid() && spanCtx.TraceID().String() != correlationID {
+			r.AddAttrs(slog.String("trace_id", spanCtx.TraceID().String()))
+		}
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// Implements slog.Handler
+func (h LogCorrelationHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return LogCorrelationHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// Implements slog.Handler
+func (h LogCorrelationHandler) WithGroup(name string) slog.Handler {
+	return LogCorrelationHandler{Handler: h.Handler.WithGroup(name)}
+}
@@ -22,6 +22,10 @@ func NewComposer() *Composer {
 type Address struct {
 	Name  string
 	Email string
+	// Locale of the recipient, used to localize the content of the email (not included in the headers)
+	Locale *string
+	// ID of the recipient, used to honor their notification preferences (not included in the headers)
+	UserID string
 }
 
 func (c *Composer) AddAddressHeader(name string, addresses []Address) {
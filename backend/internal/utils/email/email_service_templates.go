@@ -13,8 +13,36 @@ import (
 type Template[V any] struct {
 	Path  string
 	Title func(data *TemplateData[V]) string
+	// Purpose selects the sender address of the email; if empty, the default sender is used
+	Purpose Purpose
+	// Notification is set for non-essential emails that recipients can unsubscribe from.
+	// It must never be set for emails that users need to access their account, like login codes.
+	Notification Notification
 }
 
+// Notification is a kind of non-essential email that users can unsubscribe from
+type Notification string
+
+const (
+	NotificationNewLogin         Notification = "newLogin"
+	NotificationApiKeyExpiration Notification = "apiKeyExpiration"
+)
+
+// Notifications lists all the kinds of notification emails
+var Notifications = []Notification{NotificationNewLogin, NotificationApiKeyExpiration}
+
+// Purpose is the kind of an email, which can have its own sender address
+type Purpose string
+
+const (
+	// PurposeOnboarding is for emails that let users sign in, like login codes
+	PurposeOnboarding Purpose = "onboarding"
+	// PurposeSecurity is for alerts about the activity of an account, like sign ins from new devices
+	PurposeSecurity Purpose = "security"
+	// PurposeNotification is for informational emails, like expiring API keys
+	PurposeNotification Purpose = "notification"
+)
+
 type TemplateData[V any] struct {
 	AppName string
 	LogoURL string
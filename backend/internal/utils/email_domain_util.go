@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/pocket-id/pocket-id/backend/resources"
+)
+
+// disposableEmailDomains is loaded lazily from the embedded list of disposable email providers
+var disposableEmailDomains = sync.OnceValue(func() map[string]struct{} {
+	data, err := resources.FS.ReadFile("disposable-email-domains.txt")
+	if err != nil {
+		slog.Error("Failed to read the list of disposable email domains", slog.Any("error", err))
+		return map[string]struct{}{}
+	}
+
+	domains := make(map[string]struct{})
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		domains[strings.ToLower(line)] = struct{}{}
+	}
+	return domains
+})
+
+// EmailDomain returns the lowercase domain of the email address, or an empty string if the address has no domain
+func EmailDomain(email string) string {
+	i := strings.LastIndexByte(email, '@')
+	if i == -1 {
+		return ""
+	}
+	return strings.TrimSuffix(strings.ToLower(email[i+1:]), ".")
+}
+
+// ParseDomainList parses a list of domains separated by commas, spaces or new lines.
+// The domains are lowercased, and a leading "@" is removed, so that "@Example.com" becomes "example.com".
+func ParseDomainList(value string) []string {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\r' || r == '\t'
+	})
+
+	domains := make([]string, 0, len(fields))
+	for _, field := range fields {
+		domain := strings.TrimSuffix(strings.TrimPrefix(strings.ToLower(field), "@"), ".")
+		if domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// DomainMatchesAny returns true if the domain matches any of the patterns.
+// A pattern matches the same domain, and a pattern starting with "*." matches the subdomains of the rest of the pattern, e.g. "*.example.com" matches "mail.example.com" but not "example.com".
+// The comparison is case-insensitive.
+func DomainMatchesAny(domain string, patterns []string) bool {
+	domain = strings.ToLower(domain)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		if parent, ok := strings.CutPrefix(pattern, "*."); ok {
+			if strings.HasSuffix(domain, "."+parent) {
+				return true
+			}
+			continue
+		}
+		if domain == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDisposableEmailDomain returns true if the domain, or one of its parent domains, belongs to a known disposable email provider
+func IsDisposableEmailDomain(domain string) bool {
+	domains := disposableEmailDomains()
+	domain = strings.ToLower(domain)
+	for domain != "" {
+		if _, ok := domains[domain]; ok {
+			return true
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEmailDomain(t *testing.T) {
+	assert.Equal(t, "example.com", EmailDomain("john@Example.COM"))
+	assert.Equal(t, "example.com", EmailDomain("\"john@home\"@example.com."))
+	assert.Empty(t, EmailDomain("john"))
+}
+
+func TestParseDomainList(t *testing.T) {
+	assert.Equal(t, []string{"example.com", "*.example.org", "example.net"}, ParseDomainList(" @Example.com,*.example.org\nexample.net; "))
+	assert.Empty(t, ParseDomainList(""))
+}
+
+func TestDomainMatchesAny(t *testing.T) {
+	patterns := []string{"example.com", "*.Example.org"}
+
+	assert.True(t, DomainMatchesAny("example.com", patterns))
+	assert.True(t, DomainMatchesAny("EXAMPLE.com", patterns))
+	assert.False(t, DomainMatchesAny("mail.example.com", patterns))
+	assert.True(t, DomainMatchesAny("mail.example.org", patterns))
+	assert.True(t, DomainMatchesAny("a.b.example.org", patterns))
+	assert.False(t, DomainMatchesAny("example.org", patterns))
+	assert.False(t, DomainMatchesAny("badexample.org", patterns))
+}
+
+func TestIsDisposableEmailDomain(t *testing.T) {
+	assert.True(t, IsDisposableEmailDomain("mailinator.com"))
+	assert.True(t, IsDisposableEmailDomain("inbox.Mailinator.com"))
+	assert.False(t, IsDisposableEmailDomain("example.com"))
+	assert.False(t, IsDisposableEmailDomain("com"))
+	assert.False(t, IsDisposableEmailDomain(""))
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,6 +10,8 @@ import (
 	"path/filepath"
 
 	"github.com/google/uuid"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 	"github.com/pocket-id/pocket-id/backend/resources"
 )
 
@@ -63,6 +66,17 @@ func CopyEmbeddedFileToDisk(srcFilePath, destFilePath string) error {
 	return nil
 }
 
+// ReadFile reads the content of an uploaded file
+func ReadFile(file *multipart.FileHeader) ([]byte, error) {
+	src, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+
+	return io.ReadAll(src)
+}
+
 func SaveFile(file *multipart.FileHeader, dst string) error {
 	src, err := file.Open()
 	if err != nil {
@@ -79,14 +93,13 @@ func SaveFile(file *multipart.FileHeader, dst string) error {
 
 // SaveFileStream saves a stream to a file.
 func SaveFileStream(r io.Reader, dstFileName string) error {
-	// Our strategy is to save to a separate file and then rename it to override the original file
-	tmpFileName := dstFileName + "." + uuid.NewString() + "-tmp"
-
-	// Write to the temporary file
-	tmpFile, err := os.Create(tmpFileName)
+	// Our strategy is to save to a separate file and then move it to override the original file
+	// The temporary file is created in the directory configured with TEMP_PATH, or next to the destination
+	tmpFile, err := CreateTempFile(dstFileName)
 	if err != nil {
-		return fmt.Errorf("failed to open file '%s' for writing: %w", tmpFileName, err)
+		return fmt.Errorf("failed to create temporary file for '%s': %w", dstFileName, err)
 	}
+	tmpFileName := tmpFile.Name()
 
 	n, err := io.Copy(tmpFile, r)
 	if err != nil {
@@ -112,19 +125,135 @@ func SaveFileStream(r io.Reader, dstFileName string) error {
 		return errors.New("no data written")
 	}
 
-	// Rename to the final file, which overrides existing files
-	// This is an atomic operation
-	err = os.Rename(tmpFileName, dstFileName)
+	// Move to the final file, which overrides existing files
+	err = MoveFile(tmpFileName, dstFileName)
 	if err != nil {
 		// Delete the temporary file; we ignore errors here
 		_ = os.Remove(tmpFileName)
 
+		return err
+	}
+
+	return nil
+}
+
+// CreateTempFile creates a temporary file that will later be moved to dstFileName.
+// The file is created in the directory configured with TEMP_PATH, or in the same directory as the destination if it's not set.
+func CreateTempFile(dstFileName string) (*os.File, error) {
+	dir := common.EnvConfig.TempPath
+	if dir == "" {
+		dir = filepath.Dir(dstFileName)
+	}
+
+	return os.CreateTemp(dir, filepath.Base(dstFileName)+".*-tmp")
+}
+
+// MoveFile moves a file to its destination, replacing any existing file.
+// The destination is replaced atomically even if the source is on a different filesystem: in this case, the file is first copied next to the destination and then renamed.
+func MoveFile(srcFileName, dstFileName string) error {
+	err := os.Rename(srcFileName, dstFileName)
+	if err == nil {
+		return nil
+	}
+
+	// Renaming only fails for files in different directories if they are on different filesystems
+	// In this case, we copy the file to the directory of the destination first
+	if filepath.Dir(filepath.Clean(srcFileName)) == filepath.Dir(filepath.Clean(dstFileName)) {
 		return fmt.Errorf("failed to rename file '%s': %w", dstFileName, err)
 	}
 
+	stagedFileName := dstFileName + "." + uuid.NewString() + "-tmp"
+	err = copyFile(srcFileName, stagedFileName)
+	if err != nil {
+		// Delete the staged file; we ignore errors here
+		_ = os.Remove(stagedFileName)
+
+		return fmt.Errorf("failed to copy file '%s' to '%s': %w", srcFileName, stagedFileName, err)
+	}
+
+	err = os.Rename(stagedFileName, dstFileName)
+	if err != nil {
+		// Delete the staged file; we ignore errors here
+		_ = os.Remove(stagedFileName)
+
+		return fmt.Errorf("failed to rename file '%s': %w", dstFileName, err)
+	}
+
+	// The file has been moved, so the source can be removed
+	_ = os.Remove(srcFileName)
+
 	return nil
 }
 
+func copyFile(srcFileName, dstFileName string) error {
+	src, err := os.Open(srcFileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(dstFileName)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	// Flush the data to disk before the file is renamed
+	err = dst.Sync()
+	if err != nil {
+		_ = dst.Close()
+		return err
+	}
+
+	return dst.Close()
+}
+
+// EnsureDirWritable creates the directory if needed and checks that files can be written in it
+func EnsureDirWritable(dir string) error {
+	err := os.MkdirAll(dir, 0o750)
+	if err != nil {
+		return fmt.Errorf("failed to create directory '%s': %w", dir, err)
+	}
+
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory '%s' is not writable: %w", dir, err)
+	}
+	_ = f.Close()
+	_ = os.Remove(f.Name())
+
+	return nil
+}
+
+// ContextReader returns a reader that fails with the error of the context once the context is canceled.
+// This allows long copies to stop promptly, for example if the request is aborted or the server is shutting down.
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, r: r}
+}
+
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	err := r.ctx.Err()
+	if err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// CopyWithContext is like io.Copy, but it stops once the context is canceled
+func CopyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, ContextReader(ctx, src))
+}
+
 // FileExists returns true if a file exists on disk and is a regular file
 func FileExists(path string) (bool, error) {
 	s, err := os.Stat(path)
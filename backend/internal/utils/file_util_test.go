@@ -1,7 +1,17 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 )
 
 func TestGetFileExtension(t *testing.T) {
@@ -71,3 +81,87 @@ func TestGetFileExtension(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyWithContext(t *testing.T) {
+	t.Run("copies the data", func(t *testing.T) {
+		var dst bytes.Buffer
+		n, err := CopyWithContext(t.Context(), &dst, strings.NewReader("hello world"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(11), n)
+		assert.Equal(t, "hello world", dst.String())
+	})
+
+	t.Run("stops when the context is canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(t.Context())
+		cancel()
+
+		var dst bytes.Buffer
+		_, err := CopyWithContext(ctx, &dst, strings.NewReader("hello world"))
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, dst.String())
+	})
+}
+
+func TestSaveFileStream(t *testing.T) {
+	t.Run("saves the file", func(t *testing.T) {
+		dst := filepath.Join(t.TempDir(), "image.png")
+		require.NoError(t, os.WriteFile(dst, []byte("old"), 0o600))
+
+		err := SaveFileStream(strings.NewReader("new"), dst)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, "new", string(data))
+
+		// No temporary files are left behind
+		entries, err := os.ReadDir(filepath.Dir(dst))
+		require.NoError(t, err)
+		assert.Len(t, entries, 1)
+	})
+
+	t.Run("uses the temporary directory if configured", func(t *testing.T) {
+		originalTempPath := common.EnvConfig.TempPath
+		t.Cleanup(func() {
+			common.EnvConfig.TempPath = originalTempPath
+		})
+		common.EnvConfig.TempPath = t.TempDir()
+
+		dst := filepath.Join(t.TempDir(), "image.png")
+		err := SaveFileStream(strings.NewReader("data"), dst)
+		require.NoError(t, err)
+
+		data, err := os.ReadFile(dst)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+
+		entries, err := os.ReadDir(common.EnvConfig.TempPath)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestMoveFile(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.WriteFile(src, []byte("data"), 0o600))
+
+	dst := filepath.Join(t.TempDir(), "dst")
+	err := MoveFile(src, dst)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	_, err = os.Stat(src)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestEnsureDirWritable(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "tmp")
+	require.NoError(t, EnsureDirWritable(dir))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
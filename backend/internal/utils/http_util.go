@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"net/http"
 	"strconv"
 	"strings"
@@ -31,3 +33,19 @@ func SetCacheControlHeader(ctx *gin.Context, maxAge, staleWhileRevalidate time.D
 	}
 
 }
+
+// SetETagHeader sets the ETag header to a hash of the content of the response.
+// It returns true if the client already has the same content, according to the If-None-Match header.
+func SetETagHeader(ctx *gin.Context, data []byte) bool {
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+	ctx.Header("ETag", etag)
+
+	for _, candidate := range strings.Split(ctx.GetHeader("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
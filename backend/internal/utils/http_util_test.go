@@ -2,8 +2,10 @@ package utils
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -63,3 +65,29 @@ func TestBearerAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestSetETagHeader(t *testing.T) {
+	newContext := func(ifNoneMatch string) (*gin.Context, *httptest.ResponseRecorder) {
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+		if ifNoneMatch != "" {
+			c.Request.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return c, recorder
+	}
+
+	c, recorder := newContext("")
+	assert.False(t, SetETagHeader(c, []byte("logo")))
+	etag := recorder.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	c, _ = newContext(`"other", ` + etag)
+	assert.True(t, SetETagHeader(c, []byte("logo")))
+
+	c, _ = newContext("W/" + etag)
+	assert.True(t, SetETagHeader(c, []byte("logo")))
+
+	c, _ = newContext(etag)
+	assert.False(t, SetETagHeader(c, []byte("new logo")))
+}
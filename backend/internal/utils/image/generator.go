@@ -0,0 +1,56 @@
+package imageutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Generator creates the default profile pictures of users who didn't upload one
+type Generator interface {
+	// Name identifies the generator; it's part of the path of the cached pictures, so switching generators doesn't serve stale pictures
+	Name() string
+	// Key returns the value the picture of the user is generated from. Users with the same key share the same picture.
+	// The key is used in file names, so it must not contain path separators.
+	Key(userID, initials string) string
+	// Generate creates the picture for the key
+	Generate(key string) (*bytes.Buffer, error)
+}
+
+// NewGenerator returns the generator with the given name, or nil if there is none
+func NewGenerator(name string) Generator {
+	switch name {
+	case "initials":
+		return InitialsGenerator{}
+	case "identicon":
+		return IdenticonGenerator{}
+	default:
+		return nil
+	}
+}
+
+// InitialsGenerator draws the initials of the user on a white background
+type InitialsGenerator struct{}
+
+func (InitialsGenerator) Name() string { return "initials" }
+
+func (InitialsGenerator) Key(_, initials string) string { return initials }
+
+func (InitialsGenerator) Generate(key string) (*bytes.Buffer, error) {
+	return CreateDefaultProfilePicture(key)
+}
+
+// IdenticonGenerator draws a symmetric pattern derived from the user ID, so every user has a distinct picture
+type IdenticonGenerator struct{}
+
+func (IdenticonGenerator) Name() string { return "identicon" }
+
+func (IdenticonGenerator) Key(userID, _ string) string {
+	// The user ID isn't used directly, so the file names don't reveal the IDs
+	hash := sha256.Sum256([]byte(userID))
+	return hex.EncodeToString(hash[:16])
+}
+
+func (IdenticonGenerator) Generate(key string) (*bytes.Buffer, error) {
+	return createIdenticon(key)
+}
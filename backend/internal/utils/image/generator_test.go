@@ -0,0 +1,36 @@
+package imageutil
+
+import (
+	"bytes"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewGenerator(t *testing.T) {
+	assert.Equal(t, InitialsGenerator{}, NewGenerator("initials"))
+	assert.Equal(t, IdenticonGenerator{}, NewGenerator("identicon"))
+	assert.Nil(t, NewGenerator("unknown"))
+}
+
+func TestIdenticonGenerator(t *testing.T) {
+	generator := IdenticonGenerator{}
+
+	key := generator.Key("4b8f1c9e-1f3a-4a4e-9c7e-2d3c5b6a7f80", "JD")
+	assert.Len(t, key, 32)
+	assert.NotContains(t, key, "4b8f1c9e")
+	assert.NotEqual(t, key, generator.Key("0d6a2e1f-7b2c-4f3e-8a9d-1c2b3a4d5e6f", "JD"))
+
+	first, err := generator.Generate(key)
+	require.NoError(t, err)
+	second, err := generator.Generate(key)
+	require.NoError(t, err)
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	img, err := png.Decode(bytes.NewReader(first.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, ProfilePictureSize, img.Bounds().Dx())
+	assert.Equal(t, ProfilePictureSize, img.Bounds().Dy())
+}
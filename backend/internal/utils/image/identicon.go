@@ -0,0 +1,82 @@
+package imageutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+const (
+	identiconGridSize = 5
+	identiconCellSize = 50
+	identiconMargin   = (ProfilePictureSize - identiconGridSize*identiconCellSize) / 2
+)
+
+// createIdenticon draws a 5x5 grid of cells that is mirrored horizontally, in a color derived from the key
+func createIdenticon(key string) (*bytes.Buffer, error) {
+	hash := sha256.Sum256([]byte(key))
+
+	img := image.NewRGBA(image.Rect(0, 0, ProfilePictureSize, ProfilePictureSize))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{R: 240, G: 240, B: 240, A: 255}), image.Point{}, draw.Src)
+
+	hue := float64(uint16(hash[0])<<8|uint16(hash[1])) / math.MaxUint16 * 360
+	fill := image.NewUniform(hslToRGB(hue, 0.55, 0.55))
+
+	// Only the first three columns are derived from the hash, the last two mirror them
+	for row := range identiconGridSize {
+		for col := range (identiconGridSize + 1) / 2 {
+			bit := row*3 + col
+			if hash[2+bit/8]&(1<<(bit%8)) == 0 {
+				continue
+			}
+			for _, c := range []int{col, identiconGridSize - 1 - col} {
+				x := identiconMargin + c*identiconCellSize
+				y := identiconMargin + row*identiconCellSize
+				draw.Draw(img, image.Rect(x, y, x+identiconCellSize, y+identiconCellSize), fill, image.Point{}, draw.Src)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	err := imaging.Encode(&buf, img, imaging.PNG)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return &buf, nil
+}
+
+func hslToRGB(hue, saturation, lightness float64) color.RGBA {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := lightness - chroma/2
+
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = chroma, x, 0
+	case hue < 120:
+		r, g, b = x, chroma, 0
+	case hue < 180:
+		r, g, b = 0, chroma, x
+	case hue < 240:
+		r, g, b = 0, x, chroma
+	case hue < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+
+	return color.RGBA{
+		R: uint8(math.Round((r + m) * 255)),
+		G: uint8(math.Round((g + m) * 255)),
+		B: uint8(math.Round((b + m) * 255)),
+		A: 255,
+	}
+}
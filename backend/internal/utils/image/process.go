@@ -0,0 +1,217 @@
+package imageutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/disintegration/imageorient"
+	"github.com/disintegration/imaging"
+
+	// Register the decoders for the raster image types that can be uploaded
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// Limits of all uploaded raster images, to protect against decompression bombs
+const (
+	MaxPixels    = 40_000_000
+	MaxDimension = 20_000
+)
+
+// SupportedTypes contains the extensions of all image types that can be uploaded
+var SupportedTypes = []string{"png", "jpg", "jpeg", "gif", "ico", "svg"}
+
+// Options configure how Process validates and converts an image
+type Options struct {
+	// Types of the accepted images, as returned by DetectType
+	AllowedTypes []string
+	// Maximum size of the image data in bytes
+	MaxInputSize int64
+	// Format the image is converted to: "png", or empty to keep the original data.
+	// Converting applies the EXIF orientation and strips all metadata, like the EXIF data of photos.
+	OutputFormat string
+	// If set, the image is cropped to a square of this size when it's converted
+	SquareSize int
+}
+
+// Image is an image returned by Process
+type Image struct {
+	Data []byte
+	// The output format, or the detected type of the original data if the image wasn't converted
+	Type string
+}
+
+// Process validates an uploaded image against the limits and the options, and converts it if an output format is set
+func Process(data []byte, opts Options) (Image, error) {
+	if opts.MaxInputSize > 0 && int64(len(data)) > opts.MaxInputSize {
+		return Image{}, &common.FileTooLargeError{MaxSize: formatSize(opts.MaxInputSize)}
+	}
+
+	// The content is checked before the image is decoded, as the decoders accept more formats than we allow
+	imageType, err := DetectType(data)
+	if err != nil {
+		return Image{}, err
+	}
+	if !slices.Contains(opts.AllowedTypes, imageType) {
+		return Image{}, &common.FileTypeNotSupportedError{}
+	}
+
+	if opts.OutputFormat == "" {
+		return Image{Data: data, Type: imageType}, nil
+	}
+
+	format, err := imaging.FormatFromExtension(opts.OutputFormat)
+	if err != nil {
+		return Image{}, fmt.Errorf("unsupported output format '%s': %w", opts.OutputFormat, err)
+	}
+
+	img, _, err := imageorient.Decode(bytes.NewReader(data))
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if opts.SquareSize > 0 {
+		img = imaging.Fill(img, opts.SquareSize, opts.SquareSize, imaging.Center, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	err = imaging.Encode(&buf, img, format)
+	if err != nil {
+		return Image{}, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	return Image{Data: buf.Bytes(), Type: opts.OutputFormat}, nil
+}
+
+// DetectType sniffs the content of an image and returns its type: "png", "jpg", "gif", "ico" or "svg".
+// The content must start with the magic bytes of the type, and raster images must be fully decodable within the limits,
+// so files that only pretend to be an image (like polyglots) are rejected.
+func DetectType(data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+
+	var imageType string
+	switch {
+	case contentType == "image/png" && bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		imageType = "png"
+	case contentType == "image/jpeg" && bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		imageType = "jpg"
+	case contentType == "image/gif" && (bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))):
+		imageType = "gif"
+	case contentType == "image/x-icon" && isIco(data):
+		return "ico", nil
+	case (strings.HasPrefix(contentType, "text/xml") || strings.HasPrefix(contentType, "text/plain")) && isSvg(data):
+		return "svg", nil
+	default:
+		return "", &common.FileContentMismatchError{}
+	}
+
+	// Data appended after the end of the image is how most polyglots are built
+	if !hasImageTrailer(imageType, data) {
+		return "", &common.FileContentMismatchError{}
+	}
+
+	err := validateRasterImage(data)
+	if err != nil {
+		return "", err
+	}
+
+	return imageType, nil
+}
+
+// NormalizeType returns the type of an image with the given extension, as returned by DetectType
+func NormalizeType(extension string) string {
+	extension = strings.ToLower(extension)
+	if extension == "jpeg" {
+		return "jpg"
+	}
+	return extension
+}
+
+// hasImageTrailer checks that the data ends with the marker that terminates images of the given type
+func hasImageTrailer(imageType string, data []byte) bool {
+	switch imageType {
+	case "png":
+		return bytes.HasSuffix(data, []byte("\x00\x00\x00\x00IEND\xaeB`\x82"))
+	case "jpg":
+		// Valid photos often have data after the end of the image, like the video of motion photos
+		// or the additional images of the Multi-Picture Format, so JPEG images are only validated by decoding them
+		return true
+	case "gif":
+		return bytes.HasSuffix(data, []byte{0x3b})
+	default:
+		return false
+	}
+}
+
+func validateRasterImage(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return &common.FileContentMismatchError{}
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > MaxDimension || config.Height > MaxDimension || config.Width*config.Height > MaxPixels {
+		return &common.ValidationError{Message: fmt.Sprintf("image dimensions %dx%d are not allowed", config.Width, config.Height)}
+	}
+
+	_, _, err = image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return &common.FileContentMismatchError{}
+	}
+
+	return nil
+}
+
+// isIco checks the header of an ICO file: reserved field, type 1 and at least one image
+func isIco(data []byte) bool {
+	if len(data) < 6 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(data[0:2]) == 0 &&
+		binary.LittleEndian.Uint16(data[2:4]) == 1 &&
+		binary.LittleEndian.Uint16(data[4:6]) > 0
+}
+
+// isSvg checks that the root element of the XML document is an "svg" element.
+// Only an XML declaration, comments, whitespace and a doctype are allowed before it.
+func isSvg(data []byte) bool {
+	s := string(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		switch {
+		case strings.HasPrefix(s, "<?xml"):
+			_, rest, ok := strings.Cut(s, "?>")
+			if !ok {
+				return false
+			}
+			s = rest
+		case strings.HasPrefix(s, "<!--"):
+			_, rest, ok := strings.Cut(s, "-->")
+			if !ok {
+				return false
+			}
+			s = rest
+		case strings.HasPrefix(s, "<!DOCTYPE svg"):
+			_, rest, ok := strings.Cut(s, ">")
+			if !ok {
+				return false
+			}
+			s = rest
+		default:
+			return strings.HasPrefix(s, "<svg") && len(s) > 4 && strings.ContainsRune(" \t\r\n>/", rune(s[4]))
+		}
+	}
+}
+
+// formatSize formats a size in bytes as megabytes or kilobytes
+func formatSize(size int64) string {
+	if size >= 1<<20 {
+		return fmt.Sprintf("%d MB", size>>20)
+	}
+	return fmt.Sprintf("%d KB", size>>10)
+}
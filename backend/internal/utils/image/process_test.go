@@ -0,0 +1,112 @@
+package imageutil
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+func TestDetectType(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 1, color.RGBA{R: 255, A: 255})
+
+	var pngData, jpegData, gifData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, img))
+	require.NoError(t, jpeg.Encode(&jpegData, img, nil))
+	require.NoError(t, gif.Encode(&gifData, img, nil))
+
+	validTests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"png", pngData.Bytes(), "png"},
+		{"jpeg", jpegData.Bytes(), "jpg"},
+		// Motion photos have the video appended after the end of the image
+		{"jpeg with appended data", append(bytes.Clone(jpegData.Bytes()), []byte("\x00\x00\x00\x18ftypmp42")...), "jpg"},
+		{"gif", gifData.Bytes(), "gif"},
+		{"ico", []byte{0, 0, 1, 0, 1, 0, 16, 16, 0, 0, 1, 0, 32, 0}, "ico"},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`), "svg"},
+		{"svg with prolog", []byte("<?xml version=\"1.0\"?>\n<!-- logo -->\n<svg></svg>"), "svg"},
+	}
+	for _, tt := range validTests {
+		t.Run(tt.name, func(t *testing.T) {
+			imageType, err := DetectType(tt.data)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, imageType)
+		})
+	}
+
+	invalidTests := []struct {
+		name string
+		data []byte
+	}{
+		{"polyglot png with html", append(bytes.Clone(pngData.Bytes()), []byte("<html><script>alert(1)</script></html>")...)},
+		{"polyglot gif with javascript", []byte("GIF89a/*\x01\x00\x01\x00\x00\x00\x00*/=alert(1);//;")},
+		{"truncated png", pngData.Bytes()[:40]},
+		{"html", []byte("<!DOCTYPE html><html><body><svg></svg></body></html>")},
+		{"svg inside other xml", []byte(`<?xml version="1.0"?><html><svg></svg></html>`)},
+		{"text", []byte("hello world")},
+	}
+	for _, tt := range invalidTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DetectType(tt.data)
+			var mismatchErr *common.FileContentMismatchError
+			require.ErrorAs(t, err, &mismatchErr)
+		})
+	}
+}
+
+func TestProcess(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 40, 20))
+	var pngData, gifData bytes.Buffer
+	require.NoError(t, png.Encode(&pngData, img))
+	require.NoError(t, gif.Encode(&gifData, img, nil))
+
+	t.Run("keeps the original data without an output format", func(t *testing.T) {
+		result, err := Process(pngData.Bytes(), Options{AllowedTypes: SupportedTypes})
+		require.NoError(t, err)
+		assert.Equal(t, "png", result.Type)
+		assert.Equal(t, pngData.Bytes(), result.Data)
+	})
+
+	t.Run("converts the image to a square", func(t *testing.T) {
+		result, err := Process(gifData.Bytes(), Options{AllowedTypes: SupportedTypes, OutputFormat: "png", SquareSize: 10})
+		require.NoError(t, err)
+		assert.Equal(t, "png", result.Type)
+
+		converted, err := png.Decode(bytes.NewReader(result.Data))
+		require.NoError(t, err)
+		assert.Equal(t, image.Rect(0, 0, 10, 10), converted.Bounds())
+	})
+
+	t.Run("rejects types that aren't allowed", func(t *testing.T) {
+		_, err := Process(gifData.Bytes(), Options{AllowedTypes: []string{"png", "jpg"}})
+		var notSupportedErr *common.FileTypeNotSupportedError
+		require.ErrorAs(t, err, &notSupportedErr)
+	})
+
+	t.Run("rejects large files", func(t *testing.T) {
+		_, err := Process(pngData.Bytes(), Options{AllowedTypes: SupportedTypes, MaxInputSize: 10})
+		var tooLargeErr *common.FileTooLargeError
+		require.ErrorAs(t, err, &tooLargeErr)
+	})
+
+	t.Run("rejects images that exceed the maximum dimension", func(t *testing.T) {
+		var wide bytes.Buffer
+		require.NoError(t, png.Encode(&wide, image.NewGray(image.Rect(0, 0, MaxDimension+1, 1))))
+
+		_, err := Process(wide.Bytes(), Options{AllowedTypes: SupportedTypes})
+		var validationErr *common.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+	})
+}
@@ -1,13 +1,11 @@
-package profilepicture
+package imageutil
 
 import (
 	"bytes"
 	"fmt"
 	"image"
 	"image/color"
-	"io"
 
-	"github.com/disintegration/imageorient"
 	"github.com/disintegration/imaging"
 	"golang.org/x/image/font"
 	"golang.org/x/image/font/opentype"
@@ -16,34 +14,13 @@ import (
 	"github.com/pocket-id/pocket-id/backend/resources"
 )
 
-const profilePictureSize = 300
-
-// CreateProfilePicture resizes the profile picture to a square
-func CreateProfilePicture(file io.Reader) (io.Reader, error) {
-	img, _, err := imageorient.Decode(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
-	}
-
-	img = imaging.Fill(img, profilePictureSize, profilePictureSize, imaging.Center, imaging.Lanczos)
-
-	pr, pw := io.Pipe()
-	go func() {
-		innerErr := imaging.Encode(pw, img, imaging.PNG)
-		if innerErr != nil {
-			_ = pw.CloseWithError(fmt.Errorf("failed to encode image: %w", innerErr))
-			return
-		}
-		pw.Close()
-	}()
-
-	return pr, nil
-}
+// Width and height of the profile pictures
+const ProfilePictureSize = 300
 
 // CreateDefaultProfilePicture creates a profile picture with the initials
 func CreateDefaultProfilePicture(initials string) (*bytes.Buffer, error) {
 	// Create a blank image with a white background
-	img := imaging.New(profilePictureSize, profilePictureSize, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	img := imaging.New(ProfilePictureSize, ProfilePictureSize, color.RGBA{R: 255, G: 255, B: 255, A: 255})
 
 	// Load the font
 	fontBytes, err := resources.FS.ReadFile("fonts/PlayfairDisplay-Bold.ttf")
@@ -75,8 +52,8 @@ func CreateDefaultProfilePicture(initials string) (*bytes.Buffer, error) {
 	}
 
 	// Center the initials
-	x := (profilePictureSize - font.MeasureString(face, initials).Ceil()) / 2
-	y := (profilePictureSize-face.Metrics().Height.Ceil())/2 + face.Metrics().Ascent.Ceil() - 10
+	x := (ProfilePictureSize - font.MeasureString(face, initials).Ceil()) / 2
+	y := (ProfilePictureSize-face.Metrics().Height.Ceil())/2 + face.Metrics().Ascent.Ceil() - 10
 	drawer.Dot = fixed.P(x, y)
 
 	// Draw the initials
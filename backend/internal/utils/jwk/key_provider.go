@@ -1,6 +1,7 @@
 package jwk
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/lestrrat-go/jwx/v3/jwk"
@@ -21,6 +22,19 @@ type KeyProvider interface {
 	SaveKey(key jwk.Key) error
 }
 
+// KeyReplacer is implemented by key providers that can't overwrite an existing key with SaveKey
+type KeyReplacer interface {
+	ReplaceKey(key jwk.Key) error
+}
+
+// ReplaceKey saves a key, overwriting the existing one
+func ReplaceKey(keyProvider KeyProvider, key jwk.Key) error {
+	if r, ok := keyProvider.(KeyReplacer); ok {
+		return r.ReplaceKey(key)
+	}
+	return keyProvider.SaveKey(key)
+}
+
 func GetKeyProvider(db *gorm.DB, envConfig *common.EnvConfigSchema, instanceID string) (keyProvider KeyProvider, err error) {
 	// Load the encryption key (KEK) if present
 	kek, err := LoadKeyEncryptionKey(envConfig, instanceID)
@@ -34,6 +48,10 @@ func GetKeyProvider(db *gorm.DB, envConfig *common.EnvConfigSchema, instanceID s
 		keyProvider = &KeyProviderFile{}
 	case "database":
 		keyProvider = &KeyProviderDatabase{}
+	case "pem":
+		keyProvider = &KeyProviderPEM{}
+	case "kms":
+		return nil, errors.New("keys stored in a KMS can't be loaded or saved by Pocket ID; manage them in the KMS instead")
 	default:
 		return nil, fmt.Errorf("invalid key storage '%s'", envConfig.KeysStorage)
 	}
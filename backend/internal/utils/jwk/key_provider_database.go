@@ -9,6 +9,7 @@ import (
 
 	"github.com/lestrrat-go/jwx/v3/jwk"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"github.com/pocket-id/pocket-id/backend/internal/model"
 	cryptoutils "github.com/pocket-id/pocket-id/backend/internal/utils/crypto"
@@ -74,36 +75,70 @@ func (f *KeyProviderDatabase) LoadKey() (key jwk.Key, err error) {
 }
 
 func (f *KeyProviderDatabase) SaveKey(key jwk.Key) error {
-	// Encode the key to JSON
-	data, err := EncodeJWKBytes(key)
+	row, err := f.encryptKey(key)
 	if err != nil {
-		return fmt.Errorf("failed to encode key to JSON: %w", err)
+		return err
 	}
 
-	// Encrypt the key then encode to Base64
-	enc, err := cryptoutils.Encrypt(f.kek, data, nil)
+	// Save to database
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err = f.db.WithContext(ctx).Create(&row).Error
 	if err != nil {
-		return fmt.Errorf("failed to encrypt key: %w", err)
+		// There's one scenario where if Pocket ID is started fresh with more than 1 replica, they both could be trying to create the private key in the database at the same time
+		// In this case, only one of the replicas will succeed; the other one(s) will return an error here, which will cascade down and cause the replica(s) to crash and be restarted (at that point they'll load the then-existing key from the database)
+		return fmt.Errorf("failed to store private key in database: %w", err)
 	}
-	encB64 := base64.StdEncoding.EncodeToString(enc)
 
-	// Save to database
-	row := model.KV{
-		Key:   PrivateKeyDBKey,
-		Value: &encB64,
+	return nil
+}
+
+// ReplaceKey overwrites the key in the database, which SaveKey doesn't do
+func (f *KeyProviderDatabase) ReplaceKey(key jwk.Key) error {
+	row, err := f.encryptKey(key)
+	if err != nil {
+		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	err = f.db.WithContext(ctx).Create(&row).Error
+	err = f.db.
+		WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"value"}),
+		}).
+		Create(&row).
+		Error
 	if err != nil {
-		// There's one scenario where if Pocket ID is started fresh with more than 1 replica, they both could be trying to create the private key in the database at the same time
-		// In this case, only one of the replicas will succeed; the other one(s) will return an error here, which will cascade down and cause the replica(s) to crash and be restarted (at that point they'll load the then-existing key from the database)
 		return fmt.Errorf("failed to store private key in database: %w", err)
 	}
 
 	return nil
 }
 
+func (f *KeyProviderDatabase) encryptKey(key jwk.Key) (model.KV, error) {
+	// Encode the key to JSON
+	data, err := EncodeJWKBytes(key)
+	if err != nil {
+		return model.KV{}, fmt.Errorf("failed to encode key to JSON: %w", err)
+	}
+
+	// Encrypt the key then encode to Base64
+	enc, err := cryptoutils.Encrypt(f.kek, data, nil)
+	if err != nil {
+		return model.KV{}, fmt.Errorf("failed to encrypt key: %w", err)
+	}
+	encB64 := base64.StdEncoding.EncodeToString(enc)
+
+	return model.KV{
+		Key:   PrivateKeyDBKey,
+		Value: &encB64,
+	}, nil
+}
+
 // Compile-time interface check
-var _ KeyProvider = (*KeyProviderDatabase)(nil)
+var (
+	_ KeyProvider = (*KeyProviderDatabase)(nil)
+	_ KeyReplacer = (*KeyProviderDatabase)(nil)
+)
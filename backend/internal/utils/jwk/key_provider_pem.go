@@ -0,0 +1,111 @@
+package jwk
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lestrrat-go/jwx/v3/jwk"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// KeyProviderPEM loads the key from a PEM file, like the ones generated by openssl.
+// Because PEM files don't contain a key ID, the key ID is the thumbprint of the key.
+type KeyProviderPEM struct {
+	envConfig *common.EnvConfigSchema
+}
+
+func (f *KeyProviderPEM) Init(opts KeyProviderOpts) error {
+	if opts.EnvConfig.KeysPemFile == "" {
+		return errors.New("a PEM file is required when using the 'pem' key provider")
+	}
+
+	f.envConfig = opts.EnvConfig
+
+	return nil
+}
+
+func (f *KeyProviderPEM) LoadKey() (jwk.Key, error) {
+	path := f.envConfig.KeysPemFile
+	ok, err := utils.FileExists(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if private key file exists at path '%s': %w", path, err)
+	}
+	if !ok {
+		// File doesn't exist, no key was loaded
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key file at path '%s': %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("file at path '%s' doesn't contain a PEM block", path)
+	}
+
+	var rawKey any
+	switch block.Type {
+	case "PRIVATE KEY":
+		rawKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		rawKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		rawKey, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s' in file at path '%s'", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key file at path '%s': %w", path, err)
+	}
+
+	alg := f.envConfig.KeysAlgorithm
+	if alg == "" {
+		alg = DefaultKeyAlgorithm(rawKey)
+	}
+
+	key, err := ImportRawKey(rawKey, alg, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = SetThumbprintKeyID(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (f *KeyProviderPEM) SaveKey(key jwk.Key) error {
+	var rawKey any
+	err := jwk.Export(key, &rawKey)
+	if err != nil {
+		return fmt.Errorf("failed to export private key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(rawKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	path := f.envConfig.KeysPemFile
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create directory '%s' for key file: %w", filepath.Dir(path), err)
+	}
+
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write private key file at path '%s': %w", path, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+func newTestKeyProviderPEM(t *testing.T, path string) *KeyProviderPEM {
+	t.Helper()
+
+	provider := &KeyProviderPEM{}
+	err := provider.Init(KeyProviderOpts{
+		EnvConfig: &common.EnvConfigSchema{
+			KeysPemFile: path,
+		},
+	})
+	require.NoError(t, err)
+	return provider
+}
+
+func TestKeyProviderPEM_LoadKey(t *testing.T) {
+	t.Run("no existing file", func(t *testing.T) {
+		provider := newTestKeyProviderPEM(t, filepath.Join(t.TempDir(), "key.pem"))
+
+		key, err := provider.LoadKey()
+		require.NoError(t, err)
+		assert.Nil(t, key)
+	})
+
+	t.Run("PKCS#1 RSA key", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "key.pem")
+		data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		key, err := newTestKeyProviderPEM(t, path).LoadKey()
+		require.NoError(t, err)
+		require.NotNil(t, key)
+
+		alg, ok := key.Algorithm()
+		require.True(t, ok)
+		assert.Equal(t, jwa.RS256().String(), alg.String())
+
+		// The key ID must be stable across restarts
+		keyID, ok := key.KeyID()
+		require.True(t, ok)
+		key2, err := newTestKeyProviderPEM(t, path).LoadKey()
+		require.NoError(t, err)
+		keyID2, _ := key2.KeyID()
+		assert.Equal(t, keyID, keyID2)
+	})
+
+	t.Run("SEC 1 EC key", func(t *testing.T) {
+		ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.MarshalECPrivateKey(ecKey)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+
+		key, err := newTestKeyProviderPEM(t, path).LoadKey()
+		require.NoError(t, err)
+		require.NotNil(t, key)
+
+		alg, ok := key.Algorithm()
+		require.True(t, ok)
+		assert.Equal(t, jwa.ES384().String(), alg.String())
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, os.WriteFile(path, []byte("not a key"), 0600))
+
+		_, err := newTestKeyProviderPEM(t, path).LoadKey()
+		require.Error(t, err)
+	})
+}
+
+func TestKeyProviderPEM_SaveKey(t *testing.T) {
+	key, err := GenerateKey(jwa.ES256().String(), "")
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keys", "key.pem")
+	provider := newTestKeyProviderPEM(t, path)
+	require.NoError(t, provider.SaveKey(key))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	loaded, err := provider.LoadKey()
+	require.NoError(t, err)
+	require.NotNil(t, loaded)
+
+	expected, err := key.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	actual, err := loaded.Thumbprint(crypto.SHA256)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
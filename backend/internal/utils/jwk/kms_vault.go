@@ -0,0 +1,164 @@
+package jwk
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VaultTransitSigner signs data with a key of the HashiCorp Vault Transit secrets engine.
+// It implements crypto.Signer, but the private key never leaves Vault.
+type VaultTransitSigner struct {
+	httpClient *http.Client
+	baseURL    string
+	token      string
+	keyName    string
+	keyVersion int
+	publicKey  crypto.PublicKey
+}
+
+// NewVaultTransitSigner loads the public key of the latest version of the Transit key.
+// Later versions of the key are used only after a restart, so the key ID doesn't change while running.
+func NewVaultTransitSigner(ctx context.Context, httpClient *http.Client, addr, token, mount, keyName string) (*VaultTransitSigner, error) {
+	s := &VaultTransitSigner{
+		httpClient: httpClient,
+		baseURL:    strings.TrimSuffix(addr, "/") + "/v1/" + strings.Trim(mount, "/"),
+		token:      token,
+		keyName:    keyName,
+	}
+
+	var res struct {
+		Data struct {
+			LatestVersion int `json:"latest_version"`
+			Keys          map[string]struct {
+				PublicKey string `json:"public_key"`
+			} `json:"keys"`
+		} `json:"data"`
+	}
+	err := s.do(ctx, http.MethodGet, "/keys/"+keyName, nil, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Transit key '%s': %w", keyName, err)
+	}
+
+	latest, ok := res.Data.Keys[strconv.Itoa(res.Data.LatestVersion)]
+	if !ok || latest.PublicKey == "" {
+		return nil, fmt.Errorf("no public key for Transit key '%s'; only RSA and ECDSA keys are supported", keyName)
+	}
+
+	block, _ := pem.Decode([]byte(latest.PublicKey))
+	if block == nil {
+		return nil, errors.New("public key of the Transit key is not PEM-encoded")
+	}
+	s.publicKey, err = x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key of the Transit key: %w", err)
+	}
+
+	s.keyVersion = res.Data.LatestVersion
+
+	return s, nil
+}
+
+func (s *VaultTransitSigner) Public() crypto.PublicKey {
+	return s.publicKey
+}
+
+// Sign signs a digest; the signature is returned in the format of the standard library (ASN.1 for ECDSA)
+func (s *VaultTransitSigner) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var hashAlgorithm string
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		hashAlgorithm = "sha2-256"
+	case crypto.SHA384:
+		hashAlgorithm = "sha2-384"
+	case crypto.SHA512:
+		hashAlgorithm = "sha2-512"
+	default:
+		return nil, fmt.Errorf("unsupported hash function %v", opts.HashFunc())
+	}
+
+	body := map[string]any{
+		"input":                base64.StdEncoding.EncodeToString(digest),
+		"prehashed":            true,
+		"hash_algorithm":       hashAlgorithm,
+		"key_version":          s.keyVersion,
+		"marshaling_algorithm": "asn1",
+	}
+	if _, ok := s.publicKey.(*rsa.PublicKey); ok {
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			body["signature_algorithm"] = "pss"
+			body["salt_length"] = "hash"
+		} else {
+			body["signature_algorithm"] = "pkcs1v15"
+		}
+	}
+
+	// crypto.Signer has no context, so use a timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var res struct {
+		Data struct {
+			Signature string `json:"signature"`
+		} `json:"data"`
+	}
+	err := s.do(ctx, http.MethodPost, "/sign/"+s.keyName, body, &res)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with Transit key: %w", err)
+	}
+
+	// Signatures have the format "vault:v<version>:<base64>"
+	parts := strings.SplitN(res.Data.Signature, ":", 3)
+	if len(parts) != 3 || parts[0] != "vault" {
+		return nil, errors.New("invalid signature returned by Vault")
+	}
+	return base64.StdEncoding.DecodeString(parts[2])
+}
+
+func (s *VaultTransitSigner) do(ctx context.Context, method, path string, body any, result any) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", s.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := s.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("received HTTP %d from Vault", res.StatusCode)
+	}
+
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(result)
+	if err != nil {
+		return fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+	return nil
+}
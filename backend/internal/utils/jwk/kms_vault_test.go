@@ -0,0 +1,107 @@
+package jwk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestVaultTransitServer returns a server that mimics the Vault Transit API with a P-256 key
+func newTestVaultTransitServer(t *testing.T) (*httptest.Server, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	require.NoError(t, err)
+	publicKeyPEM := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/transit/keys/pocket-id":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{
+					"latest_version": 2,
+					"keys": map[string]any{
+						"1": map[string]any{"public_key": "old"},
+						"2": map[string]any{"public_key": publicKeyPEM},
+					},
+				},
+			})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/transit/sign/pocket-id":
+			var body struct {
+				Input      string `json:"input"`
+				Prehashed  bool   `json:"prehashed"`
+				KeyVersion int    `json:"key_version"`
+			}
+			if json.NewDecoder(r.Body).Decode(&body) != nil || !body.Prehashed || body.KeyVersion != 2 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			digest, _ := base64.StdEncoding.DecodeString(body.Input)
+			signature, err := ecdsa.SignASN1(rand.Reader, privateKey, digest)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]any{"signature": "vault:v2:" + base64.StdEncoding.EncodeToString(signature)},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, privateKey
+}
+
+func TestVaultTransitSigner(t *testing.T) {
+	server, privateKey := newTestVaultTransitServer(t)
+
+	t.Run("signs tokens that can be verified with the public key", func(t *testing.T) {
+		signer, err := NewVaultTransitSigner(t.Context(), server.Client(), server.URL, "token", "transit", "pocket-id")
+		require.NoError(t, err)
+		assert.True(t, privateKey.PublicKey.Equal(signer.Public()))
+
+		var _ crypto.Signer = signer
+
+		token, err := jwt.NewBuilder().Subject("user").Build()
+		require.NoError(t, err)
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.ES256(), signer))
+		require.NoError(t, err)
+
+		parsed, err := jwt.Parse(signed, jwt.WithKey(jwa.ES256(), &privateKey.PublicKey))
+		require.NoError(t, err)
+		sub, _ := parsed.Subject()
+		assert.Equal(t, "user", sub)
+	})
+
+	t.Run("fails with an invalid token", func(t *testing.T) {
+		_, err := NewVaultTransitSigner(t.Context(), server.Client(), server.URL, "wrong", "transit", "pocket-id")
+		require.Error(t, err)
+	})
+
+	t.Run("fails with an unknown key", func(t *testing.T) {
+		_, err := NewVaultTransitSigner(t.Context(), server.Client(), server.URL, "token", "transit", "missing")
+		require.Error(t, err)
+	})
+}
@@ -2,6 +2,7 @@ package jwk
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -178,3 +179,72 @@ func GenerateKey(alg string, crv string) (key jwk.Key, err error) {
 	// Import the raw key
 	return ImportRawKey(rawKey, alg, crv)
 }
+
+// DefaultKeyAlgorithm returns the default algorithm for a raw private or public key, which for ECDSA keys depends on the curve
+func DefaultKeyAlgorithm(rawKey any) string {
+	var curve elliptic.Curve
+	switch k := rawKey.(type) {
+	case *ecdsa.PrivateKey:
+		curve = k.Curve
+	case *ecdsa.PublicKey:
+		curve = k.Curve
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return jwa.EdDSA().String()
+	default:
+		return jwa.RS256().String()
+	}
+
+	switch curve {
+	case elliptic.P384():
+		return jwa.ES384().String()
+	case elliptic.P521():
+		return jwa.ES512().String()
+	default:
+		return jwa.ES256().String()
+	}
+}
+
+// ValidateKeyAlgorithm checks that the "alg" parameter of the key is set and can be used with the type of the key
+func ValidateKeyAlgorithm(key jwk.Key) error {
+	alg, ok := key.Algorithm()
+	if !ok || alg == nil {
+		return errors.New("key does not contain an algorithm")
+	}
+
+	var valid bool
+	switch key.KeyType() {
+	case jwa.RSA():
+		switch alg.String() {
+		case jwa.RS256().String(), jwa.RS384().String(), jwa.RS512().String(),
+			jwa.PS256().String(), jwa.PS384().String(), jwa.PS512().String():
+			valid = true
+		}
+	case jwa.EC():
+		var crv jwa.EllipticCurveAlgorithm
+		_ = key.Get(jwk.ECDSACrvKey, &crv)
+		switch alg.String() {
+		case jwa.ES256().String():
+			valid = crv == jwa.P256()
+		case jwa.ES384().String():
+			valid = crv == jwa.P384()
+		case jwa.ES512().String():
+			valid = crv == jwa.P521()
+		}
+	case jwa.OKP():
+		valid = alg.String() == jwa.EdDSA().String()
+	}
+
+	if !valid {
+		return fmt.Errorf("algorithm '%s' can't be used with a key of type '%s'", alg.String(), key.KeyType().String())
+	}
+	return nil
+}
+
+// SetThumbprintKeyID sets the key ID to the SHA-256 thumbprint of the key, so it's the same every time the key is imported
+func SetThumbprintKeyID(key jwk.Key) error {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return fmt.Errorf("failed to compute key thumbprint: %w", err)
+	}
+	return key.Set(jwk.KeyIDKey, base64.RawURLEncoding.EncodeToString(thumbprint))
+}
@@ -322,3 +322,45 @@ func TestEnsureAlgInKey(t *testing.T) {
 		assert.Nil(t, crv)
 	})
 }
+
+func TestValidateKeyAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	p256Key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		rawKey  any
+		alg     string
+		wantErr bool
+	}{
+		{name: "RSA key with RS256", rawKey: rsaKey, alg: jwa.RS256().String()},
+		{name: "RSA key with PS512", rawKey: rsaKey, alg: jwa.PS512().String()},
+		{name: "RSA key with ES256", rawKey: rsaKey, alg: jwa.ES256().String(), wantErr: true},
+		{name: "P-256 key with ES256", rawKey: p256Key, alg: jwa.ES256().String()},
+		{name: "P-256 key with ES384", rawKey: p256Key, alg: jwa.ES384().String(), wantErr: true},
+		{name: "Ed25519 key with EdDSA", rawKey: edKey, alg: jwa.EdDSA().String()},
+		{name: "Ed25519 key with RS256", rawKey: edKey, alg: jwa.RS256().String(), wantErr: true},
+		{name: "key without alg", rawKey: rsaKey, alg: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := jwk.Import(tt.rawKey)
+			require.NoError(t, err)
+			if tt.alg != "" {
+				require.NoError(t, key.Set(jwk.AlgorithmKey, tt.alg))
+			}
+
+			err = ValidateKeyAlgorithm(key)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
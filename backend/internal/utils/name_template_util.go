@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// NameTemplatePlaceholders are the placeholders that can be used in a name template, such as "{firstName} {lastName}"
+var NameTemplatePlaceholders = []string{"firstName", "lastName", "username", "email"}
+
+// ValidateNameTemplate checks that the braces of the template are balanced and that it only uses known placeholders
+func ValidateNameTemplate(template string) error {
+	_, err := renderNameTemplate(template, nil)
+	return err
+}
+
+// RenderNameTemplate replaces the placeholders of the template with their values.
+// Whitespace left by empty values is collapsed and trimmed.
+func RenderNameTemplate(template string, values map[string]string) (string, error) {
+	rendered, err := renderNameTemplate(template, values)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(strings.Fields(rendered), " "), nil
+}
+
+func renderNameTemplate(template string, values map[string]string) (string, error) {
+	var result strings.Builder
+	rest := template
+	for {
+		start := strings.IndexAny(rest, "{}")
+		if start == -1 {
+			result.WriteString(rest)
+			return result.String(), nil
+		}
+		if rest[start] == '}' {
+			return "", errors.New("unexpected '}'")
+		}
+
+		end := strings.IndexAny(rest[start+1:], "{}")
+		if end == -1 || rest[start+1+end] != '}' {
+			return "", errors.New("unclosed '{'")
+		}
+
+		placeholder := rest[start+1 : start+1+end]
+		if !slices.Contains(NameTemplatePlaceholders, placeholder) {
+			return "", fmt.Errorf("unknown placeholder '{%s}'", placeholder)
+		}
+
+		result.WriteString(rest[:start])
+		result.WriteString(values[placeholder])
+		rest = rest[start+end+2:]
+	}
+}
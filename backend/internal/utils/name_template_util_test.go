@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderNameTemplate(t *testing.T) {
+	values := map[string]string{"firstName": "Jane", "lastName": "", "username": "jane"}
+
+	name, err := RenderNameTemplate("{lastName}, {firstName} ({username})", values)
+	require.NoError(t, err)
+	assert.Equal(t, ", Jane (jane)", name)
+
+	name, err = RenderNameTemplate("  {firstName}   {lastName} ", values)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane", name)
+}
+
+func TestValidateNameTemplate(t *testing.T) {
+	require.NoError(t, ValidateNameTemplate(""))
+	require.NoError(t, ValidateNameTemplate("{firstName} {lastName} <{email}>"))
+	require.Error(t, ValidateNameTemplate("{firstName"))
+	require.Error(t, ValidateNameTemplate("firstName}"))
+	require.Error(t, ValidateNameTemplate("{first{Name}}"))
+	require.Error(t, ValidateNameTemplate("{nickname}"))
+}
@@ -6,6 +6,8 @@ import (
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
 )
 
 type PaginationResponse struct {
@@ -15,6 +17,11 @@ type PaginationResponse struct {
 	ItemsPerPage int   `json:"itemsPerPage"`
 }
 
+// DefaultSorter is implemented by the models that are sorted in a default order if the client doesn't request a valid one
+type DefaultSorter interface {
+	DefaultSort() (column string, desc bool)
+}
+
 type SortedPaginationRequest struct {
 	Pagination struct {
 		Page  int `form:"pagination[page]"`
@@ -32,7 +39,8 @@ func PaginateAndSort(sortedPaginationRequest SortedPaginationRequest, query *gor
 
 	capitalizedSortColumn := CapitalizeFirstLetter(sort.Column)
 
-	sortField, sortFieldFound := reflect.TypeOf(result).Elem().Elem().FieldByName(capitalizedSortColumn)
+	modelType := reflect.TypeOf(result).Elem().Elem()
+	sortField, sortFieldFound := modelType.FieldByName(capitalizedSortColumn)
 	isSortable, _ := strconv.ParseBool(sortField.Tag.Get("sortable"))
 
 	if sort.Direction == "" || (sort.Direction != "asc" && sort.Direction != "desc") {
@@ -46,20 +54,29 @@ func PaginateAndSort(sortedPaginationRequest SortedPaginationRequest, query *gor
 				{Column: clause.Column{Name: columnName}, Desc: sort.Direction == "desc"},
 			},
 		})
+	} else if sorter, ok := reflect.New(modelType).Interface().(DefaultSorter); ok {
+		column, desc := sorter.DefaultSort()
+		query = query.Clauses(clause.OrderBy{
+			Columns: []clause.OrderByColumn{
+				{Column: clause.Column{Table: clause.CurrentTable, Name: column}, Desc: desc},
+			},
+		})
 	}
 
 	return Paginate(pagination.Page, pagination.Limit, query, result)
 }
 
+// Paginate loads the requested page of the query into result.
+// The page size is capped, so that clients can't load an entire table at once; the returned ItemsPerPage is the page size that was used.
 func Paginate(page int, pageSize int, query *gorm.DB, result interface{}) (PaginationResponse, error) {
 	if page < 1 {
 		page = 1
 	}
 
 	if pageSize < 1 {
-		pageSize = 20
-	} else if pageSize > 100 {
-		pageSize = 100
+		pageSize = common.EnvConfig.PaginationDefaultLimit
+	} else if pageSize > common.EnvConfig.PaginationMaxLimit {
+		pageSize = common.EnvConfig.PaginationMaxLimit
 	}
 
 	offset := (page - 1) * pageSize
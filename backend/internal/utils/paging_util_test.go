@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+type pagingTestItem struct {
+	ID   int
+	Name string `sortable:"true"`
+}
+
+func (pagingTestItem) DefaultSort() (string, bool) { return "id", true }
+
+func newPagingTestDatabase(t *testing.T, items int) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+filepath.Join(t.TempDir(), "test.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&pagingTestItem{}))
+	for i := 1; i <= items; i++ {
+		require.NoError(t, db.Create(&pagingTestItem{ID: i, Name: string(rune('a' + i - 1))}).Error)
+	}
+
+	return db
+}
+
+func TestPaginateAndSort(t *testing.T) {
+	originalConfig := common.EnvConfig
+	t.Cleanup(func() {
+		common.EnvConfig = originalConfig
+	})
+	common.EnvConfig.PaginationDefaultLimit = 2
+	common.EnvConfig.PaginationMaxLimit = 3
+
+	db := newPagingTestDatabase(t, 5)
+
+	list := func(t *testing.T, req SortedPaginationRequest) ([]int, PaginationResponse) {
+		t.Helper()
+		var items []pagingTestItem
+		pagination, err := PaginateAndSort(req, db.Model(&pagingTestItem{}), &items)
+		require.NoError(t, err)
+
+		ids := make([]int, len(items))
+		for i, item := range items {
+			ids[i] = item.ID
+		}
+		return ids, pagination
+	}
+
+	t.Run("uses the default page size and sort", func(t *testing.T) {
+		ids, pagination := list(t, SortedPaginationRequest{})
+		assert.Equal(t, []int{5, 4}, ids)
+		assert.Equal(t, 2, pagination.ItemsPerPage)
+		assert.EqualValues(t, 3, pagination.TotalPages)
+	})
+
+	t.Run("caps the page size", func(t *testing.T) {
+		var req SortedPaginationRequest
+		req.Pagination.Limit = 1000
+		ids, pagination := list(t, req)
+		assert.Len(t, ids, 3)
+		assert.Equal(t, 3, pagination.ItemsPerPage)
+	})
+
+	t.Run("sorts by the requested column", func(t *testing.T) {
+		var req SortedPaginationRequest
+		req.Sort.Column = "name"
+		req.Sort.Direction = "asc"
+		ids, _ := list(t, req)
+		assert.Equal(t, []int{1, 2}, ids)
+	})
+
+	t.Run("ignores columns that aren't sortable", func(t *testing.T) {
+		var req SortedPaginationRequest
+		req.Sort.Column = "iD"
+		ids, _ := list(t, req)
+		assert.Equal(t, []int{5, 4}, ids)
+	})
+}
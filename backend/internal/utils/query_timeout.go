@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+const queryTimeoutCancelKey = "pocket-id:query_timeout_cancel"
+
+type queryTimeoutContextKey struct{}
+
+// WithQueryTimeout overrides the maximum duration of the database queries made with the context, e.g. for known-heavy jobs.
+// If timeout is 0, the queries don't time out.
+func WithQueryTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutContextKey{}, timeout)
+}
+
+// QueryTimeoutFromContext returns the maximum duration of the queries made with the context, or the default one if it isn't overridden
+func QueryTimeoutFromContext(ctx context.Context, defaultTimeout time.Duration) time.Duration {
+	if timeout, ok := ctx.Value(queryTimeoutContextKey{}).(time.Duration); ok {
+		return timeout
+	}
+	return defaultTimeout
+}
+
+// QueryTimeoutPlugin is a GORM plugin that cancels queries that take longer than the timeout.
+// Queries that time out fail with a common.QueryTimeoutError, so they can be told apart from other errors.
+// Postgres stops a query as soon as it's canceled, whereas SQLite only checks the context between the rows it returns.
+type QueryTimeoutPlugin struct {
+	Timeout time.Duration
+}
+
+func (p QueryTimeoutPlugin) Name() string {
+	return "pocket-id:query_timeout"
+}
+
+func (p QueryTimeoutPlugin) Initialize(db *gorm.DB) error {
+	// Queries that return rows to iterate over aren't limited, as the rows would be closed once the callbacks are done
+	// The timeout starts before the transactions GORM opens for writes, and ends once they're committed, so it includes the associations
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("gorm:begin_transaction").Register("query_timeout:before", p.before),
+		cb.Create().After("gorm:commit_or_rollback_transaction").Register("query_timeout:after", p.after),
+		cb.Query().Before("gorm:query").Register("query_timeout:before", p.before),
+		cb.Query().After("gorm:after_query").Register("query_timeout:after", p.after),
+		cb.Update().Before("gorm:begin_transaction").Register("query_timeout:before", p.before),
+		cb.Update().After("gorm:commit_or_rollback_transaction").Register("query_timeout:after", p.after),
+		cb.Delete().Before("gorm:begin_transaction").Register("query_timeout:before", p.before),
+		cb.Delete().After("gorm:commit_or_rollback_transaction").Register("query_timeout:after", p.after),
+		cb.Raw().Before("gorm:raw").Register("query_timeout:before", p.before),
+		cb.Raw().After("gorm:raw").Register("query_timeout:after", p.after),
+	)
+}
+
+func (p QueryTimeoutPlugin) before(db *gorm.DB) {
+	if db.Statement.Context == nil {
+		return
+	}
+
+	timeout := QueryTimeoutFromContext(db.Statement.Context, p.Timeout)
+	if timeout <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(db.Statement.Context, timeout)
+	db.Statement.Context = ctx
+	db.Statement.Settings.Store(queryTimeoutCancelKey, cancel)
+}
+
+func (p QueryTimeoutPlugin) after(db *gorm.DB) {
+	v, ok := db.Statement.Settings.LoadAndDelete(queryTimeoutCancelKey)
+	if !ok {
+		return
+	}
+
+	if db.Error != nil && (errors.Is(db.Statement.Context.Err(), context.DeadlineExceeded) || IsQueryCanceledError(db.Error)) {
+		db.Error = &common.QueryTimeoutError{}
+	}
+
+	v.(context.CancelFunc)()
+}
+
+// IsQueryCanceledError returns true if the database canceled the query because it exceeded the statement timeout of Postgres
+func IsQueryCanceledError(err error) bool {
+	var pgErr interface{ SQLState() string }
+	return errors.As(err, &pgErr) && pgErr.SQLState() == "57014" // query_canceled
+}
+
+// SetLocalStatementTimeout overrides the statement_timeout of Postgres for the rest of the transaction, to match a timeout set with WithQueryTimeout.
+// On SQLite, queries are only limited by the context, so it does nothing.
+func SetLocalStatementTimeout(ctx context.Context, tx *gorm.DB, timeout time.Duration) error {
+	if common.EnvConfig.DbProvider != common.DbProviderPostgres {
+		return nil
+	}
+
+	// SET doesn't support parameters, but the value is a number
+	return tx.
+		WithContext(ctx).
+		Exec("SET LOCAL statement_timeout = " + strconv.FormatInt(timeout.Milliseconds(), 10)).
+		Error
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// slowQuery returns enough rows to take a few hundred milliseconds on SQLite, which checks the context between rows
+const slowQuery = "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 500000) SELECT i FROM n"
+
+func TestQueryTimeoutPlugin(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Use(QueryTimeoutPlugin{Timeout: 10 * time.Millisecond}))
+
+	t.Run("fails queries that take too long", func(t *testing.T) {
+		var numbers []int64
+		err := db.WithContext(t.Context()).Raw(slowQuery).Find(&numbers).Error
+		require.ErrorIs(t, err, &common.QueryTimeoutError{})
+	})
+
+	t.Run("doesn't affect fast queries", func(t *testing.T) {
+		var one int
+		err := db.WithContext(t.Context()).Raw("SELECT 1").Find(&one).Error
+		require.NoError(t, err)
+		assert.Equal(t, 1, one)
+	})
+
+	t.Run("uses the timeout of the context", func(t *testing.T) {
+		ctx := WithQueryTimeout(t.Context(), 10*time.Second)
+		assert.Equal(t, 10*time.Second, QueryTimeoutFromContext(ctx, time.Second))
+		assert.Equal(t, time.Second, QueryTimeoutFromContext(t.Context(), time.Second))
+
+		var one int
+		err := db.WithContext(WithQueryTimeout(t.Context(), 0)).Raw("SELECT 1").Find(&one).Error
+		require.NoError(t, err)
+	})
+}
+
+func TestIsQueryCanceledError(t *testing.T) {
+	assert.True(t, IsQueryCanceledError(&testPgError{code: "57014"}))
+	assert.False(t, IsQueryCanceledError(&testPgError{code: "40001"}))
+}
@@ -0,0 +1,219 @@
+package utils
+
+import (
+	"context"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// RedactedValue replaces sensitive values in logs and other output
+const RedactedValue = "[redacted]"
+
+// urlRegex matches the URLs in free text, like error messages
+var urlRegex = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+
+// IsSensitiveKey returns true if values with the given key, like a log attribute or a query parameter, must not be revealed
+func IsSensitiveKey(key string) bool {
+	normalized := strings.NewReplacer("_", "", "-", "").Replace(strings.ToLower(key))
+	if normalized == "code" {
+		return true
+	}
+	for _, s := range []string{"password", "secret", "token", "licensekey", "apikey", "signature"} {
+		if strings.Contains(normalized, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redact returns RedactedValue, or an empty string if the value is empty, so it's still visible whether a value is set
+func Redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return RedactedValue
+}
+
+// RedactSecrets replaces all occurrences of the secrets in s
+func RedactSecrets(s string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, RedactedValue)
+		}
+	}
+	return s
+}
+
+// RedactQuery redacts the values of the sensitive parameters in a raw query string
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	parts := strings.Split(rawQuery, "&")
+	for i, part := range parts {
+		key, _, hasValue := strings.Cut(part, "=")
+		unescapedKey, err := url.QueryUnescape(key)
+		if err != nil {
+			unescapedKey = key
+		}
+		if hasValue && IsSensitiveKey(unescapedKey) {
+			parts[i] = key + "=" + RedactedValue
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// RedactURL redacts the password and the values of the sensitive query parameters of a URL
+func RedactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+
+	changed := false
+	if _, hasPassword := u.User.Password(); hasPassword {
+		u.User = url.UserPassword(u.User.Username(), RedactedValue)
+		changed = true
+	}
+	if u.RawQuery != "" {
+		redacted := RedactQuery(u.RawQuery)
+		changed = changed || redacted != u.RawQuery
+		u.RawQuery = redacted
+	}
+
+	if !changed {
+		return rawURL
+	}
+	// The placeholder is kept readable instead of being escaped
+	return strings.ReplaceAll(u.String(), url.QueryEscape(RedactedValue), RedactedValue)
+}
+
+// RedactURLs redacts all URLs in free text, like error messages
+func RedactURLs(s string) string {
+	return urlRegex.ReplaceAllStringFunc(s, RedactURL)
+}
+
+// RedactError returns an error whose message has the URLs and the given secrets redacted.
+// The returned error still unwraps to the original one.
+func RedactError(err error, secrets ...string) error {
+	if err == nil {
+		return nil
+	}
+	return &redactedError{err: err, secrets: secrets}
+}
+
+type redactedError struct {
+	err     error
+	secrets []string
+}
+
+func (e *redactedError) Error() string {
+	return RedactURLs(RedactSecrets(e.err.Error(), e.secrets...))
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// Secret is a string that is redacted when it's logged with slog or formatted with fmt
+type Secret string
+
+// Implements slog.LogValuer
+func (s Secret) LogValue() slog.Value {
+	return slog.StringValue(Redact(string(s)))
+}
+
+// Implements fmt.Stringer
+func (s Secret) String() string {
+	return Redact(string(s))
+}
+
+// RedactedURL is a URL whose password and sensitive query parameters are redacted when it's logged with slog
+type RedactedURL string
+
+// Implements slog.LogValuer
+func (u RedactedURL) LogValue() slog.Value {
+	return slog.StringValue(RedactURL(string(u)))
+}
+
+// LogRedactionHandler is a slog.Handler that redacts sensitive values from each record.
+// Values of attributes with a sensitive key, like "password" or "token", are redacted, and so are their occurrences in the other attributes of the same group, e.g. a token that is part of a request path.
+// Passwords and sensitive query parameters of the URLs in the message and in string or error attributes are redacted too.
+type LogRedactionHandler struct {
+	slog.Handler
+}
+
+// Implements slog.Handler
+func (h LogRedactionHandler) Handle(ctx context.Context, r slog.Record) error {
+	attrs := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+
+	redacted := slog.NewRecord(r.Time, r.Level, RedactURLs(r.Message), r.PC)
+	redacted.AddAttrs(redactLogAttrs(attrs)...)
+
+	return h.Handler.Handle(ctx, redacted)
+}
+
+// Implements slog.Handler
+func (h LogRedactionHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return LogRedactionHandler{Handler: h.Handler.WithAttrs(redactLogAttrs(attrs))}
+}
+
+// Implements slog.Handler
+func (h LogRedactionHandler) WithGroup(name string) slog.Handler {
+	return LogRedactionHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// redactLogAttrs redacts the attributes of a group
+func redactLogAttrs(attrs []slog.Attr) []slog.Attr {
+	res := make([]slog.Attr, len(attrs))
+	var secrets []string
+	for i, a := range attrs {
+		a.Value = a.Value.Resolve()
+
+		switch {
+		case a.Value.Kind() == slog.KindGroup:
+			a.Value = slog.GroupValue(redactLogAttrs(a.Value.Group())...)
+		case a.Value.Kind() == slog.KindString && IsSensitiveKey(a.Key):
+			secrets = append(secrets, a.Value.String())
+			a.Value = slog.StringValue(Redact(a.Value.String()))
+		case a.Value.Kind() == slog.KindAny:
+			// Request parameters are logged as map, e.g. by the request logger
+			if params, ok := a.Value.Any().(map[string]string); ok {
+				redactedParams := make(map[string]string, len(params))
+				for key, value := range params {
+					if IsSensitiveKey(key) {
+						secrets = append(secrets, value)
+						value = Redact(value)
+					}
+					redactedParams[key] = value
+				}
+				a.Value = slog.AnyValue(redactedParams)
+			}
+		}
+
+		res[i] = a
+	}
+
+	for i, a := range res {
+		switch {
+		case a.Value.Kind() == slog.KindString && a.Key == "query":
+			res[i].Value = slog.StringValue(RedactSecrets(RedactQuery(a.Value.String()), secrets...))
+		case a.Value.Kind() == slog.KindString:
+			res[i].Value = slog.StringValue(RedactURLs(RedactSecrets(a.Value.String(), secrets...)))
+		case a.Value.Kind() == slog.KindAny:
+			// Errors stay errors, so handlers can still format them as such
+			if err, ok := a.Value.Any().(error); ok {
+				res[i].Value = slog.AnyValue(RedactError(err, secrets...))
+			}
+		}
+	}
+
+	return res
+}
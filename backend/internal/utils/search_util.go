@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// CaseInsensitiveSearch returns a condition that matches the rows where any of the columns contains the search term, regardless of the case and of accents.
+// Postgres uses ILIKE, while SQLite's LIKE operator only ignores the case of ASCII characters, so on SQLite both sides are lowercased with a Unicode-aware function.
+// Accents are removed with the "unaccent" function, which is registered on SQLite and requires the extension with the same name on Postgres.
+// The column names must be trusted, as they are included in the query as-is.
+func CaseInsensitiveSearch(searchTerm string, columns ...string) (query string, args []any) {
+	conditions := make([]string, len(columns))
+	args = make([]any, len(columns))
+
+	for i, column := range columns {
+		switch {
+		case common.EnvConfig.DbProvider == common.DbProviderPostgres && common.EnvConfig.DbPostgresUnaccent:
+			conditions[i] = "unaccent(" + column + ") ILIKE unaccent(?)"
+			args[i] = "%" + searchTerm + "%"
+		case common.EnvConfig.DbProvider == common.DbProviderPostgres:
+			conditions[i] = column + " ILIKE ?"
+			args[i] = "%" + searchTerm + "%"
+		default:
+			conditions[i] = "unaccent(unicode_lower(" + column + ")) LIKE ?"
+			args[i] = "%" + RemoveAccents(strings.ToLower(searchTerm)) + "%"
+		}
+	}
+
+	return strings.Join(conditions, " OR "), args
+}
@@ -0,0 +1,41 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+func TestCaseInsensitiveSearch(t *testing.T) {
+	originalConfig := common.EnvConfig
+	t.Cleanup(func() {
+		common.EnvConfig = originalConfig
+	})
+
+	t.Run("SQLite", func(t *testing.T) {
+		common.EnvConfig.DbProvider = common.DbProviderSqlite
+
+		query, args := CaseInsensitiveSearch("ÉLodie", "first_name", "email")
+		assert.Equal(t, "unaccent(unicode_lower(first_name)) LIKE ? OR unaccent(unicode_lower(email)) LIKE ?", query)
+		assert.Equal(t, []any{"%elodie%", "%elodie%"}, args)
+	})
+
+	t.Run("Postgres", func(t *testing.T) {
+		common.EnvConfig.DbProvider = common.DbProviderPostgres
+
+		query, args := CaseInsensitiveSearch("ÉLodie", "first_name", "email")
+		assert.Equal(t, "first_name ILIKE ? OR email ILIKE ?", query)
+		assert.Equal(t, []any{"%ÉLodie%", "%ÉLodie%"}, args)
+	})
+
+	t.Run("Postgres with unaccent", func(t *testing.T) {
+		common.EnvConfig.DbProvider = common.DbProviderPostgres
+		common.EnvConfig.DbPostgresUnaccent = true
+
+		query, args := CaseInsensitiveSearch("ÉLodie", "first_name")
+		assert.Equal(t, "unaccent(first_name) ILIKE unaccent(?)", query)
+		assert.Equal(t, []any{"%ÉLodie%"}, args)
+	})
+}
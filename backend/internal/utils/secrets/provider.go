@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// Names of the secrets that can be resolved from a provider
+const (
+	SmtpPassword     = "smtp_password"
+	LdapBindPassword = "ldap_bind_password"
+	// JwtPrivateKey is the private key used to sign tokens, encoded as JWK
+	JwtPrivateKey = "jwt_private_key"
+)
+
+// ErrNotFound is returned by providers that don't have a value for a secret
+var ErrNotFound = errors.New("secret not found")
+
+// Provider resolves sensitive configuration values from an external source
+type Provider interface {
+	// Get returns the value of the secret with the given name, or ErrNotFound
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// NewProvider returns the provider selected by SECRETS_PROVIDER.
+// It returns nil if no provider is configured, in which case the values stored in the database are used.
+func NewProvider(envConfig *common.EnvConfigSchema, httpClient *http.Client) (Provider, error) {
+	var provider Provider
+	switch envConfig.SecretsProvider {
+	case "":
+		return nil, nil
+	case "env":
+		provider = &EnvProvider{}
+	case "file":
+		provider = &FileProvider{Dir: envConfig.SecretsFilePath}
+	case "vault":
+		provider = &VaultProvider{
+			httpClient: httpClient,
+			addr:       envConfig.SecretsVaultAddr,
+			path:       envConfig.SecretsVaultPath,
+			token:      envConfig.SecretsVaultToken,
+		}
+	default:
+		return nil, fmt.Errorf("invalid secrets provider '%s'", envConfig.SecretsProvider)
+	}
+
+	if envConfig.SecretsCacheTTL > 0 {
+		provider = NewCachedProvider(provider, envConfig.SecretsCacheTTL)
+	}
+
+	return provider, nil
+}
+
+// Resolve returns the value of the secret from the provider.
+// If the provider is nil or doesn't have the secret, the fallback value is returned.
+func Resolve(ctx context.Context, provider Provider, name string, fallback string) (string, error) {
+	if provider == nil {
+		return fallback, nil
+	}
+
+	value, err := provider.Get(ctx, name)
+	if errors.Is(err, ErrNotFound) {
+		return fallback, nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to resolve secret '%s': %w", name, err)
+	}
+
+	return value, nil
+}
+
+// CachedProvider caches the values of another provider.
+// Values are re-read once they expire, so rotated secrets are picked up without a restart.
+type CachedProvider struct {
+	provider Provider
+	ttl      time.Duration
+
+	lock    sync.Mutex
+	entries map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value     string
+	found     bool
+	expiresAt time.Time
+}
+
+func NewCachedProvider(provider Provider, ttl time.Duration) *CachedProvider {
+	return &CachedProvider{
+		provider: provider,
+		ttl:      ttl,
+		entries:  make(map[string]cachedSecret),
+	}
+}
+
+func (p *CachedProvider) Get(ctx context.Context, name string) (string, error) {
+	p.lock.Lock()
+	entry, ok := p.entries[name]
+	p.lock.Unlock()
+
+	if !ok || time.Now().After(entry.expiresAt) {
+		value, err := p.provider.Get(ctx, name)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			// Errors are not cached, so the next call tries again
+			return "", err
+		}
+
+		entry = cachedSecret{
+			value:     value,
+			found:     err == nil,
+			expiresAt: time.Now().Add(p.ttl),
+		}
+		p.lock.Lock()
+		p.entries[name] = entry
+		p.lock.Unlock()
+	}
+
+	if !entry.found {
+		return "", ErrNotFound
+	}
+	return entry.value, nil
+}
@@ -0,0 +1,18 @@
+package secrets
+
+import (
+	"context"
+	"os"
+	"strings"
+)
+
+// EnvProvider reads secrets from environment variables named after the secret in upper case, e.g. SMTP_PASSWORD
+type EnvProvider struct{}
+
+func (p *EnvProvider) Get(_ context.Context, name string) (string, error) {
+	value, ok := os.LookupEnv(strings.ToUpper(name))
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
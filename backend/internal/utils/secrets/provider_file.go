@@ -0,0 +1,32 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileProvider reads secrets from files named after the secret in a directory, like the secrets mounted by Docker or Kubernetes
+type FileProvider struct {
+	Dir string
+}
+
+func (p *FileProvider) Get(_ context.Context, name string) (string, error) {
+	// Names are constants, but make sure they can't escape the directory
+	if name != filepath.Base(name) {
+		return "", fmt.Errorf("invalid secret name '%s'", name)
+	}
+
+	data, err := os.ReadFile(filepath.Join(p.Dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrNotFound
+	} else if err != nil {
+		return "", fmt.Errorf("failed to read secret file: %w", err)
+	}
+
+	// Files often end with a newline that isn't part of the secret
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
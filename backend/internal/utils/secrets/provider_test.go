@@ -0,0 +1,132 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	t.Run("uses the fallback without a provider", func(t *testing.T) {
+		value, err := Resolve(t.Context(), nil, SmtpPassword, "from-db")
+		require.NoError(t, err)
+		assert.Equal(t, "from-db", value)
+	})
+
+	t.Run("uses the fallback if the provider doesn't have the secret", func(t *testing.T) {
+		value, err := Resolve(t.Context(), &EnvProvider{}, "pocket_id_test_missing_secret", "from-db")
+		require.NoError(t, err)
+		assert.Equal(t, "from-db", value)
+	})
+
+	t.Run("prefers the value of the provider", func(t *testing.T) {
+		t.Setenv("SMTP_PASSWORD", "from-env")
+		value, err := Resolve(t.Context(), &EnvProvider{}, SmtpPassword, "from-db")
+		require.NoError(t, err)
+		assert.Equal(t, "from-env", value)
+	})
+}
+
+func TestFileProvider(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, LdapBindPassword), []byte("secret\n"), 0o600))
+	provider := &FileProvider{Dir: dir}
+
+	value, err := provider.Get(t.Context(), LdapBindPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "secret", value)
+
+	_, err = provider.Get(t.Context(), SmtpPassword)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = provider.Get(t.Context(), "../"+LdapBindPassword)
+	require.Error(t, err)
+}
+
+func TestVaultProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/pocket-id":
+			_, _ = w.Write([]byte(`{"data":{"data":{"smtp_password":"kv2"},"metadata":{"version":1}}}`))
+		case "/v1/kv/pocket-id":
+			_, _ = w.Write([]byte(`{"data":{"smtp_password":"kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	newProvider := func(path, token string) *VaultProvider {
+		return &VaultProvider{httpClient: server.Client(), addr: server.URL, path: path, token: token}
+	}
+
+	value, err := newProvider("secret/data/pocket-id", "token").Get(t.Context(), SmtpPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "kv2", value)
+
+	value, err = newProvider("kv/pocket-id", "token").Get(t.Context(), SmtpPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "kv1", value)
+
+	_, err = newProvider("secret/data/pocket-id", "token").Get(t.Context(), LdapBindPassword)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = newProvider("secret/data/missing", "token").Get(t.Context(), SmtpPassword)
+	require.ErrorIs(t, err, ErrNotFound)
+
+	_, err = newProvider("secret/data/pocket-id", "wrong").Get(t.Context(), SmtpPassword)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrNotFound)
+}
+
+type countingProvider struct {
+	value string
+	calls int
+}
+
+func (p *countingProvider) Get(_ context.Context, _ string) (string, error) {
+	p.calls++
+	if p.value == "" {
+		return "", ErrNotFound
+	}
+	return p.value, nil
+}
+
+func TestCachedProvider(t *testing.T) {
+	inner := &countingProvider{value: "first"}
+	provider := NewCachedProvider(inner, time.Hour)
+
+	for range 3 {
+		value, err := provider.Get(t.Context(), SmtpPassword)
+		require.NoError(t, err)
+		assert.Equal(t, "first", value)
+	}
+	assert.Equal(t, 1, inner.calls)
+
+	// Rotated values are picked up once the cached value expires
+	inner.value = "rotated"
+	provider.entries[SmtpPassword] = cachedSecret{value: "first", found: true, expiresAt: time.Now().Add(-time.Second)}
+	value, err := provider.Get(t.Context(), SmtpPassword)
+	require.NoError(t, err)
+	assert.Equal(t, "rotated", value)
+	assert.Equal(t, 2, inner.calls)
+
+	// Missing secrets are cached too
+	inner.value = ""
+	_, err = provider.Get(t.Context(), LdapBindPassword)
+	require.ErrorIs(t, err, ErrNotFound)
+	_, err = provider.Get(t.Context(), LdapBindPassword)
+	require.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 3, inner.calls)
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// VaultProvider reads secrets from a HashiCorp Vault KV secret, where each secret is a key of the KV secret.
+// Both version 1 and 2 of the KV secrets engine are supported; for version 2, the path must include "/data/".
+type VaultProvider struct {
+	httpClient *http.Client
+	addr       string
+	path       string
+	token      string
+}
+
+type vaultSecretResponse struct {
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+func (p *VaultProvider) Get(parentCtx context.Context, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, 10*time.Second)
+	defer cancel()
+
+	url := strings.TrimSuffix(p.addr, "/") + "/v1/" + strings.TrimPrefix(p.path, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+
+	res, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret from Vault: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", ErrNotFound
+	} else if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to read secret from Vault, received HTTP %d", res.StatusCode)
+	}
+
+	var body vaultSecretResponse
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode Vault response: %w", err)
+	}
+
+	data := body.Data
+	// KV version 2 nests the data and adds metadata
+	if nested, ok := data["data"]; ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nil
+			err = json.Unmarshal(nested, &data)
+			if err != nil {
+				return "", fmt.Errorf("failed to decode Vault secret data: %w", err)
+			}
+		}
+	}
+
+	raw, ok := data[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	var value string
+	err = json.Unmarshal(raw, &value)
+	if err != nil {
+		return "", fmt.Errorf("value of secret '%s' is not a string", name)
+	}
+
+	return value, nil
+}
@@ -8,6 +8,8 @@ import (
 
 	sqlitelib "github.com/glebarez/go-sqlite"
 	"golang.org/x/text/unicode/norm"
+
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
 )
 
 func RegisterSqliteFunctions() {
@@ -48,4 +50,34 @@ func RegisterSqliteFunctions() {
 
 		return form.String(arg0), nil
 	})
+	// Register the `unicode_lower(text)` function, which converts the text to lower case
+	// The built-in `lower` function and the LIKE operator only handle ASCII characters, so this is used for case-insensitive searches
+	sqlitelib.MustRegisterDeterministicScalarFunction("unicode_lower", 1, func(ctx *sqlitelib.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("unicode_lower requires 1 argument")
+		}
+
+		// NULL and non-text values are returned as-is
+		arg0, ok := args[0].(string)
+		if !ok {
+			return args[0], nil
+		}
+
+		return strings.ToLower(arg0), nil
+	})
+	// Register the `unaccent(text)` function, which removes the diacritics from the text like the Postgres extension with the same name
+	// This is used for accent-insensitive searches
+	sqlitelib.MustRegisterDeterministicScalarFunction("unaccent", 1, func(ctx *sqlitelib.FunctionContext, args []driver.Value) (driver.Value, error) {
+		if len(args) != 1 {
+			return nil, errors.New("unaccent requires 1 argument")
+		}
+
+		// NULL and non-text values are returned as-is
+		arg0, ok := args[0].(string)
+		if !ok {
+			return args[0], nil
+		}
+
+		return utils.RemoveAccents(arg0), nil
+	})
 }
@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// ErrNotFound is returned when no object exists with the requested key
+var ErrNotFound = errors.New("object not found")
+
+// Storage stores objects in the local filesystem or in an external object storage
+type Storage interface {
+	// Put stores the data under the given key, replacing the object with the same key if it exists
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	// Get returns the data of the object with the given key, or ErrNotFound if it doesn't exist
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Delete removes the object with the given key; it isn't an error if the object doesn't exist
+	Delete(ctx context.Context, key string) error
+}
+
+// Config contains the settings of a storage backend
+type Config struct {
+	// Type of the backend: "file" or "s3"
+	Type string
+	// Directory where the objects are stored when Type is "file"
+	Path string
+
+	// Endpoint of the S3-compatible service; if empty, the AWS endpoint of the region is used
+	S3Endpoint        string
+	S3Region          string
+	S3Bucket          string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	// Prefix added to the keys of all objects, e.g. "pocket-id/"
+	S3Prefix string
+	// Whether the bucket is part of the path instead of the host name, which is needed by most self-hosted services
+	S3PathStyle bool
+}
+
+// New returns the storage backend described by the config
+func New(cfg Config, httpClient *http.Client) (Storage, error) {
+	switch cfg.Type {
+	case "file":
+		if cfg.Path == "" {
+			return nil, errors.New("path is required for file storage")
+		}
+		return &FileStorage{Dir: cfg.Path}, nil
+	case "s3":
+		return NewS3Storage(cfg, httpClient)
+	default:
+		return nil, fmt.Errorf("invalid storage type '%s'", cfg.Type)
+	}
+}
+
+// validateKey checks that the key is a relative, slash-separated path that doesn't escape the root of the storage
+func validateKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || path.Clean(key) != key || strings.HasPrefix(key, "../") || key == ".." {
+		return fmt.Errorf("invalid object key '%s'", key)
+	}
+	return nil
+}
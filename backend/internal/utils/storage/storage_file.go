@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/pocket-id/pocket-id/backend/internal/utils"
+)
+
+// FileStorage stores objects as files in a directory, using the keys as relative paths
+type FileStorage struct {
+	Dir string
+}
+
+func (s *FileStorage) Put(_ context.Context, key string, data []byte, _ string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	dst := s.path(key)
+	err = os.MkdirAll(filepath.Dir(dst), 0o700)
+	if err != nil {
+		return fmt.Errorf("failed to create directory for '%s': %w", dst, err)
+	}
+
+	// The file is written to a temporary file first, so readers never see a partial object
+	return utils.SaveFileStream(bytes.NewReader(data), dst)
+}
+
+func (s *FileStorage) Get(_ context.Context, key string) ([]byte, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read object '%s': %w", key, err)
+	}
+	return data, nil
+}
+
+func (s *FileStorage) Delete(_ context.Context, key string) error {
+	err := validateKey(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(s.path(key))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete object '%s': %w", key, err)
+	}
+	return nil
+}
+
+func (s *FileStorage) path(key string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(key))
+}
@@ -0,0 +1,250 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage stores objects in a bucket of an S3-compatible object storage.
+// Requests are signed with AWS Signature Version 4, which is supported by AWS and by self-hosted services like MinIO or Garage.
+type S3Storage struct {
+	httpClient      *http.Client
+	endpoint        *url.URL
+	region          string
+	bucket          string
+	accessKeyID     string
+	secretAccessKey string
+	prefix          string
+	pathStyle       bool
+}
+
+func NewS3Storage(cfg Config, httpClient *http.Client) (*S3Storage, error) {
+	if cfg.S3Bucket == "" || cfg.S3Region == "" || cfg.S3AccessKeyID == "" || cfg.S3SecretAccessKey == "" {
+		return nil, errors.New("bucket, region, access key ID and secret access key are required for S3 storage")
+	}
+
+	endpoint := cfg.S3Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.S3Region + ".amazonaws.com"
+	}
+	endpointURL, err := url.Parse(endpoint)
+	if err != nil || (endpointURL.Scheme != "http" && endpointURL.Scheme != "https") || endpointURL.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint '%s'", endpoint)
+	}
+
+	return &S3Storage{
+		httpClient:      httpClient,
+		endpoint:        endpointURL,
+		region:          cfg.S3Region,
+		bucket:          cfg.S3Bucket,
+		accessKeyID:     cfg.S3AccessKeyID,
+		secretAccessKey: cfg.S3SecretAccessKey,
+		prefix:          cfg.S3Prefix,
+		pathStyle:       cfg.S3PathStyle,
+	}, nil
+}
+
+func (s *S3Storage) Put(parentCtx context.Context, key string, data []byte, contentType string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, 5*time.Minute)
+	defer cancel()
+
+	res, err := s.do(ctx, http.MethodPut, key, data, contentType)
+	if err != nil {
+		return fmt.Errorf("failed to upload object '%s': %w", key, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to upload object '%s', %w", key, readS3Error(res))
+	}
+
+	return nil
+}
+
+func (s *S3Storage) Get(parentCtx context.Context, key string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Minute)
+	defer cancel()
+
+	res, err := s.do(ctx, http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download object '%s': %w", key, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		data, err := io.ReadAll(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object '%s': %w", key, err)
+		}
+		return data, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("failed to download object '%s', %w", key, readS3Error(res))
+	}
+}
+
+func (s *S3Storage) Delete(parentCtx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(parentCtx, time.Minute)
+	defer cancel()
+
+	res, err := s.do(ctx, http.MethodDelete, key, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to delete object '%s': %w", key, err)
+	}
+	defer res.Body.Close()
+
+	// S3 responds with 204 even if the object doesn't exist, but other services may respond with 404
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNoContent && res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("failed to delete object '%s', %w", key, readS3Error(res))
+	}
+
+	return nil
+}
+
+// do sends a signed request for the object with the given key
+func (s *S3Storage) do(ctx context.Context, method, key string, data []byte, contentType string) (*http.Response, error) {
+	err := validateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(s.prefix+key), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	signS3RequestV4(req, sha256Hex(data), s.accessKeyID, s.secretAccessKey, s.region, time.Now())
+
+	return s.httpClient.Do(req)
+}
+
+// readS3Error returns an error with the status code and the XML document describing the error in the body of the response
+func readS3Error(res *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1<<10))
+	return fmt.Errorf("received HTTP %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
+
+func (s *S3Storage) objectURL(key string) string {
+	u := *s.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if s.pathStyle {
+		u.Path = basePath + "/" + s.bucket + "/" + key
+	} else {
+		u.Host = s.bucket + "." + u.Host
+		u.Path = basePath + "/" + key
+	}
+	u.RawPath = ""
+	return u.String()
+}
+
+// signS3RequestV4 adds the headers of AWS Signature Version 4 to the request.
+// All headers already set on the request are signed, in addition to the host.
+func signS3RequestV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s3EscapePath(req.URL.Path),
+		s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	signingKey := hmacSha256([]byte("AWS4"+secretAccessKey), day)
+	signingKey = hmacSha256(signingKey, region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+",SignedHeaders="+signedHeaders+",Signature="+signature)
+}
+
+// s3EscapePath encodes every byte of the path except the unreserved characters and the slashes, as required by S3
+func s3EscapePath(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	var sb strings.Builder
+	for _, b := range []byte(p) {
+		if b == '/' || (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') || b == '-' || b == '_' || b == '.' || b == '~' {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+func s3CanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, strings.ReplaceAll(url.QueryEscape(key), "+", "%20")+"="+strings.ReplaceAll(url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -9,6 +9,9 @@ import (
 	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // GenerateRandomAlphanumericString generates a random alphanumeric string of the given length
@@ -115,3 +118,102 @@ func GetFirstCharacter(str string) string {
 	// Empty string case
 	return ""
 }
+
+// RemoveAccents removes the diacritics from the text (e.g. "é" becomes "e"), keeping the other characters unchanged
+func RemoveAccents(str string) string {
+	isASCII := true
+	for i := 0; i < len(str); i++ {
+		if str[i] >= utf8.RuneSelf {
+			isASCII = false
+			break
+		}
+	}
+	if isASCII {
+		return str
+	}
+
+	// Decompose the characters so that the accents become separate marks that can be dropped
+	decomposed := norm.NFD.String(str)
+	result := strings.Builder{}
+	result.Grow(len(decomposed))
+	for _, r := range decomposed {
+		if !unicode.Is(unicode.Mn, r) {
+			result.WriteRune(r)
+		}
+	}
+
+	return norm.NFC.String(result.String())
+}
+
+// NormalizeUnicode applies the Unicode normalization form to the text.
+// The form is "nfkc" for compatibility composition; any other value uses canonical composition (NFC).
+func NormalizeUnicode(str string, form string) string {
+	if strings.EqualFold(form, "nfkc") {
+		return norm.NFKC.String(str)
+	}
+	return norm.NFC.String(str)
+}
+
+// UsernameFromEmail derives a username from the local part of an email address.
+// The result only contains lowercase letters, numbers, dots, underscores and hyphens, starts and ends with an
+// alphanumeric character, and is at most maxLength characters long.
+// An empty string is returned if no username can be derived.
+func UsernameFromEmail(email string, maxLength int) string {
+	localPart, _, _ := strings.Cut(email, "@")
+
+	// Decompose the characters so that accents can be dropped (e.g. "é" becomes "e")
+	localPart = norm.NFKD.String(strings.ToLower(localPart))
+
+	result := strings.Builder{}
+	result.Grow(len(localPart))
+	for _, r := range localPart {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			result.WriteRune(r)
+		case r == '+':
+			// Drop sub-addressing tags, such as "user+tag@example.com"
+			return trimUsername(result.String(), maxLength)
+		}
+	}
+
+	return trimUsername(result.String(), maxLength)
+}
+
+// Slugify converts the text to a URL-safe slug, e.g. "Über Admins!" becomes "uber-admins".
+// The result only contains lowercase letters, numbers and single hyphens between them, and is at most maxLength characters long.
+func Slugify(str string, maxLength int) string {
+	str = RemoveAccents(strings.ToLower(str))
+
+	result := strings.Builder{}
+	result.Grow(len(str))
+	pendingHyphen := false
+	for _, r := range str {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if pendingHyphen && result.Len() > 0 {
+				result.WriteByte('-')
+			}
+			pendingHyphen = false
+			result.WriteRune(r)
+		} else {
+			pendingHyphen = true
+		}
+	}
+
+	slug := result.String()
+	if len(slug) > maxLength {
+		slug = strings.TrimRight(slug[:maxLength], "-")
+	}
+	return slug
+}
+
+func trimUsername(username string, maxLength int) string {
+	isAlphanumeric := func(r rune) bool {
+		return (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9')
+	}
+
+	username = strings.TrimFunc(username, func(r rune) bool { return !isAlphanumeric(r) })
+	if len(username) > maxLength {
+		username = strings.TrimRightFunc(username[:maxLength], func(r rune) bool { return !isAlphanumeric(r) })
+	}
+	return username
+}
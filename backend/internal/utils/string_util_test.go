@@ -128,3 +128,94 @@ func TestGetFirstCharacter(t *testing.T) {
 		})
 	}
 }
+
+func TestUsernameFromEmail(t *testing.T) {
+	tests := []struct {
+		name      string
+		email     string
+		maxLength int
+		expected  string
+	}{
+		{"simple", "john.doe@example.com", 50, "john.doe"},
+		{"uppercase", "John.Doe@example.com", 50, "john.doe"},
+		{"accents", "élodie@example.com", 50, "elodie"},
+		{"sub-address", "john+newsletter@example.com", 50, "john"},
+		{"invalid characters", "j!o#h$n@example.com", 50, "john"},
+		{"leading and trailing special characters", "._john_.@example.com", 50, "john"},
+		{"truncated", "averyveryverylongname@example.com", 10, "averyveryv"},
+		{"truncated on special character", "abcd.efgh@example.com", 5, "abcd"},
+		{"nothing usable", "!!!@example.com", 50, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := UsernameFromEmail(tt.email, tt.maxLength)
+			if result != tt.expected {
+				t.Errorf("UsernameFromEmail(%q) = %q, want %q", tt.email, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		maxLength int
+		expected  string
+	}{
+		{"simple", "Admins", 50, "admins"},
+		{"spaces", "Human Resources", 50, "human-resources"},
+		{"accents", "Über Équipe", 50, "uber-equipe"},
+		{"special characters", "  R&D -- Team!  ", 50, "r-d-team"},
+		{"truncated on hyphen", "abcd efgh", 5, "abcd"},
+		{"nothing usable", "!!!", 50, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := Slugify(tt.input, tt.maxLength)
+			if result != tt.expected {
+				t.Errorf("Slugify(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRemoveAccents(t *testing.T) {
+	tests := map[string]string{
+		"Federighi":     "Federighi",
+		"Fédérighi":     "Federighi",
+		"Élodie Müller": "Elodie Muller",
+		"Ångström":      "Angstrom",
+		"日本語":           "日本語",
+		"":              "",
+	}
+
+	for input, expected := range tests {
+		if got := RemoveAccents(input); got != expected {
+			t.Errorf("RemoveAccents(%q) = %q, want %q", input, got, expected)
+		}
+	}
+}
+
+func TestNormalizeUnicode(t *testing.T) {
+	tests := []struct {
+		input    string
+		form     string
+		expected string
+	}{
+		{"Cafe\u0301", "nfc", "Caf\u00e9"},
+		{"Cafe\u0301", "nfkc", "Caf\u00e9"},
+		{"\uff21lice", "nfc", "\uff21lice"},
+		{"\uff21lice", "nfkc", "Alice"},
+		{"\ufb01nance", "", "\ufb01nance"},
+		{"\ufb01nance", "NFKC", "finance"},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeUnicode(tt.input, tt.form); got != tt.expected {
+			t.Errorf("NormalizeUnicode(%q, %q) = %q, want %q", tt.input, tt.form, got, tt.expected)
+		}
+	}
+}
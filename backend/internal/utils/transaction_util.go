@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	sqlitelib "github.com/glebarez/go-sqlite"
+	"gorm.io/gorm"
+)
+
+const (
+	// Number of times a transaction is run before giving up on transient errors
+	transactionMaxAttempts = 5
+	// Delay before the first retry; it's doubled after every attempt
+	transactionInitialBackoff = 25 * time.Millisecond
+)
+
+// SQLite result codes of a database that is locked by another connection
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// WithRetryableTransaction runs fn in a transaction and commits it.
+// If the transaction fails because of a transient error, like a busy SQLite database or a serialization failure in Postgres, it's retried with a backoff.
+// Because fn may run more than once, it must not have side effects outside of the transaction.
+func WithRetryableTransaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	backoff := transactionInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := runTransaction(ctx, db, fn)
+		if err == nil || attempt >= transactionMaxAttempts || !IsTransientDatabaseError(err) {
+			return err
+		}
+
+		slog.DebugContext(ctx, "Retrying transaction after a transient error", slog.Int("attempt", attempt), slog.Any("error", err))
+
+		// Add jitter so that concurrent transactions don't retry at the same time
+		delay := backoff/2 + rand.N(backoff) //nolint:gosec
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
+}
+
+func runTransaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer func() {
+		// This is a no-op if the transaction was committed
+		tx.Rollback()
+	}()
+
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// IsTransientDatabaseError returns true if the error is caused by a concurrent transaction, so that the operation may succeed if it's retried
+func IsTransientDatabaseError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var sqliteErr *sqlitelib.Error
+	if errors.As(err, &sqliteErr) {
+		// Extended result codes contain the primary result code in the lowest byte
+		switch sqliteErr.Code() & 0xff {
+		case sqliteBusy, sqliteLocked:
+			return true
+		}
+		return false
+	}
+
+	// Errors returned by the Postgres driver expose the SQLSTATE code
+	var pgErr interface{ SQLState() string }
+	if errors.As(err, &pgErr) {
+		switch pgErr.SQLState() {
+		case "40001", // serialization_failure
+			"40P01": // deadlock_detected
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type testPgError struct {
+	code string
+}
+
+func (e *testPgError) Error() string    { return "pg error " + e.code }
+func (e *testPgError) SQLState() string { return e.code }
+
+func newTransactionTestDatabase(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE items (name TEXT)").Error)
+
+	return db
+}
+
+func countItems(t *testing.T, db *gorm.DB) int64 {
+	t.Helper()
+
+	var count int64
+	require.NoError(t, db.Table("items").Count(&count).Error)
+	return count
+}
+
+func TestWithRetryableTransaction(t *testing.T) {
+	t.Run("retries transient errors", func(t *testing.T) {
+		db := newTransactionTestDatabase(t)
+
+		attempts := 0
+		err := WithRetryableTransaction(t.Context(), db, func(tx *gorm.DB) error {
+			attempts++
+			err := tx.Exec("INSERT INTO items (name) VALUES (?)", "item").Error
+			if err != nil {
+				return err
+			}
+			if attempts < 3 {
+				return &testPgError{code: "40001"}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+		// Only the row of the successful attempt is committed
+		assert.Equal(t, int64(1), countItems(t, db))
+	})
+
+	t.Run("does not retry other errors", func(t *testing.T) {
+		db := newTransactionTestDatabase(t)
+
+		attempts := 0
+		expectedErr := errors.New("test error")
+		err := WithRetryableTransaction(t.Context(), db, func(tx *gorm.DB) error {
+			attempts++
+			require.NoError(t, tx.Exec("INSERT INTO items (name) VALUES (?)", "item").Error)
+			return expectedErr
+		})
+		require.ErrorIs(t, err, expectedErr)
+		assert.Equal(t, 1, attempts)
+		assert.Equal(t, int64(0), countItems(t, db))
+	})
+
+	t.Run("gives up after the maximum number of attempts", func(t *testing.T) {
+		db := newTransactionTestDatabase(t)
+
+		attempts := 0
+		err := WithRetryableTransaction(t.Context(), db, func(tx *gorm.DB) error {
+			attempts++
+			return &testPgError{code: "40P01"}
+		})
+		require.Error(t, err)
+		assert.Equal(t, transactionMaxAttempts, attempts)
+	})
+}
+
+func TestIsTransientDatabaseError(t *testing.T) {
+	assert.False(t, IsTransientDatabaseError(nil))
+	assert.False(t, IsTransientDatabaseError(errors.New("test error")))
+	assert.True(t, IsTransientDatabaseError(&testPgError{code: "40001"}))
+	assert.False(t, IsTransientDatabaseError(&testPgError{code: "23505"}))
+
+	t.Run("detects a busy SQLite database", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		db1, err := gorm.Open(sqlite.Open("file:"+path+"?_txlock=immediate"), &gorm.Config{})
+		require.NoError(t, err)
+		db2, err := gorm.Open(sqlite.Open("file:"+path+"?_txlock=immediate&_pragma=busy_timeout(0)"), &gorm.Config{})
+		require.NoError(t, err)
+
+		// The first transaction holds the write lock, so the second one can't start
+		tx1 := db1.Begin()
+		require.NoError(t, tx1.Error)
+		defer tx1.Rollback()
+
+		tx2 := db2.Begin()
+		require.Error(t, tx2.Error)
+		assert.True(t, IsTransientDatabaseError(tx2.Error))
+	})
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"mime/multipart"
+	"slices"
+	"strings"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+	imageutil "github.com/pocket-id/pocket-id/backend/internal/utils/image"
+)
+
+// ValidateImageUpload reads an uploaded image and processes it with the given options.
+// The extension of the file must be one of the allowed types, and it must match the type detected from the content.
+// If the image isn't converted, the type of the returned image is the lowercased extension of the file.
+func ValidateImageUpload(file *multipart.FileHeader, opts imageutil.Options) (imageutil.Image, error) {
+	fileType := strings.ToLower(GetFileExtension(file.Filename))
+	if !slices.Contains(opts.AllowedTypes, fileType) {
+		return imageutil.Image{}, &common.FileTypeNotSupportedError{}
+	}
+
+	data, err := ReadFile(file)
+	if err != nil {
+		return imageutil.Image{}, err
+	}
+
+	img, err := imageutil.Process(data, opts)
+	if err != nil {
+		return imageutil.Image{}, err
+	}
+
+	if opts.OutputFormat == "" {
+		if img.Type != imageutil.NormalizeType(fileType) {
+			return imageutil.Image{}, &common.FileContentMismatchError{}
+		}
+		img.Type = fileType
+	}
+
+	return img, nil
+}
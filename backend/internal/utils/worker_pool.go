@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+
+	"github.com/pocket-id/pocket-id/backend/internal/common"
+)
+
+// Number of workers used when the database connection pool is unbounded
+const defaultWorkerPoolLimit = 4
+
+// WorkerPool limits the number of concurrent workers of bulk operations.
+// The limit is shared by all operations that use the same pool, so that together they don't saturate the database connection pool.
+type WorkerPool struct {
+	name     string
+	sem      chan struct{}
+	inFlight atomic.Int64
+}
+
+// NewWorkerPool creates a WorkerPool that runs at most limit workers at the same time.
+// The number of workers in flight is exposed as a metric, identified by the name of the pool.
+func NewWorkerPool(name string, limit int) *WorkerPool {
+	p := &WorkerPool{
+		name: name,
+		sem:  make(chan struct{}, max(limit, 1)),
+	}
+
+	_, err := otel.Meter(common.MeterName).Int64ObservableUpDownCounter(
+		"pocket_id.worker_pool.in_flight",
+		metric.WithDescription("Number of workers of bulk operations that are currently running"),
+		metric.WithInt64Callback(func(_ context.Context, o metric.Int64Observer) error {
+			o.Observe(p.InFlight(), metric.WithAttributes(attribute.String("pool", p.name)))
+			return nil
+		}),
+	)
+	if err != nil {
+		slog.Warn("Failed to register worker pool metric", slog.String("pool", name), slog.Any("error", err))
+	}
+
+	return p
+}
+
+// DefaultWorkerPoolLimit returns a conservative concurrency limit for a database connection pool of the given size.
+// Half of the connections are left for serving requests; if the pool is unbounded (maxOpenConns <= 0), a small fixed limit is used.
+func DefaultWorkerPoolLimit(maxOpenConns int) int {
+	if maxOpenConns <= 0 {
+		return defaultWorkerPoolLimit
+	}
+	return max(maxOpenConns/2, 1)
+}
+
+// Limit returns the maximum number of concurrent workers
+func (p *WorkerPool) Limit() int {
+	return cap(p.sem)
+}
+
+// InFlight returns the number of workers that are currently running
+func (p *WorkerPool) InFlight() int64 {
+	return p.inFlight.Load()
+}
+
+// Each calls fn for every index in [0, n), running at most Limit() calls at the same time across all users of the pool.
+// It waits for all calls to complete and returns their errors joined together.
+// If the context is canceled, the remaining items are not started and the context's error is returned as well.
+// fn must not call Each on the same pool, as that could deadlock.
+func (p *WorkerPool) Each(ctx context.Context, n int, fn func(ctx context.Context, i int) error) error {
+	errs := make([]error, n+1)
+
+	var wg sync.WaitGroup
+	for i := range n {
+		// Check the context first, as select picks randomly if a slot is free as well
+		if ctx.Err() != nil {
+			errs[n] = ctx.Err()
+			break
+		}
+		select {
+		case p.sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[n] = ctx.Err()
+		}
+		if errs[n] != nil {
+			break
+		}
+
+		wg.Add(1)
+		p.inFlight.Add(1)
+		go func() {
+			defer func() {
+				p.inFlight.Add(-1)
+				<-p.sem
+				wg.Done()
+			}()
+			errs[i] = fn(ctx, i)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPool_Each(t *testing.T) {
+	t.Run("limits the number of concurrent workers", func(t *testing.T) {
+		pool := NewWorkerPool("test", 3)
+
+		var running, maxRunning atomic.Int64
+		var processed atomic.Int64
+		err := pool.Each(t.Context(), 20, func(ctx context.Context, i int) error {
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			processed.Add(1)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, int64(20), processed.Load())
+		assert.LessOrEqual(t, maxRunning.Load(), int64(3))
+		assert.Zero(t, pool.InFlight())
+	})
+
+	t.Run("returns the errors of all workers", func(t *testing.T) {
+		pool := NewWorkerPool("test", 2)
+
+		errOdd := errors.New("odd")
+		err := pool.Each(t.Context(), 4, func(ctx context.Context, i int) error {
+			if i%2 == 1 {
+				return errOdd
+			}
+			return nil
+		})
+		require.ErrorIs(t, err, errOdd)
+	})
+
+	t.Run("stops starting workers when the context is canceled", func(t *testing.T) {
+		pool := NewWorkerPool("test", 1)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		var processed atomic.Int64
+		err := pool.Each(ctx, 10, func(ctx context.Context, i int) error {
+			processed.Add(1)
+			cancel()
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, int64(1), processed.Load())
+	})
+}
+
+func TestDefaultWorkerPoolLimit(t *testing.T) {
+	assert.Equal(t, defaultWorkerPoolLimit, DefaultWorkerPoolLimit(0))
+	assert.Equal(t, 1, DefaultWorkerPoolLimit(1))
+	assert.Equal(t, 5, DefaultWorkerPoolLimit(10))
+}
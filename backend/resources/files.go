@@ -4,5 +4,5 @@ import "embed"
 
 // Embedded file systems for the project
 
-//go:embed email-templates images migrations fonts aaguids.json
+//go:embed email-templates images migrations fonts aaguids.json disposable-email-domains.txt
 var FS embed.FS
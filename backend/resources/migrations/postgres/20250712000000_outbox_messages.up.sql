@@ -0,0 +1,14 @@
+-- The "outbox_messages" table contains side effects (such as notification emails) that are stored in the same transaction as the change that caused them, and are delivered by a background worker
+CREATE TABLE outbox_messages
+(
+    id           UUID NOT NULL PRIMARY KEY,
+    created_at   TIMESTAMPTZ,
+    type         TEXT NOT NULL,
+    payload      TEXT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    available_at TIMESTAMPTZ NOT NULL,
+    locked_until TIMESTAMPTZ,
+    last_error   TEXT
+);
+
+CREATE INDEX idx_outbox_messages_available_at ON outbox_messages (available_at);
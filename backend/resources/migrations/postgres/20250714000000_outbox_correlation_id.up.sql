@@ -0,0 +1,2 @@
+-- Correlation ID of the request that enqueued the message, so the logs of the delivery can be tied to the request
+ALTER TABLE outbox_messages ADD COLUMN correlation_id TEXT;
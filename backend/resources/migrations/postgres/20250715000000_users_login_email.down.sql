@@ -0,0 +1,2 @@
+DROP INDEX users_login_email;
+ALTER TABLE users DROP COLUMN login_email;
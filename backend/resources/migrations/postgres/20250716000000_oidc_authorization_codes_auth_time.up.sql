@@ -0,0 +1,2 @@
+-- Time of the last interactive sign in of the user, emitted as the auth_time claim of the ID token
+ALTER TABLE oidc_authorization_codes ADD COLUMN auth_time TIMESTAMPTZ;
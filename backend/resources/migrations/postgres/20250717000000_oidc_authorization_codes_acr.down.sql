@@ -0,0 +1,2 @@
+ALTER TABLE oidc_authorization_codes DROP COLUMN acr;
+ALTER TABLE oidc_authorization_codes DROP COLUMN auth_methods;
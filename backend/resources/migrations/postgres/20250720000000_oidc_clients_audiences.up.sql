@@ -0,0 +1,2 @@
+-- Additional audiences of the access tokens issued to the client
+ALTER TABLE oidc_clients ADD COLUMN audiences JSONB NULL;
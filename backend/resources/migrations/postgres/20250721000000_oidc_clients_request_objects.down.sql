@@ -0,0 +1,2 @@
+ALTER TABLE oidc_clients DROP COLUMN require_signed_request_object;
+ALTER TABLE oidc_clients DROP COLUMN jwks_url;
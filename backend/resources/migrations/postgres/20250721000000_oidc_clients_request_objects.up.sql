@@ -0,0 +1,3 @@
+-- JWK set of the client and whether signed request objects are required
+ALTER TABLE oidc_clients ADD COLUMN jwks_url TEXT NOT NULL DEFAULT '';
+ALTER TABLE oidc_clients ADD COLUMN require_signed_request_object BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- Show the name and logo of the client on the login pages, enabled by default
+ALTER TABLE oidc_clients ADD COLUMN login_branding_enabled BOOLEAN NOT NULL DEFAULT TRUE;
@@ -0,0 +1,11 @@
+-- IP address of the last interactive sign in
+ALTER TABLE users ADD COLUMN last_login_ip TEXT;
+
+-- Use the sign ins that are still in the audit log for existing users
+UPDATE users SET last_login_ip = (
+    SELECT host(audit_logs.ip_address)
+    FROM audit_logs
+    WHERE audit_logs.user_id = users.id AND audit_logs.event IN ('SIGN_IN', 'TOKEN_SIGN_IN')
+    ORDER BY audit_logs.created_at DESC
+    LIMIT 1
+);
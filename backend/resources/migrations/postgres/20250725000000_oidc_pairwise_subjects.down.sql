@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_user_authorized_oidc_clients_client_id_subject;
+ALTER TABLE user_authorized_oidc_clients DROP COLUMN subject;
+ALTER TABLE oidc_clients DROP COLUMN pairwise_sector_identifier;
+ALTER TABLE oidc_clients DROP COLUMN subject_type;
@@ -0,0 +1,7 @@
+-- Subject type of the client, and the sector identifier used to derive pairwise subjects
+ALTER TABLE oidc_clients ADD COLUMN subject_type TEXT NOT NULL DEFAULT 'public';
+ALTER TABLE oidc_clients ADD COLUMN pairwise_sector_identifier TEXT NOT NULL DEFAULT '';
+
+-- Pairwise subject of the user for the client, used to find the user of an access token
+ALTER TABLE user_authorized_oidc_clients ADD COLUMN subject TEXT;
+CREATE INDEX idx_user_authorized_oidc_clients_client_id_subject ON user_authorized_oidc_clients (client_id, subject);
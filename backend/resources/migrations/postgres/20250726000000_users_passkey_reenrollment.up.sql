@@ -0,0 +1,2 @@
+-- Time at which an admin required the user to enroll new passkeys; sessions started before it are invalid
+ALTER TABLE users ADD COLUMN passkey_reenrollment_required_at TIMESTAMPTZ;
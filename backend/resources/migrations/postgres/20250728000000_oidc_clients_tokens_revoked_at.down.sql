@@ -0,0 +1 @@
+ALTER TABLE oidc_clients DROP COLUMN tokens_revoked_at;
@@ -0,0 +1,2 @@
+-- Used authorization codes are kept until they expire, so that reuse can be detected
+ALTER TABLE oidc_authorization_codes ADD COLUMN used_at TIMESTAMPTZ;
@@ -0,0 +1,3 @@
+-- Users missing from LDAP are only disabled or deleted after a grace period
+ALTER TABLE users ADD COLUMN ldap_missing_since TIMESTAMPTZ;
+ALTER TABLE users ADD COLUMN ldap_missing_syncs INTEGER NOT NULL DEFAULT 0;
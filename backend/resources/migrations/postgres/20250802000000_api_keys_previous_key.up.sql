@@ -0,0 +1,4 @@
+-- The secret of a rotated API key stays valid until the end of the grace period
+ALTER TABLE api_keys ADD COLUMN previous_key VARCHAR(255);
+ALTER TABLE api_keys ADD COLUMN previous_key_expires_at TIMESTAMPTZ;
+CREATE INDEX idx_api_keys_previous_key ON api_keys(previous_key);
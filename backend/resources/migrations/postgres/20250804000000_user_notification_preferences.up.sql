@@ -0,0 +1,9 @@
+-- Notification emails the users opted in to or out of; without a row, the notification is sent
+CREATE TABLE user_notification_preferences
+(
+    user_id      UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    notification TEXT        NOT NULL,
+    enabled      BOOLEAN     NOT NULL,
+    updated_at   TIMESTAMPTZ,
+    PRIMARY KEY (user_id, notification)
+);
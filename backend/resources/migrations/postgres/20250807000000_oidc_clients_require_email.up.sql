@@ -0,0 +1,2 @@
+-- Require the users to have an email address to authorize the client, disabled by default
+ALTER TABLE oidc_clients ADD COLUMN require_email BOOLEAN NOT NULL DEFAULT FALSE;
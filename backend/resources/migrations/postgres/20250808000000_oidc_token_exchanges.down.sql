@@ -0,0 +1 @@
+DROP TABLE oidc_token_exchanges;
@@ -0,0 +1,3 @@
+-- Sessions issued before this time are invalid, e.g. because an admin required the user to enroll new passkeys
+ALTER TABLE users ADD COLUMN sessions_valid_after TIMESTAMPTZ;
+UPDATE users SET sessions_valid_after = passkey_reenrollment_required_at WHERE passkey_reenrollment_required_at IS NOT NULL;
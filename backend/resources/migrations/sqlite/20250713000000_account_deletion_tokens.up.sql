@@ -0,0 +1,8 @@
+CREATE TABLE account_deletion_tokens
+(
+    id         TEXT NOT NULL PRIMARY KEY,
+    created_at DATETIME,
+    token_hash TEXT NOT NULL UNIQUE,
+    expires_at DATETIME NOT NULL,
+    user_id    TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
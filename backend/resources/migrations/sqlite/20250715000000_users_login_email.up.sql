@@ -0,0 +1,3 @@
+-- Optional email used to look up the user for one-time access emails, distinct from the contact email
+ALTER TABLE users ADD COLUMN login_email TEXT;
+CREATE UNIQUE INDEX users_login_email ON users (login_email);
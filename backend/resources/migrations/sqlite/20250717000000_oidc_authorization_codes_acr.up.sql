@@ -0,0 +1,3 @@
+-- Methods the user signed in with, emitted as the amr and acr claims of the ID token
+ALTER TABLE oidc_authorization_codes ADD COLUMN auth_methods TEXT NOT NULL DEFAULT '';
+ALTER TABLE oidc_authorization_codes ADD COLUMN acr TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Existing clients keep all the grant types that they could use before
+ALTER TABLE oidc_clients ADD COLUMN grant_types TEXT NULL;
+UPDATE oidc_clients SET grant_types = '["authorization_code","refresh_token","urn:ietf:params:oauth:grant-type:device_code"]';
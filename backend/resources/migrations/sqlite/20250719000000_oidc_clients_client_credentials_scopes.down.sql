@@ -0,0 +1 @@
+ALTER TABLE oidc_clients DROP COLUMN client_credentials_scopes;
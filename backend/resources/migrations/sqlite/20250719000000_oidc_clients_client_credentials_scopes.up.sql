@@ -0,0 +1,2 @@
+-- Scopes of the access tokens issued to the client itself with the client_credentials grant
+ALTER TABLE oidc_clients ADD COLUMN client_credentials_scopes TEXT NULL;
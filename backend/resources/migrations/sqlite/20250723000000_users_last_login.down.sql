@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN inactivity_email_sent;
+ALTER TABLE users DROP COLUMN last_login_at;
@@ -0,0 +1,10 @@
+-- Time of the last successful sign in, used to disable inactive users
+ALTER TABLE users ADD COLUMN last_login_at DATETIME;
+ALTER TABLE users ADD COLUMN inactivity_email_sent BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Use the sign ins that are still in the audit log for existing users
+UPDATE users SET last_login_at = (
+    SELECT MAX(audit_logs.created_at)
+    FROM audit_logs
+    WHERE audit_logs.user_id = users.id AND audit_logs.event IN ('SIGN_IN', 'TOKEN_SIGN_IN')
+);
@@ -0,0 +1 @@
+ALTER TABLE users DROP COLUMN passkey_reenrollment_required_at;
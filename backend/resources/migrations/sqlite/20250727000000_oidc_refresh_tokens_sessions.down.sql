@@ -0,0 +1,2 @@
+ALTER TABLE oidc_refresh_tokens DROP COLUMN session_started_at;
+ALTER TABLE oidc_refresh_tokens DROP COLUMN ip_address;
@@ -0,0 +1,5 @@
+-- IP address of the request that issued the refresh token, and start of the session the token belongs to
+ALTER TABLE oidc_refresh_tokens ADD COLUMN ip_address TEXT;
+ALTER TABLE oidc_refresh_tokens ADD COLUMN session_started_at DATETIME;
+
+UPDATE oidc_refresh_tokens SET session_started_at = created_at;
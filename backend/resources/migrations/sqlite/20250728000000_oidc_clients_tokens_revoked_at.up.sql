@@ -0,0 +1,2 @@
+-- Access tokens of the client issued before this time are rejected, which revokes them until they expire
+ALTER TABLE oidc_clients ADD COLUMN tokens_revoked_at DATETIME;
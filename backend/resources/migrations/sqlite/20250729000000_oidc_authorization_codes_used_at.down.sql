@@ -0,0 +1 @@
+ALTER TABLE oidc_authorization_codes DROP COLUMN used_at;
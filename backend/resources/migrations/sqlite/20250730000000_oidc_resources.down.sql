@@ -0,0 +1,2 @@
+ALTER TABLE oidc_refresh_tokens DROP COLUMN resources;
+ALTER TABLE oidc_authorization_codes DROP COLUMN resources;
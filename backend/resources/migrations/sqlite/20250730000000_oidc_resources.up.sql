@@ -0,0 +1,3 @@
+-- Resource servers (RFC 8707) the user authorized the client to get access tokens for; if empty, all the audiences of the client are allowed
+ALTER TABLE oidc_authorization_codes ADD COLUMN resources TEXT NULL;
+ALTER TABLE oidc_refresh_tokens ADD COLUMN resources TEXT NULL;
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN ldap_missing_syncs;
+ALTER TABLE users DROP COLUMN ldap_missing_since;
@@ -0,0 +1,10 @@
+-- Failed attempts to sign in with a one-time access token, which are used to slow down and limit the guessing of short codes
+CREATE TABLE one_time_access_failures
+(
+    id         TEXT NOT NULL PRIMARY KEY,
+    created_at DATETIME NOT NULL,
+    ip_address TEXT NOT NULL
+);
+
+CREATE INDEX idx_one_time_access_failures_created_at ON one_time_access_failures (created_at);
+CREATE INDEX idx_one_time_access_failures_ip_address ON one_time_access_failures (ip_address, created_at);
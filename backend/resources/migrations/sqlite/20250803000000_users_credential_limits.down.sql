@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN max_passkeys;
+ALTER TABLE users DROP COLUMN max_api_keys;
@@ -0,0 +1,3 @@
+-- Limits of the number of API keys and passkeys of a user, overriding the ones of the app config
+ALTER TABLE users ADD COLUMN max_api_keys INTEGER;
+ALTER TABLE users ADD COLUMN max_passkeys INTEGER;
@@ -0,0 +1,15 @@
+-- The "import_reports" table contains the outcome of every row of bulk imports, so admins can download it after the import
+CREATE TABLE import_reports
+(
+    id            TEXT NOT NULL PRIMARY KEY,
+    created_at    DATETIME,
+    expires_at    DATETIME NOT NULL,
+    type          TEXT NOT NULL,
+    actor_user_id TEXT REFERENCES users (id) ON DELETE SET NULL,
+    committed     BOOLEAN NOT NULL,
+    total_count   INTEGER NOT NULL,
+    failed_count  INTEGER NOT NULL,
+    entries       TEXT NOT NULL
+);
+
+CREATE INDEX idx_import_reports_expires_at ON import_reports (expires_at);
@@ -0,0 +1,21 @@
+-- The "background_jobs" table contains long-running operations that are run by a background worker, so clients can poll their status
+CREATE TABLE background_jobs
+(
+    id               TEXT NOT NULL PRIMARY KEY,
+    created_at       DATETIME,
+    type             TEXT NOT NULL,
+    status           TEXT NOT NULL,
+    payload          TEXT NOT NULL,
+    actor_user_id    TEXT REFERENCES users (id) ON DELETE SET NULL,
+    progress_done    INTEGER NOT NULL DEFAULT 0,
+    progress_total   INTEGER NOT NULL DEFAULT 0,
+    result           TEXT,
+    error            TEXT,
+    cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+    locked_until     DATETIME,
+    started_at       DATETIME,
+    finished_at      DATETIME
+);
+
+CREATE INDEX idx_background_jobs_status_created_at ON background_jobs (status, created_at);
+CREATE INDEX idx_background_jobs_finished_at ON background_jobs (finished_at);
@@ -0,0 +1,13 @@
+-- The "oidc_token_exchanges" table records the delegation chain of the access tokens issued with the token exchange grant, by their "jti"
+CREATE TABLE oidc_token_exchanges
+(
+    id               TEXT NOT NULL PRIMARY KEY,
+    created_at       DATETIME,
+    client_id        TEXT NOT NULL REFERENCES oidc_clients (id) ON DELETE CASCADE,
+    user_id          TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    actor_id         TEXT NOT NULL DEFAULT '',
+    subject_token_id TEXT,
+    expires_at       DATETIME NOT NULL
+);
+
+CREATE INDEX idx_oidc_token_exchanges_expires_at ON oidc_token_exchanges (expires_at);
@@ -0,0 +1,2 @@
+-- Entries whose IP address has no known location, so it isn't looked up again
+ALTER TABLE audit_logs ADD COLUMN location_unknown BOOLEAN NOT NULL DEFAULT FALSE;
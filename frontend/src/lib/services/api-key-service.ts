@@ -1,4 +1,4 @@
-import type { ApiKey, ApiKeyCreate, ApiKeyResponse } from '$lib/types/api-key.type';
+import type { ApiKey, ApiKeyCreate, ApiKeyResponse, ApiKeyRotate } from '$lib/types/api-key.type';
 import type { Paginated, SearchPaginationSortRequest } from '$lib/types/pagination.type';
 import APIService from './api-service';
 
@@ -15,6 +15,11 @@ export default class ApiKeyService extends APIService {
 		return res.data as ApiKeyResponse;
 	}
 
+	async rotate(id: string, data: ApiKeyRotate = {}): Promise<ApiKeyResponse> {
+		const res = await this.api.post(`/api-keys/${id}/rotate`, data);
+		return res.data as ApiKeyResponse;
+	}
+
 	async revoke(id: string): Promise<void> {
 		await this.api.delete(`/api-keys/${id}`);
 	}
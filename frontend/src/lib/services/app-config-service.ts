@@ -1,4 +1,8 @@
-import type { AllAppConfig, AppConfigRawResponse } from '$lib/types/application-configuration';
+import type {
+	AllAppConfig,
+	AppConfigRawResponse,
+	Locales
+} from '$lib/types/application-configuration';
 import { cachedApplicationLogo, cachedBackgroundImage } from '$lib/utils/cached-image-util';
 import APIService from './api-service';
 
@@ -48,6 +52,11 @@ export default class AppConfigService extends APIService {
 		cachedBackgroundImage.bustCache();
 	}
 
+	async getLocales() {
+		const { data } = await this.api.get<Locales>('/application-configuration/locales');
+		return data;
+	}
+
 	async sendTestEmail() {
 		await this.api.post('/application-configuration/test-email');
 	}
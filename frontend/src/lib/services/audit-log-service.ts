@@ -25,6 +25,11 @@ class AuditLogService extends APIService {
 		return res.data;
 	}
 
+	async listCountries() {
+		const res = await this.api.get<string[]>('/audit-logs/filters/countries');
+		return res.data;
+	}
+
 	async listUsers() {
 		const res = await this.api.get<Record<string, string>>('/audit-logs/filters/users');
 		return res.data;
@@ -5,7 +5,9 @@ import type {
 	OidcClientMetaData,
 	OidcClientWithAllowedUserGroups,
 	OidcClientWithAllowedUserGroupsCount,
-	OidcDeviceCodeInfo
+	OidcDeviceCodeInfo,
+	OidcRevokedClientTokens,
+	OidcSession
 } from '$lib/types/oidc.type';
 import type { Paginated, SearchPaginationSortRequest } from '$lib/types/pagination.type';
 import { cachedOidcClientLogo } from '$lib/utils/cached-image-util';
@@ -18,7 +20,13 @@ class OidcService extends APIService {
 		callbackURL: string,
 		nonce?: string,
 		codeChallenge?: string,
-		codeChallengeMethod?: string
+		codeChallengeMethod?: string,
+		prompt?: string,
+		maxAge?: number,
+		acrValues?: string,
+		request?: string,
+		requestUri?: string,
+		resource?: string[]
 	) {
 		const res = await this.api.post('/oidc/authorize', {
 			scope,
@@ -26,7 +34,13 @@ class OidcService extends APIService {
 			callbackURL,
 			clientId,
 			codeChallenge,
-			codeChallengeMethod
+			codeChallengeMethod,
+			prompt,
+			maxAge,
+			acrValues,
+			request,
+			requestUri,
+			resource
 		});
 
 		return res.data as AuthorizeResponse;
@@ -64,6 +78,12 @@ class OidcService extends APIService {
 		return (await this.api.get(`/oidc/clients/${id}/meta`)).data as OidcClientMetaData;
 	}
 
+	// Returns the client the user authorizes after signing in, if it should be shown on the login pages
+	async getLoginBranding(redirect: string) {
+		const res = await this.api.get('/oidc/login-branding', { params: { redirect } });
+		return (res.data || undefined) as OidcClientMetaData | undefined;
+	}
+
 	async updateClient(id: string, client: OidcClientCreate) {
 		return (await this.api.put(`/oidc/clients/${id}`, client)).data as OidcClient;
 	}
@@ -93,6 +113,19 @@ class OidcService extends APIService {
 		return (await this.api.post(`/oidc/clients/${id}/secret`)).data.secret as string;
 	}
 
+	async cloneClient(id: string) {
+		const res = await this.api.post(`/oidc/clients/${id}/clone`);
+		return res.data as OidcClientWithAllowedUserGroups & { secret?: string };
+	}
+
+	async revokeAllTokens(
+		id: string,
+		options: { revokeAccessTokens?: boolean; requireConsent?: boolean } = {}
+	) {
+		const res = await this.api.post(`/oidc/clients/${id}/revoke-tokens`, options);
+		return res.data as OidcRevokedClientTokens;
+	}
+
 	async updateAllowedUserGroups(id: string, userGroupIds: string[]) {
 		const res = await this.api.put(`/oidc/clients/${id}/allowed-user-groups`, { userGroupIds });
 		return res.data as OidcClientWithAllowedUserGroups;
@@ -113,6 +146,15 @@ class OidcService extends APIService {
 		});
 		return response.data;
 	}
+
+	async listSessions(userId: string) {
+		const res = await this.api.get(`/oidc/users/${userId}/sessions`);
+		return res.data as OidcSession[];
+	}
+
+	async revokeSession(userId: string, sessionId: string) {
+		await this.api.delete(`/oidc/users/${userId}/sessions/${sessionId}`);
+	}
 }
 
 export default OidcService;
@@ -2,7 +2,12 @@ import userStore from '$lib/stores/user-store';
 import type { Paginated, SearchPaginationSortRequest } from '$lib/types/pagination.type';
 import type { SignupTokenDto } from '$lib/types/signup-token.type';
 import type { UserGroup } from '$lib/types/user-group.type';
-import type { User, UserCreate, UserSignUp } from '$lib/types/user.type';
+import type {
+	NotificationPreference,
+	User,
+	UserCreate,
+	UserSignUp
+} from '$lib/types/user.type';
 import { cachedProfilePicture } from '$lib/utils/cached-image-util';
 import { get } from 'svelte/store';
 import APIService from './api-service';
@@ -75,6 +80,16 @@ export default class UserService extends APIService {
 		cachedProfilePicture.bustCache(userId);
 	}
 
+	async getNotificationPreferences() {
+		const res = await this.api.get('/users/me/notification-preferences');
+		return res.data as NotificationPreference[];
+	}
+
+	async updateNotificationPreferences(preferences: Omit<NotificationPreference, 'mandatory'>[]) {
+		const res = await this.api.put('/users/me/notification-preferences', { preferences });
+		return res.data as NotificationPreference[];
+	}
+
 	async createOneTimeAccessToken(expiresAt: Date, userId: string) {
 		const res = await this.api.post(`/users/${userId}/one-time-access-token`, {
 			userId,
@@ -91,6 +106,11 @@ export default class UserService extends APIService {
 		return res.data.token;
 	}
 
+	async peekOneTimeAccessToken(token: string) {
+		const res = await this.api.get(`/one-time-access-token/${token}`);
+		return res.data.valid as boolean;
+	}
+
 	async exchangeOneTimeAccessToken(token: string) {
 		const res = await this.api.post(`/one-time-access-token/${token}`);
 		return res.data as User;
@@ -104,6 +124,14 @@ export default class UserService extends APIService {
 		await this.api.post(`/users/${userId}/one-time-access-email`, { expiresAt });
 	}
 
+	async requirePasskeyReenrollment(userId: string) {
+		await this.api.post(`/users/${userId}/passkey-reenrollment`);
+	}
+
+	async cancelPasskeyReenrollment(userId: string) {
+		await this.api.delete(`/users/${userId}/passkey-reenrollment`);
+	}
+
 	async updateUserGroups(id: string, userGroupIds: string[]) {
 		const res = await this.api.put(`/users/${id}/user-groups`, { userGroupIds });
 		return res.data as User;
@@ -1,6 +1,8 @@
+import { getLocale } from '$lib/paraglide/runtime';
 import AppConfigService from '$lib/services/app-config-service';
 import type { AppConfig } from '$lib/types/application-configuration';
 import { applyAccentColor } from '$lib/utils/accent-color-util';
+import { getLocalizedAppName } from '$lib/utils/locale.util';
 import { writable } from 'svelte/store';
 
 const appConfigStore = writable<AppConfig>();
@@ -14,7 +16,12 @@ const reload = async () => {
 
 const set = (appConfig: AppConfig) => {
 	applyAccentColor(appConfig.accentColor);
-	appConfigStore.set(appConfig);
+	// The UI shows the application name in the language of the user.
+	// The page is reloaded when the language changes, so it's only resolved here.
+	appConfigStore.set({
+		...appConfig,
+		appName: getLocalizedAppName(appConfig.appName, appConfig.appNameLocalized, getLocale())
+	});
 };
 
 export default {
@@ -5,6 +5,7 @@ export type ApiKey = {
 	expiresAt: string;
 	lastUsedAt?: string;
 	createdAt: string;
+	previousKeyExpiresAt?: string;
 };
 
 export type ApiKeyCreate = {
@@ -13,6 +14,10 @@ export type ApiKeyCreate = {
 	expiresAt: Date;
 };
 
+export type ApiKeyRotate = {
+	gracePeriodSeconds?: number;
+};
+
 export type ApiKeyResponse = {
 	apiKey: ApiKey;
 	token: string;
@@ -1,6 +1,8 @@
 export type AppConfig = {
 	appName: string;
+	appNameLocalized: string;
 	allowOwnAccountEdit: boolean;
+	allowUserSelfDeletion: boolean;
 	allowUserSignups: 'disabled' | 'withToken' | 'open';
 	emailOneTimeAccessAsUnauthenticatedEnabled: boolean;
 	emailOneTimeAccessAsAdminEnabled: boolean;
@@ -8,22 +10,34 @@ export type AppConfig = {
 	disableAnimations: boolean;
 	uiConfigDisabled: boolean;
 	accentColor: string;
+	maintenanceModeEnabled: boolean;
+	maintenanceModeMessage: string;
 };
 
 export type AllAppConfig = AppConfig & {
 	// General
 	sessionDuration: number;
+	sessionIdleTimeout: number;
 	emailsVerified: boolean;
+	defaultLocale: string;
+	supportedLocales: string;
 	// Email
 	smtpHost: string;
 	smtpPort: number;
 	smtpFrom: string;
+	smtpFromOnboarding: string;
+	smtpFromSecurity: string;
+	smtpFromNotifications: string;
+	smtpReplyToNotifications: string;
 	smtpUser: string;
 	smtpPassword: string;
 	smtpTls: 'none' | 'starttls' | 'tls';
 	smtpSkipCertVerify: boolean;
 	emailLoginNotificationEnabled: boolean;
 	emailApiKeyExpirationEnabled: boolean;
+	emailWelcomeEnabled: boolean;
+	emailLoginNotificationUserDefault: NotificationUserDefault;
+	emailApiKeyExpirationUserDefault: NotificationUserDefault;
 	// LDAP
 	ldapUrl: string;
 	ldapBindDn: string;
@@ -45,6 +59,8 @@ export type AllAppConfig = AppConfig & {
 	ldapSoftDeleteUsers: boolean;
 };
 
+export type NotificationUserDefault = 'enabled' | 'disabled' | 'mandatory';
+
 export type AppConfigRawResponse = {
 	key: string;
 	type: string;
@@ -56,3 +72,8 @@ export type AppVersionInformation = {
 	newestVersion: string | null;
 	currentVersion: string;
 };
+
+export type Locales = {
+	defaultLocale: string;
+	supportedLocales: string[];
+};
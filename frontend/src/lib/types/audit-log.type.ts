@@ -15,5 +15,6 @@ export type AuditLogFilter = {
 	userId: string;
 	event: string;
 	location: string;
+	country: string;
 	clientName: string;
 };
@@ -23,6 +23,15 @@ export type OidcClient = OidcClientMetaData & {
 	isPublic: boolean;
 	pkceEnabled: boolean;
 	credentials?: OidcClientCredentials;
+	grantTypes?: string[];
+	clientCredentialsScopes?: string[];
+	audiences?: string[];
+	jwksUrl?: string;
+	requireSignedRequestObject?: boolean;
+	loginBrandingEnabled?: boolean;
+	requireEmail?: boolean;
+	subjectType?: 'public' | 'pairwise';
+	pairwiseSectorIdentifier?: string;
 };
 
 export type OidcClientWithAllowedUserGroups = OidcClient & {
@@ -49,4 +58,25 @@ export type AuthorizeResponse = {
 	code: string;
 	callbackURL: string;
 	issuer: string;
+	error?: string;
+	reauthenticationRequired?: boolean;
+};
+
+export type OidcSession = {
+	id: string;
+	client: OidcClientMetaData;
+	scope: string;
+	startedAt: string;
+	lastUsedAt: string;
+	expiresAt: string;
+	ipAddress?: string;
+	country: string;
+	city: string;
+};
+
+export type OidcRevokedClientTokens = {
+	refreshTokens: number;
+	authorizationCodes: number;
+	deviceCodes: number;
+	authorizations: number;
 };
@@ -14,10 +14,29 @@ export type User = {
 	locale?: Locale;
 	ldapId?: string;
 	disabled?: boolean;
+	lastLoginAt?: string;
+	lastLoginIp?: string;
+	passkeyReenrollmentRequiredAt?: string;
 };
 
-export type UserCreate = Omit<User, 'id' | 'customClaims' | 'ldapId' | 'userGroups'>;
+export type UserCreate = Omit<
+	User,
+	| 'id'
+	| 'customClaims'
+	| 'ldapId'
+	| 'userGroups'
+	| 'lastLoginAt'
+	| 'lastLoginIp'
+	| 'passkeyReenrollmentRequiredAt'
+>;
+
+export type NotificationPreference = {
+	notification: 'newLogin' | 'apiKeyExpiration';
+	enabled: boolean;
+	mandatory: boolean;
+};
 
 export type UserSignUp = Omit<UserCreate, 'isAdmin' | 'disabled'> & {
 	token?: string;
+	setupSecret?: string;
 };
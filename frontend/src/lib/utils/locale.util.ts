@@ -8,3 +8,25 @@ export function setLocale(locale: Locale, reload = true) {
 			setParaglideLocale(locale, { reload });
 		});
 }
+
+// Returns the application name for the locale.
+// An override for the full locale (e.g. "de-CH") is preferred over one for the language (e.g. "de").
+export function getLocalizedAppName(appName: string, appNameLocalized: string, locale: string) {
+	let overrides: Record<string, string>;
+	try {
+		overrides = JSON.parse(appNameLocalized || '{}');
+	} catch {
+		return appName;
+	}
+
+	const normalize = (value: string) => value.toLowerCase().replaceAll('_', '-');
+	const normalizedLocale = normalize(locale);
+	const language = normalizedLocale.split('-')[0];
+
+	let languageMatch: string | undefined;
+	for (const [key, name] of Object.entries(overrides ?? {})) {
+		if (normalize(key) === normalizedLocale) return name;
+		if (normalize(key) === language) languageMatch = name;
+	}
+	return languageMatch ?? appName;
+}
@@ -14,6 +14,12 @@ export const load: PageLoad = async ({ url }) => {
 		callbackURL: url.searchParams.get('redirect_uri')!,
 		client,
 		codeChallenge: url.searchParams.get('code_challenge')!,
-		codeChallengeMethod: url.searchParams.get('code_challenge_method')!
+		codeChallengeMethod: url.searchParams.get('code_challenge_method')!,
+		prompt: url.searchParams.get('prompt') || undefined,
+		maxAge: url.searchParams.has('max_age') ? Number(url.searchParams.get('max_age')) : undefined,
+		acrValues: url.searchParams.get('acr_values') || undefined,
+		request: url.searchParams.get('request') || undefined,
+		requestUri: url.searchParams.get('request_uri') || undefined,
+		resource: url.searchParams.getAll('resource')
 	};
 };
@@ -6,13 +6,15 @@ export const load: PageLoad = async () => {
 	const webauthnService = new WebAuthnService();
 	const userService = new UserService();
 
-	const [account, passkeys] = await Promise.all([
+	const [account, passkeys, notificationPreferences] = await Promise.all([
 		userService.getCurrent(),
-		webauthnService.listCredentials()
+		webauthnService.listCredentials(),
+		userService.getNotificationPreferences()
 	]);
 
 	return {
 		account,
-		passkeys
+		passkeys,
+		notificationPreferences
 	};
 };
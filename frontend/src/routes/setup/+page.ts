@@ -1,5 +1,5 @@
 import { redirect } from '@sveltejs/kit';
 import type { PageLoad } from './$types';
 
-// Alias for /signup/setup
-export const load: PageLoad = async () => redirect(307, '/signup/setup');
+// Alias for /signup/setup, keeping the setup secret if present
+export const load: PageLoad = async ({ url }) => redirect(307, '/signup/setup' + url.search);